@@ -0,0 +1,567 @@
+// Package amqp bridges the messaging service to amqp 1.0 clients.
+// Sender links targeting "project/topic" map to topics:publish and
+// receiver links sourcing "project/subscription" deliver the
+// subscription messages, reusing the token auth of the rest of the
+// api: clients authenticate with SASL PLAIN sending their token as
+// the password.
+package amqp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kaggis/argo-messaging/auth"
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/config"
+	"github.com/kaggis/argo-messaging/messages"
+	"github.com/kaggis/argo-messaging/projects"
+	"github.com/kaggis/argo-messaging/stores"
+	"github.com/kaggis/argo-messaging/subscriptions"
+	"github.com/kaggis/argo-messaging/topics"
+)
+
+// pollInterval is how often a receiver link polls the broker when there
+// are no new messages
+const pollInterval = 250 * time.Millisecond
+
+// deliverBatch bounds the messages fetched per broker poll
+const deliverBatch = int64(100)
+
+// linkCredit is the transfer credit granted to client sender links
+const linkCredit = uint32(100)
+
+// Bridge accepts amqp 1.0 connections and maps them onto the messaging api
+type Bridge struct {
+	cfg  *config.APICfg
+	pool brokers.Provider
+	str  stores.Store
+}
+
+// NewBridge creates a new amqp bridge backed by the given service references
+func NewBridge(cfg *config.APICfg, pool brokers.Provider, str stores.Store) *Bridge {
+	return &Bridge{cfg: cfg, pool: pool, str: str}
+}
+
+// Serve accepts and handles amqp clients on the given listener
+func (b *Bridge) Serve(listener net.Listener) error {
+	log.Infof("AMQP: argo-messaging amqp bridge listens on %v", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go b.handleConn(conn)
+	}
+}
+
+// link holds the state of one attached link
+type link struct {
+	handle  uint32
+	channel uint16
+	name    string
+	// receiver marks links the client consumes through
+	receiver bool
+	project  string
+	resource string
+	cancel   context.CancelFunc
+	// credit is the transfer credit the client granted through flow
+	creditLock sync.Mutex
+	credit     uint32
+}
+
+// addCredit raises the transfer credit of a receiver link
+func (l *link) addCredit(n uint32) {
+	l.creditLock.Lock()
+	defer l.creditLock.Unlock()
+	l.credit = n
+}
+
+// giveBack returns credit that was taken but not used
+func (l *link) giveBack(n uint32) {
+	l.creditLock.Lock()
+	defer l.creditLock.Unlock()
+	l.credit += n
+}
+
+// takeCredit consumes up to max credit and returns the amount taken
+func (l *link) takeCredit(max uint32) uint32 {
+	l.creditLock.Lock()
+	defer l.creditLock.Unlock()
+	taken := l.credit
+	if taken > max {
+		taken = max
+	}
+	l.credit -= taken
+	return taken
+}
+
+// session holds the state of one amqp client connection
+type session struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+	token     string
+	links     map[uint32]*link
+}
+
+// write frames and sends a performative, safe for concurrent delivery loops
+func (s *session) write(channel uint16, performative described, payload []byte) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	_, err := s.conn.Write(encodeFrame(frameAMQP, channel, performative, payload))
+	return err
+}
+
+// handleConn speaks amqp 1.0 with a single client
+func (b *Bridge) handleConn(conn net.Conn) {
+	defer conn.Close()
+	sess := session{conn: conn, links: make(map[uint32]*link)}
+	defer func() {
+		for _, l := range sess.links {
+			if l.cancel != nil {
+				l.cancel()
+			}
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+
+	// the sasl layer is mandatory, the password carries the token
+	if !b.handleSasl(&sess, reader) {
+		return
+	}
+
+	// the sasl outcome is followed by the plain amqp header exchange
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(reader, header); err != nil || !bytes.Equal(header, protoHeaderAMQP) {
+		return
+	}
+	if _, err := conn.Write(protoHeaderAMQP); err != nil {
+		return
+	}
+
+	for {
+		frameType, channel, body, err := readFrame(reader)
+		if err != nil || frameType != frameAMQP {
+			return
+		}
+		// keepalive frames carry no body
+		if len(body) == 0 {
+			continue
+		}
+		value, next, err := readValue(body, 0)
+		if err != nil {
+			return
+		}
+		performative, ok := value.(described)
+		if !ok {
+			return
+		}
+		payload := body[next:]
+
+		switch performative.descriptor {
+		case descOpen:
+			if err := sess.write(channel, described{descOpen, []interface{}{"argo-messaging"}}, nil); err != nil {
+				return
+			}
+		case descBegin:
+			reply := []interface{}{uint32(channel), uint32(0), linkCredit, linkCredit}
+			if err := sess.write(channel, described{descBegin, reply}, nil); err != nil {
+				return
+			}
+		case descAttach:
+			if !b.handleAttach(&sess, channel, performative.value) {
+				return
+			}
+		case descFlow:
+			b.handleFlow(&sess, performative.value)
+		case descTransfer:
+			if !b.handleTransfer(&sess, channel, performative.value, payload) {
+				return
+			}
+		case descDetach:
+			if !b.handleDetach(&sess, channel, performative.value) {
+				return
+			}
+		case descDisposition:
+			// deliveries are settled at the bridge, dispositions are ignored
+		case descEnd:
+			if err := sess.write(channel, described{descEnd, []interface{}{}}, nil); err != nil {
+				return
+			}
+		case descClose:
+			sess.write(channel, described{descClose, []interface{}{}}, nil)
+			return
+		default:
+			return
+		}
+	}
+}
+
+// handleSasl performs the SASL PLAIN exchange and stores the token the
+// client sent as its password
+func (b *Bridge) handleSasl(sess *session, reader *bufio.Reader) bool {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(reader, header); err != nil || !bytes.Equal(header, protoHeaderSASL) {
+		return false
+	}
+	if _, err := sess.conn.Write(protoHeaderSASL); err != nil {
+		return false
+	}
+	mechanisms := described{descSaslMechanisms, []interface{}{symbol("PLAIN")}}
+	if _, err := sess.conn.Write(encodeFrame(frameSASL, 0, mechanisms, nil)); err != nil {
+		return false
+	}
+
+	frameType, _, body, err := readFrame(reader)
+	if err != nil || frameType != frameSASL {
+		return false
+	}
+	value, _, err := readValue(body, 0)
+	if err != nil {
+		return false
+	}
+	init, ok := value.(described)
+	if !ok || init.descriptor != descSaslInit {
+		return false
+	}
+	mechanism, _ := listField(init.value, 0).(symbol)
+	response, _ := listField(init.value, 1).([]byte)
+	// a PLAIN response is authzid NUL authcid NUL password
+	fields := bytes.Split(response, []byte{0x00})
+	if mechanism != "PLAIN" || len(fields) != 3 {
+		sess.conn.Write(encodeFrame(frameSASL, 0, described{descSaslOutcome, []interface{}{uint64(1)}}, nil))
+		return false
+	}
+	sess.token = string(fields[2])
+
+	// authorization happens per link since tokens hold their roles per project
+	outcome := described{descSaslOutcome, []interface{}{uint64(0)}}
+	_, err = sess.conn.Write(encodeFrame(frameSASL, 0, outcome, nil))
+	return err == nil
+}
+
+// splitAddress splits a "project/resource" link address
+func splitAddress(address string) (string, string, bool) {
+	tokens := strings.SplitN(strings.TrimPrefix(address, "/"), "/", 2)
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" || strings.Contains(tokens[1], "/") {
+		return "", "", false
+	}
+	return tokens[0], tokens[1], true
+}
+
+// handleAttach establishes a link, validating its address against the
+// topic or subscription it maps onto
+func (b *Bridge) handleAttach(sess *session, channel uint16, fields interface{}) bool {
+	name, _ := listField(fields, 0).(string)
+	handle, _ := listField(fields, 1).(uint32)
+	// the role field carries the role of the frame sender
+	receiver, _ := listField(fields, 2).(bool)
+
+	address := ""
+	if receiver {
+		if source, ok := listField(fields, 5).(described); ok && source.descriptor == descSource {
+			address, _ = listField(source.value, 0).(string)
+		}
+	} else {
+		if target, ok := listField(fields, 6).(described); ok && target.descriptor == descTarget {
+			address, _ = listField(target.value, 0).(string)
+		}
+	}
+
+	projectName, resource, ok := splitAddress(address)
+	authorized := ok
+	if authorized {
+		if receiver {
+			authorized = b.subscribable(sess.token, projectName, resource)
+		} else {
+			authorized = b.publishable(sess.token, projectName, resource)
+		}
+	}
+
+	// the reply mirrors the link with the opposite role
+	reply := []interface{}{name, handle, !receiver}
+	if !authorized {
+		// an unusable link is answered and immediately detached with error
+		reply = append(reply, nil, nil, nil, nil)
+		if err := sess.write(channel, described{descAttach, reply}, nil); err != nil {
+			return false
+		}
+		detachErr := described{uint64(0x1d), []interface{}{symbol("amqp:unauthorized-access"), "link address not accessible with the provided token"}}
+		return sess.write(channel, described{descDetach, []interface{}{handle, true, detachErr}}, nil) == nil
+	}
+
+	l := &link{handle: handle, channel: channel, name: name, receiver: receiver, project: projectName, resource: resource}
+	sess.links[handle] = l
+
+	if receiver {
+		source := described{descSource, []interface{}{address}}
+		reply = append(reply, nil, nil, source, nil)
+	} else {
+		target := described{descTarget, []interface{}{address}}
+		reply = append(reply, nil, nil, nil, target)
+	}
+	if err := sess.write(channel, described{descAttach, reply}, nil); err != nil {
+		return false
+	}
+
+	if receiver {
+		ctx, cancel := context.WithCancel(context.Background())
+		l.cancel = cancel
+		go b.deliver(ctx, sess, l)
+		return true
+	}
+
+	// grant the client sender credit to start transferring
+	flow := []interface{}{uint32(0), linkCredit, uint32(0), linkCredit, handle, uint32(0), linkCredit}
+	return sess.write(channel, described{descFlow, flow}, nil) == nil
+}
+
+// handleFlow applies the transfer credit a client granted to a receiver link
+func (b *Bridge) handleFlow(sess *session, fields interface{}) {
+	handle, ok := listField(fields, 4).(uint32)
+	if !ok {
+		return
+	}
+	l, ok := sess.links[handle]
+	if !ok || !l.receiver {
+		return
+	}
+	if credit, ok := listField(fields, 6).(uint32); ok {
+		l.addCredit(credit)
+	}
+}
+
+// handleTransfer maps an inbound transfer onto topics:publish
+func (b *Bridge) handleTransfer(sess *session, channel uint16, fields interface{}, payload []byte) bool {
+	handle, _ := listField(fields, 0).(uint32)
+	deliveryID, _ := listField(fields, 1).(uint32)
+	settled, _ := listField(fields, 4).(bool)
+
+	l, ok := sess.links[handle]
+	if !ok || l.receiver {
+		return false
+	}
+
+	data, ok := messageData(payload)
+	if !ok {
+		return false
+	}
+
+	if !b.publish(sess, l.project, l.resource, data) {
+		return false
+	}
+	if settled {
+		return true
+	}
+	accepted := described{descAccepted, []interface{}{}}
+	disposition := []interface{}{true, deliveryID, nil, true, accepted}
+	return sess.write(channel, described{descDisposition, disposition}, nil) == nil
+}
+
+// handleDetach tears down a link and confirms the detach
+func (b *Bridge) handleDetach(sess *session, channel uint16, fields interface{}) bool {
+	handle, _ := listField(fields, 0).(uint32)
+	if l, ok := sess.links[handle]; ok {
+		if l.cancel != nil {
+			l.cancel()
+		}
+		delete(sess.links, handle)
+	}
+	return sess.write(channel, described{descDetach, []interface{}{handle, true}}, nil) == nil
+}
+
+// messageData extracts the payload bytes of a transferred message,
+// accepting data and amqp-value sections
+func messageData(payload []byte) ([]byte, bool) {
+	offset := 0
+	for offset < len(payload) {
+		value, next, err := readValue(payload, offset)
+		if err != nil {
+			return nil, false
+		}
+		offset = next
+		section, ok := value.(described)
+		if !ok {
+			continue
+		}
+		switch section.descriptor {
+		case descDataSection:
+			if data, ok := section.value.([]byte); ok {
+				return data, true
+			}
+		case descValueSection:
+			if text, ok := section.value.(string); ok {
+				return []byte(text), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// publishable authorizes the token for publishing to a project topic
+func (b *Bridge) publishable(token string, projectName string, topicName string) bool {
+	projectUUID := projects.GetUUIDByName(projectName, b.str)
+	if projectUUID == "" {
+		return false
+	}
+	roles, userUUID := auth.Authenticate(projectUUID, token, b.str)
+	if userUUID == "" || !auth.Authorize("topics:publish", roles, b.str) {
+		return false
+	}
+	if !b.perResourceAuthorized(projectUUID, "topics", topicName, userUUID, roles) {
+		return false
+	}
+	results, err := topics.Find(projectUUID, "", topicName, "", 0, b.str)
+	return err == nil && !results.Empty()
+}
+
+// publish authorizes one publish to a project topic and runs it through
+// the pipeline shared with the http api, so the size cap, the daily
+// quotas and the usage accounting apply to amqp transfers as well
+func (b *Bridge) publish(sess *session, projectName string, topicName string, data []byte) bool {
+	projectUUID := projects.GetUUIDByName(projectName, b.str)
+	if projectUUID == "" {
+		return false
+	}
+	roles, userUUID := auth.Authenticate(projectUUID, sess.token, b.str)
+	if userUUID == "" || !auth.Authorize("topics:publish", roles, b.str) {
+		return false
+	}
+	if !b.perResourceAuthorized(projectUUID, "topics", topicName, userUUID, roles) {
+		return false
+	}
+
+	results, err := topics.Find(projectUUID, "", topicName, "", 0, b.str)
+	if err != nil || results.Empty() {
+		return false
+	}
+	topic := results.One()
+
+	msgs := []messages.Message{messages.New(base64.StdEncoding.EncodeToString(data))}
+	if b.cfg.PublishIdentityAttributes {
+		messages.StampIdentity(msgs, userUUID, "key", sess.conn.RemoteAddr().String())
+	}
+	brk := brokers.ForProject(b.pool, b.str, projectUUID)
+	_, err = topics.PublishMessages(projectUUID, topic, msgs, brk, b.str)
+	return err == nil
+}
+
+// subscribable authorizes the token for pulling a subscription
+func (b *Bridge) subscribable(token string, projectName string, subName string) bool {
+	projectUUID := projects.GetUUIDByName(projectName, b.str)
+	if projectUUID == "" {
+		return false
+	}
+	roles, userUUID := auth.Authenticate(projectUUID, token, b.str)
+	if userUUID == "" || !auth.Authorize("subscriptions:pull", roles, b.str) {
+		return false
+	}
+	if !b.perResourceAuthorized(projectUUID, "subscriptions", subName, userUUID, roles) {
+		return false
+	}
+	return subscriptions.HasSub(projectUUID, subName, b.str)
+}
+
+// deliver polls a subscription and pushes its messages to the client as
+// settled transfers, honoring the credit granted through flow and
+// advancing the offset as messages go out
+func (b *Bridge) deliver(ctx context.Context, sess *session, l *link) {
+	projectUUID := projects.GetUUIDByName(l.project, b.str)
+	results, err := subscriptions.Find(projectUUID, "", l.resource, "", 0, b.str)
+	if err != nil || results.Empty() {
+		return
+	}
+	sub := results.One()
+
+	brk := brokers.ForProject(b.pool, b.str, projectUUID)
+	fullTopic := sub.ConsumeTopic()
+	offset := sub.Offset
+	deliveryID := uint32(0)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		credit := l.takeCredit(uint32(deliverBatch))
+		if credit == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		msgs, err := brk.Consume(fullTopic, offset, true, int64(credit))
+		if err != nil {
+			l.giveBack(credit)
+			if err.Error() == "offset is off" {
+				offset = brk.GetMinOffset(fullTopic)
+				b.str.UpdateSubOffset(projectUUID, l.resource, offset)
+				continue
+			}
+			return
+		}
+		l.giveBack(credit - uint32(len(msgs)))
+
+		for _, payload := range msgs {
+			msg, err := messages.LoadMsgJSON([]byte(payload))
+			if err != nil {
+				return
+			}
+			data, err := base64.StdEncoding.DecodeString(msg.Data)
+			if err != nil {
+				return
+			}
+			tag := make([]byte, 4)
+			binary.BigEndian.PutUint32(tag, deliveryID)
+			transfer := []interface{}{l.handle, deliveryID, tag, uint32(0), true}
+			section := bytes.Buffer{}
+			writeValue(&section, described{descDataSection, data})
+			if err := sess.write(l.channel, described{descTransfer, transfer}, section.Bytes()); err != nil {
+				return
+			}
+			deliveryID++
+		}
+
+		if len(msgs) > 0 {
+			offset += int64(len(msgs))
+			// settled delivery is at most once, the offset advances as
+			// messages are written out
+			b.str.UpdateSubOffset(projectUUID, l.resource, offset)
+			b.str.IncrementSubMsgNum(projectUUID, l.resource, int64(len(msgs)))
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// perResourceAuthorized checks access to a specific resource when per
+// resource authorization is enabled
+func (b *Bridge) perResourceAuthorized(projectUUID string, resourceType string, resourceName string, userUUID string, roles []string) bool {
+	if !b.cfg.ResAuth {
+		return true
+	}
+	if auth.IsServiceAdmin(roles) || auth.IsProjectAdmin(roles) {
+		return true
+	}
+	return auth.PerResource(projectUUID, resourceType, resourceName, userUUID, b.str)
+}
@@ -0,0 +1,213 @@
+package amqp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/config"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// testBridge holds a served bridge and a connected raw amqp client
+type testBridge struct {
+	str    *stores.MockStore
+	brk    *brokers.MockBroker
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newTestBridge serves a fully mocked bridge and dials it
+func newTestBridge(t *testing.T) *testBridge {
+	tb := testBridge{}
+	cfg := config.NewAPICfg()
+	cfg.LoadStrJSON(`{"kafka_clusters": {"main": ["localhost:9092"]}, "per_resource_auth": true}`)
+	tb.str = stores.NewMockStore("", "")
+	tb.brk = brokers.NewMockBroker()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go NewBridge(cfg, brokers.NewMockPool(tb.brk), tb.str).Serve(listener)
+
+	tb.conn, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tb.conn.Close() })
+	tb.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	tb.reader = bufio.NewReader(tb.conn)
+	return &tb
+}
+
+// send frames and writes a performative with an optional payload
+func (tb *testBridge) send(performative described, payload []byte) {
+	tb.conn.Write(encodeFrame(frameAMQP, 0, performative, payload))
+}
+
+// read returns the next amqp performative and its payload
+func (tb *testBridge) read() (described, []byte, error) {
+	_, _, body, err := readFrame(tb.reader)
+	if err != nil {
+		return described{}, nil, err
+	}
+	value, next, err := readValue(body, 0)
+	if err != nil {
+		return described{}, nil, err
+	}
+	performative, _ := value.(described)
+	return performative, body[next:], nil
+}
+
+// sasl performs the sasl plain exchange and returns the outcome code
+func (tb *testBridge) sasl(token string) uint64 {
+	tb.conn.Write(protoHeaderSASL)
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(tb.reader, header); err != nil || !bytes.Equal(header, protoHeaderSASL) {
+		return 0xff
+	}
+	// the server advertises its mechanisms first
+	if _, _, _, err := readFrame(tb.reader); err != nil {
+		return 0xff
+	}
+
+	response := append([]byte{0x00}, []byte("user")...)
+	response = append(response, 0x00)
+	response = append(response, []byte(token)...)
+	init := described{descSaslInit, []interface{}{symbol("PLAIN"), response}}
+	tb.conn.Write(encodeFrame(frameSASL, 0, init, nil))
+
+	_, _, body, err := readFrame(tb.reader)
+	if err != nil {
+		return 0xff
+	}
+	value, _, err := readValue(body, 0)
+	if err != nil {
+		return 0xff
+	}
+	outcome, _ := value.(described)
+	code, _ := listField(outcome.value, 0).(uint64)
+	return code
+}
+
+// handshake completes sasl, the header exchange and open/begin
+func (tb *testBridge) handshake(t *testing.T, token string) {
+	if code := tb.sasl(token); code != 0 {
+		t.Fatalf("sasl outcome %v", code)
+	}
+	tb.conn.Write(protoHeaderAMQP)
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(tb.reader, header); err != nil || !bytes.Equal(header, protoHeaderAMQP) {
+		t.Fatal("amqp header exchange failed")
+	}
+
+	tb.send(described{descOpen, []interface{}{"test-client"}}, nil)
+	if open, _, err := tb.read(); err != nil || open.descriptor != descOpen {
+		t.Fatal("open exchange failed")
+	}
+	tb.send(described{descBegin, []interface{}{nil, uint32(0), uint32(100), uint32(100)}}, nil)
+	if begin, _, err := tb.read(); err != nil || begin.descriptor != descBegin {
+		t.Fatal("begin exchange failed")
+	}
+}
+
+type BridgeTestSuite struct {
+	suite.Suite
+}
+
+func (suite *BridgeTestSuite) TestSasl() {
+	tb := newTestBridge(suite.T())
+	suite.Equal(uint64(0), tb.sasl("S3CR3T1"))
+}
+
+func (suite *BridgeTestSuite) TestPublish() {
+	tb := newTestBridge(suite.T())
+	tb.handshake(suite.T(), "S3CR3T1")
+
+	// a sender link targeting ARGO/topic1 is attached and granted credit
+	target := described{descTarget, []interface{}{"ARGO/topic1"}}
+	tb.send(described{descAttach, []interface{}{"pub-link", uint32(0), false, nil, nil, nil, target}}, nil)
+
+	attach, _, err := tb.read()
+	suite.NoError(err)
+	suite.Equal(uint64(descAttach), attach.descriptor)
+	suite.Equal(true, listField(attach.value, 2))
+
+	flow, _, err := tb.read()
+	suite.NoError(err)
+	suite.Equal(uint64(descFlow), flow.descriptor)
+	suite.Equal(linkCredit, listField(flow.value, 6))
+
+	// an unsettled transfer lands on the broker and is accepted
+	section := bytes.Buffer{}
+	writeValue(&section, described{descDataSection, []byte("amqp data")})
+	transfer := []interface{}{uint32(0), uint32(0), []byte{0x00}, uint32(0), false}
+	tb.send(described{descTransfer, transfer}, section.Bytes())
+
+	disposition, _, err := tb.read()
+	suite.NoError(err)
+	suite.Equal(uint64(descDisposition), disposition.descriptor)
+	state, _ := listField(disposition.value, 4).(described)
+	suite.Equal(uint64(descAccepted), state.descriptor)
+	suite.Equal(5, len(tb.brk.MsgList["argo_uuid.topic1"]))
+}
+
+func (suite *BridgeTestSuite) TestAttachUnauthorized() {
+	tb := newTestBridge(suite.T())
+	tb.handshake(suite.T(), "S3CR3T1")
+
+	// a sender link targeting an unknown topic is detached with an error
+	target := described{descTarget, []interface{}{"ARGO/nosuchtopic"}}
+	tb.send(described{descAttach, []interface{}{"pub-link", uint32(0), false, nil, nil, nil, target}}, nil)
+
+	attach, _, err := tb.read()
+	suite.NoError(err)
+	suite.Equal(uint64(descAttach), attach.descriptor)
+
+	detach, _, err := tb.read()
+	suite.NoError(err)
+	suite.Equal(uint64(descDetach), detach.descriptor)
+	suite.Equal(true, listField(detach.value, 1))
+}
+
+func (suite *BridgeTestSuite) TestReceive() {
+	tb := newTestBridge(suite.T())
+	tb.handshake(suite.T(), "S3CR3T1")
+
+	// a receiver link sourcing ARGO/sub1 delivers once credit is granted
+	source := described{descSource, []interface{}{"ARGO/sub1"}}
+	tb.send(described{descAttach, []interface{}{"sub-link", uint32(0), true, nil, nil, source}}, nil)
+
+	attach, _, err := tb.read()
+	suite.NoError(err)
+	suite.Equal(uint64(descAttach), attach.descriptor)
+	suite.Equal(false, listField(attach.value, 2))
+
+	flow := []interface{}{uint32(0), uint32(100), uint32(0), uint32(100), uint32(0), uint32(0), uint32(10)}
+	tb.send(described{descFlow, flow}, nil)
+
+	// the four seeded messages of topic1 arrive as settled transfers
+	for i := 0; i < 4; i++ {
+		transfer, payload, err := tb.read()
+		suite.NoError(err)
+		suite.Equal(uint64(descTransfer), transfer.descriptor)
+		suite.Equal(uint32(i), listField(transfer.value, 1))
+		value, _, err := readValue(payload, 0)
+		suite.NoError(err)
+		section, _ := value.(described)
+		suite.Equal(uint64(descDataSection), section.descriptor)
+		suite.Equal("mock_data_"+string(rune('0'+i)), string(section.value.([]byte)))
+	}
+}
+
+func TestBridgeTestSuite(t *testing.T) {
+	suite.Run(t, new(BridgeTestSuite))
+}
@@ -0,0 +1,329 @@
+package amqp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// amqp 1.0 performative and section descriptor codes
+const (
+	descOpen        = 0x10
+	descBegin       = 0x11
+	descAttach      = 0x12
+	descFlow        = 0x13
+	descTransfer    = 0x14
+	descDisposition = 0x15
+	descDetach      = 0x16
+	descEnd         = 0x17
+	descClose       = 0x18
+
+	descSource   = 0x28
+	descTarget   = 0x29
+	descAccepted = 0x24
+
+	descSaslMechanisms = 0x40
+	descSaslInit       = 0x41
+	descSaslOutcome    = 0x44
+
+	descDataSection  = 0x75
+	descValueSection = 0x77
+)
+
+// frame layer types
+const (
+	frameAMQP = 0x00
+	frameSASL = 0x01
+)
+
+// symbol is an amqp symbolic constant, encoded distinctly from strings
+type symbol string
+
+// described pairs a numeric descriptor with its value, the amqp
+// composite type used for performatives, sources, targets and sections
+type described struct {
+	descriptor uint64
+	value      interface{}
+}
+
+// maxFrameSize bounds the accepted frame size
+const maxFrameSize = 4 * 1048576
+
+// protoHeaderAMQP and protoHeaderSASL are the protocol handshake headers
+var (
+	protoHeaderAMQP = []byte{'A', 'M', 'Q', 'P', 0x00, 0x01, 0x00, 0x00}
+	protoHeaderSASL = []byte{'A', 'M', 'Q', 'P', 0x03, 0x01, 0x00, 0x00}
+)
+
+// readFrame reads one frame, returning its layer type, channel and body
+func readFrame(r io.Reader) (byte, uint16, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size < 8 || size > maxFrameSize {
+		return 0, 0, nil, errors.New("invalid frame size")
+	}
+	doff := int(header[4])
+	frameType := header[5]
+	channel := binary.BigEndian.Uint16(header[6:8])
+
+	body := make([]byte, int(size)-8)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	// skip any extended header
+	if doff*4-8 > len(body) {
+		return 0, 0, nil, errors.New("invalid frame doff")
+	}
+	return frameType, channel, body[doff*4-8:], nil
+}
+
+// encodeFrame frames a performative and an optional payload
+func encodeFrame(frameType byte, channel uint16, performative described, payload []byte) []byte {
+	body := bytes.Buffer{}
+	writeValue(&body, performative)
+	body.Write(payload)
+
+	frame := make([]byte, 8)
+	binary.BigEndian.PutUint32(frame[0:4], uint32(8+body.Len()))
+	frame[4] = 2
+	frame[5] = frameType
+	binary.BigEndian.PutUint16(frame[6:8], channel)
+	return append(frame, body.Bytes()...)
+}
+
+// writeValue encodes a value using the minimal subset of amqp types the
+// bridge speaks
+func writeValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0x40)
+	case bool:
+		if v {
+			buf.WriteByte(0x41)
+		} else {
+			buf.WriteByte(0x42)
+		}
+	case uint32:
+		b := make([]byte, 5)
+		b[0] = 0x70
+		binary.BigEndian.PutUint32(b[1:], v)
+		buf.Write(b)
+	case uint64:
+		if v < 256 {
+			buf.WriteByte(0x53)
+			buf.WriteByte(byte(v))
+		} else {
+			b := make([]byte, 9)
+			b[0] = 0x80
+			binary.BigEndian.PutUint64(b[1:], v)
+			buf.Write(b)
+		}
+	case string:
+		if len(v) < 256 {
+			buf.WriteByte(0xa1)
+			buf.WriteByte(byte(len(v)))
+		} else {
+			buf.WriteByte(0xb1)
+			binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		}
+		buf.WriteString(string(v))
+	case symbol:
+		if len(v) < 256 {
+			buf.WriteByte(0xa3)
+			buf.WriteByte(byte(len(v)))
+		} else {
+			buf.WriteByte(0xb3)
+			binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		}
+		buf.WriteString(string(v))
+	case []byte:
+		if len(v) < 256 {
+			buf.WriteByte(0xa0)
+			buf.WriteByte(byte(len(v)))
+		} else {
+			buf.WriteByte(0xb0)
+			binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		}
+		buf.Write(v)
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteByte(0x45)
+			return
+		}
+		items := bytes.Buffer{}
+		for _, item := range v {
+			writeValue(&items, item)
+		}
+		if items.Len()+1 < 256 && len(v) < 256 {
+			buf.WriteByte(0xc0)
+			buf.WriteByte(byte(items.Len() + 1))
+			buf.WriteByte(byte(len(v)))
+		} else {
+			buf.WriteByte(0xd0)
+			binary.Write(buf, binary.BigEndian, uint32(items.Len()+4))
+			binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		}
+		buf.Write(items.Bytes())
+	case described:
+		buf.WriteByte(0x00)
+		writeValue(buf, v.descriptor)
+		writeValue(buf, v.value)
+	}
+}
+
+// readValue decodes a value starting at offset, returning it and the
+// offset past it
+func readValue(buf []byte, offset int) (interface{}, int, error) {
+	if offset >= len(buf) {
+		return nil, 0, errors.New("truncated value")
+	}
+	code := buf[offset]
+	offset++
+
+	need := func(n int) error {
+		if offset+n > len(buf) {
+			return errors.New("truncated value")
+		}
+		return nil
+	}
+
+	switch code {
+	case 0x00: // described type
+		descriptor, next, err := readValue(buf, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, next, err := readValue(buf, next)
+		if err != nil {
+			return nil, 0, err
+		}
+		desc, ok := descriptor.(uint64)
+		if !ok {
+			return nil, 0, errors.New("unsupported descriptor type")
+		}
+		return described{descriptor: desc, value: value}, next, nil
+	case 0x40: // null
+		return nil, offset, nil
+	case 0x41:
+		return true, offset, nil
+	case 0x42:
+		return false, offset, nil
+	case 0x56: // boolean with payload
+		if err := need(1); err != nil {
+			return nil, 0, err
+		}
+		return buf[offset] == 0x01, offset + 1, nil
+	case 0x50: // ubyte
+		if err := need(1); err != nil {
+			return nil, 0, err
+		}
+		return uint64(buf[offset]), offset + 1, nil
+	case 0x60: // ushort
+		if err := need(2); err != nil {
+			return nil, 0, err
+		}
+		return uint64(binary.BigEndian.Uint16(buf[offset:])), offset + 2, nil
+	case 0x43: // uint0
+		return uint32(0), offset, nil
+	case 0x52: // small uint
+		if err := need(1); err != nil {
+			return nil, 0, err
+		}
+		return uint32(buf[offset]), offset + 1, nil
+	case 0x70: // uint
+		if err := need(4); err != nil {
+			return nil, 0, err
+		}
+		return binary.BigEndian.Uint32(buf[offset:]), offset + 4, nil
+	case 0x44: // ulong0
+		return uint64(0), offset, nil
+	case 0x53: // small ulong
+		if err := need(1); err != nil {
+			return nil, 0, err
+		}
+		return uint64(buf[offset]), offset + 1, nil
+	case 0x80: // ulong
+		if err := need(8); err != nil {
+			return nil, 0, err
+		}
+		return binary.BigEndian.Uint64(buf[offset:]), offset + 8, nil
+	case 0xa0, 0xa1, 0xa3: // bin8, str8, sym8
+		if err := need(1); err != nil {
+			return nil, 0, err
+		}
+		length := int(buf[offset])
+		offset++
+		if err := need(length); err != nil {
+			return nil, 0, err
+		}
+		data := buf[offset : offset+length]
+		offset += length
+		switch code {
+		case 0xa0:
+			return append([]byte{}, data...), offset, nil
+		case 0xa3:
+			return symbol(data), offset, nil
+		}
+		return string(data), offset, nil
+	case 0xb0, 0xb1, 0xb3: // bin32, str32, sym32
+		if err := need(4); err != nil {
+			return nil, 0, err
+		}
+		length := int(binary.BigEndian.Uint32(buf[offset:]))
+		offset += 4
+		if err := need(length); err != nil {
+			return nil, 0, err
+		}
+		data := buf[offset : offset+length]
+		offset += length
+		switch code {
+		case 0xb0:
+			return append([]byte{}, data...), offset, nil
+		case 0xb3:
+			return symbol(data), offset, nil
+		}
+		return string(data), offset, nil
+	case 0x45: // list0
+		return []interface{}{}, offset, nil
+	case 0xc0, 0xd0: // list8, list32
+		count := 0
+		if code == 0xc0 {
+			if err := need(2); err != nil {
+				return nil, 0, err
+			}
+			count = int(buf[offset+1])
+			offset += 2
+		} else {
+			if err := need(8); err != nil {
+				return nil, 0, err
+			}
+			count = int(binary.BigEndian.Uint32(buf[offset+4:]))
+			offset += 8
+		}
+		items := []interface{}{}
+		for i := 0; i < count; i++ {
+			item, next, err := readValue(buf, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			offset = next
+		}
+		return items, offset, nil
+	}
+	return nil, 0, errors.New("unsupported type code")
+}
+
+// listField returns the nth field of a performative list, nil when the
+// list is shorter
+func listField(value interface{}, n int) interface{} {
+	list, ok := value.([]interface{})
+	if !ok || n >= len(list) {
+		return nil
+	}
+	return list[n]
+}
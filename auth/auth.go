@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/kaggis/argo-messaging/events"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// routeRoles maps each named api route to the roles allowed to access it
+var routeRoles = map[string][]string{
+	"projects:list":                    {"service_admin"},
+	"projects:show":                    {"service_admin"},
+	"projects:create":                  {"service_admin"},
+	"projects:update":                  {"service_admin"},
+	"projects:delete":                  {"service_admin"},
+	"topics:list":                      {"service_admin", "project_admin", "publisher", "consumer"},
+	"topics:show":                      {"service_admin", "project_admin", "publisher", "consumer"},
+	"topics:create":                    {"service_admin", "project_admin"},
+	"topics:delete":                    {"service_admin", "project_admin"},
+	"topics:batchCreate":               {"service_admin", "project_admin"},
+	"topics:batchDelete":               {"service_admin", "project_admin"},
+	"topics:publish":                   {"service_admin", "project_admin", "publisher"},
+	"topics:diagnose":                  {"service_admin", "project_admin", "publisher"},
+	"topics:metrics":                   {"service_admin", "project_admin", "publisher", "consumer"},
+	"topics:acl":                       {"service_admin", "project_admin", "publisher", "consumer"},
+	"topics:modifyAcl":                 {"service_admin", "project_admin"},
+	"topics:listSubscriptions":         {"service_admin", "project_admin", "publisher", "consumer"},
+	"topics:listKeys":                  {"service_admin", "project_admin", "publisher"},
+	"topics:createKey":                 {"service_admin", "project_admin", "publisher"},
+	"topics:revokeKey":                 {"service_admin", "project_admin", "publisher"},
+	"topics:attachSchema":              {"service_admin", "project_admin"},
+	"topics:modifyLabels":              {"service_admin", "project_admin"},
+	"topics:routingRules":              {"service_admin", "project_admin", "publisher"},
+	"topics:modifyRoutingRules":        {"service_admin", "project_admin"},
+	"schemas:list":                     {"service_admin", "project_admin", "publisher"},
+	"schemas:show":                     {"service_admin", "project_admin", "publisher"},
+	"schemas:create":                   {"service_admin", "project_admin"},
+	"schemas:delete":                   {"service_admin", "project_admin"},
+	"subscriptions:list":               {"service_admin", "project_admin", "publisher", "consumer"},
+	"subscriptions:show":               {"service_admin", "project_admin", "publisher", "consumer"},
+	"subscriptions:create":             {"service_admin", "project_admin"},
+	"subscriptions:delete":             {"service_admin", "project_admin"},
+	"subscriptions:pull":               {"service_admin", "project_admin", "consumer"},
+	"subscriptions:cancelPull":         {"service_admin", "project_admin", "consumer"},
+	"subscriptions:pullSessions":       {"service_admin", "project_admin", "consumer"},
+	"subscriptions:acknowledge":        {"service_admin", "project_admin", "consumer"},
+	"subscriptions:extend":             {"service_admin", "project_admin", "consumer"},
+	"subscriptions:seek":               {"service_admin", "project_admin", "consumer"},
+	"subscriptions:modifyPushConfig":   {"service_admin", "project_admin"},
+	"subscriptions:verifyPushEndpoint": {"service_admin", "project_admin"},
+	"subscriptions:pushErrors":         {"service_admin", "project_admin", "consumer"},
+	"subscriptions:pausePush":          {"service_admin", "project_admin"},
+	"subscriptions:resumePush":         {"service_admin", "project_admin"},
+	"subscriptions:modifyAckDeadline":  {"service_admin", "project_admin"},
+	"subscriptions:modifyAckDedup":     {"service_admin", "project_admin"},
+	"subscriptions:metrics":            {"service_admin", "project_admin", "publisher", "consumer"},
+	"subscriptions:diagnose":           {"service_admin", "project_admin", "consumer"},
+	"subscriptions:stream":             {"service_admin", "project_admin", "consumer"},
+	"subscriptions:sse":                {"service_admin", "project_admin", "consumer"},
+	"subscriptions:acl":                {"service_admin", "project_admin", "publisher", "consumer"},
+	"subscriptions:modifyAcl":          {"service_admin", "project_admin"},
+	"subscriptions:modifyLabels":       {"service_admin", "project_admin"},
+	"users:list":                       {"service_admin"},
+	"users:show":                       {"service_admin"},
+	"users:create":                     {"service_admin"},
+	"users:import":                     {"service_admin"},
+	"users:suspend":                    {"service_admin"},
+	"users:activate":                   {"service_admin"},
+	"users:update":                     {"service_admin"},
+	"users:delete":                     {"service_admin"},
+	"users:refreshToken":               {"service_admin"},
+	"users:rotateToken":                {"service_admin"},
+	"users:createToken":                {"service_admin"},
+	"users:listTokens":                 {"service_admin"},
+	"users:revokeToken":                {"service_admin"},
+	"members:list":                     {"service_admin", "project_admin"},
+	"members:show":                     {"service_admin", "project_admin"},
+	"members:create":                   {"service_admin", "project_admin"},
+	"members:modifyRoles":              {"service_admin", "project_admin"},
+	"members:remove":                   {"service_admin", "project_admin"},
+	"serviceAccounts:list":             {"service_admin", "project_admin"},
+	"serviceAccounts:show":             {"service_admin", "project_admin"},
+	"serviceAccounts:create":           {"service_admin", "project_admin"},
+	"serviceAccounts:update":           {"service_admin", "project_admin"},
+	"serviceAccounts:delete":           {"service_admin", "project_admin"},
+	"projects:assignCluster":           {"service_admin"},
+	"projects:metrics":                 {"service_admin", "project_admin"},
+	"projects:quotas":                  {"service_admin", "project_admin"},
+	"projects:modifyQuotas":            {"service_admin"},
+	"projects:purge":                   {"service_admin"},
+	"operations:list":                  {"service_admin"},
+	"operations:show":                  {"service_admin"},
+	"operations:cancel":                {"service_admin"},
+	"events:stream":                    {"service_admin"},
+	"metrics:show":                     {"service_admin"},
+	"status:history":                   {"service_admin"},
+	"metrics:usageReport":              {"service_admin"},
+	"metrics:alertRules":               {"service_admin"},
+	"roles:list":                       {"service_admin"},
+	"roles:show":                       {"service_admin"},
+	"roles:create":                     {"service_admin"},
+	"roles:update":                     {"service_admin"},
+	"roles:delete":                     {"service_admin"},
+	"logs:levels":                      {"service_admin"},
+	"logs:modifyLevels":                {"service_admin"},
+	"config:show":                      {"service_admin"},
+	"admin:overview":                   {"service_admin"},
+	"auditLogs:list":                   {"service_admin"},
+	"scim:listUsers":                   {"service_admin"},
+	"scim:showUser":                    {"service_admin"},
+	"scim:createUser":                  {"service_admin"},
+	"scim:replaceUser":                 {"service_admin"},
+	"scim:deleteUser":                  {"service_admin"},
+	"scim:listGroups":                  {"service_admin"},
+	"scim:showGroup":                   {"service_admin"},
+	"scim:patchGroup":                  {"service_admin"},
+}
+
+// Authenticate authenticates a token against a project and returns the
+// roles the token holds in it along with the user uuid
+func Authenticate(projectUUID string, token string, store stores.Store) ([]string, string) {
+	return store.GetUserRoles(projectUUID, token)
+}
+
+// UserSuspended reports whether a token belongs to a suspended user, so
+// the api can reject it with a distinct error
+func UserSuspended(token string, store stores.Store) bool {
+	user, err := store.GetUserFromToken(token)
+	return err == nil && user.Suspended
+}
+
+// TokenExpired reports whether a token belongs to a user but has passed
+// its expiry, so the api can reject it with a distinct error
+func TokenExpired(token string, store stores.Store) bool {
+	user, err := store.GetUserFromToken(token)
+	if err != nil {
+		return false
+	}
+	expiry := ""
+	if token == user.Token {
+		expiry = user.TokenExpiresOn
+	} else {
+		for _, named := range user.Tokens {
+			if token == named.Token {
+				expiry = named.ExpiresOn
+				break
+			}
+		}
+	}
+	if expiry == "" {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, expiry)
+	return err != nil || !time.Now().UTC().Before(t)
+}
+
+// ValidScopeRoute reports whether a route may appear in a service
+// account scope: only the project data routes qualify, the admin surface
+// stays with full users
+func ValidScopeRoute(routeName string) bool {
+	if _, ok := routeRoles[routeName]; !ok {
+		return false
+	}
+	for _, prefix := range []string{"topics:", "subscriptions:", "schemas:"} {
+		if strings.HasPrefix(routeName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize checks if any of the given roles is allowed to access a
+// named route, either through the wired-in role table or through a
+// custom role definition stored by a service admin
+func Authorize(routeName string, roles []string, store stores.Store) bool {
+	allowed, ok := routeRoles[routeName]
+	if !ok {
+		return false
+	}
+	for _, role := range roles {
+		for _, allowedRole := range allowed {
+			if role == allowedRole {
+				return true
+			}
+		}
+	}
+	for _, role := range roles {
+		if isBuiltinRole(role) {
+			continue
+		}
+		defs, err := store.QueryRoleDefinitions(role)
+		if err != nil || len(defs) == 0 {
+			continue
+		}
+		for _, defRoute := range defs[0].Routes {
+			if defRoute == routeName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsServiceAdmin checks if the role list includes the service_admin role
+func IsServiceAdmin(roles []string) bool {
+	return hasRole(roles, "service_admin")
+}
+
+// IsProjectAdmin checks if the role list includes the project_admin role
+func IsProjectAdmin(roles []string) bool {
+	return hasRole(roles, "project_admin")
+}
+
+// IsPublisher checks if the role list includes the publisher role
+func IsPublisher(roles []string) bool {
+	return hasRole(roles, "publisher")
+}
+
+// IsConsumer checks if the role list includes the consumer role
+func IsConsumer(roles []string) bool {
+	return hasRole(roles, "consumer")
+}
+
+// PerResource checks if a user uuid is in the acl of a resource
+func PerResource(projectUUID string, resourceType string, resourceName string, userUUID string, store stores.Store) bool {
+	// topics flagged to inherit the project acl are open to every project
+	// member, the role check of the route already happened
+	if resourceType == "topics" {
+		qTopics, _, _, err := store.QueryTopics(projectUUID, "", resourceName, "", 0, "", false)
+		if err == nil && len(qTopics) > 0 && qTopics[0].InheritProjectACL {
+			return true
+		}
+	}
+
+	acl, err := store.QueryACL(projectUUID, resourceType, resourceName)
+	if err != nil {
+		return false
+	}
+	for _, uuid := range acl.ACL {
+		if uuid == userUUID {
+			// expired grants are denied right away, even before the
+			// sweep removes them
+			if expiry, ok := acl.Expires[userUUID]; ok {
+				t, err := time.Parse(time.RFC3339, expiry)
+				if err != nil || !time.Now().UTC().Before(t) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// SweepExpiredGrants removes acl entries and project memberships whose
+// expiry has passed, emitting an audit event for each removed grant
+func SweepExpiredGrants(now time.Time, store stores.Store) int {
+	expired, err := store.RemoveExpiredGrants(now.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0
+	}
+	for _, grant := range expired {
+		projectName := ""
+		if projects, err := store.QueryProjects(grant.ProjectUUID, ""); err == nil && len(projects) > 0 {
+			projectName = projects[0].Name
+		}
+		userName := grant.UserUUID
+		if users, err := store.QueryUsers("", grant.UserUUID, ""); err == nil && len(users) > 0 {
+			userName = users[0].Name
+		}
+		eventType := "acl.grant_expired"
+		resource := "projects/" + projectName + "/" + grant.ResourceType + "/" + grant.ResourceName
+		if grant.ResourceType == "project" {
+			eventType = "membership.expired"
+			resource = userName
+		}
+		event := events.New(eventType, projectName, resource)
+		event.Attrs = map[string]string{"user": userName}
+		events.Emit(event)
+	}
+	return len(expired)
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, item := range roles {
+		if item == role {
+			return true
+		}
+	}
+	return false
+}
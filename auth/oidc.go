@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// OIDCValidator validates the access tokens of the configured openid
+// connect provider: RS256 signed jwts checked against the provider jwks,
+// with a configurable claim mapping each token to a service user
+type OIDCValidator struct {
+	issuer    string
+	jwksURL   string
+	userClaim string
+	audience  string
+	client    *http.Client
+	mu        sync.Mutex
+	// keys caches the provider signing keys by key id; an unknown key
+	// id triggers a refetch so provider key rollovers are picked up
+	keys map[string]*rsa.PublicKey
+}
+
+// NewOIDCValidator creates a validator for tokens of the given issuer,
+// fetching signing keys from jwksURL; userClaim names the claim mapped
+// to the service user name, defaulting to sub; a non empty audience
+// additionally pins the aud claim of accepted tokens
+func NewOIDCValidator(issuer string, jwksURL string, userClaim string, audience string) *OIDCValidator {
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	return &OIDCValidator{
+		issuer:    issuer,
+		jwksURL:   jwksURL,
+		userClaim: userClaim,
+		audience:  audience,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		keys:      make(map[string]*rsa.PublicKey),
+	}
+}
+
+// jwk is one key of the provider jwks document
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// key returns the signing key with the given id, refetching the jwks
+// once when the id is not cached
+func (v *OIDCValidator) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	v.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if err := v.refreshKeys(); err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok = v.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key " + kid)
+	}
+	return key, nil
+}
+
+// refreshKeys replaces the cached signing keys with the current jwks
+func (v *OIDCValidator) refreshKeys() error {
+	resp, err := v.client.Get(v.jwksURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("jwks endpoint responded with " + resp.Status)
+	}
+	doc := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	v.mu.Lock()
+	v.keys = keys
+	v.mu.Unlock()
+	return nil
+}
+
+// audienceMatches reports whether the configured audience appears in
+// the aud claim of the token, falling back to azp when the token
+// carries no aud at all
+func (v *OIDCValidator) audienceMatches(claims map[string]interface{}) bool {
+	if v.audience == "" {
+		return true
+	}
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == v.audience
+	case []interface{}:
+		for _, entry := range aud {
+			if entry == v.audience {
+				return true
+			}
+		}
+		return false
+	}
+	azp, _ := claims["azp"].(string)
+	return azp == v.audience
+}
+
+// Validate checks the signature, issuer, audience and validity window of
+// a bearer token and returns the value of the configured user claim
+func (v *OIDCValidator) Validate(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", errors.New("malformed token")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return "", errors.New("malformed token")
+	}
+	if header.Alg != "RS256" {
+		return "", errors.New("unsupported signing algorithm " + header.Alg)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return "", err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed token")
+	}
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature); err != nil {
+		return "", errors.New("invalid token signature")
+	}
+
+	claimBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("malformed token")
+	}
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(claimBytes, &claims); err != nil {
+		return "", errors.New("malformed token")
+	}
+	if issuer, _ := claims["iss"].(string); issuer != v.issuer {
+		return "", errors.New("unexpected token issuer")
+	}
+	if !v.audienceMatches(claims) {
+		return "", errors.New("unexpected token audience")
+	}
+	expiry, ok := claims["exp"].(float64)
+	if !ok || !time.Now().Before(time.Unix(int64(expiry), 0)) {
+		return "", errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && time.Now().Before(time.Unix(int64(nbf), 0)) {
+		return "", errors.New("token not yet valid")
+	}
+	name, _ := claims[v.userClaim].(string)
+	if name == "" {
+		return "", errors.New("token misses the " + v.userClaim + " claim")
+	}
+	return name, nil
+}
+
+// oidcValidator handles bearer token authentication when oidc is
+// enabled, wired at startup from the service configuration
+var oidcValidator *OIDCValidator
+
+// oidcAutoProvision creates unknown federated users on their first request
+var oidcAutoProvision bool
+
+// SetOIDC wires the validator handling bearer token authentication;
+// autoProvision creates unknown users on their first request
+func SetOIDC(validator *OIDCValidator, autoProvision bool) {
+	oidcValidator = validator
+	oidcAutoProvision = autoProvision
+}
+
+// AuthenticateBearer authenticates an oidc bearer token against a
+// project and returns the roles the mapped user holds in it along with
+// the user uuid
+func AuthenticateBearer(projectUUID string, token string, store stores.Store) ([]string, string) {
+	if oidcValidator == nil {
+		return nil, ""
+	}
+	name, err := oidcValidator.Validate(token)
+	if err != nil {
+		log.Debugf("AUTH: bearer token rejected, %v", err)
+		return nil, ""
+	}
+	users, err := store.QueryUsers("", "", name)
+	if err != nil {
+		return nil, ""
+	}
+	if len(users) == 0 {
+		if !oidcAutoProvision {
+			return nil, ""
+		}
+		// federated users start without any roles; admins grant
+		// project memberships through the regular user management
+		newToken, err := GenToken()
+		if err != nil {
+			return nil, ""
+		}
+		if _, err := CreateUser(uuid.New().String(), name, []ProjectRoles{}, newToken, "", "", nil, time.Now(), "oidc", store); err != nil {
+			return nil, ""
+		}
+		log.Infof("AUTH: auto provisioned federated user %v", name)
+		users, err = store.QueryUsers("", "", name)
+		if err != nil || len(users) == 0 {
+			return nil, ""
+		}
+	}
+	user := users[0]
+	// suspension cuts off federated access the same way it voids tokens
+	if user.Suspended {
+		log.Debugf("AUTH: bearer token of suspended user %v rejected", name)
+		return nil, ""
+	}
+	roles := append([]string{}, user.ServiceRoles...)
+	for _, membership := range user.Projects {
+		if membership.ProjectUUID == projectUUID {
+			roles = append(roles, membership.Roles...)
+		}
+	}
+	return roles, user.UUID
+}
+
+// BearerSuspended reports whether a valid bearer token maps to a
+// suspended user, so the api can reject it with a distinct error
+func BearerSuspended(token string, store stores.Store) bool {
+	if oidcValidator == nil {
+		return false
+	}
+	name, err := oidcValidator.Validate(token)
+	if err != nil {
+		return false
+	}
+	users, err := store.QueryUsers("", "", name)
+	return err == nil && len(users) > 0 && users[0].Suspended
+}
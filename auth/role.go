@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// builtinRoles are the role names wired into routeRoles; custom role
+// definitions cannot shadow them
+var builtinRoles = []string{"service_admin", "project_admin", "publisher", "consumer"}
+
+// RoleDefinition defines a custom role as the set of api routes it
+// opens; the role becomes assignable wherever the built-in ones are
+type RoleDefinition struct {
+	Name   string   `json:"name"`
+	Routes []string `json:"routes"`
+}
+
+// RoleDefinitions holds a list of custom role definitions
+type RoleDefinitions struct {
+	List []RoleDefinition `json:"roles"`
+}
+
+// ExportJSON exports the role definition as a json string
+func (rd *RoleDefinition) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(rd, "", "   ")
+	return string(output), err
+}
+
+// ExportJSON exports the role definition list as a json string
+func (rds *RoleDefinitions) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(rds, "", "   ")
+	return string(output), err
+}
+
+// Empty returns true if the role definition list holds no definitions
+func (rds *RoleDefinitions) Empty() bool {
+	return len(rds.List) == 0
+}
+
+// One returns the first role definition of the list
+func (rds *RoleDefinitions) One() RoleDefinition {
+	if rds.Empty() {
+		return RoleDefinition{}
+	}
+	return rds.List[0]
+}
+
+// FindRoleDefinitions returns the custom role definitions, optionally
+// filtered by name
+func FindRoleDefinitions(name string, store stores.Store) (RoleDefinitions, error) {
+	result := RoleDefinitions{}
+	qDefs, err := store.QueryRoleDefinitions(name)
+	if err != nil {
+		return result, err
+	}
+	for _, item := range qDefs {
+		result.List = append(result.List, RoleDefinition{Name: item.Name, Routes: item.Routes})
+	}
+	if name != "" && result.Empty() {
+		return result, errors.New("not found")
+	}
+	return result, nil
+}
+
+// CreateRoleDefinition creates a new custom role definition in the store
+func CreateRoleDefinition(name string, routes []string, store stores.Store) (RoleDefinition, error) {
+	if isBuiltinRole(name) {
+		return RoleDefinition{}, errors.New("reserved role name: " + name)
+	}
+	if existing, err := store.QueryRoleDefinitions(name); err == nil && len(existing) > 0 {
+		return RoleDefinition{}, errors.New("exists")
+	}
+	if err := checkRoleRoutes(routes); err != nil {
+		return RoleDefinition{}, err
+	}
+	if err := store.InsertRoleDefinition(name, routes); err != nil {
+		return RoleDefinition{}, err
+	}
+	stored, err := FindRoleDefinitions(name, store)
+	return stored.One(), err
+}
+
+// UpdateRoleDefinition replaces the routes of a custom role definition
+func UpdateRoleDefinition(name string, routes []string, store stores.Store) (RoleDefinition, error) {
+	if err := checkRoleRoutes(routes); err != nil {
+		return RoleDefinition{}, err
+	}
+	if err := store.UpdateRoleDefinition(name, routes); err != nil {
+		return RoleDefinition{}, err
+	}
+	stored, err := FindRoleDefinitions(name, store)
+	return stored.One(), err
+}
+
+// RemoveRoleDefinition removes a custom role definition
+func RemoveRoleDefinition(name string, store stores.Store) error {
+	return store.RemoveRoleDefinition(name)
+}
+
+// checkRoleRoutes validates the route list of a role definition against
+// the named routes of the api
+func checkRoleRoutes(routes []string) error {
+	if len(routes) == 0 {
+		return errors.New("routes list is empty")
+	}
+	for _, route := range routes {
+		if _, ok := routeRoles[route]; !ok {
+			return errors.New("unknown route: " + route)
+		}
+	}
+	return nil
+}
+
+// isBuiltinRole checks if a role name is one of the wired-in roles
+func isBuiltinRole(name string) bool {
+	for _, role := range builtinRoles {
+		if role == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckProjectRoles validates roles requested for a project membership:
+// the built-in project roles and the stored custom role definitions
+// qualify, service_admin and unknown names do not
+func CheckProjectRoles(roles []string, store stores.Store) error {
+	for _, role := range roles {
+		switch role {
+		case "project_admin", "publisher", "consumer":
+			continue
+		}
+		if !isBuiltinRole(role) {
+			if defs, err := store.QueryRoleDefinitions(role); err == nil && len(defs) > 0 {
+				continue
+			}
+		}
+		return errors.New("invalid role: " + role)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// ServiceAccountScope allows a service account one api action, named by
+// its route, optionally pinned to a single resource
+type ServiceAccountScope struct {
+	Route    string `json:"route"`
+	Resource string `json:"resource,omitempty"`
+}
+
+// ServiceAccount is a non-human principal of a project whose token only
+// grants the explicitly scoped actions; the token value is only returned
+// once, on creation
+type ServiceAccount struct {
+	UUID       string                `json:"uuid"`
+	Name       string                `json:"name"`
+	Token      string                `json:"token,omitempty"`
+	Scopes     []ServiceAccountScope `json:"scopes"`
+	CreatedOn  string                `json:"created_on,omitempty"`
+	ModifiedOn string                `json:"modified_on,omitempty"`
+	CreatedBy  string                `json:"created_by,omitempty"`
+}
+
+// ServiceAccounts holds a list of service accounts
+type ServiceAccounts struct {
+	List []ServiceAccount `json:"serviceAccounts"`
+}
+
+// ExportJSON exports the service account as a json string
+func (sa *ServiceAccount) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(sa, "", "   ")
+	return string(output), err
+}
+
+// ExportJSON exports the service account list as a json string
+func (sas *ServiceAccounts) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(sas, "", "   ")
+	return string(output), err
+}
+
+// Empty returns true if the service account list holds no accounts
+func (sas *ServiceAccounts) Empty() bool {
+	return len(sas.List) == 0
+}
+
+// One returns the first service account of the list
+func (sas *ServiceAccounts) One() ServiceAccount {
+	if sas.Empty() {
+		return ServiceAccount{}
+	}
+	return sas.List[0]
+}
+
+// FindServiceAccounts returns the service accounts of a project,
+// optionally filtered by name
+func FindServiceAccounts(projectUUID string, name string, store stores.Store) (ServiceAccounts, error) {
+	result := ServiceAccounts{}
+	qAccounts, err := store.QueryServiceAccounts(projectUUID, name)
+	if err != nil {
+		return result, err
+	}
+	for _, item := range qAccounts {
+		result.List = append(result.List, serviceAccountFromQ(item))
+	}
+	if name != "" && result.Empty() {
+		return result, errors.New("not found")
+	}
+	return result, nil
+}
+
+// CreateServiceAccount creates a new service account in the store,
+// returning it with its generated token
+func CreateServiceAccount(projectUUID string, uuid string, name string, scopes []ServiceAccountScope, createdOn time.Time, createdBy string, store stores.Store) (ServiceAccount, error) {
+	if existing, err := store.QueryServiceAccounts(projectUUID, name); err == nil && len(existing) > 0 {
+		return ServiceAccount{}, errors.New("exists")
+	}
+	qScopes, err := checkScopes(scopes)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+	token, err := GenToken()
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+	created := createdOn.UTC().Format(time.RFC3339)
+	if err := store.InsertServiceAccount(projectUUID, uuid, name, token, qScopes, created, createdBy); err != nil {
+		return ServiceAccount{}, err
+	}
+	stored, err := FindServiceAccounts(projectUUID, name, store)
+	result := stored.One()
+	result.Token = token
+	return result, err
+}
+
+// UpdateServiceAccountScopes replaces the scopes of a service account
+func UpdateServiceAccountScopes(projectUUID string, name string, scopes []ServiceAccountScope, modifiedOn time.Time, store stores.Store) (ServiceAccount, error) {
+	qScopes, err := checkScopes(scopes)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+	if err := store.UpdateServiceAccountScopes(projectUUID, name, qScopes, modifiedOn.UTC().Format(time.RFC3339)); err != nil {
+		return ServiceAccount{}, err
+	}
+	stored, err := FindServiceAccounts(projectUUID, name, store)
+	return stored.One(), err
+}
+
+// RemoveServiceAccount removes a service account
+func RemoveServiceAccount(projectUUID string, name string, store stores.Store) error {
+	return store.RemoveServiceAccount(projectUUID, name)
+}
+
+// AuthenticateServiceAccount resolves a token against the service
+// accounts of a project
+func AuthenticateServiceAccount(projectUUID string, token string, store stores.Store) (stores.QServiceAccount, error) {
+	return store.GetServiceAccountFromToken(projectUUID, token)
+}
+
+// ServiceAccountAuthorized checks if the scopes of a service account
+// cover a named route on a specific resource; a scope without a resource
+// covers the route on every resource of the project
+func ServiceAccountAuthorized(sa stores.QServiceAccount, routeName string, resource string) bool {
+	for _, scope := range sa.Scopes {
+		if scope.Route != routeName {
+			continue
+		}
+		if scope.Resource == "" || scope.Resource == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// checkScopes validates a scope list and converts it to its store layout
+func checkScopes(scopes []ServiceAccountScope) ([]stores.QServiceAccountScope, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("scopes list is empty")
+	}
+	qScopes := []stores.QServiceAccountScope{}
+	for _, scope := range scopes {
+		if !ValidScopeRoute(scope.Route) {
+			return nil, errors.New("invalid scope route: " + scope.Route)
+		}
+		qScopes = append(qScopes, stores.QServiceAccountScope{Route: scope.Route, Resource: scope.Resource})
+	}
+	return qScopes, nil
+}
+
+// serviceAccountFromQ converts the store layout of a service account to
+// its api representation, omitting the token
+func serviceAccountFromQ(item stores.QServiceAccount) ServiceAccount {
+	scopes := []ServiceAccountScope{}
+	for _, scope := range item.Scopes {
+		scopes = append(scopes, ServiceAccountScope{Route: scope.Route, Resource: scope.Resource})
+	}
+	return ServiceAccount{UUID: item.UUID, Name: item.Name, Scopes: scopes,
+		CreatedOn: item.CreatedOn, ModifiedOn: item.ModifiedOn, CreatedBy: item.CreatedBy}
+}
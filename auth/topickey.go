@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// TopicKey is a publish-only credential of a single topic, letting
+// devices publish without a full user account in the project; the token
+// value is only returned once, on creation
+type TopicKey struct {
+	Name      string `json:"name"`
+	Token     string `json:"token,omitempty"`
+	CreatedOn string `json:"created_on,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// TopicKeys holds the publish keys of a topic
+type TopicKeys struct {
+	List []TopicKey `json:"keys"`
+}
+
+// ExportJSON exports the topic key as a json string
+func (tk *TopicKey) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(tk, "", "   ")
+	return string(output), err
+}
+
+// ExportJSON exports the topic key list as a json string
+func (tks *TopicKeys) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(tks, "", "   ")
+	return string(output), err
+}
+
+// CreateTopicKey creates a new publish key for a topic, returning it
+// with its generated token
+func CreateTopicKey(projectUUID string, topicName string, name string, uuid string, createdOn time.Time, createdBy string, store stores.Store) (TopicKey, error) {
+	existing, err := store.QueryTopicKeys(projectUUID, topicName)
+	if err != nil {
+		return TopicKey{}, err
+	}
+	for _, key := range existing {
+		if key.Name == name {
+			return TopicKey{}, errors.New("exists")
+		}
+	}
+	token, err := GenToken()
+	if err != nil {
+		return TopicKey{}, err
+	}
+	created := createdOn.UTC().Format(time.RFC3339)
+	if err := store.InsertTopicKey(projectUUID, topicName, name, uuid, token, created, createdBy); err != nil {
+		return TopicKey{}, err
+	}
+	return TopicKey{Name: name, Token: token, CreatedOn: created, CreatedBy: createdBy}, nil
+}
+
+// ListTopicKeys returns the publish keys of a topic, omitting the token
+// values
+func ListTopicKeys(projectUUID string, topicName string, store stores.Store) (TopicKeys, error) {
+	result := TopicKeys{List: []TopicKey{}}
+	qKeys, err := store.QueryTopicKeys(projectUUID, topicName)
+	if err != nil {
+		return result, err
+	}
+	for _, key := range qKeys {
+		result.List = append(result.List, TopicKey{Name: key.Name, CreatedOn: key.CreatedOn, CreatedBy: key.CreatedBy})
+	}
+	return result, nil
+}
+
+// RevokeTopicKey revokes a publish key of a topic
+func RevokeTopicKey(projectUUID string, topicName string, name string, store stores.Store) error {
+	return store.RemoveTopicKey(projectUUID, topicName, name)
+}
+
+// AuthenticateTopicKey resolves a token against the publish keys of a
+// topic
+func AuthenticateTopicKey(projectUUID string, topicName string, token string, store stores.Store) (stores.QTopicKey, error) {
+	return store.GetTopicKeyFromToken(projectUUID, topicName, token)
+}
@@ -0,0 +1,335 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// ProjectRoles holds the roles a user holds in a given project
+type ProjectRoles struct {
+	Project string   `json:"project"`
+	Roles   []string `json:"roles"`
+	// ExpiresOn, when set, is the utc time the membership stops being
+	// valid and gets removed by the expiry sweep
+	ExpiresOn string `json:"expires_on,omitempty"`
+}
+
+// User holds the user information exposed by the api
+type User struct {
+	UUID     string         `json:"uuid"`
+	Projects []ProjectRoles `json:"projects"`
+	Name     string         `json:"name"`
+	Token    string         `json:"token,omitempty"`
+	// TokenExpiresOn, when set, is the utc time the token stops being
+	// accepted as a credential
+	TokenExpiresOn string   `json:"token_expires_on,omitempty"`
+	Email          string   `json:"email"`
+	ServiceRoles   []string `json:"service_roles"`
+	// Suspended rejects every token of the user until reactivation
+	Suspended  bool   `json:"suspended,omitempty"`
+	CreatedOn  string `json:"created_on,omitempty"`
+	ModifiedOn string `json:"modified_on,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+}
+
+// Users holds a list of users
+type Users struct {
+	List []User `json:"users"`
+}
+
+// UserToken is one of the named api keys of a user; the token value is
+// only returned once, on creation
+type UserToken struct {
+	Name      string `json:"name"`
+	Token     string `json:"token,omitempty"`
+	CreatedOn string `json:"created_on,omitempty"`
+	ExpiresOn string `json:"expires_on,omitempty"`
+}
+
+// UserTokens holds the named api keys of a user
+type UserTokens struct {
+	List []UserToken `json:"tokens"`
+}
+
+// ExportJSON exports the named token as a json string
+func (ut *UserToken) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(ut, "", "   ")
+	return string(output), err
+}
+
+// ExportJSON exports the named token list as a json string
+func (uts *UserTokens) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(uts, "", "   ")
+	return string(output), err
+}
+
+// ExportJSON exports the user as a json string
+func (u *User) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(u, "", "   ")
+	return string(output), err
+}
+
+// ExportJSON exports the user list as a json string
+func (us *Users) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(us, "", "   ")
+	return string(output), err
+}
+
+// Empty returns true if the users list holds no users
+func (us *Users) Empty() bool {
+	return len(us.List) == 0
+}
+
+// One returns the first user of the list
+func (us *Users) One() User {
+	if us.Empty() {
+		return User{}
+	}
+	return us.List[0]
+}
+
+// GetUserFromJSON creates a user from a json definition
+func GetUserFromJSON(input []byte) (User, error) {
+	u := User{}
+	err := json.Unmarshal(input, &u)
+	return u, err
+}
+
+// GenToken generates a new random token
+func GenToken() (string, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(tokenBytes), nil
+}
+
+// FindUsers returns users filtered by project uuid, user uuid or username
+func FindUsers(projectUUID string, uuid string, name string, store stores.Store) (Users, error) {
+	result := Users{}
+	qUsers, err := store.QueryUsers(projectUUID, uuid, name)
+	if err != nil {
+		return result, err
+	}
+	for _, item := range qUsers {
+		result.List = append(result.List, userFromQUser(item, store))
+	}
+	if (uuid != "" || name != "") && result.Empty() {
+		return result, errors.New("not found")
+	}
+	return result, nil
+}
+
+// GetUserByToken returns the user holding a given token
+func GetUserByToken(token string, store stores.Store) (User, error) {
+	qUser, err := store.GetUserFromToken(token)
+	if err != nil {
+		return User{}, err
+	}
+	return userFromQUser(qUser, store), nil
+}
+
+// FilterUsers returns the users matching every given filter, with the
+// selection evaluated by the store
+func FilterUsers(projectUUID string, serviceRole string, email string, namePrefix string, createdBy string, orderBy string, descending bool, store stores.Store) (Users, error) {
+	result := Users{}
+	qUsers, err := store.FilterUsers(projectUUID, serviceRole, email, namePrefix, createdBy, orderBy, descending)
+	if err != nil {
+		return result, err
+	}
+	for _, item := range qUsers {
+		result.List = append(result.List, userFromQUser(item, store))
+	}
+	return result, nil
+}
+
+// ExistsWithName checks if a user with the given username exists
+func ExistsWithName(name string, store stores.Store) bool {
+	qUsers, err := store.QueryUsers("", "", name)
+	return err == nil && len(qUsers) > 0
+}
+
+// CreateUser creates a new user in the store
+func CreateUser(uuid string, name string, projectList []ProjectRoles, token string, tokenExpiresOn string, email string, serviceRoles []string, createdOn time.Time, createdBy string, store stores.Store) (User, error) {
+	if ExistsWithName(name, store) {
+		return User{}, errors.New("exists")
+	}
+	if err := checkTokenExpiry(tokenExpiresOn); err != nil {
+		return User{}, err
+	}
+	qProjects, err := projectsToQProjectRoles(projectList, store)
+	if err != nil {
+		return User{}, err
+	}
+	created := createdOn.UTC().Format(time.RFC3339)
+	if err := store.InsertUser(uuid, qProjects, name, token, tokenExpiresOn, email, serviceRoles, created, created, createdBy); err != nil {
+		return User{}, err
+	}
+	stored, err := FindUsers("", uuid, "", store)
+	return stored.One(), err
+}
+
+// UpdateUser updates the details of an existing user
+func UpdateUser(uuid string, name string, projectList []ProjectRoles, email string, serviceRoles []string, tokenExpiresOn string, modifiedOn time.Time, store stores.Store) (User, error) {
+	if name != "" {
+		qUsers, err := store.QueryUsers("", "", name)
+		if err == nil && len(qUsers) > 0 && qUsers[0].UUID != uuid {
+			return User{}, errors.New("exists")
+		}
+	}
+	if err := checkTokenExpiry(tokenExpiresOn); err != nil {
+		return User{}, err
+	}
+	var qProjects []stores.QProjectRoles
+	if projectList != nil {
+		var err error
+		qProjects, err = projectsToQProjectRoles(projectList, store)
+		if err != nil {
+			return User{}, err
+		}
+	}
+	if err := store.UpdateUser(uuid, qProjects, name, email, serviceRoles, tokenExpiresOn, modifiedOn.UTC().Format(time.RFC3339)); err != nil {
+		return User{}, err
+	}
+	stored, err := FindUsers("", uuid, "", store)
+	return stored.One(), err
+}
+
+// SetUserSuspension moves a user in or out of the suspended state; a
+// suspended account keeps its grants and history but none of its tokens
+// authenticate until it is activated again
+func SetUserSuspension(uuid string, suspended bool, modifiedOn time.Time, store stores.Store) (User, error) {
+	if err := store.UpdateUserSuspension(uuid, suspended, modifiedOn.UTC().Format(time.RFC3339)); err != nil {
+		return User{}, err
+	}
+	stored, err := FindUsers("", uuid, "", store)
+	return stored.One(), err
+}
+
+// RefreshToken ressigns a new token to a user
+func RefreshToken(uuid string, store stores.Store) (User, error) {
+	token, err := GenToken()
+	if err != nil {
+		return User{}, err
+	}
+	if err := store.UpdateUserToken(uuid, token); err != nil {
+		return User{}, err
+	}
+	stored, err := FindUsers("", uuid, "", store)
+	return stored.One(), err
+}
+
+// CreateUserToken adds a named api key to a user; the returned token
+// value is shown only this once
+func CreateUserToken(uuid string, name string, expiresOn string, createdOn time.Time, store stores.Store) (UserToken, error) {
+	if err := checkTokenExpiry(expiresOn); err != nil {
+		return UserToken{}, err
+	}
+	token, err := GenToken()
+	if err != nil {
+		return UserToken{}, err
+	}
+	created := createdOn.UTC().Format(time.RFC3339)
+	if err := store.InsertUserToken(uuid, name, token, created, expiresOn); err != nil {
+		return UserToken{}, err
+	}
+	return UserToken{Name: name, Token: token, CreatedOn: created, ExpiresOn: expiresOn}, nil
+}
+
+// ListUserTokens returns the named api keys of a user without their values
+func ListUserTokens(uuid string, store stores.Store) (UserTokens, error) {
+	result := UserTokens{List: []UserToken{}}
+	qUsers, err := store.QueryUsers("", uuid, "")
+	if err != nil || len(qUsers) == 0 {
+		return result, errors.New("not found")
+	}
+	for _, named := range qUsers[0].Tokens {
+		result.List = append(result.List, UserToken{Name: named.Name, CreatedOn: named.CreatedOn, ExpiresOn: named.ExpiresOn})
+	}
+	return result, nil
+}
+
+// RevokeUserToken revokes a named api key of a user
+func RevokeUserToken(uuid string, name string, store stores.Store) error {
+	return store.RemoveUserToken(uuid, name)
+}
+
+// RotateToken assigns a new token to a user, keeping the replaced one
+// valid for the given grace period so clients can switch without downtime
+func RotateToken(uuid string, grace time.Duration, store stores.Store) (User, error) {
+	token, err := GenToken()
+	if err != nil {
+		return User{}, err
+	}
+	previousExpires := time.Now().UTC().Add(grace).Format(time.RFC3339)
+	if err := store.RotateUserToken(uuid, token, previousExpires); err != nil {
+		return User{}, err
+	}
+	stored, err := FindUsers("", uuid, "", store)
+	return stored.One(), err
+}
+
+// RemoveUser removes a user from the store
+func RemoveUser(uuid string, store stores.Store) error {
+	return store.RemoveUser(uuid)
+}
+
+// userFromQUser converts a store user to an api user, resolving project
+// and creator uuids to names
+func userFromQUser(item stores.QUser, store stores.Store) User {
+	projectList := []ProjectRoles{}
+	for _, pr := range item.Projects {
+		projectName := ""
+		if projects, err := store.QueryProjects(pr.ProjectUUID, ""); err == nil && len(projects) > 0 {
+			projectName = projects[0].Name
+		}
+		projectList = append(projectList, ProjectRoles{Project: projectName, Roles: pr.Roles, ExpiresOn: pr.ExpiresOn})
+	}
+	createdBy := ""
+	if item.CreatedBy != "" {
+		if qUsers, err := store.QueryUsers("", item.CreatedBy, ""); err == nil && len(qUsers) > 0 {
+			createdBy = qUsers[0].Name
+		}
+	}
+	serviceRoles := item.ServiceRoles
+	if serviceRoles == nil {
+		serviceRoles = []string{}
+	}
+	return User{UUID: item.UUID, Projects: projectList, Name: item.Name, Token: item.Token, TokenExpiresOn: item.TokenExpiresOn,
+		Email: item.Email, ServiceRoles: serviceRoles, Suspended: item.Suspended,
+		CreatedOn: item.CreatedOn, ModifiedOn: item.ModifiedOn, CreatedBy: createdBy}
+}
+
+// checkTokenExpiry validates an optional token expiration timestamp
+func checkTokenExpiry(tokenExpiresOn string) error {
+	if tokenExpiresOn == "" {
+		return nil
+	}
+	if _, err := time.Parse(time.RFC3339, tokenExpiresOn); err != nil {
+		return errors.New("invalid token_expires_on: " + tokenExpiresOn)
+	}
+	return nil
+}
+
+// projectsToQProjectRoles resolves project names to uuids for storage
+func projectsToQProjectRoles(projectList []ProjectRoles, store stores.Store) ([]stores.QProjectRoles, error) {
+	qProjects := []stores.QProjectRoles{}
+	for _, pr := range projectList {
+		projects, err := store.QueryProjects("", pr.Project)
+		if err != nil || len(projects) == 0 {
+			return nil, errors.New("invalid project: " + pr.Project)
+		}
+		if pr.ExpiresOn != "" {
+			if _, err := time.Parse(time.RFC3339, pr.ExpiresOn); err != nil {
+				return nil, errors.New("invalid expires_on: " + pr.ExpiresOn)
+			}
+		}
+		qProjects = append(qProjects, stores.QProjectRoles{ProjectUUID: projects[0].UUID, Roles: pr.Roles, ExpiresOn: pr.ExpiresOn})
+	}
+	return qProjects, nil
+}
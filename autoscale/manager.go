@@ -0,0 +1,90 @@
+// Package autoscale watches the publish rate of every topic and surfaces
+// the ones whose sustained load outruns their partition count. The
+// consume model delivers from partition 0 only — producers pin it — so
+// growing partitions would strand messages instead of adding throughput;
+// until multi-partition consumption exists, hot topics are reported
+// through events and logs for operators to shard explicitly.
+package autoscale
+
+import (
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kaggis/argo-messaging/events"
+	"github.com/kaggis/argo-messaging/metrics"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// scaleWindow is how far back the publish rate is averaged; a short
+// burst inside a mostly idle window does not count as sustained load
+const scaleWindow = 10 * time.Minute
+
+// Manager periodically compares the publish rate of every topic against
+// its partition count and reports the hot ones
+type Manager struct {
+	store stores.Store
+	// threshold is the sustained published messages per second per
+	// partition above which a topic is considered hot
+	threshold int
+	// reported throttles the hot topic events, so a continuously hot
+	// topic surfaces once per window instead of once per scan
+	reported map[string]time.Time
+}
+
+// NewManager creates a new autoscale manager
+func NewManager(store stores.Store, threshold int) *Manager {
+	return &Manager{store: store, threshold: threshold, reported: make(map[string]time.Time)}
+}
+
+// Scan inspects every topic of every project once, reporting those whose
+// rate exceeds the threshold
+func (am *Manager) Scan() {
+	qProjects, err := am.store.QueryProjects("", "")
+	if err != nil {
+		log.Errorf("AUTOSCALE: unable to list projects, %v", err)
+		return
+	}
+	for _, project := range qProjects {
+		qTopics, _, _, err := am.store.QueryTopics(project.UUID, "", "", "", 0, "", false)
+		if err != nil {
+			continue
+		}
+		for _, topic := range qTopics {
+			// routing topics store nothing themselves and opted out
+			// topics are left alone
+			if topic.Routing || topic.AutoScaleDisabled {
+				continue
+			}
+			am.checkTopic(project, topic)
+		}
+	}
+}
+
+// checkTopic reports one topic when its sustained rate exceeds the
+// threshold for its current partition count
+func (am *Manager) checkTopic(project stores.QProject, topic stores.QTopic) {
+	partitions := topic.Partitions
+	if partitions < 1 {
+		partitions = 1
+	}
+	rate := metrics.MsgRate.PerSecond(project.UUID+"."+topic.Name, scaleWindow)
+	if rate < float64(am.threshold)*float64(partitions) {
+		return
+	}
+
+	key := project.UUID + "." + topic.Name
+	if last, ok := am.reported[key]; ok && time.Since(last) < scaleWindow {
+		return
+	}
+	am.reported[key] = time.Now()
+
+	log.Warnf("AUTOSCALE: topic %v/%v is hot (%.1f msg/s over %v partition(s))", project.Name, topic.Name, rate, partitions)
+	event := events.New("topic.hot", project.Name, topic.Name)
+	event.Attrs = map[string]string{
+		"rate":       strconv.FormatFloat(rate, 'f', 1, 64),
+		"partitions": strconv.Itoa(int(partitions)),
+	}
+	events.Emit(event)
+}
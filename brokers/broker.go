@@ -0,0 +1,23 @@
+package brokers
+
+import (
+	"time"
+
+	"github.com/kaggis/argo-messaging/messages"
+)
+
+// Broker encapsulates the backend used to store and serve messages
+type Broker interface {
+	Initialize(peers []string)
+	CloseConnections()
+	Publish(topic string, msg messages.Message) (messages.Message, error)
+	PublishIdempotent(topic string, msg messages.Message) (messages.Message, error)
+	PublishRaw(topic string, payload string) error
+	GetMinOffset(topic string) int64
+	GetMaxOffset(topic string) int64
+	OffsetForTime(topic string, t time.Time) (int64, error)
+	Consume(topic string, offset int64, imm bool, max int64) ([]string, error)
+	TopicPartitions(topic string) (int32, error)
+	CreateTopic(topic string, partitions int32, replicationFactor int16, retentionMs int64) error
+	DeleteTopic(topic string) error
+}
@@ -0,0 +1,358 @@
+package brokers
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/kaggis/argo-messaging/messages"
+	log "github.com/sirupsen/logrus"
+)
+
+// KafkaBroker struct to hold the connections to a kafka cluster
+type KafkaBroker struct {
+	sync.Mutex
+	Servers  []string
+	Settings ProducerSettings
+	Client   sarama.Client
+	Producer sarama.SyncProducer
+	Consumer sarama.Consumer
+	Admin    sarama.ClusterAdmin
+	// idemProducer is created on first use by topics that publish idempotently
+	idemProducer sarama.SyncProducer
+	// metadata caches offset and partition lookups for a short ttl
+	metadata *metadataCache
+}
+
+// metadataTTL is how long cached topic metadata stays valid
+const metadataTTL = 2 * time.Second
+
+// ProducerSettings holds the tunable kafka producer options
+type ProducerSettings struct {
+	// Compression is the codec applied to produced batches
+	// (none, gzip, snappy, lz4, zstd)
+	Compression string
+	// BatchSize is the best-effort number of bytes gathered per batch
+	BatchSize int
+	// LingerMs is how long the producer waits to fill a batch
+	LingerMs int
+	// MaxInFlight bounds the outstanding unacknowledged requests per connection
+	MaxInFlight int
+}
+
+// compressionCodecs maps the configurable codec names to sarama codecs
+var compressionCodecs = map[string]sarama.CompressionCodec{
+	"":       sarama.CompressionNone,
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
+// Apply validates the producer settings and applies them on a sarama config
+func (ps ProducerSettings) Apply(cfg *sarama.Config) error {
+	codec, ok := compressionCodecs[ps.Compression]
+	if !ok {
+		return errors.New("unknown compression codec " + ps.Compression)
+	}
+	cfg.Producer.Compression = codec
+	if ps.BatchSize > 0 {
+		cfg.Producer.Flush.Bytes = ps.BatchSize
+	}
+	if ps.LingerMs > 0 {
+		cfg.Producer.Flush.Frequency = time.Duration(ps.LingerMs) * time.Millisecond
+	}
+	if ps.MaxInFlight > 0 {
+		cfg.Net.MaxOpenRequests = ps.MaxInFlight
+	}
+	return nil
+}
+
+// NewKafkaBroker creates a new kafka broker connected to a list of peers
+func NewKafkaBroker(peers []string, settings ProducerSettings) *KafkaBroker {
+	brk := KafkaBroker{Settings: settings}
+	brk.Initialize(peers)
+	return &brk
+}
+
+// Initialize connects to the kafka cluster
+func (b *KafkaBroker) Initialize(peers []string) {
+	b.Servers = peers
+
+	cfg := sarama.NewConfig()
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Retry.Max = 5
+	cfg.Producer.Return.Successes = true
+	// the consume model tracks a single offset per subscription and reads
+	// partition 0 only, so every message must land there; the default
+	// hash partitioner would scatter keyless messages across partitions
+	cfg.Producer.Partitioner = sarama.NewManualPartitioner
+	cfg.Consumer.Fetch.Default = 1000000
+	if err := b.Settings.Apply(cfg); err != nil {
+		log.Fatalf("BROKER: invalid producer settings, %v", err)
+	}
+
+	var err error
+	if b.Client, err = sarama.NewClient(b.Servers, cfg); err != nil {
+		log.Fatalf("BROKER: unable to connect to kafka peers %v, %v", b.Servers, err)
+	}
+	if b.Producer, err = sarama.NewSyncProducerFromClient(b.Client); err != nil {
+		log.Fatalf("BROKER: unable to create producer, %v", err)
+	}
+	if b.Consumer, err = sarama.NewConsumerFromClient(b.Client); err != nil {
+		log.Fatalf("BROKER: unable to create consumer, %v", err)
+	}
+	if b.Admin, err = sarama.NewClusterAdminFromClient(b.Client); err != nil {
+		log.Fatalf("BROKER: unable to create cluster admin, %v", err)
+	}
+	b.metadata = newMetadataCache(metadataTTL, b.fetchMetadata)
+
+	log.Infof("BROKER: connected to kafka peers %v", b.Servers)
+}
+
+// CloseConnections closes the connections to the kafka cluster
+func (b *KafkaBroker) CloseConnections() {
+	if b.idemProducer != nil {
+		if err := b.idemProducer.Close(); err != nil {
+			log.Errorf("BROKER: failed to close idempotent producer, %v", err)
+		}
+	}
+	if err := b.Consumer.Close(); err != nil {
+		log.Errorf("BROKER: failed to close consumer, %v", err)
+	}
+	if err := b.Producer.Close(); err != nil {
+		log.Errorf("BROKER: failed to close producer, %v", err)
+	}
+	if err := b.Client.Close(); err != nil {
+		log.Errorf("BROKER: failed to close client, %v", err)
+	}
+}
+
+// Publish publishes a message to a topic, stamping it with its offset and publish time
+func (b *KafkaBroker) Publish(topic string, msg messages.Message) (messages.Message, error) {
+	msg.Stamp(time.Now())
+	payload, err := msg.ExportJSON()
+	if err != nil {
+		return msg, err
+	}
+
+	kafkaMsg := &sarama.ProducerMessage{Topic: topic, Partition: 0, Value: sarama.StringEncoder(payload)}
+	_, offset, err := b.Producer.SendMessage(kafkaMsg)
+	if err != nil {
+		log.Errorf("BROKER: failed to publish to topic %v, %v", topic, err)
+		b.metadata.Invalidate(topic)
+		return msg, err
+	}
+
+	b.metadata.AdvanceMaxOffset(topic, offset+1)
+	msg.ID = strconv.FormatInt(offset, 10)
+	return msg, nil
+}
+
+// PublishIdempotent publishes a message through the idempotent producer, so
+// internal retries of the same record cannot create duplicates
+func (b *KafkaBroker) PublishIdempotent(topic string, msg messages.Message) (messages.Message, error) {
+	producer, err := b.idempotentProducer()
+	if err != nil {
+		return msg, err
+	}
+
+	msg.Stamp(time.Now())
+	payload, err := msg.ExportJSON()
+	if err != nil {
+		return msg, err
+	}
+
+	kafkaMsg := &sarama.ProducerMessage{Topic: topic, Partition: 0, Value: sarama.StringEncoder(payload)}
+	_, offset, err := producer.SendMessage(kafkaMsg)
+	if err != nil {
+		log.Errorf("BROKER: failed to publish idempotently to topic %v, %v", topic, err)
+		b.metadata.Invalidate(topic)
+		return msg, err
+	}
+
+	b.metadata.AdvanceMaxOffset(topic, offset+1)
+	msg.ID = strconv.FormatInt(offset, 10)
+	return msg, nil
+}
+
+// PublishRaw appends an already serialized message to a topic as is, used
+// by the internal mirroring where the original payload must be preserved
+func (b *KafkaBroker) PublishRaw(topic string, payload string) error {
+	kafkaMsg := &sarama.ProducerMessage{Topic: topic, Partition: 0, Value: sarama.StringEncoder(payload)}
+	_, offset, err := b.Producer.SendMessage(kafkaMsg)
+	if err != nil {
+		log.Errorf("BROKER: failed to publish to topic %v, %v", topic, err)
+		b.metadata.Invalidate(topic)
+		return err
+	}
+	b.metadata.AdvanceMaxOffset(topic, offset+1)
+	return nil
+}
+
+// idempotentProducer lazily creates the idempotent producer; the idempotent
+// mode requires its own client since it caps the in flight requests to one
+func (b *KafkaBroker) idempotentProducer() (sarama.SyncProducer, error) {
+	b.Lock()
+	defer b.Unlock()
+	if b.idemProducer != nil {
+		return b.idemProducer, nil
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Retry.Max = 5
+	cfg.Producer.Return.Successes = true
+	if err := b.Settings.Apply(cfg); err != nil {
+		return nil, err
+	}
+	cfg.Producer.Partitioner = sarama.NewManualPartitioner
+	cfg.Producer.Idempotent = true
+	cfg.Net.MaxOpenRequests = 1
+
+	producer, err := sarama.NewSyncProducer(b.Servers, cfg)
+	if err != nil {
+		log.Errorf("BROKER: unable to create idempotent producer, %v", err)
+		return nil, err
+	}
+	b.idemProducer = producer
+	return producer, nil
+}
+
+// fetchMetadata reads the offset and partition details of a topic from
+// the cluster; it backs the metadata cache
+func (b *KafkaBroker) fetchMetadata(topic string) (topicMetadata, error) {
+	minOffset, err := b.Client.GetOffset(topic, 0, sarama.OffsetOldest)
+	if err != nil {
+		return topicMetadata{}, err
+	}
+	maxOffset, err := b.Client.GetOffset(topic, 0, sarama.OffsetNewest)
+	if err != nil {
+		return topicMetadata{}, err
+	}
+	partitions, err := b.Client.Partitions(topic)
+	if err != nil {
+		return topicMetadata{}, err
+	}
+	return topicMetadata{MinOffset: minOffset, MaxOffset: maxOffset, Partitions: int32(len(partitions))}, nil
+}
+
+// GetMinOffset returns the oldest available offset of a topic
+func (b *KafkaBroker) GetMinOffset(topic string) int64 {
+	metadata, err := b.metadata.Get(topic)
+	if err != nil {
+		log.Errorf("BROKER: unable to get min offset for topic %v, %v", topic, err)
+		return 0
+	}
+	return metadata.MinOffset
+}
+
+// GetMaxOffset returns the next offset to be produced on a topic
+func (b *KafkaBroker) GetMaxOffset(topic string) int64 {
+	metadata, err := b.metadata.Get(topic)
+	if err != nil {
+		log.Errorf("BROKER: unable to get max offset for topic %v, %v", topic, err)
+		return 0
+	}
+	return metadata.MaxOffset
+}
+
+// OffsetForTime returns the earliest offset with a timestamp at or after
+// the given time, falling back to the max offset when the time is past
+// the latest message
+func (b *KafkaBroker) OffsetForTime(topic string, t time.Time) (int64, error) {
+	offset, err := b.Client.GetOffset(topic, 0, t.UnixMilli())
+	if err != nil {
+		log.Errorf("BROKER: unable to look up the offset by time for topic %v, %v", topic, err)
+		return 0, err
+	}
+	if offset < 0 {
+		return b.GetMaxOffset(topic), nil
+	}
+	return offset, nil
+}
+
+// Consume reads at most max messages from a topic starting at a given offset
+func (b *KafkaBroker) Consume(topic string, offset int64, imm bool, max int64) ([]string, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	metadata, err := b.metadata.Get(topic)
+	if err != nil {
+		log.Errorf("BROKER: unable to get metadata for topic %v, %v", topic, err)
+		return []string{}, err
+	}
+	maxOffset := metadata.MaxOffset
+	minOffset := metadata.MinOffset
+
+	if offset < minOffset {
+		return []string{}, errors.New("offset is off")
+	}
+	if offset >= maxOffset {
+		return []string{}, nil
+	}
+
+	partConsumer, err := b.Consumer.ConsumePartition(topic, 0, offset)
+	if err != nil {
+		log.Errorf("BROKER: unable to consume topic %v, %v", topic, err)
+		b.metadata.Invalidate(topic)
+		return []string{}, err
+	}
+	defer partConsumer.Close()
+
+	msgs := []string{}
+	timeout := time.After(300 * time.Second)
+	for {
+		select {
+		case msg := <-partConsumer.Messages():
+			msgs = append(msgs, string(msg.Value))
+			if int64(len(msgs)) >= max || msg.Offset+1 >= maxOffset {
+				return msgs, nil
+			}
+		case <-timeout:
+			return msgs, nil
+		}
+	}
+}
+
+// TopicPartitions returns the partition count of a topic on the kafka
+// cluster, or an error if the topic does not exist
+func (b *KafkaBroker) TopicPartitions(topic string) (int32, error) {
+	metadata, err := b.metadata.Get(topic)
+	if err != nil {
+		return 0, err
+	}
+	return metadata.Partitions, nil
+}
+
+// CreateTopic creates a topic on the kafka cluster with the given
+// partition count and replication factor; a positive retentionMs
+// overrides the retention of the cluster
+func (b *KafkaBroker) CreateTopic(topic string, partitions int32, replicationFactor int16, retentionMs int64) error {
+	detail := &sarama.TopicDetail{NumPartitions: partitions, ReplicationFactor: replicationFactor}
+	if retentionMs > 0 {
+		retention := strconv.FormatInt(retentionMs, 10)
+		detail.ConfigEntries = map[string]*string{"retention.ms": &retention}
+	}
+	err := b.Admin.CreateTopic(topic, detail, false)
+	if err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		log.Errorf("BROKER: unable to create topic %v, %v", topic, err)
+		return err
+	}
+	b.metadata.Invalidate(topic)
+	return nil
+}
+
+// DeleteTopic removes a topic from the kafka cluster
+func (b *KafkaBroker) DeleteTopic(topic string) error {
+	err := b.Admin.DeleteTopic(topic)
+	if err != nil && !errors.Is(err, sarama.ErrUnknownTopicOrPartition) {
+		log.Errorf("BROKER: unable to delete topic %v, %v", topic, err)
+		return err
+	}
+	b.metadata.Invalidate(topic)
+	return nil
+}
@@ -0,0 +1,40 @@
+package brokers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/suite"
+)
+
+type KafkaTestSuite struct {
+	suite.Suite
+}
+
+func (suite *KafkaTestSuite) TestProducerSettingsApply() {
+	cfg := sarama.NewConfig()
+	settings := ProducerSettings{Compression: "snappy", BatchSize: 65536, LingerMs: 50, MaxInFlight: 3}
+	suite.NoError(settings.Apply(cfg))
+	suite.Equal(sarama.CompressionSnappy, cfg.Producer.Compression)
+	suite.Equal(65536, cfg.Producer.Flush.Bytes)
+	suite.Equal(50*time.Millisecond, cfg.Producer.Flush.Frequency)
+	suite.Equal(3, cfg.Net.MaxOpenRequests)
+}
+
+func (suite *KafkaTestSuite) TestProducerSettingsDefaults() {
+	cfg := sarama.NewConfig()
+	defaultMaxOpen := cfg.Net.MaxOpenRequests
+	suite.NoError(ProducerSettings{}.Apply(cfg))
+	suite.Equal(sarama.CompressionNone, cfg.Producer.Compression)
+	suite.Equal(defaultMaxOpen, cfg.Net.MaxOpenRequests)
+}
+
+func (suite *KafkaTestSuite) TestProducerSettingsInvalidCodec() {
+	cfg := sarama.NewConfig()
+	suite.Error(ProducerSettings{Compression: "brotli"}.Apply(cfg))
+}
+
+func TestKafkaTestSuite(t *testing.T) {
+	suite.Run(t, new(KafkaTestSuite))
+}
@@ -0,0 +1,77 @@
+package brokers
+
+import (
+	"sync"
+	"time"
+)
+
+// topicMetadata holds the offset and partition details of a topic as
+// last fetched from the cluster
+type topicMetadata struct {
+	MinOffset  int64
+	MaxOffset  int64
+	Partitions int32
+}
+
+// metadataCache caches topic metadata for a short ttl so the repeated
+// offset lookups of metrics and pulls do not hit the cluster every time
+type metadataCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	fetch   func(topic string) (topicMetadata, error)
+	entries map[string]metadataEntry
+}
+
+// metadataEntry is a cached metadata record with its fetch time
+type metadataEntry struct {
+	metadata topicMetadata
+	fetched  time.Time
+}
+
+// newMetadataCache creates a metadata cache refreshing entries older than
+// the ttl through the given fetch function
+func newMetadataCache(ttl time.Duration, fetch func(topic string) (topicMetadata, error)) *metadataCache {
+	return &metadataCache{ttl: ttl, fetch: fetch, entries: make(map[string]metadataEntry)}
+}
+
+// Get returns the metadata of a topic, refreshing the cached entry when
+// missing or older than the ttl. A fetch error drops the entry so the
+// next call retries the cluster.
+func (mc *metadataCache) Get(topic string) (topicMetadata, error) {
+	mc.mu.Lock()
+	if entry, ok := mc.entries[topic]; ok && time.Since(entry.fetched) < mc.ttl {
+		mc.mu.Unlock()
+		return entry.metadata, nil
+	}
+	mc.mu.Unlock()
+
+	metadata, err := mc.fetch(topic)
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if err != nil {
+		delete(mc.entries, topic)
+		return topicMetadata{}, err
+	}
+	mc.entries[topic] = metadataEntry{metadata: metadata, fetched: time.Now()}
+	return metadata, nil
+}
+
+// Invalidate drops the cached entry of a topic
+func (mc *metadataCache) Invalidate(topic string) {
+	mc.mu.Lock()
+	delete(mc.entries, topic)
+	mc.mu.Unlock()
+}
+
+// AdvanceMaxOffset moves the cached max offset of a topic forward after a
+// successful publish, so the produced message is visible to consumers
+// before the entry expires
+func (mc *metadataCache) AdvanceMaxOffset(topic string, maxOffset int64) {
+	mc.mu.Lock()
+	if entry, ok := mc.entries[topic]; ok && maxOffset > entry.metadata.MaxOffset {
+		entry.metadata.MaxOffset = maxOffset
+		mc.entries[topic] = entry
+	}
+	mc.mu.Unlock()
+}
@@ -0,0 +1,110 @@
+package brokers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MetadataTestSuite struct {
+	suite.Suite
+}
+
+func (suite *MetadataTestSuite) TestGetCachesWithinTTL() {
+	fetches := 0
+	mc := newMetadataCache(time.Minute, func(topic string) (topicMetadata, error) {
+		fetches++
+		return topicMetadata{MinOffset: 1, MaxOffset: 5, Partitions: 3}, nil
+	})
+
+	metadata, err := mc.Get("topic1")
+	suite.NoError(err)
+	suite.Equal(int64(5), metadata.MaxOffset)
+	suite.Equal(int32(3), metadata.Partitions)
+
+	// a second lookup within the ttl does not hit the cluster
+	mc.Get("topic1")
+	suite.Equal(1, fetches)
+
+	// other topics are fetched separately
+	mc.Get("topic2")
+	suite.Equal(2, fetches)
+}
+
+func (suite *MetadataTestSuite) TestGetRefreshesAfterTTL() {
+	fetches := 0
+	mc := newMetadataCache(time.Millisecond, func(topic string) (topicMetadata, error) {
+		fetches++
+		return topicMetadata{}, nil
+	})
+
+	mc.Get("topic1")
+	time.Sleep(5 * time.Millisecond)
+	mc.Get("topic1")
+	suite.Equal(2, fetches)
+}
+
+func (suite *MetadataTestSuite) TestInvalidateForcesRefetch() {
+	fetches := 0
+	mc := newMetadataCache(time.Minute, func(topic string) (topicMetadata, error) {
+		fetches++
+		return topicMetadata{}, nil
+	})
+
+	mc.Get("topic1")
+	mc.Invalidate("topic1")
+	mc.Get("topic1")
+	suite.Equal(2, fetches)
+}
+
+func (suite *MetadataTestSuite) TestFetchErrorDropsEntry() {
+	fail := false
+	fetches := 0
+	mc := newMetadataCache(time.Minute, func(topic string) (topicMetadata, error) {
+		fetches++
+		if fail {
+			return topicMetadata{}, errors.New("metadata unavailable")
+		}
+		return topicMetadata{MaxOffset: 5}, nil
+	})
+
+	mc.Get("topic1")
+	mc.Invalidate("topic1")
+
+	// the failed fetch surfaces the error and leaves no entry behind
+	fail = true
+	_, err := mc.Get("topic1")
+	suite.Error(err)
+
+	fail = false
+	metadata, err := mc.Get("topic1")
+	suite.NoError(err)
+	suite.Equal(int64(5), metadata.MaxOffset)
+	suite.Equal(3, fetches)
+}
+
+func (suite *MetadataTestSuite) TestAdvanceMaxOffset() {
+	mc := newMetadataCache(time.Minute, func(topic string) (topicMetadata, error) {
+		return topicMetadata{MaxOffset: 5}, nil
+	})
+
+	// advancing an uncached topic is a no-op
+	mc.AdvanceMaxOffset("topic1", 10)
+	metadata, _ := mc.Get("topic1")
+	suite.Equal(int64(5), metadata.MaxOffset)
+
+	// a publish moves the cached max offset forward, never backwards
+	mc.AdvanceMaxOffset("topic1", 6)
+	metadata, _ = mc.Get("topic1")
+	suite.Equal(int64(6), metadata.MaxOffset)
+
+	mc.AdvanceMaxOffset("topic1", 2)
+	metadata, _ = mc.Get("topic1")
+	suite.Equal(int64(6), metadata.MaxOffset)
+}
+
+func TestMetadataTestSuite(t *testing.T) {
+	suite.Run(t, new(MetadataTestSuite))
+}
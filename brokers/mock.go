@@ -0,0 +1,144 @@
+package brokers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/kaggis/argo-messaging/messages"
+)
+
+// MockBroker holds the messages in memory, used for testing
+type MockBroker struct {
+	MsgList map[string][]string
+	// TopicDetails records the settings of topics created through CreateTopic
+	TopicDetails map[string]MockTopicDetail
+}
+
+// MockTopicDetail holds the creation settings of a mock topic
+type MockTopicDetail struct {
+	Partitions        int32
+	ReplicationFactor int16
+	RetentionMs       int64
+}
+
+// NewMockBroker creates a new mock broker initialized with fixture messages
+func NewMockBroker() *MockBroker {
+	brk := MockBroker{}
+	brk.Initialize([]string{"localhost"})
+	return &brk
+}
+
+// Initialize seeds the mock broker with fixture messages
+func (b *MockBroker) Initialize(peers []string) {
+	b.MsgList = make(map[string][]string)
+	b.TopicDetails = make(map[string]MockTopicDetail)
+	// seed topic1 with four messages so pull tests have data to consume
+	for i := 0; i < 4; i++ {
+		msg := messages.Message{
+			ID:      strconv.Itoa(i),
+			Data:    base64.StdEncoding.EncodeToString([]byte("mock_data_" + strconv.Itoa(i))),
+			PubTime: "2016-10-13T02:19:09Z",
+		}
+		payload, _ := msg.ExportJSON()
+		b.MsgList["argo_uuid.topic1"] = append(b.MsgList["argo_uuid.topic1"], payload)
+	}
+}
+
+// CloseConnections is a noop for the mock broker
+func (b *MockBroker) CloseConnections() {
+}
+
+// Publish appends a message to a topic, stamping it with its offset and publish time
+func (b *MockBroker) Publish(topic string, msg messages.Message) (messages.Message, error) {
+	msg.ID = strconv.Itoa(len(b.MsgList[topic]))
+	msg.Stamp(time.Now())
+	payload, err := msg.ExportJSON()
+	if err != nil {
+		return msg, err
+	}
+	b.MsgList[topic] = append(b.MsgList[topic], payload)
+	return msg, nil
+}
+
+// PublishIdempotent appends a message to a topic, same as Publish for the mock broker
+func (b *MockBroker) PublishIdempotent(topic string, msg messages.Message) (messages.Message, error) {
+	return b.Publish(topic, msg)
+}
+
+// PublishRaw appends an already serialized message to a topic as is
+func (b *MockBroker) PublishRaw(topic string, payload string) error {
+	b.MsgList[topic] = append(b.MsgList[topic], payload)
+	return nil
+}
+
+// GetMinOffset returns the oldest available offset of a topic
+func (b *MockBroker) GetMinOffset(topic string) int64 {
+	return 0
+}
+
+// GetMaxOffset returns the next offset to be produced on a topic
+func (b *MockBroker) GetMaxOffset(topic string) int64 {
+	return int64(len(b.MsgList[topic]))
+}
+
+// OffsetForTime returns the offset of the first message published at or
+// after the given time, or the max offset when every message is older
+func (b *MockBroker) OffsetForTime(topic string, t time.Time) (int64, error) {
+	for i, payload := range b.MsgList[topic] {
+		msg, err := messages.LoadMsgJSON([]byte(payload))
+		if err != nil {
+			continue
+		}
+		pubTime, err := time.Parse(time.RFC3339Nano, msg.PubTime)
+		if err != nil {
+			continue
+		}
+		if !pubTime.Before(t) {
+			return int64(i), nil
+		}
+	}
+	return int64(len(b.MsgList[topic])), nil
+}
+
+// Consume reads at most max messages from a topic starting at a given offset
+func (b *MockBroker) Consume(topic string, offset int64, imm bool, max int64) ([]string, error) {
+	msgs := b.MsgList[topic]
+	if offset < 0 {
+		return []string{}, errors.New("offset is off")
+	}
+	if offset >= int64(len(msgs)) {
+		return []string{}, nil
+	}
+	end := int64(len(msgs))
+	if offset+max < end {
+		end = offset + max
+	}
+	return msgs[offset:end], nil
+}
+
+// TopicPartitions returns the partition count of a mock topic, or an
+// error if the topic is unknown to the broker
+func (b *MockBroker) TopicPartitions(topic string) (int32, error) {
+	if detail, ok := b.TopicDetails[topic]; ok {
+		return detail.Partitions, nil
+	}
+	if _, ok := b.MsgList[topic]; ok {
+		return 1, nil
+	}
+	return 0, errors.New("topic not found")
+}
+
+// CreateTopic records a topic creation on the mock broker
+func (b *MockBroker) CreateTopic(topic string, partitions int32, replicationFactor int16, retentionMs int64) error {
+	b.TopicDetails[topic] = MockTopicDetail{Partitions: partitions, ReplicationFactor: replicationFactor, RetentionMs: retentionMs}
+	return nil
+}
+
+// DeleteTopic removes a topic from the mock broker
+func (b *MockBroker) DeleteTopic(topic string) error {
+	delete(b.MsgList, topic)
+	delete(b.TopicDetails, topic)
+	return nil
+}
@@ -0,0 +1,107 @@
+package brokers
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// Provider serves broker clients out of a pool of named kafka clusters
+type Provider interface {
+	// For returns the broker serving a named cluster, falling back to the
+	// default cluster for unknown or empty names
+	For(cluster string) Broker
+	// Default returns the broker of the default cluster
+	Default() Broker
+	// Clusters returns the names of the registered clusters
+	Clusters() []string
+	// CloseAll closes the connections of all brokers in the pool
+	CloseAll()
+}
+
+// KafkaPool maintains one kafka client per configured cluster
+type KafkaPool struct {
+	defaultCluster string
+	brokers        map[string]Broker
+}
+
+// NewKafkaPool creates a kafka broker for every configured cluster
+func NewKafkaPool(clusters map[string][]string, defaultCluster string, settings ProducerSettings) *KafkaPool {
+	pool := KafkaPool{defaultCluster: defaultCluster, brokers: make(map[string]Broker)}
+	for name, peers := range clusters {
+		pool.brokers[name] = NewKafkaBroker(peers, settings)
+	}
+	if _, ok := pool.brokers[defaultCluster]; !ok {
+		log.Fatalf("BROKER: default cluster %v is not among the configured clusters", defaultCluster)
+	}
+	return &pool
+}
+
+// For returns the broker serving a named cluster
+func (p *KafkaPool) For(cluster string) Broker {
+	if brk, ok := p.brokers[cluster]; ok {
+		return brk
+	}
+	return p.brokers[p.defaultCluster]
+}
+
+// Default returns the broker of the default cluster
+func (p *KafkaPool) Default() Broker {
+	return p.brokers[p.defaultCluster]
+}
+
+// Clusters returns the names of the registered clusters
+func (p *KafkaPool) Clusters() []string {
+	names := []string{}
+	for name := range p.brokers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// CloseAll closes the connections of all brokers in the pool
+func (p *KafkaPool) CloseAll() {
+	for _, brk := range p.brokers {
+		brk.CloseConnections()
+	}
+}
+
+// MockPool serves a single mock broker under any cluster name, used for testing
+type MockPool struct {
+	Broker Broker
+}
+
+// NewMockPool creates a pool wrapping a single mock broker
+func NewMockPool(brk Broker) *MockPool {
+	return &MockPool{Broker: brk}
+}
+
+// For returns the wrapped broker regardless of cluster name
+func (p *MockPool) For(cluster string) Broker {
+	return p.Broker
+}
+
+// Default returns the wrapped broker
+func (p *MockPool) Default() Broker {
+	return p.Broker
+}
+
+// Clusters returns the single mock cluster name
+func (p *MockPool) Clusters() []string {
+	return []string{"mock"}
+}
+
+// CloseAll closes the wrapped broker
+func (p *MockPool) CloseAll() {
+	p.Broker.CloseConnections()
+}
+
+// ForProject returns the broker serving a project by consulting the
+// project to cluster mapping held in the store
+func ForProject(pool Provider, store stores.Store, projectUUID string) Broker {
+	cluster, err := store.QueryProjectCluster(projectUUID)
+	if err != nil || cluster == "" {
+		return pool.Default()
+	}
+	return pool.For(cluster)
+}
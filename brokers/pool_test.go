@@ -0,0 +1,58 @@
+package brokers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+type PoolTestSuite struct {
+	suite.Suite
+}
+
+func (suite *PoolTestSuite) TestForProject() {
+	store := stores.NewMockStore("", "")
+	brkDefault := NewMockBroker()
+	pool := NewMockPool(brkDefault)
+
+	// a project without a cluster assignment routes to the default broker
+	suite.Equal(brkDefault, ForProject(pool, store, "argo_uuid"))
+
+	// an unknown project routes to the default broker as well
+	suite.Equal(brkDefault, ForProject(pool, store, "unknown_uuid"))
+}
+
+func (suite *PoolTestSuite) TestKafkaPoolRouting() {
+	// exercise the routing logic of KafkaPool without live connections
+	brkA := NewMockBroker()
+	brkB := NewMockBroker()
+	pool := &KafkaPool{defaultCluster: "main", brokers: map[string]Broker{"main": brkA, "heavy": brkB}}
+
+	suite.Equal(brkA, pool.Default())
+	suite.Equal(brkB, pool.For("heavy"))
+	// unknown cluster names fall back to the default cluster
+	suite.Equal(brkA, pool.For("unknown"))
+	suite.Equal(brkA, pool.For(""))
+
+	suite.ElementsMatch([]string{"main", "heavy"}, pool.Clusters())
+}
+
+func (suite *PoolTestSuite) TestStoreClusterAssignment() {
+	store := stores.NewMockStore("", "")
+	brkA := NewMockBroker()
+	brkB := NewMockBroker()
+	pool := &KafkaPool{defaultCluster: "main", brokers: map[string]Broker{"main": brkA, "heavy": brkB}}
+
+	// assigning a cluster to a project reroutes its traffic
+	suite.NoError(store.UpdateProjectCluster("argo_uuid", "heavy"))
+	suite.Equal(brkB, ForProject(pool, store, "argo_uuid"))
+
+	// other projects keep using the default cluster
+	suite.Equal(brkA, ForProject(pool, store, "argo_uuid2"))
+}
+
+func TestPoolTestSuite(t *testing.T) {
+	suite.Run(t, new(PoolTestSuite))
+}
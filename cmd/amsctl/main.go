@@ -0,0 +1,307 @@
+// amsctl is the administrative command line client of argo-messaging:
+// the common operator tasks against the json api without hand-written
+// curl calls, plus a direct store mode for recovery work
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaggis/argo-messaging/projects"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+const usage = `usage: amsctl [options] <command> [args]
+
+options:
+  -url <url>        base url of the service (default https://localhost:8080)
+  -key <token>      api token used for every call
+  -insecure         skip tls certificate verification
+
+commands:
+  user create <name> [email] [project:role1,role2 ...]
+  user show <name>
+  project create <name> [description]
+  acl show (topics|subscriptions) <project> <name>
+  acl set (topics|subscriptions) <project> <name> <user1,user2,...>
+  sub offsets <project> <subscription>
+  push reload <project> <subscription>
+
+recovery commands (talk to the store directly, bypassing the api):
+  -store-type <mongodb|embedded> -store-host <host> -store-db <db>
+  recover set-offset <project> <subscription> <offset>
+`
+
+// client wraps the calls against the json api
+type client struct {
+	base string
+	key  string
+	http *http.Client
+}
+
+// call performs one api request and returns the response body, treating
+// every non-2xx answer as an error carrying the body
+func (cl *client) call(method string, path string, body string) ([]byte, error) {
+	endpoint := strings.TrimSuffix(cl.base, "/") + path
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	endpoint += separator + "key=" + url.QueryEscape(cl.key)
+	req, err := http.NewRequest(method, endpoint, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := cl.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	answer, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%v %v answered %v: %v", method, path, resp.StatusCode, strings.TrimSpace(string(answer)))
+	}
+	return answer, nil
+}
+
+// show prints an api response as delivered, already indented json
+func show(body []byte) {
+	fmt.Println(strings.TrimSpace(string(body)))
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "amsctl: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// resourceKind validates the acl target of the acl commands
+func resourceKind(arg string) string {
+	if arg != "topics" && arg != "subscriptions" {
+		fail("acl targets are topics or subscriptions, not %v", arg)
+	}
+	return arg
+}
+
+func main() {
+	base := "https://localhost:8080"
+	key := ""
+	insecure := false
+	storeType := ""
+	storeHost := ""
+	storeDB := ""
+
+	args := os.Args[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		flag := args[0]
+		value := func() string {
+			if len(args) < 2 {
+				fail("option %v needs a value", flag)
+			}
+			v := args[1]
+			args = args[2:]
+			return v
+		}
+		switch flag {
+		case "-url":
+			base = value()
+		case "-key":
+			key = value()
+		case "-insecure":
+			insecure = true
+			args = args[1:]
+		case "-store-type":
+			storeType = value()
+		case "-store-host":
+			storeHost = value()
+		case "-store-db":
+			storeDB = value()
+		case "-h", "-help", "--help":
+			fmt.Print(usage)
+			return
+		default:
+			fail("unknown option %v\n%v", flag, usage)
+		}
+	}
+	if len(args) == 0 {
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+
+	cl := &client{base: base, key: key, http: &http.Client{Timeout: 30 * time.Second}}
+	if insecure {
+		cl.http.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	command := strings.Join(args[:min(2, len(args))], " ")
+	rest := args[min(2, len(args)):]
+	switch command {
+
+	case "user create":
+		if len(rest) < 1 {
+			fail("user create needs a name")
+		}
+		body := map[string]interface{}{}
+		if len(rest) > 1 && rest[1] != "" {
+			body["email"] = rest[1]
+		}
+		memberships := []map[string]interface{}{}
+		for _, grant := range rest[2:] {
+			parts := strings.SplitN(grant, ":", 2)
+			if len(parts) != 2 {
+				fail("project grants look like project:role1,role2, not %v", grant)
+			}
+			memberships = append(memberships, map[string]interface{}{
+				"project": parts[0], "roles": strings.Split(parts[1], ","),
+			})
+		}
+		if len(memberships) > 0 {
+			body["projects"] = memberships
+		}
+		payload, _ := json.Marshal(body)
+		answer, err := cl.call("POST", "/v1/users/"+rest[0], string(payload))
+		if err != nil {
+			fail("%v", err)
+		}
+		show(answer)
+
+	case "user show":
+		if len(rest) < 1 {
+			fail("user show needs a name")
+		}
+		answer, err := cl.call("GET", "/v1/users/"+rest[0], "")
+		if err != nil {
+			fail("%v", err)
+		}
+		show(answer)
+
+	case "project create":
+		if len(rest) < 1 {
+			fail("project create needs a name")
+		}
+		body := "{}"
+		if len(rest) > 1 {
+			payload, _ := json.Marshal(map[string]string{"description": rest[1]})
+			body = string(payload)
+		}
+		answer, err := cl.call("POST", "/v1/projects/"+rest[0], body)
+		if err != nil {
+			fail("%v", err)
+		}
+		show(answer)
+
+	case "acl show":
+		if len(rest) < 3 {
+			fail("acl show needs a kind, a project and a resource name")
+		}
+		answer, err := cl.call("GET",
+			"/v1/projects/"+rest[1]+"/"+resourceKind(rest[0])+"/"+rest[2]+":acl", "")
+		if err != nil {
+			fail("%v", err)
+		}
+		show(answer)
+
+	case "acl set":
+		if len(rest) < 4 {
+			fail("acl set needs a kind, a project, a resource name and a user list")
+		}
+		payload, _ := json.Marshal(map[string][]string{
+			"authorized_users": strings.Split(rest[3], ","),
+		})
+		answer, err := cl.call("POST",
+			"/v1/projects/"+rest[1]+"/"+resourceKind(rest[0])+"/"+rest[2]+":modifyAcl", string(payload))
+		if err != nil {
+			fail("%v", err)
+		}
+		show(answer)
+
+	case "sub offsets":
+		if len(rest) < 2 {
+			fail("sub offsets needs a project and a subscription")
+		}
+		answer, err := cl.call("GET",
+			"/v1/projects/"+rest[0]+"/subscriptions/"+rest[1]+":diagnose", "")
+		if err != nil {
+			fail("%v", err)
+		}
+		show(answer)
+
+	case "push reload":
+		if len(rest) < 2 {
+			fail("push reload needs a project and a subscription")
+		}
+		// re-submitting the current push configuration restarts the
+		// delivery loop of the subscription
+		answer, err := cl.call("GET", "/v1/projects/"+rest[0]+"/subscriptions/"+rest[1], "")
+		if err != nil {
+			fail("%v", err)
+		}
+		sub := struct {
+			PushConfig json.RawMessage `json:"pushConfig"`
+		}{}
+		if err := json.Unmarshal(answer, &sub); err != nil {
+			fail("unexpected subscription resource, %v", err)
+		}
+		if string(sub.PushConfig) == "" || string(sub.PushConfig) == "null" {
+			fail("subscription %v has no push configuration", rest[1])
+		}
+		answer, err = cl.call("POST",
+			"/v1/projects/"+rest[0]+"/subscriptions/"+rest[1]+":modifyPushConfig",
+			`{"pushConfig": `+string(sub.PushConfig)+`}`)
+		if err != nil {
+			fail("%v", err)
+		}
+		show(answer)
+
+	case "recover set-offset":
+		if len(rest) < 3 {
+			fail("recover set-offset needs a project, a subscription and an offset")
+		}
+		offset, err := strconv.ParseInt(rest[2], 10, 64)
+		if err != nil {
+			fail("%v is not a valid offset", rest[2])
+		}
+		var str stores.Store
+		switch storeType {
+		case "mongodb":
+			str = stores.NewMongoStore(storeHost, storeDB)
+		case "embedded":
+			str = stores.NewEmbeddedStore(storeHost)
+		default:
+			fail("recovery commands need -store-type mongodb or embedded")
+		}
+		str.Initialize()
+		defer str.Close()
+		projectUUID := projects.GetUUIDByName(rest[0], str)
+		if projectUUID == "" {
+			fail("project %v does not exist", rest[0])
+		}
+		if err := str.UpdateSubOffset(projectUUID, rest[1], offset); err != nil {
+			fail("unable to update the offset, %v", err)
+		}
+		fmt.Printf("offset of %v/%v set to %v\n", rest[0], rest[1], offset)
+
+	default:
+		fail("unknown command %v\n%v", strings.Join(args, " "), usage)
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,761 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// APICfg holds the service configuration
+type APICfg struct {
+	// BindIP is the ip address the service will bind to
+	BindIP string
+	// Port is the port the service will listen on
+	Port int
+	// Clusters maps a kafka cluster name to its list of broker hosts
+	Clusters map[string][]string
+	// DefaultCluster is the cluster used for projects without an explicit mapping
+	DefaultCluster string
+	// DefaultPartitions is the partition count of newly created kafka topics
+	DefaultPartitions int32
+	// DefaultReplicationFactor is the replication factor of newly created kafka topics
+	DefaultReplicationFactor int16
+	// AutoScaleEnabled enables the scanner reporting topics whose
+	// sustained publish rate outruns their partition count
+	AutoScaleEnabled bool
+	// AutoScaleMsgsPerSec is the sustained published messages per second
+	// per partition above which a topic is reported as hot
+	AutoScaleMsgsPerSec int
+	// ProducerCompression is the compression codec of the kafka producer
+	ProducerCompression string
+	// ProducerBatchSize is the best-effort batch size in bytes of the kafka producer
+	ProducerBatchSize int
+	// ProducerLingerMs is how long the kafka producer waits to fill a batch
+	ProducerLingerMs int
+	// ProducerMaxInFlight bounds the outstanding requests per kafka connection
+	ProducerMaxInFlight int
+	// StoreType selects the store backend, either "mongodb" (default) or
+	// "embedded" for a single-node store that needs no mongodb deployment
+	StoreType string
+	// StoreHost is the store backend host. For the mongodb backend this is
+	// either a plain host[:port] or a full mongodb:// / mongodb+srv://
+	// connection string with auth, replica set and tls options; for the
+	// embedded backend it is the path of the snapshot file
+	StoreHost string
+	// StoreDB is the store database name
+	StoreDB string
+	// GRPCEnabled enables the grpc api alongside the http api
+	GRPCEnabled bool
+	// GRPCPort is the port the grpc api will listen on
+	GRPCPort int
+	// MQTTEnabled enables the mqtt bridge alongside the http api
+	MQTTEnabled bool
+	// MQTTPort is the port the mqtt bridge will listen on
+	MQTTPort int
+	// AMQPEnabled enables the amqp 1.0 bridge alongside the http api
+	AMQPEnabled bool
+	// AMQPPort is the port the amqp bridge will listen on
+	AMQPPort int
+	// SocketPath binds the api to a unix domain socket alongside tcp,
+	// for sidecar and reverse proxy deployments
+	SocketPath string
+	// SocketMode sets the permission bits of the socket file, octal
+	SocketMode string
+	// TLSMinVersion is the lowest tls version the listeners accept
+	TLSMinVersion string
+	// TLSCipherSuites restricts the tls 1.2 cipher suites by name; an
+	// empty list keeps the defaults of the runtime
+	TLSCipherSuites []string
+	// TLSCurvePreferences orders the key exchange curves by name; an
+	// empty list keeps the defaults of the runtime
+	TLSCurvePreferences []string
+	// TLSClientCertCA is a ca bundle path; when set clients must present
+	// a certificate it signed
+	TLSClientCertCA string
+	// TLSEnabled terminates tls in the service itself; switching it off
+	// is only meant for deployments behind a tls terminating balancer
+	TLSEnabled bool
+	// TrustedProxies lists the addresses or networks of the balancers
+	// whose X-Forwarded-For/Proto headers are honored
+	TrustedProxies []string
+	// Cert is the path to the tls certificate file
+	Cert string
+	// CertKey is the path to the tls certificate key file
+	CertKey string
+	// ResAuth enables per-resource authorization (ACLs)
+	ResAuth bool
+	// PushEnabled enables the in-process push manager
+	PushEnabled bool
+	// PushServerHost is the endpoint of an external push server (optional)
+	PushServerHost string
+	// PushAuthzKey is the secret encrypting the stored push
+	// authorization headers at rest
+	PushAuthzKey string
+	// PushTLSCertDir is the directory holding the client certificate
+	// pairs (<name>.crt, <name>.key) that push deliveries can present to
+	// endpoints requiring mutual tls
+	PushTLSCertDir string
+	// PushAWSCredentials maps a credentials name to the aws key pair
+	// signing push deliveries towards sqs and sns destinations
+	PushAWSCredentials map[string]AWSCredentials
+	// PushSMTPHost is the host:port of the smtp server delivering email
+	// push destinations (empty disables the email destination type)
+	PushSMTPHost string
+	// PushSMTPFrom is the sender address of email push deliveries
+	PushSMTPFrom string
+	// PushSMTPUser authenticates against the smtp server (optional)
+	PushSMTPUser string
+	// PushSMTPPassword is the password of the smtp user
+	PushSMTPPassword string
+	// OIDCEnabled accepts openid connect access tokens alongside the
+	// static user tokens
+	OIDCEnabled bool
+	// OIDCIssuer is the expected iss claim of accepted tokens
+	OIDCIssuer string
+	// OIDCJWKSURL is the jwks endpoint serving the provider signing keys
+	OIDCJWKSURL string
+	// OIDCUserClaim is the token claim mapped to the service user name
+	OIDCUserClaim string
+	// OIDCAudience is the expected aud claim of accepted tokens; empty
+	// skips the audience check
+	OIDCAudience string
+	// OIDCAutoProvision creates unknown federated users on their first
+	// request, without any roles
+	OIDCAutoProvision bool
+	// DirSyncEnabled runs the periodic user import from an external
+	// ldap/voms directory
+	DirSyncEnabled bool
+	// DirSyncIntervalSec is how often the directory sync runs
+	DirSyncIntervalSec int
+	// DirSyncLDAPHost is the host:port of the directory server
+	DirSyncLDAPHost string
+	// DirSyncLDAPTLS dials the directory server over tls (ldaps)
+	DirSyncLDAPTLS bool
+	// DirSyncLDAPBindDN and DirSyncLDAPBindPassword authenticate the
+	// directory searches; both empty binds anonymously
+	DirSyncLDAPBindDN       string
+	DirSyncLDAPBindPassword string
+	// DirSyncLDAPBaseDN is the subtree searched for account entries
+	DirSyncLDAPBaseDN string
+	// DirSyncLDAPFilter selects the account entries within the subtree
+	DirSyncLDAPFilter string
+	// DirSyncLDAPUserAttr, DirSyncLDAPEmailAttr and DirSyncLDAPGroupAttr
+	// name the entry attributes read into the account name, email and
+	// group list
+	DirSyncLDAPUserAttr  string
+	DirSyncLDAPEmailAttr string
+	DirSyncLDAPGroupAttr string
+	// DirSyncMappings maps a directory group to the project and roles its
+	// members are granted
+	DirSyncMappings map[string]DirSyncMapping
+	// AckDeadlineSec is the default subscription ack deadline in seconds
+	AckDeadlineSec int
+	// PullMaxWaitSec bounds how long a pull with returnImmediately=false
+	// waits for messages before returning an empty list
+	PullMaxWaitSec int
+	// AuthCacheTTLSec enables caching of token and acl lookups for the
+	// given number of seconds (0 disables the cache)
+	AuthCacheTTLSec int
+	// TokenGracePeriodSec is how long a rotated-out token stays valid so
+	// clients can switch to the new one without downtime
+	TokenGracePeriodSec int
+	// PublishIdentityAttributes stamps published messages with
+	// server-verified publisher provenance under the reserved "ams."
+	// attribute namespace
+	PublishIdentityAttributes bool
+	// PublishDedupWindowSec is how long a client supplied dedupId
+	// suppresses retried publishes of the same message
+	PublishDedupWindowSec int
+	// AlertSubscriptionLag is the subscription lag in messages above which
+	// the exported prometheus alert rules fire
+	AlertSubscriptionLag int
+	// AlertPushFailuresPerMin is the push failure rate per minute above
+	// which the exported prometheus alert rules fire
+	AlertPushFailuresPerMin int
+	// AlertQuotaSaturationPct is the quota usage percentage above which
+	// the exported prometheus alert rules fire
+	AlertQuotaSaturationPct int
+	// DataPlaneMaxConcurrent bounds concurrent publish/pull/ack requests (0 for unlimited)
+	DataPlaneMaxConcurrent int
+	// ControlPlaneMaxConcurrent bounds concurrent administrative requests (0 for unlimited)
+	ControlPlaneMaxConcurrent int
+	// MetricsPort serves the prometheus metrics endpoint when set; 0
+	// keeps the endpoint off
+	MetricsPort int
+	// CORSAllowedOrigins lists the origins browser clients may call the
+	// api from; an empty list leaves cross origin requests unanswered
+	CORSAllowedOrigins []string
+	// CORSAllowedHeaders lists the request headers allowed on cross
+	// origin calls
+	CORSAllowedHeaders []string
+	// CORSMaxAgeSec is how long browsers may cache a preflight response
+	CORSMaxAgeSec int
+	// LogLevel sets the logging verbosity
+	LogLevel string
+	// LogOutput selects where logs are written: stdout (default), file,
+	// syslog or journald
+	LogOutput string
+	// LogFormat selects the log line format, either text (default) or json
+	LogFormat string
+	// LogFile is the path of the log file used when LogOutput is "file"
+	LogFile string
+	// LogFileMaxSizeMB is the size in megabytes past which the log file is
+	// rotated (0 disables rotation)
+	LogFileMaxSizeMB int
+	// LogFileMaxBackups is how many rotated log files are kept
+	LogFileMaxBackups int
+}
+
+// DirSyncMapping grants the members of one directory group a set of
+// roles in a project
+type DirSyncMapping struct {
+	Project string   `json:"project"`
+	Roles   []string `json:"roles"`
+}
+
+// AWSCredentials is a named aws key pair referenced by subscriptions
+// pushing towards sqs and sns destinations
+type AWSCredentials struct {
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+}
+
+// schemaField binds one configuration key to its field, its default and
+// its validation, so loading, checking and dumping all derive from the
+// same table
+type schemaField struct {
+	key    string
+	target interface{}
+	// def applies the default when the key is absent from the input
+	def func()
+	// check validates the loaded value, run after all defaults applied
+	check func() error
+	// secret redacts the value in the effective configuration dump
+	secret bool
+}
+
+// deprecatedKeys maps keys of earlier releases to their replacement;
+// their values still load best-effort so old config files keep working
+var deprecatedKeys = map[string]string{
+	"broker_hosts":   "kafka_clusters",
+	"log_facilities": "log_output",
+}
+
+// schema returns the configuration schema of the service
+func (cfg *APICfg) schema() []schemaField {
+	portCheck := func(key string, target *int) func() error {
+		return func() error {
+			if *target < 0 || *target > 65535 {
+				return errors.New(key + " must be a port number between 0 and 65535")
+			}
+			return nil
+		}
+	}
+	nonNegative := func(key string, target *int) func() error {
+		return func() error {
+			if *target < 0 {
+				return errors.New(key + " must not be negative")
+			}
+			return nil
+		}
+	}
+	oneOf := func(key string, target *string, allowed ...string) func() error {
+		return func() error {
+			for _, value := range allowed {
+				if *target == value {
+					return nil
+				}
+			}
+			return errors.New(key + " must be one of " + strings.Join(allowed, ", "))
+		}
+	}
+	intDef := func(target *int, value int) func() { return func() { *target = value } }
+
+	return []schemaField{
+		{key: "bind_ip", target: &cfg.BindIP},
+		{key: "port", target: &cfg.Port, check: portCheck("port", &cfg.Port)},
+		{key: "kafka_clusters", target: &cfg.Clusters},
+		{key: "kafka_default_cluster", target: &cfg.DefaultCluster, check: func() error {
+			if cfg.DefaultCluster != "" && len(cfg.Clusters) > 0 && cfg.Clusters[cfg.DefaultCluster] == nil {
+				return errors.New("kafka_default_cluster names a cluster missing from kafka_clusters")
+			}
+			return nil
+		}},
+		{key: "kafka_default_partitions", target: &cfg.DefaultPartitions,
+			def: func() { cfg.DefaultPartitions = 1 },
+			check: func() error {
+				// consumption reads a single partition per topic
+				if cfg.DefaultPartitions != 1 {
+					return errors.New("kafka_default_partitions must be 1")
+				}
+				return nil
+			}},
+		{key: "kafka_default_replication_factor", target: &cfg.DefaultReplicationFactor,
+			def: func() { cfg.DefaultReplicationFactor = 1 },
+			check: func() error {
+				if cfg.DefaultReplicationFactor < 1 {
+					return errors.New("kafka_default_replication_factor must be at least 1")
+				}
+				return nil
+			}},
+		{key: "autoscale_enabled", target: &cfg.AutoScaleEnabled},
+		{key: "autoscale_msgs_per_sec", target: &cfg.AutoScaleMsgsPerSec,
+			def: intDef(&cfg.AutoScaleMsgsPerSec, 100),
+			check: func() error {
+				if cfg.AutoScaleMsgsPerSec < 1 {
+					return errors.New("autoscale_msgs_per_sec must be at least 1")
+				}
+				return nil
+			}},
+		{key: "kafka_producer_compression", target: &cfg.ProducerCompression,
+			check: oneOf("kafka_producer_compression", &cfg.ProducerCompression,
+				"", "none", "gzip", "snappy", "lz4", "zstd")},
+		{key: "kafka_producer_batch_size", target: &cfg.ProducerBatchSize,
+			check: nonNegative("kafka_producer_batch_size", &cfg.ProducerBatchSize)},
+		{key: "kafka_producer_linger_ms", target: &cfg.ProducerLingerMs,
+			check: nonNegative("kafka_producer_linger_ms", &cfg.ProducerLingerMs)},
+		{key: "kafka_producer_max_in_flight", target: &cfg.ProducerMaxInFlight,
+			check: nonNegative("kafka_producer_max_in_flight", &cfg.ProducerMaxInFlight)},
+		{key: "store_type", target: &cfg.StoreType,
+			def:   func() { cfg.StoreType = "mongodb" },
+			check: oneOf("store_type", &cfg.StoreType, "mongodb", "embedded")},
+		{key: "store_host", target: &cfg.StoreHost},
+		{key: "store_db", target: &cfg.StoreDB},
+		{key: "grpc_enabled", target: &cfg.GRPCEnabled},
+		{key: "grpc_port", target: &cfg.GRPCPort,
+			def: intDef(&cfg.GRPCPort, 8443), check: portCheck("grpc_port", &cfg.GRPCPort)},
+		{key: "mqtt_enabled", target: &cfg.MQTTEnabled},
+		{key: "mqtt_port", target: &cfg.MQTTPort,
+			def: intDef(&cfg.MQTTPort, 8883), check: portCheck("mqtt_port", &cfg.MQTTPort)},
+		{key: "amqp_enabled", target: &cfg.AMQPEnabled},
+		{key: "amqp_port", target: &cfg.AMQPPort,
+			def: intDef(&cfg.AMQPPort, 5671), check: portCheck("amqp_port", &cfg.AMQPPort)},
+		{key: "certificate", target: &cfg.Cert},
+		{key: "certificate_key", target: &cfg.CertKey},
+		{key: "socket_path", target: &cfg.SocketPath},
+		{key: "socket_mode", target: &cfg.SocketMode,
+			def: func() { cfg.SocketMode = "0660" },
+			check: func() error {
+				if _, err := strconv.ParseUint(cfg.SocketMode, 8, 32); err != nil {
+					return errors.New("socket_mode must be an octal permission string")
+				}
+				return nil
+			}},
+		{key: "tls_enabled", target: &cfg.TLSEnabled,
+			def: func() { cfg.TLSEnabled = true }},
+		{key: "tls_min_version", target: &cfg.TLSMinVersion,
+			def:   func() { cfg.TLSMinVersion = "1.2" },
+			check: oneOf("tls_min_version", &cfg.TLSMinVersion, "1.0", "1.1", "1.2", "1.3")},
+		{key: "tls_cipher_suites", target: &cfg.TLSCipherSuites,
+			check: func() error {
+				for _, name := range cfg.TLSCipherSuites {
+					if _, ok := cipherSuiteIDs()[name]; !ok {
+						return errors.New("tls_cipher_suites entry " + name + " is not a known secure cipher suite")
+					}
+				}
+				return nil
+			}},
+		{key: "tls_curve_preferences", target: &cfg.TLSCurvePreferences,
+			check: func() error {
+				for _, name := range cfg.TLSCurvePreferences {
+					if _, ok := curveIDs[name]; !ok {
+						return errors.New("tls_curve_preferences entry " + name + " is not a known curve")
+					}
+				}
+				return nil
+			}},
+		{key: "tls_client_cert_ca", target: &cfg.TLSClientCertCA},
+		{key: "trusted_proxies", target: &cfg.TrustedProxies},
+		{key: "per_resource_auth", target: &cfg.ResAuth},
+		{key: "push_enabled", target: &cfg.PushEnabled},
+		{key: "push_server_host", target: &cfg.PushServerHost},
+		{key: "push_authz_encryption_key", target: &cfg.PushAuthzKey, secret: true},
+		{key: "push_tls_cert_dir", target: &cfg.PushTLSCertDir},
+		{key: "push_aws_credentials", target: &cfg.PushAWSCredentials, secret: true,
+			check: func() error {
+				for name, cred := range cfg.PushAWSCredentials {
+					if cred.AccessKey == "" || cred.SecretKey == "" {
+						return errors.New("push_aws_credentials entry " + name + " must set access_key and secret_key")
+					}
+				}
+				return nil
+			}},
+		{key: "push_smtp_host", target: &cfg.PushSMTPHost},
+		{key: "push_smtp_from", target: &cfg.PushSMTPFrom, check: func() error {
+			if cfg.PushSMTPHost != "" && cfg.PushSMTPFrom == "" {
+				return errors.New("push_smtp_from must be set when push_smtp_host is set")
+			}
+			return nil
+		}},
+		{key: "push_smtp_user", target: &cfg.PushSMTPUser},
+		{key: "push_smtp_password", target: &cfg.PushSMTPPassword, secret: true},
+		{key: "oidc_enabled", target: &cfg.OIDCEnabled},
+		{key: "oidc_issuer", target: &cfg.OIDCIssuer, check: func() error {
+			if cfg.OIDCEnabled && cfg.OIDCIssuer == "" {
+				return errors.New("oidc_issuer must be set when oidc_enabled is set")
+			}
+			return nil
+		}},
+		{key: "oidc_jwks_url", target: &cfg.OIDCJWKSURL, check: func() error {
+			if cfg.OIDCEnabled && cfg.OIDCJWKSURL == "" {
+				return errors.New("oidc_jwks_url must be set when oidc_enabled is set")
+			}
+			return nil
+		}},
+		{key: "oidc_user_claim", target: &cfg.OIDCUserClaim,
+			def: func() { cfg.OIDCUserClaim = "sub" }},
+		{key: "oidc_audience", target: &cfg.OIDCAudience},
+		{key: "oidc_auto_provision", target: &cfg.OIDCAutoProvision},
+		{key: "dir_sync_enabled", target: &cfg.DirSyncEnabled},
+		{key: "dir_sync_interval_sec", target: &cfg.DirSyncIntervalSec,
+			def: intDef(&cfg.DirSyncIntervalSec, 3600),
+			check: func() error {
+				if cfg.DirSyncEnabled && cfg.DirSyncIntervalSec < 60 {
+					return errors.New("dir_sync_interval_sec must be at least 60")
+				}
+				return nil
+			}},
+		{key: "dir_sync_ldap_host", target: &cfg.DirSyncLDAPHost, check: func() error {
+			if cfg.DirSyncEnabled && cfg.DirSyncLDAPHost == "" {
+				return errors.New("dir_sync_ldap_host must be set when dir_sync_enabled is set")
+			}
+			return nil
+		}},
+		{key: "dir_sync_ldap_tls", target: &cfg.DirSyncLDAPTLS},
+		{key: "dir_sync_ldap_bind_dn", target: &cfg.DirSyncLDAPBindDN},
+		{key: "dir_sync_ldap_bind_password", target: &cfg.DirSyncLDAPBindPassword, secret: true},
+		{key: "dir_sync_ldap_base_dn", target: &cfg.DirSyncLDAPBaseDN, check: func() error {
+			if cfg.DirSyncEnabled && cfg.DirSyncLDAPBaseDN == "" {
+				return errors.New("dir_sync_ldap_base_dn must be set when dir_sync_enabled is set")
+			}
+			return nil
+		}},
+		{key: "dir_sync_ldap_filter", target: &cfg.DirSyncLDAPFilter,
+			def: func() { cfg.DirSyncLDAPFilter = "(objectClass=person)" }},
+		{key: "dir_sync_ldap_user_attr", target: &cfg.DirSyncLDAPUserAttr,
+			def: func() { cfg.DirSyncLDAPUserAttr = "uid" }},
+		{key: "dir_sync_ldap_email_attr", target: &cfg.DirSyncLDAPEmailAttr,
+			def: func() { cfg.DirSyncLDAPEmailAttr = "mail" }},
+		{key: "dir_sync_ldap_group_attr", target: &cfg.DirSyncLDAPGroupAttr,
+			def: func() { cfg.DirSyncLDAPGroupAttr = "isMemberOf" }},
+		{key: "dir_sync_mappings", target: &cfg.DirSyncMappings,
+			check: func() error {
+				for group, mapping := range cfg.DirSyncMappings {
+					if mapping.Project == "" || len(mapping.Roles) == 0 {
+						return errors.New("dir_sync_mappings entry " + group + " must set project and roles")
+					}
+				}
+				return nil
+			}},
+		{key: "ack_deadline_sec", target: &cfg.AckDeadlineSec,
+			def: intDef(&cfg.AckDeadlineSec, 10),
+			check: func() error {
+				if cfg.AckDeadlineSec < 1 || cfg.AckDeadlineSec > 600 {
+					return errors.New("ack_deadline_sec must be between 1 and 600")
+				}
+				return nil
+			}},
+		{key: "pull_max_wait_sec", target: &cfg.PullMaxWaitSec,
+			def: intDef(&cfg.PullMaxWaitSec, 30),
+			check: func() error {
+				if cfg.PullMaxWaitSec < 1 || cfg.PullMaxWaitSec > 300 {
+					return errors.New("pull_max_wait_sec must be between 1 and 300")
+				}
+				return nil
+			}},
+		{key: "auth_cache_ttl_sec", target: &cfg.AuthCacheTTLSec,
+			check: nonNegative("auth_cache_ttl_sec", &cfg.AuthCacheTTLSec)},
+		{key: "token_grace_period_sec", target: &cfg.TokenGracePeriodSec,
+			def:   intDef(&cfg.TokenGracePeriodSec, 3600),
+			check: nonNegative("token_grace_period_sec", &cfg.TokenGracePeriodSec)},
+		{key: "publish_identity_attributes", target: &cfg.PublishIdentityAttributes},
+		{key: "publish_dedup_window_sec", target: &cfg.PublishDedupWindowSec,
+			def:   intDef(&cfg.PublishDedupWindowSec, 300),
+			check: nonNegative("publish_dedup_window_sec", &cfg.PublishDedupWindowSec)},
+		{key: "alert_subscription_lag", target: &cfg.AlertSubscriptionLag,
+			def:   intDef(&cfg.AlertSubscriptionLag, 1000),
+			check: nonNegative("alert_subscription_lag", &cfg.AlertSubscriptionLag)},
+		{key: "alert_push_failures_per_min", target: &cfg.AlertPushFailuresPerMin,
+			def:   intDef(&cfg.AlertPushFailuresPerMin, 10),
+			check: nonNegative("alert_push_failures_per_min", &cfg.AlertPushFailuresPerMin)},
+		{key: "alert_quota_saturation_pct", target: &cfg.AlertQuotaSaturationPct,
+			def: intDef(&cfg.AlertQuotaSaturationPct, 90),
+			check: func() error {
+				if cfg.AlertQuotaSaturationPct < 0 || cfg.AlertQuotaSaturationPct > 100 {
+					return errors.New("alert_quota_saturation_pct must be between 0 and 100")
+				}
+				return nil
+			}},
+		{key: "data_plane_max_concurrent", target: &cfg.DataPlaneMaxConcurrent,
+			check: nonNegative("data_plane_max_concurrent", &cfg.DataPlaneMaxConcurrent)},
+		{key: "control_plane_max_concurrent", target: &cfg.ControlPlaneMaxConcurrent,
+			check: nonNegative("control_plane_max_concurrent", &cfg.ControlPlaneMaxConcurrent)},
+		{key: "metrics_port", target: &cfg.MetricsPort, check: portCheck("metrics_port", &cfg.MetricsPort)},
+		{key: "cors_allowed_origins", target: &cfg.CORSAllowedOrigins},
+		{key: "cors_allowed_headers", target: &cfg.CORSAllowedHeaders,
+			def: func() {
+				cfg.CORSAllowedHeaders = []string{"Content-Type", "Authorization", "X-Request-Id"}
+			}},
+		{key: "cors_max_age_sec", target: &cfg.CORSMaxAgeSec,
+			def:   intDef(&cfg.CORSMaxAgeSec, 3600),
+			check: nonNegative("cors_max_age_sec", &cfg.CORSMaxAgeSec)},
+		{key: "log_level", target: &cfg.LogLevel, check: func() error {
+			if cfg.LogLevel == "" {
+				return nil
+			}
+			if _, err := log.ParseLevel(cfg.LogLevel); err != nil {
+				return errors.New("log_level is not a known level")
+			}
+			return nil
+		}},
+		{key: "log_output", target: &cfg.LogOutput,
+			check: oneOf("log_output", &cfg.LogOutput, "", "stdout", "file", "syslog", "journald")},
+		{key: "log_format", target: &cfg.LogFormat,
+			check: oneOf("log_format", &cfg.LogFormat, "", "text", "json")},
+		{key: "log_file", target: &cfg.LogFile},
+		{key: "log_file_max_size_mb", target: &cfg.LogFileMaxSizeMB,
+			def:   intDef(&cfg.LogFileMaxSizeMB, 100),
+			check: nonNegative("log_file_max_size_mb", &cfg.LogFileMaxSizeMB)},
+		{key: "log_file_max_backups", target: &cfg.LogFileMaxBackups,
+			def:   intDef(&cfg.LogFileMaxBackups, 3),
+			check: nonNegative("log_file_max_backups", &cfg.LogFileMaxBackups)},
+	}
+}
+
+// NewAPICfg creates a new APICfg. If the argument "LOAD" is given the
+// configuration is immediately loaded from the default location
+func NewAPICfg(params ...string) *APICfg {
+	cfg := APICfg{}
+
+	// If LOAD parameter is present, load the config from the default location
+	if len(params) > 0 && params[0] == "LOAD" {
+		cfg.Load()
+		return &cfg
+	}
+
+	return &cfg
+}
+
+// defaultConfigPath is the default location of the config file
+const defaultConfigPath = "/etc/argo-messaging/config.json"
+
+// Load loads the configuration from the default location
+func (cfg *APICfg) Load() {
+	cfg.LoadFilePath(defaultConfigPath)
+}
+
+// LoadFilePath loads the configuration from a given file path
+func (cfg *APICfg) LoadFilePath(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("CONFIG: cannot read configuration file %v, %v", path, err)
+	}
+	if err := cfg.LoadStrJSON(string(data)); err != nil {
+		log.Fatalf("CONFIG: cannot parse configuration file %v, %v", path, err)
+	}
+}
+
+// LoadStrJSON loads the configuration from a json string against the
+// schema: defaults apply for absent keys, values are validated, and
+// unknown or deprecated keys are warned about
+func (cfg *APICfg) LoadStrJSON(input string) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(input), &raw); err != nil {
+		return err
+	}
+
+	schema := cfg.schema()
+	known := map[string]bool{}
+	for _, field := range schema {
+		known[field.key] = true
+		value, ok := raw[field.key]
+		if !ok {
+			if field.def != nil {
+				field.def()
+			}
+			continue
+		}
+		if err := json.Unmarshal(value, field.target); err != nil {
+			return errors.New("invalid value for config key " + field.key)
+		}
+	}
+
+	if err := cfg.loadDeprecated(raw); err != nil {
+		return err
+	}
+	for key := range raw {
+		if _, deprecated := deprecatedKeys[key]; !known[key] && !deprecated {
+			log.Warnf("CONFIG: unknown configuration key %v", key)
+		}
+	}
+
+	if cfg.DefaultCluster == "" && len(cfg.Clusters) == 1 {
+		for name := range cfg.Clusters {
+			cfg.DefaultCluster = name
+		}
+	}
+
+	for _, field := range schema {
+		if field.check == nil {
+			continue
+		}
+		if err := field.check(); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("CONFIG: loaded configuration for %v kafka cluster(s)", len(cfg.Clusters))
+	return nil
+}
+
+// loadDeprecated maps the keys of earlier releases onto their current
+// fields so old config files keep working, warning about each one
+func (cfg *APICfg) loadDeprecated(raw map[string]json.RawMessage) error {
+	for key, replacement := range deprecatedKeys {
+		if _, ok := raw[key]; ok {
+			log.Warnf("CONFIG: key %v is deprecated, use %v", key, replacement)
+		}
+	}
+
+	// broker_hosts predates the named cluster map and listed the brokers
+	// of the single kafka cluster
+	if value, ok := raw["broker_hosts"]; ok {
+		hosts := []string{}
+		if err := json.Unmarshal(value, &hosts); err != nil {
+			return errors.New("invalid value for config key broker_hosts")
+		}
+		if len(cfg.Clusters) == 0 {
+			cfg.Clusters = map[string][]string{"default": hosts}
+		}
+	}
+
+	// log_facilities predates log_output and listed syslog facilities
+	if value, ok := raw["log_facilities"]; ok {
+		facilities := []string{}
+		if err := json.Unmarshal(value, &facilities); err != nil {
+			return errors.New("invalid value for config key log_facilities")
+		}
+		if cfg.LogOutput == "" {
+			for _, facility := range facilities {
+				if facility == "syslog" {
+					cfg.LogOutput = "syslog"
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Effective returns the effective configuration keyed as in the config
+// file with secrets redacted, served by the config admin endpoint
+func (cfg *APICfg) Effective() map[string]interface{} {
+	result := map[string]interface{}{}
+	for _, field := range cfg.schema() {
+		var value interface{}
+		switch target := field.target.(type) {
+		case *string:
+			value = *target
+		case *int:
+			value = *target
+		case *int32:
+			value = *target
+		case *int16:
+			value = *target
+		case *bool:
+			value = *target
+		case *[]string:
+			value = *target
+		case *map[string][]string:
+			value = *target
+		default:
+			value = "unsupported"
+		}
+		if field.secret {
+			if str, ok := value.(string); ok && str != "" {
+				value = "<redacted>"
+			}
+		}
+		result[field.key] = value
+	}
+	// a mongodb connection string may embed credentials
+	result["store_host"] = redactStoreHost(cfg.StoreHost)
+	// only the names of the aws credential entries are exposed
+	credNames := make([]string, 0, len(cfg.PushAWSCredentials))
+	for name := range cfg.PushAWSCredentials {
+		credNames = append(credNames, name)
+	}
+	sort.Strings(credNames)
+	result["push_aws_credentials"] = credNames
+	return result
+}
+
+// redactStoreHost strips the credentials out of a connection string
+func redactStoreHost(host string) string {
+	scheme, rest, ok := strings.Cut(host, "://")
+	if !ok {
+		return host
+	}
+	if userinfo, hostpart, ok := strings.Cut(rest, "@"); ok && !strings.Contains(userinfo, "/") {
+		return scheme + "://<redacted>@" + hostpart
+	}
+	return host
+}
+
+// tlsVersions maps the configured version names onto the runtime constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// curveIDs maps the configured curve names onto the runtime constants
+var curveIDs = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P-256":  tls.CurveP256,
+	"P-384":  tls.CurveP384,
+	"P-521":  tls.CurveP521,
+}
+
+// cipherSuiteIDs maps the names of the secure cipher suites of the
+// runtime onto their ids; the insecure set is deliberately absent
+func cipherSuiteIDs() map[string]uint16 {
+	ids := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+	return ids
+}
+
+// TLSServerConfig builds the tls listener configuration of the service
+// from the configured minimum version, cipher suites, curve preferences
+// and client certificate requirements
+func (cfg *APICfg) TLSServerConfig() (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tlsVersions[cfg.TLSMinVersion]}
+	for _, name := range cfg.TLSCipherSuites {
+		tlsCfg.CipherSuites = append(tlsCfg.CipherSuites, cipherSuiteIDs()[name])
+	}
+	for _, name := range cfg.TLSCurvePreferences {
+		tlsCfg.CurvePreferences = append(tlsCfg.CurvePreferences, curveIDs[name])
+	}
+	if cfg.TLSClientCertCA != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCertCA)
+		if err != nil {
+			return nil, errors.New("unable to read tls_client_cert_ca, " + err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("tls_client_cert_ca holds no usable ca certificate")
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsCfg, nil
+}
@@ -0,0 +1,378 @@
+package dirsync
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LDAPSource lists accounts through an ldap v3 simple bind and a subtree
+// search; it speaks the small protocol subset this needs directly, so no
+// client library is pulled in
+type LDAPSource struct {
+	// Addr is the host:port of the directory server
+	Addr string
+	// TLS dials the server over tls (ldaps)
+	TLS bool
+	// BindDN and BindPassword authenticate the search connection; both
+	// empty performs an anonymous bind
+	BindDN       string
+	BindPassword string
+	// BaseDN is the subtree the account search runs under
+	BaseDN string
+	// Filter selects the account entries, e.g. (objectClass=person);
+	// equality, presence and and/or groupings are supported
+	Filter string
+	// UserAttr, EmailAttr and GroupAttr name the entry attributes read
+	// into the account name, email and group list
+	UserAttr  string
+	EmailAttr string
+	GroupAttr string
+}
+
+// Fetch runs the configured search and returns the matching accounts
+func (src *LDAPSource) Fetch() ([]Entry, error) {
+	filter, err := parseFilter(src.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	if src.TLS {
+		conn, err = tls.DialWithDialer(&dialer, "tcp", src.Addr, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", src.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	rd := bufio.NewReader(conn)
+
+	// simple bind, then check the result code of the bind response
+	bind := berSeq(tagBindRequest,
+		berInt(tagInteger, ldapVersion),
+		berString(tagOctetString, src.BindDN),
+		berString(tagSimpleAuth, src.BindPassword))
+	if _, err := conn.Write(berEnvelope(1, bind)); err != nil {
+		return nil, err
+	}
+	op, err := readOp(rd, tagBindResponse)
+	if err != nil {
+		return nil, err
+	}
+	if code := resultCode(op); code != 0 {
+		return nil, errors.New("ldap bind failed with result code " + strconv.Itoa(code))
+	}
+
+	// subtree search for the account entries
+	search := berSeq(tagSearchRequest,
+		berString(tagOctetString, src.BaseDN),
+		berInt(tagEnumerated, scopeWholeSubtree),
+		berInt(tagEnumerated, 0), // neverDerefAliases
+		berInt(tagInteger, 0),    // no size limit
+		berInt(tagInteger, 0),    // no time limit
+		[]byte{tagBoolean, 1, 0}, // typesOnly false
+		filter,
+		berSeq(tagSequence,
+			berString(tagOctetString, src.UserAttr),
+			berString(tagOctetString, src.EmailAttr),
+			berString(tagOctetString, src.GroupAttr)))
+	if _, err := conn.Write(berEnvelope(2, search)); err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	for {
+		op, err := readOp(rd, 0)
+		if err != nil {
+			return nil, err
+		}
+		if op.tag == tagSearchResultDone {
+			if code := resultCode(op); code != 0 {
+				return nil, errors.New("ldap search failed with result code " + strconv.Itoa(code))
+			}
+			break
+		}
+		if op.tag != tagSearchResultEntry {
+			continue
+		}
+		if entry, ok := src.parseEntry(op); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	conn.Write(berEnvelope(3, []byte{tagUnbindRequest, 0}))
+	return entries, nil
+}
+
+// parseEntry maps the attributes of a search result entry onto an
+// account; entries without the user attribute are skipped
+func (src *LDAPSource) parseEntry(op berElement) (Entry, bool) {
+	rest := op.content
+	// objectName, then the partial attribute list
+	if _, r, err := berNext(rest); err != nil {
+		return Entry{}, false
+	} else {
+		rest = r
+	}
+	attrList, _, err := berNext(rest)
+	if err != nil {
+		return Entry{}, false
+	}
+
+	entry := Entry{}
+	attrs := attrList.content
+	for len(attrs) > 0 {
+		attr, r, err := berNext(attrs)
+		if err != nil {
+			return Entry{}, false
+		}
+		attrs = r
+		name, r2, err := berNext(attr.content)
+		if err != nil {
+			continue
+		}
+		valSet, _, err := berNext(r2)
+		if err != nil {
+			continue
+		}
+		values := []string{}
+		vals := valSet.content
+		for len(vals) > 0 {
+			val, r3, err := berNext(vals)
+			if err != nil {
+				break
+			}
+			vals = r3
+			values = append(values, string(val.content))
+		}
+		if len(values) == 0 {
+			continue
+		}
+		switch string(name.content) {
+		case src.UserAttr:
+			entry.Name = values[0]
+		case src.EmailAttr:
+			entry.Email = values[0]
+		case src.GroupAttr:
+			entry.Groups = values
+		}
+	}
+	return entry, entry.Name != ""
+}
+
+// the ber tags of the ldap v3 subset used above
+const (
+	ldapVersion       = 3
+	scopeWholeSubtree = 2
+
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+
+	tagBindRequest       = 0x60
+	tagBindResponse      = 0x61
+	tagUnbindRequest     = 0x42
+	tagSearchRequest     = 0x63
+	tagSearchResultEntry = 0x64
+	tagSearchResultDone  = 0x65
+	tagSimpleAuth        = 0x80
+
+	tagFilterAnd      = 0xa0
+	tagFilterOr       = 0xa1
+	tagFilterEquality = 0xa3
+	tagFilterPresent  = 0x87
+)
+
+// berElement is one decoded tag-length-value element
+type berElement struct {
+	tag     byte
+	content []byte
+}
+
+// berLen encodes a ber length, using the long form past 127 bytes
+func berLen(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	body := []byte{}
+	for v := n; v > 0; v = v >> 8 {
+		body = append([]byte{byte(v)}, body...)
+	}
+	return append([]byte{0x80 | byte(len(body))}, body...)
+}
+
+// berSeq encodes a constructed element holding the given parts
+func berSeq(tag byte, parts ...[]byte) []byte {
+	content := []byte{}
+	for _, part := range parts {
+		content = append(content, part...)
+	}
+	return append(append([]byte{tag}, berLen(len(content))...), content...)
+}
+
+// berString encodes a string under the given tag
+func berString(tag byte, value string) []byte {
+	return append(append([]byte{tag}, berLen(len(value))...), value...)
+}
+
+// berInt encodes a small non-negative integer under the given tag
+func berInt(tag byte, value int) []byte {
+	body := []byte{byte(value)}
+	for v := value >> 8; v > 0; v = v >> 8 {
+		body = append([]byte{byte(v)}, body...)
+	}
+	return append(append([]byte{tag}, berLen(len(body))...), body...)
+}
+
+// berEnvelope wraps a protocol op into an ldap message with the given
+// message id
+func berEnvelope(messageID int, op []byte) []byte {
+	return berSeq(tagSequence, berInt(tagInteger, messageID), op)
+}
+
+// berNext decodes the first element of data and returns the remainder
+func berNext(data []byte) (berElement, []byte, error) {
+	if len(data) < 2 {
+		return berElement{}, nil, errors.New("ldap response truncated")
+	}
+	tag := data[0]
+	length := int(data[1])
+	rest := data[2:]
+	if length >= 0x80 {
+		digits := length & 0x7f
+		if digits > 4 || len(rest) < digits {
+			return berElement{}, nil, errors.New("ldap response truncated")
+		}
+		length = 0
+		for _, digit := range rest[:digits] {
+			length = length<<8 | int(digit)
+		}
+		rest = rest[digits:]
+	}
+	if len(rest) < length {
+		return berElement{}, nil, errors.New("ldap response truncated")
+	}
+	return berElement{tag: tag, content: rest[:length]}, rest[length:], nil
+}
+
+// readOp reads one ldap message off the wire and returns its protocol
+// op, checking the tag when one is expected
+func readOp(rd *bufio.Reader, expect byte) (berElement, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(rd, header); err != nil {
+		return berElement{}, err
+	}
+	length := int(header[1])
+	if length >= 0x80 {
+		digits := make([]byte, length&0x7f)
+		if len(digits) > 4 {
+			return berElement{}, errors.New("ldap response too large")
+		}
+		if _, err := io.ReadFull(rd, digits); err != nil {
+			return berElement{}, err
+		}
+		length = 0
+		for _, digit := range digits {
+			length = length<<8 | int(digit)
+		}
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(rd, content); err != nil {
+		return berElement{}, err
+	}
+
+	// skip the message id, the op follows
+	if _, rest, err := berNext(content); err != nil {
+		return berElement{}, err
+	} else {
+		content = rest
+	}
+	op, _, err := berNext(content)
+	if err != nil {
+		return berElement{}, err
+	}
+	if expect != 0 && op.tag != expect {
+		return berElement{}, errors.New("unexpected ldap response tag " + strconv.Itoa(int(op.tag)))
+	}
+	return op, nil
+}
+
+// resultCode extracts the result code leading a bind or done response
+func resultCode(op berElement) int {
+	code, _, err := berNext(op.content)
+	if err != nil {
+		return -1
+	}
+	value := 0
+	for _, digit := range code.content {
+		value = value<<8 | int(digit)
+	}
+	return value
+}
+
+// parseFilter encodes a search filter of the supported forms: (attr=*),
+// (attr=value) and (&...)/(|...) groupings of them
+func parseFilter(filter string) ([]byte, error) {
+	encoded, rest, err := parseFilterPart(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, errors.New("invalid ldap filter: trailing input")
+	}
+	return encoded, nil
+}
+
+// parseFilterPart encodes the leading parenthesized expression of input
+// and returns the remainder
+func parseFilterPart(input string) ([]byte, string, error) {
+	if !strings.HasPrefix(input, "(") {
+		return nil, "", errors.New("invalid ldap filter: expected (")
+	}
+	body := input[1:]
+
+	if strings.HasPrefix(body, "&") || strings.HasPrefix(body, "|") {
+		tag := byte(tagFilterAnd)
+		if body[0] == '|' {
+			tag = tagFilterOr
+		}
+		body = body[1:]
+		parts := [][]byte{}
+		for strings.HasPrefix(body, "(") {
+			part, rest, err := parseFilterPart(body)
+			if err != nil {
+				return nil, "", err
+			}
+			parts = append(parts, part)
+			body = rest
+		}
+		if len(parts) == 0 || !strings.HasPrefix(body, ")") {
+			return nil, "", errors.New("invalid ldap filter: unterminated group")
+		}
+		return berSeq(tag, parts...), body[1:], nil
+	}
+
+	end := strings.Index(body, ")")
+	eq := strings.Index(body, "=")
+	if end < 0 || eq < 1 || eq > end {
+		return nil, "", errors.New("invalid ldap filter: expected attr=value")
+	}
+	attr, value := body[:eq], body[eq+1:end]
+	if value == "*" {
+		return berString(tagFilterPresent, attr), body[end+1:], nil
+	}
+	return berSeq(tagFilterEquality,
+		berString(tagOctetString, attr),
+		berString(tagOctetString, value)), body[end+1:], nil
+}
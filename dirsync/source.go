@@ -0,0 +1,20 @@
+// Package dirsync periodically imports users and project memberships
+// from an external directory (ldap/voms), so account management is not
+// duplicated between the directory and the service
+package dirsync
+
+// Entry is one account as reported by the directory
+type Entry struct {
+	// Name is the account name the service user is keyed by
+	Name string
+	// Email is the contact address of the account
+	Email string
+	// Groups holds the directory group memberships the mapping rules
+	// translate into project roles
+	Groups []string
+}
+
+// Source lists the accounts of an external directory
+type Source interface {
+	Fetch() ([]Entry, error)
+}
@@ -0,0 +1,185 @@
+package dirsync
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kaggis/argo-messaging/auth"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// Mapping routes the members of one directory group into a project with
+// a set of roles
+type Mapping struct {
+	Project string
+	Roles   []string
+}
+
+// Syncer imports the accounts of a directory source into the user store
+// according to a set of group mapping rules
+type Syncer struct {
+	source   Source
+	mappings map[string]Mapping
+	store    stores.Store
+}
+
+// NewSyncer creates a new Syncer over the given source and mapping rules
+func NewSyncer(source Source, mappings map[string]Mapping, store stores.Store) *Syncer {
+	return &Syncer{source: source, mappings: mappings, store: store}
+}
+
+// Run fetches the directory accounts and reconciles the users and the
+// memberships of the mapped projects against them, returning how many
+// users were created and updated. Memberships of projects outside the
+// mapping rules are left alone, as are users the directory does not know
+func (sync *Syncer) Run(now time.Time) (int, int, error) {
+	entries, err := sync.source.Fetch()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	qProjects, err := sync.store.QueryProjects("", "")
+	if err != nil {
+		return 0, 0, err
+	}
+	projectUUIDs := map[string]string{}
+	for _, project := range qProjects {
+		projectUUIDs[project.Name] = project.UUID
+	}
+
+	// the sync owns the memberships of the projects the rules map into
+	managed := map[string]bool{}
+	for group, mapping := range sync.mappings {
+		projectUUID, ok := projectUUIDs[mapping.Project]
+		if !ok {
+			log.Warnf("DIRSYNC: mapping of group %v targets unknown project %v", group, mapping.Project)
+			continue
+		}
+		managed[projectUUID] = true
+	}
+
+	created, updated := 0, 0
+	stamp := now.UTC().Format(time.RFC3339)
+	for _, entry := range entries {
+		desired := sync.desiredMemberships(entry, projectUUIDs)
+
+		qUsers, err := sync.store.QueryUsers("", "", entry.Name)
+		if err != nil {
+			return created, updated, err
+		}
+		if len(qUsers) == 0 {
+			// only accounts mapping into at least one project are imported
+			if len(desired) == 0 {
+				continue
+			}
+			token, err := auth.GenToken()
+			if err != nil {
+				return created, updated, err
+			}
+			if err := sync.store.InsertUser(uuid.New().String(), desired, entry.Name, token, "",
+				entry.Email, nil, stamp, stamp, "dirsync"); err != nil {
+				return created, updated, err
+			}
+			created++
+			continue
+		}
+
+		user := qUsers[0]
+		projects, changed := mergeMemberships(user.Projects, desired, managed)
+		if entry.Email != "" && entry.Email != user.Email {
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err := sync.store.UpdateUser(user.UUID, projects, "", entry.Email, nil, "", stamp); err != nil {
+			return created, updated, err
+		}
+		updated++
+	}
+	return created, updated, nil
+}
+
+// desiredMemberships folds the groups of an account through the mapping
+// rules into per-project role lists
+func (sync *Syncer) desiredMemberships(entry Entry, projectUUIDs map[string]string) []stores.QProjectRoles {
+	roleSets := map[string]map[string]bool{}
+	for _, group := range entry.Groups {
+		mapping, ok := sync.mappings[group]
+		if !ok {
+			continue
+		}
+		projectUUID, ok := projectUUIDs[mapping.Project]
+		if !ok {
+			continue
+		}
+		if roleSets[projectUUID] == nil {
+			roleSets[projectUUID] = map[string]bool{}
+		}
+		for _, role := range mapping.Roles {
+			roleSets[projectUUID][role] = true
+		}
+	}
+
+	result := []stores.QProjectRoles{}
+	for projectUUID, roleSet := range roleSets {
+		roles := []string{}
+		for role := range roleSet {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		result = append(result, stores.QProjectRoles{ProjectUUID: projectUUID, Roles: roles})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ProjectUUID < result[j].ProjectUUID })
+	return result
+}
+
+// mergeMemberships replaces the managed project memberships of a user
+// with the desired ones, keeping the rest, and reports whether anything
+// changed
+func mergeMemberships(current []stores.QProjectRoles, desired []stores.QProjectRoles, managed map[string]bool) ([]stores.QProjectRoles, bool) {
+	result := []stores.QProjectRoles{}
+	kept := map[string]stores.QProjectRoles{}
+	for _, membership := range current {
+		if managed[membership.ProjectUUID] {
+			kept[membership.ProjectUUID] = membership
+			continue
+		}
+		result = append(result, membership)
+	}
+
+	changed := false
+	for _, membership := range desired {
+		existing, ok := kept[membership.ProjectUUID]
+		if !ok || !sameRoles(existing.Roles, membership.Roles) {
+			changed = true
+		}
+		delete(kept, membership.ProjectUUID)
+		result = append(result, membership)
+	}
+	// memberships of managed projects the directory no longer grants
+	if len(kept) > 0 {
+		changed = true
+	}
+	return result, changed
+}
+
+// sameRoles compares two role lists ignoring order
+func sameRoles(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
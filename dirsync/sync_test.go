@@ -0,0 +1,140 @@
+package dirsync
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+type DirSyncTestSuite struct {
+	suite.Suite
+}
+
+// stubSource serves a fixed entry list
+type stubSource struct {
+	entries []Entry
+}
+
+func (src *stubSource) Fetch() ([]Entry, error) {
+	return src.entries, nil
+}
+
+func (suite *DirSyncTestSuite) TestRunReconciles() {
+	str := stores.NewMockStore("", "")
+	mappings := map[string]Mapping{
+		"cn=argo-producers": {Project: "ARGO", Roles: []string{"publisher"}},
+		"cn=argo2-members":  {Project: "ARGO2", Roles: []string{"consumer"}},
+	}
+	source := &stubSource{entries: []Entry{
+		// unknown to the store, imported with the mapped membership
+		{Name: "dirnew", Email: "dirnew@example.org", Groups: []string{"cn=argo-producers"}},
+		// known user whose managed memberships move to ARGO2
+		{Name: "UserA", Email: "usera@example.org", Groups: []string{"cn=argo2-members"}},
+		// no mapped group, not imported
+		{Name: "outsider", Groups: []string{"cn=unrelated"}},
+	}}
+
+	created, updated, err := NewSyncer(source, mappings, str).Run(time.Now())
+	suite.NoError(err)
+	suite.Equal(1, created)
+	suite.Equal(1, updated)
+
+	qUsers, _ := str.QueryUsers("", "", "dirnew")
+	suite.Equal(1, len(qUsers))
+	suite.Equal("dirnew@example.org", qUsers[0].Email)
+	suite.Equal("dirsync", qUsers[0].CreatedBy)
+	suite.NotEqual("", qUsers[0].Token)
+	suite.Equal([]stores.QProjectRoles{{ProjectUUID: "argo_uuid", Roles: []string{"publisher"}}}, qUsers[0].Projects)
+
+	// the ARGO membership of UserA is managed by the rules and was not
+	// granted by the directory, so it was dropped in favor of ARGO2
+	qUsers, _ = str.QueryUsers("", "", "UserA")
+	suite.Equal([]stores.QProjectRoles{{ProjectUUID: "argo_uuid2", Roles: []string{"consumer"}}}, qUsers[0].Projects)
+
+	qUsers, _ = str.QueryUsers("", "", "outsider")
+	suite.Equal(0, len(qUsers))
+
+	// a second run finds nothing left to change
+	created, updated, err = NewSyncer(source, mappings, str).Run(time.Now())
+	suite.NoError(err)
+	suite.Equal(0, created)
+	suite.Equal(0, updated)
+}
+
+func (suite *DirSyncTestSuite) TestLDAPFetch() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.NoError(err)
+	defer listener.Close()
+
+	// a directory stub speaking the same protocol subset: accept the
+	// bind, answer the search with two entries
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rd := bufio.NewReader(conn)
+
+		if _, err := readOp(rd, tagBindRequest); err != nil {
+			return
+		}
+		conn.Write(berEnvelope(1, berSeq(tagBindResponse,
+			berInt(tagEnumerated, 0), berString(tagOctetString, ""), berString(tagOctetString, ""))))
+
+		if _, err := readOp(rd, tagSearchRequest); err != nil {
+			return
+		}
+		entry := func(dn string, attrs ...[]byte) []byte {
+			return berSeq(tagSearchResultEntry, berString(tagOctetString, dn), berSeq(tagSequence, attrs...))
+		}
+		attr := func(name string, values ...string) []byte {
+			vals := [][]byte{}
+			for _, value := range values {
+				vals = append(vals, berString(tagOctetString, value))
+			}
+			return berSeq(tagSequence, berString(tagOctetString, name), berSeq(0x31, vals...))
+		}
+		conn.Write(berEnvelope(2, entry("uid=alice,dc=example",
+			attr("uid", "alice"), attr("mail", "alice@example.org"),
+			attr("isMemberOf", "cn=groupA", "cn=groupB"))))
+		conn.Write(berEnvelope(2, entry("cn=noname,dc=example", attr("mail", "ignored@example.org"))))
+		conn.Write(berEnvelope(2, berSeq(tagSearchResultDone,
+			berInt(tagEnumerated, 0), berString(tagOctetString, ""), berString(tagOctetString, ""))))
+	}()
+
+	source := &LDAPSource{
+		Addr:      listener.Addr().String(),
+		BaseDN:    "dc=example",
+		Filter:    "(&(objectClass=person)(uid=*))",
+		UserAttr:  "uid",
+		EmailAttr: "mail",
+		GroupAttr: "isMemberOf",
+	}
+	entries, err := source.Fetch()
+	suite.NoError(err)
+	suite.Equal(1, len(entries))
+	suite.Equal("alice", entries[0].Name)
+	suite.Equal("alice@example.org", entries[0].Email)
+	suite.Equal([]string{"cn=groupA", "cn=groupB"}, entries[0].Groups)
+}
+
+func (suite *DirSyncTestSuite) TestParseFilter() {
+	for _, valid := range []string{"(objectClass=person)", "(uid=*)", "(&(a=1)(|(b=2)(c=*)))"} {
+		_, err := parseFilter(valid)
+		suite.NoError(err, valid)
+	}
+	for _, invalid := range []string{"", "objectClass=person", "(a=1", "(&)", "(a=1)(b=2)"} {
+		_, err := parseFilter(invalid)
+		suite.Error(err, invalid)
+	}
+}
+
+func TestDirSyncTestSuite(t *testing.T) {
+	suite.Run(t, new(DirSyncTestSuite))
+}
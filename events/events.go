@@ -0,0 +1,101 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event is a control-plane event emitted by the service
+type Event struct {
+	// Type names the event, e.g. topic.created or push.activated
+	Type string `json:"type"`
+	// Project is the name of the project the event belongs to, if any
+	Project string `json:"project,omitempty"`
+	// Resource is the affected resource, if any
+	Resource string `json:"resource,omitempty"`
+	// Timestamp is the utc time the event occurred
+	Timestamp string `json:"timestamp"`
+	// Attrs carries extra event details
+	Attrs map[string]string `json:"attributes,omitempty"`
+}
+
+// New creates a new event stamped with the current time
+func New(eventType string, project string, resource string) Event {
+	return Event{Type: eventType, Project: project, Resource: resource,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano)}
+}
+
+// ExportJSON exports the event as a json string
+func (e *Event) ExportJSON() (string, error) {
+	output, err := json.Marshal(e)
+	return string(output), err
+}
+
+// Hub fans control-plane events out to its subscribers
+type Hub struct {
+	sync.Mutex
+	nextID      int
+	subscribers map[int]chan Event
+}
+
+// subscriberBuffer bounds the queued events per subscriber; slow
+// subscribers drop events rather than block the emitters
+const subscriberBuffer = 64
+
+// NewHub creates a new event hub
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[int]chan Event)}
+}
+
+// Emit delivers an event to all current subscribers
+func (h *Hub) Emit(event Event) {
+	h.Lock()
+	defer h.Unlock()
+	for _, sub := range h.subscribers {
+		select {
+		case sub <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe registers a new subscriber, returning its channel and a
+// function that cancels the subscription
+func (h *Hub) Subscribe() (<-chan Event, func()) {
+	h.Lock()
+	defer h.Unlock()
+	id := h.nextID
+	h.nextID++
+	ch := make(chan Event, subscriberBuffer)
+	h.subscribers[id] = ch
+	cancel := func() {
+		h.Lock()
+		defer h.Unlock()
+		if sub, ok := h.subscribers[id]; ok {
+			delete(h.subscribers, id)
+			close(sub)
+		}
+	}
+	return ch, cancel
+}
+
+// Subscribers returns the number of active subscribers
+func (h *Hub) Subscribers() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.subscribers)
+}
+
+// defaultHub is the service-wide hub used by the api and the push subsystem
+var defaultHub = NewHub()
+
+// Emit delivers an event on the service-wide hub
+func Emit(event Event) {
+	defaultHub.Emit(event)
+}
+
+// Subscribe registers a subscriber on the service-wide hub
+func Subscribe() (<-chan Event, func()) {
+	return defaultHub.Subscribe()
+}
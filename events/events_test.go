@@ -0,0 +1,55 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type EventsTestSuite struct {
+	suite.Suite
+}
+
+func (suite *EventsTestSuite) TestSubscribeEmit() {
+	hub := NewHub()
+	stream, cancel := hub.Subscribe()
+	suite.Equal(1, hub.Subscribers())
+
+	hub.Emit(New("topic.created", "ARGO", "projects/ARGO/topics/topic1"))
+	event := <-stream
+	suite.Equal("topic.created", event.Type)
+	suite.Equal("ARGO", event.Project)
+	suite.Equal("projects/ARGO/topics/topic1", event.Resource)
+	suite.NotEmpty(event.Timestamp)
+
+	cancel()
+	suite.Equal(0, hub.Subscribers())
+	// cancelling twice is safe
+	cancel()
+
+	// emitting without subscribers is a noop
+	hub.Emit(New("topic.deleted", "ARGO", "topic1"))
+}
+
+func (suite *EventsTestSuite) TestSlowSubscriberDoesNotBlock() {
+	hub := NewHub()
+	_, cancel := hub.Subscribe()
+	defer cancel()
+
+	// emit more events than the subscriber buffer holds, the overflow
+	// is dropped instead of blocking the emitter
+	for i := 0; i < subscriberBuffer*2; i++ {
+		hub.Emit(New("topic.created", "ARGO", "t"))
+	}
+}
+
+func (suite *EventsTestSuite) TestExportJSON() {
+	event := Event{Type: "push.activated", Resource: "projects/ARGO/subscriptions/sub4", Timestamp: "2016-10-13T02:19:09Z"}
+	output, err := event.ExportJSON()
+	suite.NoError(err)
+	suite.Equal(`{"type":"push.activated","resource":"projects/ARGO/subscriptions/sub4","timestamp":"2016-10-13T02:19:09Z"}`, output)
+}
+
+func TestEventsTestSuite(t *testing.T) {
+	suite.Run(t, new(EventsTestSuite))
+}
@@ -0,0 +1,753 @@
+// Package grpcapi exposes the core messaging api over grpc, sharing the
+// auth, store and broker layers with the http handlers.
+package grpcapi
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/kaggis/argo-messaging/auth"
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/config"
+	"github.com/kaggis/argo-messaging/events"
+	"github.com/kaggis/argo-messaging/grpcapi/amspb"
+	"github.com/kaggis/argo-messaging/messages"
+	"github.com/kaggis/argo-messaging/names"
+	"github.com/kaggis/argo-messaging/projects"
+	"github.com/kaggis/argo-messaging/push"
+	"github.com/kaggis/argo-messaging/stores"
+	"github.com/kaggis/argo-messaging/subscriptions"
+	"github.com/kaggis/argo-messaging/topics"
+)
+
+// maxMessageSize is the maximum accepted payload size of a single message
+const maxMessageSize = 1048576
+
+// streamPollInterval is how often a streaming pull polls the broker when
+// there are no new messages or no flow control window
+const streamPollInterval = 250 * time.Millisecond
+
+// defaultOutstanding is the flow control window of a streaming pull when
+// the client does not set max_outstanding_messages
+const defaultOutstanding = int64(100)
+
+// validName restricts the names of user created resources
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Server implements the AMS grpc service on top of the same service
+// references the http handlers use
+type Server struct {
+	amspb.UnimplementedAMSServer
+	cfg  *config.APICfg
+	pool brokers.Provider
+	str  stores.Store
+	prv  push.Provider
+}
+
+// NewServer creates a new grpc server backed by the given service references
+func NewServer(cfg *config.APICfg, pool brokers.Provider, str stores.Store, prv push.Provider) *Server {
+	return &Server{cfg: cfg, pool: pool, str: str, prv: prv}
+}
+
+// Serve registers the service and blocks serving grpc requests over tls
+func Serve(cfg *config.APICfg, pool brokers.Provider, str stores.Store, prv push.Provider) error {
+	creds, err := credentials.NewServerTLSFromFile(cfg.Cert, cfg.CertKey)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", cfg.BindIP+":"+strconv.Itoa(cfg.GRPCPort))
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer(grpc.Creds(creds))
+	amspb.RegisterAMSServer(srv, NewServer(cfg, pool, str, prv))
+	log.Infof("GRPC: argo-messaging grpc api listens on %v", listener.Addr())
+	return srv.Serve(listener)
+}
+
+// session holds the authenticated caller of a single rpc
+type session struct {
+	projectUUID string
+	userUUID    string
+	roles       []string
+	// requestID correlates the rpc with the push activation calls it
+	// triggers; taken from the x-request-id metadata when the caller
+	// supplies one
+	requestID string
+}
+
+// authorize authenticates the rpc metadata token and authorizes it
+// against the named route, mirroring the http auth wrapper
+func (srv *Server) authorize(ctx context.Context, routeName string, projectName string) (session, error) {
+	sess := session{}
+
+	if projectName != "" {
+		sess.projectUUID = projects.GetUUIDByName(projectName, srv.str)
+		if sess.projectUUID == "" {
+			return sess, status.Error(codes.NotFound, "project not found")
+		}
+	}
+
+	token := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-api-key"); len(values) > 0 {
+			token = values[0]
+		}
+		if values := md.Get("x-request-id"); len(values) > 0 {
+			sess.requestID = values[0]
+		}
+	}
+	if sess.requestID == "" {
+		sess.requestID = uuid.NewString()
+	}
+	if token == "" {
+		return sess, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+
+	sess.roles, sess.userUUID = auth.Authenticate(sess.projectUUID, token, srv.str)
+	if sess.userUUID == "" {
+		return sess, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	if !auth.Authorize(routeName, sess.roles, srv.str) {
+		return sess, status.Error(codes.PermissionDenied, "access forbidden")
+	}
+	return sess, nil
+}
+
+// perResourceAuthorized checks access to a specific resource when per
+// resource authorization is enabled
+func (srv *Server) perResourceAuthorized(sess session, resourceType string, resourceName string) bool {
+	if !srv.cfg.ResAuth {
+		return true
+	}
+	if auth.IsServiceAdmin(sess.roles) || auth.IsProjectAdmin(sess.roles) {
+		return true
+	}
+	return auth.PerResource(sess.projectUUID, resourceType, resourceName, sess.userUUID, srv.str)
+}
+
+// exportTopic maps a topic model to its grpc representation
+func exportTopic(topic topics.Topic) *amspb.Topic {
+	return &amspb.Topic{
+		Name:              topic.FullName,
+		Partitions:        topic.Partitions,
+		ReplicationFactor: int32(topic.ReplicationFactor),
+		Idempotent:        topic.Idempotent,
+		InheritProjectAcl: topic.InheritProjectACL,
+	}
+}
+
+// exportSub maps a subscription model to its grpc representation
+func exportSub(sub subscriptions.Subscription) *amspb.Subscription {
+	result := amspb.Subscription{
+		Name:               sub.FullName,
+		Topic:              sub.FullTopic,
+		AckDeadlineSeconds: int32(sub.Ack),
+	}
+	if sub.PushCfg.Pend != "" {
+		result.PushConfig = &amspb.PushConfig{
+			PushEndpoint: sub.PushCfg.Pend,
+			RetryPolicy:  &amspb.RetryPolicy{Type: sub.PushCfg.RetPol.PolicyType, Period: int32(sub.PushCfg.RetPol.Period)},
+		}
+	}
+	return &result
+}
+
+// ListTopics returns a page of the topics of a project
+func (srv *Server) ListTopics(ctx context.Context, req *amspb.ListTopicsRequest) (*amspb.ListTopicsResponse, error) {
+	sess, err := srv.authorize(ctx, "topics:list", req.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	userUUID := ""
+	if srv.cfg.ResAuth && !auth.IsServiceAdmin(sess.roles) && !auth.IsProjectAdmin(sess.roles) {
+		userUUID = sess.userUUID
+	}
+
+	results, err := topics.Find(sess.projectUUID, userUUID, "", req.PageToken, req.PageSize, srv.str)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid page token")
+	}
+
+	response := amspb.ListTopicsResponse{NextPageToken: results.NextPageToken, TotalSize: results.TotalSize}
+	for _, topic := range results.Topics {
+		response.Topics = append(response.Topics, exportTopic(topic))
+	}
+	return &response, nil
+}
+
+// GetTopic returns the details of a single topic
+func (srv *Server) GetTopic(ctx context.Context, req *amspb.GetTopicRequest) (*amspb.Topic, error) {
+	sess, err := srv.authorize(ctx, "topics:show", req.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := topics.Find(sess.projectUUID, "", req.Topic, "", 0, srv.str)
+	if err != nil || results.Empty() {
+		return nil, status.Error(codes.NotFound, "topic not found")
+	}
+	if !srv.perResourceAuthorized(sess, "topics", req.Topic) {
+		return nil, status.Error(codes.PermissionDenied, "access forbidden")
+	}
+	return exportTopic(results.One()), nil
+}
+
+// CreateTopic creates a new topic
+func (srv *Server) CreateTopic(ctx context.Context, req *amspb.CreateTopicRequest) (*amspb.Topic, error) {
+	sess, err := srv.authorize(ctx, "topics:create", req.Project)
+	if err != nil {
+		return nil, err
+	}
+	if !validName.MatchString(req.Topic) {
+		return nil, status.Error(codes.InvalidArgument, "invalid topic name")
+	}
+
+	partitions := req.Partitions
+	if partitions == 0 {
+		partitions = srv.cfg.DefaultPartitions
+	}
+	replicationFactor := int16(req.ReplicationFactor)
+	if replicationFactor == 0 {
+		replicationFactor = srv.cfg.DefaultReplicationFactor
+	}
+	if partitions < 1 || replicationFactor < 1 {
+		return nil, status.Error(codes.InvalidArgument, "invalid partitions or replication_factor")
+	}
+	// consumption reads a single partition per topic, so more partitions
+	// would silently strand messages
+	if partitions > 1 {
+		return nil, status.Error(codes.InvalidArgument, "only one partition per topic is supported")
+	}
+
+	if prj, pjErr := projects.Find(sess.projectUUID, "", srv.str); pjErr == nil && !prj.Empty() {
+		p := prj.One()
+		if p.MaxTopics > 0 && p.TopicsCount >= int64(p.MaxTopics) {
+			return nil, status.Error(codes.ResourceExhausted, "topics quota exceeded")
+		}
+	}
+
+	result, err := topics.CreateTopic(sess.projectUUID, req.Topic, partitions, replicationFactor, req.Idempotent, req.InheritProjectAcl, false, false, time.Now(), srv.str)
+	if err != nil {
+		if err.Error() == "exists" {
+			return nil, status.Error(codes.AlreadyExists, "topic already exists")
+		}
+		return nil, status.Error(codes.Internal, "backend error")
+	}
+
+	brk := brokers.ForProject(srv.pool, srv.str, sess.projectUUID)
+	if err := brk.CreateTopic(sess.projectUUID+"."+req.Topic, partitions, replicationFactor, 0); err != nil {
+		return nil, status.Error(codes.Internal, "broker error")
+	}
+
+	events.Emit(events.New("topic.created", req.Project, result.FullName))
+	return exportTopic(result), nil
+}
+
+// DeleteTopic removes a topic
+func (srv *Server) DeleteTopic(ctx context.Context, req *amspb.DeleteTopicRequest) (*amspb.Empty, error) {
+	sess, err := srv.authorize(ctx, "topics:delete", req.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := topics.RemoveTopic(sess.projectUUID, req.Topic, srv.str); err != nil {
+		if err.Error() == "not found" {
+			return nil, status.Error(codes.NotFound, "topic not found")
+		}
+		return nil, status.Error(codes.Internal, "backend error")
+	}
+
+	brk := brokers.ForProject(srv.pool, srv.str, sess.projectUUID)
+	if err := brk.DeleteTopic(sess.projectUUID + "." + req.Topic); err != nil {
+		return nil, status.Error(codes.Internal, "broker error")
+	}
+
+	events.Emit(events.New("topic.deleted", req.Project, req.Topic))
+	return &amspb.Empty{}, nil
+}
+
+// ListSubscriptions returns a page of the subscriptions of a project
+func (srv *Server) ListSubscriptions(ctx context.Context, req *amspb.ListSubscriptionsRequest) (*amspb.ListSubscriptionsResponse, error) {
+	sess, err := srv.authorize(ctx, "subscriptions:list", req.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	userUUID := ""
+	if srv.cfg.ResAuth && !auth.IsServiceAdmin(sess.roles) && !auth.IsProjectAdmin(sess.roles) {
+		userUUID = sess.userUUID
+	}
+
+	results, err := subscriptions.Find(sess.projectUUID, userUUID, "", req.PageToken, req.PageSize, srv.str)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid page token")
+	}
+
+	response := amspb.ListSubscriptionsResponse{NextPageToken: results.NextPageToken, TotalSize: results.TotalSize}
+	for _, sub := range results.Subscriptions {
+		response.Subscriptions = append(response.Subscriptions, exportSub(sub))
+	}
+	return &response, nil
+}
+
+// GetSubscription returns the details of a single subscription
+func (srv *Server) GetSubscription(ctx context.Context, req *amspb.GetSubscriptionRequest) (*amspb.Subscription, error) {
+	sess, err := srv.authorize(ctx, "subscriptions:show", req.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := subscriptions.Find(sess.projectUUID, "", req.Subscription, "", 0, srv.str)
+	if err != nil || results.Empty() {
+		return nil, status.Error(codes.NotFound, "subscription not found")
+	}
+	if !srv.perResourceAuthorized(sess, "subscriptions", req.Subscription) {
+		return nil, status.Error(codes.PermissionDenied, "access forbidden")
+	}
+	return exportSub(results.One()), nil
+}
+
+// CreateSubscription creates a new subscription
+func (srv *Server) CreateSubscription(ctx context.Context, req *amspb.CreateSubscriptionRequest) (*amspb.Subscription, error) {
+	sess, err := srv.authorize(ctx, "subscriptions:create", req.Project)
+	if err != nil {
+		return nil, err
+	}
+	if !validName.MatchString(req.Subscription) {
+		return nil, status.Error(codes.InvalidArgument, "invalid subscription name")
+	}
+
+	// the referenced topic must be in the same project
+	topicProject, topicName, err := names.ParseTopic(req.Topic)
+	if err != nil || topicProject != req.Project || !topics.HasTopic(sess.projectUUID, topicName, srv.str) {
+		return nil, status.Error(codes.NotFound, "topic not found")
+	}
+
+	// new subscriptions start at the current max offset of the topic
+	brk := brokers.ForProject(srv.pool, srv.str, sess.projectUUID)
+	offset := brk.GetMaxOffset(sess.projectUUID + "." + topicName)
+
+	pushEndpoint := ""
+	retPolicy := ""
+	retPeriod := 0
+	if req.PushConfig != nil {
+		pushEndpoint = req.PushConfig.PushEndpoint
+		if req.PushConfig.RetryPolicy != nil {
+			retPolicy = req.PushConfig.RetryPolicy.Type
+			retPeriod = int(req.PushConfig.RetryPolicy.Period)
+		}
+	}
+	if pushEndpoint != "" && retPolicy == "" {
+		retPolicy = "linear"
+		retPeriod = 3000
+	}
+
+	if prj, pjErr := projects.Find(sess.projectUUID, "", srv.str); pjErr == nil && !prj.Empty() {
+		p := prj.One()
+		if p.MaxSubscriptions > 0 && p.SubscriptionsCount >= int64(p.MaxSubscriptions) {
+			return nil, status.Error(codes.ResourceExhausted, "subscriptions quota exceeded")
+		}
+	}
+
+	result, err := subscriptions.CreateSub(sess.projectUUID, req.Subscription, topicName, pushEndpoint, offset,
+		int(req.AckDeadlineSeconds), retPolicy, retPeriod, 0, 0, time.Now(), srv.str)
+	if err != nil {
+		if err.Error() == "exists" {
+			return nil, status.Error(codes.AlreadyExists, "subscription already exists")
+		}
+		return nil, status.Error(codes.Internal, "backend error")
+	}
+
+	// push delivery only starts once the endpoint ownership has been
+	// verified through the rest api
+
+	events.Emit(events.New("subscription.created", req.Project, result.FullName))
+	return exportSub(result), nil
+}
+
+// DeleteSubscription removes a subscription
+func (srv *Server) DeleteSubscription(ctx context.Context, req *amspb.DeleteSubscriptionRequest) (*amspb.Empty, error) {
+	sess, err := srv.authorize(ctx, "subscriptions:delete", req.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := subscriptions.Find(sess.projectUUID, "", req.Subscription, "", 0, srv.str)
+	if err != nil || results.Empty() {
+		return nil, status.Error(codes.NotFound, "subscription not found")
+	}
+
+	if results.One().PushCfg.Pend != "" && srv.cfg.PushEnabled {
+		srv.deactivatePush(sess.projectUUID, req.Subscription, sess.requestID)
+	}
+
+	if err := subscriptions.RemoveSub(sess.projectUUID, req.Subscription, srv.str); err != nil {
+		return nil, status.Error(codes.Internal, "backend error")
+	}
+
+	events.Emit(events.New("subscription.deleted", req.Project, req.Subscription))
+	return &amspb.Empty{}, nil
+}
+
+// Publish publishes a list of messages to a topic
+func (srv *Server) Publish(ctx context.Context, req *amspb.PublishRequest) (*amspb.PublishResponse, error) {
+	sess, err := srv.authorize(ctx, "topics:publish", req.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := topics.Find(sess.projectUUID, "", req.Topic, "", 0, srv.str)
+	if err != nil || results.Empty() {
+		return nil, status.Error(codes.NotFound, "topic not found")
+	}
+	topic := results.One()
+	if !srv.perResourceAuthorized(sess, "topics", req.Topic) {
+		return nil, status.Error(codes.PermissionDenied, "access forbidden")
+	}
+	if len(req.Messages) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "message list is empty")
+	}
+	// the daily publish quotas of the project are enforced before
+	// anything reaches the broker
+	if prj, err := projects.Find(sess.projectUUID, "", srv.str); err == nil && !prj.Empty() {
+		p := prj.One()
+		if p.MaxDailyMessages > 0 || p.MaxDailyBytes > 0 {
+			usage, err := srv.str.GetDailyProjectUsage(time.Now().UTC().Format("2006-01-02"), sess.projectUUID)
+			if err != nil {
+				return nil, status.Error(codes.Internal, "backend error")
+			}
+			incomingBytes := int64(0)
+			for _, reqMsg := range req.Messages {
+				incomingBytes += int64(len(reqMsg.Data))
+			}
+			if p.MaxDailyMessages > 0 && usage.MsgCount+int64(len(req.Messages)) > p.MaxDailyMessages {
+				return nil, status.Error(codes.ResourceExhausted, "project has reached its daily messages quota")
+			}
+			if p.MaxDailyBytes > 0 && usage.BytesCount+incomingBytes > p.MaxDailyBytes {
+				return nil, status.Error(codes.ResourceExhausted, "project has reached its daily bytes quota")
+			}
+		}
+	}
+
+	brk := brokers.ForProject(srv.pool, srv.str, sess.projectUUID)
+	fullTopic := sess.projectUUID + "." + req.Topic
+
+	remoteAddr := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+
+	msgIDs := []string{}
+	totalBytes := int64(0)
+	for _, reqMsg := range req.Messages {
+		if len(reqMsg.Data) > maxMessageSize {
+			return nil, status.Error(codes.InvalidArgument, "message size too large")
+		}
+		// the broker payload keeps the json layout of the http api, with
+		// the message data base64 encoded
+		msg := messages.New(base64.StdEncoding.EncodeToString(reqMsg.Data))
+		msg.Attrs = reqMsg.Attributes
+		// the grpc api authenticates with api keys only
+		if srv.cfg.PublishIdentityAttributes {
+			stamped := []messages.Message{msg}
+			messages.StampIdentity(stamped, sess.userUUID, "key", remoteAddr)
+			msg = stamped[0]
+		}
+
+		published, err := publishMsg(brk, topic, fullTopic, msg)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "broker error")
+		}
+		msgIDs = append(msgIDs, published.ID)
+		totalBytes += int64(len(published.Data))
+	}
+
+	srv.str.IncrementTopicMsgNum(sess.projectUUID, req.Topic, int64(len(req.Messages)))
+	srv.str.IncrementTopicBytes(sess.projectUUID, req.Topic, totalBytes)
+	srv.str.IncrementDailyProjectUsage(time.Now().UTC().Format("2006-01-02"), sess.projectUUID, int64(len(req.Messages)), totalBytes)
+	srv.str.UpdateTopicLatestPublish(sess.projectUUID, req.Topic, time.Now().UTC().Format(time.RFC3339Nano))
+
+	return &amspb.PublishResponse{MessageIds: msgIDs}, nil
+}
+
+// Pull consumes messages from a subscription
+func (srv *Server) Pull(ctx context.Context, req *amspb.PullRequest) (*amspb.PullResponse, error) {
+	sess, err := srv.authorize(ctx, "subscriptions:pull", req.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := subscriptions.Find(sess.projectUUID, "", req.Subscription, "", 0, srv.str)
+	if err != nil || results.Empty() {
+		return nil, status.Error(codes.NotFound, "subscription not found")
+	}
+	if !srv.perResourceAuthorized(sess, "subscriptions", req.Subscription) {
+		return nil, status.Error(codes.PermissionDenied, "access forbidden")
+	}
+	sub := results.One()
+
+	maxMessages := req.MaxMessages
+	if maxMessages == 0 {
+		maxMessages = 1
+	}
+	if maxMessages < 0 || req.MaxBytes < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid max_messages or max_bytes")
+	}
+
+	brk := brokers.ForProject(srv.pool, srv.str, sess.projectUUID)
+	fullTopic := sub.ConsumeTopic()
+
+	msgs, err := brk.Consume(fullTopic, sub.Offset, true, maxMessages)
+	if err != nil {
+		if err.Error() == "offset is off" {
+			srv.str.UpdateSubOffset(sess.projectUUID, req.Subscription, brk.GetMinOffset(fullTopic))
+		}
+		return nil, status.Error(codes.Internal, "backend error")
+	}
+
+	// when max_bytes is set, stop before the message that would exceed it;
+	// the first message is always returned so consumers keep making progress
+	if req.MaxBytes > 0 {
+		size := int64(0)
+		for i, payload := range msgs {
+			size += int64(len(payload))
+			if i > 0 && size > req.MaxBytes {
+				msgs = msgs[:i]
+				break
+			}
+		}
+	}
+
+	response := amspb.PullResponse{}
+	for i, payload := range msgs {
+		msg, err := messages.LoadMsgJSON([]byte(payload))
+		if err != nil {
+			return nil, status.Error(codes.Internal, "backend error")
+		}
+		data, err := base64.StdEncoding.DecodeString(msg.Data)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "backend error")
+		}
+		ackID := names.AckID(sub.FullName, sub.Offset+int64(i))
+		response.ReceivedMessages = append(response.ReceivedMessages, &amspb.ReceivedMessage{
+			AckId:   ackID,
+			Message: &amspb.Message{Id: msg.ID, Data: data, Attributes: msg.Attrs, PublishTime: msg.PubTime},
+		})
+	}
+
+	if len(msgs) > 0 {
+		srv.str.UpdateSubPull(sess.projectUUID, req.Subscription, sub.Offset+int64(len(msgs)), time.Now().UTC().Format(time.RFC3339Nano))
+		srv.str.IncrementSubMsgNum(sess.projectUUID, req.Subscription, int64(len(msgs)))
+	}
+
+	return &response, nil
+}
+
+// StreamingPull streams messages of a subscription continuously while
+// the client sends back acks and flow control updates, keeping at most
+// max_outstanding_messages unacknowledged messages in flight
+func (srv *Server) StreamingPull(stream amspb.AMS_StreamingPullServer) error {
+	ctx := stream.Context()
+
+	// the first frame carries the subscription and the initial flow control
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	sess, err := srv.authorize(ctx, "subscriptions:pull", first.Project)
+	if err != nil {
+		return err
+	}
+	results, err := subscriptions.Find(sess.projectUUID, "", first.Subscription, "", 0, srv.str)
+	if err != nil || results.Empty() {
+		return status.Error(codes.NotFound, "subscription not found")
+	}
+	if !srv.perResourceAuthorized(sess, "subscriptions", first.Subscription) {
+		return status.Error(codes.PermissionDenied, "access forbidden")
+	}
+	sub := results.One()
+
+	// acked tracks the highest acknowledged offset, shared with the
+	// goroutine consuming the client frames
+	var flowLock sync.Mutex
+	acked := sub.Offset
+	maxOutstanding := first.MaxOutstandingMessages
+	if maxOutstanding <= 0 {
+		maxOutstanding = defaultOutstanding
+	}
+
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			flowLock.Lock()
+			if req.MaxOutstandingMessages > 0 {
+				maxOutstanding = req.MaxOutstandingMessages
+			}
+			for _, ackID := range req.AckIds {
+				if !strings.HasPrefix(ackID, sub.FullName+":") {
+					continue
+				}
+				offset, err := subscriptions.GetOffsetFromAckID(ackID)
+				if err != nil {
+					continue
+				}
+				if offset+1 > acked {
+					acked = offset + 1
+					srv.str.UpdateSubOffsetAck(sess.projectUUID, first.Subscription, offset+1, time.Now().UTC().Format(time.RFC3339Nano))
+				}
+			}
+			flowLock.Unlock()
+		}
+	}()
+
+	brk := brokers.ForProject(srv.pool, srv.str, sess.projectUUID)
+	fullTopic := sub.ConsumeTopic()
+	nextOffset := sub.Offset
+
+	for {
+		select {
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		flowLock.Lock()
+		window := maxOutstanding - (nextOffset - acked)
+		flowLock.Unlock()
+		if window <= 0 {
+			time.Sleep(streamPollInterval)
+			continue
+		}
+
+		msgs, err := brk.Consume(fullTopic, nextOffset, true, window)
+		if err != nil {
+			if err.Error() == "offset is off" {
+				nextOffset = brk.GetMinOffset(fullTopic)
+				srv.str.UpdateSubOffset(sess.projectUUID, first.Subscription, nextOffset)
+				continue
+			}
+			return status.Error(codes.Internal, "backend error")
+		}
+		if len(msgs) == 0 {
+			time.Sleep(streamPollInterval)
+			continue
+		}
+
+		response := amspb.StreamingPullResponse{}
+		for i, payload := range msgs {
+			msg, err := messages.LoadMsgJSON([]byte(payload))
+			if err != nil {
+				return status.Error(codes.Internal, "backend error")
+			}
+			data, err := base64.StdEncoding.DecodeString(msg.Data)
+			if err != nil {
+				return status.Error(codes.Internal, "backend error")
+			}
+			ackID := names.AckID(sub.FullName, nextOffset+int64(i))
+			response.ReceivedMessages = append(response.ReceivedMessages, &amspb.ReceivedMessage{
+				AckId:   ackID,
+				Message: &amspb.Message{Id: msg.ID, Data: data, Attributes: msg.Attrs, PublishTime: msg.PubTime},
+			})
+		}
+		if err := stream.Send(&response); err != nil {
+			return err
+		}
+
+		nextOffset += int64(len(msgs))
+		srv.str.UpdateSubPull(sess.projectUUID, first.Subscription, nextOffset, time.Now().UTC().Format(time.RFC3339Nano))
+		srv.str.IncrementSubMsgNum(sess.projectUUID, first.Subscription, int64(len(msgs)))
+	}
+}
+
+// Acknowledge acknowledges the consumption of messages
+func (srv *Server) Acknowledge(ctx context.Context, req *amspb.AcknowledgeRequest) (*amspb.Empty, error) {
+	sess, err := srv.authorize(ctx, "subscriptions:acknowledge", req.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := subscriptions.Find(sess.projectUUID, "", req.Subscription, "", 0, srv.str)
+	if err != nil || results.Empty() {
+		return nil, status.Error(codes.NotFound, "subscription not found")
+	}
+	if !srv.perResourceAuthorized(sess, "subscriptions", req.Subscription) {
+		return nil, status.Error(codes.PermissionDenied, "access forbidden")
+	}
+	sub := results.One()
+
+	if len(req.AckIds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid ack id")
+	}
+	for _, ackID := range req.AckIds {
+		if !strings.HasPrefix(ackID, sub.FullName+":") {
+			return nil, status.Error(codes.InvalidArgument, "invalid ack id")
+		}
+	}
+
+	maxAckID, err := subscriptions.GetMaxAckID(req.AckIds)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid ack id")
+	}
+	offset, _ := subscriptions.GetOffsetFromAckID(maxAckID)
+
+	// the ack must arrive within the subscription ack deadline
+	if sub.PendingAck == "" {
+		return nil, status.Error(codes.DeadlineExceeded, "ack timeout")
+	}
+	// the persisted deadline, set at pull time and pushed forward by
+	// extend calls, decides how long the lease is held
+	deadline, ok := sub.AckDeadlineTime()
+	if !ok || !time.Now().Before(deadline) {
+		return nil, status.Error(codes.DeadlineExceeded, "ack timeout")
+	}
+
+	if err := srv.str.UpdateSubOffsetAck(sess.projectUUID, req.Subscription, offset+1, time.Now().UTC().Format(time.RFC3339Nano)); err != nil {
+		return nil, status.Error(codes.DeadlineExceeded, "ack timeout")
+	}
+	return &amspb.Empty{}, nil
+}
+
+// push activation helpers delegate to the configured push provider
+func (srv *Server) activatePush(projectUUID string, subName string, requestID string) {
+	srv.prv.ActivateSubscription(projectUUID, subName, requestID)
+}
+
+func (srv *Server) deactivatePush(projectUUID string, subName string, requestID string) {
+	srv.prv.DeactivateSubscription(projectUUID, subName, requestID)
+}
+
+// publishMsg sends a message through the producer matching the topic settings
+func publishMsg(brk brokers.Broker, topic topics.Topic, fullTopic string, msg messages.Message) (messages.Message, error) {
+	if topic.Idempotent {
+		return brk.PublishIdempotent(fullTopic, msg)
+	}
+	return brk.Publish(fullTopic, msg)
+}
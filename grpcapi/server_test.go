@@ -0,0 +1,196 @@
+package grpcapi
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/config"
+	"github.com/kaggis/argo-messaging/grpcapi/amspb"
+	"github.com/kaggis/argo-messaging/push"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// newTestServer creates a fully mocked grpc server against fixture data
+func newTestServer() *Server {
+	cfg := config.NewAPICfg()
+	cfg.LoadStrJSON(`{"kafka_clusters": {"main": ["localhost:9092"]}, "per_resource_auth": true}`)
+	str := stores.NewMockStore("", "")
+	brk := brokers.NewMockBroker()
+	pool := brokers.NewMockPool(brk)
+	sender := push.NewMockSender()
+	mgr := push.NewManager(pool, str, sender)
+	return NewServer(cfg, pool, str, push.NewProvider(cfg, mgr, push.NewMockClient()))
+}
+
+// authCtx returns a context carrying the given token as rpc metadata
+func authCtx(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", token))
+}
+
+type GrpcTestSuite struct {
+	suite.Suite
+}
+
+func (suite *GrpcTestSuite) TestAuth() {
+	srv := newTestServer()
+
+	// no token
+	_, err := srv.ListTopics(context.Background(), &amspb.ListTopicsRequest{Project: "ARGO"})
+	suite.Equal(codes.Unauthenticated, status.Code(err))
+
+	// valid token without the required role
+	_, err = srv.CreateTopic(authCtx("S3CR3T1"), &amspb.CreateTopicRequest{Project: "ARGO", Topic: "topicGrpc"})
+	suite.Equal(codes.PermissionDenied, status.Code(err))
+
+	// unknown project
+	_, err = srv.ListTopics(authCtx("S3CR3T1"), &amspb.ListTopicsRequest{Project: "NOSUCH"})
+	suite.Equal(codes.NotFound, status.Code(err))
+}
+
+func (suite *GrpcTestSuite) TestTopicLifecycle() {
+	srv := newTestServer()
+
+	topic, err := srv.CreateTopic(authCtx("S3CR3T7"), &amspb.CreateTopicRequest{Project: "ARGO", Topic: "topicGrpc"})
+	suite.NoError(err)
+	suite.Equal("projects/ARGO/topics/topicGrpc", topic.Name)
+
+	_, err = srv.CreateTopic(authCtx("S3CR3T7"), &amspb.CreateTopicRequest{Project: "ARGO", Topic: "topicGrpc"})
+	suite.Equal(codes.AlreadyExists, status.Code(err))
+
+	_, err = srv.DeleteTopic(authCtx("S3CR3T7"), &amspb.DeleteTopicRequest{Project: "ARGO", Topic: "topicGrpc"})
+	suite.NoError(err)
+
+	_, err = srv.GetTopic(authCtx("S3CR3T7"), &amspb.GetTopicRequest{Project: "ARGO", Topic: "topicGrpc"})
+	suite.Equal(codes.NotFound, status.Code(err))
+}
+
+func (suite *GrpcTestSuite) TestPublishPullAck() {
+	srv := newTestServer()
+
+	published, err := srv.Publish(authCtx("S3CR3T1"), &amspb.PublishRequest{
+		Project: "ARGO", Topic: "topic1",
+		Messages: []*amspb.Message{{Data: []byte("grpc payload")}},
+	})
+	suite.NoError(err)
+	// topic1 is seeded with four messages so the new one lands on offset 4
+	suite.Equal([]string{"4"}, published.MessageIds)
+
+	pulled, err := srv.Pull(authCtx("S3CR3T1"), &amspb.PullRequest{Project: "ARGO", Subscription: "sub1", MaxMessages: 1})
+	suite.NoError(err)
+	suite.Equal(1, len(pulled.ReceivedMessages))
+	suite.Equal("projects/ARGO/subscriptions/sub1:0", pulled.ReceivedMessages[0].AckId)
+
+	// a consumer outside the acl of sub1 cannot ack on its behalf
+	_, err = srv.Acknowledge(authCtx("S3CR3T4"), &amspb.AcknowledgeRequest{
+		Project: "ARGO", Subscription: "sub1", AckIds: []string{"projects/ARGO/subscriptions/sub1:0"},
+	})
+	suite.Equal(codes.PermissionDenied, status.Code(err))
+
+	_, err = srv.Acknowledge(authCtx("S3CR3T1"), &amspb.AcknowledgeRequest{
+		Project: "ARGO", Subscription: "sub1", AckIds: []string{"projects/ARGO/subscriptions/sub1:0"},
+	})
+	suite.NoError(err)
+
+	// the next pull picks up after the acknowledged offset
+	pulled, err = srv.Pull(authCtx("S3CR3T1"), &amspb.PullRequest{Project: "ARGO", Subscription: "sub1", MaxMessages: 1})
+	suite.NoError(err)
+	suite.Equal("projects/ARGO/subscriptions/sub1:1", pulled.ReceivedMessages[0].AckId)
+
+	// stale ack ids are rejected
+	_, err = srv.Acknowledge(authCtx("S3CR3T1"), &amspb.AcknowledgeRequest{
+		Project: "ARGO", Subscription: "sub1", AckIds: []string{"projects/ARGO/subscriptions/sub2:0"},
+	})
+	suite.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+// mockPullStream implements the streaming pull server stream over channels
+type mockPullStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	reqs  chan *amspb.StreamingPullRequest
+	resps chan *amspb.StreamingPullResponse
+}
+
+func (m *mockPullStream) Context() context.Context { return m.ctx }
+
+func (m *mockPullStream) Send(resp *amspb.StreamingPullResponse) error {
+	m.resps <- resp
+	return nil
+}
+
+func (m *mockPullStream) Recv() (*amspb.StreamingPullRequest, error) {
+	req, ok := <-m.reqs
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func (suite *GrpcTestSuite) TestStreamingPull() {
+	srv := newTestServer()
+	stream := &mockPullStream{
+		ctx:   authCtx("S3CR3T1"),
+		reqs:  make(chan *amspb.StreamingPullRequest, 4),
+		resps: make(chan *amspb.StreamingPullResponse, 4),
+	}
+	stream.reqs <- &amspb.StreamingPullRequest{Project: "ARGO", Subscription: "sub1", MaxOutstandingMessages: 2}
+
+	done := make(chan error, 1)
+	go func() { done <- srv.StreamingPull(stream) }()
+
+	// the flow control window caps the first delivery to two messages
+	resp := <-stream.resps
+	suite.Equal(2, len(resp.ReceivedMessages))
+	suite.Equal("projects/ARGO/subscriptions/sub1:0", resp.ReceivedMessages[0].AckId)
+	suite.Equal([]byte("mock_data_0"), resp.ReceivedMessages[0].Message.Data)
+
+	// acking opens the window for the remaining seeded messages
+	stream.reqs <- &amspb.StreamingPullRequest{AckIds: []string{resp.ReceivedMessages[1].AckId}}
+	resp = <-stream.resps
+	suite.Equal(2, len(resp.ReceivedMessages))
+	suite.Equal("projects/ARGO/subscriptions/sub1:2", resp.ReceivedMessages[0].AckId)
+
+	// closing the client side ends the stream cleanly
+	close(stream.reqs)
+	suite.NoError(<-done)
+}
+
+func (suite *GrpcTestSuite) TestStreamingPullAuth() {
+	srv := newTestServer()
+	stream := &mockPullStream{
+		ctx:   authCtx("S3CR3T4"),
+		reqs:  make(chan *amspb.StreamingPullRequest, 1),
+		resps: make(chan *amspb.StreamingPullResponse, 1),
+	}
+	stream.reqs <- &amspb.StreamingPullRequest{Project: "ARGO", Subscription: "sub1"}
+	suite.Equal(codes.PermissionDenied, status.Code(srv.StreamingPull(stream)))
+}
+
+func (suite *GrpcTestSuite) TestSubscriptionLifecycle() {
+	srv := newTestServer()
+
+	sub, err := srv.CreateSubscription(authCtx("S3CR3T7"), &amspb.CreateSubscriptionRequest{
+		Project: "ARGO", Subscription: "subGrpc", Topic: "projects/ARGO/topics/topic1",
+	})
+	suite.NoError(err)
+	suite.Equal("projects/ARGO/subscriptions/subGrpc", sub.Name)
+
+	list, err := srv.ListSubscriptions(authCtx("S3CR3T7"), &amspb.ListSubscriptionsRequest{Project: "ARGO"})
+	suite.NoError(err)
+	suite.Equal(int64(5), list.TotalSize)
+
+	_, err = srv.DeleteSubscription(authCtx("S3CR3T7"), &amspb.DeleteSubscriptionRequest{Project: "ARGO", Subscription: "subGrpc"})
+	suite.NoError(err)
+}
+
+func TestGrpcTestSuite(t *testing.T) {
+	suite.Run(t, new(GrpcTestSuite))
+}
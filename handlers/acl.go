@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/kaggis/argo-messaging/auth"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// ACLResponse holds the usernames authorized to use a resource, along
+// with the optional expiry timestamp of each temporary grant
+type ACLResponse struct {
+	AuthUsers   []string          `json:"authorized_users"`
+	Expirations map[string]string `json:"expirations,omitempty"`
+}
+
+// isPrivileged checks if the request user holds an admin role
+func isPrivileged(r *http.Request) bool {
+	roles := getRoles(r)
+	return auth.IsServiceAdmin(roles) || auth.IsProjectAdmin(roles)
+}
+
+// aclRepresentation returns the acl of a resource with the stored user
+// uuids resolved to usernames
+func aclRepresentation(str stores.Store, projectUUID string, resourceType string, resourceName string) (ACLResponse, error) {
+	acl, err := str.QueryACL(projectUUID, resourceType, resourceName)
+	if err != nil {
+		return ACLResponse{}, err
+	}
+
+	names := []string{}
+	var expirations map[string]string
+	for _, userUUID := range acl.ACL {
+		users, err := str.QueryUsers("", userUUID, "")
+		if err == nil && len(users) > 0 {
+			names = append(names, users[0].Name)
+			if expiry, ok := acl.Expires[userUUID]; ok {
+				if expirations == nil {
+					expirations = make(map[string]string)
+				}
+				expirations[users[0].Name] = expiry
+			}
+		}
+	}
+	return ACLResponse{AuthUsers: names, Expirations: expirations}, nil
+}
+
+// respondACL writes the acl of a resource resolving user uuids to names
+func respondACL(w http.ResponseWriter, r *http.Request, resourceType string, resourceName string) {
+	str := getStr(r)
+	projectUUID := getProjectUUID(r)
+
+	exists := str.HasTopic(projectUUID, resourceName)
+	if resourceType == "subscriptions" {
+		exists = str.HasSub(projectUUID, resourceName)
+	}
+	if !exists {
+		respondErr(w, apiErrorNotFound(resourceLabel(resourceType)))
+		return
+	}
+
+	acl, err := aclRepresentation(str, projectUUID, resourceType, resourceName)
+	if err != nil {
+		respondErr(w, apiErrorNotFound(resourceLabel(resourceType)))
+		return
+	}
+
+	output, _ := json.MarshalIndent(acl, "", "   ")
+	respondOK(w, output)
+}
+
+// modACL replaces the acl of a resource after validating the given usernames
+func modACL(w http.ResponseWriter, r *http.Request, resourceType string, resourceName string) {
+	str := getStr(r)
+	projectUUID := getProjectUUID(r)
+
+	exists := str.HasTopic(projectUUID, resourceName)
+	if resourceType == "subscriptions" {
+		exists = str.HasSub(projectUUID, resourceName)
+	}
+	if !exists {
+		respondErr(w, apiErrorNotFound(resourceLabel(resourceType)))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	// a json patch body expresses atomic add/remove operations against
+	// the current acl instead of replacing it wholesale
+	if isJSONPatch(r) {
+		current, err := aclRepresentation(str, projectUUID, resourceType, resourceName)
+		if err != nil {
+			respondErr(w, apiErrorNotFound(resourceLabel(resourceType)))
+			return
+		}
+		currentDoc, _ := json.Marshal(current)
+		if body, err = applyJSONPatch(currentDoc, body); err != nil {
+			respondErr(w, apiErrorInvalidArgument(err.Error()))
+			return
+		}
+	}
+
+	postBody := ACLResponse{}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	// all given users must be members of the project
+	found, missing := str.HasUsers(projectUUID, postBody.AuthUsers)
+	if !found {
+		respondErr(w, apiErrorInvalidArgument("User(s): "+joinNames(missing)+" do not exist"))
+		return
+	}
+
+	// expirations are keyed by username and must refer to listed users
+	for name, expiry := range postBody.Expirations {
+		if _, err := time.Parse(time.RFC3339, expiry); err != nil {
+			respondErr(w, apiErrorInvalidArgument("Invalid expiration for user: "+name))
+			return
+		}
+		listed := false
+		for _, authName := range postBody.AuthUsers {
+			if authName == name {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			respondErr(w, apiErrorInvalidArgument("User: "+name+" has an expiration but is not in authorized_users"))
+			return
+		}
+	}
+
+	// store the acl as user uuids
+	uuids := []string{}
+	var expires map[string]string
+	for _, name := range postBody.AuthUsers {
+		users, err := str.QueryUsers("", "", name)
+		if err != nil || len(users) == 0 {
+			respondErr(w, apiErrorInternal("Backend error"))
+			return
+		}
+		uuids = append(uuids, users[0].UUID)
+		if expiry, ok := postBody.Expirations[name]; ok {
+			if expires == nil {
+				expires = make(map[string]string)
+			}
+			expires[users[0].UUID] = expiry
+		}
+	}
+
+	if err := str.ModACL(projectUUID, resourceType, resourceName, uuids, expires); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	respondOK(w, []byte(""))
+}
+
+// resourceLabel maps a resource type to the label used in error messages
+func resourceLabel(resourceType string) string {
+	if resourceType == "subscriptions" {
+		return "Subscription"
+	}
+	return "Topic"
+}
+
+// joinNames joins a list of names for error message output
+func joinNames(names []string) string {
+	result := ""
+	for i, name := range names {
+		if i > 0 {
+			result += ", "
+		}
+		result += name
+	}
+	return result
+}
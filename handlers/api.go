@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/config"
+	"github.com/kaggis/argo-messaging/push"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// API holds the router of the service
+type API struct {
+	Router  *mux.Router
+	limiter *priorityLimiter
+}
+
+// APIRoute holds the details of a route, used to populate the router
+type APIRoute struct {
+	Name    string
+	Method  string
+	Path    string
+	Auth    bool
+	Handler http.HandlerFunc
+}
+
+// Routes is the routing table of the api. Action routes (:action suffix)
+// must precede the plain routes of the same resource so that they match first
+var Routes = []APIRoute{
+	{"projects:assignCluster", "POST", "/projects/{project:[a-zA-Z0-9_-]+}:assignCluster", true, ProjectAssignCluster},
+	{"projects:purge", "POST", "/projects/{project:[a-zA-Z0-9_-]+}:purge", true, ProjectPurge},
+	{"projects:metrics", "GET", "/projects/{project:[a-zA-Z0-9_-]+}:metrics", true, ProjectMetrics},
+	{"projects:quotas", "GET", "/projects/{project:[a-zA-Z0-9_-]+}:quotas", true, ProjectQuotas},
+	{"projects:modifyQuotas", "POST", "/projects/{project:[a-zA-Z0-9_-]+}:modifyQuotas", true, ProjectModifyQuotas},
+	{"projects:list", "GET", "/projects", true, ProjectListAll},
+	{"projects:show", "GET", "/projects/{project:[a-zA-Z0-9_-]+}", true, ProjectListOne},
+	{"projects:create", "POST", "/projects/{project:[a-zA-Z0-9_-]+}", true, ProjectCreate},
+	{"projects:update", "PUT", "/projects/{project:[a-zA-Z0-9_-]+}", true, ProjectUpdate},
+	{"projects:delete", "DELETE", "/projects/{project:[a-zA-Z0-9_-]+}", true, ProjectDelete},
+	{"topics:publish", "POST", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}:publish", true, TopicPublish},
+	{"topics:diagnose", "GET", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}:diagnose", true, TopicDiagnose},
+	{"topics:metrics", "GET", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}:metrics", true, TopicMetrics},
+	{"topics:acl", "GET", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}:acl", true, TopicACL},
+	{"topics:modifyAcl", "POST", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}:modifyAcl", true, TopicModACL},
+	{"topics:attachSchema", "POST", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}:attachSchema", true, TopicAttachSchema},
+	{"topics:modifyLabels", "POST", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}:modifyLabels", true, TopicModLabels},
+	{"topics:routingRules", "GET", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}:routingRules", true, TopicRoutingRules},
+	{"topics:modifyRoutingRules", "POST", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}:modifyRoutingRules", true, TopicModRoutingRules},
+	{"topics:batchCreate", "POST", "/projects/{project}/topics:batchCreate", true, TopicBatchCreate},
+	{"topics:batchDelete", "POST", "/projects/{project}/topics:batchDelete", true, TopicBatchDelete},
+	{"topics:listSubscriptions", "GET", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}/subscriptions", true, TopicListSubscriptions},
+	{"topics:listKeys", "GET", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}/keys", true, TopicKeyListAll},
+	{"topics:createKey", "POST", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}/keys/{keyName:[a-zA-Z0-9_-]+}", true, TopicKeyCreate},
+	{"topics:revokeKey", "DELETE", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}/keys/{keyName:[a-zA-Z0-9_-]+}", true, TopicKeyDelete},
+	{"topics:list", "GET", "/projects/{project}/topics", true, TopicListAll},
+	{"topics:show", "GET", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}", true, TopicListOne},
+	{"topics:create", "PUT", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}", true, TopicCreate},
+	{"topics:delete", "DELETE", "/projects/{project}/topics/{topic:[a-zA-Z0-9_-]+}", true, TopicDelete},
+	{"schemas:list", "GET", "/projects/{project}/schemas", true, SchemaListAll},
+	{"schemas:show", "GET", "/projects/{project}/schemas/{schema:[a-zA-Z0-9_-]+}", true, SchemaListOne},
+	{"schemas:create", "PUT", "/projects/{project}/schemas/{schema:[a-zA-Z0-9_-]+}", true, SchemaCreate},
+	{"schemas:delete", "DELETE", "/projects/{project}/schemas/{schema:[a-zA-Z0-9_-]+}", true, SchemaDelete},
+	{"members:list", "GET", "/projects/{project}/members", true, MemberListAll},
+	{"members:show", "GET", "/projects/{project}/members/{user:[a-zA-Z0-9_.-]+}", true, MemberListOne},
+	{"members:create", "POST", "/projects/{project}/members/{user:[a-zA-Z0-9_.-]+}", true, MemberCreate},
+	{"members:modifyRoles", "POST", "/projects/{project}/members/{user:[a-zA-Z0-9_.-]+}:modifyRoles", true, MemberModifyRoles},
+	{"members:remove", "POST", "/projects/{project}/members/{user:[a-zA-Z0-9_.-]+}:remove", true, MemberRemove},
+	{"serviceAccounts:list", "GET", "/projects/{project}/serviceAccounts", true, ServiceAccountListAll},
+	{"serviceAccounts:show", "GET", "/projects/{project}/serviceAccounts/{serviceAccount:[a-zA-Z0-9_-]+}", true, ServiceAccountListOne},
+	{"serviceAccounts:create", "PUT", "/projects/{project}/serviceAccounts/{serviceAccount:[a-zA-Z0-9_-]+}", true, ServiceAccountCreate},
+	{"serviceAccounts:update", "POST", "/projects/{project}/serviceAccounts/{serviceAccount:[a-zA-Z0-9_-]+}", true, ServiceAccountUpdate},
+	{"serviceAccounts:delete", "DELETE", "/projects/{project}/serviceAccounts/{serviceAccount:[a-zA-Z0-9_-]+}", true, ServiceAccountDelete},
+	{"subscriptions:pull", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:pull", true, SubPull},
+	{"subscriptions:cancelPull", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:cancelPull", true, SubCancelPull},
+	{"subscriptions:pullSessions", "GET", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:pullSessions", true, SubPullSessions},
+	{"subscriptions:acknowledge", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:acknowledge", true, SubAck},
+	{"subscriptions:extend", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:extend", true, SubExtendAck},
+	{"subscriptions:seek", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:seek", true, SubSeek},
+	{"subscriptions:modifyPushConfig", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:modifyPushConfig", true, SubModPush},
+	{"subscriptions:pushErrors", "GET", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:pushErrors", true, SubPushErrors},
+	{"subscriptions:pausePush", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:pausePush", true, SubPausePush},
+	{"subscriptions:resumePush", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:resumePush", true, SubResumePush},
+	{"subscriptions:verifyPushEndpoint", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:verifyPushEndpoint", true, SubVerifyPushEndpoint},
+	{"subscriptions:modifyAckDeadline", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:modifyAckDeadline", true, SubModAck},
+	{"subscriptions:modifyAckDedup", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:modifyAckDedup", true, SubModAckDedup},
+	{"subscriptions:metrics", "GET", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:metrics", true, SubMetrics},
+	{"subscriptions:diagnose", "GET", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:diagnose", true, SubDiagnose},
+	{"subscriptions:stream", "GET", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:stream", true, SubStream},
+	{"subscriptions:sse", "GET", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:sse", true, SubSSE},
+	{"subscriptions:acl", "GET", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:acl", true, SubACL},
+	{"subscriptions:modifyLabels", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:modifyLabels", true, SubModLabels},
+	{"subscriptions:modifyAcl", "POST", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}:modifyAcl", true, SubModACL},
+	{"subscriptions:list", "GET", "/projects/{project}/subscriptions", true, SubListAll},
+	{"subscriptions:show", "GET", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}", true, SubListOne},
+	{"subscriptions:create", "PUT", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}", true, SubCreate},
+	{"subscriptions:delete", "DELETE", "/projects/{project}/subscriptions/{subscription:[a-zA-Z0-9_-]+}", true, SubDelete},
+	{"users:refreshToken", "POST", "/users/{user:[a-zA-Z0-9_.-]+}:refreshToken", true, UserRefreshToken},
+	{"users:rotateToken", "POST", "/users/{user:[a-zA-Z0-9_.-]+}:rotateToken", true, UserRotateToken},
+	{"users:listTokens", "GET", "/users/{user:[a-zA-Z0-9_.-]+}/tokens", true, UserTokenListAll},
+	{"users:createToken", "POST", "/users/{user:[a-zA-Z0-9_.-]+}/tokens/{tokenName:[a-zA-Z0-9_-]+}", true, UserTokenCreate},
+	{"users:revokeToken", "DELETE", "/users/{user:[a-zA-Z0-9_.-]+}/tokens/{tokenName:[a-zA-Z0-9_-]+}", true, UserTokenDelete},
+	{"roles:list", "GET", "/roles", true, RoleListAll},
+	{"roles:show", "GET", "/roles/{role:[a-zA-Z0-9_-]+}", true, RoleListOne},
+	{"roles:create", "POST", "/roles/{role:[a-zA-Z0-9_-]+}", true, RoleCreate},
+	{"roles:update", "PUT", "/roles/{role:[a-zA-Z0-9_-]+}", true, RoleUpdate},
+	{"roles:delete", "DELETE", "/roles/{role:[a-zA-Z0-9_-]+}", true, RoleDelete},
+	{"users:list", "GET", "/users", true, UserListAll},
+	{"users:show", "GET", "/users/{user:[a-zA-Z0-9_.-]+}", true, UserListOne},
+	{"users:create", "POST", "/users/{user:[a-zA-Z0-9_.-]+}", true, UserCreate},
+	{"users:import", "POST", "/users:import", true, UserBulkImport},
+	{"users:suspend", "POST", "/users/{user:[a-zA-Z0-9_.-]+}:suspend", true, UserSuspend},
+	{"users:activate", "POST", "/users/{user:[a-zA-Z0-9_.-]+}:activate", true, UserActivate},
+	{"users:update", "PUT", "/users/{user:[a-zA-Z0-9_.-]+}", true, UserUpdate},
+	{"users:delete", "DELETE", "/users/{user:[a-zA-Z0-9_.-]+}", true, UserDelete},
+	{"operations:cancel", "POST", "/operations/{operation:[a-zA-Z0-9_-]+}:cancel", true, OperationCancel},
+	{"operations:list", "GET", "/operations", true, OperationList},
+	{"operations:show", "GET", "/operations/{operation:[a-zA-Z0-9_-]+}", true, OperationShow},
+	{"events:stream", "GET", "/events:stream", true, EventStream},
+	{"metrics:usageReport", "GET", "/metrics:usageReport", true, OpsUsageReport},
+	{"metrics:alertRules", "GET", "/metrics:alertRules", true, OpsAlertRules},
+	{"metrics:show", "GET", "/metrics", true, OpsMetrics},
+	{"logs:levels", "GET", "/logs:levels", true, LogLevelsShow},
+	{"logs:modifyLevels", "POST", "/logs:modifyLevels", true, LogLevelsModify},
+	{"config:show", "GET", "/config", true, ConfigShow},
+	{"admin:overview", "GET", "/admin/overview", true, OpsOverview},
+	{"auditLogs:list", "GET", "/auditlogs", true, AuditLogListAll},
+	{"limits:show", "GET", "/limits", false, LimitsShow},
+	{"status:history", "GET", "/status/history", true, OpsStatusHistory},
+	{"status:show", "GET", "/status", false, OpsStatus},
+}
+
+// ScimRoutes is the routing table of the scim 2.0 provisioning api,
+// mounted under /scim/v2 for the central idm
+var ScimRoutes = []APIRoute{
+	{"scim:listUsers", "GET", "/Users", true, ScimUserList},
+	{"scim:createUser", "POST", "/Users", true, ScimUserCreate},
+	{"scim:showUser", "GET", "/Users/{user:[a-zA-Z0-9_.-]+}", true, ScimUserGet},
+	{"scim:replaceUser", "PUT", "/Users/{user:[a-zA-Z0-9_.-]+}", true, ScimUserReplace},
+	{"scim:deleteUser", "DELETE", "/Users/{user:[a-zA-Z0-9_.-]+}", true, ScimUserDelete},
+	{"scim:listGroups", "GET", "/Groups", true, ScimGroupList},
+	{"scim:showGroup", "GET", "/Groups/{group:[a-zA-Z0-9_:.-]+}", true, ScimGroupGet},
+	{"scim:patchGroup", "PATCH", "/Groups/{group:[a-zA-Z0-9_:.-]+}", true, ScimGroupPatch},
+}
+
+// NewRouting creates a new router based on the service routing table
+func NewRouting(cfg *config.APICfg, pool brokers.Provider, str stores.Store, prv push.Provider) *API {
+	api := API{Router: mux.NewRouter().StrictSlash(false)}
+	api.limiter = newPriorityLimiter(cfg.DataPlaneMaxConcurrent, cfg.ControlPlaneMaxConcurrent)
+	v1 := api.Router.PathPrefix("/v1").Subrouter()
+
+	for _, route := range Routes {
+		handler := route.Handler
+		if route.Auth {
+			handler = WrapAuth(handler, route.Name)
+		}
+		handler = WrapConfig(handler, cfg, pool, str, prv)
+		handler = WrapPriority(handler, route.Name, api.limiter)
+		handler = WrapDeprecation(handler, route.Name)
+		v1.Path(route.Path).Methods(route.Method).Name(route.Name).Handler(WrapLog(handler, route.Name))
+	}
+
+	// requests arriving through configured balancers carry the client
+	// address in forwarded headers
+	if len(cfg.TrustedProxies) > 0 {
+		api.Router.Use(WrapProxyHeaders(parseTrustedProxies(cfg.TrustedProxies)))
+	}
+
+	// browser dashboards call the api cross origin once their origin is
+	// on the allow list; without configured origins no cors headers are
+	// emitted and preflights find no route
+	if len(cfg.CORSAllowedOrigins) > 0 {
+		api.Router.Use(WrapCORS(cfg))
+		api.Router.PathPrefix("/").Methods(http.MethodOptions).Handler(CORSPreflight(cfg))
+	}
+
+	// the kubernetes probes live outside the versioned api, need no
+	// credentials and skip the access log so they do not flood it
+	api.Router.Path("/healthz").Methods(http.MethodGet).Name("healthz").
+		Handler(WrapConfig(Healthz, cfg, pool, str, prv))
+	api.Router.Path("/readyz").Methods(http.MethodGet).Name("readyz").
+		Handler(WrapConfig(Readyz, cfg, pool, str, prv))
+
+	scim := api.Router.PathPrefix("/scim/v2").Subrouter()
+	for _, route := range ScimRoutes {
+		handler := WrapAuth(route.Handler, route.Name)
+		handler = WrapConfig(handler, cfg, pool, str, prv)
+		handler = WrapPriority(handler, route.Name, api.limiter)
+		scim.Path(route.Path).Methods(route.Method).Name(route.Name).Handler(WrapLog(handler, route.Name))
+	}
+
+	return &api
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// auditLogEntry is one recorded mutating api call as served by the api
+type auditLogEntry struct {
+	Timestamp  string `json:"timestamp"`
+	UserUUID   string `json:"user_uuid"`
+	AuthMethod string `json:"auth_method"`
+	Action     string `json:"action"`
+	Project    string `json:"project,omitempty"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	StatusCode int    `json:"status_code"`
+}
+
+// auditLogsResponse holds the audit log entries of a query
+type auditLogsResponse struct {
+	AuditLogs []auditLogEntry `json:"auditLogs"`
+}
+
+// AuditLogListAll (GET) returns the recorded mutating api calls, newest
+// first, filtered by project, user, action and time range
+func AuditLogListAll(w http.ResponseWriter, r *http.Request) {
+	str := getStr(r)
+	query := r.URL.Query()
+
+	projectUUID := ""
+	if name := query.Get("project"); name != "" {
+		qProjects, err := str.QueryProjects("", name)
+		if err != nil || len(qProjects) == 0 {
+			respondErr(w, apiErrorNotFound("ProjectUUID"))
+			return
+		}
+		projectUUID = qProjects[0].UUID
+	}
+	for _, key := range []string{"since", "until"} {
+		if raw := query.Get(key); raw != "" {
+			if _, err := time.Parse(time.RFC3339, raw); err != nil {
+				respondErr(w, apiErrorInvalidArgument("Time is not in valid Zulu format."))
+				return
+			}
+		}
+	}
+
+	qLogs, err := str.QueryAuditLogs(projectUUID, query.Get("user"), query.Get("action"),
+		query.Get("since"), query.Get("until"))
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	// render the project uuids back to names
+	projectNames := map[string]string{}
+	if qProjects, err := str.QueryProjects("", ""); err == nil {
+		for _, project := range qProjects {
+			projectNames[project.UUID] = project.Name
+		}
+	}
+	response := auditLogsResponse{AuditLogs: []auditLogEntry{}}
+	for _, entry := range qLogs {
+		response.AuditLogs = append(response.AuditLogs, auditLogEntry{
+			Timestamp:  entry.Timestamp,
+			UserUUID:   entry.UserUUID,
+			AuthMethod: entry.AuthMethod,
+			Action:     entry.Action,
+			Project:    projectNames[entry.ProjectUUID],
+			Method:     entry.Method,
+			Path:       entry.Path,
+			StatusCode: entry.StatusCode,
+		})
+	}
+	output, _ := json.MarshalIndent(response, "", "   ")
+	respondOK(w, output)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/config"
+)
+
+// corsOriginAllowed matches the origin of a browser request against the
+// configured allow list; a "*" entry admits every origin
+func corsOriginAllowed(origin string, allowed []string) bool {
+	for _, entry := range allowed {
+		if entry == "*" || strings.EqualFold(entry, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// WrapCORS stamps the cross origin response headers on requests arriving
+// from an allowed origin; requests without an Origin header pass through
+// untouched
+func WrapCORS(cfg *config.APICfg) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" && corsOriginAllowed(origin, cfg.CORSAllowedOrigins) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Expose-Headers", "X-Request-Id")
+				w.Header().Add("Vary", "Origin")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CORSPreflight answers the OPTIONS preflight browsers send before a
+// cross origin call; origins outside the allow list get an empty answer,
+// which the browser turns into a blocked request
+func CORSPreflight(cfg *config.APICfg) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !corsOriginAllowed(origin, cfg.CORSAllowedOrigins) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(cfg.CORSAllowedHeaders, ", "))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cfg.CORSMaxAgeSec))
+		w.Header().Add("Vary", "Origin")
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// dedupEntry remembers the id of a published message and when it falls
+// out of its dedup window; the deadline is kept per entry because the
+// trackers are shared between resources with different window settings
+type dedupEntry struct {
+	msgID   string
+	expires time.Time
+}
+
+// dedupTracker remembers the ids of recently published messages keyed by
+// topic and client supplied dedup id, so a retried publish returns the
+// original id instead of creating a duplicate downstream
+type dedupTracker struct {
+	sync.Mutex
+	entries map[string]dedupEntry
+}
+
+// publishDedup tracks the dedup ids seen by the publish handlers
+var publishDedup = &dedupTracker{entries: make(map[string]dedupEntry)}
+
+// ackDedup tracks the offsets recently acknowledged per subscription, so
+// pulls of dedup enabled subscriptions can filter redelivered messages
+// the consumer already processed
+var ackDedup = &dedupTracker{entries: make(map[string]dedupEntry)}
+
+// lookup returns the original message id recorded for a dedup id if its
+// window has not passed yet
+func (dt *dedupTracker) lookup(topic string, dedupID string) (string, bool) {
+	dt.Lock()
+	defer dt.Unlock()
+	entry, ok := dt.entries[topic+"/"+dedupID]
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.msgID, true
+}
+
+// record stores the id of a published message under its dedup id for the
+// given window and prunes entries whose own deadline has passed
+func (dt *dedupTracker) record(topic string, dedupID string, msgID string, window time.Duration) {
+	dt.Lock()
+	defer dt.Unlock()
+	now := time.Now()
+	for key, entry := range dt.entries {
+		if now.After(entry.expires) {
+			delete(dt.entries, key)
+		}
+	}
+	dt.entries[topic+"/"+dedupID] = dedupEntry{msgID: msgID, expires: now.Add(window)}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Deprecation describes a feature slated for removal
+type Deprecation struct {
+	// Since is when the deprecation was announced, as an http date
+	Since string `json:"since"`
+	// Sunset is when the feature stops working, as an http date
+	Sunset string `json:"sunset"`
+	// Warning is a short machine-readable explanation of what to use instead
+	Warning string `json:"warning"`
+}
+
+// routeDeprecations maps route names to their deprecation notice; routes
+// listed here respond with Deprecation/Sunset headers until removal
+var routeDeprecations = map[string]Deprecation{}
+
+// paramDeprecations maps deprecated query parameters to their notice;
+// requests using them respond with Deprecation/Sunset headers
+var paramDeprecations = map[string]Deprecation{
+	"key": {
+		Since:   "Mon, 03 Aug 2026 00:00:00 GMT",
+		Sunset:  "Fri, 01 Jan 2027 00:00:00 GMT",
+		Warning: "query parameter authentication is deprecated, send the token in the x-api-key header instead",
+	},
+}
+
+// applyDeprecation attaches the deprecation headers to a response
+func applyDeprecation(w http.ResponseWriter, dep Deprecation) {
+	w.Header().Set("Deprecation", dep.Since)
+	w.Header().Set("Sunset", dep.Sunset)
+	notice, _ := json.Marshal(dep)
+	w.Header().Set("X-Deprecation-Notice", string(notice))
+}
+
+// WrapDeprecation attaches deprecation headers when the route or one of
+// the used query parameters is slated for removal
+func WrapDeprecation(hfn http.HandlerFunc, routeName string) http.HandlerFunc {
+	dep, deprecatedRoute := routeDeprecations[routeName]
+	return func(w http.ResponseWriter, r *http.Request) {
+		if deprecatedRoute {
+			applyDeprecation(w, dep)
+		}
+		for param, paramDep := range paramDeprecations {
+			if r.URL.Query().Has(param) {
+				applyDeprecation(w, paramDep)
+			}
+		}
+		hfn.ServeHTTP(w, r)
+	}
+}
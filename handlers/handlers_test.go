@@ -0,0 +1,3019 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/kaggis/argo-messaging/auth"
+	"github.com/kaggis/argo-messaging/autoscale"
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/config"
+	"github.com/kaggis/argo-messaging/events"
+	"github.com/kaggis/argo-messaging/metrics"
+	"github.com/kaggis/argo-messaging/projects"
+	"github.com/kaggis/argo-messaging/push"
+	"github.com/kaggis/argo-messaging/replay"
+	"github.com/kaggis/argo-messaging/status"
+	"github.com/kaggis/argo-messaging/stores"
+	"github.com/kaggis/argo-messaging/subscriptions"
+	"github.com/kaggis/argo-messaging/topics"
+)
+
+// testSetup holds the mocked service references used by the handler tests
+type testSetup struct {
+	cfg    *config.APICfg
+	str    *stores.MockStore
+	brk    *brokers.MockBroker
+	pool   brokers.Provider
+	mgr    *push.Manager
+	sender *push.MockSender
+	aws    *push.MockAWSSender
+	kafka  *push.MockKafkaSender
+	email  *push.MockEmailSender
+	pc     *push.MockClient
+	api    *API
+}
+
+// newTestSetup creates a fully mocked api against fixture data
+func newTestSetup() *testSetup {
+	ts := testSetup{}
+	ts.cfg = config.NewAPICfg()
+	ts.cfg.LoadStrJSON(`{"kafka_clusters": {"main": ["localhost:9092"]}, "per_resource_auth": true}`)
+	ts.str = stores.NewMockStore("", "")
+	ts.brk = brokers.NewMockBroker()
+	ts.pool = brokers.NewMockPool(ts.brk)
+	ts.sender = push.NewMockSender()
+	ts.mgr = push.NewManager(ts.pool, ts.str, ts.sender)
+	ts.aws = push.NewMockAWSSender()
+	ts.mgr.SetAWSSender(ts.aws)
+	ts.kafka = push.NewMockKafkaSender()
+	ts.mgr.SetKafkaSender(ts.kafka)
+	ts.email = push.NewMockEmailSender()
+	ts.mgr.SetEmailSender(ts.email)
+	ts.pc = push.NewMockClient()
+	ts.api = NewRouting(ts.cfg, ts.pool, ts.str, push.NewProvider(ts.cfg, ts.mgr, ts.pc))
+	return &ts
+}
+
+// request performs a request against the test router and records the response
+func (ts *testSetup) request(method string, url string, body string) *httptest.ResponseRecorder {
+	var reader *strings.Reader
+	if body == "" {
+		reader = strings.NewReader("")
+	} else {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, url, reader)
+	w := httptest.NewRecorder()
+	ts.api.Router.ServeHTTP(w, req)
+	return w
+}
+
+func (ts *testSetup) requestWithType(method string, url string, body string, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	ts.api.Router.ServeHTTP(w, req)
+	return w
+}
+
+type HandlersTestSuite struct {
+	suite.Suite
+}
+
+func (suite *HandlersTestSuite) TestTopicCreateListDelete() {
+	ts := newTestSetup()
+
+	// create
+	w := ts.request("PUT", "/v1/projects/ARGO/topics/topicNew?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "projects/ARGO/topics/topicNew")
+
+	// create duplicate
+	w = ts.request("PUT", "/v1/projects/ARGO/topics/topicNew?key=S3CR3T7", "")
+	suite.Equal(http.StatusConflict, w.Code)
+
+	// show
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topicNew?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+
+	// delete
+	w = ts.request("DELETE", "/v1/projects/ARGO/topics/topicNew?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+
+	// show after delete
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topicNew?key=S3CR3T7", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestTopicListSubscriptions() {
+	ts := newTestSetup()
+
+	// attach two more subscriptions to topic1 alongside the fixture sub1
+	for _, name := range []string{"subByTopicA", "subByTopicB"} {
+		w := ts.request("PUT", "/v1/projects/ARGO/subscriptions/"+name+"?key=S3CR3T7",
+			`{"topic": "projects/ARGO/topics/topic1"}`)
+		suite.Equal(http.StatusOK, w.Code)
+	}
+
+	w := ts.request("GET", "/v1/projects/ARGO/topics/topic1/subscriptions?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"totalSize": 3`)
+	suite.Contains(w.Body.String(), "sub1")
+	suite.NotContains(w.Body.String(), "sub2")
+
+	// a bounded page returns a token leading to the rest
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1/subscriptions?key=S3CR3T7&pageSize=2", "")
+	suite.Equal(http.StatusOK, w.Code)
+	page := struct {
+		Subscriptions []struct {
+			Name string `json:"name"`
+		} `json:"subscriptions"`
+		NextPageToken string `json:"nextPageToken"`
+		TotalSize     int64  `json:"totalSize"`
+	}{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &page))
+	suite.Equal(2, len(page.Subscriptions))
+	suite.Equal(int64(3), page.TotalSize)
+	suite.NotEmpty(page.NextPageToken)
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1/subscriptions?key=S3CR3T7&pageSize=2&pageToken="+page.NextPageToken, "")
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &page))
+	suite.Equal(1, len(page.Subscriptions))
+	suite.Empty(page.NextPageToken)
+
+	// bad tokens and missing topics are rejected
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1/subscriptions?key=S3CR3T7&pageToken=garbage", "")
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/topics/missing/subscriptions?key=S3CR3T7", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestListOrdering() {
+	ts := newTestSetup()
+
+	names := func(w *httptest.ResponseRecorder, key string) []string {
+		page := map[string]json.RawMessage{}
+		suite.NoError(json.Unmarshal(w.Body.Bytes(), &page))
+		items := []struct {
+			Name string `json:"name"`
+		}{}
+		suite.NoError(json.Unmarshal(page[key], &items))
+		result := []string{}
+		for _, item := range items {
+			result = append(result, item.Name)
+		}
+		return result
+	}
+
+	// without orderBy the listing stays newest first
+	w := ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal([]string{"projects/ARGO/topics/topic3", "projects/ARGO/topics/topic2",
+		"projects/ARGO/topics/topic1"}, names(w, "topics"))
+
+	// orderBy sorts ascending by default, direction=desc flips it
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T7&orderBy=name", "")
+	suite.Equal([]string{"projects/ARGO/topics/topic1", "projects/ARGO/topics/topic2",
+		"projects/ARGO/topics/topic3"}, names(w, "topics"))
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T7&orderBy=name&direction=desc", "")
+	suite.Equal([]string{"projects/ARGO/topics/topic3", "projects/ARGO/topics/topic2",
+		"projects/ARGO/topics/topic1"}, names(w, "topics"))
+
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions?key=S3CR3T7&orderBy=name", "")
+	subNames := names(w, "subscriptions")
+	suite.Equal("projects/ARGO/subscriptions/sub1", subNames[0])
+
+	// a sorted user listing goes through the filtered path
+	w = ts.request("GET", "/v1/users?key=S3CR3T8&orderBy=name", "")
+	suite.Equal(http.StatusOK, w.Code)
+	userNames := names(w, "users")
+	suite.Equal("Test", userNames[0])
+
+	// unknown fields and directions are rejected
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T7&orderBy=msg_num", "")
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T7&orderBy=name&direction=sideways", "")
+	suite.Equal(http.StatusBadRequest, w.Code)
+	// modified_on only exists on users
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T7&orderBy=modified_on", "")
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("GET", "/v1/users?key=S3CR3T8&orderBy=modified_on", "")
+	suite.Equal(http.StatusOK, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestTopicCreateWithPartitions() {
+	ts := newTestSetup()
+
+	w := ts.request("PUT", "/v1/projects/ARGO/topics/topicTuned?key=S3CR3T7",
+		`{"partitions": 1, "replication_factor": 3}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"partitions": 1`)
+	suite.Contains(w.Body.String(), `"replication_factor": 3`)
+
+	// the settings reach the broker topic creation
+	detail := ts.brk.TopicDetails["argo_uuid.topicTuned"]
+	suite.Equal(int32(1), detail.Partitions)
+	suite.Equal(int16(3), detail.ReplicationFactor)
+
+	// omitted settings fall back to the configured defaults
+	w = ts.request("PUT", "/v1/projects/ARGO/topics/topicPlain?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	detail = ts.brk.TopicDetails["argo_uuid.topicPlain"]
+	suite.Equal(int32(1), detail.Partitions)
+	suite.Equal(int16(1), detail.ReplicationFactor)
+
+	// invalid values are rejected
+	w = ts.request("PUT", "/v1/projects/ARGO/topics/topicBad?key=S3CR3T7", `{"partitions": 0}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// the consume model reads a single partition per topic, so asking
+	// for more is refused instead of silently losing traffic
+	w = ts.request("PUT", "/v1/projects/ARGO/topics/topicWide?key=S3CR3T7", `{"partitions": 4}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Only one partition per topic is supported")
+}
+
+func (suite *HandlersTestSuite) TestTopicAutoScale() {
+	ts := newTestSetup()
+
+	w := ts.request("PUT", "/v1/projects/ARGO/topics/topicScale?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("PUT", "/v1/projects/ARGO/topics/topicPinned?key=S3CR3T7", `{"autoScaleDisabled": true}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"autoScaleDisabled": true`)
+
+	// both topics run hot, but only the first may be reported
+	metrics.MsgRate.Record("argo_uuid.topicScale", 1000000)
+	metrics.MsgRate.Record("argo_uuid.topicPinned", 1000000)
+
+	eventCh, cancel := events.Subscribe()
+	defer cancel()
+
+	am := autoscale.NewManager(ts.str, 100)
+	am.Scan()
+
+	// the hot topic is reported, not repartitioned: the consume model
+	// reads partition 0 only, so growth would strand messages
+	event := <-eventCh
+	suite.Equal("topic.hot", event.Type)
+	suite.Equal("topicScale", event.Resource)
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topicScale?key=S3CR3T7", "")
+	suite.Contains(w.Body.String(), `"partitions": 1`)
+	suite.Equal(int32(1), ts.brk.TopicDetails["argo_uuid.topicScale"].Partitions)
+
+	// repeated scans within the window do not repeat the report, and the
+	// opted out topic never surfaces
+	am.Scan()
+	select {
+	case event := <-eventCh:
+		suite.Fail("unexpected event " + event.Type + " for " + event.Resource)
+	default:
+	}
+}
+
+func (suite *HandlersTestSuite) TestResourceLabels() {
+	ts := newTestSetup()
+
+	// labels set at creation come back on the resource
+	w := ts.request("PUT", "/v1/projects/ARGO/topics/topicLbl?key=S3CR3T7",
+		`{"labels": {"env": "prod", "experiment": "atlas"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"env": "prod"`)
+	w = ts.request("PUT", "/v1/projects/ARGO/subscriptions/subLbl?key=S3CR3T7",
+		`{"topic": "projects/ARGO/topics/topicLbl", "labels": {"env": "prod"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"env": "prod"`)
+
+	// the list endpoints filter on a label selector; every pair must match
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T7&labels=env=prod", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "topicLbl")
+	suite.NotContains(w.Body.String(), "topic1")
+	suite.Contains(w.Body.String(), `"totalSize": 1`)
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T7&labels=env=prod,experiment=cms", "")
+	suite.Contains(w.Body.String(), `"totalSize": 0`)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions?key=S3CR3T7&labels=env=prod", "")
+	suite.Contains(w.Body.String(), "subLbl")
+	suite.NotContains(w.Body.String(), "sub1")
+
+	// a malformed selector is rejected
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T7&labels=no-value", "")
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// :modifyLabels replaces the whole label set
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topicLbl:modifyLabels?key=S3CR3T7",
+		`{"labels": {"env": "dev"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topicLbl?key=S3CR3T7", "")
+	suite.Contains(w.Body.String(), `"env": "dev"`)
+	suite.NotContains(w.Body.String(), "experiment")
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/subLbl:modifyLabels?key=S3CR3T7",
+		`{"labels": {"env": "dev"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions?key=S3CR3T7&labels=env=dev", "")
+	suite.Contains(w.Body.String(), "subLbl")
+
+	// plain members may not touch labels, and missing resources 404
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topicLbl:modifyLabels?key=S3CR3T1",
+		`{"labels": {"env": "dev"}}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/missing:modifyLabels?key=S3CR3T7",
+		`{"labels": {}}`)
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestTopicCreateIdempotent() {
+	ts := newTestSetup()
+
+	w := ts.request("PUT", "/v1/projects/ARGO/topics/topicIdem?key=S3CR3T7", `{"idempotent": true}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"idempotent": true`)
+
+	// publishing to an idempotent topic goes through the idempotent producer
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topicIdem:publish?key=S3CR3T7",
+		`{"messages": [{"data": "bW9ja19kYXRh"}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"0"`)
+}
+
+func (suite *HandlersTestSuite) TestDeprecationHeaders() {
+	ts := newTestSetup()
+
+	// requests using the deprecated key parameter carry the notice
+	w := ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.NotEmpty(w.Header().Get("Deprecation"))
+	suite.NotEmpty(w.Header().Get("Sunset"))
+	suite.Contains(w.Header().Get("X-Deprecation-Notice"), "x-api-key")
+
+	// requests without deprecated features stay clean
+	w = ts.request("GET", "/v1/status", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Empty(w.Header().Get("Deprecation"))
+	suite.Empty(w.Header().Get("Sunset"))
+}
+
+func (suite *HandlersTestSuite) TestSubPullSessionsAndCancel() {
+	ts := newTestSetup()
+
+	// with no pull in flight the session list is empty
+	w := ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1:pullSessions?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"sessions": []`)
+
+	// register a pull session as an in-flight pull would
+	ctx, session := activePulls.register(context.Background(), "projects/ARGO/subscriptions/sub1")
+	defer activePulls.release(session)
+
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1:pullSessions?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), session.ID)
+
+	// cancelling releases the in-flight pull immediately
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:cancelPull?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"cancelled": 1`)
+	suite.Error(ctx.Err())
+
+	// unknown subscriptions return not found
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/nosuchsub:cancelPull?key=S3CR3T1", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestSubStream() {
+	ts := newTestSetup()
+	server := httptest.NewServer(ts.api.Router)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/projects/ARGO/subscriptions/sub1:stream?key=S3CR3T1"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	suite.NoError(err)
+	defer conn.Close()
+
+	// the four seeded messages of topic1 are delivered immediately
+	rec := struct {
+		AckID string `json:"ackId"`
+	}{}
+	for i := 0; i < 4; i++ {
+		suite.NoError(conn.ReadJSON(&rec))
+	}
+	suite.Equal("projects/ARGO/subscriptions/sub1:3", rec.AckID)
+
+	// acking in-band advances the subscription offset
+	suite.NoError(conn.WriteJSON(map[string][]string{"ackIds": {"projects/ARGO/subscriptions/sub1:3"}}))
+	acked := false
+	for i := 0; i < 100; i++ {
+		subs, _, _, _ := ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+		if len(subs) > 0 && subs[0].Offset == 4 {
+			acked = true
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	suite.True(acked)
+}
+
+func (suite *HandlersTestSuite) TestSubSSE() {
+	ts := newTestSetup()
+	server := httptest.NewServer(ts.api.Router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/projects/ARGO/subscriptions/sub1:sse?key=S3CR3T1")
+	suite.NoError(err)
+	defer resp.Body.Close()
+	suite.Equal(http.StatusOK, resp.StatusCode)
+	suite.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	// the four seeded messages of topic1 arrive as events
+	scanner := bufio.NewScanner(resp.Body)
+	data := []string{}
+	for scanner.Scan() && len(data) < 4 {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data: ") {
+			data = append(data, line)
+		}
+	}
+	suite.Equal(4, len(data))
+	suite.Contains(data[0], "projects/ARGO/subscriptions/sub1:0")
+	suite.Contains(data[3], "projects/ARGO/subscriptions/sub1:3")
+
+	// the delivery is stamped so a companion acknowledge advances the offset
+	acked := false
+	for i := 0; i < 100; i++ {
+		w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:acknowledge?key=S3CR3T1",
+			`{"ackIds": ["projects/ARGO/subscriptions/sub1:3"]}`)
+		if w.Code == http.StatusOK {
+			acked = true
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	suite.True(acked)
+}
+
+func (suite *HandlersTestSuite) TestSubStreamForbidden() {
+	ts := newTestSetup()
+
+	// UserZ is a consumer but not in the acl of sub1
+	w := ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1:stream?key=S3CR3T4", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestProjectPurgeOperation() {
+	ts := newTestSetup()
+
+	// purge returns an operation immediately
+	w := ts.request("POST", "/v1/projects/ARGO:purge?key=S3CR3T8", "")
+	suite.Equal(http.StatusAccepted, w.Code)
+	suite.Contains(w.Body.String(), `"type": "project.purge"`)
+
+	op := struct {
+		ID string `json:"id"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &op)
+
+	// poll the operation until it completes
+	status := ""
+	for i := 0; i < 100; i++ {
+		w = ts.request("GET", "/v1/operations/"+op.ID+"?key=S3CR3T8", "")
+		suite.Equal(http.StatusOK, w.Code)
+		if strings.Contains(w.Body.String(), `"status": "completed"`) {
+			status = "completed"
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	suite.Equal("completed", status)
+
+	// the project has no topics or subscriptions left
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T8", "")
+	suite.Contains(w.Body.String(), `"totalSize": 0`)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions?key=S3CR3T8", "")
+	suite.Contains(w.Body.String(), `"totalSize": 0`)
+
+	// unknown operations return not found, non admins cannot poll
+	w = ts.request("GET", "/v1/operations/nosuchop?key=S3CR3T8", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+	w = ts.request("GET", "/v1/operations/"+op.ID+"?key=S3CR3T1", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestTopicBatchCreateDelete() {
+	ts := newTestSetup()
+
+	// topic1 already exists, the rest of the batch still goes through
+	w := ts.request("POST", "/v1/projects/ARGO/topics:batchCreate?key=S3CR3T7",
+		`{"topics": [{"name": "batch1"}, {"name": "topic1"}, {"name": "bad name"}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"name": "batch1",
+         "success": true`)
+	suite.Contains(w.Body.String(), `"error": "Topic already exists"`)
+	suite.Contains(w.Body.String(), `"error": "Invalid topic name"`)
+
+	// batch delete reports missing topics per item as well
+	w = ts.request("POST", "/v1/projects/ARGO/topics:batchDelete?key=S3CR3T7",
+		`{"topics": ["batch1", "nosuchtopic"]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"name": "batch1",
+         "success": true`)
+	suite.Contains(w.Body.String(), `"error": "Topic not found"`)
+
+	// empty batches are rejected outright
+	w = ts.request("POST", "/v1/projects/ARGO/topics:batchCreate?key=S3CR3T7", `{"topics": []}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestTopicPublish() {
+	ts := newTestSetup()
+
+	body := `{"messages": [{"attributes": {"foo": "bar"}, "data": "YmFzZTY0"}]}`
+	w := ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1", body)
+	suite.Equal(http.StatusOK, w.Code)
+	// topic1 is seeded with four messages so the new one lands on offset 4
+	suite.Contains(w.Body.String(), `"4"`)
+
+	// publish to a missing topic
+	w = ts.request("POST", "/v1/projects/ARGO/topics/nosuchtopic:publish?key=S3CR3T1", body)
+	suite.Equal(http.StatusNotFound, w.Code)
+
+	// invalid base64 payload
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1", `{"messages": [{"data": "invalid!!"}]}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestPublishIdentityAttributes() {
+	// with the flag off, client supplied ams. attributes pass through
+	ts := newTestSetup()
+	body := `{"messages": [{"attributes": {"ams.publisher": "fake"}, "data": "YmFzZTY0"}]}`
+	w := ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1", body)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "5"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "fake")
+	suite.NotContains(w.Body.String(), "ams.auth")
+
+	// with the flag on, the reserved namespace carries the verified
+	// publisher identity and the forged attribute is dropped
+	ts = newTestSetup()
+	ts.cfg.PublishIdentityAttributes = true
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1", body)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "5"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "ams.publisher")
+	suite.Contains(w.Body.String(), "uuid1")
+	suite.Contains(w.Body.String(), "ams.auth")
+	suite.Contains(w.Body.String(), "ams.source_ip")
+	suite.NotContains(w.Body.String(), "fake")
+}
+
+func (suite *HandlersTestSuite) TestOIDCAuth() {
+	ts := newTestSetup()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	suite.NoError(err)
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		doc := map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1",
+			"n": base64.RawURLEncoding.EncodeToString(rsaKey.PublicKey.N.Bytes()),
+			"e": "AQAB",
+		}}}
+		json.NewEncoder(w).Encode(doc)
+	}))
+	defer jwks.Close()
+
+	auth.SetOIDC(auth.NewOIDCValidator("https://idp.example.com", jwks.URL, "preferred_username", ""), true)
+	defer auth.SetOIDC(nil, false)
+
+	sign := func(claims map[string]interface{}) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+		payload, _ := json.Marshal(claims)
+		signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		hashed := sha256.Sum256([]byte(signingInput))
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, hashed[:])
+		return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	bearerRequest := func(token string, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, strings.NewReader(""))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		ts.api.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	// a valid token maps to the existing user through the configured claim
+	token := sign(map[string]interface{}{"iss": "https://idp.example.com",
+		"exp": time.Now().Add(time.Hour).Unix(), "preferred_username": "UserA"})
+	w := bearerRequest(token, "/v1/projects/ARGO/subscriptions")
+	suite.Equal(http.StatusOK, w.Code)
+
+	// a suspended federated user is cut off with the distinct error
+	ts.str.UpdateUserSuspension("uuid1", true, "2024-01-01T00:00:00Z")
+	w = bearerRequest(token, "/v1/projects/ARGO/subscriptions")
+	suite.Equal(http.StatusForbidden, w.Code)
+	suite.Contains(w.Body.String(), "Account is suspended")
+	ts.str.UpdateUserSuspension("uuid1", false, "2024-01-01T00:00:00Z")
+	suite.Equal(http.StatusOK, bearerRequest(token, "/v1/projects/ARGO/subscriptions").Code)
+
+	// wrong issuer, expired and tampered tokens are rejected
+	token = sign(map[string]interface{}{"iss": "https://evil.example.com",
+		"exp": time.Now().Add(time.Hour).Unix(), "preferred_username": "UserA"})
+	suite.Equal(http.StatusUnauthorized, bearerRequest(token, "/v1/projects/ARGO/subscriptions").Code)
+	token = sign(map[string]interface{}{"iss": "https://idp.example.com",
+		"exp": time.Now().Add(-time.Hour).Unix(), "preferred_username": "UserA"})
+	suite.Equal(http.StatusUnauthorized, bearerRequest(token, "/v1/projects/ARGO/subscriptions").Code)
+	token = sign(map[string]interface{}{"iss": "https://idp.example.com",
+		"exp": time.Now().Add(time.Hour).Unix(), "preferred_username": "UserA"})
+	suite.Equal(http.StatusUnauthorized, bearerRequest(token+"x", "/v1/projects/ARGO/subscriptions").Code)
+
+	// unknown subjects are auto provisioned without any roles
+	token = sign(map[string]interface{}{"iss": "https://idp.example.com",
+		"exp": time.Now().Add(time.Hour).Unix(), "preferred_username": "federated_user"})
+	suite.Equal(http.StatusForbidden, bearerRequest(token, "/v1/projects/ARGO/subscriptions").Code)
+	provisioned, _ := ts.str.QueryUsers("", "", "federated_user")
+	suite.Equal(1, len(provisioned))
+	suite.Empty(provisioned[0].ServiceRoles)
+
+	// without auto provisioning unknown subjects stay unauthorized
+	auth.SetOIDC(auth.NewOIDCValidator("https://idp.example.com", jwks.URL, "preferred_username", ""), false)
+	token = sign(map[string]interface{}{"iss": "https://idp.example.com",
+		"exp": time.Now().Add(time.Hour).Unix(), "preferred_username": "other_user"})
+	suite.Equal(http.StatusUnauthorized, bearerRequest(token, "/v1/projects/ARGO/subscriptions").Code)
+	provisioned, _ = ts.str.QueryUsers("", "", "other_user")
+	suite.Empty(provisioned)
+
+	// with an audience configured, only tokens minted for this service
+	// pass; string and list aud claims both count, azp stands in when
+	// the token carries no aud
+	auth.SetOIDC(auth.NewOIDCValidator("https://idp.example.com", jwks.URL, "preferred_username", "ams-api"), false)
+	base := map[string]interface{}{"iss": "https://idp.example.com",
+		"exp": time.Now().Add(time.Hour).Unix(), "preferred_username": "UserA"}
+	withClaim := func(key string, value interface{}) map[string]interface{} {
+		claims := map[string]interface{}{}
+		for k, v := range base {
+			claims[k] = v
+		}
+		claims[key] = value
+		return claims
+	}
+	suite.Equal(http.StatusUnauthorized, bearerRequest(sign(base), "/v1/projects/ARGO/subscriptions").Code)
+	suite.Equal(http.StatusUnauthorized, bearerRequest(sign(withClaim("aud", "other-api")), "/v1/projects/ARGO/subscriptions").Code)
+	suite.Equal(http.StatusOK, bearerRequest(sign(withClaim("aud", "ams-api")), "/v1/projects/ARGO/subscriptions").Code)
+	suite.Equal(http.StatusOK, bearerRequest(sign(withClaim("aud", []string{"other-api", "ams-api"})), "/v1/projects/ARGO/subscriptions").Code)
+	suite.Equal(http.StatusOK, bearerRequest(sign(withClaim("azp", "ams-api")), "/v1/projects/ARGO/subscriptions").Code)
+
+	// tokens before their nbf are rejected
+	notYet := withClaim("aud", "ams-api")
+	notYet["nbf"] = time.Now().Add(time.Hour).Unix()
+	suite.Equal(http.StatusUnauthorized, bearerRequest(sign(notYet), "/v1/projects/ARGO/subscriptions").Code)
+}
+
+func (suite *HandlersTestSuite) TestAuthErrors() {
+	ts := newTestSetup()
+
+	// missing token
+	w := ts.request("GET", "/v1/projects/ARGO/topics", "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	// invalid token
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=WRONG", "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	// consumer role cannot create topics
+	w = ts.request("PUT", "/v1/projects/ARGO/topics/topicNew?key=S3CR3T4", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	// unknown project
+	w = ts.request("GET", "/v1/projects/NOPE/topics?key=S3CR3T1", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestProjectAssignCluster() {
+	ts := newTestSetup()
+
+	// only service admins may assign clusters
+	w := ts.request("POST", "/v1/projects/ARGO:assignCluster?key=S3CR3T1", `{"cluster": "mock"}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	// unknown clusters are rejected
+	w = ts.request("POST", "/v1/projects/ARGO:assignCluster?key=S3CR3T8", `{"cluster": "unknown"}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// the mock pool registers a single cluster named mock
+	w = ts.request("POST", "/v1/projects/ARGO:assignCluster?key=S3CR3T8", `{"cluster": "mock"}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	cluster, err := ts.str.QueryProjectCluster("argo_uuid")
+	suite.NoError(err)
+	suite.Equal("mock", cluster)
+}
+
+func (suite *HandlersTestSuite) TestSubPullAck() {
+	ts := newTestSetup()
+
+	// pull two messages from the seeded topic
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "2"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "projects/ARGO/subscriptions/sub1:0")
+	suite.Contains(w.Body.String(), "projects/ARGO/subscriptions/sub1:1")
+
+	// acknowledge them
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:acknowledge?key=S3CR3T1",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:1"]}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// a second ack without a pending pull times out
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:acknowledge?key=S3CR3T1",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:1"]}`)
+	suite.Equal(http.StatusRequestTimeout, w.Code)
+
+	// the next pull resumes after the acked offset
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "projects/ARGO/subscriptions/sub1:2")
+}
+
+func (suite *HandlersTestSuite) TestSubAckPerResourceAuth() {
+	ts := newTestSetup()
+	ts.cfg.ResAuth = true
+
+	// UserZ is a consumer of the project but not on the acl of sub1, so
+	// guessable ack ids must not let it advance the offset
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:acknowledge?key=S3CR3T4",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:0"]}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	// the lease cannot be extended from outside the acl either
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:extend?key=S3CR3T4",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:0"], "extendSeconds": 60}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	// the acl holder still acks its own pull
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:acknowledge?key=S3CR3T1",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:0"]}`)
+	suite.Equal(http.StatusOK, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestSubExtendAck() {
+	ts := newTestSetup()
+
+	// shrink the subscription-wide deadline so its expiry can be observed
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyAckDeadline?key=S3CR3T7",
+		`{"ackDeadlineSeconds": 1}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// pull a message and heartbeat the lease past the base deadline
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:extend?key=S3CR3T1",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:0"], "extendSeconds": 60}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"extendedUntil"`)
+
+	// the extended lease keeps the ack valid after the base deadline
+	time.Sleep(1200 * time.Millisecond)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:acknowledge?key=S3CR3T1",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:0"]}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// without a heartbeat the lease expires as before
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	time.Sleep(1200 * time.Millisecond)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:extend?key=S3CR3T1",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:1"], "extendSeconds": 60}`)
+	suite.Equal(http.StatusRequestTimeout, w.Code)
+
+	// malformed extensions are rejected
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:extend?key=S3CR3T1",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:1"], "extendSeconds": 0}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:extend?key=S3CR3T1",
+		`{"ackIds": ["projects/OTHER/subscriptions/sub1:1"], "extendSeconds": 60}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestSubSeek() {
+	ts := newTestSetup()
+
+	// drain the subscription past the seeded messages
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "4"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:acknowledge?key=S3CR3T1",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:3"]}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// seeking to the seeded publish time replays from the beginning
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:seek?key=S3CR3T1",
+		`{"time": "2016-10-13T02:19:09Z"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"offset": 0`)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "1"}`)
+	suite.Contains(w.Body.String(), "projects/ARGO/subscriptions/sub1:0")
+
+	// a timestamp past the latest message seeks to the end
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:seek?key=S3CR3T1",
+		`{"time": "`+time.Now().UTC().Format(time.RFC3339Nano)+`"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"offset": 4`)
+
+	// malformed timestamps are rejected
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:seek?key=S3CR3T1", `{"time": "yesterday"}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// a consumer outside the acl cannot seek
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub2:seek?key=S3CR3T4",
+		`{"time": "2016-10-13T02:19:09Z"}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestSubAckDedup() {
+	ts := newTestSetup()
+
+	// windows outside the accepted range are rejected
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyAckDedup?key=S3CR3T7",
+		`{"ackDedupWindowSeconds": 9999}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyAckDedup?key=S3CR3T7",
+		`{"ackDedupWindowSeconds": 300}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"ackDedupWindowSeconds": 300`)
+
+	// consume and ack the first two messages
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "2"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:acknowledge?key=S3CR3T1",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:0", "projects/ARGO/subscriptions/sub1:1"]}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// replaying from the beginning redelivers only the unacked messages
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:seek?key=S3CR3T1",
+		`{"time": "2016-10-13T02:19:09Z"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "4"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.NotContains(w.Body.String(), "projects/ARGO/subscriptions/sub1:0")
+	suite.NotContains(w.Body.String(), "projects/ARGO/subscriptions/sub1:1")
+	suite.Contains(w.Body.String(), "projects/ARGO/subscriptions/sub1:2")
+
+	// with the window disabled the acked messages are redelivered again
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyAckDedup?key=S3CR3T7",
+		`{"ackDedupWindowSeconds": 0}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:cancelPull?key=S3CR3T1", "")
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:seek?key=S3CR3T1",
+		`{"time": "2016-10-13T02:19:09Z"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "2"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "projects/ARGO/subscriptions/sub1:0")
+}
+
+func (suite *HandlersTestSuite) TestLogLevels() {
+	ts := newTestSetup()
+
+	// the defaults apply to every subsystem
+	w := ts.request("GET", "/v1/logs:levels?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"brokers": "info"`)
+
+	// an override turns on broker debug logging only
+	w = ts.request("POST", "/v1/logs:modifyLevels?key=S3CR3T8", `{"levels": {"brokers": "debug"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"brokers": "debug"`)
+	suite.Contains(w.Body.String(), `"stores": "info"`)
+
+	// the default level drops the override again
+	w = ts.request("POST", "/v1/logs:modifyLevels?key=S3CR3T8", `{"levels": {"brokers": "default"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"brokers": "info"`)
+
+	// unknown modules and levels are rejected
+	w = ts.request("POST", "/v1/logs:modifyLevels?key=S3CR3T8", `{"levels": {"nosuch": "debug"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("POST", "/v1/logs:modifyLevels?key=S3CR3T8", `{"levels": {"brokers": "loud"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// only service admins may touch log levels
+	w = ts.request("GET", "/v1/logs:levels?key=S3CR3T7", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestInFlightRecovery() {
+	ts := newTestSetup()
+
+	// simulate a pull whose instance went away after the deadline passed
+	ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyAckDeadline?key=S3CR3T7",
+		`{"ackDeadlineSeconds": 1}`)
+	ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "2"}`)
+	time.Sleep(1200 * time.Millisecond)
+
+	// the startup pass clears only the expired in-flight state
+	suite.Equal(1, subscriptions.RecoverInFlight(time.Now(), ts.str))
+	suite.Equal(0, subscriptions.RecoverInFlight(time.Now(), ts.str))
+
+	// the unacked messages are redelivered from the persisted offset
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "1"}`)
+	suite.Contains(w.Body.String(), "projects/ARGO/subscriptions/sub1:0")
+
+	// a lease still within its deadline is kept so its consumer can ack
+	ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyAckDeadline?key=S3CR3T7",
+		`{"ackDeadlineSeconds": 60}`)
+	ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "1"}`)
+	suite.Equal(0, subscriptions.RecoverInFlight(time.Now(), ts.str))
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:acknowledge?key=S3CR3T1",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:0"]}`)
+	suite.Equal(http.StatusOK, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestTopicInheritProjectACL() {
+	ts := newTestSetup()
+
+	// a topic flagged to inherit the project acl accepts publishes from
+	// any project member with the publisher role
+	w := ts.request("PUT", "/v1/projects/ARGO/topics/opentopic?key=S3CR3T7",
+		`{"inheritProjectAcl": true}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"inheritProjectAcl": true`)
+
+	w = ts.request("POST", "/v1/projects/ARGO/topics/opentopic:publish?key=S3CR3T1",
+		`{"messages": [{"data": "b3Blbg=="}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// without the flag a publisher outside the topic acl stays locked out
+	w = ts.request("PUT", "/v1/projects/ARGO/topics/closedtopic?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+
+	w = ts.request("POST", "/v1/projects/ARGO/topics/closedtopic:publish?key=S3CR3T1",
+		`{"messages": [{"data": "Y2xvc2Vk"}]}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestDedupTrackerWindows() {
+	dt := &dedupTracker{entries: make(map[string]dedupEntry)}
+
+	// recording through a short window must not evict entries of other
+	// resources whose own longer window has not passed
+	dt.record("topicLong", "id1", "msg1", time.Hour)
+	dt.record("topicShort", "id2", "msg2", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	dt.record("topicShort", "id3", "msg3", time.Millisecond)
+
+	msgID, ok := dt.lookup("topicLong", "id1")
+	suite.True(ok)
+	suite.Equal("msg1", msgID)
+
+	// the expired short window entry was pruned and no longer matches
+	_, ok = dt.lookup("topicShort", "id2")
+	suite.False(ok)
+	dt.Lock()
+	_, present := dt.entries["topicShort/id2"]
+	dt.Unlock()
+	suite.False(present)
+}
+
+func (suite *HandlersTestSuite) TestSubPullLongPoll() {
+	ts := newTestSetup()
+	ts.cfg.PullMaxWaitSec = 1
+
+	// an empty subscription holds the request until the max wait expires
+	start := time.Now()
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub2:pull?key=S3CR3T1",
+		`{"maxMessages": "1", "returnImmediately": "false"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"receivedMessages": []`)
+	suite.GreaterOrEqual(time.Since(start), time.Second)
+
+	// a publish during the wait releases the pull before the deadline
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		ts.request("POST", "/v1/projects/ARGO/topics/topic2:publish?key=S3CR3T1",
+			`{"messages": [{"data": "bG9uZyBwb2xs"}]}`)
+	}()
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub2:pull?key=S3CR3T1",
+		`{"maxMessages": "1", "returnImmediately": "false"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "projects/ARGO/subscriptions/sub2:0")
+
+	// messages already available return immediately
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1",
+		`{"maxMessages": "1", "returnImmediately": "false"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "projects/ARGO/subscriptions/sub1:0")
+
+	// invalid values are rejected
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1",
+		`{"returnImmediately": "maybe"}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestSubPullDecode() {
+	ts := newTestSetup()
+
+	// with decode=true valid utf-8 payloads come back decoded alongside base64
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1&decode=true",
+		`{"maxMessages": "1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"decodedData": "mock_data_0"`)
+
+	// without the flag only the base64 payload is returned
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1",
+		`{"maxMessages": "1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.NotContains(w.Body.String(), "decodedData")
+}
+
+func (suite *HandlersTestSuite) TestSubPullMaxBytes() {
+	ts := newTestSetup()
+
+	// each seeded payload is ~100 bytes, so a 150 byte budget returns one
+	// message even though four are requested
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1",
+		`{"maxMessages": "4", "maxBytes": "150"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "projects/ARGO/subscriptions/sub1:0")
+	suite.NotContains(w.Body.String(), "projects/ARGO/subscriptions/sub1:1")
+
+	// acknowledge the delivered message before pulling again
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:acknowledge?key=S3CR3T1",
+		`{"ackIds": ["projects/ARGO/subscriptions/sub1:0"]}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// a budget smaller than a single message still returns the first one
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1",
+		`{"maxMessages": "4", "maxBytes": "1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "projects/ARGO/subscriptions/sub1:1")
+	suite.NotContains(w.Body.String(), "projects/ARGO/subscriptions/sub1:2")
+
+	// invalid values are rejected
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1",
+		`{"maxMessages": "4", "maxBytes": "oops"}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestPublishLatencyMetrics() {
+	ts := newTestSetup()
+
+	// a message stamped with a sendTime attribute feeds the latency metrics
+	sendTime := time.Now().UTC().Add(-time.Second).Format(time.RFC3339Nano)
+	body := fmt.Sprintf(`{"messages": [{"data": "bW9ja19kYXRh", "attributes": {"sendTime": "%v"}}]}`, sendTime)
+	w := ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1", body)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// only service admins may read the service metrics
+	w = ts.request("GET", "/v1/metrics?key=S3CR3T1", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	w = ts.request("GET", "/v1/metrics?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"publish_latency"`)
+	suite.Contains(w.Body.String(), `"ARGO"`)
+}
+
+func (suite *HandlersTestSuite) TestTopicDiagnose() {
+	ts := newTestSetup()
+
+	// topic1 is seeded on the mock broker
+	w := ts.request("GET", "/v1/projects/ARGO/topics/topic1:diagnose?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"name": "broker_topic_exists",
+         "ok": true`)
+
+	// topic2 exists in the store but not on the broker
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic2:diagnose?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"status": "unhealthy"`)
+	suite.Contains(w.Body.String(), "topic is missing on the broker")
+
+	w = ts.request("GET", "/v1/projects/ARGO/topics/nosuchtopic:diagnose?key=S3CR3T1", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestSubReplay() {
+	ts := newTestSetup()
+
+	// out of range replay windows are rejected
+	w := ts.request("PUT", "/v1/projects/ARGO/subscriptions/subReplay?key=S3CR3T7",
+		`{"topic": "projects/ARGO/topics/topic1", "replayDays": 1000}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Replay days must be between 1 and 365")
+
+	// the replay topic is created with the extended retention
+	w = ts.request("PUT", "/v1/projects/ARGO/subscriptions/subReplay?key=S3CR3T7",
+		`{"topic": "projects/ARGO/topics/topic1", "replayDays": 90}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"replayDays": 90`)
+	detail := ts.brk.TopicDetails["argo_uuid._replay.subReplay"]
+	suite.Equal(int64(90*24*60*60*1000), detail.RetentionMs)
+
+	// the mirror picks up the subscription and copies messages published
+	// after creation into the replay topic as stored
+	rm := replay.NewManager(ts.pool, ts.str)
+	rm.StartAll()
+	suite.True(rm.IsActive("argo_uuid", "subReplay"))
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "cmVwbGF5ZWQ="}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	for i := 0; i < 50 && len(ts.brk.MsgList["argo_uuid._replay.subReplay"]) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	rm.StopAll()
+	suite.Equal(1, len(ts.brk.MsgList["argo_uuid._replay.subReplay"]))
+	suite.Equal(ts.brk.MsgList["argo_uuid.topic1"][4], ts.brk.MsgList["argo_uuid._replay.subReplay"][0])
+
+	// the subscription consumes the mirror from its start
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/subReplay:pull?key=S3CR3T7", `{"maxMessages": "1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "cmVwbGF5ZWQ=")
+	suite.Contains(w.Body.String(), "projects/ARGO/subscriptions/subReplay:0")
+
+	// the replay topic goes away with the subscription
+	w = ts.request("DELETE", "/v1/projects/ARGO/subscriptions/subReplay?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Empty(ts.brk.MsgList["argo_uuid._replay.subReplay"])
+}
+
+func (suite *HandlersTestSuite) TestSubMetrics() {
+	ts := newTestSetup()
+
+	// consuming a message feeds the subscription counters
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1:metrics?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"msg_num": 1`)
+
+	// the pulled counters carry the consumption, the pushed ones stay flat
+	suite.Contains(w.Body.String(), `"pulled"`)
+	suite.Contains(w.Body.String(), `"pushed"`)
+
+	// push deliveries land on the pushed counters without touching pulled
+	ts.str.IncrementSubPushed("argo_uuid", "sub4", 2, 64, "2016-10-13T02:19:09Z")
+	ts.str.IncrementSubPushErrors("argo_uuid", "sub4", 1)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub4:metrics?key=S3CR3T4", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"msg_num": 2`)
+	suite.Contains(w.Body.String(), `"error_num": 1`)
+	suite.Contains(w.Body.String(), `"latest_activity": "2016-10-13T02:19:09Z"`)
+
+	// push latency percentiles appear once deliveries were recorded
+	metrics.PushLatency.Record("projects/ARGO/subscriptions/sub4", 5*time.Millisecond)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub4:metrics?key=S3CR3T4", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"push_latency"`)
+	suite.Contains(w.Body.String(), `"p99_ms": 5`)
+
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/nosuchsub:metrics?key=S3CR3T1", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestSubDiagnose() {
+	ts := newTestSetup()
+
+	// sub1 points to an existing topic with a valid offset
+	w := ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1:diagnose?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"name": "topic_exists",
+         "ok": true`)
+	suite.Contains(w.Body.String(), `"name": "offset_in_range",
+         "ok": true`)
+
+	// sub4 is attached to a topic that no longer exists
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub4:diagnose?key=S3CR3T4", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"status": "unhealthy"`)
+	suite.Contains(w.Body.String(), "attached topic no longer exists")
+	// the mock sender accepts the probe of the push endpoint
+	suite.Contains(w.Body.String(), `"name": "push_endpoint_reachable",
+         "ok": true`)
+
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/nosuchsub:diagnose?key=S3CR3T1", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestACLExpiration() {
+	ts := newTestSetup()
+
+	// grant UserA access to topic1 with an expiry already in the past
+	past := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	w := ts.request("POST", "/v1/projects/ARGO/topics/topic1:modifyAcl?key=S3CR3T7",
+		`{"authorized_users": ["UserA"], "expirations": {"UserA": "`+past+`"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// the expiry shows up in the acl and the grant is already denied
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1:acl?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"UserA": "`+past+`"`)
+
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "ZXhwaXJlZA=="}]}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	// the sweep removes the grant and emits an audit event
+	suite.Equal(1, auth.SweepExpiredGrants(time.Now(), ts.str))
+	acl, err := ts.str.QueryACL("argo_uuid", "topics", "topic1")
+	suite.NoError(err)
+	suite.NotContains(acl.ACL, "uuid1")
+
+	// malformed timestamps and dangling expirations are rejected
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:modifyAcl?key=S3CR3T7",
+		`{"authorized_users": ["UserA"], "expirations": {"UserA": "tomorrow"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:modifyAcl?key=S3CR3T7",
+		`{"authorized_users": ["UserA"], "expirations": {"UserB": "`+past+`"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestMembershipExpiration() {
+	ts := newTestSetup()
+
+	// memberships may carry an expiry too; a past one is swept away
+	past := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	w := ts.request("PUT", "/v1/users/UserX?key=S3CR3T8",
+		`{"projects": [{"project": "ARGO", "roles": ["publisher"], "expires_on": "`+past+`"}], "email": "foo-x@email.com"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"expires_on": "`+past+`"`)
+
+	suite.Equal(1, auth.SweepExpiredGrants(time.Now(), ts.str))
+	roles, _ := ts.str.GetUserRoles("argo_uuid", "S3CR3T3")
+	suite.Empty(roles)
+
+	// an invalid expiry on a membership is rejected at update time
+	w = ts.request("PUT", "/v1/users/UserX?key=S3CR3T8",
+		`{"projects": [{"project": "ARGO", "roles": ["publisher"], "expires_on": "never"}], "email": "foo-x@email.com"}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestTokenExpirationRotation() {
+	ts := newTestSetup()
+
+	// an expiry in the past on UserA's token turns it away with a
+	// distinct error so clients know to rotate rather than retry
+	past := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	w := ts.request("PUT", "/v1/users/UserA?key=S3CR3T8", `{"token_expires_on": "`+past+`"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"token_expires_on": "`+past+`"`)
+
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T1", "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+	suite.Contains(w.Body.String(), "TOKEN_EXPIRED")
+
+	// rotation hands out a new token while the old one keeps working
+	// for the grace period
+	w = ts.request("POST", "/v1/users/UserAdmin:rotateToken?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	rotated, err := auth.GetUserFromJSON(w.Body.Bytes())
+	suite.NoError(err)
+	suite.NotEqual("S3CR3T8", rotated.Token)
+
+	w = ts.request("GET", "/v1/users?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/users?key="+rotated.Token, "")
+	suite.Equal(http.StatusOK, w.Code)
+
+	// a malformed expiry is rejected at update time
+	w = ts.request("PUT", "/v1/users/UserX?key="+rotated.Token, `{"token_expires_on": "never"}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// a refresh cuts off the rotated-out token right away
+	w = ts.request("POST", "/v1/users/UserAdmin:refreshToken?key="+rotated.Token, "")
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/users?key=S3CR3T8", "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestProjectMembers() {
+	ts := newTestSetup()
+
+	// a project admin sees the members of their project without tokens,
+	// service roles or memberships of other tenants
+	w := ts.request("GET", "/v1/projects/ARGO/members?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"name": "UserA"`)
+	suite.NotContains(w.Body.String(), "S3CR3T")
+	suite.NotContains(w.Body.String(), "ARGO2")
+
+	// the same admin cannot reach another project
+	w = ts.request("GET", "/v1/projects/ARGO2/members?key=S3CR3T7", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	// onboarding a member grants roles only inside this project and
+	// returns the token once
+	w = ts.request("POST", "/v1/projects/ARGO/members/tenantuser?key=S3CR3T7",
+		`{"email": "tenant@example.org", "roles": ["consumer"]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"token"`)
+	users, _ := auth.FindUsers("", "", "tenantuser", ts.str)
+	suite.Equal(1, len(users.One().Projects))
+	suite.Equal("ARGO", users.One().Projects[0].Project)
+	suite.Equal(0, len(users.One().ServiceRoles))
+
+	// changing roles touches only the membership of this project
+	w = ts.request("POST", "/v1/projects/ARGO/members/tenantuser:modifyRoles?key=S3CR3T7",
+		`{"roles": ["publisher", "consumer"]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"publisher"`)
+
+	// service wide or unknown roles cannot be granted through the member
+	// endpoints, so a project admin cannot escalate a tenant account
+	w = ts.request("POST", "/v1/projects/ARGO/members/escalated?key=S3CR3T7",
+		`{"roles": ["service_admin"]}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	users, _ = auth.FindUsers("", "", "escalated", ts.str)
+	suite.True(users.Empty())
+	w = ts.request("POST", "/v1/projects/ARGO/members/tenantuser:modifyRoles?key=S3CR3T7",
+		`{"roles": ["service_admin"]}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/members/tenantuser:modifyRoles?key=S3CR3T7",
+		`{"roles": ["nosuchrole"]}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// removing a member keeps the account alive without the membership
+	w = ts.request("POST", "/v1/projects/ARGO/members/tenantuser:remove?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	users, _ = auth.FindUsers("", "", "tenantuser", ts.str)
+	suite.Equal(0, len(users.One().Projects))
+	w = ts.request("GET", "/v1/projects/ARGO/members/tenantuser?key=S3CR3T7", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+
+	// plain project roles cannot manage members
+	w = ts.request("GET", "/v1/projects/ARGO/members?key=S3CR3T1", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestUserListFilters() {
+	ts := newTestSetup()
+
+	// project membership filter
+	w := ts.request("GET", "/v1/users?project=ARGO2&key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.NotContains(w.Body.String(), `"name": "UserP"`)
+
+	// service role filter
+	w = ts.request("GET", "/v1/users?service_role=service_admin&key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"name": "UserAdmin"`)
+	suite.NotContains(w.Body.String(), `"name": "UserA"`)
+
+	// email substring is matched case-insensitively
+	w = ts.request("GET", "/v1/users?email=TEST%40&key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"name": "Test"`)
+	suite.NotContains(w.Body.String(), `"name": "UserA"`)
+
+	// name prefix and creator can be combined
+	w = ts.request("GET", "/v1/users?name_prefix=UserB&created_by=UserA&key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"name": "UserB"`)
+	suite.NotContains(w.Body.String(), `"name": "UserX"`)
+
+	// unknown filter targets answer not found
+	w = ts.request("GET", "/v1/users?project=NOSUCH&key=S3CR3T8", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+	w = ts.request("GET", "/v1/users?created_by=nosuch&key=S3CR3T8", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestUserSuspension() {
+	ts := newTestSetup()
+
+	// suspending a user rejects its token with a distinct error while
+	// the account and its grants stay in place
+	w := ts.request("POST", "/v1/users/UserA:suspend?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"suspended": true`)
+
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T1", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+	suite.Contains(w.Body.String(), "USER_SUSPENDED")
+	suite.Contains(w.Body.String(), "Account is suspended")
+
+	users, err := auth.FindUsers("", "", "UserA", ts.str)
+	suite.NoError(err)
+	suite.True(users.One().Suspended)
+	suite.NotEqual(0, len(users.One().Projects))
+
+	// activation restores access
+	w = ts.request("POST", "/v1/users/UserA:activate?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.NotContains(w.Body.String(), `"suspended"`)
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+
+	// admin only, and unknown users are a 404
+	w = ts.request("POST", "/v1/users/UserA:suspend?key=S3CR3T7", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+	w = ts.request("POST", "/v1/users/nosuch:suspend?key=S3CR3T8", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestUserBulkImport() {
+	ts := newTestSetup()
+
+	// a batch with a broken entry creates nothing and reports every
+	// entry, so the caller can fix and resubmit the whole file
+	batch := `{"users": [
+		{"name": "import1", "email": "import1@example.org", "projects": [{"project": "ARGO", "roles": ["consumer"]}]},
+		{"name": "import2", "projects": [{"project": "NOSUCH", "roles": ["consumer"]}]}
+	]}`
+	w := ts.request("POST", "/v1/users:import?key=S3CR3T8", batch)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), `"status": "ok"`)
+	suite.Contains(w.Body.String(), "Project NOSUCH doesn't exist")
+	users, _ := auth.FindUsers("", "", "import1", ts.str)
+	suite.True(users.Empty())
+
+	// a clean batch creates every user with its grants and tokens
+	batch = `{"users": [
+		{"name": "import1", "email": "import1@example.org", "projects": [{"project": "ARGO", "roles": ["consumer"]}]},
+		{"name": "import2", "projects": [{"project": "ARGO2", "roles": ["publisher", "consumer"]}], "service_roles": ["service_admin"]}
+	]}`
+	w = ts.request("POST", "/v1/users:import?key=S3CR3T8", batch)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"name": "import1"`)
+	suite.Contains(w.Body.String(), `"token"`)
+	users, _ = auth.FindUsers("", "", "import2", ts.str)
+	suite.Equal(1, len(users.List))
+	suite.Equal([]string{"service_admin"}, users.List[0].ServiceRoles)
+
+	// duplicate names inside one batch are rejected up front
+	batch = `{"users": [{"name": "twice"}, {"name": "twice"}]}`
+	w = ts.request("POST", "/v1/users:import?key=S3CR3T8", batch)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Duplicate entry in the batch")
+
+	// the endpoint is admin only and an empty batch is invalid
+	w = ts.request("POST", "/v1/users:import?key=S3CR3T7", batch)
+	suite.Equal(http.StatusForbidden, w.Code)
+	w = ts.request("POST", "/v1/users:import?key=S3CR3T8", `{"users": []}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestUserNamedTokens() {
+	ts := newTestSetup()
+
+	// create a named key for UserA; the value comes back only this once
+	w := ts.request("POST", "/v1/users/UserA/tokens/agent-1?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	created := auth.UserToken{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &created))
+	suite.Equal("agent-1", created.Name)
+	suite.NotEmpty(created.Token)
+
+	// the named key authenticates like the primary token
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key="+created.Token, "")
+	suite.Equal(http.StatusOK, w.Code)
+
+	// duplicate names conflict and listings omit the values
+	w = ts.request("POST", "/v1/users/UserA/tokens/agent-1?key=S3CR3T8", "")
+	suite.Equal(http.StatusConflict, w.Code)
+	w = ts.request("POST", "/v1/users/UserA/tokens/agent-2?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/users/UserA/tokens?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"agent-1"`)
+	suite.Contains(w.Body.String(), `"agent-2"`)
+	suite.NotContains(w.Body.String(), created.Token)
+
+	// an expired named key is rejected with the distinct error
+	past := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	w = ts.request("POST", "/v1/users/UserA/tokens/expired?key=S3CR3T8", `{"expires_on": "`+past+`"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	expired := auth.UserToken{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &expired))
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key="+expired.Token, "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+	suite.Contains(w.Body.String(), "TOKEN_EXPIRED")
+
+	// revoking one key cuts off that key alone
+	w = ts.request("DELETE", "/v1/users/UserA/tokens/agent-1?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key="+created.Token, "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+
+	w = ts.request("DELETE", "/v1/users/UserA/tokens/agent-1?key=S3CR3T8", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestCustomRoles() {
+	ts := newTestSetup()
+
+	// definitions may only reference known routes and not shadow the
+	// wired-in role names
+	w := ts.request("POST", "/v1/roles/auditor?key=S3CR3T8", `{"routes": ["topics:nosuchroute"]}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("POST", "/v1/roles/publisher?key=S3CR3T8", `{"routes": ["topics:list"]}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// define a read-only role and assign it to a new user
+	w = ts.request("POST", "/v1/roles/auditor?key=S3CR3T8", `{"routes": ["topics:list", "subscriptions:list"]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/users/auditor1?key=S3CR3T8",
+		`{"projects": [{"project": "ARGO", "roles": ["auditor"]}], "email": "auditor@example.com"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	created := struct {
+		Token string `json:"token"`
+	}{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &created))
+
+	// the stored definition opens exactly its routes
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key="+created.Token, "")
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions?key="+created.Token, "")
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key="+created.Token,
+		`{"messages": [{"data": "YmFzZTY0"}]}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	// narrowing the definition takes effect and deleting it drops access
+	w = ts.request("PUT", "/v1/roles/auditor?key=S3CR3T8", `{"routes": ["topics:list"]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions?key="+created.Token, "")
+	suite.Equal(http.StatusForbidden, w.Code)
+	w = ts.request("DELETE", "/v1/roles/auditor?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key="+created.Token, "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestTopicPublishKeys() {
+	ts := newTestSetup()
+
+	// a publisher in the topic acl can mint a key for it
+	w := ts.request("POST", "/v1/projects/ARGO/topics/topic1/keys/sensor-fleet?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	created := struct {
+		Token string `json:"token"`
+	}{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &created))
+	suite.NotEmpty(created.Token)
+
+	// the key publishes to its topic only and opens nothing else
+	body := `{"messages": [{"data": "YmFzZTY0"}]}`
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key="+created.Token, body)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic2:publish?key="+created.Token, body)
+	suite.Equal(http.StatusUnauthorized, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1?key="+created.Token, "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key="+created.Token, `{"maxMessages": "1"}`)
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	// duplicate key names conflict and listings omit the token value
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1/keys/sensor-fleet?key=S3CR3T1", "")
+	suite.Equal(http.StatusConflict, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1/keys?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "sensor-fleet")
+	suite.NotContains(w.Body.String(), created.Token)
+
+	// a publisher outside the topic acl cannot manage its keys
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic3/keys?key=S3CR3T1", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	// a revoked key stops publishing
+	w = ts.request("DELETE", "/v1/projects/ARGO/topics/topic1/keys/sensor-fleet?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key="+created.Token, body)
+	suite.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestServiceAccounts() {
+	ts := newTestSetup()
+
+	// a scope naming an unknown route is rejected
+	w := ts.request("PUT", "/v1/projects/ARGO/serviceAccounts/ingest?key=S3CR3T7",
+		`{"scopes": [{"route": "users:create"}]}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// create a publish-only account pinned to topic1
+	w = ts.request("PUT", "/v1/projects/ARGO/serviceAccounts/ingest?key=S3CR3T7",
+		`{"scopes": [{"route": "topics:publish", "resource": "topic1"}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	created := struct {
+		Token string `json:"token"`
+	}{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &created))
+	suite.NotEmpty(created.Token)
+
+	// the token may publish to the scoped topic only
+	body := `{"messages": [{"data": "YmFzZTY0"}]}`
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key="+created.Token, body)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic2:publish?key="+created.Token, body)
+	suite.Equal(http.StatusForbidden, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key="+created.Token, `{"maxMessages": "1"}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+
+	// the token holds no scope in another project
+	w = ts.request("POST", "/v1/projects/ARGO2/topics/topic1:publish?key="+created.Token, body)
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	// listings omit the token value
+	w = ts.request("GET", "/v1/projects/ARGO/serviceAccounts?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "ingest")
+	suite.NotContains(w.Body.String(), created.Token)
+
+	// widening the scopes takes effect
+	w = ts.request("POST", "/v1/projects/ARGO/serviceAccounts/ingest?key=S3CR3T7",
+		`{"scopes": [{"route": "topics:publish"}, {"route": "subscriptions:pull", "resource": "sub1"}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic2:publish?key="+created.Token, body)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key="+created.Token, `{"maxMessages": "1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// a removed account loses access entirely
+	w = ts.request("DELETE", "/v1/projects/ARGO/serviceAccounts/ingest?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key="+created.Token, body)
+	suite.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestProjectQuotas() {
+	ts := newTestSetup()
+
+	// the project resource exposes its live resource counts
+	w := ts.request("GET", "/v1/projects/ARGO?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	prj, err := projects.GetFromJSON(w.Body.Bytes())
+	suite.NoError(err)
+	suite.True(prj.TopicsCount > 0)
+	suite.True(prj.SubscriptionsCount > 0)
+
+	// cap the project at its current usage; further creations get a
+	// dedicated quota error carrying the usage and the limit
+	w = ts.request("PUT", "/v1/projects/ARGO?key=S3CR3T8",
+		fmt.Sprintf(`{"max_topics": %v, "max_subscriptions": %v}`, prj.TopicsCount, prj.SubscriptionsCount))
+	suite.Equal(http.StatusOK, w.Code)
+
+	w = ts.request("PUT", "/v1/projects/ARGO/topics/overquota?key=S3CR3T7", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+	suite.Contains(w.Body.String(), "QUOTA_EXCEEDED")
+	suite.Contains(w.Body.String(), fmt.Sprintf(`"limit": %v`, prj.TopicsCount))
+	suite.Contains(w.Body.String(), fmt.Sprintf(`"used": %v`, prj.TopicsCount))
+
+	w = ts.request("PUT", "/v1/projects/ARGO/subscriptions/overquota?key=S3CR3T7",
+		`{"topic": "projects/ARGO/topics/topic1"}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+	suite.Contains(w.Body.String(), "QUOTA_EXCEEDED")
+
+	// raising the quota lets the creation through and the count moves
+	w = ts.request("PUT", "/v1/projects/ARGO?key=S3CR3T8",
+		fmt.Sprintf(`{"max_topics": %v}`, prj.TopicsCount+1))
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("PUT", "/v1/projects/ARGO/topics/overquota?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+
+	w = ts.request("GET", "/v1/projects/ARGO?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), fmt.Sprintf(`"topics_count": %v`, prj.TopicsCount+1))
+}
+
+func (suite *HandlersTestSuite) TestCORS() {
+	ts := newTestSetup()
+	ts.cfg.LoadStrJSON(`{"kafka_clusters": {"main": ["localhost:9092"]},
+		"cors_allowed_origins": ["https://ui.example.org"]}`)
+	ts.api = NewRouting(ts.cfg, ts.pool, ts.str, push.NewProvider(ts.cfg, ts.mgr, ts.pc))
+
+	// a preflight from an allowed origin gets the full answer
+	req := httptest.NewRequest("OPTIONS", "/v1/projects", nil)
+	req.Header.Set("Origin", "https://ui.example.org")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	w := httptest.NewRecorder()
+	ts.api.Router.ServeHTTP(w, req)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal("https://ui.example.org", w.Header().Get("Access-Control-Allow-Origin"))
+	suite.Contains(w.Header().Get("Access-Control-Allow-Methods"), "GET")
+	suite.Contains(w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+	suite.Equal("3600", w.Header().Get("Access-Control-Max-Age"))
+
+	// an origin outside the allow list gets no cors headers back
+	req = httptest.NewRequest("OPTIONS", "/v1/projects", nil)
+	req.Header.Set("Origin", "https://evil.example.org")
+	w = httptest.NewRecorder()
+	ts.api.Router.ServeHTTP(w, req)
+	suite.Equal("", w.Header().Get("Access-Control-Allow-Origin"))
+
+	// actual requests from an allowed origin carry the origin header
+	req = httptest.NewRequest("GET", "/v1/projects?key=S3CR3T8", nil)
+	req.Header.Set("Origin", "https://ui.example.org")
+	w = httptest.NewRecorder()
+	ts.api.Router.ServeHTTP(w, req)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal("https://ui.example.org", w.Header().Get("Access-Control-Allow-Origin"))
+	suite.Equal("X-Request-Id", w.Header().Get("Access-Control-Expose-Headers"))
+
+	// without configured origins preflights find no route
+	ts = newTestSetup()
+	req = httptest.NewRequest("OPTIONS", "/v1/projects", nil)
+	req.Header.Set("Origin", "https://ui.example.org")
+	w = httptest.NewRecorder()
+	ts.api.Router.ServeHTTP(w, req)
+	suite.NotEqual(http.StatusOK, w.Code)
+	suite.Equal("", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func (suite *HandlersTestSuite) TestProjectDailyQuotas() {
+	ts := newTestSetup()
+
+	// only the service admin may set quotas
+	w := ts.request("POST", "/v1/projects/ARGO:modifyQuotas?key=S3CR3T7",
+		`{"max_daily_messages": 5}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO:modifyQuotas?key=S3CR3T8",
+		`{"max_daily_messages": -1}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	w = ts.request("POST", "/v1/projects/ARGO:modifyQuotas?key=S3CR3T8",
+		`{"max_topics": 100, "max_subscriptions": 100, "max_daily_messages": 2, "max_daily_bytes": 1000}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"max_daily_messages": 2`)
+
+	// publishing counts against the daily usage of the project
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "YQ=="}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// a batch that would push the usage over the message cap is refused
+	// whole, with the usage and the limit in the error details
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "YQ=="}, {"data": "YQ=="}]}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+	suite.Contains(w.Body.String(), "QUOTA_EXCEEDED")
+	suite.Contains(w.Body.String(), "daily messages")
+	suite.Contains(w.Body.String(), `"used": 1`)
+	suite.Contains(w.Body.String(), `"limit": 2`)
+
+	// a single message still fits
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "YQ=="}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// the quota view reports the limits next to the live usage
+	w = ts.request("GET", "/v1/projects/ARGO:quotas?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"max_daily_messages": 2`)
+	suite.Contains(w.Body.String(), `"daily_messages": 2`)
+	suite.Contains(w.Body.String(), `"daily_bytes": 8`)
+
+	// the byte cap is enforced the same way
+	w = ts.request("POST", "/v1/projects/ARGO:modifyQuotas?key=S3CR3T8",
+		`{"max_daily_messages": 100, "max_daily_bytes": 10}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "YQ=="}]}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+	suite.Contains(w.Body.String(), "daily bytes")
+
+	// clearing the caps lifts the enforcement
+	w = ts.request("POST", "/v1/projects/ARGO:modifyQuotas?key=S3CR3T8", `{}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "YQ=="}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestJSONPatch() {
+	ts := newTestSetup()
+
+	// remove a single grant atomically, guarded by a test op against
+	// the current acl
+	patch := `[{"op": "test", "path": "/authorized_users/0", "value": "UserA"},
+		{"op": "remove", "path": "/authorized_users/0"}]`
+	w := ts.requestWithType("POST", "/v1/projects/ARGO/topics/topic1:modifyAcl?key=S3CR3T7",
+		patch, "application/json-patch+json")
+	suite.Equal(http.StatusOK, w.Code)
+
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1:acl?key=S3CR3T7", "")
+	suite.NotContains(w.Body.String(), "UserA")
+	suite.Contains(w.Body.String(), "UserB")
+
+	// a failed test op rejects the patch and leaves the acl untouched
+	w = ts.requestWithType("POST", "/v1/projects/ARGO/topics/topic1:modifyAcl?key=S3CR3T7",
+		patch, "application/json-patch+json")
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "patch test failed")
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1:acl?key=S3CR3T7", "")
+	suite.Contains(w.Body.String(), "UserB")
+
+	// the "-" index appends to the list
+	w = ts.requestWithType("POST", "/v1/projects/ARGO/topics/topic1:modifyAcl?key=S3CR3T7",
+		`[{"op": "add", "path": "/authorized_users/-", "value": "UserA"}]`, "application/json-patch+json")
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1:acl?key=S3CR3T7", "")
+	suite.Contains(w.Body.String(), "UserA")
+
+	// user and project updates accept the same content type
+	w = ts.requestWithType("PUT", "/v1/users/UserX?key=S3CR3T8",
+		`[{"op": "replace", "path": "/email", "value": "patched@email.com"}]`, "application/json-patch+json")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "patched@email.com")
+
+	w = ts.requestWithType("PUT", "/v1/projects/ARGO?key=S3CR3T8",
+		`[{"op": "replace", "path": "/description", "value": "patched description"}]`, "application/json-patch+json")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "patched description")
+
+	// unsupported ops and malformed pointers are rejected
+	w = ts.requestWithType("POST", "/v1/projects/ARGO/topics/topic1:modifyAcl?key=S3CR3T7",
+		`[{"op": "move", "path": "/authorized_users/0"}]`, "application/json-patch+json")
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.requestWithType("POST", "/v1/projects/ARGO/topics/topic1:modifyAcl?key=S3CR3T7",
+		`[{"op": "remove", "path": "authorized_users"}]`, "application/json-patch+json")
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestUsageReport() {
+	ts := newTestSetup()
+
+	// tag the first project with a cost center, the second stays untagged
+	w := ts.request("PUT", "/v1/projects/ARGO?key=S3CR3T8",
+		`{"tags": {"cost-center": "physics"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"cost-center": "physics"`)
+
+	// generate some traffic so the byte counters move
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "cmVwb3J0ZWQ="}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pull?key=S3CR3T1", `{"maxMessages": "1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// the rollup groups the traffic under the tag value
+	w = ts.request("GET", "/v1/metrics:usageReport?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"tag_key": "cost-center"`)
+	suite.Contains(w.Body.String(), `"tag": "physics"`)
+	suite.Contains(w.Body.String(), `"ARGO"`)
+	suite.Contains(w.Body.String(), `"tag": "untagged"`)
+
+	report := struct {
+		Report []struct {
+			Tag          string `json:"tag"`
+			PublishBytes int64  `json:"publish_bytes"`
+			ConsumeBytes int64  `json:"consume_bytes"`
+		} `json:"report"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &report)
+	suite.Equal(2, len(report.Report))
+	suite.Equal("physics", report.Report[0].Tag)
+	suite.Greater(report.Report[0].PublishBytes, int64(0))
+	suite.Greater(report.Report[0].ConsumeBytes, int64(0))
+
+	// only service admins get the report
+	w = ts.request("GET", "/v1/metrics:usageReport?key=S3CR3T7", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestAlertRules() {
+	ts := newTestSetup()
+
+	// the rendered rules carry the default thresholds
+	w := ts.request("GET", "/v1/metrics:alertRules?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal("application/x-yaml", w.Header().Get("Content-Type"))
+	suite.Contains(w.Body.String(), "ams_subscription_lag_messages > 1000")
+	suite.Contains(w.Body.String(), "rate(ams_push_failures_total[5m]) * 60 > 10")
+	suite.Contains(w.Body.String(), "ams_project_quota_usage_ratio * 100 > 90")
+	suite.Contains(w.Body.String(), "{{ $labels.subscription }}")
+
+	// configured thresholds flow into the rendered rules
+	ts.cfg.AlertSubscriptionLag = 5000
+	w = ts.request("GET", "/v1/metrics:alertRules?key=S3CR3T8", "")
+	suite.Contains(w.Body.String(), "ams_subscription_lag_messages > 5000")
+
+	// only service admins may export the rules
+	w = ts.request("GET", "/v1/metrics:alertRules?key=S3CR3T7", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestProjectMetrics() {
+	ts := newTestSetup()
+
+	w := ts.request("GET", "/v1/projects/ARGO:metrics?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"project": "ARGO"`)
+	suite.Contains(w.Body.String(), `"topics_number": 3`)
+	suite.Contains(w.Body.String(), `"subscriptions_number": 4`)
+	suite.Contains(w.Body.String(), `"users_number": 6`)
+
+	// UserA is in the acl of two topics and three subscriptions
+	suite.Contains(w.Body.String(), `"user": "UserA",
+         "topics_number": 2,
+         "subscriptions_number": 3`)
+
+	// within the ttl the cached response is served, so new traffic does
+	// not show up yet
+	ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "bWV0cmljcw=="}]}`)
+	w = ts.request("GET", "/v1/projects/ARGO:metrics?key=S3CR3T7", "")
+	suite.Contains(w.Body.String(), `"publish_bytes": 0`)
+
+	// publishers and consumers have no access to the project rollup
+	w = ts.request("GET", "/v1/projects/ARGO:metrics?key=S3CR3T1", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestTopicPublishDedup() {
+	ts := newTestSetup()
+
+	// the first publish with a dedup id lands as usual on offset 4
+	body := `{"messages": [{"data": "ZGVkdXA=", "dedupId": "order-42"}]}`
+	w := ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1", body)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"4"`)
+
+	// a retry with the same dedup id returns the original id and
+	// produces nothing new
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1", body)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"4"`)
+	suite.Equal(5, len(ts.brk.MsgList["argo_uuid.topic1"]))
+
+	// the suppressed duplicate does not feed the topic counters
+	topics, _, _, _ := ts.str.QueryTopics("argo_uuid", "", "topic1", "", 0, "", false)
+	suite.Equal(int64(1), topics[0].MsgNum)
+
+	// a different dedup id publishes normally
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "ZGVkdXA=", "dedupId": "order-43"}]}`)
+	suite.Contains(w.Body.String(), `"5"`)
+}
+
+func (suite *HandlersTestSuite) TestTopicPublishDelayed() {
+	ts := newTestSetup()
+
+	// a future publishTime holds the message back from the broker
+	due := time.Now().Add(250 * time.Millisecond).UTC().Format(time.RFC3339Nano)
+	w := ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "bGF0ZXI=", "publishTime": "`+due+`"}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"scheduled"`)
+	suite.Equal(1, delayedPublishes.pendingCount())
+	suite.Equal(4, len(ts.brk.MsgList["argo_uuid.topic1"]))
+
+	// once due, the message reaches the broker and feeds the counters
+	time.Sleep(500 * time.Millisecond)
+	suite.Equal(0, delayedPublishes.pendingCount())
+	suite.Equal(5, len(ts.brk.MsgList["argo_uuid.topic1"]))
+	topics, _, _, _ := ts.str.QueryTopics("argo_uuid", "", "topic1", "", 0, "", false)
+	suite.Equal(int64(1), topics[0].MsgNum)
+
+	// a zero deliverAfter delay publishes right away
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"attributes": {"deliverAfter": "0"}, "data": "bm93"}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"5"`)
+
+	// malformed delivery attributes are rejected before anything publishes
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"attributes": {"deliverAfter": "soon"}, "data": "bm93"}]}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "bm93", "publishTime": "tomorrow"}]}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestTopicMetricsDailyCounts() {
+	ts := newTestSetup()
+
+	// publish a message and fold the counters into today's bucket
+	ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "ZGFpbHk="}]}`)
+	suite.Equal(1, topics.PrecomputeDailyCounts(time.Now(), ts.str))
+
+	today := time.Now().UTC().Format("2006-01-02")
+	w := ts.request("GET", "/v1/projects/ARGO/topics/topic1:metrics?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"msg_num": 1`)
+	suite.Contains(w.Body.String(), `"date": "`+today+`"`)
+	suite.Contains(w.Body.String(), `"msg_count": 1`)
+
+	// a rerun without new traffic changes nothing
+	suite.Equal(0, topics.PrecomputeDailyCounts(time.Now(), ts.str))
+
+	// only the delta since the last run lands in the bucket
+	ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "ZGFpbHk="}]}`)
+	suite.Equal(1, topics.PrecomputeDailyCounts(time.Now(), ts.str))
+	counts, _ := ts.str.QueryDailyMsgCount("argo_uuid", "topic1")
+	suite.Equal(1, len(counts))
+	suite.Equal(int64(2), counts[0].MsgCount)
+
+	// the project rollup sums the per-topic days; drop the response
+	// cached by earlier tests first
+	projectMetricsCache.Lock()
+	delete(projectMetricsCache.entries, "argo_uuid")
+	projectMetricsCache.Unlock()
+	w = ts.request("GET", "/v1/projects/ARGO:metrics?key=S3CR3T7", "")
+	suite.Contains(w.Body.String(), `"msg_count": 2`)
+
+	w = ts.request("GET", "/v1/projects/ARGO/topics/nosuchtopic:metrics?key=S3CR3T1", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestDerivedMetrics() {
+	ts := newTestSetup()
+
+	ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "ZGVyaXZlZA=="}, {"data": "ZGVyaXZlZA=="}]}`)
+	topics.PrecomputeDailyCounts(time.Now(), ts.str)
+
+	w := ts.request("GET", "/v1/projects/ARGO/topics/topic1:metrics?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	response := struct {
+		Derived struct {
+			MsgRatePerSec          float64 `json:"msg_rate_per_sec"`
+			TrendSevenDayPct       float64 `json:"trend_seven_day_pct"`
+			ProjectedDailyMsgCount int64   `json:"projected_daily_msg_count"`
+		} `json:"derived"`
+	}{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	suite.True(response.Derived.MsgRatePerSec > 0)
+	suite.True(response.Derived.ProjectedDailyMsgCount >= 1)
+
+	// the project rollup derives the same series over all its topics
+	projectMetricsCache.Lock()
+	delete(projectMetricsCache.entries, "argo_uuid")
+	projectMetricsCache.Unlock()
+	w = ts.request("GET", "/v1/projects/ARGO:metrics?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"msg_rate_per_sec"`)
+	suite.Contains(w.Body.String(), `"trend_seven_day_pct"`)
+	suite.Contains(w.Body.String(), `"projected_daily_msg_count"`)
+
+	// drop the cached response so later tests compute their own
+	projectMetricsCache.Lock()
+	delete(projectMetricsCache.entries, "argo_uuid")
+	projectMetricsCache.Unlock()
+}
+
+func (suite *HandlersTestSuite) TestMetricsCSVExport() {
+	ts := newTestSetup()
+
+	ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "Y3N2"}]}`)
+	topics.PrecomputeDailyCounts(time.Now(), ts.str)
+	today := time.Now().UTC().Format("2006-01-02")
+
+	w := ts.request("GET", "/v1/projects/ARGO/topics/topic1:metrics?key=S3CR3T1&format=csv", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal("text/csv", w.Header().Get("Content-Type"))
+	suite.Contains(w.Header().Get("Content-Disposition"), "topic1-metrics.csv")
+	suite.Contains(w.Body.String(), "date,msg_count\n")
+	suite.Contains(w.Body.String(), today+",1\n")
+
+	// the time range bounds the exported series
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1:metrics?key=S3CR3T1&format=csv&start=2000-01-01&end=2000-01-31", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal("date,msg_count\n", w.Body.String())
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1:metrics?key=S3CR3T1&format=csv&start=bogus", "")
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1:metrics?key=S3CR3T1&start=2020-01-02&end=2020-01-01", "")
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// project and ops metrics export the same way
+	w = ts.request("GET", "/v1/projects/ARGO:metrics?key=S3CR3T7&format=csv", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Header().Get("Content-Disposition"), "ARGO-metrics.csv")
+	suite.Contains(w.Body.String(), today+",1\n")
+
+	w = ts.request("GET", "/v1/metrics?key=S3CR3T8&format=csv", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), "project,count,min_ms,mean_ms,p50_ms,p95_ms,p99_ms,max_ms\n")
+}
+
+func (suite *HandlersTestSuite) TestTopicPublishCloudEvents() {
+	ts := newTestSetup()
+
+	// structured mode, the context attributes land as message attributes
+	req := httptest.NewRequest("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		strings.NewReader(`{"specversion": "1.0", "id": "order-1", "source": "/shop",
+			"type": "com.example.order", "data": {"item": "disk"}}`))
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	w := httptest.NewRecorder()
+	ts.api.Router.ServeHTTP(w, req)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"messageIds"`)
+	stored := ts.brk.MsgList["argo_uuid.topic1"][4]
+	suite.Contains(stored, `"ce-id":"order-1"`)
+	suite.Contains(stored, `"ce-source":"/shop"`)
+
+	// binary mode, the context travels in ce- headers and the body is the data
+	req = httptest.NewRequest("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		strings.NewReader(`{"item": "tape"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ce-Specversion", "1.0")
+	req.Header.Set("Ce-Id", "order-2")
+	req.Header.Set("Ce-Source", "/shop")
+	req.Header.Set("Ce-Type", "com.example.order")
+	w = httptest.NewRecorder()
+	ts.api.Router.ServeHTTP(w, req)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(ts.brk.MsgList["argo_uuid.topic1"][5], `"ce-id":"order-2"`)
+
+	// an event missing its required context is rejected
+	req = httptest.NewRequest("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		strings.NewReader(`{"specversion": "1.0", "source": "/shop"}`))
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	w = httptest.NewRecorder()
+	ts.api.Router.ServeHTTP(w, req)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// the push config flag switching deliveries to cloudevents envelopes
+	// round-trips through the subscription resource
+	w2 := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/recv", "cloudEvents": true}}`)
+	suite.Equal(http.StatusOK, w2.Code)
+	w2 = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w2.Body.String(), `"cloudEvents": true`)
+}
+
+func (suite *HandlersTestSuite) TestScimProvisioning() {
+	ts := newTestSetup()
+
+	// lookup by userName, the filter form idm reconciliation uses
+	w := ts.request("GET", "/scim/v2/Users?key=S3CR3T8&filter=userName%20eq%20%22UserA%22", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal("application/scim+json", w.Header().Get("Content-Type"))
+	suite.Contains(w.Body.String(), `"totalResults": 1`)
+	suite.Contains(w.Body.String(), `"id": "uuid1"`)
+
+	// provision a new account; the conflict on replay is reported in
+	// scim error form
+	w = ts.request("POST", "/scim/v2/Users?key=S3CR3T8",
+		`{"userName": "scimuser", "emails": [{"value": "scimuser@example.org"}]}`)
+	suite.Equal(http.StatusCreated, w.Code)
+	createdUser := struct {
+		ID string `json:"id"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &createdUser)
+	suite.NotEqual("", createdUser.ID)
+	suite.Contains(w.Body.String(), `"active": true`)
+	w = ts.request("POST", "/scim/v2/Users?key=S3CR3T8", `{"userName": "scimuser"}`)
+	suite.Equal(http.StatusConflict, w.Code)
+	suite.Contains(w.Body.String(), "urn:ietf:params:scim:api:messages:2.0:Error")
+
+	// group membership drives the project roles
+	w = ts.request("GET", "/scim/v2/Groups/ARGO:publisher?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"value": "uuid1"`)
+
+	w = ts.request("PATCH", "/scim/v2/Groups/ARGO:publisher?key=S3CR3T8",
+		`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		  "Operations": [{"op": "add", "path": "members", "value": [{"value": "`+createdUser.ID+`"}]}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), createdUser.ID)
+	qUsers, _ := ts.str.QueryUsers("", createdUser.ID, "")
+	suite.Equal([]string{"publisher"}, qUsers[0].Projects[0].Roles)
+
+	w = ts.request("PATCH", "/scim/v2/Groups/ARGO:publisher?key=S3CR3T8",
+		`{"Operations": [{"op": "remove", "path": "members[value eq \"`+createdUser.ID+`\"]"}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.NotContains(w.Body.String(), createdUser.ID)
+
+	// deprovisioning removes the account
+	w = ts.request("DELETE", "/scim/v2/Users/"+createdUser.ID+"?key=S3CR3T8", "")
+	suite.Equal(http.StatusNoContent, w.Code)
+	w = ts.request("GET", "/scim/v2/Users/"+createdUser.ID+"?key=S3CR3T8", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+
+	// the provisioning surface is service admin only
+	w = ts.request("GET", "/scim/v2/Users?key=S3CR3T7", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestConfigShow() {
+	ts := newTestSetup()
+
+	// only service admins may inspect the effective configuration
+	w := ts.request("GET", "/v1/config?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"per_resource_auth": true`)
+	suite.Contains(w.Body.String(), `"ack_deadline_sec": 10`)
+
+	w = ts.request("GET", "/v1/config?key=S3CR3T7", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestAuditLogs() {
+	ts := newTestSetup()
+
+	// a mutating control plane call lands in the audit log with its
+	// caller and outcome, including failed attempts
+	ts.request("PUT", "/v1/projects/ARGO/topics/topicAudited?key=S3CR3T7", "")
+	ts.request("PUT", "/v1/projects/ARGO/topics/topic1?key=S3CR3T7", "")
+	// reads and data plane traffic stay out of it
+	ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T1", "")
+	ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "bWVzc2FnZQ=="}]}`)
+
+	w := ts.request("GET", "/v1/auditlogs?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"action": "topics:create"`)
+	suite.Contains(w.Body.String(), `"user_uuid": "uuid7"`)
+	suite.Contains(w.Body.String(), `"project": "ARGO"`)
+	suite.Contains(w.Body.String(), `"path": "/v1/projects/ARGO/topics/topicAudited"`)
+	suite.Contains(w.Body.String(), `"status_code": 409`)
+	suite.NotContains(w.Body.String(), `"topics:publish"`)
+	suite.NotContains(w.Body.String(), `"topics:list"`)
+
+	// filtering by action, user and time range
+	w = ts.request("GET", "/v1/auditlogs?key=S3CR3T8&action=subscriptions:create", "")
+	suite.Contains(w.Body.String(), `"auditLogs": []`)
+	w = ts.request("GET", "/v1/auditlogs?key=S3CR3T8&user=uuid7&project=ARGO", "")
+	suite.Contains(w.Body.String(), `"action": "topics:create"`)
+	w = ts.request("GET", "/v1/auditlogs?key=S3CR3T8&since=2100-01-01T00:00:00Z", "")
+	suite.Contains(w.Body.String(), `"auditLogs": []`)
+	w = ts.request("GET", "/v1/auditlogs?key=S3CR3T8&since=yesterday", "")
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// the audit log is service admin only
+	w = ts.request("GET", "/v1/auditlogs?key=S3CR3T7", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestAdminOverview() {
+	ts := newTestSetup()
+	ts.str.AcquireLock("push", "ams-1.example.org", "2016-10-13T02:19:09Z", "2016-10-13T02:20:09Z")
+	ts.str.IncrementSubPushErrors("argo_uuid", "sub4", 3)
+
+	w := ts.request("GET", "/v1/admin/overview?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"status": "ok"`)
+	suite.Contains(w.Body.String(), `"holder": "ams-1.example.org"`)
+	suite.Contains(w.Body.String(), `"projects": 2`)
+	suite.Contains(w.Body.String(), `"topics": 3`)
+	suite.Contains(w.Body.String(), `"subscriptions": 4`)
+	// topic1 carries four seeded messages none of which sub1 consumed yet
+	suite.Contains(w.Body.String(), `"subscription": "/projects/ARGO/subscriptions/sub1"`)
+	suite.Contains(w.Body.String(), `"lag": 4`)
+	suite.Contains(w.Body.String(), `"endpoint": "endpoint.foo"`)
+	suite.Contains(w.Body.String(), `"error_num": 3`)
+
+	// the overview is part of the service admin surface
+	w = ts.request("GET", "/v1/admin/overview?key=S3CR3T7", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestPushAuthzHeader() {
+	ts := newTestSetup()
+	subscriptions.SetPushAuthzKey("test-key")
+	defer subscriptions.SetPushAuthzKey("")
+
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/recv", "authorizationHeader": "Bearer s3cr3t"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	// the header is encrypted at rest and recovered on read
+	qSubs, _, _, _ := ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	suite.True(strings.HasPrefix(qSubs[0].AuthzHeader, "enc:"))
+	suite.NotContains(qSubs[0].AuthzHeader, "s3cr3t")
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"authorizationHeader": "Bearer s3cr3t"`)
+
+	// the endpoint probe of the diagnose run carries the header
+	ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1:diagnose?key=S3CR3T1", "")
+	suite.Equal("Bearer s3cr3t", ts.sender.LastAuthz)
+}
+
+func (suite *HandlersTestSuite) TestRequestIDPropagation() {
+	ts := newTestSetup()
+	ts.cfg.PushEnabled = true
+	ts.cfg.PushServerHost = "http://localhost:8080"
+
+	// a caller supplied request id is echoed back
+	req := httptest.NewRequest("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		strings.NewReader(`{"pushConfig": {"pushEndpoint": "https://example.com/recv"}}`))
+	req.Header.Set("X-Request-Id", "req-42")
+	w := httptest.NewRecorder()
+	ts.api.Router.ServeHTTP(w, req)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal("req-42", w.Header().Get("X-Request-Id"))
+
+	// activation happens at verification time and carries the id of the
+	// verifying request into the call towards the push server
+	qSubs, _, _, _ := ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	ts.sender.VerificationHashes["https://example.com/recv"] = qSubs[0].VerificationHash
+	req = httptest.NewRequest("POST", "/v1/projects/ARGO/subscriptions/sub1:verifyPushEndpoint?key=S3CR3T7", nil)
+	req.Header.Set("X-Request-Id", "req-42")
+	w = httptest.NewRecorder()
+	ts.api.Router.ServeHTTP(w, req)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(ts.pc.Activated, "argo_uuid/sub1")
+	suite.Equal("req-42", ts.pc.LastRequestID)
+
+	// without one the service assigns an id of its own
+	w2 := ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.NotEmpty(w2.Header().Get("X-Request-Id"))
+}
+
+func (suite *HandlersTestSuite) TestPushEndpointVerification() {
+	ts := newTestSetup()
+	ts.cfg.PushEnabled = true
+
+	// configuring a push endpoint issues a challenge and starts unverified
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/recv"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	qSubs, _, _, _ := ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	suite.NotEmpty(qSubs[0].VerificationHash)
+	suite.False(qSubs[0].Verified)
+	suite.False(ts.mgr.IsActive("argo_uuid", "sub1"))
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"verified": false`)
+
+	// verification fails until the endpoint exposes the challenge
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:verifyPushEndpoint?key=S3CR3T7", "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	ts.sender.VerificationHashes["https://example.com/recv"] = qSubs[0].VerificationHash
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:verifyPushEndpoint?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.True(ts.mgr.IsActive("argo_uuid", "sub1"))
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"verified": true`)
+
+	// a verified endpoint cannot be verified twice
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:verifyPushEndpoint?key=S3CR3T7", "")
+	suite.Equal(http.StatusConflict, w.Code)
+
+	// changing the endpoint resets the verification and stops delivery
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/other"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	qSubs, _, _, _ = ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	suite.False(qSubs[0].Verified)
+	suite.False(ts.mgr.IsActive("argo_uuid", "sub1"))
+
+	ts.mgr.StopAll()
+}
+
+func (suite *HandlersTestSuite) TestProxyHeaders() {
+	seen := ""
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.RemoteAddr
+	})
+	wrapped := WrapProxyHeaders(parseTrustedProxies([]string{"192.0.2.0/24", "198.51.100.7"}))(inner)
+
+	// a trusted balancer forwards the address of the original client
+	req := httptest.NewRequest("GET", "/v1/projects", nil)
+	req.RemoteAddr = "192.0.2.10:33000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.5, 192.0.2.10")
+	wrapped.ServeHTTP(httptest.NewRecorder(), req)
+	suite.Equal("203.0.113.5", seen)
+
+	// direct clients cannot spoof the forwarded headers
+	req = httptest.NewRequest("GET", "/v1/projects", nil)
+	req.RemoteAddr = "203.0.113.99:44000"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	wrapped.ServeHTTP(httptest.NewRecorder(), req)
+	suite.Equal("203.0.113.99:44000", seen)
+	suite.Equal("", req.Header.Get("X-Forwarded-For"))
+
+	// invalid configuration entries are dropped, not trusted
+	suite.Equal(2, len(parseTrustedProxies([]string{"192.0.2.0/24", "notanip", "198.51.100.7"})))
+}
+
+func (suite *HandlersTestSuite) TestProbes() {
+	ts := newTestSetup()
+
+	// liveness answers without consulting any dependency
+	w := ts.request("GET", "/healthz", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"status": "alive"`)
+
+	// readiness verifies the store and every broker cluster
+	w = ts.request("GET", "/readyz", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"status": "ok"`)
+	suite.Contains(w.Body.String(), `"store": "ok"`)
+	suite.Contains(w.Body.String(), `"broker/mock": "ok"`)
+}
+
+func (suite *HandlersTestSuite) TestStatusHistory() {
+	ts := newTestSetup()
+
+	// the live status reports the health of every component
+	w := ts.request("GET", "/v1/status", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"status": "ok"`)
+	suite.Contains(w.Body.String(), `"store": "ok"`)
+	suite.Contains(w.Body.String(), `"broker/mock": "ok"`)
+
+	// persisted snapshots are served newest first within the window
+	now := time.Now().UTC()
+	prv := push.NewProvider(ts.cfg, ts.mgr, ts.pc)
+	old := status.Capture(now.Add(-48*time.Hour), ts.pool, ts.str, prv, "")
+	ts.str.InsertStatusSnapshot(old)
+	recent := status.Capture(now.Add(-time.Hour), ts.pool, ts.str, prv, "")
+	recent.Status = "degraded"
+	recent.Components["store"] = "connection refused"
+	ts.str.InsertStatusSnapshot(recent)
+
+	w = ts.request("GET", "/v1/status/history?key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"degraded"`)
+	suite.Contains(w.Body.String(), `"connection refused"`)
+	suite.NotContains(w.Body.String(), old.CapturedOn)
+
+	// widening the window includes the older snapshot
+	w = ts.request("GET", "/v1/status/history?hours=72&key=S3CR3T8", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), old.CapturedOn)
+
+	// the window is validated and the endpoint is admin only
+	w = ts.request("GET", "/v1/status/history?hours=0&key=S3CR3T8", "")
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("GET", "/v1/status/history?key=S3CR3T1", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestLimits() {
+	ts := newTestSetup()
+
+	// the endpoint authenticates by token alone
+	w := ts.request("GET", "/v1/limits", "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+	w = ts.request("GET", "/v1/limits?key=unknown", "")
+	suite.Equal(http.StatusUnauthorized, w.Code)
+
+	// publish one message so today's usage is non zero
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "bW9ja19kYXRhXzA="}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal(1, topics.PrecomputeDailyCounts(time.Now(), ts.str))
+
+	w = ts.request("GET", "/v1/limits?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"maxMessageSizeBytes": 1048576`)
+	suite.Contains(w.Body.String(), `"maxBatchItems": 100`)
+	suite.Contains(w.Body.String(), `"default": 10`)
+	suite.Contains(w.Body.String(), `"pullMaxWaitSec": 30`)
+	suite.Contains(w.Body.String(), `"project": "ARGO"`)
+	suite.Contains(w.Body.String(), `"messagesToday": 1`)
+}
+
+func (suite *HandlersTestSuite) TestPushAWS() {
+	ts := newTestSetup()
+	ts.cfg.PushEnabled = true
+	ts.cfg.PushAWSCredentials = map[string]config.AWSCredentials{
+		"teamA": {AccessKey: "AKIAEXAMPLE", SecretKey: "secret"},
+	}
+
+	// the type, the arn and the credentials reference are all validated
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/recv", "type": "kinesis"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Push type must be one of http, chat, email, kafka, sqs, sns")
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "not-an-arn", "type": "sqs", "awsCredentials": "teamA"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Push endpoint must be a valid sqs arn")
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "arn:aws:sns:eu-west-1:123456789012:alerts", "type": "sqs", "awsCredentials": "teamA"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "arn:aws:sqs:eu-west-1:123456789012:ingest", "type": "sqs", "awsCredentials": "teamB"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "AWS credentials are not available")
+
+	// an sqs destination needs no endpoint verification, deliveries start
+	// right away through the aws sender
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "arn:aws:sqs:eu-west-1:123456789012:ingest", "type": "sqs", "awsCredentials": "teamA"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"type": "sqs"`)
+	suite.Contains(w.Body.String(), `"awsCredentials": "teamA"`)
+	suite.Contains(w.Body.String(), `"verified": true`)
+	suite.True(ts.mgr.IsActive("argo_uuid", "sub1"))
+
+	arn := "arn:aws:sqs:eu-west-1:123456789012:ingest"
+	for i := 0; i < 50 && len(ts.aws.Sent[arn]) < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	ts.mgr.StopAll()
+	suite.Equal(4, len(ts.aws.Sent[arn]))
+	suite.Contains(ts.aws.Sent[arn][0], `"subscription":"projects/ARGO/subscriptions/sub1"`)
+	suite.Equal("sqs", ts.aws.LastType)
+	suite.Equal("teamA", ts.aws.LastCredentials)
+}
+
+func (suite *HandlersTestSuite) TestPushChat() {
+	ts := newTestSetup()
+	ts.cfg.PushEnabled = true
+
+	// the webhook url and the option combinations are validated
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "http://hooks.example.com/T000/B000", "type": "chat"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Push endpoint must be an https webhook url")
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://hooks.example.com/T000/B000", "type": "chat", "digest": true}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Chat destinations cannot be combined with cloudEvents, digest or batching")
+
+	// a chat webhook cannot serve the ownership challenge, deliveries
+	// start right away through the regular http sender
+	hook := "https://hooks.example.com/T000/B000"
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "`+hook+`", "type": "chat", "chatTemplate": "{topic}: {data}"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"type": "chat"`)
+	suite.Contains(w.Body.String(), `"chatTemplate": "{topic}: {data}"`)
+	suite.Contains(w.Body.String(), `"verified": true`)
+	suite.True(ts.mgr.IsActive("argo_uuid", "sub1"))
+
+	for i := 0; i < 50 && len(ts.sender.Sent[hook]) < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	ts.mgr.StopAll()
+	suite.Equal(4, len(ts.sender.Sent[hook]))
+	// the template renders into the webhook text payload
+	suite.Equal(`{"text":"projects/ARGO/topics/topic1: mock_data_0"}`, ts.sender.Sent[hook][0])
+}
+
+func (suite *HandlersTestSuite) TestPushEmail() {
+	ts := newTestSetup()
+	ts.cfg.PushEnabled = true
+
+	// email destinations are rejected when no smtp server is configured
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "oncall@example.com", "type": "email"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Email destinations are not available")
+
+	ts.cfg.PushSMTPHost = "smtp.example.com:587"
+
+	// the recipient list and the option combinations are validated
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "not-an-address", "type": "email"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Push endpoint must be a comma separated list of email addresses")
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "oncall@example.com", "type": "email", "cloudEvents": true}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Email destinations cannot be combined with cloudEvents or batching")
+
+	// an email destination needs no endpoint verification, deliveries
+	// start right away through the email sender
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "oncall@example.com,backup@example.com", "type": "email", "emailSubject": "Alarm on {topic}"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"type": "email"`)
+	suite.Contains(w.Body.String(), `"emailSubject": "Alarm on {topic}"`)
+	suite.Contains(w.Body.String(), `"verified": true`)
+	suite.True(ts.mgr.IsActive("argo_uuid", "sub1"))
+
+	for i := 0; i < 50 && len(ts.email.Sent) < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	ts.mgr.StopAll()
+	suite.Equal(4, len(ts.email.Sent))
+	// the body carries the decoded message data and the subject template
+	// is expanded with the source topic
+	suite.Equal([]string{"oncall@example.com", "backup@example.com"}, ts.email.Sent[0].Recipients)
+	suite.Equal("Alarm on projects/ARGO/topics/topic1", ts.email.Sent[0].Subject)
+	suite.Equal("mock_data_0", ts.email.Sent[0].Body)
+}
+
+func (suite *HandlersTestSuite) TestPushKafka() {
+	ts := newTestSetup()
+	ts.cfg.PushEnabled = true
+	subscriptions.SetPushAuthzKey("test-key")
+	defer subscriptions.SetPushAuthzKey("")
+
+	// the destination uri and the sasl pairing are validated
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/recv", "type": "kafka"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Push endpoint must be a valid kafka destination")
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "kafka://broker1:9092/mirror", "type": "kafka", "saslUser": "svc"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "SASL user and password must be set together")
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "kafka://broker1:9092/mirror", "type": "kafka", "cloudEvents": true}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Kafka mirroring cannot be combined with cloudEvents, digest or batching")
+
+	// a kafka destination needs no endpoint verification, mirroring starts
+	// right away through the kafka sender
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "kafka://broker1:9092,broker2:9092/mirror", "type": "kafka", "kafkaTls": true, "saslUser": "svc", "saslPassword": "s3cr3t"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"type": "kafka"`)
+	suite.Contains(w.Body.String(), `"verified": true`)
+	suite.True(ts.mgr.IsActive("argo_uuid", "sub1"))
+
+	// the sasl password is encrypted at rest
+	qSubs, _, _, _ := ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	suite.True(strings.HasPrefix(qSubs[0].SASLPassword, "enc:"))
+	suite.NotContains(qSubs[0].SASLPassword, "s3cr3t")
+
+	for i := 0; i < 50 && len(ts.kafka.Sent["mirror"]) < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	ts.mgr.StopAll()
+	suite.Equal(4, len(ts.kafka.Sent["mirror"]))
+	// the message is mirrored as stored on the broker, not wrapped in the
+	// push envelope
+	suite.Contains(ts.kafka.Sent["mirror"][0], `"data":"bW9ja19kYXRhXzA="`)
+	suite.NotContains(ts.kafka.Sent["mirror"][0], "subscription")
+	suite.Equal([]string{"broker1:9092", "broker2:9092"}, ts.kafka.LastBrokers)
+	suite.True(ts.kafka.LastTLS)
+	suite.Equal("svc", ts.kafka.LastSASLUser)
+	suite.Equal("s3cr3t", ts.kafka.LastSASLPassword)
+}
+
+func (suite *HandlersTestSuite) TestPushMTLS() {
+	ts := newTestSetup()
+	ts.cfg.PushEnabled = true
+
+	// a referenced certificate must name a pair deployed under the
+	// configured certificate directory
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/mtls", "tlsCert": "../evil"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "Invalid TLS certificate name")
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/mtls", "tlsCert": "client"}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	suite.Contains(w.Body.String(), "TLS certificate is not available")
+
+	certDir, err := os.MkdirTemp("", "ams-certs")
+	suite.NoError(err)
+	defer os.RemoveAll(certDir)
+	suite.NoError(os.WriteFile(filepath.Join(certDir, "client.crt"), []byte("cert"), 0600))
+	suite.NoError(os.WriteFile(filepath.Join(certDir, "client.key"), []byte("key"), 0600))
+	ts.cfg.PushTLSCertDir = certDir
+
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/mtls", "tlsCert": "client"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"tlsCert": "client"`)
+
+	// deliveries present the named certificate to the endpoint
+	qSubs, _, _, _ := ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	ts.sender.VerificationHashes["https://example.com/mtls"] = qSubs[0].VerificationHash
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:verifyPushEndpoint?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	for i := 0; i < 50 && len(ts.sender.Sent["https://example.com/mtls"]) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	ts.mgr.StopAll()
+	suite.NotEmpty(ts.sender.Sent["https://example.com/mtls"])
+	suite.Equal("client", ts.sender.LastTLSCert)
+}
+
+func (suite *HandlersTestSuite) TestPushSignature() {
+	ts := newTestSetup()
+	ts.cfg.PushEnabled = true
+	subscriptions.SetPushAuthzKey("test-key")
+	defer subscriptions.SetPushAuthzKey("")
+
+	// enabling push generates a per-subscription signing secret,
+	// encrypted at rest and exposed to the subscription owner
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/recv"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	qSubs, _, _, _ := ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	suite.True(strings.HasPrefix(qSubs[0].SigningSecret, "enc:"))
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	shown := subscriptions.Subscription{}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &shown))
+	secret := shown.PushCfg.SigningSecret
+	suite.NotEmpty(secret)
+
+	// the secret survives endpoint changes
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/other"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"signing_secret": "`+secret+`"`)
+
+	// deliveries carry an X-AMS-Signature header the receiver can
+	// recompute from the secret, the timestamp and the payload
+	qSubs, _, _, _ = ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	ts.sender.VerificationHashes["https://example.com/other"] = qSubs[0].VerificationHash
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:verifyPushEndpoint?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	for i := 0; i < 50 && ts.sender.LastSignature == ""; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	ts.mgr.StopAll()
+	suite.Regexp(`^t=\d+,v1=[0-9a-f]{64}$`, ts.sender.LastSignature)
+
+	parts := strings.SplitN(ts.sender.LastSignature, ",v1=", 2)
+	timestamp := strings.TrimPrefix(parts[0], "t=")
+	payloads := ts.sender.Sent["https://example.com/other"]
+	suite.NotEmpty(payloads)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payloads[len(payloads)-1]))
+	suite.Equal(hex.EncodeToString(mac.Sum(nil)), parts[1])
+}
+
+func (suite *HandlersTestSuite) TestPushErrors() {
+	ts := newTestSetup()
+	ts.cfg.PushEnabled = true
+
+	w := ts.request("GET", "/v1/projects/ARGO/subscriptions/nonexistent:pushErrors?key=S3CR3T1", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+
+	// a rejecting endpoint leaves failed attempts in the log
+	ts.sender.Fail = true
+	ts.sender.FailStatus = 503
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/recv"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	qSubs, _, _, _ := ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	ts.sender.VerificationHashes["https://example.com/recv"] = qSubs[0].VerificationHash
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:verifyPushEndpoint?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	attempts := []stores.QPushAttempt{}
+	for i := 0; i < 50 && len(attempts) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+		attempts, _ = ts.str.QueryPushAttempts("argo_uuid", "sub1")
+	}
+	ts.mgr.StopAll()
+	suite.NotEmpty(attempts)
+	suite.Equal(503, attempts[0].StatusCode)
+	suite.Equal("endpoint responded with 503", attempts[0].Error)
+
+	// the attempt log is exposed to the subscription consumers
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1:pushErrors?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"subscription": "projects/ARGO/subscriptions/sub1"`)
+	suite.Contains(w.Body.String(), `"statusCode": 503`)
+	suite.Contains(w.Body.String(), `"error": "endpoint responded with 503"`)
+	suite.Contains(w.Body.String(), `"latencyMs"`)
+
+	// the log keeps the most recent attempts only
+	for i := 0; i < 30; i++ {
+		ts.str.AppendPushAttempt(stores.QPushAttempt{ProjectUUID: "argo_uuid", Sub: "sub1",
+			Timestamp: time.Now().UTC().Format(time.RFC3339Nano), LatencyMs: int64(i)})
+	}
+	attempts, _ = ts.str.QueryPushAttempts("argo_uuid", "sub1")
+	suite.Equal(20, len(attempts))
+}
+
+func (suite *HandlersTestSuite) TestPushPauseResume() {
+	ts := newTestSetup()
+	ts.cfg.PushEnabled = true
+
+	// pause only applies to push enabled subscriptions
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pausePush?key=S3CR3T7", "")
+	suite.Equal(http.StatusConflict, w.Code)
+
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/recv"}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	qSubs, _, _, _ := ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	ts.sender.VerificationHashes["https://example.com/recv"] = qSubs[0].VerificationHash
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:verifyPushEndpoint?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.True(ts.mgr.IsActive("argo_uuid", "sub1"))
+
+	// pausing stops the delivery loop but keeps the endpoint and its
+	// ownership verification
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pausePush?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.False(ts.mgr.IsActive("argo_uuid", "sub1"))
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"paused": true`)
+	suite.Contains(w.Body.String(), `"pushEndpoint": "https://example.com/recv"`)
+	suite.Contains(w.Body.String(), `"verified": true`)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:pausePush?key=S3CR3T7", "")
+	suite.Equal(http.StatusConflict, w.Code)
+
+	// resuming restarts delivery with the stored configuration
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:resumePush?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.True(ts.mgr.IsActive("argo_uuid", "sub1"))
+	ts.mgr.StopAll()
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:resumePush?key=S3CR3T7", "")
+	suite.Equal(http.StatusConflict, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestPushDigest() {
+	ts := newTestSetup()
+	ts.cfg.PushEnabled = true
+
+	// the window is bounded and digests exclude cloudevents envelopes
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/digest", "digest": true, "digestWindow": 5}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/digest", "digest": true, "cloudEvents": true}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/digest", "digest": true, "digestWindow": 60}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"digest": true`)
+	suite.Contains(w.Body.String(), `"digestWindow": 60`)
+
+	// the accumulated messages arrive as a single digest envelope
+	qSubs, _, _, _ := ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	ts.sender.VerificationHashes["https://example.com/digest"] = qSubs[0].VerificationHash
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:verifyPushEndpoint?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	for i := 0; i < 50 && len(ts.sender.Sent["https://example.com/digest"]) == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	ts.mgr.StopAll()
+
+	payloads := ts.sender.Sent["https://example.com/digest"]
+	suite.Equal(1, len(payloads))
+	suite.Contains(payloads[0], `"count":4`)
+	suite.Contains(payloads[0], `"subscription":"projects/ARGO/subscriptions/sub1"`)
+	qSubs, _, _, _ = ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	suite.Equal(int64(4), qSubs[0].Offset)
+}
+
+func (suite *HandlersTestSuite) TestPushBatching() {
+	ts := newTestSetup()
+	ts.cfg.PushEnabled = true
+
+	// batch bounds are validated
+	w := ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/batch", "maxBatchMessages": 5000}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/batch", "maxBatchMessages": 3, "cloudEvents": true}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/batch", "digest": true, "maxBatchMessages": 3}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:modifyPushConfig?key=S3CR3T7",
+		`{"pushConfig": {"pushEndpoint": "https://example.com/batch", "maxBatchMessages": 3}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/subscriptions/sub1?key=S3CR3T1", "")
+	suite.Contains(w.Body.String(), `"maxBatchMessages": 3`)
+
+	// the four fixture messages arrive as a batch of three plus one
+	qSubs, _, _, _ := ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	ts.sender.VerificationHashes["https://example.com/batch"] = qSubs[0].VerificationHash
+	w = ts.request("POST", "/v1/projects/ARGO/subscriptions/sub1:verifyPushEndpoint?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	for i := 0; i < 50 && len(ts.sender.Sent["https://example.com/batch"]) < 2; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	ts.mgr.StopAll()
+
+	payloads := ts.sender.Sent["https://example.com/batch"]
+	suite.Equal(2, len(payloads))
+	suite.Contains(payloads[0], `"count":3`)
+	suite.Contains(payloads[1], `"count":1`)
+	qSubs, _, _, _ = ts.str.QuerySubs("argo_uuid", "", "sub1", "", 0, "", false)
+	suite.Equal(int64(4), qSubs[0].Offset)
+}
+
+func (suite *HandlersTestSuite) TestSchemaCRUD() {
+	ts := newTestSetup()
+
+	w := ts.request("PUT", "/v1/projects/ARGO/schemas/schema-1?key=S3CR3T7",
+		`{"type": "json", "schema": {"type": "object", "required": ["id"]}}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"name": "projects/ARGO/schemas/schema-1"`)
+
+	w = ts.request("PUT", "/v1/projects/ARGO/schemas/schema-1?key=S3CR3T7", `{"type": "json", "schema": {}}`)
+	suite.Equal(http.StatusConflict, w.Code)
+	w = ts.request("PUT", "/v1/projects/ARGO/schemas/schema-2?key=S3CR3T7", `{"type": "protobuf", "schema": {}}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	w = ts.request("GET", "/v1/projects/ARGO/schemas?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"type": "json"`)
+	w = ts.request("GET", "/v1/projects/ARGO/schemas/schema-1?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+
+	// the same schema may be attached to multiple topics
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:attachSchema?key=S3CR3T7", `{"schema": "schema-1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic2:attachSchema?key=S3CR3T7", `{"schema": "schema-1"}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/topics/topic1?key=S3CR3T7", "")
+	suite.Contains(w.Body.String(), `"schema": "schema-1"`)
+
+	// attaching a missing schema fails, attached schemas cannot be removed
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic3:attachSchema?key=S3CR3T7", `{"schema": "missing"}`)
+	suite.Equal(http.StatusNotFound, w.Code)
+	w = ts.request("DELETE", "/v1/projects/ARGO/schemas/schema-1?key=S3CR3T7", "")
+	suite.Equal(http.StatusConflict, w.Code)
+
+	// detaching both topics frees the schema for deletion
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:attachSchema?key=S3CR3T7", `{"schema": ""}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic2:attachSchema?key=S3CR3T7", `{"schema": ""}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("DELETE", "/v1/projects/ARGO/schemas/schema-1?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/schemas/schema-1?key=S3CR3T7", "")
+	suite.Equal(http.StatusNotFound, w.Code)
+
+	// consumers hold no schema management roles
+	w = ts.request("PUT", "/v1/projects/ARGO/schemas/schema-3?key=S3CR3T4", `{"type": "json", "schema": {}}`)
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestRoutingTopic() {
+	ts := newTestSetup()
+
+	w := ts.request("PUT", "/v1/projects/ARGO/topics/router?key=S3CR3T7", `{"routing": true}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"routing": true`)
+	// routing topics hold no messages of their own, no kafka topic is created
+	_, ok := ts.brk.MsgList["argo_uuid.router"]
+	suite.False(ok)
+
+	// rules are rejected when targeting missing, routing or own topics
+	w = ts.request("POST", "/v1/projects/ARGO/topics/router:modifyRoutingRules?key=S3CR3T7",
+		`{"rules": [{"attribute": "type", "value": "alarm", "topic": "missing"}]}`)
+	suite.Equal(http.StatusNotFound, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/router:modifyRoutingRules?key=S3CR3T7",
+		`{"rules": [{"attribute": "type", "value": "alarm", "topic": "router"}]}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	w = ts.request("POST", "/v1/projects/ARGO/topics/router:modifyRoutingRules?key=S3CR3T7",
+		`{"rules": [{"attribute": "type", "value": "alarm", "topic": "topic1"},
+		            {"attribute": "type", "value": "metric", "topic": "topic2"},
+		            {"attribute": "region", "value": "eu", "topic": "topic2"}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("GET", "/v1/projects/ARGO/topics/router:routingRules?key=S3CR3T7", "")
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"attribute": "type"`)
+
+	// messages fan out to the member topics their attributes match
+	topic1Before := len(ts.brk.MsgList["argo_uuid.topic1"])
+	topic2Before := len(ts.brk.MsgList["argo_uuid.topic2"])
+	w = ts.request("POST", "/v1/projects/ARGO/topics/router:publish?key=S3CR3T7",
+		`{"messages": [{"data": "bW9ja19kYXRh", "attributes": {"type": "alarm"}},
+		               {"data": "bW9ja19kYXRh", "attributes": {"type": "metric", "region": "eu"}},
+		               {"data": "bW9ja19kYXRh", "attributes": {"type": "other"}}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Contains(w.Body.String(), `"unrouted"`)
+	suite.Equal(topic1Before+1, len(ts.brk.MsgList["argo_uuid.topic1"]))
+	suite.Equal(topic2Before+2, len(ts.brk.MsgList["argo_uuid.topic2"]))
+
+	// routing topics cannot be subscribed to or used as rule targets
+	w = ts.request("PUT", "/v1/projects/ARGO/subscriptions/subRouter?key=S3CR3T7", `{"topic": "projects/ARGO/topics/router"}`)
+	suite.Equal(http.StatusConflict, w.Code)
+	w = ts.request("PUT", "/v1/projects/ARGO/topics/router2?key=S3CR3T7", `{"routing": true}`)
+	suite.Equal(http.StatusOK, w.Code)
+	w = ts.request("POST", "/v1/projects/ARGO/topics/router2:modifyRoutingRules?key=S3CR3T7",
+		`{"rules": [{"attribute": "type", "value": "alarm", "topic": "router"}]}`)
+	suite.Equal(http.StatusBadRequest, w.Code)
+
+	// rule management endpoints reject plain topics
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:modifyRoutingRules?key=S3CR3T7", `{"rules": []}`)
+	suite.Equal(http.StatusConflict, w.Code)
+}
+
+func (suite *HandlersTestSuite) TestEventStreamForbidden() {
+	ts := newTestSetup()
+
+	// only service admins may attach to the event firehose
+	w := ts.request("GET", "/v1/events:stream?key=S3CR3T1", "")
+	suite.Equal(http.StatusForbidden, w.Code)
+}
+
+func TestHandlersTestSuite(t *testing.T) {
+	suite.Run(t, new(HandlersTestSuite))
+}
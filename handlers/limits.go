@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kaggis/argo-messaging/metrics"
+)
+
+// limitRange describes the accepted range of a request setting along
+// with its effective default
+type limitRange struct {
+	Min     int `json:"min"`
+	Max     int `json:"max"`
+	Default int `json:"default,omitempty"`
+}
+
+// projectUsage reports the current usage of one project of the caller
+type projectUsage struct {
+	Project string   `json:"project"`
+	Roles   []string `json:"roles"`
+	// MessagesToday is the number of messages published to the project today
+	MessagesToday int64 `json:"messagesToday"`
+	// PublishRatePerSec is the publish rate of the project over the last minute
+	PublishRatePerSec float64 `json:"publishRatePerSec"`
+}
+
+// limitsResponse is the payload of the limits endpoint
+type limitsResponse struct {
+	MaxMessageSizeBytes       int            `json:"maxMessageSizeBytes"`
+	MaxBatchItems             int            `json:"maxBatchItems"`
+	AckDeadlineSec            limitRange     `json:"ackDeadlineSec"`
+	ReplayDays                limitRange     `json:"replayDays"`
+	PullMaxWaitSec            int            `json:"pullMaxWaitSec"`
+	PublishDedupWindowSec     int            `json:"publishDedupWindowSec"`
+	DataPlaneMaxConcurrent    int            `json:"dataPlaneMaxConcurrent,omitempty"`
+	ControlPlaneMaxConcurrent int            `json:"controlPlaneMaxConcurrent,omitempty"`
+	Projects                  []projectUsage `json:"projects"`
+}
+
+// LimitsShow (GET) returns the effective service limits for the caller
+// along with its current per-project usage, so client sdks can configure
+// batching and pacing without discovering the limits through 4xx errors
+func LimitsShow(w http.ResponseWriter, r *http.Request) {
+	str := getStr(r)
+	cfg := getCfg(r)
+
+	// the endpoint is not project scoped, so the caller authenticates by
+	// token alone and the response covers every project it belongs to
+	token := r.URL.Query().Get("key")
+	if token == "" {
+		respondErr(w, apiErrorUnauthorized())
+		return
+	}
+	user, err := str.GetUserFromToken(token)
+	if err != nil {
+		respondErr(w, apiErrorUnauthorized())
+		return
+	}
+
+	response := limitsResponse{
+		MaxMessageSizeBytes:       maxMessageSize,
+		MaxBatchItems:             maxBatchSize,
+		AckDeadlineSec:            limitRange{Min: 0, Max: maxLeaseSeconds, Default: cfg.AckDeadlineSec},
+		ReplayDays:                limitRange{Min: 1, Max: 365},
+		PullMaxWaitSec:            cfg.PullMaxWaitSec,
+		PublishDedupWindowSec:     cfg.PublishDedupWindowSec,
+		DataPlaneMaxConcurrent:    cfg.DataPlaneMaxConcurrent,
+		ControlPlaneMaxConcurrent: cfg.ControlPlaneMaxConcurrent,
+		Projects:                  []projectUsage{},
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	for _, membership := range user.Projects {
+		qProjects, err := str.QueryProjects(membership.ProjectUUID, "")
+		if err != nil || len(qProjects) == 0 {
+			continue
+		}
+		usage := projectUsage{Project: qProjects[0].Name, Roles: membership.Roles}
+		counts, _ := str.QueryDailyMsgCount(membership.ProjectUUID, "")
+		for _, count := range counts {
+			if count.Date == today {
+				usage.MessagesToday += count.MsgCount
+			}
+		}
+		usage.PublishRatePerSec = metrics.MsgRate.PerSecond(membership.ProjectUUID, time.Minute)
+		response.Projects = append(response.Projects, usage)
+	}
+
+	output, _ := json.MarshalIndent(response, "", "   ")
+	respondOK(w, output)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/auth"
+)
+
+// memberView renders a user as seen from inside one project: only the
+// membership of that project is exposed and neither tokens nor service
+// wide roles leak to a project admin
+func memberView(user auth.User, projectName string) auth.User {
+	trimmed := user
+	trimmed.Token = ""
+	trimmed.ServiceRoles = []string{}
+	trimmed.Projects = []auth.ProjectRoles{}
+	for _, membership := range user.Projects {
+		if membership.Project == projectName {
+			trimmed.Projects = append(trimmed.Projects, membership)
+		}
+	}
+	return trimmed
+}
+
+// MemberListAll (GET) returns the users holding a membership of the
+// project, rendered from the perspective of that project
+func MemberListAll(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	results, err := auth.FindUsers(getProjectUUID(r), "", "", getStr(r))
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	for i := range results.List {
+		results.List[i] = memberView(results.List[i], urlVars["project"])
+	}
+	output, _ := results.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// MemberListOne (GET) returns one member of the project
+func MemberListOne(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	results, err := auth.FindUsers(getProjectUUID(r), "", urlVars["user"], getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("User"))
+		return
+	}
+	result := memberView(results.One(), urlVars["project"])
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// memberRolesBody carries the project roles of a member request
+type memberRolesBody struct {
+	Email string   `json:"email"`
+	Roles []string `json:"roles"`
+}
+
+// MemberCreate (POST) creates a user whose only membership is the
+// project, so a project admin can onboard accounts without touching any
+// other tenant; the token is returned once, on creation
+func MemberCreate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody := memberRolesBody{}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	// only project-assignable roles may be granted here, so a project
+	// admin cannot mint members carrying service wide roles
+	if err := auth.CheckProjectRoles(postBody.Roles, getStr(r)); err != nil {
+		respondErr(w, apiErrorInvalidArgument(err.Error()))
+		return
+	}
+
+	token, err := auth.GenToken()
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	memberships := []auth.ProjectRoles{{Project: urlVars["project"], Roles: postBody.Roles}}
+	result, err := auth.CreateUser(uuid.New().String(), urlVars["user"], memberships, token, "",
+		postBody.Email, []string{}, time.Now(), getUserUUID(r), getStr(r))
+	if err != nil {
+		if err.Error() == "exists" {
+			respondErr(w, apiErrorConflict("User"))
+			return
+		}
+		respondErr(w, apiErrorInvalidArgument(err.Error()))
+		return
+	}
+	// the one view carrying the token: the admin has to hand it over
+	trimmed := memberView(result, urlVars["project"])
+	trimmed.Token = result.Token
+	output, _ := trimmed.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// MemberModifyRoles (POST) replaces the roles a user holds within the
+// project; memberships of other projects and service roles stay as
+// they are
+func MemberModifyRoles(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	str := getStr(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody := memberRolesBody{}
+	if err := json.Unmarshal(body, &postBody); err != nil || len(postBody.Roles) == 0 {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if err := auth.CheckProjectRoles(postBody.Roles, str); err != nil {
+		respondErr(w, apiErrorInvalidArgument(err.Error()))
+		return
+	}
+
+	existing, err := auth.FindUsers(getProjectUUID(r), "", urlVars["user"], str)
+	if err != nil || existing.Empty() {
+		respondErr(w, apiErrorNotFound("User"))
+		return
+	}
+	user := existing.One()
+	memberships := []auth.ProjectRoles{}
+	for _, membership := range user.Projects {
+		if membership.Project != urlVars["project"] {
+			memberships = append(memberships, membership)
+		}
+	}
+	memberships = append(memberships, auth.ProjectRoles{Project: urlVars["project"], Roles: postBody.Roles})
+
+	result, err := auth.UpdateUser(user.UUID, "", memberships, "", nil, user.TokenExpiresOn, time.Now(), str)
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument(err.Error()))
+		return
+	}
+	updated := memberView(result, urlVars["project"])
+	output, _ := updated.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// MemberRemove (POST) drops the membership of a user from the project;
+// the account itself, its other memberships and its history survive
+func MemberRemove(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	str := getStr(r)
+
+	existing, err := auth.FindUsers(getProjectUUID(r), "", urlVars["user"], str)
+	if err != nil || existing.Empty() {
+		respondErr(w, apiErrorNotFound("User"))
+		return
+	}
+	user := existing.One()
+	memberships := []auth.ProjectRoles{}
+	for _, membership := range user.Projects {
+		if membership.Project != urlVars["project"] {
+			memberships = append(memberships, membership)
+		}
+	}
+
+	if _, err := auth.UpdateUser(user.UUID, "", memberships, "", nil, user.TokenExpiresOn, time.Now(), str); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	respondOK(w, []byte("{}"))
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/events"
+	"github.com/kaggis/argo-messaging/operations"
+	"github.com/kaggis/argo-messaging/subscriptions"
+	"github.com/kaggis/argo-messaging/topics"
+)
+
+// OperationList (GET) returns the list of known long-running operations
+func OperationList(w http.ResponseWriter, r *http.Request) {
+	output, _ := json.MarshalIndent(map[string][]operations.Operation{"operations": operations.List()}, "", "   ")
+	respondOK(w, output)
+}
+
+// OperationShow (GET) returns the current status of a long-running operation
+func OperationShow(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	op, err := operations.Get(urlVars["operation"])
+	if err != nil {
+		respondErr(w, apiErrorNotFound("Operation"))
+		return
+	}
+	output, _ := op.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// OperationCancel (POST) requests the cancellation of a running operation
+func OperationCancel(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	op, err := operations.Cancel(urlVars["operation"])
+	if err != nil {
+		respondErr(w, apiErrorNotFound("Operation"))
+		return
+	}
+	output, _ := op.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// ProjectPurge (POST) removes every topic and subscription of a project
+// in the background, returning an operation to poll for completion
+func ProjectPurge(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectName := urlVars["project"]
+	projectUUID := getProjectUUID(r)
+	str := getStr(r)
+	brk := brokers.ForProject(getPool(r), str, projectUUID)
+
+	run := func(ctx context.Context) error {
+		qSubs, _, _, err := str.QuerySubs(projectUUID, "", "", "", 0, "", false)
+		if err != nil {
+			return err
+		}
+		for _, sub := range qSubs {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			if err := subscriptions.RemoveSub(projectUUID, sub.Name, str); err != nil {
+				return err
+			}
+		}
+
+		qTopics, _, _, err := str.QueryTopics(projectUUID, "", "", "", 0, "", false)
+		if err != nil {
+			return err
+		}
+		for _, topic := range qTopics {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			if err := topics.RemoveTopic(projectUUID, topic.Name, str); err != nil {
+				return err
+			}
+			if err := brk.DeleteTopic(projectUUID + "." + topic.Name); err != nil {
+				return err
+			}
+		}
+
+		events.Emit(events.New("project.purged", projectName, projectName))
+		return nil
+	}
+
+	op := operations.Start("project.purge", projectName, run)
+	output, _ := op.ExportJSON()
+	respondAccepted(w, []byte(output))
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// patchOp is one operation of an application/json-patch+json body (rfc 6902)
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// isJSONPatch reports whether the request declares a json patch body
+func isJSONPatch(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/json-patch+json")
+}
+
+// applyJSONPatch applies an rfc 6902 patch document to the json
+// representation of a resource, returning the patched document. The
+// add, remove, replace and test operations are supported with rfc 6901
+// pointers; test lets clients guard a patch against a concurrently
+// modified resource.
+func applyJSONPatch(doc []byte, patch []byte) ([]byte, error) {
+	ops := []patchOp{}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, errors.New("invalid patch document")
+	}
+	var root interface{}
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		tokens, err := pointerTokens(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add", "remove", "replace", "test":
+			root, err = patchNode(root, tokens, op)
+		default:
+			err = errors.New("unsupported patch op: " + op.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(root)
+}
+
+// pointerTokens splits an rfc 6901 json pointer into its reference tokens
+func pointerTokens(path string) ([]string, error) {
+	if path == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, errors.New("invalid patch path: " + path)
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		token = strings.ReplaceAll(token, "~1", "/")
+		tokens[i] = strings.ReplaceAll(token, "~0", "~")
+	}
+	return tokens, nil
+}
+
+// patchNode applies one patch operation at the location the remaining
+// pointer tokens name, returning the possibly replaced node
+func patchNode(node interface{}, tokens []string, op patchOp) (interface{}, error) {
+	if len(tokens) == 0 {
+		switch op.Op {
+		case "remove":
+			return nil, errors.New("cannot remove the document root")
+		case "test":
+			return node, testValue(node, op)
+		}
+		return patchValue(op)
+	}
+
+	key := tokens[0]
+	switch t := node.(type) {
+	case map[string]interface{}:
+		if len(tokens) > 1 {
+			child, ok := t[key]
+			if !ok {
+				return nil, errors.New("patch path not found: " + op.Path)
+			}
+			patched, err := patchNode(child, tokens[1:], op)
+			if err != nil {
+				return nil, err
+			}
+			t[key] = patched
+			return t, nil
+		}
+		switch op.Op {
+		case "add":
+			value, err := patchValue(op)
+			if err != nil {
+				return nil, err
+			}
+			t[key] = value
+		case "replace":
+			if _, ok := t[key]; !ok {
+				return nil, errors.New("patch path not found: " + op.Path)
+			}
+			value, err := patchValue(op)
+			if err != nil {
+				return nil, err
+			}
+			t[key] = value
+		case "remove":
+			if _, ok := t[key]; !ok {
+				return nil, errors.New("patch path not found: " + op.Path)
+			}
+			delete(t, key)
+		case "test":
+			return t, testValue(t[key], op)
+		}
+		return t, nil
+	case []interface{}:
+		if key == "-" && op.Op == "add" && len(tokens) == 1 {
+			value, err := patchValue(op)
+			if err != nil {
+				return nil, err
+			}
+			return append(t, value), nil
+		}
+		idx, err := strconv.Atoi(key)
+		if err != nil || idx < 0 || idx > len(t) || (idx == len(t) && op.Op != "add") {
+			return nil, errors.New("invalid array index in patch path: " + op.Path)
+		}
+		if len(tokens) > 1 {
+			patched, err := patchNode(t[idx], tokens[1:], op)
+			if err != nil {
+				return nil, err
+			}
+			t[idx] = patched
+			return t, nil
+		}
+		switch op.Op {
+		case "add":
+			value, err := patchValue(op)
+			if err != nil {
+				return nil, err
+			}
+			t = append(t, nil)
+			copy(t[idx+1:], t[idx:])
+			t[idx] = value
+		case "replace":
+			value, err := patchValue(op)
+			if err != nil {
+				return nil, err
+			}
+			t[idx] = value
+		case "remove":
+			t = append(t[:idx], t[idx+1:]...)
+		case "test":
+			return t, testValue(t[idx], op)
+		}
+		return t, nil
+	default:
+		return nil, errors.New("patch path not found: " + op.Path)
+	}
+}
+
+// patchValue decodes the value carried by a patch operation
+func patchValue(op patchOp) (interface{}, error) {
+	if op.Value == nil {
+		return nil, errors.New("patch op " + op.Op + " misses a value")
+	}
+	var value interface{}
+	if err := json.Unmarshal(op.Value, &value); err != nil {
+		return nil, errors.New("invalid patch value")
+	}
+	return value, nil
+}
+
+// testValue checks a test operation against the current value
+func testValue(current interface{}, op patchOp) error {
+	expected, err := patchValue(op)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(current, expected) {
+		return errors.New("patch test failed at " + op.Path)
+	}
+	return nil
+}
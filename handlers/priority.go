@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+)
+
+// priority classes of the api routes. Data plane routes move messages,
+// control plane routes administer resources; each class draws from its
+// own concurrency pool so a spike in one cannot starve the other
+const (
+	priorityDataPlane    = "data_plane"
+	priorityControlPlane = "control_plane"
+)
+
+// dataPlaneRoutes holds the route names that belong to the data plane
+var dataPlaneRoutes = map[string]bool{
+	"topics:publish":            true,
+	"subscriptions:pull":        true,
+	"subscriptions:acknowledge": true,
+}
+
+// acquireWait bounds how long a request waits for a free slot in its
+// class before it is rejected
+const acquireWait = 500 * time.Millisecond
+
+// priorityLimiter holds one concurrency pool per priority class
+type priorityLimiter struct {
+	data    chan struct{}
+	control chan struct{}
+}
+
+// newPriorityLimiter creates a limiter with the given per-class limits,
+// a zero limit leaves the class unbounded
+func newPriorityLimiter(dataLimit int, controlLimit int) *priorityLimiter {
+	lim := priorityLimiter{}
+	if dataLimit > 0 {
+		lim.data = make(chan struct{}, dataLimit)
+	}
+	if controlLimit > 0 {
+		lim.control = make(chan struct{}, controlLimit)
+	}
+	return &lim
+}
+
+// classify returns the priority class of a named route
+func classify(routeName string) string {
+	if dataPlaneRoutes[routeName] {
+		return priorityDataPlane
+	}
+	return priorityControlPlane
+}
+
+// pool returns the concurrency pool of a class, nil when unbounded
+func (lim *priorityLimiter) pool(class string) chan struct{} {
+	if class == priorityDataPlane {
+		return lim.data
+	}
+	return lim.control
+}
+
+// acquire takes a slot from the pool of a class, returning false when
+// the class stays saturated for the whole wait window
+func (lim *priorityLimiter) acquire(class string) bool {
+	pool := lim.pool(class)
+	if pool == nil {
+		return true
+	}
+	select {
+	case pool <- struct{}{}:
+		return true
+	case <-time.After(acquireWait):
+		return false
+	}
+}
+
+// release returns a slot to the pool of a class
+func (lim *priorityLimiter) release(class string) {
+	pool := lim.pool(class)
+	if pool == nil {
+		return
+	}
+	<-pool
+}
+
+// streamingRoutes hold their connection open indefinitely and are
+// therefore exempt from the per-class pools
+var streamingRoutes = map[string]bool{
+	"events:stream":        true,
+	"subscriptions:stream": true,
+	"subscriptions:sse":    true,
+}
+
+// WrapPriority guards a route with the concurrency pool of its priority class
+func WrapPriority(hfn http.HandlerFunc, routeName string, lim *priorityLimiter) http.HandlerFunc {
+	if streamingRoutes[routeName] {
+		return hfn
+	}
+	class := classify(routeName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !lim.acquire(class) {
+			respondErr(w, apiErrorTooManyRequests())
+			return
+		}
+		defer lim.release(class)
+		hfn.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/kaggis/argo-messaging/push"
+)
+
+type PriorityTestSuite struct {
+	suite.Suite
+}
+
+func (suite *PriorityTestSuite) TestClassify() {
+	suite.Equal(priorityDataPlane, classify("topics:publish"))
+	suite.Equal(priorityDataPlane, classify("subscriptions:pull"))
+	suite.Equal(priorityDataPlane, classify("subscriptions:acknowledge"))
+	suite.Equal(priorityControlPlane, classify("topics:list"))
+	suite.Equal(priorityControlPlane, classify("users:create"))
+}
+
+func (suite *PriorityTestSuite) TestLimiter() {
+	lim := newPriorityLimiter(1, 1)
+
+	// a saturated data plane pool rejects after the wait window but
+	// leaves the control plane pool unaffected
+	suite.True(lim.acquire(priorityDataPlane))
+	suite.False(lim.acquire(priorityDataPlane))
+	suite.True(lim.acquire(priorityControlPlane))
+	suite.False(lim.acquire(priorityControlPlane))
+
+	lim.release(priorityDataPlane)
+	suite.True(lim.acquire(priorityDataPlane))
+
+	// unbounded classes always admit
+	unbounded := newPriorityLimiter(0, 0)
+	for i := 0; i < 10; i++ {
+		suite.True(unbounded.acquire(priorityDataPlane))
+		suite.True(unbounded.acquire(priorityControlPlane))
+	}
+}
+
+func (suite *PriorityTestSuite) TestSaturatedClassRejects() {
+	ts := newTestSetup()
+	ts.cfg.ControlPlaneMaxConcurrent = 1
+	ts.api = NewRouting(ts.cfg, ts.pool, ts.str, push.NewProvider(ts.cfg, ts.mgr, ts.pc))
+
+	// hold the single control plane slot
+	suite.True(ts.api.limiter.acquire(priorityControlPlane))
+
+	// control plane requests are rejected while the slot is held
+	w := ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T1", "")
+	suite.Equal(http.StatusTooManyRequests, w.Code)
+
+	// data plane requests still go through
+	w = ts.request("POST", "/v1/projects/ARGO/topics/topic1:publish?key=S3CR3T1",
+		`{"messages": [{"data": "YmFzZTY0"}]}`)
+	suite.Equal(http.StatusOK, w.Code)
+
+	ts.api.limiter.release(priorityControlPlane)
+	w = ts.request("GET", "/v1/projects/ARGO/topics?key=S3CR3T1", "")
+	suite.Equal(http.StatusOK, w.Code)
+}
+
+func TestPriorityTestSuite(t *testing.T) {
+	suite.Run(t, new(PriorityTestSuite))
+}
@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/events"
+	"github.com/kaggis/argo-messaging/projects"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// ProjectListAll (GET) returns the list of all projects
+func ProjectListAll(w http.ResponseWriter, r *http.Request) {
+	results, err := projects.Find("", "", getStr(r))
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := results.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// ProjectListOne (GET) returns the details of a single project
+func ProjectListOne(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	results, err := projects.Find("", urlVars["project"], getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("project"))
+		return
+	}
+	project := results.One()
+	output, _ := project.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// ProjectCreate (POST) creates a new project
+func ProjectCreate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectName := urlVars["project"]
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody, err := projects.GetFromJSON(body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	projectUUID := uuid.New().String()
+	result, err := projects.CreateProject(projectUUID, projectName, time.Now(), getUserUUID(r), postBody.Description,
+		postBody.Tags, postBody.MaxTopics, postBody.MaxSubscriptions, getStr(r))
+	if err != nil {
+		if err.Error() == "exists" {
+			respondErr(w, apiErrorConflict("Project"))
+			return
+		}
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	events.Emit(events.New("project.created", projectName, projectName))
+
+	// show the created by username in the response
+	result.CreatedBy = createdByName(r, getUserUUID(r))
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// ProjectUpdate (PUT) updates the name or description of a project
+func ProjectUpdate(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	// a json patch body modifies the current project representation
+	// instead of replacing fields wholesale
+	if isJSONPatch(r) {
+		current, err := projects.Find(getProjectUUID(r), "", getStr(r))
+		if err != nil || current.Empty() {
+			respondErr(w, apiErrorNotFound("project"))
+			return
+		}
+		currentDoc, _ := json.Marshal(current.One())
+		if body, err = applyJSONPatch(currentDoc, body); err != nil {
+			respondErr(w, apiErrorInvalidArgument(err.Error()))
+			return
+		}
+	}
+
+	postBody, err := projects.GetFromJSON(body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	result, err := projects.UpdateProject(getProjectUUID(r), postBody.Name, postBody.Description, postBody.Tags,
+		postBody.MaxTopics, postBody.MaxSubscriptions, time.Now(), getStr(r))
+	if err != nil {
+		if err.Error() == "exists" {
+			respondErr(w, apiErrorConflict("Project"))
+			return
+		}
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// ProjectDelete (DELETE) removes a project
+func ProjectDelete(w http.ResponseWriter, r *http.Request) {
+	if err := projects.RemoveProject(getProjectUUID(r), getStr(r)); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	events.Emit(events.New("project.deleted", mux.Vars(r)["project"], ""))
+	respondOK(w, []byte(""))
+}
+
+// projectMetricsTTL is how long a computed project metrics response is
+// served from cache before being recomputed
+const projectMetricsTTL = 10 * time.Second
+
+// projectMetricsWorkers bounds the concurrent store queries of the
+// per-user metrics fan-out
+const projectMetricsWorkers = 4
+
+// userMetrics holds the per-user resource counters of a project
+type userMetrics struct {
+	User                string `json:"user"`
+	TopicsNumber        int    `json:"topics_number"`
+	SubscriptionsNumber int    `json:"subscriptions_number"`
+}
+
+// projectMetricsResponse holds the aggregated usage metrics of a project
+type projectMetricsResponse struct {
+	Project             string        `json:"project"`
+	TopicsNumber        int           `json:"topics_number"`
+	SubscriptionsNumber int           `json:"subscriptions_number"`
+	UsersNumber         int           `json:"users_number"`
+	PublishBytes        int64         `json:"publish_bytes"`
+	ConsumeBytes        int64         `json:"consume_bytes"`
+	UserMetrics         []userMetrics `json:"user_metrics"`
+	// NumberOfDailyMessages serves the precomputed per-day counts summed
+	// over the topics of the project
+	NumberOfDailyMessages []dailyMsgCount `json:"number_of_daily_messages"`
+	// Derived serves the server-side computed series
+	Derived derivedMetrics `json:"derived"`
+}
+
+// projectMetricsEntry is a cached rendered response with its compute time
+type projectMetricsEntry struct {
+	output   []byte
+	computed time.Time
+}
+
+// projectMetricsCache caches rendered responses for a short ttl since
+// dashboards poll the endpoint aggressively
+var projectMetricsCache = struct {
+	sync.Mutex
+	entries map[string]projectMetricsEntry
+}{entries: make(map[string]projectMetricsEntry)}
+
+// ProjectMetrics (GET) returns the aggregated usage metrics of a project,
+// fanning the per-user counters out over a bounded worker pool
+func ProjectMetrics(w http.ResponseWriter, r *http.Request) {
+	str := getStr(r)
+	projectUUID := getProjectUUID(r)
+
+	start, end, rangeErr := metricsTimeRange(r)
+	if rangeErr != nil {
+		respondErr(w, apiErrorInvalidArgument(rangeErr.Error()))
+		return
+	}
+	exportCSV := r.URL.Query().Get("format") == "csv"
+
+	// the cache only serves the default json rendering
+	if !exportCSV && start == "" && end == "" {
+		projectMetricsCache.Lock()
+		entry, ok := projectMetricsCache.entries[projectUUID]
+		projectMetricsCache.Unlock()
+		if ok && time.Since(entry.computed) < projectMetricsTTL {
+			respondOK(w, entry.output)
+			return
+		}
+	}
+
+	topics, _, _, err := str.QueryTopics(projectUUID, "", "", "", 0, "", false)
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	subs, _, _, err := str.QuerySubs(projectUUID, "", "", "", 0, "", false)
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	users, err := str.QueryUsers(projectUUID, "", "")
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	response := projectMetricsResponse{
+		Project:             mux.Vars(r)["project"],
+		TopicsNumber:        len(topics),
+		SubscriptionsNumber: len(subs),
+		UsersNumber:         len(users),
+	}
+	for _, topic := range topics {
+		response.PublishBytes += topic.TotalBytes
+	}
+	for _, sub := range subs {
+		response.ConsumeBytes += sub.TotalBytes
+	}
+
+	// the per-user counters each need their own acl-filtered queries, so
+	// they run concurrently with at most projectMetricsWorkers in flight
+	response.UserMetrics = make([]userMetrics, len(users))
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, projectMetricsWorkers)
+	for i, user := range users {
+		wg.Add(1)
+		go func(i int, user stores.QUser) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			userTopics, _, _, _ := str.QueryTopics(projectUUID, user.UUID, "", "", 0, "", false)
+			userSubs, _, _, _ := str.QuerySubs(projectUUID, user.UUID, "", "", 0, "", false)
+			response.UserMetrics[i] = userMetrics{User: user.Name,
+				TopicsNumber: len(userTopics), SubscriptionsNumber: len(userSubs)}
+		}(i, user)
+	}
+	wg.Wait()
+
+	// fold the precomputed per-topic days into per-day project totals
+	response.NumberOfDailyMessages = []dailyMsgCount{}
+	counts, _ := str.QueryDailyMsgCount(projectUUID, "")
+	perDay := make(map[string]int64)
+	for _, count := range counts {
+		perDay[count.Date] += count.MsgCount
+	}
+	days := make([]string, 0, len(perDay))
+	for day := range perDay {
+		days = append(days, day)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(days)))
+	for _, day := range days {
+		response.NumberOfDailyMessages = append(response.NumberOfDailyMessages,
+			dailyMsgCount{Date: day, MsgCount: perDay[day]})
+	}
+	response.NumberOfDailyMessages = filterDailyCounts(response.NumberOfDailyMessages, start, end)
+	if exportCSV {
+		respondCSV(w, response.Project+"-metrics.csv", dailyCountsCSV(response.NumberOfDailyMessages))
+		return
+	}
+	response.Derived = deriveMetrics(projectUUID, response.NumberOfDailyMessages, time.Now())
+
+	output, _ := json.MarshalIndent(response, "", "   ")
+	if start == "" && end == "" {
+		projectMetricsCache.Lock()
+		projectMetricsCache.entries[projectUUID] = projectMetricsEntry{output: output, computed: time.Now()}
+		projectMetricsCache.Unlock()
+	}
+	respondOK(w, output)
+}
+
+// ProjectAssignCluster (POST) maps a project to a named kafka cluster
+func ProjectAssignCluster(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody := struct {
+		Cluster string `json:"cluster"`
+	}{}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	// the cluster must be registered in the broker pool
+	if !clusterExists(r, postBody.Cluster) {
+		respondErr(w, apiErrorInvalidArgument("Cluster is not registered"))
+		return
+	}
+
+	if err := getStr(r).UpdateProjectCluster(getProjectUUID(r), postBody.Cluster); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	event := events.New("project.cluster_assigned", mux.Vars(r)["project"], "")
+	event.Attrs = map[string]string{"cluster": postBody.Cluster}
+	events.Emit(event)
+
+	output, _ := json.MarshalIndent(postBody, "", "   ")
+	respondOK(w, output)
+}
+
+// clusterExists checks if a cluster name is registered in the broker pool
+func clusterExists(r *http.Request, cluster string) bool {
+	for _, name := range getPool(r).Clusters() {
+		if name == cluster {
+			return true
+		}
+	}
+	return false
+}
+
+// createdByName resolves a user uuid to a username for response output
+func createdByName(r *http.Request, userUUID string) string {
+	users, err := getStr(r).QueryUsers("", userUUID, "")
+	if err != nil || len(users) == 0 {
+		return ""
+	}
+	return users[0].Name
+}
+
+// projectQuotasResponse holds the quota limits of a project next to its
+// live usage
+type projectQuotasResponse struct {
+	MaxTopics        int   `json:"max_topics"`
+	MaxSubscriptions int   `json:"max_subscriptions"`
+	MaxDailyMessages int64 `json:"max_daily_messages"`
+	MaxDailyBytes    int64 `json:"max_daily_bytes"`
+	Usage            struct {
+		Topics        int64 `json:"topics"`
+		Subscriptions int64 `json:"subscriptions"`
+		DailyMessages int64 `json:"daily_messages"`
+		DailyBytes    int64 `json:"daily_bytes"`
+	} `json:"usage"`
+}
+
+// projectQuotasView assembles the quota response of a project
+func projectQuotasView(r *http.Request, projectUUID string) (projectQuotasResponse, error) {
+	response := projectQuotasResponse{}
+	prj, err := projects.Find(projectUUID, "", getStr(r))
+	if err != nil || prj.Empty() {
+		return response, errors.New("not found")
+	}
+	p := prj.One()
+	response.MaxTopics = p.MaxTopics
+	response.MaxSubscriptions = p.MaxSubscriptions
+	response.MaxDailyMessages = p.MaxDailyMessages
+	response.MaxDailyBytes = p.MaxDailyBytes
+	response.Usage.Topics = p.TopicsCount
+	response.Usage.Subscriptions = p.SubscriptionsCount
+	usage, err := getStr(r).GetDailyProjectUsage(time.Now().UTC().Format("2006-01-02"), projectUUID)
+	if err != nil {
+		return response, err
+	}
+	response.Usage.DailyMessages = usage.MsgCount
+	response.Usage.DailyBytes = usage.BytesCount
+	return response, nil
+}
+
+// ProjectQuotas (GET) returns the quota limits of a project along with
+// the live usage they are enforced against
+func ProjectQuotas(w http.ResponseWriter, r *http.Request) {
+	response, err := projectQuotasView(r, getProjectUUID(r))
+	if err != nil {
+		if err.Error() == "not found" {
+			respondErr(w, apiErrorNotFound("ProjectUUID"))
+			return
+		}
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := json.MarshalIndent(response, "", "   ")
+	respondOK(w, output)
+}
+
+// ProjectModifyQuotas (POST) replaces the quota limits of a project; a
+// limit of 0 removes the cap
+func ProjectModifyQuotas(w http.ResponseWriter, r *http.Request) {
+	projectUUID := getProjectUUID(r)
+	str := getStr(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody := struct {
+		MaxTopics        int   `json:"max_topics"`
+		MaxSubscriptions int   `json:"max_subscriptions"`
+		MaxDailyMessages int64 `json:"max_daily_messages"`
+		MaxDailyBytes    int64 `json:"max_daily_bytes"`
+	}{}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if postBody.MaxTopics < 0 || postBody.MaxSubscriptions < 0 ||
+		postBody.MaxDailyMessages < 0 || postBody.MaxDailyBytes < 0 {
+		respondErr(w, apiErrorInvalidArgument("Quota limits cannot be negative"))
+		return
+	}
+
+	if err := str.UpdateProjectQuotas(projectUUID, postBody.MaxTopics, postBody.MaxSubscriptions,
+		postBody.MaxDailyMessages, postBody.MaxDailyBytes, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	response, err := projectQuotasView(r, projectUUID)
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := json.MarshalIndent(response, "", "   ")
+	respondOK(w, output)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// parseTrustedProxies turns the configured proxy addresses into
+// networks; plain addresses become host networks
+func parseTrustedProxies(entries []string) []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 32
+				if ip.To4() == nil {
+					bits = 128
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, network)
+			continue
+		}
+		log.Warnf("PROXY: ignoring invalid trusted_proxies entry %v", entry)
+	}
+	return nets
+}
+
+// trustedPeer checks whether the direct peer of a request is one of the
+// configured proxies
+func trustedPeer(remoteAddr string, nets []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// WrapProxyHeaders honors X-Forwarded-For and X-Forwarded-Proto on
+// requests arriving through a configured proxy, so the identity stamps
+// and logs carry the address of the client rather than the balancer;
+// the headers of direct clients are dropped so they cannot spoof either
+func WrapProxyHeaders(trusted []*net.IPNet) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !trustedPeer(r.RemoteAddr, trusted) {
+				r.Header.Del("X-Forwarded-For")
+				r.Header.Del("X-Forwarded-Proto")
+				next.ServeHTTP(w, r)
+				return
+			}
+			if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+				// the first entry is the original client, later hops are
+				// appended by each proxy on the way
+				client := strings.TrimSpace(strings.Split(forwarded, ",")[0])
+				if net.ParseIP(client) != nil {
+					r.RemoteAddr = client
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
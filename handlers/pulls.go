@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/subscriptions"
+)
+
+// pullSession describes an in-flight pull of a subscription
+type pullSession struct {
+	ID           string `json:"id"`
+	Subscription string `json:"subscription"`
+	StartedOn    string `json:"started_on"`
+	cancel       context.CancelFunc
+}
+
+// pullTracker keeps the active pull sessions per subscription so they
+// can be inspected and cancelled without waiting out their deadline
+type pullTracker struct {
+	sync.Mutex
+	sessions map[string]map[string]*pullSession
+}
+
+// newPullTracker creates a new pull session tracker
+func newPullTracker() *pullTracker {
+	return &pullTracker{sessions: make(map[string]map[string]*pullSession)}
+}
+
+// register opens a session for a pull, returning a context that is
+// cancelled when the client disconnects or the session is cancelled
+func (t *pullTracker) register(parent context.Context, subFullName string) (context.Context, *pullSession) {
+	ctx, cancel := context.WithCancel(parent)
+	session := pullSession{
+		ID:           uuid.NewString(),
+		Subscription: subFullName,
+		StartedOn:    time.Now().UTC().Format(time.RFC3339Nano),
+		cancel:       cancel,
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	if t.sessions[subFullName] == nil {
+		t.sessions[subFullName] = make(map[string]*pullSession)
+	}
+	t.sessions[subFullName][session.ID] = &session
+	return ctx, &session
+}
+
+// release closes a session when its pull returns
+func (t *pullTracker) release(session *pullSession) {
+	t.Lock()
+	defer t.Unlock()
+	session.cancel()
+	delete(t.sessions[session.Subscription], session.ID)
+	if len(t.sessions[session.Subscription]) == 0 {
+		delete(t.sessions, session.Subscription)
+	}
+}
+
+// list returns the active sessions of a subscription
+func (t *pullTracker) list(subFullName string) []pullSession {
+	t.Lock()
+	defer t.Unlock()
+	result := []pullSession{}
+	for _, session := range t.sessions[subFullName] {
+		result = append(result, *session)
+	}
+	return result
+}
+
+// cancelAll cancels every active session of a subscription and returns
+// how many were cancelled
+func (t *pullTracker) cancelAll(subFullName string) int {
+	t.Lock()
+	defer t.Unlock()
+	cancelled := 0
+	for _, session := range t.sessions[subFullName] {
+		session.cancel()
+		cancelled++
+	}
+	return cancelled
+}
+
+// activePulls is the service-wide pull session tracker
+var activePulls = newPullTracker()
+
+// SubPullSessions (GET) returns the active pull sessions of a subscription
+func SubPullSessions(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	subName := urlVars["subscription"]
+
+	results, err := subscriptions.Find(getProjectUUID(r), "", subName, "", 0, getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", subName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+
+	output, _ := json.MarshalIndent(map[string][]pullSession{"sessions": activePulls.list(results.One().FullName)}, "", "   ")
+	respondOK(w, output)
+}
+
+// SubCancelPull (POST) cancels the in-flight pulls of a subscription so
+// restarted consumers do not wait out the full pull deadline
+func SubCancelPull(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	subName := urlVars["subscription"]
+
+	results, err := subscriptions.Find(getProjectUUID(r), "", subName, "", 0, getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", subName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+
+	cancelled := activePulls.cancelAll(results.One().FullName)
+	output, _ := json.MarshalIndent(map[string]int{"cancelled": cancelled}, "", "   ")
+	respondOK(w, output)
+}
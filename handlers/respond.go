@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/kaggis/argo-messaging/metrics"
+)
+
+// APIErrorRoot wraps the error body in the json error response
+type APIErrorRoot struct {
+	Body APIErrorBody `json:"error"`
+}
+
+// APIErrorBody holds the error details of an api error response
+type APIErrorBody struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+	// Details optionally carries machine-readable context, such as the
+	// live usage and limit behind a quota rejection
+	Details interface{} `json:"details,omitempty"`
+}
+
+// respondOK writes a successful json response
+func respondOK(w http.ResponseWriter, output []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
+
+// respondCSV writes csv rows as a downloadable attachment
+func respondCSV(w http.ResponseWriter, filename string, rows [][]string) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	w.WriteHeader(http.StatusOK)
+	writer := csv.NewWriter(w)
+	writer.WriteAll(rows)
+}
+
+// respondAccepted writes a json response for work accepted but not yet done
+func respondAccepted(w http.ResponseWriter, output []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(output)
+}
+
+// respondErr writes a json error response
+func respondErr(w http.ResponseWriter, apiErr APIErrorRoot) {
+	if apiErr.Body.Code >= 500 {
+		metrics.Prom.IncCounter("ams_api_errors_total", "api responses indicating a broker or store failure",
+			map[string]string{"code": strconv.Itoa(apiErr.Body.Code)}, 1)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Body.Code)
+	output, _ := json.MarshalIndent(apiErr, "", "   ")
+	w.Write(output)
+}
+
+// apiError constructs an error response body
+func apiError(code int, message string, status string) APIErrorRoot {
+	return APIErrorRoot{Body: APIErrorBody{Code: code, Message: message, Status: status}}
+}
+
+// apiErrorNotFound is used when a resource could not be found
+func apiErrorNotFound(resource string) APIErrorRoot {
+	return apiError(http.StatusNotFound, resource+" doesn't exist", "NOT_FOUND")
+}
+
+// apiErrorConflict is used when a resource already exists
+func apiErrorConflict(resource string) APIErrorRoot {
+	return apiError(http.StatusConflict, resource+" already exists", "ALREADY_EXISTS")
+}
+
+// apiErrorInvalidArgument is used when a request carries invalid data
+func apiErrorInvalidArgument(msg string) APIErrorRoot {
+	return apiError(http.StatusBadRequest, msg, "INVALID_ARGUMENT")
+}
+
+// apiErrorInvalidRequestBody is used when a request body cannot be parsed
+func apiErrorInvalidRequestBody() APIErrorRoot {
+	return apiError(http.StatusBadRequest, "Invalid Request Body", "BAD_REQUEST")
+}
+
+// apiErrorUnauthorized is used when a request carries no valid credentials
+func apiErrorUnauthorized() APIErrorRoot {
+	return apiError(http.StatusUnauthorized, "Unauthorized", "UNAUTHORIZED")
+}
+
+// apiErrorTokenExpired is used when a request carries a token past its expiry
+func apiErrorTokenExpired() APIErrorRoot {
+	return apiError(http.StatusUnauthorized, "Token has expired", "TOKEN_EXPIRED")
+}
+
+// apiErrorUserSuspended is used when a request carries a token of a
+// suspended account
+func apiErrorUserSuspended() APIErrorRoot {
+	return apiError(http.StatusForbidden, "Account is suspended", "USER_SUSPENDED")
+}
+
+// apiErrorForbidden is used when the credentials cannot access a resource
+func apiErrorForbidden() APIErrorRoot {
+	return apiError(http.StatusForbidden, "Access to this resource is forbidden", "FORBIDDEN")
+}
+
+// apiErrorQuotaExceeded is used when a project hits a resource count
+// quota; the details carry the live usage so automation can decide to
+// clean up rather than retry
+func apiErrorQuotaExceeded(resource string, used int64, limit int) APIErrorRoot {
+	root := apiError(http.StatusForbidden, "Project has reached its "+resource+" quota", "QUOTA_EXCEEDED")
+	root.Body.Details = map[string]int64{"used": used, "limit": int64(limit)}
+	return root
+}
+
+// apiErrorTimeout is used when an operation exceeds its deadline
+func apiErrorTimeout(msg string) APIErrorRoot {
+	return apiError(http.StatusRequestTimeout, msg, "TIMEOUT")
+}
+
+// apiErrorTooManyRequests is used when a priority class is saturated
+func apiErrorTooManyRequests() APIErrorRoot {
+	return apiError(http.StatusTooManyRequests, "Too many pending requests", "TOO_MANY_REQUESTS")
+}
+
+// apiErrorInternal is used when an unexpected backend error occurs
+func apiErrorInternal(msg string) APIErrorRoot {
+	return apiError(http.StatusInternalServerError, msg, "INTERNAL_SERVER_ERROR")
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/auth"
+)
+
+// RoleListAll (GET) returns the custom role definitions
+func RoleListAll(w http.ResponseWriter, r *http.Request) {
+	results, err := auth.FindRoleDefinitions("", getStr(r))
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := results.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// RoleListOne (GET) returns the details of a custom role definition
+func RoleListOne(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	results, err := auth.FindRoleDefinitions(urlVars["role"], getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Role"))
+		return
+	}
+	result := results.One()
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// RoleCreate (POST) defines a new custom role as a set of allowed routes
+func RoleCreate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody := struct {
+		Routes []string `json:"routes"`
+	}{}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	result, err := auth.CreateRoleDefinition(urlVars["role"], postBody.Routes, getStr(r))
+	if err != nil {
+		if err.Error() == "exists" {
+			respondErr(w, apiErrorConflict("Role"))
+			return
+		}
+		respondErr(w, apiErrorInvalidArgument(err.Error()))
+		return
+	}
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// RoleUpdate (PUT) replaces the routes of a custom role definition
+func RoleUpdate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	existing, err := auth.FindRoleDefinitions(urlVars["role"], getStr(r))
+	if err != nil || existing.Empty() {
+		respondErr(w, apiErrorNotFound("Role"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody := struct {
+		Routes []string `json:"routes"`
+	}{}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	result, err := auth.UpdateRoleDefinition(urlVars["role"], postBody.Routes, getStr(r))
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument(err.Error()))
+		return
+	}
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// RoleDelete (DELETE) removes a custom role definition
+func RoleDelete(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	if err := auth.RemoveRoleDefinition(urlVars["role"], getStr(r)); err != nil {
+		respondErr(w, apiErrorNotFound("Role"))
+		return
+	}
+	respondOK(w, []byte(""))
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kaggis/argo-messaging/messages"
+)
+
+// publishScheduler counts the messages held back by a delivery delay; each
+// one is handed to the broker by its own timer once it becomes due
+type publishScheduler struct {
+	sync.Mutex
+	pending int
+}
+
+// delayedPublishes tracks the messages scheduled for later delivery
+var delayedPublishes = &publishScheduler{}
+
+// schedule runs deliver once the due time passes; until then the message
+// is invisible to subscriptions since it never reached the broker
+func (ps *publishScheduler) schedule(due time.Time, deliver func() error) {
+	ps.Lock()
+	ps.pending++
+	ps.Unlock()
+	time.AfterFunc(time.Until(due), func() {
+		if err := deliver(); err != nil {
+			log.Errorf("HANDLERS: unable to deliver a scheduled message, %v", err)
+		}
+		ps.Lock()
+		ps.pending--
+		ps.Unlock()
+	})
+}
+
+// pendingCount returns the number of messages awaiting delivery
+func (ps *publishScheduler) pendingCount() int {
+	ps.Lock()
+	defer ps.Unlock()
+	return ps.pending
+}
+
+// deliveryTime resolves the requested delivery time of a message: a
+// deliverAfter attribute holds a delay in seconds, a client supplied
+// publishTime holds an absolute timestamp. The zero time means the
+// message is delivered right away.
+func deliveryTime(msg messages.Message, now time.Time) (time.Time, error) {
+	if msg.AttrExists("deliverAfter") {
+		secs, err := strconv.ParseInt(msg.Attrs["deliverAfter"], 10, 64)
+		if err != nil || secs < 0 {
+			return time.Time{}, errors.New("Invalid deliverAfter, a non negative delay in seconds is expected")
+		}
+		return now.Add(time.Duration(secs) * time.Second), nil
+	}
+	if msg.PubTime != "" {
+		t, err := time.Parse(time.RFC3339Nano, msg.PubTime)
+		if err != nil {
+			return time.Time{}, errors.New("Invalid publishTime, an rfc3339 timestamp is expected")
+		}
+		return t, nil
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/events"
+	"github.com/kaggis/argo-messaging/schemas"
+)
+
+// SchemaListAll (GET) returns the schemas of a project
+func SchemaListAll(w http.ResponseWriter, r *http.Request) {
+	results, err := schemas.Find(getProjectUUID(r), "", getStr(r))
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := results.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// SchemaListOne (GET) returns the details of a single schema
+func SchemaListOne(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	results, err := schemas.Find(getProjectUUID(r), urlVars["schema"], getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Schema"))
+		return
+	}
+	schema := results.One()
+	output, _ := schema.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// SchemaCreate (PUT) creates a new schema in a project
+func SchemaCreate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	schemaName := urlVars["schema"]
+
+	if !validName.MatchString(schemaName) {
+		respondErr(w, apiErrorInvalidArgument("Invalid schema name"))
+		return
+	}
+
+	postBody := struct {
+		Type      string          `json:"type"`
+		RawSchema json.RawMessage `json:"schema"`
+	}{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	result, err := schemas.Create(getProjectUUID(r), schemaName, postBody.Type, postBody.RawSchema, time.Now(), getStr(r))
+	if err != nil {
+		switch err.Error() {
+		case "exists":
+			respondErr(w, apiErrorConflict("Schema"))
+		case "unsupported schema type", "invalid schema":
+			respondErr(w, apiErrorInvalidArgument(err.Error()))
+		default:
+			respondErr(w, apiErrorInternal("Backend error"))
+		}
+		return
+	}
+
+	events.Emit(events.New("schema.created", urlVars["project"], result.FullName))
+
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// SchemaDelete (DELETE) removes a schema; schemas still attached to a
+// topic cannot be removed
+func SchemaDelete(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	schemaName := urlVars["schema"]
+
+	if err := schemas.Remove(getProjectUUID(r), schemaName, getStr(r)); err != nil {
+		switch err.Error() {
+		case "not found":
+			respondErr(w, apiErrorNotFound("Schema"))
+		case "in use":
+			respondErr(w, apiError(http.StatusConflict, "Schema is attached to one or more topics", "CONFLICT"))
+		default:
+			respondErr(w, apiErrorInternal("Backend error"))
+		}
+		return
+	}
+
+	events.Emit(events.New("schema.deleted", urlVars["project"], schemaName))
+	respondOK(w, []byte(""))
+}
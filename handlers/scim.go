@@ -0,0 +1,515 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/auth"
+)
+
+// the scim 2.0 schema urns of the provisioning api
+const (
+	scimUserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// scimMeta is the resource metadata block of a scim resource
+type scimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// scimEmail is one email entry of a scim user
+type scimEmail struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// scimUser is a service user in scim 2.0 form
+type scimUser struct {
+	Schemas  []string    `json:"schemas"`
+	ID       string      `json:"id"`
+	UserName string      `json:"userName"`
+	Emails   []scimEmail `json:"emails,omitempty"`
+	Active   bool        `json:"active"`
+	Meta     scimMeta    `json:"meta"`
+}
+
+// scimGroupMember is one member reference of a scim group
+type scimGroupMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+// scimGroup maps a project role onto a scim 2.0 group named
+// <project>:<role>, so the idm provisions memberships as group
+// assignments
+type scimGroup struct {
+	Schemas     []string          `json:"schemas"`
+	ID          string            `json:"id"`
+	DisplayName string            `json:"displayName"`
+	Members     []scimGroupMember `json:"members"`
+	Meta        scimMeta          `json:"meta"`
+}
+
+// scimListResponse is the scim 2.0 paged list envelope
+type scimListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// respondSCIM writes a scim response under the scim media type
+func respondSCIM(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(code)
+	if body != nil {
+		output, _ := json.MarshalIndent(body, "", "   ")
+		w.Write(output)
+	}
+}
+
+// respondSCIMError writes an error in the scim error format
+func respondSCIMError(w http.ResponseWriter, code int, detail string) {
+	respondSCIM(w, code, map[string]interface{}{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(code),
+		"detail":  detail,
+	})
+}
+
+// scimUserFromUser renders a service user as a scim resource
+func scimUserFromUser(user auth.User) scimUser {
+	result := scimUser{
+		Schemas:  []string{scimUserSchema},
+		ID:       user.UUID,
+		UserName: user.Name,
+		Active:   true,
+		Meta:     scimMeta{ResourceType: "User", Created: user.CreatedOn, LastModified: user.ModifiedOn},
+	}
+	if user.Email != "" {
+		result.Emails = []scimEmail{{Value: user.Email, Primary: true}}
+	}
+	return result
+}
+
+// scimPaginate applies the 1-based startIndex/count paging of scim lists
+func scimPaginate(r *http.Request, resources []interface{}) scimListResponse {
+	start := 1
+	if raw := r.URL.Query().Get("startIndex"); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 1 {
+			start = parsed
+		}
+	}
+	total := len(resources)
+	if start-1 < len(resources) {
+		resources = resources[start-1:]
+	} else {
+		resources = []interface{}{}
+	}
+	if raw := r.URL.Query().Get("count"); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed >= 0 && parsed < len(resources) {
+			resources = resources[:parsed]
+		}
+	}
+	return scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: total,
+		StartIndex:   start,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// scimEqFilter extracts the value of a `<attribute> eq "<value>"` filter;
+// other filter forms are not supported
+func scimEqFilter(r *http.Request, attribute string) string {
+	filter := strings.TrimSpace(r.URL.Query().Get("filter"))
+	if filter == "" {
+		return ""
+	}
+	rest, found := strings.CutPrefix(filter, attribute)
+	if !found {
+		return ""
+	}
+	rest = strings.TrimSpace(rest)
+	if rest, found = strings.CutPrefix(rest, "eq"); !found {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(rest), `"`)
+}
+
+// ScimUserList (GET) returns the users as scim resources, supporting the
+// userName eq filter of provisioning lookups
+func ScimUserList(w http.ResponseWriter, r *http.Request) {
+	results, err := auth.FindUsers("", "", scimEqFilter(r, "userName"), getStr(r))
+	if err != nil && err.Error() != "not found" {
+		respondSCIMError(w, http.StatusInternalServerError, "Backend error")
+		return
+	}
+	resources := []interface{}{}
+	for _, user := range results.List {
+		resources = append(resources, scimUserFromUser(user))
+	}
+	respondSCIM(w, http.StatusOK, scimPaginate(r, resources))
+}
+
+// ScimUserGet (GET) returns one user as a scim resource
+func ScimUserGet(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	results, err := auth.FindUsers("", urlVars["user"], "", getStr(r))
+	if err != nil || results.Empty() {
+		respondSCIMError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	respondSCIM(w, http.StatusOK, scimUserFromUser(results.One()))
+}
+
+// ScimUserCreate (POST) provisions a new user from a scim resource; the
+// api token is generated and handed out through the service api, not scim
+func ScimUserCreate(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondSCIMError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	postBody := scimUser{}
+	if err := json.Unmarshal(body, &postBody); err != nil || postBody.UserName == "" {
+		respondSCIMError(w, http.StatusBadRequest, "userName is required")
+		return
+	}
+
+	token, err := auth.GenToken()
+	if err != nil {
+		respondSCIMError(w, http.StatusInternalServerError, "Backend error")
+		return
+	}
+	email := ""
+	if len(postBody.Emails) > 0 {
+		email = postBody.Emails[0].Value
+	}
+	user, err := auth.CreateUser(uuid.New().String(), postBody.UserName, []auth.ProjectRoles{}, token, "",
+		email, nil, time.Now(), "scim", getStr(r))
+	if err != nil {
+		if err.Error() == "exists" {
+			respondSCIMError(w, http.StatusConflict, "userName is already taken")
+			return
+		}
+		respondSCIMError(w, http.StatusInternalServerError, "Backend error")
+		return
+	}
+	respondSCIM(w, http.StatusCreated, scimUserFromUser(user))
+}
+
+// ScimUserReplace (PUT) updates the name and email of a user from a scim
+// resource
+func ScimUserReplace(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	str := getStr(r)
+
+	existing, err := auth.FindUsers("", urlVars["user"], "", str)
+	if err != nil || existing.Empty() {
+		respondSCIMError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondSCIMError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	postBody := scimUser{}
+	if err := json.Unmarshal(body, &postBody); err != nil || postBody.UserName == "" {
+		respondSCIMError(w, http.StatusBadRequest, "userName is required")
+		return
+	}
+
+	email := ""
+	if len(postBody.Emails) > 0 {
+		email = postBody.Emails[0].Value
+	}
+	user, err := auth.UpdateUser(urlVars["user"], postBody.UserName, nil, email, nil, "", time.Now(), str)
+	if err != nil {
+		if err.Error() == "exists" {
+			respondSCIMError(w, http.StatusConflict, "userName is already taken")
+			return
+		}
+		respondSCIMError(w, http.StatusInternalServerError, "Backend error")
+		return
+	}
+	respondSCIM(w, http.StatusOK, scimUserFromUser(user))
+}
+
+// ScimUserDelete (DELETE) deprovisions a user
+func ScimUserDelete(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	if err := auth.RemoveUser(urlVars["user"], getStr(r)); err != nil {
+		respondSCIMError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	respondSCIM(w, http.StatusNoContent, nil)
+}
+
+// scimGroupRoles lists the roles exposed as groups: the built-in project
+// roles plus the stored custom role definitions
+func scimGroupRoles(r *http.Request) []string {
+	roles := []string{"project_admin", "publisher", "consumer"}
+	if defs, err := auth.FindRoleDefinitions("", getStr(r)); err == nil {
+		for _, def := range defs.List {
+			roles = append(roles, def.Name)
+		}
+	}
+	return roles
+}
+
+// ScimGroupList (GET) returns one group per project role, with the
+// displayName eq filter of provisioning lookups
+func ScimGroupList(w http.ResponseWriter, r *http.Request) {
+	str := getStr(r)
+
+	qProjects, err := str.QueryProjects("", "")
+	if err != nil {
+		respondSCIMError(w, http.StatusInternalServerError, "Backend error")
+		return
+	}
+	nameFilter := scimEqFilter(r, "displayName")
+	resources := []interface{}{}
+	for _, project := range qProjects {
+		for _, role := range scimGroupRoles(r) {
+			displayName := project.Name + ":" + role
+			if nameFilter != "" && nameFilter != displayName {
+				continue
+			}
+			group, err := scimGroupOf(r, project.Name, project.UUID, role)
+			if err != nil {
+				respondSCIMError(w, http.StatusInternalServerError, "Backend error")
+				return
+			}
+			resources = append(resources, group)
+		}
+	}
+	respondSCIM(w, http.StatusOK, scimPaginate(r, resources))
+}
+
+// scimGroupOf assembles the group resource of one project role
+func scimGroupOf(r *http.Request, projectName string, projectUUID string, role string) (scimGroup, error) {
+	group := scimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          projectName + ":" + role,
+		DisplayName: projectName + ":" + role,
+		Members:     []scimGroupMember{},
+		Meta:        scimMeta{ResourceType: "Group"},
+	}
+	qUsers, err := getStr(r).QueryUsers(projectUUID, "", "")
+	if err != nil {
+		return group, err
+	}
+	for _, user := range qUsers {
+		for _, membership := range user.Projects {
+			if membership.ProjectUUID != projectUUID {
+				continue
+			}
+			for _, held := range membership.Roles {
+				if held == role {
+					group.Members = append(group.Members, scimGroupMember{Value: user.UUID, Display: user.Name})
+				}
+			}
+		}
+	}
+	sort.Slice(group.Members, func(i, j int) bool { return group.Members[i].Value < group.Members[j].Value })
+	return group, nil
+}
+
+// scimResolveGroup splits a group id into the project and role behind it
+func scimResolveGroup(r *http.Request, groupID string) (string, string, string, bool) {
+	projectName, role, found := strings.Cut(groupID, ":")
+	if !found {
+		return "", "", "", false
+	}
+	qProjects, err := getStr(r).QueryProjects("", projectName)
+	if err != nil || len(qProjects) == 0 {
+		return "", "", "", false
+	}
+	for _, known := range scimGroupRoles(r) {
+		if known == role {
+			return projectName, qProjects[0].UUID, role, true
+		}
+	}
+	return "", "", "", false
+}
+
+// ScimGroupGet (GET) returns the group of one project role
+func ScimGroupGet(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	projectName, projectUUID, role, ok := scimResolveGroup(r, urlVars["group"])
+	if !ok {
+		respondSCIMError(w, http.StatusNotFound, "Group not found")
+		return
+	}
+	group, err := scimGroupOf(r, projectName, projectUUID, role)
+	if err != nil {
+		respondSCIMError(w, http.StatusInternalServerError, "Backend error")
+		return
+	}
+	respondSCIM(w, http.StatusOK, group)
+}
+
+// scimPatchOp is one operation of a scim patch request
+type scimPatchOp struct {
+	Op    string            `json:"op"`
+	Path  string            `json:"path"`
+	Value []scimGroupMember `json:"value"`
+}
+
+// ScimGroupPatch (PATCH) adds, removes or replaces the members of a
+// group, granting and revoking the project role behind it
+func ScimGroupPatch(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	projectName, projectUUID, role, ok := scimResolveGroup(r, urlVars["group"])
+	if !ok {
+		respondSCIMError(w, http.StatusNotFound, "Group not found")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondSCIMError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	postBody := struct {
+		Operations []scimPatchOp `json:"Operations"`
+	}{}
+	if err := json.Unmarshal(body, &postBody); err != nil || len(postBody.Operations) == 0 {
+		respondSCIMError(w, http.StatusBadRequest, "Operations are required")
+		return
+	}
+
+	for _, op := range postBody.Operations {
+		members := op.Value
+		switch strings.ToLower(op.Op) {
+		case "add":
+			for _, member := range members {
+				if err := scimSetRole(r, member.Value, projectName, role, true); err != nil {
+					respondSCIMError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+		case "remove":
+			// the filter form members[value eq "<id>"] names the member
+			// in the path instead of the value list
+			if target := scimPathMember(op.Path); target != "" {
+				members = []scimGroupMember{{Value: target}}
+			}
+			for _, member := range members {
+				if err := scimSetRole(r, member.Value, projectName, role, false); err != nil {
+					respondSCIMError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+		case "replace":
+			current, err := scimGroupOf(r, projectName, projectUUID, role)
+			if err != nil {
+				respondSCIMError(w, http.StatusInternalServerError, "Backend error")
+				return
+			}
+			desired := map[string]bool{}
+			for _, member := range members {
+				desired[member.Value] = true
+				if err := scimSetRole(r, member.Value, projectName, role, true); err != nil {
+					respondSCIMError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+			for _, member := range current.Members {
+				if desired[member.Value] {
+					continue
+				}
+				if err := scimSetRole(r, member.Value, projectName, role, false); err != nil {
+					respondSCIMError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+		default:
+			respondSCIMError(w, http.StatusBadRequest, "Unsupported patch op "+op.Op)
+			return
+		}
+	}
+	group, err := scimGroupOf(r, projectName, projectUUID, role)
+	if err != nil {
+		respondSCIMError(w, http.StatusInternalServerError, "Backend error")
+		return
+	}
+	respondSCIM(w, http.StatusOK, group)
+}
+
+// scimPathMember extracts the member id of a members[value eq "<id>"]
+// patch path
+func scimPathMember(path string) string {
+	rest, found := strings.CutPrefix(strings.TrimSpace(path), "members[value eq ")
+	if !found {
+		return ""
+	}
+	rest, found = strings.CutSuffix(rest, "]")
+	if !found {
+		return ""
+	}
+	return strings.Trim(rest, `"`)
+}
+
+// scimSetRole grants or revokes one project role of a user; memberships
+// left without roles are dropped
+func scimSetRole(r *http.Request, userUUID string, projectName string, role string, grant bool) error {
+	str := getStr(r)
+	results, err := auth.FindUsers("", userUUID, "", str)
+	if err != nil || results.Empty() {
+		return errors.New("member " + userUUID + " does not exist")
+	}
+	user := results.One()
+
+	projects := []auth.ProjectRoles{}
+	handled := false
+	for _, membership := range user.Projects {
+		if membership.Project != projectName {
+			projects = append(projects, membership)
+			continue
+		}
+		handled = true
+		roles := []string{}
+		for _, held := range membership.Roles {
+			if held != role {
+				roles = append(roles, held)
+			}
+		}
+		if grant {
+			roles = append(roles, role)
+			sort.Strings(roles)
+		}
+		if len(roles) > 0 {
+			membership.Roles = roles
+			projects = append(projects, membership)
+		}
+	}
+	if grant && !handled {
+		projects = append(projects, auth.ProjectRoles{Project: projectName, Roles: []string{role}})
+	}
+
+	_, err = auth.UpdateUser(user.UUID, "", projects, user.Email, nil, "", time.Now(), str)
+	return err
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/auth"
+)
+
+// ServiceAccountListAll (GET) returns the service accounts of a project
+func ServiceAccountListAll(w http.ResponseWriter, r *http.Request) {
+	results, err := auth.FindServiceAccounts(getProjectUUID(r), "", getStr(r))
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := results.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// ServiceAccountListOne (GET) returns the details of a service account
+func ServiceAccountListOne(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	results, err := auth.FindServiceAccounts(getProjectUUID(r), urlVars["serviceAccount"], getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Service account"))
+		return
+	}
+	result := results.One()
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// ServiceAccountCreate (PUT) creates a new service account with the
+// given scopes, returning its token once
+func ServiceAccountCreate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody := struct {
+		Scopes []auth.ServiceAccountScope `json:"scopes"`
+	}{}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	result, err := auth.CreateServiceAccount(getProjectUUID(r), uuid.New().String(), urlVars["serviceAccount"],
+		postBody.Scopes, time.Now(), getUserUUID(r), getStr(r))
+	if err != nil {
+		if err.Error() == "exists" {
+			respondErr(w, apiErrorConflict("Service account"))
+			return
+		}
+		respondErr(w, apiErrorInvalidArgument(err.Error()))
+		return
+	}
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// ServiceAccountUpdate (POST) replaces the scopes of a service account
+func ServiceAccountUpdate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	existing, err := auth.FindServiceAccounts(getProjectUUID(r), urlVars["serviceAccount"], getStr(r))
+	if err != nil || existing.Empty() {
+		respondErr(w, apiErrorNotFound("Service account"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody := struct {
+		Scopes []auth.ServiceAccountScope `json:"scopes"`
+	}{}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	result, err := auth.UpdateServiceAccountScopes(getProjectUUID(r), urlVars["serviceAccount"],
+		postBody.Scopes, time.Now(), getStr(r))
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument(err.Error()))
+		return
+	}
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// ServiceAccountDelete (DELETE) removes a service account
+func ServiceAccountDelete(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	if err := auth.RemoveServiceAccount(getProjectUUID(r), urlVars["serviceAccount"], getStr(r)); err != nil {
+		respondErr(w, apiErrorNotFound("Service account"))
+		return
+	}
+	respondOK(w, []byte(""))
+}
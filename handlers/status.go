@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/logging"
+	"github.com/kaggis/argo-messaging/metrics"
+	"github.com/kaggis/argo-messaging/status"
+)
+
+// serviceStart marks the startup time of the service
+var serviceStart = time.Now()
+
+// statusResponse holds the service status details
+type statusResponse struct {
+	Status      string `json:"status"`
+	PushEnabled bool   `json:"push_enabled"`
+	Uptime      string `json:"uptime"`
+	// Components maps a component name to "ok" or its error text
+	Components map[string]string `json:"components"`
+}
+
+// metricsResponse holds the service wide metrics
+type metricsResponse struct {
+	// PublishLatency summarizes the publish-path latency per project for
+	// messages stamped with a client sendTime attribute
+	PublishLatency map[string]metrics.LatencySummary `json:"publish_latency"`
+}
+
+// OpsMetrics (GET) returns the service wide metrics
+func OpsMetrics(w http.ResponseWriter, r *http.Request) {
+	snapshot := metrics.PublishLatency.Snapshot()
+	if r.URL.Query().Get("format") == "csv" {
+		projects := make([]string, 0, len(snapshot))
+		for project := range snapshot {
+			projects = append(projects, project)
+		}
+		sort.Strings(projects)
+		rows := [][]string{{"project", "count", "min_ms", "mean_ms", "p50_ms", "p95_ms", "p99_ms", "max_ms"}}
+		for _, project := range projects {
+			summary := snapshot[project]
+			rows = append(rows, []string{project, strconv.FormatInt(summary.Count, 10),
+				formatMs(summary.MinMs), formatMs(summary.MeanMs), formatMs(summary.P50Ms),
+				formatMs(summary.P95Ms), formatMs(summary.P99Ms), formatMs(summary.MaxMs)})
+		}
+		respondCSV(w, "metrics.csv", rows)
+		return
+	}
+	response := metricsResponse{PublishLatency: snapshot}
+	output, _ := json.MarshalIndent(response, "", "   ")
+	respondOK(w, output)
+}
+
+// formatMs renders a fractional millisecond value for csv output
+func formatMs(ms float64) string {
+	return strconv.FormatFloat(ms, 'f', -1, 64)
+}
+
+// usageReportEntry aggregates the byte counters of the projects sharing
+// a value of the report tag
+type usageReportEntry struct {
+	Tag          string   `json:"tag"`
+	Projects     []string `json:"projects"`
+	PublishBytes int64    `json:"publish_bytes"`
+	ConsumeBytes int64    `json:"consume_bytes"`
+}
+
+// usageReportResponse holds the per-tag usage rollup
+type usageReportResponse struct {
+	TagKey string             `json:"tag_key"`
+	Report []usageReportEntry `json:"report"`
+}
+
+// OpsUsageReport (GET) rolls the publish and consume byte counters up by
+// project tag for cost allocation; projects without the tag are grouped
+// under "untagged". The tag key defaults to cost-center
+func OpsUsageReport(w http.ResponseWriter, r *http.Request) {
+	str := getStr(r)
+	tagKey := r.URL.Query().Get("tag")
+	if tagKey == "" {
+		tagKey = "cost-center"
+	}
+
+	qProjects, err := str.QueryProjects("", "")
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	entries := make(map[string]*usageReportEntry)
+	for _, project := range qProjects {
+		tag := project.Tags[tagKey]
+		if tag == "" {
+			tag = "untagged"
+		}
+		entry, ok := entries[tag]
+		if !ok {
+			entry = &usageReportEntry{Tag: tag, Projects: []string{}}
+			entries[tag] = entry
+		}
+		entry.Projects = append(entry.Projects, project.Name)
+
+		topics, _, _, err := str.QueryTopics(project.UUID, "", "", "", 0, "", false)
+		if err != nil {
+			respondErr(w, apiErrorInternal("Backend error"))
+			return
+		}
+		for _, topic := range topics {
+			entry.PublishBytes += topic.TotalBytes
+		}
+		subs, _, _, err := str.QuerySubs(project.UUID, "", "", "", 0, "", false)
+		if err != nil {
+			respondErr(w, apiErrorInternal("Backend error"))
+			return
+		}
+		for _, sub := range subs {
+			entry.ConsumeBytes += sub.TotalBytes
+		}
+	}
+
+	response := usageReportResponse{TagKey: tagKey, Report: []usageReportEntry{}}
+	for _, entry := range entries {
+		response.Report = append(response.Report, *entry)
+	}
+	sort.Slice(response.Report, func(i, j int) bool {
+		return response.Report[i].Tag < response.Report[j].Tag
+	})
+
+	output, _ := json.MarshalIndent(response, "", "   ")
+	respondOK(w, output)
+}
+
+// alertRulesTemplate renders a prometheus rule file from the configured
+// alert thresholds so monitoring config stays in sync with the service
+var alertRulesTemplate = template.Must(template.New("alertRules").Parse(`groups:
+  - name: ams.alerts
+    rules:
+      - alert: AMSSubscriptionLag
+        expr: ams_subscription_lag_messages > {{.SubscriptionLag}}
+        for: 5m
+        labels:
+          severity: warning
+        annotations:
+          summary: "Subscription {{"{{ $labels.subscription }}"}} lags more than {{.SubscriptionLag}} messages"
+      - alert: AMSPushFailures
+        expr: rate(ams_push_failures_total[5m]) * 60 > {{.PushFailuresPerMin}}
+        for: 5m
+        labels:
+          severity: warning
+        annotations:
+          summary: "Subscription {{"{{ $labels.subscription }}"}} fails more than {{.PushFailuresPerMin}} pushes per minute"
+      - alert: AMSQuotaSaturation
+        expr: ams_project_quota_usage_ratio * 100 > {{.QuotaSaturationPct}}
+        for: 15m
+        labels:
+          severity: critical
+        annotations:
+          summary: "Project {{"{{ $labels.project }}"}} uses more than {{.QuotaSaturationPct}}% of its quota"
+`))
+
+// OpsAlertRules (GET) renders the recommended prometheus alert rules
+// parameterized by the configured thresholds
+func OpsAlertRules(w http.ResponseWriter, r *http.Request) {
+	cfg := getCfg(r)
+	params := struct {
+		SubscriptionLag    int
+		PushFailuresPerMin int
+		QuotaSaturationPct int
+	}{cfg.AlertSubscriptionLag, cfg.AlertPushFailuresPerMin, cfg.AlertQuotaSaturationPct}
+
+	output := bytes.Buffer{}
+	if err := alertRulesTemplate.Execute(&output, params); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-yaml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(output.Bytes())
+}
+
+// LogLevelsShow (GET) returns the effective log level of each subsystem
+func LogLevelsShow(w http.ResponseWriter, r *http.Request) {
+	output, _ := json.MarshalIndent(map[string]map[string]string{"levels": logging.Levels()}, "", "   ")
+	respondOK(w, output)
+}
+
+// LogLevelsModify (POST) overrides the log level of named subsystems at
+// runtime, e.g. to turn on broker debug logging during an incident; the
+// level "default" drops an override again
+func LogLevelsModify(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody := struct {
+		Levels map[string]string `json:"levels"`
+	}{}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if len(postBody.Levels) == 0 {
+		respondErr(w, apiErrorInvalidArgument("Level list is empty"))
+		return
+	}
+
+	for module, level := range postBody.Levels {
+		if err := logging.SetModuleLevel(module, level); err != nil {
+			respondErr(w, apiErrorInvalidArgument(err.Error()))
+			return
+		}
+	}
+
+	output, _ := json.MarshalIndent(map[string]map[string]string{"levels": logging.Levels()}, "", "   ")
+	respondOK(w, output)
+}
+
+// ConfigShow (GET) returns the effective service configuration with
+// secrets redacted, so operators can inspect what the service runs with
+// after defaults and deprecated keys were resolved
+func ConfigShow(w http.ResponseWriter, r *http.Request) {
+	output, _ := json.MarshalIndent(getCfg(r).Effective(), "", "   ")
+	respondOK(w, output)
+}
+
+// OpsStatus (GET) returns the operational status of the service along
+// with the live health of its components
+func OpsStatus(w http.ResponseWriter, r *http.Request) {
+	snapshot := status.Capture(time.Now(), getPool(r), getStr(r), getPush(r), getCfg(r).PushServerHost)
+	response := statusResponse{
+		Status:      snapshot.Status,
+		PushEnabled: getCfg(r).PushEnabled,
+		Uptime:      time.Since(serviceStart).Truncate(time.Second).String(),
+		Components:  snapshot.Components,
+	}
+	output, _ := json.MarshalIndent(response, "", "   ")
+	respondOK(w, output)
+}
+
+// Healthz (GET) is the liveness probe: a response means the process is
+// up, no dependency is consulted
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	output, _ := json.MarshalIndent(map[string]string{"status": "alive"}, "", "   ")
+	respondOK(w, output)
+}
+
+// Readyz (GET) is the readiness probe: the store, every broker cluster
+// and the push server are verified and traffic should only be routed
+// here when all of them answer
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	snapshot := status.Capture(time.Now(), getPool(r), getStr(r), getPush(r), getCfg(r).PushServerHost)
+	response := statusSnapshot{
+		CapturedOn: snapshot.CapturedOn,
+		Status:     snapshot.Status,
+		Components: snapshot.Components,
+	}
+	output, _ := json.MarshalIndent(response, "", "   ")
+	if snapshot.Status != "ok" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(output)
+		return
+	}
+	respondOK(w, output)
+}
+
+// statusSnapshot is one persisted health snapshot as served by the api
+type statusSnapshot struct {
+	CapturedOn string            `json:"captured_on"`
+	Status     string            `json:"status"`
+	Components map[string]string `json:"components"`
+}
+
+// statusHistoryResponse holds the health snapshots of the requested window
+type statusHistoryResponse struct {
+	Snapshots []statusSnapshot `json:"snapshots"`
+}
+
+// OpsStatusHistory (GET) returns the health snapshots captured over the
+// requested number of hours (default 24), newest first, so post-incident
+// reviews do not depend on external monitoring retention
+func OpsStatusHistory(w http.ResponseWriter, r *http.Request) {
+	hours := 24
+	if raw := r.URL.Query().Get("hours"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > 168 {
+			respondErr(w, apiErrorInvalidArgument("Hours must be between 1 and 168"))
+			return
+		}
+		hours = parsed
+	}
+	since := time.Now().UTC().Add(-time.Duration(hours) * time.Hour).Format(time.RFC3339)
+
+	qSnapshots, err := getStr(r).QueryStatusSnapshots(since)
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	response := statusHistoryResponse{Snapshots: []statusSnapshot{}}
+	for _, item := range qSnapshots {
+		response.Snapshots = append(response.Snapshots,
+			statusSnapshot{CapturedOn: item.CapturedOn, Status: item.Status, Components: item.Components})
+	}
+	output, _ := json.MarshalIndent(response, "", "   ")
+	respondOK(w, output)
+}
+
+// overviewInstance is one service instance as derived from the singleton
+// job leases it holds
+type overviewInstance struct {
+	Lock      string `json:"lock"`
+	Holder    string `json:"holder"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// overviewTotals holds the resource counts across all projects
+type overviewTotals struct {
+	Projects      int `json:"projects"`
+	Topics        int `json:"topics"`
+	Subscriptions int `json:"subscriptions"`
+}
+
+// overviewLaggingSub is a subscription together with its broker lag
+type overviewLaggingSub struct {
+	Subscription string `json:"subscription"`
+	Lag          int64  `json:"lag"`
+}
+
+// overviewFailingPush is a push subscription with delivery failures on
+// record
+type overviewFailingPush struct {
+	Subscription string `json:"subscription"`
+	Endpoint     string `json:"endpoint"`
+	ErrorNum     int64  `json:"error_num"`
+	LatestPush   string `json:"latest_push,omitempty"`
+}
+
+// overviewQuotaBreach is a project at or over one of its quota limits
+type overviewQuotaBreach struct {
+	Project  string `json:"project"`
+	Resource string `json:"resource"`
+	Used     int    `json:"used"`
+	Limit    int    `json:"limit"`
+}
+
+// overviewResponse aggregates the data operators reach for first during
+// an incident
+type overviewResponse struct {
+	Status               string                `json:"status"`
+	Components           map[string]string     `json:"components"`
+	Instances            []overviewInstance    `json:"instances"`
+	Totals               overviewTotals        `json:"totals"`
+	LaggingSubscriptions []overviewLaggingSub  `json:"lagging_subscriptions"`
+	FailingPushEndpoints []overviewFailingPush `json:"failing_push_endpoints"`
+	QuotaBreaches        []overviewQuotaBreach `json:"quota_breaches"`
+}
+
+// overviewLaggingLimit caps how many lagging subscriptions the overview
+// reports
+const overviewLaggingLimit = 5
+
+// OpsOverview (GET) aggregates component health, known instances,
+// resource totals, the top lagging subscriptions, failing push endpoints
+// and quota breaches into a single admin view
+func OpsOverview(w http.ResponseWriter, r *http.Request) {
+	str := getStr(r)
+
+	snapshot := status.Capture(time.Now(), getPool(r), str, getPush(r), getCfg(r).PushServerHost)
+	response := overviewResponse{
+		Status:               snapshot.Status,
+		Components:           snapshot.Components,
+		Instances:            []overviewInstance{},
+		LaggingSubscriptions: []overviewLaggingSub{},
+		FailingPushEndpoints: []overviewFailingPush{},
+		QuotaBreaches:        []overviewQuotaBreach{},
+	}
+
+	qLocks, err := str.QueryLocks()
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	for _, lock := range qLocks {
+		response.Instances = append(response.Instances,
+			overviewInstance{Lock: lock.Name, Holder: lock.Holder, ExpiresAt: lock.ExpiresAt})
+	}
+
+	qProjects, err := str.QueryProjects("", "")
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	response.Totals.Projects = len(qProjects)
+
+	for _, project := range qProjects {
+		topics, topicNum, _, err := str.QueryTopics(project.UUID, "", "", "", 0, "", false)
+		if err != nil {
+			respondErr(w, apiErrorInternal("Backend error"))
+			return
+		}
+		subs, subNum, _, err := str.QuerySubs(project.UUID, "", "", "", 0, "", false)
+		if err != nil {
+			respondErr(w, apiErrorInternal("Backend error"))
+			return
+		}
+		response.Totals.Topics += int(topicNum)
+		response.Totals.Subscriptions += int(subNum)
+
+		if project.MaxTopics > 0 && len(topics) >= project.MaxTopics {
+			response.QuotaBreaches = append(response.QuotaBreaches,
+				overviewQuotaBreach{Project: project.Name, Resource: "topics", Used: len(topics), Limit: project.MaxTopics})
+		}
+		if project.MaxSubs > 0 && len(subs) >= project.MaxSubs {
+			response.QuotaBreaches = append(response.QuotaBreaches,
+				overviewQuotaBreach{Project: project.Name, Resource: "subscriptions", Used: len(subs), Limit: project.MaxSubs})
+		}
+
+		brk := brokers.ForProject(getPool(r), str, project.UUID)
+		for _, sub := range subs {
+			subName := "/projects/" + project.Name + "/subscriptions/" + sub.Name
+			lag := brk.GetMaxOffset(project.UUID+"."+sub.Topic) - sub.Offset
+			if lag > 0 {
+				response.LaggingSubscriptions = append(response.LaggingSubscriptions,
+					overviewLaggingSub{Subscription: subName, Lag: lag})
+			}
+			if sub.PushEndpoint != "" && sub.PushErrorNum > 0 {
+				response.FailingPushEndpoints = append(response.FailingPushEndpoints,
+					overviewFailingPush{Subscription: subName, Endpoint: sub.PushEndpoint,
+						ErrorNum: sub.PushErrorNum, LatestPush: sub.LatestPush})
+			}
+		}
+	}
+
+	sort.Slice(response.LaggingSubscriptions, func(i, j int) bool {
+		return response.LaggingSubscriptions[i].Lag > response.LaggingSubscriptions[j].Lag
+	})
+	if len(response.LaggingSubscriptions) > overviewLaggingLimit {
+		response.LaggingSubscriptions = response.LaggingSubscriptions[:overviewLaggingLimit]
+	}
+
+	output, _ := json.MarshalIndent(response, "", "   ")
+	respondOK(w, output)
+}
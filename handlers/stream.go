@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/messages"
+	"github.com/kaggis/argo-messaging/names"
+	"github.com/kaggis/argo-messaging/subscriptions"
+)
+
+// streamPollInterval is how often the stream polls the broker when the
+// subscription has no new messages
+const streamPollInterval = 250 * time.Millisecond
+
+// streamBatchSize bounds the messages delivered between in-band acks
+const streamBatchSize = int64(100)
+
+// upgrader upgrades streaming requests to websocket connections
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// streamAck is the in-band ack frame sent by the client over the stream
+type streamAck struct {
+	AckIDs []string `json:"ackIds"`
+}
+
+// SubSSE (GET) streams the messages of a subscription as server-sent
+// events for browser-based consumers. Delivery is one-way: clients ack
+// through the regular :acknowledge call, which advances the offset the
+// same way a pull does. Keep-alive comments keep idle connections open
+// through proxies.
+func SubSSE(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", subName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	sub := results.One()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondErr(w, apiErrorInternal("Streaming unsupported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	brk := brokers.ForProject(getPool(r), str, projectUUID)
+	fullTopic := sub.ConsumeTopic()
+	offset := sub.Offset
+
+	poll := time.NewTicker(streamPollInterval)
+	defer poll.Stop()
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		msgs, err := brk.Consume(fullTopic, offset, true, streamBatchSize)
+		if err != nil {
+			if err.Error() == "offset is off" {
+				offset = brk.GetMinOffset(fullTopic)
+				str.UpdateSubOffset(projectUUID, subName, offset)
+				continue
+			}
+			return
+		}
+
+		for i, payload := range msgs {
+			msg, err := messages.LoadMsgJSON([]byte(payload))
+			if err != nil {
+				return
+			}
+			ackID := names.AckID(sub.FullName, offset+int64(i))
+			data, _ := json.Marshal(messages.RecMsg{AckID: ackID, Msg: msg})
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+		}
+		if len(msgs) > 0 {
+			flusher.Flush()
+			offset += int64(len(msgs))
+			// stamp the delivery so a companion :acknowledge call within
+			// the ack deadline advances the offset
+			str.UpdateSubPull(projectUUID, subName, offset, time.Now().UTC().Format(time.RFC3339Nano))
+			str.IncrementSubMsgNum(projectUUID, subName, int64(len(msgs)))
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-poll.C:
+		}
+	}
+}
+
+// SubStream (GET) upgrades to a websocket connection and continuously
+// delivers the messages of a subscription, advancing the offset on
+// in-band acks so consumers do not have to poll :pull in a loop
+func SubStream(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", subName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	sub := results.One()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already written the error response
+		return
+	}
+	defer conn.Close()
+
+	brk := brokers.ForProject(getPool(r), str, projectUUID)
+	fullTopic := sub.ConsumeTopic()
+	offset := sub.Offset
+
+	for {
+		msgs, err := brk.Consume(fullTopic, offset, true, streamBatchSize)
+		if err != nil {
+			if err.Error() == "offset is off" {
+				// the offset fell behind the topic retention, fast forward
+				// to the earliest available message
+				offset = brk.GetMinOffset(fullTopic)
+				str.UpdateSubOffset(projectUUID, subName, offset)
+				continue
+			}
+			return
+		}
+
+		if len(msgs) == 0 {
+			// nothing new; probe the connection so closed clients are
+			// detected instead of polling forever
+			deadline := time.Now().Add(streamPollInterval)
+			if err := conn.WriteControl(websocket.PingMessage, []byte{}, deadline); err != nil {
+				return
+			}
+			time.Sleep(streamPollInterval)
+			continue
+		}
+
+		for i, payload := range msgs {
+			msg, err := messages.LoadMsgJSON([]byte(payload))
+			if err != nil {
+				return
+			}
+			ackID := names.AckID(sub.FullName, offset+int64(i))
+			if err := conn.WriteJSON(messages.RecMsg{AckID: ackID, Msg: msg}); err != nil {
+				return
+			}
+		}
+
+		str.UpdateSubPull(projectUUID, subName, offset+int64(len(msgs)), time.Now().UTC().Format(time.RFC3339Nano))
+		str.IncrementSubMsgNum(projectUUID, subName, int64(len(msgs)))
+
+		// the batch must be acked in-band within the ack deadline before
+		// further messages are delivered
+		conn.SetReadDeadline(time.Now().Add(time.Duration(sub.Ack) * time.Second))
+		ack := streamAck{}
+		if err := conn.ReadJSON(&ack); err != nil {
+			return
+		}
+		valid := len(ack.AckIDs) > 0
+		for _, ackID := range ack.AckIDs {
+			if !strings.HasPrefix(ackID, sub.FullName+":") {
+				valid = false
+				break
+			}
+		}
+		if !valid {
+			return
+		}
+		maxAckID, err := subscriptions.GetMaxAckID(ack.AckIDs)
+		if err != nil {
+			return
+		}
+		ackOffset, _ := subscriptions.GetOffsetFromAckID(maxAckID)
+		if err := str.UpdateSubOffsetAck(projectUUID, subName, ackOffset+1, time.Now().UTC().Format(time.RFC3339Nano)); err != nil {
+			return
+		}
+		offset = ackOffset + 1
+	}
+}
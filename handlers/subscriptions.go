@@ -0,0 +1,1216 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/events"
+	"github.com/kaggis/argo-messaging/messages"
+	"github.com/kaggis/argo-messaging/metrics"
+	"github.com/kaggis/argo-messaging/names"
+	"github.com/kaggis/argo-messaging/projects"
+	"github.com/kaggis/argo-messaging/push"
+	"github.com/kaggis/argo-messaging/stores"
+	"github.com/kaggis/argo-messaging/subscriptions"
+	"github.com/kaggis/argo-messaging/topics"
+)
+
+// pullOptions holds the parameters of a pull request
+type pullOptions struct {
+	MaxMessages string `json:"maxMessages"`
+	// MaxBytes bounds the total payload size of the returned messages
+	MaxBytes string `json:"maxBytes"`
+	// ReturnImmediately set to "false" holds the request until messages
+	// arrive or the configured max wait expires
+	ReturnImmediately string `json:"returnImmediately"`
+}
+
+// SubListAll (GET) returns a page of the subscriptions of a project
+func SubListAll(w http.ResponseWriter, r *http.Request) {
+	pageToken := r.URL.Query().Get("pageToken")
+	pageSize, err := parsePageSize(r)
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid page size"))
+		return
+	}
+
+	selector, err := parseLabelSelector(r)
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid label selector"))
+		return
+	}
+	orderBy, descending, err := parseOrderBy(r, "name", "created_on")
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid sort parameters"))
+		return
+	}
+
+	userUUID := ""
+	if getCfg(r).ResAuth && !isPrivileged(r) {
+		userUUID = getUserUUID(r)
+	}
+
+	var results subscriptions.PaginatedSubscriptions
+	if len(selector) > 0 {
+		results, err = subscriptions.FindByLabels(getProjectUUID(r), userUUID, selector, getStr(r))
+	} else {
+		results, err = subscriptions.FindSorted(getProjectUUID(r), userUUID, "", pageToken, pageSize, orderBy, descending, getStr(r))
+	}
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid page token"))
+		return
+	}
+	output, _ := results.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// SubListOne (GET) returns the details of a single subscription
+func SubListOne(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	results, err := subscriptions.Find(getProjectUUID(r), "", urlVars["subscription"], "", 0, getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", urlVars["subscription"]) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	sub := results.One()
+	output, _ := sub.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// SubCreate (PUT) creates a new subscription
+func SubCreate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	if !validName.MatchString(subName) {
+		respondErr(w, apiErrorInvalidArgument("Invalid subscription name"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody, err := subscriptions.GetFromJSON(body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	// the referenced topic must be in the same project
+	topicProject, topicName, pErr := names.ParseTopic(postBody.FullTopic)
+	if pErr != nil || topicProject != urlVars["project"] {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	tResults, tErr := topics.Find(projectUUID, "", topicName, "", 0, str)
+	if tErr != nil || tResults.Empty() {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	// routing topics store nothing themselves, their member topics are
+	// the ones to subscribe to
+	if tResults.One().Routing {
+		respondErr(w, apiError(http.StatusConflict, "Cannot subscribe to a routing topic", "CONFLICT"))
+		return
+	}
+
+	if prj, pjErr := projects.Find(projectUUID, "", str); pjErr == nil && !prj.Empty() {
+		p := prj.One()
+		if p.MaxSubscriptions > 0 && p.SubscriptionsCount >= int64(p.MaxSubscriptions) {
+			respondErr(w, apiErrorQuotaExceeded("subscriptions", p.SubscriptionsCount, p.MaxSubscriptions))
+			return
+		}
+	}
+
+	if postBody.ReplayDays < 0 || postBody.ReplayDays > 365 {
+		respondErr(w, apiErrorInvalidArgument("Replay days must be between 1 and 365"))
+		return
+	}
+
+	// new subscriptions start at the current max offset of the topic
+	brk := brokers.ForProject(getPool(r), str, projectUUID)
+	offset := brk.GetMaxOffset(projectUUID + "." + topicName)
+
+	// extended replayability mirrors the source topic into a dedicated
+	// longer-retention internal topic; mirroring starts at the point of
+	// creation and the subscription consumes the mirror from its start
+	replayOffset := int64(0)
+	if postBody.ReplayDays > 0 {
+		retentionMs := int64(postBody.ReplayDays) * 24 * int64(time.Hour/time.Millisecond)
+		replayTopic := subscriptions.ReplayTopic(projectUUID, subName)
+		if err := brk.CreateTopic(replayTopic, 1, getCfg(r).DefaultReplicationFactor, retentionMs); err != nil {
+			respondErr(w, apiErrorInternal("Broker error"))
+			return
+		}
+		replayOffset = offset
+		offset = brk.GetMaxOffset(replayTopic)
+	}
+
+	retPolicy := postBody.PushCfg.RetPol.PolicyType
+	retPeriod := postBody.PushCfg.RetPol.Period
+	if postBody.PushCfg.Pend != "" && retPolicy == "" {
+		retPolicy = "linear"
+		retPeriod = 3000
+	}
+
+	result, err := subscriptions.CreateSub(projectUUID, subName, topicName, postBody.PushCfg.Pend, offset,
+		postBody.Ack, retPolicy, retPeriod, postBody.ReplayDays, replayOffset, time.Now(), str)
+	if err != nil {
+		if err.Error() == "exists" {
+			respondErr(w, apiErrorConflict("Subscription"))
+			return
+		}
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	if len(postBody.Labels) > 0 {
+		if err := subscriptions.ModLabels(projectUUID, subName, postBody.Labels, str); err != nil {
+			respondErr(w, apiErrorInternal("Backend error"))
+			return
+		}
+		result.Labels = postBody.Labels
+	}
+
+	// push delivery only starts once the endpoint ownership has been
+	// verified through :verifyPushEndpoint
+
+	events.Emit(events.New("subscription.created", urlVars["project"], result.FullName))
+
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// SubDelete (DELETE) removes a subscription
+func SubDelete(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+
+	if results.One().PushCfg.Pend != "" && getCfg(r).PushEnabled {
+		deactivatePush(r, projectUUID, subName)
+	}
+
+	if err := subscriptions.RemoveSub(projectUUID, subName, getStr(r)); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	// the mirrored replay topic goes away with its subscription
+	if results.One().ReplayDays > 0 {
+		brk := brokers.ForProject(getPool(r), getStr(r), projectUUID)
+		brk.DeleteTopic(subscriptions.ReplayTopic(projectUUID, subName))
+	}
+
+	events.Emit(events.New("subscription.deleted", urlVars["project"], subName))
+	respondOK(w, []byte(""))
+}
+
+// SubModPush (POST) modifies the push configuration of a subscription
+func SubModPush(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody, err := subscriptions.GetFromJSON(body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	retPolicy := postBody.PushCfg.RetPol.PolicyType
+	retPeriod := postBody.PushCfg.RetPol.Period
+	if postBody.PushCfg.Pend != "" && retPolicy == "" {
+		retPolicy = "linear"
+		retPeriod = 3000
+	}
+
+	digestWindow := postBody.PushCfg.DigestWindow
+	if postBody.PushCfg.Digest {
+		if digestWindow == 0 {
+			digestWindow = 60
+		}
+		if digestWindow < 10 || digestWindow > 86400 {
+			respondErr(w, apiErrorInvalidArgument("Digest window must be between 10 and 86400 seconds"))
+			return
+		}
+		if postBody.PushCfg.CloudEvents {
+			respondErr(w, apiErrorInvalidArgument("Digest delivery cannot be combined with cloudEvents"))
+			return
+		}
+		if postBody.PushCfg.MaxBatchMessages > 0 {
+			respondErr(w, apiErrorInvalidArgument("Digest delivery cannot be combined with batching"))
+			return
+		}
+	}
+
+	if postBody.PushCfg.MaxBatchMessages < 0 || postBody.PushCfg.MaxBatchMessages > 1000 {
+		respondErr(w, apiErrorInvalidArgument("Max batch messages must be between 1 and 1000"))
+		return
+	}
+	if postBody.PushCfg.MaxBatchBytes < 0 {
+		respondErr(w, apiErrorInvalidArgument("Max batch bytes must be a positive number"))
+		return
+	}
+	if postBody.PushCfg.MaxBatchMessages > 1 && postBody.PushCfg.CloudEvents {
+		respondErr(w, apiErrorInvalidArgument("Batching cannot be combined with cloudEvents"))
+		return
+	}
+
+	destType := postBody.PushCfg.Type
+	if destType != "" && destType != "http" && destType != "chat" && destType != "email" && destType != "sqs" && destType != "sns" && destType != "kafka" {
+		respondErr(w, apiErrorInvalidArgument("Push type must be one of http, chat, email, kafka, sqs, sns"))
+		return
+	}
+	if destType == "sqs" || destType == "sns" {
+		// the push endpoint carries the queue or topic arn; the named
+		// credentials must be configured on the service
+		arn, err := push.ParseARN(postBody.PushCfg.Pend)
+		if err != nil || arn.Service != destType {
+			respondErr(w, apiErrorInvalidArgument("Push endpoint must be a valid "+destType+" arn"))
+			return
+		}
+		if _, ok := getCfg(r).PushAWSCredentials[postBody.PushCfg.AWSCredentials]; postBody.PushCfg.AWSCredentials == "" || !ok {
+			respondErr(w, apiErrorInvalidArgument("AWS credentials are not available"))
+			return
+		}
+	}
+	if destType == "kafka" {
+		// the push endpoint carries the brokers and topic of the external
+		// cluster; messages are mirrored as stored, so the envelope
+		// transformations do not apply
+		if _, _, err := push.ParseKafkaDest(postBody.PushCfg.Pend); err != nil {
+			respondErr(w, apiErrorInvalidArgument("Push endpoint must be a valid kafka destination (kafka://host:port[,host:port]/topic)"))
+			return
+		}
+		if (postBody.PushCfg.SASLUser == "") != (postBody.PushCfg.SASLPassword == "") {
+			respondErr(w, apiErrorInvalidArgument("SASL user and password must be set together"))
+			return
+		}
+		if postBody.PushCfg.CloudEvents || postBody.PushCfg.Digest || postBody.PushCfg.MaxBatchMessages > 1 {
+			respondErr(w, apiErrorInvalidArgument("Kafka mirroring cannot be combined with cloudEvents, digest or batching"))
+			return
+		}
+	}
+	if destType == "chat" {
+		// the push endpoint carries the slack or mattermost incoming
+		// webhook url; deliveries post the rendered text payload to it
+		if !strings.HasPrefix(postBody.PushCfg.Pend, "https://") {
+			respondErr(w, apiErrorInvalidArgument("Push endpoint must be an https webhook url"))
+			return
+		}
+		if postBody.PushCfg.CloudEvents || postBody.PushCfg.Digest || postBody.PushCfg.MaxBatchMessages > 1 {
+			respondErr(w, apiErrorInvalidArgument("Chat destinations cannot be combined with cloudEvents, digest or batching"))
+			return
+		}
+	}
+	if destType == "email" {
+		// the push endpoint carries the comma separated recipient list;
+		// the smtp server must be configured on the service
+		if getCfg(r).PushSMTPHost == "" {
+			respondErr(w, apiErrorInvalidArgument("Email destinations are not available"))
+			return
+		}
+		for _, recipient := range strings.Split(postBody.PushCfg.Pend, ",") {
+			if !validEmail.MatchString(recipient) {
+				respondErr(w, apiErrorInvalidArgument("Push endpoint must be a comma separated list of email addresses"))
+				return
+			}
+		}
+		if postBody.PushCfg.CloudEvents || postBody.PushCfg.MaxBatchMessages > 1 {
+			respondErr(w, apiErrorInvalidArgument("Email destinations cannot be combined with cloudEvents or batching"))
+			return
+		}
+	}
+
+	// a referenced client certificate must be one of the service-managed
+	// pairs deployed under the configured certificate directory
+	if postBody.PushCfg.TLSCert != "" {
+		if !validName.MatchString(postBody.PushCfg.TLSCert) {
+			respondErr(w, apiErrorInvalidArgument("Invalid TLS certificate name"))
+			return
+		}
+		if !tlsCertAvailable(getCfg(r).PushTLSCertDir, postBody.PushCfg.TLSCert) {
+			respondErr(w, apiErrorInvalidArgument("TLS certificate is not available"))
+			return
+		}
+	}
+
+	err = subscriptions.ModSubPush(projectUUID, subName, postBody.PushCfg.Pend, retPolicy, retPeriod,
+		postBody.PushCfg.CloudEvents, postBody.PushCfg.AuthzHeader, postBody.PushCfg.Digest, digestWindow,
+		postBody.PushCfg.MaxBatchMessages, postBody.PushCfg.MaxBatchBytes, postBody.PushCfg.TLSCert,
+		destType, postBody.PushCfg.AWSCredentials, postBody.PushCfg.KafkaTLS, postBody.PushCfg.SASLUser,
+		postBody.PushCfg.SASLPassword, postBody.PushCfg.EmailSubject, postBody.PushCfg.ChatTemplate, getStr(r))
+	if err != nil {
+		if err.Error() == "not found" {
+			respondErr(w, apiErrorNotFound("Subscription"))
+			return
+		}
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	if getCfg(r).PushEnabled {
+		// a changed endpoint resets the ownership verification, so only
+		// still-verified configurations keep delivering
+		results, _ := subscriptions.Find(projectUUID, "", subName, "", 0, getStr(r))
+		if postBody.PushCfg.Pend != "" && !results.Empty() &&
+			results.One().PushCfg.Verified && !results.One().PushCfg.Paused {
+			refreshPush(r, projectUUID, subName)
+		} else {
+			deactivatePush(r, projectUUID, subName)
+		}
+	}
+
+	events.Emit(events.New("subscription.config_modified", urlVars["project"], subName))
+	respondOK(w, []byte(""))
+}
+
+// pushErrorsResponse holds the recent push delivery attempts of a
+// subscription, most recent first
+type pushErrorsResponse struct {
+	Subscription string                `json:"subscription"`
+	PushErrors   []stores.QPushAttempt `json:"pushErrors"`
+}
+
+// SubPushErrors (GET) returns the recent push delivery attempts of a
+// subscription so endpoint owners can debug failed deliveries
+func SubPushErrors(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", subName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+
+	attempts, err := str.QueryPushAttempts(projectUUID, subName)
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	if attempts == nil {
+		attempts = []stores.QPushAttempt{}
+	}
+	output, _ := json.MarshalIndent(pushErrorsResponse{Subscription: results.One().FullName, PushErrors: attempts}, "", "   ")
+	respondOK(w, output)
+}
+
+// SubPausePush (POST) pauses the push deliveries of a subscription while
+// keeping the stored push configuration intact
+func SubPausePush(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	sub := results.One()
+	if sub.PushCfg.Pend == "" {
+		respondErr(w, apiError(http.StatusConflict, "Subscription is not in push mode", "CONFLICT"))
+		return
+	}
+	if sub.PushCfg.Paused {
+		respondErr(w, apiError(http.StatusConflict, "Push is already paused", "CONFLICT"))
+		return
+	}
+
+	if err := getStr(r).ModSubPushPaused(projectUUID, subName, true); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	if getCfg(r).PushEnabled {
+		deactivatePush(r, projectUUID, subName)
+	}
+
+	events.Emit(events.New("push.paused", urlVars["project"], sub.FullName))
+	respondOK(w, []byte(""))
+}
+
+// SubResumePush (POST) resumes the paused push deliveries of a
+// subscription from its current offset
+func SubResumePush(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	sub := results.One()
+	if !sub.PushCfg.Paused {
+		respondErr(w, apiError(http.StatusConflict, "Push is not paused", "CONFLICT"))
+		return
+	}
+
+	if err := getStr(r).ModSubPushPaused(projectUUID, subName, false); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	// deliveries only restart for verified endpoints; unverified ones
+	// still need to go through :verifyPushEndpoint first
+	if getCfg(r).PushEnabled && sub.PushCfg.Verified {
+		activatePush(r, projectUUID, subName)
+	}
+
+	events.Emit(events.New("push.resumed", urlVars["project"], sub.FullName))
+	respondOK(w, []byte(""))
+}
+
+// tlsCertAvailable checks that both files of a named client certificate
+// pair are deployed under the service certificate directory
+func tlsCertAvailable(certDir string, name string) bool {
+	if certDir == "" {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(certDir, name+".crt")); err != nil {
+		return false
+	}
+	if _, err := os.Stat(filepath.Join(certDir, name+".key")); err != nil {
+		return false
+	}
+	return true
+}
+
+// SubVerifyPushEndpoint (POST) verifies the ownership of the push
+// endpoint of a subscription by matching the challenge it exposes at the
+// well-known verification path, so the service cannot be used to spam
+// arbitrary urls; deliveries start once the endpoint is verified
+func SubVerifyPushEndpoint(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	sub := results.One()
+	if sub.PushCfg.Pend == "" {
+		respondErr(w, apiError(http.StatusConflict, "Subscription is not in push mode", "CONFLICT"))
+		return
+	}
+	if sub.PushCfg.Verified {
+		respondErr(w, apiError(http.StatusConflict, "Push endpoint is already verified", "CONFLICT"))
+		return
+	}
+
+	hash, err := getPush(r).FetchVerificationHash(sub.PushCfg.Pend, sub.PushCfg.TLSCert)
+	if err != nil || hash != sub.PushCfg.VerificationHash {
+		respondErr(w, apiError(http.StatusUnauthorized, "Endpoint verification failed", "UNAUTHORIZED"))
+		return
+	}
+
+	if err := str.ModSubVerification(projectUUID, subName, sub.PushCfg.VerificationHash, true); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	if getCfg(r).PushEnabled && !sub.PushCfg.Paused {
+		activatePush(r, projectUUID, subName)
+	}
+
+	events.Emit(events.New("push.endpoint_verified", urlVars["project"], sub.FullName))
+	respondOK(w, []byte(""))
+}
+
+// SubModAck (POST) modifies the ack deadline of a subscription
+func SubModAck(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody, err := subscriptions.GetAckDeadlineFromJSON(body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if postBody.AckDeadline < 0 || postBody.AckDeadline > 600 {
+		respondErr(w, apiErrorInvalidArgument("Invalid ackDeadlineSeconds(needs value between 0 and 600)"))
+		return
+	}
+
+	if !subscriptions.HasSub(projectUUID, subName, str) {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+
+	if err := str.ModAck(projectUUID, subName, postBody.AckDeadline); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	respondOK(w, []byte(""))
+}
+
+// SubModAckDedup (POST) modifies the ack dedup window of a subscription;
+// a zero window disables the duplicate filtering of pull responses
+func SubModAckDedup(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody, err := subscriptions.GetAckDedupFromJSON(body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if postBody.AckDedupWindow < 0 || postBody.AckDedupWindow > 3600 {
+		respondErr(w, apiErrorInvalidArgument("Invalid ackDedupWindowSeconds(needs value between 0 and 3600)"))
+		return
+	}
+
+	if !subscriptions.HasSub(projectUUID, subName, str) {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+
+	if err := str.ModSubAckDedup(projectUUID, subName, postBody.AckDedupWindow); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	respondOK(w, []byte(""))
+}
+
+// SubPull (POST) consumes messages from a subscription
+func SubPull(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", subName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	sub := results.One()
+
+	// track the pull so it can be cancelled and shows up in :pullSessions
+	ctx, session := activePulls.register(r.Context(), sub.FullName)
+	defer activePulls.release(session)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	opts := pullOptions{MaxMessages: "1"}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &opts); err != nil {
+			respondErr(w, apiErrorInvalidRequestBody())
+			return
+		}
+	}
+	maxMessages, err := strconv.ParseInt(opts.MaxMessages, 10, 64)
+	if err != nil || maxMessages <= 0 {
+		respondErr(w, apiErrorInvalidArgument("Invalid maxMessages"))
+		return
+	}
+	maxBytes := int64(0)
+	if opts.MaxBytes != "" {
+		maxBytes, err = strconv.ParseInt(opts.MaxBytes, 10, 64)
+		if err != nil || maxBytes <= 0 {
+			respondErr(w, apiErrorInvalidArgument("Invalid maxBytes"))
+			return
+		}
+	}
+	if opts.ReturnImmediately != "" && opts.ReturnImmediately != "true" && opts.ReturnImmediately != "false" {
+		respondErr(w, apiErrorInvalidArgument("Invalid returnImmediately"))
+		return
+	}
+
+	brk := brokers.ForProject(getPool(r), str, projectUUID)
+	fullTopic := sub.ConsumeTopic()
+
+	// a cancelled or disconnected pull returns empty without touching the
+	// subscription state
+	if ctx.Err() != nil {
+		recList := messages.RecList{RecMsgs: []messages.RecMsg{}}
+		output, _ := recList.ExportJSON()
+		respondOK(w, []byte(output))
+		return
+	}
+
+	// with returnImmediately=false an empty poll is retried until messages
+	// arrive, the client goes away or the configured max wait expires
+	longPoll := opts.ReturnImmediately == "false"
+	deadline := time.Now().Add(time.Duration(getCfg(r).PullMaxWaitSec) * time.Second)
+
+	var msgs []string
+	for {
+		msgs, err = brk.Consume(fullTopic, sub.Offset, true, maxMessages)
+		if err != nil {
+			if err.Error() == "offset is off" {
+				// the subscription offset fell behind the topic retention,
+				// fast forward to the earliest available message
+				str.UpdateSubOffset(projectUUID, subName, brk.GetMinOffset(fullTopic))
+				respondErr(w, apiErrorInternal("Backend error"))
+				return
+			}
+			respondErr(w, apiErrorInternal("Backend error"))
+			return
+		}
+		if len(msgs) > 0 || !longPoll || !time.Now().Before(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(streamPollInterval):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	// when maxBytes is set, stop before the message that would exceed it;
+	// the first message is always returned so consumers keep making progress
+	if maxBytes > 0 {
+		size := int64(0)
+		for i, payload := range msgs {
+			size += int64(len(payload))
+			if i > 0 && size > maxBytes {
+				msgs = msgs[:i]
+				break
+			}
+		}
+	}
+
+	// with ?decode=true valid utf-8 payloads are also returned decoded
+	decode := r.URL.Query().Get("decode") == "true"
+
+	// a dedup enabled subscription drops messages acked within the window
+	// from the response; redelivered duplicates still advance the offset
+
+	recList := messages.RecList{RecMsgs: []messages.RecMsg{}}
+	for i, payload := range msgs {
+		msg, err := messages.LoadMsgJSON([]byte(payload))
+		if err != nil {
+			respondErr(w, apiErrorInternal("Backend error"))
+			return
+		}
+		if decode {
+			msg.AttachDecoded()
+		}
+		ackID := names.AckID(sub.FullName, sub.Offset+int64(i))
+		if sub.AckDedupWindowSec > 0 {
+			if _, acked := ackDedup.lookup(sub.FullName, strconv.FormatInt(sub.Offset+int64(i), 10)); acked {
+				continue
+			}
+		}
+		recList.RecMsgs = append(recList.RecMsgs, messages.RecMsg{AckID: ackID, Msg: msg})
+	}
+
+	metrics.Prom.IncCounter("ams_messages_pulled_total", "messages returned to pull consumers", nil, float64(len(recList.RecMsgs)))
+
+	if len(msgs) > 0 {
+		totalBytes := int64(0)
+		for _, payload := range msgs {
+			totalBytes += int64(len(payload))
+		}
+		str.UpdateSubPull(projectUUID, subName, sub.Offset+int64(len(msgs)), time.Now().UTC().Format(time.RFC3339Nano))
+		str.IncrementSubMsgNum(projectUUID, subName, int64(len(msgs)))
+		str.IncrementSubBytes(projectUUID, subName, totalBytes)
+	}
+
+	output, _ := recList.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// SubAck (POST) acknowledges the consumption of messages
+func SubAck(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", subName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	sub := results.One()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody, err := subscriptions.GetAckFromJSON(body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if len(postBody.IDs) == 0 {
+		respondErr(w, apiErrorInvalidArgument("Invalid ack id"))
+		return
+	}
+	for _, ackID := range postBody.IDs {
+		if !strings.HasPrefix(ackID, sub.FullName+":") {
+			respondErr(w, apiErrorInvalidArgument("Invalid ack id"))
+			return
+		}
+	}
+
+	maxAckID, err := subscriptions.GetMaxAckID(postBody.IDs)
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid ack id"))
+		return
+	}
+	offset, _ := subscriptions.GetOffsetFromAckID(maxAckID)
+
+	// the ack must arrive within the subscription ack deadline
+	if sub.PendingAck == "" {
+		respondErr(w, apiErrorTimeout("ack timeout"))
+		return
+	}
+	// the persisted deadline, set at pull time and pushed forward by
+	// extend calls, decides how long the lease is held
+	deadline, ok := sub.AckDeadlineTime()
+	if !ok || !time.Now().Before(deadline) {
+		respondErr(w, apiErrorTimeout("ack timeout"))
+		return
+	}
+
+	if err := str.UpdateSubOffsetAck(projectUUID, subName, offset+1, time.Now().UTC().Format(time.RFC3339Nano)); err != nil {
+		respondErr(w, apiErrorTimeout("ack timeout"))
+		return
+	}
+
+	// remember the acked offsets of dedup enabled subscriptions so later
+	// pulls can filter redelivered duplicates within the window
+	if sub.AckDedupWindowSec > 0 {
+		window := time.Duration(sub.AckDedupWindowSec) * time.Second
+		for _, ackID := range postBody.IDs {
+			ackedOffset, _ := subscriptions.GetOffsetFromAckID(ackID)
+			ackDedup.record(sub.FullName, strconv.FormatInt(ackedOffset, 10), "", window)
+		}
+	}
+	respondOK(w, []byte(""))
+}
+
+// maxLeaseSeconds bounds how far past its pull a lease can be pushed
+// through repeated extend calls
+const maxLeaseSeconds = 600
+
+// SubExtendAck (POST) extends the lease on pulled messages so consumers
+// processing long tasks can heartbeat the deadline instead of setting a
+// huge subscription-wide ackDeadlineSeconds
+func SubExtendAck(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", subName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	sub := results.One()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody, err := subscriptions.GetExtendAckFromJSON(body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if postBody.ExtendSeconds < 1 || postBody.ExtendSeconds > maxLeaseSeconds {
+		respondErr(w, apiErrorInvalidArgument("Invalid extendSeconds(needs value between 1 and 600)"))
+		return
+	}
+	if len(postBody.IDs) == 0 {
+		respondErr(w, apiErrorInvalidArgument("Invalid ack id"))
+		return
+	}
+	for _, ackID := range postBody.IDs {
+		if !strings.HasPrefix(ackID, sub.FullName+":") {
+			respondErr(w, apiErrorInvalidArgument("Invalid ack id"))
+			return
+		}
+	}
+
+	// the lease can only be extended while it is still held
+	if sub.PendingAck == "" {
+		respondErr(w, apiErrorTimeout("ack timeout"))
+		return
+	}
+	pulled, err := time.Parse(time.RFC3339Nano, sub.PendingAck)
+	if err != nil {
+		respondErr(w, apiErrorTimeout("ack timeout"))
+		return
+	}
+	deadline, ok := sub.AckDeadlineTime()
+	if !ok || !time.Now().Before(deadline) {
+		respondErr(w, apiErrorTimeout("ack timeout"))
+		return
+	}
+
+	// repeated extensions are capped at maxLeaseSeconds past the pull and
+	// persisted, so the lease survives an instance restart
+	extended := time.Now().Add(time.Duration(postBody.ExtendSeconds) * time.Second)
+	if max := pulled.Add(maxLeaseSeconds * time.Second); extended.After(max) {
+		extended = max
+	}
+	if err := str.UpdateSubAckDeadline(projectUUID, subName, extended.UTC().Format(time.RFC3339Nano)); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := json.MarshalIndent(map[string]string{
+		"extendedUntil": extended.UTC().Format(time.RFC3339Nano)}, "", "   ")
+	respondOK(w, output)
+}
+
+// SubSeek (POST) repositions the offset of a subscription to the first
+// message published at or after a given timestamp, so consumers can
+// replay recent history without recreating the subscription
+func SubSeek(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", subName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	sub := results.One()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody := struct {
+		Time string `json:"time"`
+	}{}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	seekTime, err := time.Parse(time.RFC3339Nano, postBody.Time)
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid time, an rfc3339 timestamp is expected"))
+		return
+	}
+
+	brk := brokers.ForProject(getPool(r), str, projectUUID)
+	fullTopic := sub.ConsumeTopic()
+	offset, err := brk.OffsetForTime(fullTopic, seekTime)
+	if err != nil {
+		respondErr(w, apiErrorInternal("Broker error"))
+		return
+	}
+	// the looked up offset may point before the retained range
+	if minOffset := brk.GetMinOffset(fullTopic); offset < minOffset {
+		offset = minOffset
+	}
+
+	if err := str.UpdateSubOffset(projectUUID, subName, offset); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	event := events.New("subscription.seeked", urlVars["project"], sub.FullName)
+	event.Attrs = map[string]string{"offset": strconv.FormatInt(offset, 10)}
+	events.Emit(event)
+
+	output, _ := json.MarshalIndent(map[string]int64{"offset": offset}, "", "   ")
+	respondOK(w, output)
+}
+
+// subDeliveryMetrics holds the consumption counters of one delivery
+// mode of a subscription
+type subDeliveryMetrics struct {
+	MsgNum         int64  `json:"msg_num"`
+	TotalBytes     int64  `json:"total_bytes"`
+	ErrorNum       int64  `json:"error_num,omitempty"`
+	LatestActivity string `json:"latest_activity,omitempty"`
+}
+
+// subMetricsResponse holds the usage metrics of a subscription; the
+// top-level counters total both delivery modes while pulled and pushed
+// break them down per mode
+type subMetricsResponse struct {
+	MsgNum        int64              `json:"msg_num"`
+	TotalBytes    int64              `json:"total_bytes"`
+	LatestConsume string             `json:"latest_consume,omitempty"`
+	Pulled        subDeliveryMetrics `json:"pulled"`
+	Pushed        subDeliveryMetrics `json:"pushed"`
+	// PushLatency summarizes the broker-to-webhook latency of push
+	// enabled subscriptions
+	PushLatency *metrics.LatencySummary `json:"push_latency,omitempty"`
+}
+
+// SubMetrics (GET) returns the usage metrics of a subscription
+func SubMetrics(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", subName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	sub := results.One()
+
+	response := subMetricsResponse{}
+	qSubs, _, _, _ := str.QuerySubs(projectUUID, "", subName, "", 0, "", false)
+	if len(qSubs) > 0 {
+		response.Pulled = subDeliveryMetrics{MsgNum: qSubs[0].MsgNum, TotalBytes: qSubs[0].TotalBytes,
+			LatestActivity: qSubs[0].LatestConsume}
+		response.Pushed = subDeliveryMetrics{MsgNum: qSubs[0].PushedMsgNum, TotalBytes: qSubs[0].PushedBytes,
+			ErrorNum: qSubs[0].PushErrorNum, LatestActivity: qSubs[0].LatestPush}
+		response.MsgNum = response.Pulled.MsgNum + response.Pushed.MsgNum
+		response.TotalBytes = response.Pulled.TotalBytes + response.Pushed.TotalBytes
+		response.LatestConsume = qSubs[0].LatestConsume
+	}
+	if summary, ok := metrics.PushLatency.Snapshot()[sub.FullName]; ok {
+		response.PushLatency = &summary
+	}
+
+	output, _ := json.MarshalIndent(response, "", "   ")
+	respondOK(w, output)
+}
+
+// diagCheck is the outcome of a single diagnostic check
+type diagCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Details string `json:"details,omitempty"`
+}
+
+// subDiagnosis is the structured result of a subscription diagnosis
+type subDiagnosis struct {
+	Subscription string      `json:"subscription"`
+	Status       string      `json:"status"`
+	Checks       []diagCheck `json:"checks"`
+}
+
+// SubDiagnose (GET) runs a set of health checks against a subscription
+// and returns a structured diagnosis
+func SubDiagnose(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", subName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	sub := results.One()
+
+	diagnosis := subDiagnosis{Subscription: sub.FullName, Status: "healthy", Checks: []diagCheck{}}
+	fail := func(check diagCheck, critical bool) {
+		if critical {
+			diagnosis.Status = "unhealthy"
+		} else if diagnosis.Status == "healthy" {
+			diagnosis.Status = "warning"
+		}
+		diagnosis.Checks = append(diagnosis.Checks, check)
+	}
+	pass := func(name string) {
+		diagnosis.Checks = append(diagnosis.Checks, diagCheck{Name: name, OK: true})
+	}
+
+	// the attached topic must still exist in the project
+	topicExists := topics.HasTopic(projectUUID, sub.Topic, str)
+	if topicExists {
+		pass("topic_exists")
+	} else {
+		fail(diagCheck{Name: "topic_exists", Details: "attached topic no longer exists"}, true)
+	}
+
+	// the subscription must have an acl entry
+	if _, err := str.QueryACL(projectUUID, "subscriptions", subName); err == nil {
+		pass("acl_configured")
+	} else {
+		fail(diagCheck{Name: "acl_configured", Details: "no acl entry for the subscription"}, false)
+	}
+
+	// the offset must point inside the retained range of the topic
+	if topicExists {
+		brk := brokers.ForProject(getPool(r), str, projectUUID)
+		fullTopic := sub.ConsumeTopic()
+		minOffset, maxOffset := brk.GetMinOffset(fullTopic), brk.GetMaxOffset(fullTopic)
+		if sub.Offset >= minOffset && sub.Offset <= maxOffset {
+			pass("offset_in_range")
+		} else {
+			fail(diagCheck{Name: "offset_in_range",
+				Details: "offset " + strconv.FormatInt(sub.Offset, 10) + " is outside the retained range [" +
+					strconv.FormatInt(minOffset, 10) + "," + strconv.FormatInt(maxOffset, 10) + "]"}, true)
+		}
+	}
+
+	// push enabled subscriptions must have a reachable endpoint
+	if sub.PushCfg.Pend != "" {
+		if err := getPush(r).ProbeEndpoint(sub.PushCfg.Pend, sub.PushCfg.AuthzHeader, sub.PushCfg.TLSCert); err == nil {
+			pass("push_endpoint_reachable")
+		} else {
+			fail(diagCheck{Name: "push_endpoint_reachable", Details: err.Error()}, false)
+		}
+	}
+
+	// a delivery or consumption must have succeeded recently
+	qSubs, _, _, _ := str.QuerySubs(projectUUID, "", subName, "", 0, "", false)
+	if len(qSubs) > 0 {
+		latest, err := time.Parse(time.RFC3339Nano, qSubs[0].LatestConsume)
+		if err == nil && time.Since(latest) < time.Hour {
+			pass("recent_delivery")
+		} else {
+			fail(diagCheck{Name: "recent_delivery", Details: "no successful delivery within the last hour"}, false)
+		}
+	}
+
+	output, _ := json.MarshalIndent(diagnosis, "", "   ")
+	respondOK(w, output)
+}
+
+// SubACL (GET) returns the authorized users of a subscription
+func SubACL(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	respondACL(w, r, "subscriptions", urlVars["subscription"])
+}
+
+// SubModLabels (POST) replaces the labels of a subscription
+func SubModLabels(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	subName := urlVars["subscription"]
+	str := getStr(r)
+
+	if !subscriptions.HasSub(projectUUID, subName, str) {
+		respondErr(w, apiErrorNotFound("Subscription"))
+		return
+	}
+	if !perResourceAuthorized(r, "subscriptions", subName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+
+	postBody := struct {
+		Labels map[string]string `json:"labels"`
+	}{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	if err := subscriptions.ModLabels(projectUUID, subName, postBody.Labels, str); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	events.Emit(events.New("subscription.labels_modified", urlVars["project"], subName))
+	respondOK(w, []byte(""))
+}
+
+// SubModACL (POST) replaces the authorized users of a subscription
+func SubModACL(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	modACL(w, r, "subscriptions", urlVars["subscription"])
+}
+
+// push activation helpers delegate to the configured push provider
+func activatePush(r *http.Request, projectUUID string, subName string) {
+	getPush(r).ActivateSubscription(projectUUID, subName, getReqID(r))
+}
+
+func deactivatePush(r *http.Request, projectUUID string, subName string) {
+	getPush(r).DeactivateSubscription(projectUUID, subName, getReqID(r))
+}
+
+func refreshPush(r *http.Request, projectUUID string, subName string) {
+	getPush(r).RefreshSubscription(projectUUID, subName, getReqID(r))
+}
@@ -0,0 +1,1121 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/auth"
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/events"
+	"github.com/kaggis/argo-messaging/messages"
+	"github.com/kaggis/argo-messaging/metrics"
+	"github.com/kaggis/argo-messaging/projects"
+	"github.com/kaggis/argo-messaging/schemas"
+	"github.com/kaggis/argo-messaging/subscriptions"
+	"github.com/kaggis/argo-messaging/topics"
+)
+
+// maxMessageSize is the maximum accepted payload size of a single message
+const maxMessageSize = topics.MaxMessageSize
+
+// TopicListAll (GET) returns a page of the topics of a project
+func TopicListAll(w http.ResponseWriter, r *http.Request) {
+	pageToken := r.URL.Query().Get("pageToken")
+	pageSize, err := parsePageSize(r)
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid page size"))
+		return
+	}
+
+	selector, err := parseLabelSelector(r)
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid label selector"))
+		return
+	}
+	orderBy, descending, err := parseOrderBy(r, "name", "created_on")
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid sort parameters"))
+		return
+	}
+
+	// non privileged users only see the topics they are authorized to use
+	userUUID := ""
+	if getCfg(r).ResAuth && !isPrivileged(r) {
+		userUUID = getUserUUID(r)
+	}
+
+	var results topics.PaginatedTopics
+	if len(selector) > 0 {
+		results, err = topics.FindByLabels(getProjectUUID(r), userUUID, selector, getStr(r))
+	} else {
+		results, err = topics.FindSorted(getProjectUUID(r), userUUID, "", pageToken, pageSize, orderBy, descending, getStr(r))
+	}
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid page token"))
+		return
+	}
+	output, _ := results.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// TopicListOne (GET) returns the details of a single topic
+func TopicListOne(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	results, err := topics.Find(getProjectUUID(r), "", urlVars["topic"], "", 0, getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	if !perResourceAuthorized(r, "topics", urlVars["topic"]) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	topic := results.One()
+	output, _ := topic.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// TopicListSubscriptions (GET) returns a page of the subscriptions
+// attached to a topic
+func TopicListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	topicName := urlVars["topic"]
+	str := getStr(r)
+
+	pageToken := r.URL.Query().Get("pageToken")
+	pageSize, err := parsePageSize(r)
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid page size"))
+		return
+	}
+
+	if !topics.HasTopic(projectUUID, topicName, str) {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	if !perResourceAuthorized(r, "topics", topicName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+
+	results, err := subscriptions.FindByTopic(projectUUID, topicName, pageToken, pageSize, str)
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid page token"))
+		return
+	}
+	output, _ := results.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// TopicCreate (PUT) creates a new topic
+func TopicCreate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	topicName := urlVars["topic"]
+
+	if !validName.MatchString(topicName) {
+		respondErr(w, apiErrorInvalidArgument("Invalid topic name"))
+		return
+	}
+
+	// partitions and replication factor are optional, defaulting to the
+	// configured project-level values
+	cfg := getCfg(r)
+	postBody := struct {
+		Partitions        int32             `json:"partitions"`
+		ReplicationFactor int16             `json:"replication_factor"`
+		Idempotent        bool              `json:"idempotent"`
+		InheritProjectACL bool              `json:"inheritProjectAcl"`
+		Routing           bool              `json:"routing"`
+		AutoScaleDisabled bool              `json:"autoScaleDisabled"`
+		Labels            map[string]string `json:"labels"`
+	}{Partitions: cfg.DefaultPartitions, ReplicationFactor: cfg.DefaultReplicationFactor}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &postBody); err != nil {
+			respondErr(w, apiErrorInvalidRequestBody())
+			return
+		}
+	}
+	if postBody.Partitions < 1 || postBody.ReplicationFactor < 1 {
+		respondErr(w, apiErrorInvalidArgument("Invalid partitions or replication_factor"))
+		return
+	}
+	// consumption reads a single partition per topic, so more partitions
+	// would silently strand messages instead of adding throughput
+	if postBody.Partitions > 1 {
+		respondErr(w, apiErrorInvalidArgument("Only one partition per topic is supported"))
+		return
+	}
+
+	projectUUID := getProjectUUID(r)
+	if prj, err := projects.Find(projectUUID, "", getStr(r)); err == nil && !prj.Empty() {
+		p := prj.One()
+		if p.MaxTopics > 0 && p.TopicsCount >= int64(p.MaxTopics) {
+			respondErr(w, apiErrorQuotaExceeded("topics", p.TopicsCount, p.MaxTopics))
+			return
+		}
+	}
+	result, err := topics.CreateTopic(projectUUID, topicName, postBody.Partitions, postBody.ReplicationFactor, postBody.Idempotent, postBody.InheritProjectACL, postBody.Routing, postBody.AutoScaleDisabled, time.Now(), getStr(r))
+	if err != nil {
+		if err.Error() == "exists" {
+			respondErr(w, apiErrorConflict("Topic"))
+			return
+		}
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	if len(postBody.Labels) > 0 {
+		if err := topics.ModLabels(projectUUID, topicName, postBody.Labels, getStr(r)); err != nil {
+			respondErr(w, apiErrorInternal("Backend error"))
+			return
+		}
+		result.Labels = postBody.Labels
+	}
+
+	// create the underlying kafka topic on the broker serving the project;
+	// routing topics store nothing themselves so they need no kafka topic
+	if !postBody.Routing {
+		brk := brokers.ForProject(getPool(r), getStr(r), projectUUID)
+		if err := brk.CreateTopic(projectUUID+"."+topicName, postBody.Partitions, postBody.ReplicationFactor, 0); err != nil {
+			respondErr(w, apiErrorInternal("Broker error"))
+			return
+		}
+	}
+
+	events.Emit(events.New("topic.created", urlVars["project"], result.FullName))
+
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// TopicDelete (DELETE) removes a topic
+func TopicDelete(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	topicName := urlVars["topic"]
+
+	if err := topics.RemoveTopic(projectUUID, topicName, getStr(r)); err != nil {
+		if err.Error() == "not found" {
+			respondErr(w, apiErrorNotFound("Topic"))
+			return
+		}
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	// remove the topic from the broker serving the project as well
+	brk := brokers.ForProject(getPool(r), getStr(r), projectUUID)
+	if err := brk.DeleteTopic(projectUUID + "." + topicName); err != nil {
+		respondErr(w, apiErrorInternal("Broker error"))
+		return
+	}
+
+	events.Emit(events.New("topic.deleted", urlVars["project"], topicName))
+	respondOK(w, []byte(""))
+}
+
+// maxBatchSize is the maximum number of items accepted by a batch request
+const maxBatchSize = 100
+
+// batchResult is the per-item outcome of a batch request
+type batchResult struct {
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// TopicBatchCreate (POST) creates multiple topics in one request,
+// returning a per-item result instead of failing the whole batch
+func TopicBatchCreate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	cfg := getCfg(r)
+	projectUUID := getProjectUUID(r)
+	str := getStr(r)
+
+	postBody := struct {
+		Topics []struct {
+			Name              string `json:"name"`
+			Partitions        int32  `json:"partitions"`
+			ReplicationFactor int16  `json:"replication_factor"`
+			Idempotent        bool   `json:"idempotent"`
+			InheritProjectACL bool   `json:"inheritProjectAcl"`
+		} `json:"topics"`
+	}{}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if len(postBody.Topics) == 0 {
+		respondErr(w, apiErrorInvalidArgument("Topic list is empty"))
+		return
+	}
+	if len(postBody.Topics) > maxBatchSize {
+		respondErr(w, apiErrorInvalidArgument("Batch too large"))
+		return
+	}
+
+	brk := brokers.ForProject(getPool(r), str, projectUUID)
+	batch := []batchResult{}
+	for _, item := range postBody.Topics {
+		if !validName.MatchString(item.Name) {
+			batch = append(batch, batchResult{Name: item.Name, Error: "Invalid topic name"})
+			continue
+		}
+		partitions := item.Partitions
+		if partitions == 0 {
+			partitions = cfg.DefaultPartitions
+		}
+		replicationFactor := item.ReplicationFactor
+		if replicationFactor == 0 {
+			replicationFactor = cfg.DefaultReplicationFactor
+		}
+		if partitions < 1 || replicationFactor < 1 {
+			batch = append(batch, batchResult{Name: item.Name, Error: "Invalid partitions or replication_factor"})
+			continue
+		}
+		if partitions > 1 {
+			batch = append(batch, batchResult{Name: item.Name, Error: "Only one partition per topic is supported"})
+			continue
+		}
+
+		result, err := topics.CreateTopic(projectUUID, item.Name, partitions, replicationFactor, item.Idempotent, item.InheritProjectACL, false, false, time.Now(), str)
+		if err != nil {
+			if err.Error() == "exists" {
+				batch = append(batch, batchResult{Name: item.Name, Error: "Topic already exists"})
+			} else {
+				batch = append(batch, batchResult{Name: item.Name, Error: "Backend error"})
+			}
+			continue
+		}
+		if err := brk.CreateTopic(projectUUID+"."+item.Name, partitions, replicationFactor, 0); err != nil {
+			batch = append(batch, batchResult{Name: item.Name, Error: "Broker error"})
+			continue
+		}
+		events.Emit(events.New("topic.created", urlVars["project"], result.FullName))
+		batch = append(batch, batchResult{Name: item.Name, Success: true})
+	}
+
+	output, _ := json.MarshalIndent(map[string][]batchResult{"results": batch}, "", "   ")
+	respondOK(w, output)
+}
+
+// TopicBatchDelete (POST) removes multiple topics in one request,
+// returning a per-item result instead of failing the whole batch
+func TopicBatchDelete(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	str := getStr(r)
+
+	postBody := struct {
+		Topics []string `json:"topics"`
+	}{}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if len(postBody.Topics) == 0 {
+		respondErr(w, apiErrorInvalidArgument("Topic list is empty"))
+		return
+	}
+	if len(postBody.Topics) > maxBatchSize {
+		respondErr(w, apiErrorInvalidArgument("Batch too large"))
+		return
+	}
+
+	brk := brokers.ForProject(getPool(r), str, projectUUID)
+	batch := []batchResult{}
+	for _, topicName := range postBody.Topics {
+		if err := topics.RemoveTopic(projectUUID, topicName, str); err != nil {
+			if err.Error() == "not found" {
+				batch = append(batch, batchResult{Name: topicName, Error: "Topic not found"})
+			} else {
+				batch = append(batch, batchResult{Name: topicName, Error: "Backend error"})
+			}
+			continue
+		}
+		if err := brk.DeleteTopic(projectUUID + "." + topicName); err != nil {
+			batch = append(batch, batchResult{Name: topicName, Error: "Broker error"})
+			continue
+		}
+		events.Emit(events.New("topic.deleted", urlVars["project"], topicName))
+		batch = append(batch, batchResult{Name: topicName, Success: true})
+	}
+
+	output, _ := json.MarshalIndent(map[string][]batchResult{"results": batch}, "", "   ")
+	respondOK(w, output)
+}
+
+// TopicKeyListAll (GET) returns the publish keys of a topic, omitting
+// the token values
+func TopicKeyListAll(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	topicName := urlVars["topic"]
+	str := getStr(r)
+
+	if !str.HasTopic(projectUUID, topicName) {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	if !perResourceAuthorized(r, "topics", topicName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+
+	results, err := auth.ListTopicKeys(projectUUID, topicName, str)
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := results.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// TopicKeyCreate (POST) creates a publish-only key for a topic,
+// returning its token once
+func TopicKeyCreate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	topicName := urlVars["topic"]
+	str := getStr(r)
+
+	if !str.HasTopic(projectUUID, topicName) {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	if !perResourceAuthorized(r, "topics", topicName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+
+	result, err := auth.CreateTopicKey(projectUUID, topicName, urlVars["keyName"], uuid.New().String(),
+		time.Now(), getUserUUID(r), str)
+	if err != nil {
+		if err.Error() == "exists" {
+			respondErr(w, apiErrorConflict("Topic key"))
+			return
+		}
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// TopicKeyDelete (DELETE) revokes a publish key of a topic
+func TopicKeyDelete(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	topicName := urlVars["topic"]
+	str := getStr(r)
+
+	if !str.HasTopic(projectUUID, topicName) {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	if !perResourceAuthorized(r, "topics", topicName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+
+	if err := auth.RevokeTopicKey(projectUUID, topicName, urlVars["keyName"], str); err != nil {
+		respondErr(w, apiErrorNotFound("Topic key"))
+		return
+	}
+	respondOK(w, []byte(""))
+}
+
+// TopicPublish (POST) publishes a list of messages to a topic
+func TopicPublish(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	topicName := urlVars["topic"]
+	str := getStr(r)
+
+	results, err := topics.Find(projectUUID, "", topicName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	topic := results.One()
+	if !perResourceAuthorized(r, "topics", topicName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	// besides the native message list the endpoint accepts cloudevents
+	// 1.0 events in structured or binary mode, mapping their context
+	// attributes to ce- prefixed message attributes
+	msgList := messages.MsgList{}
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, messages.ContentTypeCloudEvents):
+		msg, err := messages.FromCloudEvent(body)
+		if err != nil {
+			respondErr(w, apiErrorInvalidArgument(err.Error()))
+			return
+		}
+		msgList.Msgs = []messages.Message{msg}
+	case r.Header.Get("ce-specversion") != "":
+		headers := make(map[string]string)
+		for key := range r.Header {
+			headers[key] = r.Header.Get(key)
+		}
+		msg, err := messages.FromCloudEventBinary(headers, contentType, body)
+		if err != nil {
+			respondErr(w, apiErrorInvalidArgument(err.Error()))
+			return
+		}
+		msgList.Msgs = []messages.Message{msg}
+	default:
+		msgList, err = messages.LoadMsgListJSON(body)
+		if err != nil {
+			respondErr(w, apiErrorInvalidRequestBody())
+			return
+		}
+	}
+	if len(msgList.Msgs) == 0 {
+		respondErr(w, apiErrorInvalidArgument("Message list is empty"))
+		return
+	}
+	if getCfg(r).PublishIdentityAttributes {
+		messages.StampIdentity(msgList.Msgs, getUserUUID(r), getAuthMethod(r), r.RemoteAddr)
+	}
+	now := time.Now()
+	dueTimes := make([]time.Time, len(msgList.Msgs))
+	for i, msg := range msgList.Msgs {
+		if len(msg.Data) > maxMessageSize {
+			respondErr(w, apiErrorInvalidArgument("Message size too large"))
+			return
+		}
+		if _, err := base64.StdEncoding.DecodeString(msg.Data); err != nil {
+			respondErr(w, apiErrorInvalidArgument("Invalid message payload, base64 encoded data expected"))
+			return
+		}
+		due, err := deliveryTime(msg, now)
+		if err != nil {
+			respondErr(w, apiErrorInvalidArgument(err.Error()))
+			return
+		}
+		dueTimes[i] = due
+	}
+
+	// the daily publish quotas of the project are enforced before
+	// anything reaches the broker
+	if err := topics.CheckDailyQuota(projectUUID, msgList.Msgs, str); err != nil {
+		var quotaErr *topics.QuotaError
+		if errors.As(err, &quotaErr) {
+			respondErr(w, apiErrorQuotaExceeded(quotaErr.Resource, quotaErr.Usage, int(quotaErr.Limit)))
+			return
+		}
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	// routing topics fan each message out to the member topics whose
+	// attribute rules match instead of storing messages themselves;
+	// scheduled delivery and dedup do not apply to routed messages
+	if topic.Routing {
+		publishRouted(w, r, projectUUID, topicName, msgList)
+		return
+	}
+
+	brk := brokers.ForProject(getPool(r), str, projectUUID)
+	fullTopic := projectUUID + "." + topicName
+
+	dedupWindow := time.Duration(getCfg(r).PublishDedupWindowSec) * time.Second
+
+	msgIDs := []string{}
+	totalBytes := int64(0)
+	publishedNum := int64(0)
+	for i, msg := range msgList.Msgs {
+		// a retried publish carrying a known dedup id returns the
+		// original message id without producing a duplicate
+		if msg.DedupID != "" {
+			if origID, ok := publishDedup.lookup(fullTopic, msg.DedupID); ok {
+				msgIDs = append(msgIDs, origID)
+				continue
+			}
+		}
+		// a message with a future delivery time is held back by the
+		// scheduler; counters are updated once it actually reaches the
+		// broker and its id is only assigned at that point
+		if due := dueTimes[i]; due.After(time.Now()) {
+			msg := msg
+			delayedPublishes.schedule(due, func() error {
+				published, err := publish(brk, topic, fullTopic, msg)
+				if err != nil {
+					return err
+				}
+				str.IncrementTopicMsgNum(projectUUID, topicName, 1)
+				str.IncrementTopicBytes(projectUUID, topicName, int64(len(published.Data)))
+				str.IncrementDailyProjectUsage(time.Now().UTC().Format("2006-01-02"), projectUUID, 1, int64(len(published.Data)))
+				str.UpdateTopicLatestPublish(projectUUID, topicName, time.Now().UTC().Format(time.RFC3339Nano))
+				metrics.MsgRate.Record(fullTopic, 1)
+				metrics.MsgRate.Record(projectUUID, 1)
+				return nil
+			})
+			if msg.DedupID != "" {
+				publishDedup.record(fullTopic, msg.DedupID, "scheduled", dedupWindow)
+			}
+			msgIDs = append(msgIDs, "scheduled")
+			continue
+		}
+		published, err := publish(brk, topic, fullTopic, msg)
+		if err != nil {
+			respondErr(w, apiErrorInternal("Broker error"))
+			return
+		}
+		if msg.DedupID != "" {
+			publishDedup.record(fullTopic, msg.DedupID, published.ID, dedupWindow)
+		}
+		msgIDs = append(msgIDs, published.ID)
+		totalBytes += int64(len(published.Data))
+		publishedNum++
+		recordPublishLatency(urlVars["project"], published)
+	}
+
+	if publishedNum > 0 {
+		metrics.Prom.IncCounter("ams_messages_published_total", "messages accepted for publishing", nil, float64(publishedNum))
+		metrics.Prom.IncCounter("ams_bytes_published_total", "payload bytes accepted for publishing", nil, float64(totalBytes))
+		str.IncrementTopicMsgNum(projectUUID, topicName, publishedNum)
+		str.IncrementTopicBytes(projectUUID, topicName, totalBytes)
+		str.IncrementDailyProjectUsage(time.Now().UTC().Format("2006-01-02"), projectUUID, publishedNum, totalBytes)
+		str.UpdateTopicLatestPublish(projectUUID, topicName, time.Now().UTC().Format(time.RFC3339Nano))
+		metrics.MsgRate.Record(fullTopic, publishedNum)
+		metrics.MsgRate.Record(projectUUID, publishedNum)
+	}
+
+	output, _ := json.MarshalIndent(map[string][]string{"messageIds": msgIDs}, "", "   ")
+	respondOK(w, output)
+}
+
+// publishRouted fans the messages published to a routing topic out to
+// the member topics whose attribute rules match; messages matching no
+// rule are acknowledged as unrouted and dropped, mirroring an exchange
+// without a matching binding
+func publishRouted(w http.ResponseWriter, r *http.Request, projectUUID string, topicName string, msgList messages.MsgList) {
+	str := getStr(r)
+	brk := brokers.ForProject(getPool(r), str, projectUUID)
+	msgIDs, err := topics.RouteMessages(projectUUID, topicName, msgList.Msgs, brk, str)
+	if err != nil {
+		respondErr(w, apiErrorInternal("Broker error"))
+		return
+	}
+
+	output, _ := json.MarshalIndent(map[string][]string{"messageIds": msgIDs}, "", "   ")
+	respondOK(w, output)
+}
+
+// TopicRoutingRules (GET) returns the attribute rules of a routing topic
+func TopicRoutingRules(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	topicName := urlVars["topic"]
+
+	results, err := topics.Find(projectUUID, "", topicName, "", 0, getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	if !perResourceAuthorized(r, "topics", topicName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	if !results.One().Routing {
+		respondErr(w, apiError(http.StatusConflict, "Topic is not a routing topic", "CONFLICT"))
+		return
+	}
+
+	rules, err := topics.GetRoutingRules(projectUUID, topicName, getStr(r))
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := rules.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// TopicModRoutingRules (POST) replaces the attribute rules of a routing topic
+func TopicModRoutingRules(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	topicName := urlVars["topic"]
+	str := getStr(r)
+
+	results, err := topics.Find(projectUUID, "", topicName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	if !perResourceAuthorized(r, "topics", topicName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	if !results.One().Routing {
+		respondErr(w, apiError(http.StatusConflict, "Topic is not a routing topic", "CONFLICT"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody := topics.RoutingRules{}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	for _, rule := range postBody.Rules {
+		if rule.Attribute == "" {
+			respondErr(w, apiErrorInvalidArgument("Invalid routing rule, an attribute is required"))
+			return
+		}
+		if rule.Topic == topicName {
+			respondErr(w, apiErrorInvalidArgument("A routing rule cannot target its own topic"))
+			return
+		}
+		targets, err := topics.Find(projectUUID, "", rule.Topic, "", 0, str)
+		if err != nil || targets.Empty() {
+			respondErr(w, apiErrorNotFound("Target topic"))
+			return
+		}
+		if targets.One().Routing {
+			respondErr(w, apiErrorInvalidArgument("A routing rule cannot target another routing topic"))
+			return
+		}
+	}
+
+	if err := topics.ModRoutingRules(projectUUID, topicName, postBody, str); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	events.Emit(events.New("topic.routing_rules_modified", urlVars["project"], topicName))
+	respondOK(w, []byte(""))
+}
+
+// dailyMsgCount is a per-day message count exposed by metrics endpoints
+type dailyMsgCount struct {
+	Date     string `json:"date"`
+	MsgCount int64  `json:"msg_count"`
+}
+
+// metricsTimeRange parses the optional start/end query parameters
+// (formatted as 2006-01-02) bounding an exported metrics timeseries
+func metricsTimeRange(r *http.Request) (string, string, error) {
+	start := r.URL.Query().Get("start")
+	end := r.URL.Query().Get("end")
+	for _, date := range []string{start, end} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return "", "", errors.New("Invalid time range, dates must be formatted as YYYY-MM-DD")
+		}
+	}
+	if start != "" && end != "" && start > end {
+		return "", "", errors.New("Invalid time range, start must not be after end")
+	}
+	return start, end, nil
+}
+
+// filterDailyCounts keeps the days within the inclusive start/end range
+func filterDailyCounts(counts []dailyMsgCount, start string, end string) []dailyMsgCount {
+	filtered := []dailyMsgCount{}
+	for _, count := range counts {
+		if start != "" && count.Date < start {
+			continue
+		}
+		if end != "" && count.Date > end {
+			continue
+		}
+		filtered = append(filtered, count)
+	}
+	return filtered
+}
+
+// dailyCountsCSV renders the per-day counts as csv rows
+func dailyCountsCSV(counts []dailyMsgCount) [][]string {
+	rows := [][]string{{"date", "msg_count"}}
+	for _, count := range counts {
+		rows = append(rows, []string{count.Date, strconv.FormatInt(count.MsgCount, 10)})
+	}
+	return rows
+}
+
+// derivedMetrics are the series a metrics response computes server-side
+// from the raw counters, saving dashboards from reimplementing the same
+// arithmetic
+type derivedMetrics struct {
+	// MsgRatePerSec is the publish rate over the last hour
+	MsgRatePerSec float64 `json:"msg_rate_per_sec"`
+	// TrendSevenDayPct is the percentage change of the last seven days
+	// against the seven days before them
+	TrendSevenDayPct float64 `json:"trend_seven_day_pct"`
+	// ProjectedDailyMsgCount extrapolates today's count to a full day
+	ProjectedDailyMsgCount int64 `json:"projected_daily_msg_count"`
+}
+
+// deriveMetrics computes the derived series from the publish rate of the
+// given key and the per-day counts
+func deriveMetrics(rateKey string, counts []dailyMsgCount, now time.Time) derivedMetrics {
+	derived := derivedMetrics{MsgRatePerSec: metrics.MsgRate.PerSecond(rateKey, time.Hour)}
+
+	perDay := make(map[string]int64)
+	for _, count := range counts {
+		perDay[count.Date] += count.MsgCount
+	}
+	now = now.UTC()
+	last, previous := int64(0), int64(0)
+	for i := 0; i < 7; i++ {
+		last += perDay[now.AddDate(0, 0, -i).Format("2006-01-02")]
+		previous += perDay[now.AddDate(0, 0, -i-7).Format("2006-01-02")]
+	}
+	if previous > 0 {
+		derived.TrendSevenDayPct = float64(last-previous) / float64(previous) * 100
+	}
+
+	// early in the day the projection would explode, so the first minute
+	// serves the plain count
+	today := perDay[now.Format("2006-01-02")]
+	elapsed := now.Sub(now.Truncate(24 * time.Hour)).Seconds()
+	derived.ProjectedDailyMsgCount = today
+	if elapsed >= 60 {
+		derived.ProjectedDailyMsgCount = int64(float64(today) * 86400 / elapsed)
+	}
+	return derived
+}
+
+// topicMetricsResponse holds the usage metrics of a topic
+type topicMetricsResponse struct {
+	MsgNum        int64  `json:"msg_num"`
+	TotalBytes    int64  `json:"total_bytes"`
+	LatestPublish string `json:"latest_publish,omitempty"`
+	// NumberOfDailyMessages serves the precomputed per-day counts
+	NumberOfDailyMessages []dailyMsgCount `json:"number_of_daily_messages"`
+	// Derived serves the server-side computed series
+	Derived derivedMetrics `json:"derived"`
+}
+
+// TopicMetrics (GET) returns the usage metrics of a topic
+func TopicMetrics(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	topicName := urlVars["topic"]
+	str := getStr(r)
+
+	results, err := topics.Find(projectUUID, "", topicName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	if !perResourceAuthorized(r, "topics", topicName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+
+	response := topicMetricsResponse{NumberOfDailyMessages: []dailyMsgCount{}}
+	qTopics, _, _, _ := str.QueryTopics(projectUUID, "", topicName, "", 0, "", false)
+	if len(qTopics) > 0 {
+		response.MsgNum = qTopics[0].MsgNum
+		response.TotalBytes = qTopics[0].TotalBytes
+		response.LatestPublish = qTopics[0].LatestPublish
+	}
+	start, end, err := metricsTimeRange(r)
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument(err.Error()))
+		return
+	}
+
+	counts, _ := str.QueryDailyMsgCount(projectUUID, topicName)
+	for _, count := range counts {
+		response.NumberOfDailyMessages = append(response.NumberOfDailyMessages,
+			dailyMsgCount{Date: count.Date, MsgCount: count.MsgCount})
+	}
+	response.NumberOfDailyMessages = filterDailyCounts(response.NumberOfDailyMessages, start, end)
+	if r.URL.Query().Get("format") == "csv" {
+		respondCSV(w, topicName+"-metrics.csv", dailyCountsCSV(response.NumberOfDailyMessages))
+		return
+	}
+	response.Derived = deriveMetrics(projectUUID+"."+topicName, response.NumberOfDailyMessages, time.Now())
+
+	output, _ := json.MarshalIndent(response, "", "   ")
+	respondOK(w, output)
+}
+
+// topicDiagnosis is the structured result of a topic diagnosis
+type topicDiagnosis struct {
+	Topic  string      `json:"topic"`
+	Status string      `json:"status"`
+	Checks []diagCheck `json:"checks"`
+}
+
+// TopicDiagnose (GET) runs a set of health checks against a topic and
+// returns a structured diagnosis
+func TopicDiagnose(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	topicName := urlVars["topic"]
+	str := getStr(r)
+
+	results, err := topics.Find(projectUUID, "", topicName, "", 0, str)
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	if !perResourceAuthorized(r, "topics", topicName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+	topic := results.One()
+
+	diagnosis := topicDiagnosis{Topic: topic.FullName, Status: "healthy", Checks: []diagCheck{}}
+	fail := func(check diagCheck, critical bool) {
+		if critical {
+			diagnosis.Status = "unhealthy"
+		} else if diagnosis.Status == "healthy" {
+			diagnosis.Status = "warning"
+		}
+		diagnosis.Checks = append(diagnosis.Checks, check)
+	}
+	pass := func(name string) {
+		diagnosis.Checks = append(diagnosis.Checks, diagCheck{Name: name, OK: true})
+	}
+
+	// the topic must exist on the broker serving the project
+	brk := brokers.ForProject(getPool(r), str, projectUUID)
+	brkPartitions, err := brk.TopicPartitions(projectUUID + "." + topicName)
+	if err == nil {
+		pass("broker_topic_exists")
+	} else {
+		fail(diagCheck{Name: "broker_topic_exists", Details: "topic is missing on the broker"}, true)
+	}
+
+	// the broker partition count must match the stored topic settings
+	if err == nil && topic.Partitions > 0 {
+		if brkPartitions == topic.Partitions {
+			pass("partitions_match")
+		} else {
+			fail(diagCheck{Name: "partitions_match",
+				Details: "broker reports " + strconv.FormatInt(int64(brkPartitions), 10) +
+					" partition(s), expected " + strconv.FormatInt(int64(topic.Partitions), 10)}, false)
+		}
+	}
+
+	// a publish must have succeeded recently
+	qTopics, _, _, _ := str.QueryTopics(projectUUID, "", topicName, "", 0, "", false)
+	if len(qTopics) > 0 {
+		latest, err := time.Parse(time.RFC3339Nano, qTopics[0].LatestPublish)
+		if err == nil && time.Since(latest) < time.Hour {
+			pass("recent_publish")
+		} else {
+			fail(diagCheck{Name: "recent_publish", Details: "no successful publish within the last hour"}, false)
+		}
+	}
+
+	output, _ := json.MarshalIndent(diagnosis, "", "   ")
+	respondOK(w, output)
+}
+
+// TopicACL (GET) returns the authorized users of a topic
+func TopicACL(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	respondACL(w, r, "topics", urlVars["topic"])
+}
+
+// TopicModACL (POST) replaces the authorized users of a topic
+func TopicModACL(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	modACL(w, r, "topics", urlVars["topic"])
+}
+
+// TopicModLabels (POST) replaces the labels of a topic
+func TopicModLabels(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	topicName := urlVars["topic"]
+	str := getStr(r)
+
+	if !topics.HasTopic(projectUUID, topicName, str) {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	if !perResourceAuthorized(r, "topics", topicName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+
+	postBody := struct {
+		Labels map[string]string `json:"labels"`
+	}{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	if err := topics.ModLabels(projectUUID, topicName, postBody.Labels, str); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	events.Emit(events.New("topic.labels_modified", urlVars["project"], topicName))
+	respondOK(w, []byte(""))
+}
+
+// TopicAttachSchema (POST) attaches a schema of the project to a topic;
+// an empty schema name detaches the current one
+func TopicAttachSchema(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	projectUUID := getProjectUUID(r)
+	topicName := urlVars["topic"]
+	str := getStr(r)
+
+	if !topics.HasTopic(projectUUID, topicName, str) {
+		respondErr(w, apiErrorNotFound("Topic"))
+		return
+	}
+	if !perResourceAuthorized(r, "topics", topicName) {
+		respondErr(w, apiErrorForbidden())
+		return
+	}
+
+	postBody := struct {
+		Schema string `json:"schema"`
+	}{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if err := json.Unmarshal(body, &postBody); err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if postBody.Schema != "" && !schemas.HasSchema(projectUUID, postBody.Schema, str) {
+		respondErr(w, apiErrorNotFound("Schema"))
+		return
+	}
+
+	if err := topics.AttachSchema(projectUUID, topicName, postBody.Schema, str); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	events.Emit(events.New("topic.schema_attached", urlVars["project"], topicName))
+	respondOK(w, []byte(""))
+}
+
+// recordPublishLatency records the publish-path latency of a message when
+// the client stamped it with a sendTime attribute
+func recordPublishLatency(projectName string, msg messages.Message) {
+	sendTime, err := time.Parse(time.RFC3339Nano, msg.Attrs["sendTime"])
+	if err != nil {
+		return
+	}
+	pubTime, err := time.Parse(time.RFC3339Nano, msg.PubTime)
+	if err != nil {
+		return
+	}
+	metrics.PublishLatency.Record(projectName, pubTime.Sub(sendTime))
+}
+
+// publish sends a message through the producer matching the topic settings
+func publish(brk brokers.Broker, topic topics.Topic, fullTopic string, msg messages.Message) (messages.Message, error) {
+	if topic.Idempotent {
+		return brk.PublishIdempotent(fullTopic, msg)
+	}
+	return brk.Publish(fullTopic, msg)
+}
+
+// parsePageSize extracts the optional pageSize url parameter of list requests
+func parsePageSize(r *http.Request) (int64, error) {
+	pageSize := r.URL.Query().Get("pageSize")
+	if pageSize == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(pageSize, 10, 64)
+}
+
+// parseLabelSelector parses the labels query parameter, a comma
+// separated list of key=value pairs the listed resources must all carry
+func parseLabelSelector(r *http.Request) (map[string]string, error) {
+	raw := r.URL.Query().Get("labels")
+	if raw == "" {
+		return nil, nil
+	}
+	selector := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || key == "" {
+			return nil, errors.New("invalid label selector")
+		}
+		selector[key] = value
+	}
+	return selector, nil
+}
+
+// parseOrderBy parses the orderBy/direction sort parameters of a list
+// request against the fields the resource supports
+func parseOrderBy(r *http.Request, fields ...string) (string, bool, error) {
+	orderBy := r.URL.Query().Get("orderBy")
+	if orderBy != "" {
+		valid := false
+		for _, field := range fields {
+			if orderBy == field {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return "", false, errors.New("invalid order by")
+		}
+	}
+	switch r.URL.Query().Get("direction") {
+	case "", "asc":
+		return orderBy, false, nil
+	case "desc":
+		return orderBy, true, nil
+	}
+	return "", false, errors.New("invalid direction")
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/kaggis/argo-messaging/auth"
+	"github.com/kaggis/argo-messaging/projects"
+)
+
+// UserListAll (GET) returns the list of all users
+func UserListAll(w http.ResponseWriter, r *http.Request) {
+	str := getStr(r)
+	query := r.URL.Query()
+	projectName := query.Get("project")
+	serviceRole := query.Get("service_role")
+	email := query.Get("email")
+	namePrefix := query.Get("name_prefix")
+	createdBy := query.Get("created_by")
+	orderBy, descending, err := parseOrderBy(r, "name", "created_on", "modified_on")
+	if err != nil {
+		respondErr(w, apiErrorInvalidArgument("Invalid sort parameters"))
+		return
+	}
+
+	// without filters or sorting the full list is returned as before
+	if projectName == "" && serviceRole == "" && email == "" && namePrefix == "" && createdBy == "" && orderBy == "" {
+		results, err := auth.FindUsers("", "", "", str)
+		if err != nil {
+			respondErr(w, apiErrorInternal("Backend error"))
+			return
+		}
+		output, _ := results.ExportJSON()
+		respondOK(w, []byte(output))
+		return
+	}
+
+	projectUUID := ""
+	if projectName != "" {
+		projectUUID = projects.GetUUIDByName(projectName, str)
+		if projectUUID == "" {
+			respondErr(w, apiErrorNotFound("ProjectUUID"))
+			return
+		}
+	}
+	createdByUUID := ""
+	if createdBy != "" {
+		creator, err := auth.FindUsers("", "", createdBy, str)
+		if err != nil || creator.Empty() {
+			respondErr(w, apiErrorNotFound("User"))
+			return
+		}
+		createdByUUID = creator.One().UUID
+	}
+
+	results, err := auth.FilterUsers(projectUUID, serviceRole, email, namePrefix, createdByUUID, orderBy, descending, str)
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := results.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// UserListOne (GET) returns the details of a single user
+func UserListOne(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	results, err := auth.FindUsers("", "", urlVars["user"], getStr(r))
+	if err != nil || results.Empty() {
+		respondErr(w, apiErrorNotFound("User"))
+		return
+	}
+	user := results.One()
+	output, _ := user.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// UserCreate (POST) creates a new user
+func UserCreate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+	userName := urlVars["user"]
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	postBody, err := auth.GetUserFromJSON(body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	token, err := auth.GenToken()
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+
+	userUUID := uuid.New().String()
+	result, err := auth.CreateUser(userUUID, userName, postBody.Projects, token, postBody.TokenExpiresOn,
+		postBody.Email, postBody.ServiceRoles, time.Now(), getUserUUID(r), getStr(r))
+	if err != nil {
+		if err.Error() == "exists" {
+			respondErr(w, apiErrorConflict("User"))
+			return
+		}
+		respondErr(w, apiErrorInvalidArgument(err.Error()))
+		return
+	}
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// userImportEntry is one user definition of a bulk import request
+type userImportEntry struct {
+	Name           string              `json:"name"`
+	Email          string              `json:"email"`
+	Projects       []auth.ProjectRoles `json:"projects"`
+	ServiceRoles   []string            `json:"service_roles"`
+	TokenExpiresOn string              `json:"token_expires_on"`
+}
+
+// userImportResult carries the per-entry outcome of a bulk import
+type userImportResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	UUID   string `json:"uuid,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+// UserBulkImport (POST) creates a batch of users in one call; the batch
+// is validated as a whole first and nothing is created while any entry
+// fails, so a partially onboarded project cannot result
+func UserBulkImport(w http.ResponseWriter, r *http.Request) {
+	str := getStr(r)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	importBody := struct {
+		Users []userImportEntry `json:"users"`
+	}{}
+	if err := json.Unmarshal(body, &importBody); err != nil || len(importBody.Users) == 0 {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	// validation pass over the whole batch before anything is written
+	results := make([]userImportResult, len(importBody.Users))
+	seen := map[string]bool{}
+	failed := false
+	for i, entry := range importBody.Users {
+		results[i] = userImportResult{Name: entry.Name, Status: "ok"}
+		reject := func(reason string) {
+			results[i].Status = "error"
+			results[i].Error = reason
+			failed = true
+		}
+		switch {
+		case !validName.MatchString(entry.Name):
+			reject("Invalid user name")
+		case seen[entry.Name]:
+			reject("Duplicate entry in the batch")
+		case auth.ExistsWithName(entry.Name, str):
+			reject("User already exists")
+		}
+		seen[entry.Name] = true
+		for _, membership := range entry.Projects {
+			if projects.GetUUIDByName(membership.Project, str) == "" {
+				reject("Project " + membership.Project + " doesn't exist")
+				break
+			}
+		}
+	}
+
+	response := struct {
+		Users []userImportResult `json:"users"`
+	}{Users: results}
+	if failed {
+		output, _ := json.MarshalIndent(response, "", "   ")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(output)
+		return
+	}
+
+	for i, entry := range importBody.Users {
+		token, err := auth.GenToken()
+		if err != nil {
+			respondErr(w, apiErrorInternal("Backend error"))
+			return
+		}
+		userUUID := uuid.New().String()
+		result, err := auth.CreateUser(userUUID, entry.Name, entry.Projects, token, entry.TokenExpiresOn,
+			entry.Email, entry.ServiceRoles, time.Now(), getUserUUID(r), str)
+		if err != nil {
+			results[i].Status = "error"
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].UUID = result.UUID
+		results[i].Token = result.Token
+	}
+	output, _ := json.MarshalIndent(response, "", "   ")
+	respondOK(w, output)
+}
+
+// userSetSuspension implements the :suspend and :activate actions
+func userSetSuspension(w http.ResponseWriter, r *http.Request, suspended bool) {
+	urlVars := mux.Vars(r)
+	existing, err := auth.FindUsers("", "", urlVars["user"], getStr(r))
+	if err != nil || existing.Empty() {
+		respondErr(w, apiErrorNotFound("User"))
+		return
+	}
+	result, err := auth.SetUserSuspension(existing.One().UUID, suspended, time.Now(), getStr(r))
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// UserSuspend (POST) suspends a user: every token stops authenticating
+// but the account, its acl entries and its history stay intact
+func UserSuspend(w http.ResponseWriter, r *http.Request) {
+	userSetSuspension(w, r, true)
+}
+
+// UserActivate (POST) lifts the suspension of a user
+func UserActivate(w http.ResponseWriter, r *http.Request) {
+	userSetSuspension(w, r, false)
+}
+
+// UserUpdate (PUT) updates the details of a user
+func UserUpdate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	existing, err := auth.FindUsers("", "", urlVars["user"], getStr(r))
+	if err != nil || existing.Empty() {
+		respondErr(w, apiErrorNotFound("User"))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	// a json patch body modifies the current user representation instead
+	// of replacing fields wholesale
+	if isJSONPatch(r) {
+		currentDoc, _ := json.Marshal(existing.One())
+		if body, err = applyJSONPatch(currentDoc, body); err != nil {
+			respondErr(w, apiErrorInvalidArgument(err.Error()))
+			return
+		}
+	}
+
+	postBody, err := auth.GetUserFromJSON(body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+
+	result, err := auth.UpdateUser(existing.One().UUID, postBody.Name, postBody.Projects, postBody.Email,
+		postBody.ServiceRoles, postBody.TokenExpiresOn, time.Now(), getStr(r))
+	if err != nil {
+		if err.Error() == "exists" {
+			respondErr(w, apiErrorConflict("User"))
+			return
+		}
+		respondErr(w, apiErrorInvalidArgument(err.Error()))
+		return
+	}
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// UserDelete (DELETE) removes a user
+func UserDelete(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	existing, err := auth.FindUsers("", "", urlVars["user"], getStr(r))
+	if err != nil || existing.Empty() {
+		respondErr(w, apiErrorNotFound("User"))
+		return
+	}
+
+	if err := auth.RemoveUser(existing.One().UUID, getStr(r)); err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	respondOK(w, []byte(""))
+}
+
+// UserRefreshToken (POST) reassigns a new token to a user
+func UserRefreshToken(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	existing, err := auth.FindUsers("", "", urlVars["user"], getStr(r))
+	if err != nil || existing.Empty() {
+		respondErr(w, apiErrorNotFound("User"))
+		return
+	}
+
+	result, err := auth.RefreshToken(existing.One().UUID, getStr(r))
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// UserTokenCreate (POST) adds a named api key to a user; the token
+// value is returned only once
+func UserTokenCreate(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	existing, err := auth.FindUsers("", "", urlVars["user"], getStr(r))
+	if err != nil || existing.Empty() {
+		respondErr(w, apiErrorNotFound("User"))
+		return
+	}
+
+	postBody := struct {
+		ExpiresOn string `json:"expires_on"`
+	}{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		respondErr(w, apiErrorInvalidRequestBody())
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &postBody); err != nil {
+			respondErr(w, apiErrorInvalidRequestBody())
+			return
+		}
+	}
+
+	result, err := auth.CreateUserToken(existing.One().UUID, urlVars["tokenName"], postBody.ExpiresOn, time.Now(), getStr(r))
+	if err != nil {
+		if err.Error() == "exists" {
+			respondErr(w, apiErrorConflict("Token"))
+			return
+		}
+		respondErr(w, apiErrorInvalidArgument(err.Error()))
+		return
+	}
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// UserTokenListAll (GET) lists the named api keys of a user without
+// their values
+func UserTokenListAll(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	existing, err := auth.FindUsers("", "", urlVars["user"], getStr(r))
+	if err != nil || existing.Empty() {
+		respondErr(w, apiErrorNotFound("User"))
+		return
+	}
+
+	results, err := auth.ListUserTokens(existing.One().UUID, getStr(r))
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := results.ExportJSON()
+	respondOK(w, []byte(output))
+}
+
+// UserTokenDelete (DELETE) revokes a named api key of a user
+func UserTokenDelete(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	existing, err := auth.FindUsers("", "", urlVars["user"], getStr(r))
+	if err != nil || existing.Empty() {
+		respondErr(w, apiErrorNotFound("User"))
+		return
+	}
+
+	if err := auth.RevokeUserToken(existing.One().UUID, urlVars["tokenName"], getStr(r)); err != nil {
+		respondErr(w, apiErrorNotFound("Token"))
+		return
+	}
+	respondOK(w, []byte(""))
+}
+
+// UserRotateToken (POST) assigns a new token to a user, keeping the old
+// one valid for the configured grace period
+func UserRotateToken(w http.ResponseWriter, r *http.Request) {
+	urlVars := mux.Vars(r)
+
+	existing, err := auth.FindUsers("", "", urlVars["user"], getStr(r))
+	if err != nil || existing.Empty() {
+		respondErr(w, apiErrorNotFound("User"))
+		return
+	}
+
+	grace := time.Duration(getCfg(r).TokenGracePeriodSec) * time.Second
+	result, err := auth.RotateToken(existing.One().UUID, grace, getStr(r))
+	if err != nil {
+		respondErr(w, apiErrorInternal("Backend error"))
+		return
+	}
+	output, _ := result.ExportJSON()
+	respondOK(w, []byte(output))
+}
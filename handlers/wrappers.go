@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kaggis/argo-messaging/auth"
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/config"
+	"github.com/kaggis/argo-messaging/metrics"
+	"github.com/kaggis/argo-messaging/projects"
+	"github.com/kaggis/argo-messaging/push"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// ctxKey is the type of the context keys used to thread references
+// through the middleware chain
+type ctxKey string
+
+const (
+	ctxCfg        ctxKey = "cfg"
+	ctxPool       ctxKey = "brk"
+	ctxStore      ctxKey = "str"
+	ctxPush       ctxKey = "push"
+	ctxRoles      ctxKey = "auth_roles"
+	ctxUserUUID   ctxKey = "auth_user_uuid"
+	ctxAuthMethod ctxKey = "auth_method"
+	ctxProject    ctxKey = "auth_project_uuid"
+	ctxReqID      ctxKey = "request_id"
+)
+
+// validName restricts the names of user created resources
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// validEmail loosely restricts the recipients of email destinations
+var validEmail = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// WrapConfig attaches the service references to the request context
+func WrapConfig(hfn http.HandlerFunc, cfg *config.APICfg, pool brokers.Provider, str stores.Store, prv push.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, ctxCfg, cfg)
+		ctx = context.WithValue(ctx, ctxPool, pool)
+		ctx = context.WithValue(ctx, ctxStore, str)
+		ctx = context.WithValue(ctx, ctxPush, prv)
+		hfn.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// WrapLog tags the request with a correlation id and logs the request
+// cycle; the id is carried into the push activation calls so the push
+// worker logs can be correlated with the originating api request
+func WrapLog(hfn http.Handler, name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-Id")
+		if requestID == "" {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set("X-Request-Id", requestID)
+		r = r.WithContext(context.WithValue(r.Context(), ctxReqID, requestID))
+		recorder := &auditWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		hfn.ServeHTTP(recorder, r)
+		metrics.Prom.IncCounter("ams_api_requests_total", "api requests by route, method and status code",
+			map[string]string{"route": name, "method": r.Method, "code": strconv.Itoa(recorder.status)}, 1)
+		metrics.Prom.Observe("ams_api_request_duration_seconds", "api request latency by route",
+			map[string]string{"route": name}, time.Since(start).Seconds())
+		log.Infof("ACCESS: %v %v %v %v", r.Method, r.URL.Path, name, requestID)
+	}
+}
+
+// WrapAuth authenticates and authorizes the request against a named route
+func WrapAuth(hfn http.HandlerFunc, routeName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		urlVars := mux.Vars(r)
+		str := getStr(r)
+
+		// resolve the project if the route is project scoped
+		projectUUID := ""
+		if projectName, ok := urlVars["project"]; ok {
+			projectUUID = projects.GetUUIDByName(projectName, str)
+			if projectUUID == "" {
+				respondErr(w, apiErrorNotFound("project"))
+				return
+			}
+		}
+
+		// the static url token takes precedence; without one, an
+		// Authorization bearer header is tried against the configured
+		// openid connect provider
+		roles := []string{}
+		userUUID := ""
+		authMethod := ""
+		token := r.URL.Query().Get("key")
+		if token != "" {
+			roles, userUUID = auth.Authenticate(projectUUID, token, str)
+			authMethod = "key"
+		} else if bearer := bearerToken(r); bearer != "" {
+			roles, userUUID = auth.AuthenticateBearer(projectUUID, bearer, str)
+			authMethod = "oidc"
+		}
+		// a token matching no user may still name a service account of
+		// the project; its scopes replace the role based authorization
+		saAuthorized := false
+		if userUUID == "" && token != "" && projectUUID != "" {
+			if sa, err := auth.AuthenticateServiceAccount(projectUUID, token, str); err == nil {
+				if !auth.ServiceAccountAuthorized(sa, routeName, scopeResource(urlVars)) {
+					respondErr(w, apiErrorForbidden())
+					return
+				}
+				userUUID = sa.UUID
+				authMethod = "service_account"
+				saAuthorized = true
+			}
+		}
+		// a topic publish key only opens :publish on its own topic
+		if userUUID == "" && token != "" && projectUUID != "" && routeName == "topics:publish" {
+			if key, err := auth.AuthenticateTopicKey(projectUUID, urlVars["topic"], token, str); err == nil {
+				userUUID = key.UUID
+				authMethod = "topic_key"
+				saAuthorized = true
+			}
+		}
+		if userUUID == "" {
+			// tokens of suspended accounts and expired tokens each get a
+			// distinct error so clients know what to do about it
+			if token != "" && auth.UserSuspended(token, str) {
+				respondErr(w, apiErrorUserSuspended())
+				return
+			}
+			if bearer := bearerToken(r); bearer != "" && auth.BearerSuspended(bearer, str) {
+				respondErr(w, apiErrorUserSuspended())
+				return
+			}
+			if token != "" && auth.TokenExpired(token, str) {
+				respondErr(w, apiErrorTokenExpired())
+				return
+			}
+			respondErr(w, apiErrorUnauthorized())
+			return
+		}
+
+		if !saAuthorized && !auth.Authorize(routeName, roles, str) {
+			respondErr(w, apiErrorForbidden())
+			return
+		}
+
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, ctxRoles, roles)
+		ctx = context.WithValue(ctx, ctxUserUUID, userUUID)
+		ctx = context.WithValue(ctx, ctxAuthMethod, authMethod)
+		ctx = context.WithValue(ctx, ctxProject, projectUUID)
+
+		// mutating control plane calls are recorded in the audit log;
+		// the high-volume data plane routes stay out of it
+		if r.Method != http.MethodGet && !dataPlaneRoutes[routeName] {
+			recorder := &auditWriter{ResponseWriter: w, status: http.StatusOK}
+			hfn.ServeHTTP(recorder, r.WithContext(ctx))
+			if err := str.InsertAuditLog(stores.QAuditLog{
+				Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+				UserUUID:    userUUID,
+				AuthMethod:  authMethod,
+				Action:      routeName,
+				ProjectUUID: projectUUID,
+				Method:      r.Method,
+				Path:        r.URL.Path,
+				StatusCode:  recorder.status,
+			}); err != nil {
+				log.Errorf("AUDIT: unable to record %v, %v", routeName, err)
+			}
+			return
+		}
+		hfn.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// auditWriter captures the response status of an audited call
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status before writing it through
+func (w *auditWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes streaming writes through to the wrapped writer
+func (w *auditWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection over for websocket upgrades
+func (w *auditWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("response writer does not support hijacking")
+}
+
+// scopeResource names the resource a project route addresses, used to
+// match service account scopes
+func scopeResource(urlVars map[string]string) string {
+	for _, key := range []string{"topic", "subscription", "schema"} {
+		if name, ok := urlVars[key]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// bearerToken extracts the access token of an Authorization Bearer header
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return ""
+}
+
+// context accessors used by the handlers
+
+func getCfg(r *http.Request) *config.APICfg {
+	return r.Context().Value(ctxCfg).(*config.APICfg)
+}
+
+func getPool(r *http.Request) brokers.Provider {
+	return r.Context().Value(ctxPool).(brokers.Provider)
+}
+
+func getStr(r *http.Request) stores.Store {
+	return r.Context().Value(ctxStore).(stores.Store)
+}
+
+func getPush(r *http.Request) push.Provider {
+	return r.Context().Value(ctxPush).(push.Provider)
+}
+
+func getRoles(r *http.Request) []string {
+	if roles, ok := r.Context().Value(ctxRoles).([]string); ok {
+		return roles
+	}
+	return []string{}
+}
+
+func getUserUUID(r *http.Request) string {
+	if uuid, ok := r.Context().Value(ctxUserUUID).(string); ok {
+		return uuid
+	}
+	return ""
+}
+
+func getAuthMethod(r *http.Request) string {
+	if method, ok := r.Context().Value(ctxAuthMethod).(string); ok {
+		return method
+	}
+	return ""
+}
+
+func getProjectUUID(r *http.Request) string {
+	if uuid, ok := r.Context().Value(ctxProject).(string); ok {
+		return uuid
+	}
+	return ""
+}
+
+func getReqID(r *http.Request) string {
+	if id, ok := r.Context().Value(ctxReqID).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// perResourceAuthorized checks access to a specific resource when
+// per resource authorization is enabled
+func perResourceAuthorized(r *http.Request, resourceType string, resourceName string) bool {
+	cfg := getCfg(r)
+	if !cfg.ResAuth {
+		return true
+	}
+	// service accounts and topic keys passed their scope check already,
+	// the scope pins the resource the route may touch
+	if method := getAuthMethod(r); method == "service_account" || method == "topic_key" {
+		return true
+	}
+	roles := getRoles(r)
+	if auth.IsServiceAdmin(roles) || auth.IsProjectAdmin(roles) {
+		return true
+	}
+	return auth.PerResource(getProjectUUID(r), resourceType, resourceName, getUserUUID(r), getStr(r))
+}
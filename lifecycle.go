@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hook holds the start and stop transitions of one subsystem
+type hook struct {
+	name  string
+	start func() error
+	stop  func() error
+}
+
+// lifecycle brings the registered subsystems up in registration order
+// and down again in reverse, bounding every stop by a timeout so a
+// hanging subsystem does not block shutdown. New subsystems plug in by
+// registering their transitions instead of growing main
+type lifecycle struct {
+	hooks       []hook
+	started     []hook
+	stopTimeout time.Duration
+}
+
+// newLifecycle creates a lifecycle manager with the given stop timeout
+func newLifecycle(stopTimeout time.Duration) *lifecycle {
+	return &lifecycle{stopTimeout: stopTimeout}
+}
+
+// register adds a subsystem; either transition may be nil when the
+// subsystem has nothing to do on that side
+func (lc *lifecycle) register(name string, start func() error, stop func() error) {
+	lc.hooks = append(lc.hooks, hook{name: name, start: start, stop: stop})
+}
+
+// start runs the start hooks in registration order; when one fails the
+// subsystems already running are stopped again in reverse
+func (lc *lifecycle) start() error {
+	for _, h := range lc.hooks {
+		if h.start != nil {
+			if err := h.start(); err != nil {
+				lc.stop()
+				return errors.New(h.name + ": " + err.Error())
+			}
+		}
+		lc.started = append(lc.started, h)
+		log.Infof("MAIN: started %v", h.name)
+	}
+	return nil
+}
+
+// stop runs the stop hooks of the started subsystems in reverse order
+func (lc *lifecycle) stop() {
+	for i := len(lc.started) - 1; i >= 0; i-- {
+		h := lc.started[i]
+		if h.stop == nil {
+			continue
+		}
+		done := make(chan error, 1)
+		go func() { done <- h.stop() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Errorf("MAIN: unable to stop %v, %v", h.name, err)
+				continue
+			}
+			log.Infof("MAIN: stopped %v", h.name)
+		case <-time.After(lc.stopTimeout):
+			log.Errorf("MAIN: stopping %v timed out after %v", h.name, lc.stopTimeout)
+		}
+	}
+	lc.started = nil
+}
@@ -0,0 +1,33 @@
+package logging
+
+import (
+	"net"
+	"strings"
+)
+
+// journaldSocket is the native datagram socket of the systemd journal
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldWriter sends log lines to the systemd journal through its
+// native socket, avoiding an external journald client dependency
+type journaldWriter struct {
+	conn net.Conn
+	tag  string
+}
+
+// newJournaldWriter connects to the journal socket
+func newJournaldWriter(tag string) (*journaldWriter, error) {
+	conn, err := net.Dial("unixgram", journaldSocket)
+	if err != nil {
+		return nil, err
+	}
+	return &journaldWriter{conn: conn, tag: tag}, nil
+}
+
+func (w *journaldWriter) Write(p []byte) (int, error) {
+	payload := "SYSLOG_IDENTIFIER=" + w.tag + "\nMESSAGE=" + strings.TrimRight(string(p), "\n") + "\n"
+	if _, err := w.conn.Write([]byte(payload)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,131 @@
+package logging
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// modulePrefixes maps the overridable subsystems to the prefix their log
+// lines carry
+var modulePrefixes = map[string]string{
+	"main":       "MAIN",
+	"config":     "CONFIG",
+	"handlers":   "HANDLERS",
+	"brokers":    "BROKER",
+	"stores":     "STORE",
+	"push":       "PUSH",
+	"grpc":       "GRPC",
+	"mqtt":       "MQTT",
+	"amqp":       "AMQP",
+	"migrations": "MIGRATIONS",
+}
+
+// moduleLevels holds the default log level plus the runtime overrides
+// set per subsystem through the log levels endpoint
+type moduleLevels struct {
+	sync.Mutex
+	defaultLevel log.Level
+	overrides    map[string]log.Level
+}
+
+var levels = &moduleLevels{defaultLevel: log.InfoLevel, overrides: make(map[string]log.Level)}
+
+// SetModuleLevel overrides the log level of a subsystem at runtime; the
+// level "default" drops the override
+func SetModuleLevel(module string, level string) error {
+	if _, ok := modulePrefixes[module]; !ok {
+		return errors.New("unknown log module " + module)
+	}
+	levels.Lock()
+	defer levels.Unlock()
+	if level == "default" {
+		delete(levels.overrides, module)
+	} else {
+		parsed, err := log.ParseLevel(level)
+		if err != nil {
+			return errors.New("invalid log level " + level)
+		}
+		levels.overrides[module] = parsed
+	}
+	// the global level must admit the most verbose subsystem, the filter
+	// formatter drops what the quieter ones do not want
+	log.SetLevel(levels.mostVerbose())
+	return nil
+}
+
+// Levels returns the effective log level of every subsystem
+func Levels() map[string]string {
+	levels.Lock()
+	defer levels.Unlock()
+	result := make(map[string]string)
+	for module := range modulePrefixes {
+		level := levels.defaultLevel
+		if override, ok := levels.overrides[module]; ok {
+			level = override
+		}
+		result[module] = level.String()
+	}
+	return result
+}
+
+// setDefault records the configured level and resets the overrides
+func (ml *moduleLevels) setDefault(level log.Level) {
+	ml.Lock()
+	defer ml.Unlock()
+	ml.defaultLevel = level
+	ml.overrides = make(map[string]log.Level)
+	log.SetLevel(level)
+}
+
+// allows reports whether a subsystem wants entries of the given level
+func (ml *moduleLevels) allows(module string, level log.Level) bool {
+	ml.Lock()
+	defer ml.Unlock()
+	allowed := ml.defaultLevel
+	if override, ok := ml.overrides[module]; ok {
+		allowed = override
+	}
+	return level <= allowed
+}
+
+// mostVerbose returns the most verbose level among default and overrides;
+// the caller holds the lock
+func (ml *moduleLevels) mostVerbose() log.Level {
+	most := ml.defaultLevel
+	for _, level := range ml.overrides {
+		if level > most {
+			most = level
+		}
+	}
+	return most
+}
+
+// moduleOfEntry derives the subsystem of a log line from its prefix
+func moduleOfEntry(message string) string {
+	prefix, _, ok := strings.Cut(message, ":")
+	if !ok {
+		return ""
+	}
+	for module, modulePrefix := range modulePrefixes {
+		if modulePrefix == prefix {
+			return module
+		}
+	}
+	return ""
+}
+
+// filterFormatter drops the entries of subsystems with a quieter
+// override before handing the rest to the wrapped formatter
+type filterFormatter struct {
+	wrapped log.Formatter
+}
+
+func (f *filterFormatter) Format(entry *log.Entry) ([]byte, error) {
+	if !levels.allows(moduleOfEntry(entry.Message), entry.Level) {
+		return nil, nil
+	}
+	return f.wrapped.Format(entry)
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type LoggingTestSuite struct {
+	suite.Suite
+}
+
+func (suite *LoggingTestSuite) TestRotation() {
+	path := filepath.Join(suite.T().TempDir(), "ams.log")
+	w, err := newRotatingWriter(path, 64, 2)
+	suite.NoError(err)
+
+	line := strings.Repeat("x", 30) + "\n"
+	for i := 0; i < 5; i++ {
+		_, err := w.Write([]byte(line))
+		suite.NoError(err)
+	}
+
+	// the writes that crossed the size limit rotated through the backups
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(name)
+		suite.NoError(err)
+		suite.NotEmpty(data)
+	}
+
+	// only maxBackups rotated copies are kept
+	_, err = os.Stat(path + ".3")
+	suite.True(os.IsNotExist(err))
+}
+
+func (suite *LoggingTestSuite) TestSetup() {
+	suite.Error(Setup(Options{Format: "xml"}))
+	suite.Error(Setup(Options{Output: "nowhere"}))
+	suite.Error(Setup(Options{Output: "file"}))
+
+	// a file setup with json format writes structured lines
+	path := filepath.Join(suite.T().TempDir(), "ams.log")
+	suite.NoError(Setup(Options{Output: "file", Format: "json", Level: "info",
+		File: path, MaxSizeMB: 1, MaxBackups: 1}))
+	log.Info("hello")
+	data, err := os.ReadFile(path)
+	suite.NoError(err)
+	suite.Contains(string(data), `"msg":"hello"`)
+
+	// restore the default setup for any following tests
+	suite.NoError(Setup(Options{Level: "info"}))
+}
+
+func (suite *LoggingTestSuite) TestModuleLevels() {
+	suite.NoError(Setup(Options{Level: "info"}))
+	suite.Error(SetModuleLevel("nosuch", "debug"))
+	suite.Error(SetModuleLevel("brokers", "loud"))
+
+	// only the overridden subsystem lets debug entries through
+	suite.NoError(SetModuleLevel("brokers", "debug"))
+	suite.Equal("debug", Levels()["brokers"])
+	suite.Equal("info", Levels()["stores"])
+	suite.Equal(log.DebugLevel, log.GetLevel())
+	suite.True(levels.allows("brokers", log.DebugLevel))
+	suite.False(levels.allows("stores", log.DebugLevel))
+	suite.False(levels.allows("", log.DebugLevel))
+	suite.Equal("brokers", moduleOfEntry("BROKER: message consumed"))
+
+	// the default level drops the override again
+	suite.NoError(SetModuleLevel("brokers", "default"))
+	suite.Equal("info", Levels()["brokers"])
+	suite.Equal(log.InfoLevel, log.GetLevel())
+}
+
+func TestLoggingTestSuite(t *testing.T) {
+	suite.Run(t, new(LoggingTestSuite))
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kaggis/argo-messaging/amqp"
+	"github.com/kaggis/argo-messaging/auth"
+	"github.com/kaggis/argo-messaging/autoscale"
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/config"
+	"github.com/kaggis/argo-messaging/dirsync"
+	"github.com/kaggis/argo-messaging/grpcapi"
+	"github.com/kaggis/argo-messaging/handlers"
+	"github.com/kaggis/argo-messaging/logging"
+	"github.com/kaggis/argo-messaging/metrics"
+	"github.com/kaggis/argo-messaging/migrations"
+	"github.com/kaggis/argo-messaging/mqtt"
+	"github.com/kaggis/argo-messaging/push"
+	"github.com/kaggis/argo-messaging/replay"
+	"github.com/kaggis/argo-messaging/status"
+	"github.com/kaggis/argo-messaging/stores"
+	"github.com/kaggis/argo-messaging/subscriptions"
+	"github.com/kaggis/argo-messaging/topics"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// run wires the subsystems together and blocks until a termination
+// signal or a fatal error; it returns the process exit code so every
+// path leaves through the deferred lifecycle stop hooks instead of
+// exiting on the spot
+func run() int {
+	// load the service configuration
+	cfg := config.NewAPICfg("LOAD")
+
+	if err := logging.Setup(logging.Options{
+		Output:     cfg.LogOutput,
+		Format:     cfg.LogFormat,
+		Level:      cfg.LogLevel,
+		File:       cfg.LogFile,
+		MaxSizeMB:  cfg.LogFileMaxSizeMB,
+		MaxBackups: cfg.LogFileMaxBackups,
+	}); err != nil {
+		log.Fatalf("MAIN: unable to set up logging, %v", err)
+	}
+
+	// the push authorization headers are encrypted with the configured key
+	subscriptions.SetPushAuthzKey(cfg.PushAuthzKey)
+
+	// bearer tokens of the configured openid connect provider are
+	// accepted alongside the static user tokens
+	if cfg.OIDCEnabled {
+		auth.SetOIDC(auth.NewOIDCValidator(cfg.OIDCIssuer, cfg.OIDCJWKSURL, cfg.OIDCUserClaim, cfg.OIDCAudience), cfg.OIDCAutoProvision)
+	}
+
+	// the subsystems register their start/stop transitions below and are
+	// brought up in registration order, down in reverse
+	lc := newLifecycle(30 * time.Second)
+
+	// subsystems running in the background report unrecoverable errors
+	// here instead of calling log.Fatal, which would skip the lifecycle
+	// stop hooks; the main loop below turns the first one into an
+	// orderly exit
+	fatal := make(chan error, 1)
+	fail := func(err error) {
+		select {
+		case fatal <- err:
+		default:
+		}
+	}
+
+	// the store backend, connected and migrated before anything else
+	// touches it
+	var store stores.Store
+	switch cfg.StoreType {
+	case "embedded":
+		store = stores.NewEmbeddedStore(cfg.StoreHost)
+	default:
+		store = stores.NewMongoStore(cfg.StoreHost, cfg.StoreDB)
+	}
+	// optionally cache the hot auth/acl lookups in front of the store
+	if cfg.AuthCacheTTLSec > 0 {
+		store = stores.NewCachedStore(store, time.Duration(cfg.AuthCacheTTLSec)*time.Second)
+		log.Infof("MAIN: auth/acl cache enabled with ttl %vs", cfg.AuthCacheTTLSec)
+	}
+	lc.register("store",
+		func() error {
+			if err := store.Initialize(); err != nil {
+				return err
+			}
+			// bring the store schema up to date before serving traffic
+			if err := migrations.Run(store); err != nil {
+				return err
+			}
+			// recover the in-flight delivery state left behind by a previous run
+			if recovered := subscriptions.RecoverInFlight(time.Now(), store); recovered > 0 {
+				log.Infof("MAIN: recovered %v subscriptions with expired in-flight messages", recovered)
+			}
+			return nil
+		},
+		func() error {
+			store.Close()
+			return nil
+		})
+
+	// one broker client per configured kafka cluster, connected lazily
+	settings := brokers.ProducerSettings{
+		Compression: cfg.ProducerCompression,
+		BatchSize:   cfg.ProducerBatchSize,
+		LingerMs:    cfg.ProducerLingerMs,
+		MaxInFlight: cfg.ProducerMaxInFlight,
+	}
+	pool := brokers.NewKafkaPool(cfg.Clusters, cfg.DefaultCluster, settings)
+	lc.register("broker pool", nil,
+		func() error {
+			pool.CloseAll()
+			return nil
+		})
+
+	// push delivery, either in-process or through an external push server
+	// singleton jobs coordinate across instances through store-backed
+	// locks; the holder identity names this instance in the lock table
+	hostname, _ := os.Hostname()
+	lockHolder := hostname + "/" + strconv.Itoa(os.Getpid())
+
+	sender := push.NewHTTPSender(30, cfg.PushTLSCertDir)
+	mgr := push.NewManager(pool, store, sender)
+	awsCreds := make(map[string]push.AWSCredentials)
+	for name, cred := range cfg.PushAWSCredentials {
+		awsCreds[name] = push.AWSCredentials{AccessKey: cred.AccessKey, SecretKey: cred.SecretKey}
+	}
+	mgr.SetAWSSender(push.NewHTTPAWSSender(30, awsCreds))
+	mgr.SetKafkaSender(push.NewSaramaSender(30))
+	if cfg.PushSMTPHost != "" {
+		mgr.SetEmailSender(push.NewSMTPSender(cfg.PushSMTPHost, cfg.PushSMTPFrom, cfg.PushSMTPUser, cfg.PushSMTPPassword))
+	}
+	var pc push.Client
+	if cfg.PushServerHost != "" {
+		pc = push.NewHTTPClient(cfg.PushServerHost)
+	} else {
+		pc = push.NewMockClient()
+	}
+	prv := push.NewProvider(cfg, mgr, pc)
+	if cfg.PushEnabled && cfg.PushServerHost == "" {
+		// only the instance holding the push leader lock runs the
+		// delivery loops, so multi-instance deployments do not push
+		// the same messages twice
+		pushLock := stores.NewDistLock(store, "push_leader", lockHolder, 90*time.Second)
+		pushStop := make(chan struct{})
+		lc.register("push manager",
+			func() error {
+				leader := false
+				elect := func() {
+					if pushLock.TryAcquire() {
+						if !leader {
+							log.Infof("MAIN: elected push delivery leader")
+							mgr.StartAll()
+							leader = true
+						}
+					} else if leader {
+						log.Infof("MAIN: lost push delivery leadership")
+						mgr.StopAll()
+						leader = false
+					}
+				}
+				elect()
+				go func() {
+					ticker := time.NewTicker(30 * time.Second)
+					defer ticker.Stop()
+					for {
+						select {
+						case <-pushStop:
+							return
+						case <-ticker.C:
+							elect()
+						}
+					}
+				}()
+				return nil
+			},
+			func() error {
+				// drain the in-flight deliveries first, then release
+				// the leader lock so a surviving instance takes over
+				// without waiting for the lease to expire
+				close(pushStop)
+				mgr.StopAll()
+				pushLock.Release()
+				return nil
+			})
+	}
+
+	// report topics whose sustained publish rate outruns their partition
+	// count, so operators can shard the load
+	if cfg.AutoScaleEnabled {
+		am := autoscale.NewManager(store, cfg.AutoScaleMsgsPerSec)
+		autoscaleLock := stores.NewDistLock(store, "topic_autoscaler", lockHolder, 2*time.Minute)
+		autoscaleStop := make(chan struct{})
+		lc.register("topic autoscaler",
+			func() error {
+				go func() {
+					ticker := time.NewTicker(time.Minute)
+					defer ticker.Stop()
+					for {
+						select {
+						case <-autoscaleStop:
+							return
+						case <-ticker.C:
+							if autoscaleLock.TryAcquire() {
+								am.Scan()
+							}
+						}
+					}
+				}()
+				return nil
+			},
+			func() error {
+				close(autoscaleStop)
+				autoscaleLock.Release()
+				return nil
+			})
+	}
+
+	// mirror the source topics of replay enabled subscriptions into their
+	// longer-retention replay topics; the periodic rescan picks up
+	// subscriptions created while running
+	rm := replay.NewManager(pool, store)
+	replayStop := make(chan struct{})
+	lc.register("replay mirror",
+		func() error {
+			rm.StartAll()
+			go func() {
+				ticker := time.NewTicker(30 * time.Second)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-replayStop:
+						return
+					case <-ticker.C:
+						rm.StartAll()
+					}
+				}
+			}()
+			return nil
+		},
+		func() error {
+			close(replayStop)
+			rm.StopAll()
+			return nil
+		})
+
+	// optionally expose topics and subscriptions over mqtt
+	if cfg.MQTTEnabled {
+		lc.register("mqtt bridge",
+			func() error {
+				cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.CertKey)
+				if err != nil {
+					return err
+				}
+				listener, err := tls.Listen("tcp", cfg.BindIP+":"+strconv.Itoa(cfg.MQTTPort),
+					&tls.Config{Certificates: []tls.Certificate{cert}})
+				if err != nil {
+					return err
+				}
+				go func() {
+					fail(fmt.Errorf("unable to serve the mqtt bridge, %v", mqtt.NewBridge(cfg, pool, store).Serve(listener)))
+				}()
+				return nil
+			}, nil)
+	}
+
+	// optionally expose topics and subscriptions over amqp 1.0
+	if cfg.AMQPEnabled {
+		lc.register("amqp bridge",
+			func() error {
+				cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.CertKey)
+				if err != nil {
+					return err
+				}
+				listener, err := tls.Listen("tcp", cfg.BindIP+":"+strconv.Itoa(cfg.AMQPPort),
+					&tls.Config{Certificates: []tls.Certificate{cert}})
+				if err != nil {
+					return err
+				}
+				go func() {
+					fail(fmt.Errorf("unable to serve the amqp bridge, %v", amqp.NewBridge(cfg, pool, store).Serve(listener)))
+				}()
+				return nil
+			}, nil)
+	}
+
+	// periodically fold the topic message counters into the precomputed
+	// per-day counts served by the metrics endpoints
+	dailyLock := stores.NewDistLock(store, "daily_count_scheduler", lockHolder, 2*time.Hour)
+	dailyStop := make(chan struct{})
+	lc.register("daily count scheduler",
+		func() error {
+			go func() {
+				if dailyLock.TryAcquire() {
+					topics.PrecomputeDailyCounts(time.Now(), store)
+				}
+				ticker := time.NewTicker(time.Hour)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-dailyStop:
+						return
+					case <-ticker.C:
+						if dailyLock.TryAcquire() {
+							topics.PrecomputeDailyCounts(time.Now(), store)
+						}
+					}
+				}
+			}()
+			return nil
+		},
+		func() error {
+			close(dailyStop)
+			dailyLock.Release()
+			return nil
+		})
+
+	// periodically remove expired acl grants and project memberships
+	sweepLock := stores.NewDistLock(store, "expiry_sweep", lockHolder, 2*time.Minute)
+	sweepStop := make(chan struct{})
+	lc.register("expiry sweep scheduler",
+		func() error {
+			go func() {
+				ticker := time.NewTicker(time.Minute)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-sweepStop:
+						return
+					case <-ticker.C:
+						if !sweepLock.TryAcquire() {
+							continue
+						}
+						if removed := auth.SweepExpiredGrants(time.Now(), store); removed > 0 {
+							log.Infof("MAIN: removed %v expired access grants", removed)
+						}
+					}
+				}
+			}()
+			return nil
+		},
+		func() error {
+			close(sweepStop)
+			sweepLock.Release()
+			return nil
+		})
+
+	// periodically import users and project memberships from the
+	// configured ldap/voms directory
+	if cfg.DirSyncEnabled {
+		mappings := make(map[string]dirsync.Mapping)
+		for group, mapping := range cfg.DirSyncMappings {
+			mappings[group] = dirsync.Mapping{Project: mapping.Project, Roles: mapping.Roles}
+		}
+		syncer := dirsync.NewSyncer(&dirsync.LDAPSource{
+			Addr:         cfg.DirSyncLDAPHost,
+			TLS:          cfg.DirSyncLDAPTLS,
+			BindDN:       cfg.DirSyncLDAPBindDN,
+			BindPassword: cfg.DirSyncLDAPBindPassword,
+			BaseDN:       cfg.DirSyncLDAPBaseDN,
+			Filter:       cfg.DirSyncLDAPFilter,
+			UserAttr:     cfg.DirSyncLDAPUserAttr,
+			EmailAttr:    cfg.DirSyncLDAPEmailAttr,
+			GroupAttr:    cfg.DirSyncLDAPGroupAttr,
+		}, mappings, store)
+		dirSyncLock := stores.NewDistLock(store, "dir_sync", lockHolder,
+			2*time.Duration(cfg.DirSyncIntervalSec)*time.Second)
+		dirSyncStop := make(chan struct{})
+		lc.register("directory sync scheduler",
+			func() error {
+				go func() {
+					ticker := time.NewTicker(time.Duration(cfg.DirSyncIntervalSec) * time.Second)
+					defer ticker.Stop()
+					for {
+						select {
+						case <-dirSyncStop:
+							return
+						case <-ticker.C:
+							if !dirSyncLock.TryAcquire() {
+								continue
+							}
+							created, updated, err := syncer.Run(time.Now())
+							if err != nil {
+								log.Errorf("MAIN: directory sync failed, %v", err)
+							} else if created > 0 || updated > 0 {
+								log.Infof("MAIN: directory sync created %v and updated %v users", created, updated)
+							}
+						}
+					}
+				}()
+				return nil
+			},
+			func() error {
+				close(dirSyncStop)
+				dirSyncLock.Release()
+				return nil
+			})
+	}
+
+	// periodically persist a health snapshot of the service components so
+	// status history survives for post-incident reviews
+	statusLock := stores.NewDistLock(store, "status_snapshots", lockHolder, 10*time.Minute)
+	statusStop := make(chan struct{})
+	lc.register("status snapshot scheduler",
+		func() error {
+			go func() {
+				ticker := time.NewTicker(5 * time.Minute)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-statusStop:
+						return
+					case <-ticker.C:
+						if !statusLock.TryAcquire() {
+							continue
+						}
+						now := time.Now()
+						store.InsertStatusSnapshot(status.Capture(now, pool, store, prv, cfg.PushServerHost))
+						store.RemoveStatusSnapshots(now.UTC().Add(-7 * 24 * time.Hour).Format(time.RFC3339))
+					}
+				}
+			}()
+			return nil
+		},
+		func() error {
+			close(statusStop)
+			statusLock.Release()
+			return nil
+		})
+
+	// the prometheus endpoint lives on its own admin port so the metrics
+	// stay off the public api
+	if cfg.MetricsPort > 0 {
+		metricsServer := &http.Server{
+			Addr:    cfg.BindIP + ":" + strconv.Itoa(cfg.MetricsPort),
+			Handler: metrics.Prom.Handler(),
+		}
+		lc.register("prometheus metrics",
+			func() error {
+				go func() {
+					log.Infof("MAIN: prometheus metrics listen on %v", metricsServer.Addr)
+					if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+						fail(fmt.Errorf("unable to serve the prometheus metrics, %v", err))
+					}
+				}()
+				return nil
+			},
+			func() error {
+				return metricsServer.Close()
+			})
+	}
+
+	// optionally expose the core api over grpc alongside http
+	if cfg.GRPCEnabled {
+		lc.register("grpc api",
+			func() error {
+				go func() {
+					if err := grpcapi.Serve(cfg, pool, store, prv); err != nil {
+						fail(fmt.Errorf("unable to serve the grpc api, %v", err))
+					}
+				}()
+				return nil
+			}, nil)
+	}
+
+	if err := lc.start(); err != nil {
+		log.Errorf("MAIN: unable to start, %v", err)
+		return 1
+	}
+	defer lc.stop()
+
+	api := handlers.NewRouting(cfg, pool, store, prv)
+
+	tlsCfg, err := cfg.TLSServerConfig()
+	if err != nil {
+		log.Errorf("MAIN: invalid tls configuration, %v", err)
+		return 1
+	}
+
+	// a unix socket carries the same api in parallel, always plain; the
+	// proxy in front owns transport security
+	var socketServer *http.Server
+	if cfg.SocketPath != "" {
+		os.Remove(cfg.SocketPath)
+		socket, err := net.Listen("unix", cfg.SocketPath)
+		if err != nil {
+			log.Errorf("MAIN: unable to bind the unix socket, %v", err)
+			return 1
+		}
+		mode, _ := strconv.ParseUint(cfg.SocketMode, 8, 32)
+		if err := os.Chmod(cfg.SocketPath, os.FileMode(mode)); err != nil {
+			log.Errorf("MAIN: unable to set the unix socket permissions, %v", err)
+			return 1
+		}
+		socketServer = &http.Server{Handler: api.Router}
+		go func() {
+			log.Infof("MAIN: argo-messaging listens on unix socket %v", cfg.SocketPath)
+			if err := socketServer.Serve(socket); err != nil && err != http.ErrServerClosed {
+				fail(fmt.Errorf("unable to serve the unix socket, %v", err))
+			}
+		}()
+	}
+
+	server := &http.Server{
+		Addr:      cfg.BindIP + ":" + strconv.Itoa(cfg.Port),
+		Handler:   api.Router,
+		TLSConfig: tlsCfg,
+	}
+
+	// the api server runs in the background so main can wait on a
+	// termination signal and drive a graceful shutdown; exiting through
+	// log.Fatal here would skip the deferred lc.stop() and with it the
+	// push handover
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Infof("MAIN: argo-messaging listens on %v", server.Addr)
+		if !cfg.TLSEnabled {
+			log.Warn("MAIN: TLS IS DISABLED, the api is served over plain http; only do this behind a tls terminating balancer")
+			serveErr <- server.ListenAndServe()
+			return
+		}
+		serveErr <- server.ListenAndServeTLS(cfg.Cert, cfg.CertKey)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	select {
+	case sig := <-signals:
+		log.Infof("MAIN: received %v, shutting down", sig)
+		// stop accepting new requests and let the in-flight ones finish
+		// before the deferred lc.stop() winds the subsystems down
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorf("MAIN: unable to shut down the api server, %v", err)
+		}
+		if socketServer != nil {
+			if err := socketServer.Shutdown(ctx); err != nil {
+				log.Errorf("MAIN: unable to shut down the unix socket server, %v", err)
+			}
+		}
+		return 0
+	case err := <-serveErr:
+		log.Errorf("MAIN: unable to serve the api, %v", err)
+	case err := <-fatal:
+		log.Errorf("MAIN: %v", err)
+	}
+	return 1
+}
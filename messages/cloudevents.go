@@ -0,0 +1,143 @@
+package messages
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ContentTypeCloudEvents is the content type of a structured mode
+// cloudevents 1.0 publish request
+const ContentTypeCloudEvents = "application/cloudevents+json"
+
+// ceSpecVersion is the only cloudevents spec revision the service accepts
+const ceSpecVersion = "1.0"
+
+// cloudEvent mirrors the structured mode json encoding of a
+// cloudevents 1.0 event
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+}
+
+// ceContextKeys are the context attributes handled explicitly when
+// converting an event; every other top-level member is an extension
+var ceContextKeys = map[string]bool{
+	"specversion": true, "id": true, "source": true, "type": true,
+	"datacontenttype": true, "subject": true, "time": true,
+	"data": true, "data_base64": true,
+}
+
+// FromCloudEvent converts a structured mode cloudevent into a message,
+// mapping the context attributes to ce- prefixed message attributes
+func FromCloudEvent(input []byte) (Message, error) {
+	event := cloudEvent{}
+	if err := json.Unmarshal(input, &event); err != nil {
+		return Message{}, errors.New("invalid cloudevent payload")
+	}
+	if event.SpecVersion != ceSpecVersion {
+		return Message{}, errors.New("unsupported cloudevents specversion, 1.0 is expected")
+	}
+	if event.ID == "" || event.Source == "" || event.Type == "" {
+		return Message{}, errors.New("missing required cloudevents context attribute (id, source, type)")
+	}
+
+	msg := Message{Attrs: map[string]string{
+		"ce-specversion": event.SpecVersion,
+		"ce-id":          event.ID,
+		"ce-source":      event.Source,
+		"ce-type":        event.Type,
+	}}
+	if event.DataContentType != "" {
+		msg.Attrs["ce-datacontenttype"] = event.DataContentType
+	}
+	if event.Subject != "" {
+		msg.Attrs["ce-subject"] = event.Subject
+	}
+	if event.Time != "" {
+		msg.Attrs["ce-time"] = event.Time
+	}
+
+	// extension attributes become ce- prefixed message attributes as well
+	members := map[string]interface{}{}
+	json.Unmarshal(input, &members)
+	for key, value := range members {
+		if ceContextKeys[key] {
+			continue
+		}
+		if str, ok := value.(string); ok {
+			msg.Attrs["ce-"+key] = str
+		}
+	}
+
+	switch {
+	case event.DataBase64 != "":
+		if _, err := base64.StdEncoding.DecodeString(event.DataBase64); err != nil {
+			return Message{}, errors.New("invalid cloudevents data_base64 payload")
+		}
+		msg.Data = event.DataBase64
+	case event.Data != nil:
+		msg.Data = base64.StdEncoding.EncodeToString(event.Data)
+	}
+	return msg, nil
+}
+
+// FromCloudEventBinary converts a binary mode cloudevent into a message;
+// the ce- prefixed transport headers carry the context attributes and
+// the body is the raw event data
+func FromCloudEventBinary(headers map[string]string, contentType string, body []byte) (Message, error) {
+	msg := Message{Attrs: make(map[string]string)}
+	for key, value := range headers {
+		key = strings.ToLower(key)
+		if strings.HasPrefix(key, "ce-") {
+			msg.Attrs[key] = value
+		}
+	}
+	if msg.Attrs["ce-specversion"] != ceSpecVersion {
+		return Message{}, errors.New("unsupported cloudevents specversion, 1.0 is expected")
+	}
+	if msg.Attrs["ce-id"] == "" || msg.Attrs["ce-source"] == "" || msg.Attrs["ce-type"] == "" {
+		return Message{}, errors.New("missing required cloudevents context attribute (id, source, type)")
+	}
+	if contentType != "" {
+		msg.Attrs["ce-datacontenttype"] = contentType
+	}
+	msg.Data = base64.StdEncoding.EncodeToString(body)
+	return msg, nil
+}
+
+// ToCloudEvent exports the message as a structured mode cloudevent, used
+// for push deliveries to endpoints expecting cloudevents envelopes.
+// Messages published as cloudevents keep their original context
+// attributes; plain messages get a generated context with the
+// subscription as the source
+func (msg *Message) ToCloudEvent(source string) (string, error) {
+	event := map[string]interface{}{
+		"specversion": ceSpecVersion,
+		"id":          msg.ID,
+		"source":      source,
+		"type":        "ams.message",
+	}
+	if msg.PubTime != "" {
+		event["time"] = msg.PubTime
+	}
+	for key, value := range msg.Attrs {
+		if !strings.HasPrefix(key, "ce-") {
+			continue
+		}
+		event[strings.TrimPrefix(key, "ce-")] = value
+	}
+	if msg.Data != "" {
+		event["data_base64"] = msg.Data
+	}
+	output, err := json.Marshal(event)
+	return string(output), err
+}
@@ -0,0 +1,141 @@
+package messages
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Message struct used to hold a pub/sub message
+type Message struct {
+	ID    string            `json:"messageId,omitempty"`
+	Attrs map[string]string `json:"attributes,omitempty"`
+	Data  string            `json:"data"`
+	// DedupID is an optional client supplied key; retried publishes
+	// carrying the same key within the dedup window are suppressed
+	DedupID string `json:"dedupId,omitempty"`
+	// DecodedData holds the utf-8 decoded payload, populated on demand
+	DecodedData string `json:"decodedData,omitempty"`
+	PubTime     string `json:"publishTime,omitempty"`
+}
+
+// MsgList holds a list of messages
+type MsgList struct {
+	Msgs []Message `json:"messages"`
+}
+
+// RecMsg holds a message and the ackId needed to acknowledge it
+type RecMsg struct {
+	AckID string  `json:"ackId,omitempty"`
+	Msg   Message `json:"message"`
+}
+
+// RecList holds a list of received messages
+type RecList struct {
+	RecMsgs []RecMsg `json:"receivedMessages"`
+}
+
+// New creates a new message with the given payload
+func New(data string) Message {
+	return Message{ID: "0", Attrs: make(map[string]string), Data: data}
+}
+
+// LoadMsgListJSON creates a MsgList from a json definition
+func LoadMsgListJSON(input []byte) (MsgList, error) {
+	list := MsgList{}
+	err := json.Unmarshal(input, &list)
+	return list, err
+}
+
+// LoadMsgJSON creates a Message from a json definition
+func LoadMsgJSON(input []byte) (Message, error) {
+	msg := Message{}
+	err := json.Unmarshal(input, &msg)
+	return msg, err
+}
+
+// ExportJSON exports the message as a json string
+func (msg *Message) ExportJSON() (string, error) {
+	output, err := json.Marshal(msg)
+	return string(output), err
+}
+
+// ExportJSON exports the message list as a json string
+func (msgL *MsgList) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(msgL, "", "   ")
+	return string(output), err
+}
+
+// ExportJSON exports the received message list as a json string
+func (recL *RecList) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(recL, "", "   ")
+	return string(output), err
+}
+
+// GetDecoded returns the base64 decoded payload of the message
+func (msg *Message) GetDecoded() (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(msg.Data)
+	if err != nil {
+		return "", errors.New("invalid base64 payload")
+	}
+	return string(decoded), nil
+}
+
+// AttachDecoded sets DecodedData when the payload decodes to valid
+// utf-8; binary or mangled payloads keep only their base64 form
+func (msg *Message) AttachDecoded() {
+	decoded, err := base64.StdEncoding.DecodeString(msg.Data)
+	if err != nil || !utf8.Valid(decoded) {
+		return
+	}
+	msg.DecodedData = string(decoded)
+}
+
+// AttrExists checks if an attribute exists in the message
+func (msg *Message) AttrExists(key string) bool {
+	_, ok := msg.Attrs[key]
+	return ok
+}
+
+// InsertAttribute adds or replaces an attribute on the message
+func (msg *Message) InsertAttribute(key string, value string) {
+	if msg.Attrs == nil {
+		msg.Attrs = make(map[string]string)
+	}
+	msg.Attrs[key] = value
+}
+
+// Stamp sets the publish time of the message
+func (msg *Message) Stamp(t time.Time) {
+	msg.PubTime = t.UTC().Format(time.RFC3339Nano)
+}
+
+// IdentityAttrPrefix reserves an attribute namespace for server-verified
+// publisher provenance; client supplied attributes under it are dropped
+const IdentityAttrPrefix = "ams."
+
+// StampIdentity overwrites the reserved provenance attributes of each
+// message with the server-verified publisher identity, so consumers can
+// trust them over anything the producer claims
+func StampIdentity(msgs []Message, userUUID string, authMethod string, remoteAddr string) {
+	sourceIP := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		sourceIP = host
+	}
+	for i := range msgs {
+		for key := range msgs[i].Attrs {
+			if strings.HasPrefix(key, IdentityAttrPrefix) {
+				delete(msgs[i].Attrs, key)
+			}
+		}
+		msgs[i].InsertAttribute(IdentityAttrPrefix+"publisher", userUUID)
+		msgs[i].InsertAttribute(IdentityAttrPrefix+"auth", authMethod)
+		if sourceIP != "" {
+			msgs[i].InsertAttribute(IdentityAttrPrefix+"source_ip", sourceIP)
+		}
+	}
+}
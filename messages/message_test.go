@@ -0,0 +1,127 @@
+package messages
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MessageTestSuite struct {
+	suite.Suite
+}
+
+func (suite *MessageTestSuite) TestLoadMsgListJSON() {
+	input := `{"messages": [{"attributes": {"foo": "bar"}, "data": "YmFzZTY0"}]}`
+	list, err := LoadMsgListJSON([]byte(input))
+	suite.NoError(err)
+	suite.Equal(1, len(list.Msgs))
+	suite.Equal("YmFzZTY0", list.Msgs[0].Data)
+	suite.Equal("bar", list.Msgs[0].Attrs["foo"])
+}
+
+func (suite *MessageTestSuite) TestGetDecoded() {
+	msg := New("aGVsbG8=")
+	decoded, err := msg.GetDecoded()
+	suite.NoError(err)
+	suite.Equal("hello", decoded)
+
+	msg = New("not-base64!!")
+	_, err = msg.GetDecoded()
+	suite.Error(err)
+}
+
+func (suite *MessageTestSuite) TestFromCloudEvent() {
+	input := `{"specversion": "1.0", "id": "ev-1", "source": "/sensors/a",
+		"type": "com.example.reading", "subject": "room-1", "traceid": "t-9",
+		"data": {"value": 21}}`
+	msg, err := FromCloudEvent([]byte(input))
+	suite.NoError(err)
+	suite.Equal("ev-1", msg.Attrs["ce-id"])
+	suite.Equal("/sensors/a", msg.Attrs["ce-source"])
+	suite.Equal("com.example.reading", msg.Attrs["ce-type"])
+	suite.Equal("room-1", msg.Attrs["ce-subject"])
+	// extension attributes come through with the same prefix
+	suite.Equal("t-9", msg.Attrs["ce-traceid"])
+	decoded, err := msg.GetDecoded()
+	suite.NoError(err)
+	suite.Contains(decoded, `"value": 21`)
+
+	// the required context attributes and the spec revision are enforced
+	_, err = FromCloudEvent([]byte(`{"specversion": "0.3", "id": "a", "source": "b", "type": "c"}`))
+	suite.Error(err)
+	_, err = FromCloudEvent([]byte(`{"specversion": "1.0", "source": "b", "type": "c"}`))
+	suite.Error(err)
+}
+
+func (suite *MessageTestSuite) TestFromCloudEventBinary() {
+	headers := map[string]string{
+		"Ce-Specversion": "1.0", "Ce-Id": "ev-2", "Ce-Source": "/sensors/b", "Ce-Type": "com.example.reading",
+	}
+	msg, err := FromCloudEventBinary(headers, "text/plain", []byte("21"))
+	suite.NoError(err)
+	suite.Equal("ev-2", msg.Attrs["ce-id"])
+	suite.Equal("text/plain", msg.Attrs["ce-datacontenttype"])
+	decoded, err := msg.GetDecoded()
+	suite.NoError(err)
+	suite.Equal("21", decoded)
+
+	_, err = FromCloudEventBinary(map[string]string{"Ce-Specversion": "1.0"}, "", []byte(""))
+	suite.Error(err)
+}
+
+func (suite *MessageTestSuite) TestToCloudEvent() {
+	// a message published as a cloudevent keeps its original context
+	msg, err := FromCloudEvent([]byte(`{"specversion": "1.0", "id": "ev-3",
+		"source": "/sensors/c", "type": "com.example.reading", "data_base64": "aGVsbG8="}`))
+	suite.NoError(err)
+	output, err := msg.ToCloudEvent("/projects/ARGO/subscriptions/sub1")
+	suite.NoError(err)
+	suite.Contains(output, `"id":"ev-3"`)
+	suite.Contains(output, `"source":"/sensors/c"`)
+	suite.Contains(output, `"data_base64":"aGVsbG8="`)
+
+	// a plain message gets a generated context with the subscription as source
+	plain := New("aGVsbG8=")
+	plain.ID = "4"
+	plain.Stamp(time.Date(2016, 10, 13, 2, 19, 9, 0, time.UTC))
+	output, err = plain.ToCloudEvent("/projects/ARGO/subscriptions/sub1")
+	suite.NoError(err)
+	suite.Contains(output, `"source":"/projects/ARGO/subscriptions/sub1"`)
+	suite.Contains(output, `"type":"ams.message"`)
+	suite.Contains(output, `"specversion":"1.0"`)
+}
+
+func (suite *MessageTestSuite) TestAttachDecoded() {
+	msg := New("aGVsbG8=")
+	msg.AttachDecoded()
+	suite.Equal("hello", msg.DecodedData)
+
+	// payloads that are not valid utf-8 keep only their base64 form
+	msg = New("//4=")
+	msg.AttachDecoded()
+	suite.Equal("", msg.DecodedData)
+
+	msg = New("not-base64!!")
+	msg.AttachDecoded()
+	suite.Equal("", msg.DecodedData)
+}
+
+func (suite *MessageTestSuite) TestAttributes() {
+	msg := Message{Data: "data"}
+	suite.False(msg.AttrExists("foo"))
+	msg.InsertAttribute("foo", "bar")
+	suite.True(msg.AttrExists("foo"))
+	suite.Equal("bar", msg.Attrs["foo"])
+}
+
+func (suite *MessageTestSuite) TestStamp() {
+	msg := New("data")
+	ts := time.Date(2016, 10, 13, 2, 19, 9, 0, time.UTC)
+	msg.Stamp(ts)
+	suite.Equal("2016-10-13T02:19:09Z", msg.PubTime)
+}
+
+func TestMessageTestSuite(t *testing.T) {
+	suite.Run(t, new(MessageTestSuite))
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxSamples bounds the retained samples per key; older samples are
+// discarded so summaries reflect recent traffic
+const maxSamples = 1024
+
+// LatencySummary describes the distribution of the retained samples of a key
+type LatencySummary struct {
+	Count  int64   `json:"count"`
+	MinMs  float64 `json:"min_ms"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P95Ms  float64 `json:"p95_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+// LatencyRecorder aggregates latency samples per key, e.g. per project
+type LatencyRecorder struct {
+	sync.Mutex
+	samples map[string][]time.Duration
+	counts  map[string]int64
+}
+
+// NewLatencyRecorder creates a new latency recorder
+func NewLatencyRecorder() *LatencyRecorder {
+	return &LatencyRecorder{samples: make(map[string][]time.Duration), counts: make(map[string]int64)}
+}
+
+// Record adds a latency sample for a key
+func (lr *LatencyRecorder) Record(key string, latency time.Duration) {
+	if latency < 0 {
+		return
+	}
+	lr.Lock()
+	defer lr.Unlock()
+	retained := lr.samples[key]
+	if len(retained) >= maxSamples {
+		retained = retained[1:]
+	}
+	lr.samples[key] = append(retained, latency)
+	lr.counts[key]++
+}
+
+// Snapshot summarizes the retained samples of every key
+func (lr *LatencyRecorder) Snapshot() map[string]LatencySummary {
+	lr.Lock()
+	defer lr.Unlock()
+	result := make(map[string]LatencySummary)
+	for key, retained := range lr.samples {
+		result[key] = summarize(retained, lr.counts[key])
+	}
+	return result
+}
+
+// summarize computes the distribution of a set of samples
+func summarize(samples []time.Duration, count int64) LatencySummary {
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	sum := time.Duration(0)
+	for _, sample := range sorted {
+		sum += sample
+	}
+	return LatencySummary{
+		Count:  count,
+		MinMs:  toMs(sorted[0]),
+		MeanMs: toMs(sum / time.Duration(len(sorted))),
+		P50Ms:  toMs(percentile(sorted, 50)),
+		P95Ms:  toMs(percentile(sorted, 95)),
+		P99Ms:  toMs(percentile(sorted, 99)),
+		MaxMs:  toMs(sorted[len(sorted)-1]),
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, pct int) time.Duration {
+	rank := (len(sorted)*pct + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// toMs converts a duration to fractional milliseconds
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// PublishLatency records the publish-path latency per project, measured
+// from the client supplied sendTime attribute to the broker publish time
+var PublishLatency = NewLatencyRecorder()
+
+// PushLatency records the end-to-end latency per subscription, measured
+// from the broker publish time to the successful push delivery
+var PushLatency = NewLatencyRecorder()
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+}
+
+func (suite *MetricsTestSuite) TestRecordSnapshot() {
+	lr := NewLatencyRecorder()
+	suite.Equal(0, len(lr.Snapshot()))
+
+	for i := 1; i <= 100; i++ {
+		lr.Record("ARGO", time.Duration(i)*time.Millisecond)
+	}
+	summary := lr.Snapshot()["ARGO"]
+	suite.Equal(int64(100), summary.Count)
+	suite.Equal(float64(1), summary.MinMs)
+	suite.Equal(float64(50), summary.P50Ms)
+	suite.Equal(float64(95), summary.P95Ms)
+	suite.Equal(float64(99), summary.P99Ms)
+	suite.Equal(float64(100), summary.MaxMs)
+	suite.Equal(float64(50.5), summary.MeanMs)
+
+	// negative samples are ignored
+	lr.Record("ARGO", -time.Millisecond)
+	suite.Equal(int64(100), lr.Snapshot()["ARGO"].Count)
+}
+
+func (suite *MetricsTestSuite) TestSampleRetention() {
+	lr := NewLatencyRecorder()
+	for i := 0; i < maxSamples+10; i++ {
+		lr.Record("ARGO", time.Millisecond)
+	}
+	// the count keeps growing while the retained samples stay bounded
+	suite.Equal(int64(maxSamples+10), lr.Snapshot()["ARGO"].Count)
+	suite.Equal(maxSamples, len(lr.samples["ARGO"]))
+}
+
+func TestMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(MetricsTestSuite))
+}
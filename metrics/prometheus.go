@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Prom is the registry the service components record into, exposed by
+// the prometheus endpoint of the admin port
+var Prom = NewPromRegistry()
+
+// defBuckets are the histogram bucket bounds in seconds, matching the
+// defaults of the prometheus client libraries
+var defBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PromRegistry collects counters and histograms and serves them in the
+// prometheus text exposition format
+type PromRegistry struct {
+	sync.Mutex
+	help       map[string]string
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*promHistogram
+}
+
+// promHistogram holds the cumulative bucket counts of one label set
+type promHistogram struct {
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+// NewPromRegistry creates an empty registry
+func NewPromRegistry() *PromRegistry {
+	return &PromRegistry{
+		help:       map[string]string{},
+		counters:   map[string]map[string]float64{},
+		histograms: map[string]map[string]*promHistogram{},
+	}
+}
+
+// IncCounter adds to the counter named by the metric and its label set
+func (reg *PromRegistry) IncCounter(name string, help string, labels map[string]string, value float64) {
+	reg.Lock()
+	defer reg.Unlock()
+	reg.help[name] = help
+	if reg.counters[name] == nil {
+		reg.counters[name] = map[string]float64{}
+	}
+	reg.counters[name][labelKey(labels)] += value
+}
+
+// Observe records a duration in seconds into the histogram named by the
+// metric and its label set
+func (reg *PromRegistry) Observe(name string, help string, labels map[string]string, seconds float64) {
+	reg.Lock()
+	defer reg.Unlock()
+	reg.help[name] = help
+	if reg.histograms[name] == nil {
+		reg.histograms[name] = map[string]*promHistogram{}
+	}
+	key := labelKey(labels)
+	hist := reg.histograms[name][key]
+	if hist == nil {
+		hist = &promHistogram{buckets: make([]int64, len(defBuckets))}
+		reg.histograms[name][key] = hist
+	}
+	for i, bound := range defBuckets {
+		if seconds <= bound {
+			hist.buckets[i]++
+		}
+	}
+	hist.count++
+	hist.sum += seconds
+}
+
+// labelKey serializes a label set into its exposition form, with the
+// label names sorted so equal sets always collapse onto one series
+func labelKey(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[name])
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, name, value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// series renders a metric line with an optional extra label appended
+func series(name string, key string, extra string) string {
+	labels := key
+	if extra != "" {
+		if labels != "" {
+			labels += ","
+		}
+		labels += extra
+	}
+	if labels == "" {
+		return name
+	}
+	return name + "{" + labels + "}"
+}
+
+// Write renders the registry in the text exposition format, metrics
+// and series in lexical order
+func (reg *PromRegistry) Write(w io.Writer) {
+	reg.Lock()
+	defer reg.Unlock()
+
+	names := make([]string, 0, len(reg.counters)+len(reg.histograms))
+	for name := range reg.counters {
+		names = append(names, name)
+	}
+	for name := range reg.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if sets, ok := reg.counters[name]; ok {
+			fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n", name, reg.help[name], name)
+			for _, key := range sortedKeys(sets) {
+				fmt.Fprintf(w, "%v %v\n", series(name, key, ""), sets[key])
+			}
+			continue
+		}
+		sets := reg.histograms[name]
+		fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v histogram\n", name, reg.help[name], name)
+		keys := make([]string, 0, len(sets))
+		for key := range sets {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			hist := sets[key]
+			for i, bound := range defBuckets {
+				fmt.Fprintf(w, "%v %v\n", series(name+"_bucket", key, fmt.Sprintf(`le="%v"`, bound)), hist.buckets[i])
+			}
+			fmt.Fprintf(w, "%v %v\n", series(name+"_bucket", key, `le="+Inf"`), hist.count)
+			fmt.Fprintf(w, "%v %v\n", series(name+"_sum", key, ""), hist.sum)
+			fmt.Fprintf(w, "%v %v\n", series(name+"_count", key, ""), hist.count)
+		}
+	}
+}
+
+// sortedKeys returns the label keys of a counter in lexical order
+func sortedKeys(sets map[string]float64) []string {
+	keys := make([]string, 0, len(sets))
+	for key := range sets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Handler serves the registry over http
+func (reg *PromRegistry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.Write(w)
+	})
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+)
+
+func (suite *MetricsTestSuite) TestPromExposition() {
+	reg := NewPromRegistry()
+	reg.IncCounter("ams_api_requests_total", "api requests",
+		map[string]string{"route": "topics:publish", "code": "200"}, 1)
+	reg.IncCounter("ams_api_requests_total", "api requests",
+		map[string]string{"code": "200", "route": "topics:publish"}, 2)
+	reg.IncCounter("ams_messages_published_total", "messages", nil, 5)
+	reg.Observe("ams_api_request_duration_seconds", "latency",
+		map[string]string{"route": "topics:publish"}, 0.03)
+	reg.Observe("ams_api_request_duration_seconds", "latency",
+		map[string]string{"route": "topics:publish"}, 42)
+
+	out := &strings.Builder{}
+	reg.Write(out)
+	text := out.String()
+
+	// label order does not split the series
+	suite.Contains(text, `ams_api_requests_total{code="200",route="topics:publish"} 3`)
+	suite.Contains(text, "# TYPE ams_api_requests_total counter")
+	suite.Contains(text, "ams_messages_published_total 5")
+	suite.Contains(text, "# TYPE ams_api_request_duration_seconds histogram")
+	// 0.03 lands in the 0.05 bucket, 42 only in +Inf
+	suite.Contains(text, `ams_api_request_duration_seconds_bucket{route="topics:publish",le="0.025"} 0`)
+	suite.Contains(text, `ams_api_request_duration_seconds_bucket{route="topics:publish",le="0.05"} 1`)
+	suite.Contains(text, `ams_api_request_duration_seconds_bucket{route="topics:publish",le="+Inf"} 2`)
+	suite.Contains(text, `ams_api_request_duration_seconds_sum{route="topics:publish"} 42.03`)
+	suite.Contains(text, `ams_api_request_duration_seconds_count{route="topics:publish"} 2`)
+
+	w := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	suite.Equal("text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	suite.Contains(w.Body.String(), "ams_messages_published_total 5")
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRateWindow is how far back publish events are retained for rate
+// computations
+const maxRateWindow = time.Hour
+
+// rateEvent is a counted occurrence at a point in time
+type rateEvent struct {
+	at time.Time
+	n  int64
+}
+
+// RateRecorder aggregates counted events per key so rates over a recent
+// window can be derived, e.g. messages per second per topic
+type RateRecorder struct {
+	sync.Mutex
+	events map[string][]rateEvent
+}
+
+// NewRateRecorder creates a new rate recorder
+func NewRateRecorder() *RateRecorder {
+	return &RateRecorder{events: make(map[string][]rateEvent)}
+}
+
+// Record adds n occurrences for a key at the current time
+func (rr *RateRecorder) Record(key string, n int64) {
+	if n <= 0 {
+		return
+	}
+	rr.Lock()
+	defer rr.Unlock()
+	now := time.Now().UTC()
+	rr.events[key] = append(prune(rr.events[key], now), rateEvent{at: now, n: n})
+}
+
+// PerSecond returns the rate of the events of a key over the given
+// window, bounded by the retention window
+func (rr *RateRecorder) PerSecond(key string, window time.Duration) float64 {
+	if window <= 0 || window > maxRateWindow {
+		window = maxRateWindow
+	}
+	rr.Lock()
+	defer rr.Unlock()
+	now := time.Now().UTC()
+	rr.events[key] = prune(rr.events[key], now)
+	total := int64(0)
+	for _, event := range rr.events[key] {
+		if now.Sub(event.at) <= window {
+			total += event.n
+		}
+	}
+	return float64(total) / window.Seconds()
+}
+
+// prune drops events older than the retention window
+func prune(events []rateEvent, now time.Time) []rateEvent {
+	kept := events[:0]
+	for _, event := range events {
+		if now.Sub(event.at) <= maxRateWindow {
+			kept = append(kept, event)
+		}
+	}
+	return kept
+}
+
+// MsgRate records the published messages per topic (projectUUID.topic)
+// and per project (projectUUID), used for the derived metric series
+var MsgRate = NewRateRecorder()
+
+// LockContention records, per lock name, the failed acquisitions of the
+// store-backed locks coordinating singleton jobs across instances
+var LockContention = NewRateRecorder()
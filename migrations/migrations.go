@@ -0,0 +1,85 @@
+package migrations
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// Migration evolves the store schema by one versioned step
+type Migration struct {
+	// Version orders the migration; versions are applied once, lowest first
+	Version int
+	// Description summarizes the change for the migration log
+	Description string
+	// Apply performs the change against the store
+	Apply func(store stores.Store) error
+}
+
+// registry holds every known migration in version order. New schema
+// changes are appended here with the next version number instead of
+// being applied manually on the backend.
+var registry = []Migration{
+	{Version: 1, Description: "backfill missing topic and subscription acl entries", Apply: backfillACLEntries},
+}
+
+// Run applies the pending migrations in order, recording every applied
+// version in the store so reruns are noops
+func Run(store stores.Store) error {
+	applied, err := store.QueryAppliedMigrations()
+	if err != nil {
+		return err
+	}
+	appliedVersions := make(map[int]bool)
+	for _, migration := range applied {
+		appliedVersions[migration.Version] = true
+	}
+
+	for _, migration := range registry {
+		if appliedVersions[migration.Version] {
+			continue
+		}
+		log.Infof("MIGRATIONS: applying version %v, %v", migration.Version, migration.Description)
+		if err := migration.Apply(store); err != nil {
+			return err
+		}
+		if err := store.InsertAppliedMigration(migration.Version, migration.Description,
+			time.Now().UTC().Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillACLEntries creates empty acl entries for topics and
+// subscriptions that predate per-resource authorization
+func backfillACLEntries(store stores.Store) error {
+	projects, err := store.QueryProjects("", "")
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		topics, _, _, err := store.QueryTopics(project.UUID, "", "", "", 0, "", false)
+		if err != nil {
+			return err
+		}
+		for _, topic := range topics {
+			if err := store.EnsureACL(project.UUID, "topics", topic.Name); err != nil {
+				return err
+			}
+		}
+
+		subs, _, _, err := store.QuerySubs(project.UUID, "", "", "", 0, "", false)
+		if err != nil {
+			return err
+		}
+		for _, sub := range subs {
+			if err := store.EnsureACL(project.UUID, "subscriptions", sub.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+type MigrationsTestSuite struct {
+	suite.Suite
+}
+
+func (suite *MigrationsTestSuite) TestRunAppliesOnce() {
+	store := stores.NewMockStore("", "")
+
+	// simulate a topic that predates per-resource authorization
+	delete(store.TopicsACL, "argo_uuid.topic1")
+
+	suite.NoError(Run(store))
+
+	// the missing acl entry was backfilled
+	acl, err := store.QueryACL("argo_uuid", "topics", "topic1")
+	suite.NoError(err)
+	suite.Equal([]string{}, acl.ACL)
+
+	// existing entries are left untouched
+	acl, _ = store.QueryACL("argo_uuid", "topics", "topic2")
+	suite.Equal([]string{"uuid1", "uuid2", "uuid4"}, acl.ACL)
+
+	// every applied version is recorded once, reruns are noops
+	suite.Equal(len(registry), len(store.Migrations))
+	suite.NoError(Run(store))
+	suite.Equal(len(registry), len(store.Migrations))
+}
+
+func TestMigrationsTestSuite(t *testing.T) {
+	suite.Run(t, new(MigrationsTestSuite))
+}
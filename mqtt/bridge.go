@@ -0,0 +1,391 @@
+// Package mqtt bridges the messaging service to mqtt 3.1.1 clients.
+// Publishing to "project/topic" maps to topics:publish and subscribing
+// to "project/subscription" delivers the subscription messages, reusing
+// the token auth of the rest of the api: clients send their token as
+// the mqtt password.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kaggis/argo-messaging/auth"
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/config"
+	"github.com/kaggis/argo-messaging/messages"
+	"github.com/kaggis/argo-messaging/projects"
+	"github.com/kaggis/argo-messaging/stores"
+	"github.com/kaggis/argo-messaging/subscriptions"
+	"github.com/kaggis/argo-messaging/topics"
+)
+
+// pollInterval is how often a subscription delivery polls the broker
+// when there are no new messages
+const pollInterval = 250 * time.Millisecond
+
+// deliverBatch bounds the messages fetched per broker poll
+const deliverBatch = int64(100)
+
+// Bridge accepts mqtt connections and maps them onto the messaging api
+type Bridge struct {
+	cfg  *config.APICfg
+	pool brokers.Provider
+	str  stores.Store
+}
+
+// NewBridge creates a new mqtt bridge backed by the given service references
+func NewBridge(cfg *config.APICfg, pool brokers.Provider, str stores.Store) *Bridge {
+	return &Bridge{cfg: cfg, pool: pool, str: str}
+}
+
+// Serve accepts and handles mqtt clients on the given listener
+func (b *Bridge) Serve(listener net.Listener) error {
+	log.Infof("MQTT: argo-messaging mqtt bridge listens on %v", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go b.handleConn(conn)
+	}
+}
+
+// session holds the state of one mqtt client connection
+type session struct {
+	conn      net.Conn
+	writeLock sync.Mutex
+	token     string
+	// deliveries maps a subscribed topic filter to the cancel function
+	// of its delivery loop
+	deliveries map[string]context.CancelFunc
+}
+
+// write frames and sends a packet, safe for concurrent delivery loops
+func (s *session) write(header byte, payload []byte) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	_, err := s.conn.Write(encodePacket(header, payload))
+	return err
+}
+
+// handleConn speaks mqtt 3.1.1 with a single client
+func (b *Bridge) handleConn(conn net.Conn) {
+	defer conn.Close()
+	sess := session{conn: conn, deliveries: make(map[string]context.CancelFunc)}
+	defer func() {
+		for _, cancel := range sess.deliveries {
+			cancel()
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+
+	// the first packet must be a connect
+	header, payload, err := readPacket(reader)
+	if err != nil || header>>4 != packetConnect {
+		return
+	}
+	if !b.handleConnect(&sess, payload) {
+		return
+	}
+
+	for {
+		header, payload, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case packetPublish:
+			if !b.handlePublish(&sess, header, payload) {
+				return
+			}
+		case packetSubscribe:
+			if !b.handleSubscribe(&sess, payload) {
+				return
+			}
+		case packetUnsubscribe:
+			if !b.handleUnsubscribe(&sess, payload) {
+				return
+			}
+		case packetPingreq:
+			if err := sess.write(packetPingresp<<4, []byte{}); err != nil {
+				return
+			}
+		case packetDisconnect:
+			return
+		default:
+			return
+		}
+	}
+}
+
+// handleConnect validates the connect packet and stores the token the
+// client sent as its mqtt password
+func (b *Bridge) handleConnect(sess *session, payload []byte) bool {
+	protocol, offset, err := readString(payload, 0)
+	if err != nil || offset >= len(payload) {
+		return false
+	}
+	level := payload[offset]
+	if protocol != "MQTT" || level != 4 {
+		sess.write(packetConnack<<4, []byte{0x00, connBadProtocolVersion})
+		return false
+	}
+	flags := payload[offset+1]
+	// skip the keepalive and the client id
+	offset += 4
+	_, offset, err = readString(payload, offset)
+	if err != nil {
+		return false
+	}
+	// skip the will topic and message if present
+	if flags&0x04 != 0 {
+		if _, offset, err = readString(payload, offset); err != nil {
+			return false
+		}
+		if _, offset, err = readString(payload, offset); err != nil {
+			return false
+		}
+	}
+	// the username is ignored, the password carries the token
+	if flags&0x80 != 0 {
+		if _, offset, err = readString(payload, offset); err != nil {
+			return false
+		}
+	}
+	if flags&0x40 != 0 {
+		if sess.token, _, err = readString(payload, offset); err != nil {
+			return false
+		}
+	}
+
+	// authorization happens per publish/subscribe since tokens hold
+	// their roles per project
+	return sess.write(packetConnack<<4, []byte{0x00, connAccepted}) == nil
+}
+
+// splitTopic splits a "project/resource" mqtt topic name
+func splitTopic(topic string) (string, string, bool) {
+	tokens := strings.SplitN(topic, "/", 2)
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" || strings.ContainsAny(tokens[1], "/#+") {
+		return "", "", false
+	}
+	return tokens[0], tokens[1], true
+}
+
+// handlePublish maps an inbound publish onto topics:publish
+func (b *Bridge) handlePublish(sess *session, header byte, payload []byte) bool {
+	topicName, offset, err := readString(payload, 0)
+	if err != nil {
+		return false
+	}
+	qos := (header >> 1) & 0x03
+	pid := []byte{}
+	if qos > 0 {
+		if offset+2 > len(payload) {
+			return false
+		}
+		pid = payload[offset : offset+2]
+		offset += 2
+	}
+	data := payload[offset:]
+
+	projectName, name, ok := splitTopic(topicName)
+	if !ok {
+		return false
+	}
+
+	if !b.publish(sess, projectName, name, data) {
+		// a rejected qos 0 publish is dropped silently per spec; higher
+		// qos levels get no ack so the client can retry elsewhere
+		return true
+	}
+	if qos == 1 {
+		return sess.write(packetPuback<<4, pid) == nil
+	}
+	return true
+}
+
+// publish authorizes one publish to a project topic and runs it through
+// the pipeline shared with the http api, so the size cap, the daily
+// quotas and the usage accounting apply to mqtt publishes as well
+func (b *Bridge) publish(sess *session, projectName string, topicName string, data []byte) bool {
+	projectUUID := projects.GetUUIDByName(projectName, b.str)
+	if projectUUID == "" {
+		return false
+	}
+	roles, userUUID := auth.Authenticate(projectUUID, sess.token, b.str)
+	if userUUID == "" || !auth.Authorize("topics:publish", roles, b.str) {
+		return false
+	}
+	if !b.perResourceAuthorized(projectUUID, "topics", topicName, userUUID, roles) {
+		return false
+	}
+
+	results, err := topics.Find(projectUUID, "", topicName, "", 0, b.str)
+	if err != nil || results.Empty() {
+		return false
+	}
+	topic := results.One()
+
+	msgs := []messages.Message{messages.New(base64.StdEncoding.EncodeToString(data))}
+	if b.cfg.PublishIdentityAttributes {
+		messages.StampIdentity(msgs, userUUID, "key", sess.conn.RemoteAddr().String())
+	}
+	brk := brokers.ForProject(b.pool, b.str, projectUUID)
+	_, err = topics.PublishMessages(projectUUID, topic, msgs, brk, b.str)
+	return err == nil
+}
+
+// handleSubscribe maps topic filters onto subscription delivery loops
+func (b *Bridge) handleSubscribe(sess *session, payload []byte) bool {
+	if len(payload) < 2 {
+		return false
+	}
+	pid := payload[0:2]
+	offset := 2
+
+	granted := []byte{}
+	for offset < len(payload) {
+		filter, next, err := readString(payload, offset)
+		if err != nil || next >= len(payload) {
+			return false
+		}
+		// the requested qos is capped to 0, delivery is at most once
+		offset = next + 1
+
+		projectName, subName, ok := splitTopic(filter)
+		if !ok || !b.subscribable(sess.token, projectName, subName) {
+			granted = append(granted, subFailure)
+			continue
+		}
+
+		if _, exists := sess.deliveries[filter]; !exists {
+			ctx, cancel := context.WithCancel(context.Background())
+			sess.deliveries[filter] = cancel
+			go b.deliver(ctx, sess, filter, projectName, subName)
+		}
+		granted = append(granted, 0x00)
+	}
+
+	return sess.write(packetSuback<<4, append(pid, granted...)) == nil
+}
+
+// handleUnsubscribe stops the delivery loops of the given filters
+func (b *Bridge) handleUnsubscribe(sess *session, payload []byte) bool {
+	if len(payload) < 2 {
+		return false
+	}
+	pid := payload[0:2]
+	offset := 2
+	for offset < len(payload) {
+		filter, next, err := readString(payload, offset)
+		if err != nil {
+			return false
+		}
+		offset = next
+		if cancel, ok := sess.deliveries[filter]; ok {
+			cancel()
+			delete(sess.deliveries, filter)
+		}
+	}
+	return sess.write(packetUnsuback<<4, pid) == nil
+}
+
+// subscribable authorizes the token for pulling a subscription
+func (b *Bridge) subscribable(token string, projectName string, subName string) bool {
+	projectUUID := projects.GetUUIDByName(projectName, b.str)
+	if projectUUID == "" {
+		return false
+	}
+	roles, userUUID := auth.Authenticate(projectUUID, token, b.str)
+	if userUUID == "" || !auth.Authorize("subscriptions:pull", roles, b.str) {
+		return false
+	}
+	if !b.perResourceAuthorized(projectUUID, "subscriptions", subName, userUUID, roles) {
+		return false
+	}
+	return subscriptions.HasSub(projectUUID, subName, b.str)
+}
+
+// deliver polls a subscription and pushes its messages to the client as
+// qos 0 publishes, advancing the offset as messages go out
+func (b *Bridge) deliver(ctx context.Context, sess *session, filter string, projectName string, subName string) {
+	projectUUID := projects.GetUUIDByName(projectName, b.str)
+	results, err := subscriptions.Find(projectUUID, "", subName, "", 0, b.str)
+	if err != nil || results.Empty() {
+		return
+	}
+	sub := results.One()
+
+	brk := brokers.ForProject(b.pool, b.str, projectUUID)
+	fullTopic := sub.ConsumeTopic()
+	offset := sub.Offset
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		msgs, err := brk.Consume(fullTopic, offset, true, deliverBatch)
+		if err != nil {
+			if err.Error() == "offset is off" {
+				offset = brk.GetMinOffset(fullTopic)
+				b.str.UpdateSubOffset(projectUUID, subName, offset)
+				continue
+			}
+			return
+		}
+
+		for _, payload := range msgs {
+			msg, err := messages.LoadMsgJSON([]byte(payload))
+			if err != nil {
+				return
+			}
+			data, err := base64.StdEncoding.DecodeString(msg.Data)
+			if err != nil {
+				return
+			}
+			packet := append(encodeString(filter), data...)
+			if err := sess.write(packetPublish<<4, packet); err != nil {
+				return
+			}
+		}
+
+		if len(msgs) > 0 {
+			offset += int64(len(msgs))
+			// qos 0 delivery is at most once, the offset advances as
+			// messages are written out
+			b.str.UpdateSubOffset(projectUUID, subName, offset)
+			b.str.IncrementSubMsgNum(projectUUID, subName, int64(len(msgs)))
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// perResourceAuthorized checks access to a specific resource when per
+// resource authorization is enabled
+func (b *Bridge) perResourceAuthorized(projectUUID string, resourceType string, resourceName string, userUUID string, roles []string) bool {
+	if !b.cfg.ResAuth {
+		return true
+	}
+	if auth.IsServiceAdmin(roles) || auth.IsProjectAdmin(roles) {
+		return true
+	}
+	return auth.PerResource(projectUUID, resourceType, resourceName, userUUID, b.str)
+}
@@ -0,0 +1,135 @@
+package mqtt
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/config"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// testBridge holds a served bridge and a connected raw mqtt client
+type testBridge struct {
+	str    *stores.MockStore
+	brk    *brokers.MockBroker
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newTestBridge serves a fully mocked bridge and dials it
+func newTestBridge(t *testing.T) *testBridge {
+	tb := testBridge{}
+	cfg := config.NewAPICfg()
+	cfg.LoadStrJSON(`{"kafka_clusters": {"main": ["localhost:9092"]}, "per_resource_auth": true}`)
+	tb.str = stores.NewMockStore("", "")
+	tb.brk = brokers.NewMockBroker()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go NewBridge(cfg, brokers.NewMockPool(tb.brk), tb.str).Serve(listener)
+
+	tb.conn, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tb.conn.Close() })
+	tb.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	tb.reader = bufio.NewReader(tb.conn)
+	return &tb
+}
+
+// connect performs the mqtt connect handshake with the given token
+func (tb *testBridge) connect(token string) byte {
+	payload := encodeString("MQTT")
+	payload = append(payload, 4, 0xC0, 0, 0)
+	payload = append(payload, encodeString("test-client")...)
+	payload = append(payload, encodeString("user")...)
+	payload = append(payload, encodeString(token)...)
+	tb.conn.Write(encodePacket(packetConnect<<4, payload))
+
+	header, ack, _ := readPacket(tb.reader)
+	if header>>4 != packetConnack || len(ack) != 2 {
+		return 0xff
+	}
+	return ack[1]
+}
+
+type BridgeTestSuite struct {
+	suite.Suite
+}
+
+func (suite *BridgeTestSuite) TestConnect() {
+	tb := newTestBridge(suite.T())
+	suite.Equal(byte(connAccepted), tb.connect("S3CR3T1"))
+}
+
+func (suite *BridgeTestSuite) TestRejectsOldProtocol() {
+	tb := newTestBridge(suite.T())
+
+	payload := encodeString("MQIsdp")
+	payload = append(payload, 3, 0x00, 0, 0)
+	payload = append(payload, encodeString("test-client")...)
+	tb.conn.Write(encodePacket(packetConnect<<4, payload))
+
+	header, ack, err := readPacket(tb.reader)
+	suite.NoError(err)
+	suite.Equal(byte(packetConnack), header>>4)
+	suite.Equal(byte(connBadProtocolVersion), ack[1])
+}
+
+func (suite *BridgeTestSuite) TestPublish() {
+	tb := newTestBridge(suite.T())
+	tb.connect("S3CR3T1")
+
+	// qos 1 publish to ARGO/topic1 is acked and lands on the broker
+	payload := encodeString("ARGO/topic1")
+	payload = append(payload, 0x00, 0x01)
+	payload = append(payload, []byte("mqtt data")...)
+	tb.conn.Write(encodePacket(packetPublish<<4|0x02, payload))
+
+	header, ack, err := readPacket(tb.reader)
+	suite.NoError(err)
+	suite.Equal(byte(packetPuback), header>>4)
+	suite.Equal([]byte{0x00, 0x01}, ack)
+	suite.Equal(5, len(tb.brk.MsgList["argo_uuid.topic1"]))
+}
+
+func (suite *BridgeTestSuite) TestSubscribe() {
+	tb := newTestBridge(suite.T())
+	tb.connect("S3CR3T1")
+
+	// subscribing to sub1 and an unknown subscription in one packet
+	payload := []byte{0x00, 0x01}
+	payload = append(payload, encodeString("ARGO/sub1")...)
+	payload = append(payload, 0x00)
+	payload = append(payload, encodeString("ARGO/nosuchsub")...)
+	payload = append(payload, 0x00)
+	tb.conn.Write(encodePacket(packetSubscribe<<4|0x02, payload))
+
+	header, ack, err := readPacket(tb.reader)
+	suite.NoError(err)
+	suite.Equal(byte(packetSuback), header>>4)
+	suite.Equal([]byte{0x00, 0x01, 0x00, subFailure}, ack)
+
+	// the four seeded messages of topic1 arrive as qos 0 publishes
+	for i := 0; i < 4; i++ {
+		header, publish, err := readPacket(tb.reader)
+		suite.NoError(err)
+		suite.Equal(byte(packetPublish), header>>4)
+		topic, offset, _ := readString(publish, 0)
+		suite.Equal("ARGO/sub1", topic)
+		suite.Equal("mock_data_"+string(rune('0'+i)), string(publish[offset:]))
+	}
+}
+
+func TestBridgeTestSuite(t *testing.T) {
+	suite.Run(t, new(BridgeTestSuite))
+}
@@ -0,0 +1,106 @@
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// mqtt 3.1.1 control packet types
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// connack return codes
+const (
+	connAccepted           = 0x00
+	connBadProtocolVersion = 0x01
+)
+
+// subFailure marks a rejected topic filter in a suback
+const subFailure = 0x80
+
+// maxRemainingLength bounds the accepted packet size
+const maxRemainingLength = 4 * 1048576
+
+// readPacket reads one control packet, returning its fixed header byte
+// and the remaining bytes
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// remaining length is a 7 bit per byte varint
+	length := 0
+	for shift := 0; ; shift += 7 {
+		if shift > 21 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxRemainingLength {
+		return 0, nil, errors.New("packet too large")
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header, payload, nil
+}
+
+// encodePacket frames a control packet with its remaining length
+func encodePacket(header byte, payload []byte) []byte {
+	packet := []byte{header}
+	length := len(payload)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, payload...)
+}
+
+// readString reads a length-prefixed utf8 string starting at offset,
+// returning the string and the offset past it
+func readString(payload []byte, offset int) (string, int, error) {
+	if offset+2 > len(payload) {
+		return "", 0, errors.New("malformed string")
+	}
+	length := int(payload[offset])<<8 | int(payload[offset+1])
+	offset += 2
+	if offset+length > len(payload) {
+		return "", 0, errors.New("malformed string")
+	}
+	return string(payload[offset : offset+length]), offset + length, nil
+}
+
+// encodeString encodes a length-prefixed utf8 string
+func encodeString(value string) []byte {
+	encoded := []byte{byte(len(value) >> 8), byte(len(value))}
+	return append(encoded, value...)
+}
@@ -0,0 +1,70 @@
+// Package names centralizes the formatting and parsing of the
+// projects/X/topics/Y style resource fullnames and the ack ids derived from
+// them, so every handler exchanges the exact same formats with clients.
+package names
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// validSegment restricts the project and resource segments of a fullname to
+// the character set the api routes accept
+var validSegment = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Topic formats the fullname of a topic
+func Topic(project string, topic string) string {
+	return "projects/" + project + "/topics/" + topic
+}
+
+// Subscription formats the fullname of a subscription
+func Subscription(project string, sub string) string {
+	return "projects/" + project + "/subscriptions/" + sub
+}
+
+// ParseTopic extracts the project and topic name from a projects/X/topics/Y
+// reference, rejecting anything that Topic would not have produced
+func ParseTopic(fullName string) (string, string, error) {
+	return parse(fullName, "topics")
+}
+
+// ParseSubscription extracts the project and subscription name from a
+// projects/X/subscriptions/Y reference, rejecting anything that Subscription
+// would not have produced
+func ParseSubscription(fullName string) (string, string, error) {
+	return parse(fullName, "subscriptions")
+}
+
+func parse(fullName string, collection string) (string, string, error) {
+	tokens := strings.Split(fullName, "/")
+	if len(tokens) != 4 || tokens[0] != "projects" || tokens[2] != collection ||
+		!validSegment.MatchString(tokens[1]) || !validSegment.MatchString(tokens[3]) {
+		return "", "", errors.New("invalid " + strings.TrimSuffix(collection, "s") + " fullname")
+	}
+	return tokens[1], tokens[3], nil
+}
+
+// AckID formats the ack id of a message pulled at the given offset
+func AckID(subFullName string, offset int64) string {
+	return subFullName + ":" + strconv.FormatInt(offset, 10)
+}
+
+// ParseAckID extracts the subscription fullname and message offset from an
+// ack id, rejecting anything that AckID would not have produced
+func ParseAckID(ackID string) (string, int64, error) {
+	idx := strings.LastIndex(ackID, ":")
+	if idx < 0 {
+		return "", 0, errors.New("invalid ack id")
+	}
+	fullName := ackID[:idx]
+	if _, _, err := ParseSubscription(fullName); err != nil {
+		return "", 0, errors.New("invalid ack id")
+	}
+	offset, err := strconv.ParseInt(ackID[idx+1:], 10, 64)
+	if err != nil || offset < 0 {
+		return "", 0, errors.New("invalid ack id")
+	}
+	return fullName, offset, nil
+}
@@ -0,0 +1,72 @@
+package names
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type NamesTestSuite struct {
+	suite.Suite
+}
+
+func (suite *NamesTestSuite) TestTopicRoundTrip() {
+	fullName := Topic("ARGO", "topic1")
+	suite.Equal("projects/ARGO/topics/topic1", fullName)
+	project, topic, err := ParseTopic(fullName)
+	suite.NoError(err)
+	suite.Equal("ARGO", project)
+	suite.Equal("topic1", topic)
+}
+
+func (suite *NamesTestSuite) TestSubscriptionRoundTrip() {
+	fullName := Subscription("ARGO", "sub1")
+	suite.Equal("projects/ARGO/subscriptions/sub1", fullName)
+	project, sub, err := ParseSubscription(fullName)
+	suite.NoError(err)
+	suite.Equal("ARGO", project)
+	suite.Equal("sub1", sub)
+}
+
+func (suite *NamesTestSuite) TestParseStrict() {
+	for _, fullName := range []string{
+		"",
+		"topic1",
+		"projects/ARGO/topics",
+		"projects/ARGO/topics/topic1/extra",
+		"projects/ARGO/subscriptions/sub1",
+		"projects//topics/topic1",
+		"projects/ARGO/topics/bad.name",
+		"Projects/ARGO/topics/topic1",
+	} {
+		_, _, err := ParseTopic(fullName)
+		suite.Error(err, fullName)
+	}
+	_, _, err := ParseSubscription("projects/ARGO/topics/topic1")
+	suite.Error(err)
+}
+
+func (suite *NamesTestSuite) TestAckIDRoundTrip() {
+	ackID := AckID("projects/ARGO/subscriptions/sub1", 42)
+	suite.Equal("projects/ARGO/subscriptions/sub1:42", ackID)
+	fullName, offset, err := ParseAckID(ackID)
+	suite.NoError(err)
+	suite.Equal("projects/ARGO/subscriptions/sub1", fullName)
+	suite.Equal(int64(42), offset)
+
+	for _, ackID := range []string{
+		"",
+		"projects/ARGO/subscriptions/sub1",
+		"projects/ARGO/subscriptions/sub1:",
+		"projects/ARGO/subscriptions/sub1:-1",
+		"projects/ARGO/subscriptions/sub1:five",
+		"projects/ARGO/topics/topic1:5",
+	} {
+		_, _, err := ParseAckID(ackID)
+		suite.Error(err, ackID)
+	}
+}
+
+func TestNamesTestSuite(t *testing.T) {
+	suite.Run(t, new(NamesTestSuite))
+}
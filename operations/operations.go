@@ -0,0 +1,160 @@
+package operations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Operation is the public view of a long-running action. Heavy api
+// actions return an operation immediately and perform the work in the
+// background; clients poll the operation until it reaches a terminal
+// status (completed, failed or cancelled).
+type Operation struct {
+	ID string `json:"id"`
+	// Type names the action, e.g. project.purge
+	Type string `json:"type"`
+	// Resource is the resource the action operates on
+	Resource string `json:"resource"`
+	// Status is one of pending, running, completed, failed, cancelled
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	CreatedOn string `json:"created_on"`
+	UpdatedOn string `json:"updated_on"`
+}
+
+// ExportJSON exports the operation as an indented json string
+func (op *Operation) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(op, "", "   ")
+	return string(output), err
+}
+
+// operation pairs the public view with the cancellation handle of the
+// background run
+type operation struct {
+	view   Operation
+	cancel context.CancelFunc
+}
+
+// Manager tracks the long-running operations of the service in memory
+type Manager struct {
+	sync.Mutex
+	ops map[string]*operation
+}
+
+// NewManager creates a new operation manager
+func NewManager() *Manager {
+	return &Manager{ops: make(map[string]*operation)}
+}
+
+// Start registers a new operation and runs the given function in the
+// background, updating the operation status as it progresses. The run
+// function must honor its context so cancellation can take effect.
+func (m *Manager) Start(opType string, resource string, run func(ctx context.Context) error) Operation {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	ctx, cancel := context.WithCancel(context.Background())
+	op := operation{
+		view:   Operation{ID: uuid.NewString(), Type: opType, Resource: resource, Status: "pending", CreatedOn: now, UpdatedOn: now},
+		cancel: cancel,
+	}
+
+	m.Lock()
+	m.ops[op.view.ID] = &op
+	m.Unlock()
+
+	go func() {
+		m.setStatus(op.view.ID, "running", "")
+		err := run(ctx)
+		switch {
+		case err == nil:
+			m.setStatus(op.view.ID, "completed", "")
+		case errors.Is(err, context.Canceled):
+			m.setStatus(op.view.ID, "cancelled", "")
+		default:
+			m.setStatus(op.view.ID, "failed", err.Error())
+		}
+	}()
+
+	return op.view
+}
+
+// Get returns the current view of an operation
+func (m *Manager) Get(id string) (Operation, error) {
+	m.Lock()
+	defer m.Unlock()
+	op, ok := m.ops[id]
+	if !ok {
+		return Operation{}, errors.New("not found")
+	}
+	return op.view, nil
+}
+
+// Cancel requests the cancellation of a running operation and returns
+// its current view; terminal operations are left untouched
+func (m *Manager) Cancel(id string) (Operation, error) {
+	m.Lock()
+	defer m.Unlock()
+	op, ok := m.ops[id]
+	if !ok {
+		return Operation{}, errors.New("not found")
+	}
+	if op.view.Status == "pending" || op.view.Status == "running" {
+		op.cancel()
+	}
+	return op.view, nil
+}
+
+// List returns the current view of every known operation
+func (m *Manager) List() []Operation {
+	m.Lock()
+	defer m.Unlock()
+	result := []Operation{}
+	for _, op := range m.ops {
+		result = append(result, op.view)
+	}
+	return result
+}
+
+// setStatus moves an operation to a new status unless it is already
+// terminal (a cancelled run must not flip back to completed)
+func (m *Manager) setStatus(id string, status string, errMsg string) {
+	m.Lock()
+	defer m.Unlock()
+	op, ok := m.ops[id]
+	if !ok {
+		return
+	}
+	if op.view.Status != "pending" && op.view.Status != "running" {
+		return
+	}
+	op.view.Status = status
+	op.view.Error = errMsg
+	op.view.UpdatedOn = time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// defaultManager is the service-wide operation manager used by the api
+var defaultManager = NewManager()
+
+// Start registers and runs an operation on the service-wide manager
+func Start(opType string, resource string, run func(ctx context.Context) error) Operation {
+	return defaultManager.Start(opType, resource, run)
+}
+
+// Get returns an operation of the service-wide manager
+func Get(id string) (Operation, error) {
+	return defaultManager.Get(id)
+}
+
+// Cancel cancels an operation of the service-wide manager
+func Cancel(id string) (Operation, error) {
+	return defaultManager.Cancel(id)
+}
+
+// List returns the operations of the service-wide manager
+func List() []Operation {
+	return defaultManager.List()
+}
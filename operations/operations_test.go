@@ -0,0 +1,83 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type OperationsTestSuite struct {
+	suite.Suite
+}
+
+// waitStatus polls an operation until it leaves the pending/running states
+func waitStatus(m *Manager, id string) Operation {
+	for i := 0; i < 100; i++ {
+		op, _ := m.Get(id)
+		if op.Status != "pending" && op.Status != "running" {
+			return op
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	op, _ := m.Get(id)
+	return op
+}
+
+func (suite *OperationsTestSuite) TestStartCompletes() {
+	m := NewManager()
+
+	op := m.Start("test.op", "resource1", func(ctx context.Context) error {
+		return nil
+	})
+	suite.Equal("test.op", op.Type)
+	suite.Equal("resource1", op.Resource)
+
+	op = waitStatus(m, op.ID)
+	suite.Equal("completed", op.Status)
+	suite.Equal("", op.Error)
+}
+
+func (suite *OperationsTestSuite) TestStartFails() {
+	m := NewManager()
+
+	op := m.Start("test.op", "resource1", func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+
+	op = waitStatus(m, op.ID)
+	suite.Equal("failed", op.Status)
+	suite.Equal("boom", op.Error)
+}
+
+func (suite *OperationsTestSuite) TestCancel() {
+	m := NewManager()
+
+	op := m.Start("test.op", "resource1", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	_, err := m.Cancel(op.ID)
+	suite.NoError(err)
+
+	op = waitStatus(m, op.ID)
+	suite.Equal("cancelled", op.Status)
+
+	// cancelling a terminal operation is a noop
+	op, err = m.Cancel(op.ID)
+	suite.NoError(err)
+	suite.Equal("cancelled", op.Status)
+}
+
+func (suite *OperationsTestSuite) TestGetUnknown() {
+	m := NewManager()
+	_, err := m.Get("nosuchop")
+	suite.Equal("not found", err.Error())
+}
+
+func TestOperationsTestSuite(t *testing.T) {
+	suite.Run(t, new(OperationsTestSuite))
+}
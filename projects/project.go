@@ -0,0 +1,163 @@
+package projects
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// Project holds the project information exposed by the api
+type Project struct {
+	UUID        string `json:"-"`
+	Name        string `json:"name,omitempty"`
+	CreatedOn   string `json:"created_on,omitempty"`
+	ModifiedOn  string `json:"modified_on,omitempty"`
+	CreatedBy   string `json:"created_by,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Tags are free-form labels grouping projects in usage reports,
+	// e.g. a cost-center for per-activity accounting
+	Tags map[string]string `json:"tags,omitempty"`
+	// MaxTopics and MaxSubscriptions cap how many topics and
+	// subscriptions the project may hold (0 means unlimited)
+	MaxTopics        int `json:"max_topics,omitempty"`
+	MaxSubscriptions int `json:"max_subscriptions,omitempty"`
+	// MaxDailyMessages and MaxDailyBytes cap what the project may publish
+	// per utc day; 0 leaves the throughput uncapped
+	MaxDailyMessages int64 `json:"max_daily_messages,omitempty"`
+	MaxDailyBytes    int64 `json:"max_daily_bytes,omitempty"`
+	// TopicsCount and SubscriptionsCount are the live resource counts,
+	// filled in when the project is read back
+	TopicsCount        int64 `json:"topics_count"`
+	SubscriptionsCount int64 `json:"subscriptions_count"`
+}
+
+// Projects holds a list of projects
+type Projects struct {
+	List []Project `json:"projects"`
+}
+
+// ExportJSON exports the project as a json string
+func (p *Project) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(p, "", "   ")
+	return string(output), err
+}
+
+// ExportJSON exports the project list as a json string
+func (ps *Projects) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(ps, "", "   ")
+	return string(output), err
+}
+
+// Empty returns true if the projects list holds no projects
+func (ps *Projects) Empty() bool {
+	return len(ps.List) == 0
+}
+
+// One returns the first project of the list
+func (ps *Projects) One() Project {
+	if ps.Empty() {
+		return Project{}
+	}
+	return ps.List[0]
+}
+
+// GetFromJSON creates a project from a json definition
+func GetFromJSON(input []byte) (Project, error) {
+	p := Project{}
+	err := json.Unmarshal(input, &p)
+	return p, err
+}
+
+// NewProject creates a new project instance
+func NewProject(uuid string, name string, createdOn time.Time, createdBy string, description string, tags map[string]string, maxTopics int, maxSubs int) Project {
+	created := createdOn.UTC().Format(time.RFC3339)
+	return Project{UUID: uuid, Name: name, CreatedOn: created, ModifiedOn: created, CreatedBy: createdBy,
+		Description: description, Tags: tags, MaxTopics: maxTopics, MaxSubscriptions: maxSubs}
+}
+
+// Find returns projects by uuid or name, or all projects if both are empty
+func Find(uuid string, name string, store stores.Store) (Projects, error) {
+	result := Projects{}
+	projects, err := store.QueryProjects(uuid, name)
+	if err != nil {
+		return result, err
+	}
+	for _, item := range projects {
+		// resolve created by uuid to username
+		createdBy := ""
+		users, err := store.QueryUsers("", item.CreatedBy, "")
+		if err == nil && len(users) > 0 {
+			createdBy = users[0].Name
+		}
+		// expose the live resource counts so automation can compare
+		// them against the quotas without listing the resources
+		_, topicsCount, _, _ := store.QueryTopics(item.UUID, "", "", "", 0, "", false)
+		_, subsCount, _, _ := store.QuerySubs(item.UUID, "", "", "", 0, "", false)
+		result.List = append(result.List, Project{UUID: item.UUID, Name: item.Name, CreatedOn: item.CreatedOn,
+			ModifiedOn: item.ModifiedOn, CreatedBy: createdBy, Description: item.Description, Tags: item.Tags,
+			MaxTopics: item.MaxTopics, MaxSubscriptions: item.MaxSubs,
+			MaxDailyMessages: item.MaxDailyMessages, MaxDailyBytes: item.MaxDailyBytes,
+			TopicsCount: topicsCount, SubscriptionsCount: subsCount})
+	}
+	return result, nil
+}
+
+// GetUUIDByName resolves a project name to its uuid
+func GetUUIDByName(name string, store stores.Store) string {
+	projects, err := store.QueryProjects("", name)
+	if err != nil || len(projects) == 0 {
+		return ""
+	}
+	return projects[0].UUID
+}
+
+// GetNameByUUID resolves a project uuid to its name
+func GetNameByUUID(uuid string, store stores.Store) string {
+	projects, err := store.QueryProjects(uuid, "")
+	if err != nil || len(projects) == 0 {
+		return ""
+	}
+	return projects[0].Name
+}
+
+// ExistsWithName checks if a project with the given name exists
+func ExistsWithName(name string, store stores.Store) bool {
+	return GetUUIDByName(name, store) != ""
+}
+
+// CreateProject creates a new project in the store
+func CreateProject(uuid string, name string, createdOn time.Time, createdBy string, description string, tags map[string]string, maxTopics int, maxSubs int, store stores.Store) (Project, error) {
+	if ExistsWithName(name, store) {
+		return Project{}, errors.New("exists")
+	}
+	p := NewProject(uuid, name, createdOn, createdBy, description, tags, maxTopics, maxSubs)
+	err := store.InsertProject(p.UUID, p.Name, p.CreatedOn, p.ModifiedOn, createdBy, p.Description, p.Tags, maxTopics, maxSubs)
+	return p, err
+}
+
+// UpdateProject updates the name, description, tags and quotas of an
+// existing project
+func UpdateProject(uuid string, name string, description string, tags map[string]string, maxTopics int, maxSubs int, modifiedOn time.Time, store stores.Store) (Project, error) {
+	if name != "" {
+		projects, err := store.QueryProjects("", name)
+		if err == nil && len(projects) > 0 && projects[0].UUID != uuid {
+			return Project{}, errors.New("exists")
+		}
+	}
+	err := store.UpdateProject(uuid, name, description, tags, maxTopics, maxSubs, modifiedOn.UTC().Format(time.RFC3339))
+	if err != nil {
+		return Project{}, err
+	}
+	result, err := Find(uuid, "", store)
+	if err != nil || result.Empty() {
+		return Project{}, errors.New("not found")
+	}
+	return result.One(), nil
+}
+
+// RemoveProject removes a project from the store
+func RemoveProject(uuid string, store stores.Store) error {
+	return store.RemoveProject(uuid)
+}
@@ -0,0 +1,186 @@
+package push
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AWSCredentials is a named aws key pair signing deliveries towards sqs
+// and sns destinations
+type AWSCredentials struct {
+	AccessKey string
+	SecretKey string
+}
+
+// AWSSender delivers push messages to aws sqs queues and sns topics
+type AWSSender interface {
+	// SendAWS delivers a payload to the sqs queue or sns topic identified
+	// by arn, signed with the named credential pair
+	SendAWS(destType string, arn string, credentials string, payload string) error
+}
+
+// ARN holds the parsed parts of an aws resource name
+type ARN struct {
+	Service  string
+	Region   string
+	Account  string
+	Resource string
+}
+
+// ParseARN splits a resource name of the form
+// arn:<partition>:<service>:<region>:<account>:<resource>
+func ParseARN(value string) (ARN, error) {
+	tokens := strings.SplitN(value, ":", 6)
+	if len(tokens) != 6 || tokens[0] != "arn" {
+		return ARN{}, errors.New("invalid arn")
+	}
+	arn := ARN{Service: tokens[2], Region: tokens[3], Account: tokens[4], Resource: tokens[5]}
+	if arn.Service == "" || arn.Region == "" || arn.Account == "" || arn.Resource == "" {
+		return ARN{}, errors.New("invalid arn")
+	}
+	return arn, nil
+}
+
+// awsAPIVersions maps a destination type to the version of its query api
+var awsAPIVersions = map[string]string{"sqs": "2012-11-05", "sns": "2010-03-31"}
+
+// HTTPAWSSender delivers push messages through the aws query apis,
+// signing every request with aws signature version 4
+type HTTPAWSSender struct {
+	Client *http.Client
+	creds  map[string]AWSCredentials
+}
+
+// NewHTTPAWSSender creates a new aws sender with a bounded request
+// timeout; creds holds the configured named credential pairs
+func NewHTTPAWSSender(timeoutSec int, creds map[string]AWSCredentials) *HTTPAWSSender {
+	return &HTTPAWSSender{
+		Client: &http.Client{Timeout: time.Duration(timeoutSec) * time.Second},
+		creds:  creds,
+	}
+}
+
+// SendAWS delivers a payload to an sqs queue or sns topic
+func (s *HTTPAWSSender) SendAWS(destType string, arn string, credentials string, payload string) error {
+	cred, ok := s.creds[credentials]
+	if !ok {
+		return errors.New("unknown aws credentials " + credentials)
+	}
+	parsed, err := ParseARN(arn)
+	if err != nil {
+		return err
+	}
+
+	endpoint := ""
+	form := url.Values{}
+	switch destType {
+	case "sqs":
+		endpoint = "https://sqs." + parsed.Region + ".amazonaws.com/" + parsed.Account + "/" + parsed.Resource
+		form.Set("Action", "SendMessage")
+		form.Set("MessageBody", payload)
+	case "sns":
+		endpoint = "https://sns." + parsed.Region + ".amazonaws.com/"
+		form.Set("Action", "Publish")
+		form.Set("TopicArn", arn)
+		form.Set("Message", payload)
+	default:
+		return errors.New("unsupported destination type " + destType)
+	}
+	form.Set("Version", awsAPIVersions[destType])
+	body := form.Encode()
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signAWSRequest(req, body, destType, parsed.Region, cred, time.Now().UTC())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(destType + " responded with " + resp.Status)
+	}
+	return nil
+}
+
+// signAWSRequest signs a query api request with aws signature version 4
+// over the host and x-amz-date headers and the form encoded body
+func signAWSRequest(req *http.Request, body string, service string, region string, cred AWSCredentials, t time.Time) {
+	amzDate := t.Format("20060102T150405Z")
+	dateStamp := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	canonicalURI := req.URL.Path
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+	payloadHash := hex.EncodeToString(sha256Sum(body))
+	canonicalRequest := strings.Join([]string{req.Method, canonicalURI, "",
+		"host:" + req.URL.Host + "\n" + "x-amz-date:" + amzDate + "\n",
+		"host;x-amz-date", payloadHash}, "\n")
+
+	scope := dateStamp + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope,
+		hex.EncodeToString(sha256Sum(canonicalRequest))}, "\n")
+
+	key := hmacSum([]byte("AWS4"+cred.SecretKey), dateStamp)
+	key = hmacSum(key, region)
+	key = hmacSum(key, service)
+	key = hmacSum(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSum(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+cred.AccessKey+"/"+scope+
+		", SignedHeaders=host;x-amz-date, Signature="+signature)
+}
+
+// sha256Sum returns the sha256 digest of a string
+func sha256Sum(data string) []byte {
+	digest := sha256.Sum256([]byte(data))
+	return digest[:]
+}
+
+// hmacSum returns the hmac-sha256 of data under key
+func hmacSum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// MockAWSSender records aws deliveries in memory, used for testing
+type MockAWSSender struct {
+	// Sent holds the payloads delivered per arn
+	Sent map[string][]string
+	// LastType holds the destination type of the latest delivery
+	LastType string
+	// LastCredentials holds the credentials name of the latest delivery
+	LastCredentials string
+	// Fail makes every delivery fail when set
+	Fail bool
+}
+
+// NewMockAWSSender creates a new mock aws sender
+func NewMockAWSSender() *MockAWSSender {
+	return &MockAWSSender{Sent: make(map[string][]string)}
+}
+
+// SendAWS records a delivery in memory
+func (s *MockAWSSender) SendAWS(destType string, arn string, credentials string, payload string) error {
+	if s.Fail {
+		return errors.New("mock send failure")
+	}
+	s.Sent[arn] = append(s.Sent[arn], payload)
+	s.LastType = destType
+	s.LastCredentials = credentials
+	return nil
+}
@@ -0,0 +1,111 @@
+package push
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Client talks to an external push server that handles deliveries
+// on behalf of the api nodes
+type Client interface {
+	ActivateSubscription(projectUUID string, name string, requestID string) error
+	DeactivateSubscription(projectUUID string, name string, requestID string) error
+	HealthCheck() error
+}
+
+// HTTPClient implements Client against the push server http api
+type HTTPClient struct {
+	Endpoint string
+	client   *http.Client
+}
+
+// activationRequest is the body of an activate/deactivate call to the push server
+type activationRequest struct {
+	ProjectUUID  string `json:"project_uuid"`
+	Subscription string `json:"subscription"`
+	// RequestID is the id of the api request behind the call, logged on
+	// both sides so api and push worker logs correlate end to end
+	RequestID string `json:"request_id"`
+}
+
+// NewHTTPClient creates a new client for an external push server
+func NewHTTPClient(endpoint string) *HTTPClient {
+	return &HTTPClient{Endpoint: endpoint, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// ActivateSubscription asks the push server to start delivering a subscription
+func (c *HTTPClient) ActivateSubscription(projectUUID string, name string, requestID string) error {
+	return c.post("/v1/activate", activationRequest{ProjectUUID: projectUUID, Subscription: name, RequestID: requestID})
+}
+
+// DeactivateSubscription asks the push server to stop delivering a subscription
+func (c *HTTPClient) DeactivateSubscription(projectUUID string, name string, requestID string) error {
+	return c.post("/v1/deactivate", activationRequest{ProjectUUID: projectUUID, Subscription: name, RequestID: requestID})
+}
+
+// HealthCheck checks the availability of the push server
+func (c *HTTPClient) HealthCheck() error {
+	resp, err := c.client.Get(c.Endpoint + "/v1/health")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("push server responded with " + resp.Status)
+	}
+	return nil
+}
+
+func (c *HTTPClient) post(path string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(c.Endpoint+path, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("push server responded with " + resp.Status)
+	}
+	return nil
+}
+
+// MockClient records activation calls in memory, used for testing
+type MockClient struct {
+	Activated     []string
+	Deactivated   []string
+	LastRequestID string
+	Healthy       bool
+}
+
+// NewMockClient creates a new mock push server client
+func NewMockClient() *MockClient {
+	return &MockClient{Healthy: true}
+}
+
+// ActivateSubscription records an activation call
+func (c *MockClient) ActivateSubscription(projectUUID string, name string, requestID string) error {
+	c.Activated = append(c.Activated, projectUUID+"/"+name)
+	c.LastRequestID = requestID
+	return nil
+}
+
+// DeactivateSubscription records a deactivation call
+func (c *MockClient) DeactivateSubscription(projectUUID string, name string, requestID string) error {
+	c.Deactivated = append(c.Deactivated, projectUUID+"/"+name)
+	c.LastRequestID = requestID
+	return nil
+}
+
+// HealthCheck reports the configured health state
+func (c *MockClient) HealthCheck() error {
+	if !c.Healthy {
+		return errors.New("push server unavailable")
+	}
+	return nil
+}
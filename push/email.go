@@ -0,0 +1,75 @@
+package push
+
+import (
+	"errors"
+	"net/smtp"
+	"strings"
+)
+
+// EmailSender delivers push messages to human recipients over email,
+// used by low-volume alarm topics that notify people directly
+type EmailSender interface {
+	SendEmail(recipients []string, subject string, body string) error
+}
+
+// SMTPSender delivers emails through a configured smtp server
+type SMTPSender struct {
+	host     string
+	from     string
+	user     string
+	password string
+}
+
+// NewSMTPSender creates a new email sender against an smtp server; user
+// may be empty for servers accepting unauthenticated submission
+func NewSMTPSender(host string, from string, user string, password string) *SMTPSender {
+	return &SMTPSender{host: host, from: from, user: user, password: password}
+}
+
+// SendEmail submits an email to the smtp server
+func (s *SMTPSender) SendEmail(recipients []string, subject string, body string) error {
+	msg := "From: " + s.from + "\r\n" +
+		"To: " + strings.Join(recipients, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body + "\r\n"
+	var auth smtp.Auth
+	if s.user != "" {
+		hostname := s.host
+		if idx := strings.LastIndex(hostname, ":"); idx != -1 {
+			hostname = hostname[:idx]
+		}
+		auth = smtp.PlainAuth("", s.user, s.password, hostname)
+	}
+	return smtp.SendMail(s.host, auth, s.from, recipients, []byte(msg))
+}
+
+// MockEmail records one email delivered through the mock sender
+type MockEmail struct {
+	Recipients []string
+	Subject    string
+	Body       string
+}
+
+// MockEmailSender records email deliveries in memory, used for testing
+type MockEmailSender struct {
+	// Sent holds the delivered emails in order
+	Sent []MockEmail
+	// Fail makes every delivery fail when set
+	Fail bool
+}
+
+// NewMockEmailSender creates a new mock email sender
+func NewMockEmailSender() *MockEmailSender {
+	return &MockEmailSender{}
+}
+
+// SendEmail records a delivery in memory
+func (s *MockEmailSender) SendEmail(recipients []string, subject string, body string) error {
+	if s.Fail {
+		return errors.New("mock send failure")
+	}
+	s.Sent = append(s.Sent, MockEmail{Recipients: recipients, Subject: subject, Body: body})
+	return nil
+}
@@ -0,0 +1,135 @@
+package push
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// ParseKafkaDest splits a kafka mirror destination of the form
+// kafka://host:port[,host:port]/topic into its broker list and topic
+func ParseKafkaDest(value string) ([]string, string, error) {
+	rest, ok := strings.CutPrefix(value, "kafka://")
+	if !ok {
+		return nil, "", errors.New("invalid kafka destination")
+	}
+	hosts, topic, ok := strings.Cut(rest, "/")
+	if !ok || hosts == "" || topic == "" {
+		return nil, "", errors.New("invalid kafka destination")
+	}
+	return strings.Split(hosts, ","), topic, nil
+}
+
+// KafkaSender republishes push messages to topics on external kafka
+// clusters, giving tenants managed topic mirroring
+type KafkaSender interface {
+	SendKafka(brokers []string, topic string, useTLS bool, saslUser string, saslPassword string, payload string) error
+}
+
+// SaramaSender mirrors push messages through sarama sync producers, one
+// per external cluster connection
+type SaramaSender struct {
+	sync.Mutex
+	timeout time.Duration
+	// producers caches one producer per broker list and connection
+	// settings, since reconnecting per delivery would be prohibitive
+	producers map[string]sarama.SyncProducer
+}
+
+// NewSaramaSender creates a new kafka mirror sender with a bounded
+// connection timeout
+func NewSaramaSender(timeoutSec int) *SaramaSender {
+	return &SaramaSender{
+		timeout:   time.Duration(timeoutSec) * time.Second,
+		producers: make(map[string]sarama.SyncProducer),
+	}
+}
+
+// producerFor returns the producer connected to an external cluster,
+// creating and caching it on first use
+func (s *SaramaSender) producerFor(brokers []string, useTLS bool, saslUser string, saslPassword string) (sarama.SyncProducer, error) {
+	key := strings.Join(brokers, ",")
+	if useTLS {
+		key += "|tls"
+	}
+	if saslUser != "" {
+		key += "|" + saslUser
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if producer, ok := s.producers[key]; ok {
+		return producer, nil
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Retry.Max = 5
+	cfg.Producer.Return.Successes = true
+	cfg.Net.DialTimeout = s.timeout
+	if useTLS {
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if saslUser != "" {
+		cfg.Net.SASL.Enable = true
+		cfg.Net.SASL.User = saslUser
+		cfg.Net.SASL.Password = saslPassword
+	}
+
+	producer, err := sarama.NewSyncProducer(brokers, cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.producers[key] = producer
+	return producer, nil
+}
+
+// SendKafka republishes a payload to a topic on an external cluster
+func (s *SaramaSender) SendKafka(brokers []string, topic string, useTLS bool, saslUser string, saslPassword string, payload string) error {
+	producer, err := s.producerFor(brokers, useTLS, saslUser, saslPassword)
+	if err != nil {
+		return err
+	}
+	kafkaMsg := &sarama.ProducerMessage{Topic: topic, Value: sarama.StringEncoder(payload)}
+	_, _, err = producer.SendMessage(kafkaMsg)
+	return err
+}
+
+// MockKafkaSender records kafka mirror deliveries in memory, used for testing
+type MockKafkaSender struct {
+	// Sent holds the payloads delivered per topic
+	Sent map[string][]string
+	// LastBrokers holds the broker list of the latest delivery
+	LastBrokers []string
+	// LastTLS holds the tls setting of the latest delivery
+	LastTLS bool
+	// LastSASLUser holds the sasl user of the latest delivery
+	LastSASLUser string
+	// LastSASLPassword holds the sasl password of the latest delivery
+	LastSASLPassword string
+	// Fail makes every delivery fail when set
+	Fail bool
+}
+
+// NewMockKafkaSender creates a new mock kafka mirror sender
+func NewMockKafkaSender() *MockKafkaSender {
+	return &MockKafkaSender{Sent: make(map[string][]string)}
+}
+
+// SendKafka records a delivery in memory
+func (s *MockKafkaSender) SendKafka(brokers []string, topic string, useTLS bool, saslUser string, saslPassword string, payload string) error {
+	if s.Fail {
+		return errors.New("mock send failure")
+	}
+	s.Sent[topic] = append(s.Sent[topic], payload)
+	s.LastBrokers = brokers
+	s.LastTLS = useTLS
+	s.LastSASLUser = saslUser
+	s.LastSASLPassword = saslPassword
+	return nil
+}
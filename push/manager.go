@@ -0,0 +1,559 @@
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/events"
+	"github.com/kaggis/argo-messaging/messages"
+	"github.com/kaggis/argo-messaging/metrics"
+	"github.com/kaggis/argo-messaging/stores"
+	"github.com/kaggis/argo-messaging/subscriptions"
+)
+
+// Manager runs a delivery loop for every push enabled subscription
+type Manager struct {
+	sync.Mutex
+	pool   brokers.Provider
+	store  stores.Store
+	sender Sender
+	// awsSender handles subscriptions pushing towards sqs and sns
+	// destinations instead of http endpoints
+	awsSender AWSSender
+	// kafkaSender handles subscriptions mirroring towards topics on
+	// external kafka clusters
+	kafkaSender KafkaSender
+	// emailSender handles subscriptions notifying human recipients
+	// over email
+	emailSender EmailSender
+	workers     map[string]worker
+}
+
+// worker tracks a running delivery loop along with the id of the api
+// request that activated it, carried in the delivery logs so they can
+// be correlated with the originating request
+type worker struct {
+	stop      chan struct{}
+	done      chan struct{}
+	requestID string
+}
+
+// drainTimeout bounds how long stopping waits for the in-flight
+// deliveries of the loops being stopped; a variable so tests can
+// exercise the give-up path without the full wait
+var drainTimeout = 30 * time.Second
+
+// awaitDone waits for a delivery loop to finish its in-flight delivery,
+// giving up at the deadline
+func awaitDone(done chan struct{}, deadline time.Time) {
+	select {
+	case <-done:
+	case <-time.After(time.Until(deadline)):
+	}
+}
+
+// pushPayload is the envelope delivered to push endpoints
+type pushPayload struct {
+	Message      messages.Message `json:"message"`
+	Subscription string           `json:"subscription"`
+}
+
+// digestPayload is the envelope carrying multiple messages per delivery,
+// used both for windowed digests and for batched deliveries
+type digestPayload struct {
+	Messages     []messages.Message `json:"messages"`
+	Count        int                `json:"count"`
+	Subscription string             `json:"subscription"`
+}
+
+// digestMaxMessages bounds the number of messages a single digest carries
+const digestMaxMessages = 100
+
+// NewManager creates a new push manager
+func NewManager(pool brokers.Provider, store stores.Store, sender Sender) *Manager {
+	return &Manager{pool: pool, store: store, sender: sender, workers: make(map[string]worker)}
+}
+
+// SetAWSSender wires the sender handling sqs and sns destinations
+func (mgr *Manager) SetAWSSender(sender AWSSender) {
+	mgr.awsSender = sender
+}
+
+// SetKafkaSender wires the sender handling kafka mirror destinations
+func (mgr *Manager) SetKafkaSender(sender KafkaSender) {
+	mgr.kafkaSender = sender
+}
+
+// SetEmailSender wires the sender handling email destinations
+func (mgr *Manager) SetEmailSender(sender EmailSender) {
+	mgr.emailSender = sender
+}
+
+// chatPayload renders the slack/mattermost webhook payload of a chat
+// destination; the template expands {data}, {attributes}, {subscription}
+// and {topic}, defaulting to the decoded message data
+func chatPayload(sub *subscriptions.Subscription, msg messages.Message) string {
+	template := sub.PushCfg.ChatTemplate
+	if template == "" {
+		template = "{data}"
+	}
+	data, err := msg.GetDecoded()
+	if err != nil {
+		data = msg.Data
+	}
+	keys := make([]string, 0, len(msg.Attrs))
+	for key := range msg.Attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, key+"="+msg.Attrs[key])
+	}
+	text := strings.ReplaceAll(template, "{data}", data)
+	text = strings.ReplaceAll(text, "{attributes}", strings.Join(attrs, ", "))
+	text = strings.ReplaceAll(text, "{subscription}", sub.FullName)
+	text = strings.ReplaceAll(text, "{topic}", sub.FullTopic)
+	encoded, _ := json.Marshal(map[string]string{"text": text})
+	return string(encoded)
+}
+
+// emailSubject expands the subject template of an email destination;
+// an empty template falls back to naming the source topic
+func emailSubject(sub *subscriptions.Subscription) string {
+	subject := sub.PushCfg.EmailSubject
+	if subject == "" {
+		subject = "New messages on " + sub.FullTopic
+	}
+	subject = strings.ReplaceAll(subject, "{subscription}", sub.FullName)
+	subject = strings.ReplaceAll(subject, "{topic}", sub.FullTopic)
+	return subject
+}
+
+// send routes a delivery through the sender matching the destination
+// type of the subscription and records the attempt in the
+// per-subscription attempt log
+func (mgr *Manager) send(sub *subscriptions.Subscription, payload string, signature string) error {
+	start := time.Now()
+	err := mgr.dispatch(sub, payload, signature)
+	attempt := stores.QPushAttempt{
+		ProjectUUID: sub.ProjectUUID,
+		Sub:         sub.Name,
+		Timestamp:   start.UTC().Format(time.RFC3339Nano),
+		LatencyMs:   time.Since(start).Milliseconds(),
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+		attempt.Error = err.Error()
+		deliveryErr := &DeliveryError{}
+		if errors.As(err, &deliveryErr) {
+			attempt.StatusCode = deliveryErr.StatusCode
+		}
+	}
+	metrics.Prom.IncCounter("ams_push_deliveries_total", "push delivery attempts by result",
+		map[string]string{"result": result}, 1)
+	mgr.store.AppendPushAttempt(attempt)
+	return err
+}
+
+// dispatch hands a delivery to the sender matching the destination type
+// of the subscription
+func (mgr *Manager) dispatch(sub *subscriptions.Subscription, payload string, signature string) error {
+	if sub.PushCfg.Type == "sqs" || sub.PushCfg.Type == "sns" {
+		if mgr.awsSender == nil {
+			return errors.New("no aws sender configured")
+		}
+		return mgr.awsSender.SendAWS(sub.PushCfg.Type, sub.PushCfg.Pend, sub.PushCfg.AWSCredentials, payload)
+	}
+	if sub.PushCfg.Type == "kafka" {
+		if mgr.kafkaSender == nil {
+			return errors.New("no kafka sender configured")
+		}
+		clusterBrokers, topic, err := ParseKafkaDest(sub.PushCfg.Pend)
+		if err != nil {
+			return err
+		}
+		return mgr.kafkaSender.SendKafka(clusterBrokers, topic, sub.PushCfg.KafkaTLS, sub.PushCfg.SASLUser, sub.PushCfg.SASLPassword, payload)
+	}
+	if sub.PushCfg.Type == "email" {
+		if mgr.emailSender == nil {
+			return errors.New("no email sender configured")
+		}
+		recipients := strings.Split(sub.PushCfg.Pend, ",")
+		return mgr.emailSender.SendEmail(recipients, emailSubject(sub), payload)
+	}
+	return mgr.sender.Send(sub.PushCfg.Pend, payload, sub.PushCfg.AuthzHeader, signature, sub.PushCfg.TLSCert)
+}
+
+// Add starts the delivery loop of a push enabled subscription; requestID
+// is the id of the api request behind the activation
+func (mgr *Manager) Add(projectUUID string, name string, requestID string) error {
+	mgr.Lock()
+	defer mgr.Unlock()
+
+	key := projectUUID + "/" + name
+	if _, ok := mgr.workers[key]; ok {
+		return errors.New("already active")
+	}
+
+	sub, err := mgr.lookup(projectUUID, name)
+	if err != nil {
+		return err
+	}
+	if sub.PushCfg.Pend == "" {
+		return errors.New("not push enabled")
+	}
+	if !sub.PushCfg.Verified {
+		return errors.New("endpoint not verified")
+	}
+	if sub.PushCfg.Paused {
+		return errors.New("push paused")
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	mgr.workers[key] = worker{stop: stop, done: done, requestID: requestID}
+	go func() {
+		defer close(done)
+		mgr.loop(projectUUID, name, requestID, stop)
+	}()
+	log.Infof("PUSH: activated push loop for %v (request %v)", sub.FullName, requestID)
+	events.Emit(events.New("push.activated", "", sub.FullName))
+	return nil
+}
+
+// Stop stops the delivery loop of a subscription, waiting for its
+// in-flight delivery to finish
+func (mgr *Manager) Stop(projectUUID string, name string, requestID string) error {
+	mgr.Lock()
+	key := projectUUID + "/" + name
+	active, ok := mgr.workers[key]
+	if !ok {
+		mgr.Unlock()
+		return errors.New("not active")
+	}
+	close(active.stop)
+	delete(mgr.workers, key)
+	mgr.Unlock()
+
+	awaitDone(active.done, time.Now().Add(drainTimeout))
+	log.Infof("PUSH: stopped push loop for %v/%v (request %v)", projectUUID, name, requestID)
+	events.Emit(events.New("push.stopped", "", projectUUID+"/"+name))
+	return nil
+}
+
+// Refresh restarts the delivery loop of a subscription to pick up new configuration
+func (mgr *Manager) Refresh(projectUUID string, name string, requestID string) error {
+	mgr.Stop(projectUUID, name, requestID)
+	return mgr.Add(projectUUID, name, requestID)
+}
+
+// IsActive checks if a subscription has an active delivery loop
+func (mgr *Manager) IsActive(projectUUID string, name string) bool {
+	mgr.Lock()
+	defer mgr.Unlock()
+	_, ok := mgr.workers[projectUUID+"/"+name]
+	return ok
+}
+
+// ProbeEndpoint checks that a push endpoint accepts deliveries by sending
+// an empty probe payload
+func (mgr *Manager) ProbeEndpoint(endpoint string, authzHeader string, tlsCert string) error {
+	return mgr.sender.Send(endpoint, "{}", authzHeader, "", tlsCert)
+}
+
+// FetchVerificationHash retrieves the ownership challenge a push endpoint
+// exposes at the well-known verification path
+func (mgr *Manager) FetchVerificationHash(endpoint string, tlsCert string) (string, error) {
+	return mgr.sender.FetchVerificationHash(endpoint, tlsCert)
+}
+
+// StartAll starts delivery loops for all push enabled subscriptions of all projects
+func (mgr *Manager) StartAll() {
+	qProjects, err := mgr.store.QueryProjects("", "")
+	if err != nil {
+		log.Errorf("PUSH: unable to list projects, %v", err)
+		return
+	}
+	for _, project := range qProjects {
+		qSubs, _, _, err := mgr.store.QuerySubs(project.UUID, "", "", "", 0, "", false)
+		if err != nil {
+			continue
+		}
+		for _, sub := range qSubs {
+			if sub.PushEndpoint != "" && sub.Verified && !sub.PushPaused {
+				mgr.Add(project.UUID, sub.Name, "startup")
+			}
+		}
+	}
+}
+
+// StopAll stops all active delivery loops and waits for their in-flight
+// deliveries to finish, so a clean shutdown leaves the persisted offsets
+// consistent and the next leader resumes without redeliveries
+func (mgr *Manager) StopAll() {
+	mgr.Lock()
+	stopping := make([]worker, 0, len(mgr.workers))
+	for key, active := range mgr.workers {
+		close(active.stop)
+		delete(mgr.workers, key)
+		stopping = append(stopping, active)
+	}
+	mgr.Unlock()
+
+	deadline := time.Now().Add(drainTimeout)
+	for _, active := range stopping {
+		awaitDone(active.done, deadline)
+	}
+	if len(stopping) > 0 {
+		log.Infof("PUSH: drained %v delivery loops", len(stopping))
+	}
+}
+
+// loop consumes and delivers messages for a subscription until stopped
+func (mgr *Manager) loop(projectUUID string, name string, requestID string, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		sub, err := mgr.lookup(projectUUID, name)
+		if err != nil || sub.PushCfg.Pend == "" {
+			return
+		}
+
+		// digest enabled subscriptions deliver one digest per window
+		// instead of individual messages
+		if sub.PushCfg.Digest {
+			window := time.Duration(sub.PushCfg.DigestWindow) * time.Second
+			if window == 0 {
+				window = time.Minute
+			}
+			mgr.deliverDigest(projectUUID, &sub, requestID)
+			select {
+			case <-stop:
+				return
+			case <-time.After(window):
+			}
+			continue
+		}
+
+		period := time.Duration(sub.PushCfg.RetPol.Period) * time.Millisecond
+		if period == 0 {
+			period = 300 * time.Millisecond
+		}
+
+		delivered := false
+		if sub.PushCfg.MaxBatchMessages > 1 {
+			delivered = mgr.deliverBatch(projectUUID, &sub, requestID)
+		} else {
+			delivered = mgr.deliverNext(projectUUID, &sub, requestID)
+		}
+		if !delivered {
+			select {
+			case <-stop:
+				return
+			case <-time.After(period):
+			}
+		}
+	}
+}
+
+// deliverNext delivers the next available message of a subscription,
+// returning true if a message was sent and acknowledged
+func (mgr *Manager) deliverNext(projectUUID string, sub *subscriptions.Subscription, requestID string) bool {
+	brk := brokers.ForProject(mgr.pool, mgr.store, projectUUID)
+	fullTopic := sub.ConsumeTopic()
+
+	msgs, err := brk.Consume(fullTopic, sub.Offset, true, 1)
+	if err != nil || len(msgs) == 0 {
+		return false
+	}
+
+	msg, err := messages.LoadMsgJSON([]byte(msgs[0]))
+	if err != nil {
+		log.Errorf("PUSH: malformed message on %v, skipping offset %v (request %v)", fullTopic, sub.Offset, requestID)
+		mgr.store.UpdateSubOffset(projectUUID, sub.Name, sub.Offset+1)
+		return true
+	}
+
+	payload := ""
+	if sub.PushCfg.Type == "kafka" {
+		// kafka destinations mirror the message as stored on the broker,
+		// so consumers of the external topic see the original payload
+		payload = msgs[0]
+	} else if sub.PushCfg.Type == "email" {
+		// email bodies carry the message data decoded for human readers
+		decoded, derr := msg.GetDecoded()
+		if derr != nil {
+			decoded = msg.Data
+		}
+		payload = decoded
+	} else if sub.PushCfg.Type == "chat" {
+		// chat destinations post the rendered webhook payload
+		payload = chatPayload(sub, msg)
+	} else if sub.PushCfg.CloudEvents {
+		payload, err = msg.ToCloudEvent(sub.FullName)
+	} else {
+		encoded, merr := json.Marshal(pushPayload{Message: msg, Subscription: sub.FullName})
+		payload, err = string(encoded), merr
+	}
+	if err != nil {
+		return false
+	}
+
+	signature := ""
+	if sub.PushCfg.SigningSecret != "" {
+		signature = signPayload(sub.PushCfg.SigningSecret, payload, time.Now().UTC())
+	}
+
+	if err := mgr.send(sub, payload, signature); err != nil {
+		log.Debugf("PUSH: delivery of %v to %v failed, %v (request %v)", sub.FullName, sub.PushCfg.Pend, err, requestID)
+		mgr.store.IncrementSubPushErrors(projectUUID, sub.Name, 1)
+		return false
+	}
+
+	mgr.store.UpdateSubOffset(projectUUID, sub.Name, sub.Offset+1)
+	mgr.store.IncrementSubPushed(projectUUID, sub.Name, 1, int64(len(msgs[0])), time.Now().UTC().Format(time.RFC3339Nano))
+
+	// track how long the message took from broker receive to webhook delivery
+	if pubTime, err := time.Parse(time.RFC3339Nano, msg.PubTime); err == nil {
+		metrics.PushLatency.Record(sub.FullName, time.Since(pubTime))
+	}
+	return true
+}
+
+// deliverDigest delivers the messages accumulated since the last digest
+// as a single envelope, returning true if a digest was sent
+func (mgr *Manager) deliverDigest(projectUUID string, sub *subscriptions.Subscription, requestID string) bool {
+	brk := brokers.ForProject(mgr.pool, mgr.store, projectUUID)
+	fullTopic := sub.ConsumeTopic()
+
+	raw, err := brk.Consume(fullTopic, sub.Offset, true, digestMaxMessages)
+	if err != nil || len(raw) == 0 {
+		return false
+	}
+
+	digest := digestPayload{Messages: []messages.Message{}, Subscription: sub.FullName}
+	totalBytes := int64(0)
+	for i, item := range raw {
+		msg, err := messages.LoadMsgJSON([]byte(item))
+		if err != nil {
+			log.Errorf("PUSH: malformed message on %v, skipping offset %v (request %v)", fullTopic, sub.Offset+int64(i), requestID)
+			continue
+		}
+		digest.Messages = append(digest.Messages, msg)
+		totalBytes += int64(len(item))
+	}
+	digest.Count = len(digest.Messages)
+
+	payload := ""
+	if sub.PushCfg.Type == "email" {
+		// email digests carry the decoded data of every message, one
+		// block per message, for human readers
+		blocks := []string{}
+		for i := range digest.Messages {
+			decoded, derr := digest.Messages[i].GetDecoded()
+			if derr != nil {
+				decoded = digest.Messages[i].Data
+			}
+			blocks = append(blocks, decoded)
+		}
+		payload = strings.Join(blocks, "\n\n")
+	} else {
+		encoded, err := json.Marshal(digest)
+		if err != nil {
+			return false
+		}
+		payload = string(encoded)
+	}
+
+	signature := ""
+	if sub.PushCfg.SigningSecret != "" {
+		signature = signPayload(sub.PushCfg.SigningSecret, payload, time.Now().UTC())
+	}
+
+	if err := mgr.send(sub, payload, signature); err != nil {
+		log.Debugf("PUSH: digest delivery of %v to %v failed, %v (request %v)", sub.FullName, sub.PushCfg.Pend, err, requestID)
+		mgr.store.IncrementSubPushErrors(projectUUID, sub.Name, 1)
+		return false
+	}
+
+	mgr.store.UpdateSubOffset(projectUUID, sub.Name, sub.Offset+int64(len(raw)))
+	mgr.store.IncrementSubPushed(projectUUID, sub.Name, int64(digest.Count), totalBytes, time.Now().UTC().Format(time.RFC3339Nano))
+	return true
+}
+
+// deliverBatch delivers up to maxBatchMessages messages as a single
+// envelope acknowledged with one offset update, returning true if a
+// batch was sent
+func (mgr *Manager) deliverBatch(projectUUID string, sub *subscriptions.Subscription, requestID string) bool {
+	brk := brokers.ForProject(mgr.pool, mgr.store, projectUUID)
+	fullTopic := sub.ConsumeTopic()
+
+	raw, err := brk.Consume(fullTopic, sub.Offset, true, int64(sub.PushCfg.MaxBatchMessages))
+	if err != nil || len(raw) == 0 {
+		return false
+	}
+
+	batch := digestPayload{Messages: []messages.Message{}, Subscription: sub.FullName}
+	consumed := 0
+	totalBytes := int64(0)
+	for i, item := range raw {
+		// the byte budget closes the batch early; the first message is
+		// always included so oversized messages still get delivered
+		if sub.PushCfg.MaxBatchBytes > 0 && consumed > 0 && totalBytes+int64(len(item)) > sub.PushCfg.MaxBatchBytes {
+			break
+		}
+		msg, err := messages.LoadMsgJSON([]byte(item))
+		if err != nil {
+			log.Errorf("PUSH: malformed message on %v, skipping offset %v (request %v)", fullTopic, sub.Offset+int64(i), requestID)
+			consumed++
+			continue
+		}
+		batch.Messages = append(batch.Messages, msg)
+		totalBytes += int64(len(item))
+		consumed++
+	}
+	batch.Count = len(batch.Messages)
+
+	encoded, err := json.Marshal(batch)
+	if err != nil {
+		return false
+	}
+	payload := string(encoded)
+
+	signature := ""
+	if sub.PushCfg.SigningSecret != "" {
+		signature = signPayload(sub.PushCfg.SigningSecret, payload, time.Now().UTC())
+	}
+
+	if err := mgr.send(sub, payload, signature); err != nil {
+		log.Debugf("PUSH: batch delivery of %v to %v failed, %v (request %v)", sub.FullName, sub.PushCfg.Pend, err, requestID)
+		mgr.store.IncrementSubPushErrors(projectUUID, sub.Name, 1)
+		return false
+	}
+
+	mgr.store.UpdateSubOffset(projectUUID, sub.Name, sub.Offset+int64(consumed))
+	mgr.store.IncrementSubPushed(projectUUID, sub.Name, int64(batch.Count), totalBytes, time.Now().UTC().Format(time.RFC3339Nano))
+	return true
+}
+
+// lookup retrieves the current subscription details from the store
+func (mgr *Manager) lookup(projectUUID string, name string) (subscriptions.Subscription, error) {
+	results, err := subscriptions.Find(projectUUID, "", name, "", 0, mgr.store)
+	if err != nil || results.Empty() {
+		return subscriptions.Subscription{}, errors.New("not found")
+	}
+	return results.One(), nil
+}
@@ -0,0 +1,110 @@
+package push
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/messages"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// blockingSender holds every delivery in flight until released, so tests
+// can observe what stopping does to a delivery that has already started
+type blockingSender struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newBlockingSender() *blockingSender {
+	return &blockingSender{entered: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+// Send signals that a delivery is in flight and blocks until released
+func (s *blockingSender) Send(endpoint string, payload string, authzHeader string, signature string, tlsCert string) error {
+	s.entered <- struct{}{}
+	<-s.release
+	return nil
+}
+
+// FetchVerificationHash is unused by the drain tests
+func (s *blockingSender) FetchVerificationHash(endpoint string, tlsCert string) (string, error) {
+	return "", nil
+}
+
+// newDrainSetup wires a manager over the fixture push subscription,
+// marked verified and with one message waiting on its topic
+func newDrainSetup(sender Sender) (*Manager, *stores.MockStore) {
+	str := stores.NewMockStore("", "")
+	for i := range str.SubList {
+		if str.SubList[i].Name == "sub4" {
+			str.SubList[i].Verified = true
+		}
+	}
+	brk := brokers.NewMockBroker()
+	brk.Publish("argo_uuid.topic4", messages.New("cGF5bG9hZA=="))
+	return NewManager(brokers.NewMockPool(brk), str, sender), str
+}
+
+type ManagerTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ManagerTestSuite) TestStopDrainsInFlightDelivery() {
+	sender := newBlockingSender()
+	mgr, str := newDrainSetup(sender)
+
+	suite.NoError(mgr.Add("argo_uuid", "sub4", "test"))
+	<-sender.entered
+
+	stopped := make(chan struct{})
+	go func() {
+		mgr.Stop("argo_uuid", "sub4", "test")
+		close(stopped)
+	}()
+
+	// stopping waits for the in-flight delivery instead of abandoning it
+	select {
+	case <-stopped:
+		suite.Fail("stop returned while a delivery was still in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(sender.release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		suite.Fail("stop did not return after the delivery finished")
+	}
+	suite.False(mgr.IsActive("argo_uuid", "sub4"))
+
+	// the drained delivery was acknowledged before the loop exited
+	subs, _, _, _ := str.QuerySubs("argo_uuid", "", "sub4", "", 0, "", false)
+	suite.Equal(int64(1), subs[0].Offset)
+}
+
+func (suite *ManagerTestSuite) TestStopAllGivesUpAtDrainTimeout() {
+	sender := newBlockingSender()
+	mgr, _ := newDrainSetup(sender)
+
+	previous := drainTimeout
+	drainTimeout = 100 * time.Millisecond
+	defer func() { drainTimeout = previous }()
+
+	suite.NoError(mgr.Add("argo_uuid", "sub4", "test"))
+	<-sender.entered
+
+	// a delivery that never finishes cannot hold the shutdown forever
+	start := time.Now()
+	mgr.StopAll()
+	suite.Less(time.Since(start), time.Second)
+	suite.False(mgr.IsActive("argo_uuid", "sub4"))
+
+	close(sender.release)
+}
+
+func TestManagerTestSuite(t *testing.T) {
+	suite.Run(t, new(ManagerTestSuite))
+}
@@ -0,0 +1,84 @@
+package push
+
+import (
+	"github.com/kaggis/argo-messaging/config"
+)
+
+// Provider is the push backend the api activates subscriptions on,
+// fronting either the in-process manager or an external push server so
+// handlers need neither reference nor the selection logic
+type Provider interface {
+	ActivateSubscription(projectUUID string, name string, requestID string) error
+	DeactivateSubscription(projectUUID string, name string, requestID string) error
+	RefreshSubscription(projectUUID string, name string, requestID string) error
+	// FetchVerificationHash retrieves the ownership challenge a push
+	// endpoint exposes at the well-known verification path
+	FetchVerificationHash(endpoint string, tlsCert string) (string, error)
+	// ProbeEndpoint checks that a push endpoint accepts deliveries
+	ProbeEndpoint(endpoint string, authzHeader string, tlsCert string) error
+	HealthCheck() error
+}
+
+// NewProvider creates the push provider of the service: activations go
+// to the external push server whenever one is configured, otherwise to
+// the in-process manager; endpoint interrogation (ownership
+// verification, probes) always runs on the api node
+func NewProvider(cfg *config.APICfg, mgr *Manager, client Client) Provider {
+	return &provider{cfg: cfg, mgr: mgr, client: client}
+}
+
+// provider routes push calls per the service configuration
+type provider struct {
+	cfg    *config.APICfg
+	mgr    *Manager
+	client Client
+}
+
+// external reports whether an external push server handles deliveries
+func (p *provider) external() bool {
+	return p.cfg.PushServerHost != ""
+}
+
+// ActivateSubscription starts the delivery of a subscription
+func (p *provider) ActivateSubscription(projectUUID string, name string, requestID string) error {
+	if p.external() {
+		return p.client.ActivateSubscription(projectUUID, name, requestID)
+	}
+	return p.mgr.Add(projectUUID, name, requestID)
+}
+
+// DeactivateSubscription stops the delivery of a subscription
+func (p *provider) DeactivateSubscription(projectUUID string, name string, requestID string) error {
+	if p.external() {
+		return p.client.DeactivateSubscription(projectUUID, name, requestID)
+	}
+	return p.mgr.Stop(projectUUID, name, requestID)
+}
+
+// RefreshSubscription restarts the delivery of a subscription to pick
+// up new configuration
+func (p *provider) RefreshSubscription(projectUUID string, name string, requestID string) error {
+	if p.external() {
+		p.client.DeactivateSubscription(projectUUID, name, requestID)
+		return p.client.ActivateSubscription(projectUUID, name, requestID)
+	}
+	return p.mgr.Refresh(projectUUID, name, requestID)
+}
+
+// FetchVerificationHash retrieves the ownership challenge of an endpoint
+func (p *provider) FetchVerificationHash(endpoint string, tlsCert string) (string, error) {
+	return p.mgr.FetchVerificationHash(endpoint, tlsCert)
+}
+
+// ProbeEndpoint checks that a push endpoint accepts deliveries
+func (p *provider) ProbeEndpoint(endpoint string, authzHeader string, tlsCert string) error {
+	return p.mgr.ProbeEndpoint(endpoint, authzHeader, tlsCert)
+}
+
+// HealthCheck checks the availability of the push backend
+func (p *provider) HealthCheck() error {
+	if p.external() {
+		return p.client.HealthCheck()
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package push
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// verificationPath is the well-known path at which a push endpoint
+// exposes its ownership challenge
+const verificationPath = "/ams_verification_hash"
+
+// signPayload computes the value of the X-AMS-Signature header: the unix
+// timestamp of the delivery and the hex encoded hmac-sha256 of
+// "<timestamp>.<payload>" under the subscription signing secret, so
+// receivers can authenticate the origin and detect replays
+func signPayload(secret string, payload string, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + payload))
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliveryError is the error of a delivery the endpoint rejected,
+// carrying the http status code for the per-subscription attempt log
+type DeliveryError struct {
+	StatusCode int
+	Msg        string
+}
+
+func (e *DeliveryError) Error() string {
+	return e.Msg
+}
+
+// Sender sends push messages to remote endpoints; authzHeader, when
+// set, is the authorization header value included on the delivery,
+// signature, when set, is the X-AMS-Signature header value and tlsCert,
+// when set, names the client certificate presented for mutual tls
+type Sender interface {
+	Send(endpoint string, payload string, authzHeader string, signature string, tlsCert string) error
+	// FetchVerificationHash retrieves the ownership challenge a push
+	// endpoint exposes at the well-known verification path
+	FetchVerificationHash(endpoint string, tlsCert string) (string, error)
+}
+
+// HTTPSender delivers push messages over http POST requests
+type HTTPSender struct {
+	Client *http.Client
+	// certDir is the directory holding the client certificate pairs
+	// presented to endpoints requiring mutual tls
+	certDir string
+	sync.Mutex
+	// clients caches one http client per client certificate, since the
+	// certificate lives in the tls config of the transport
+	clients map[string]*http.Client
+}
+
+// NewHTTPSender creates a new http sender with a bounded request
+// timeout; certDir is the directory holding the client certificate
+// pairs used for mutual tls deliveries, empty when unconfigured
+func NewHTTPSender(timeoutSec int, certDir string) *HTTPSender {
+	return &HTTPSender{
+		Client:  &http.Client{Timeout: time.Duration(timeoutSec) * time.Second},
+		certDir: certDir,
+		clients: make(map[string]*http.Client),
+	}
+}
+
+// clientFor returns the http client used for a delivery; a non-empty
+// tlsCert selects a client presenting the named certificate pair
+// (<name>.crt, <name>.key) from the configured certificate directory
+func (s *HTTPSender) clientFor(tlsCert string) (*http.Client, error) {
+	if tlsCert == "" {
+		return s.Client, nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	if client, ok := s.clients[tlsCert]; ok {
+		return client, nil
+	}
+	if s.certDir == "" {
+		return nil, errors.New("no push tls certificate directory configured")
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(s.certDir, tlsCert+".crt"),
+		filepath.Join(s.certDir, tlsCert+".key"))
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Timeout:   s.Client.Timeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{cert}}},
+	}
+	s.clients[tlsCert] = client
+	return client, nil
+}
+
+// Send delivers a payload to a remote endpoint
+func (s *HTTPSender) Send(endpoint string, payload string, authzHeader string, signature string, tlsCert string) error {
+	client, err := s.clientFor(tlsCert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBufferString(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authzHeader != "" {
+		req.Header.Set("Authorization", authzHeader)
+	}
+	if signature != "" {
+		req.Header.Set("X-AMS-Signature", signature)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &DeliveryError{StatusCode: resp.StatusCode, Msg: "endpoint responded with " + resp.Status}
+	}
+	return nil
+}
+
+// FetchVerificationHash retrieves the ownership challenge of an endpoint
+func (s *HTTPSender) FetchVerificationHash(endpoint string, tlsCert string) (string, error) {
+	client, err := s.clientFor(tlsCert)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Get(strings.TrimSuffix(endpoint, "/") + verificationPath)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", errors.New("endpoint responded with " + resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// MockSender records push deliveries in memory, used for testing
+type MockSender struct {
+	// Sent holds the payloads delivered per endpoint
+	Sent map[string][]string
+	// LastAuthz holds the authorization header of the latest delivery
+	LastAuthz string
+	// LastSignature holds the X-AMS-Signature header of the latest delivery
+	LastSignature string
+	// LastTLSCert holds the client certificate name of the latest delivery
+	LastTLSCert string
+	// VerificationHashes holds the challenge each mock endpoint exposes
+	VerificationHashes map[string]string
+	// Fail makes every delivery fail when set
+	Fail bool
+	// FailStatus is the http status code of the simulated failures, so
+	// tests can exercise the per-subscription attempt log
+	FailStatus int
+}
+
+// NewMockSender creates a new mock sender
+func NewMockSender() *MockSender {
+	return &MockSender{Sent: make(map[string][]string), VerificationHashes: make(map[string]string)}
+}
+
+// Send records a delivery in memory
+func (s *MockSender) Send(endpoint string, payload string, authzHeader string, signature string, tlsCert string) error {
+	if s.Fail {
+		if s.FailStatus != 0 {
+			return &DeliveryError{StatusCode: s.FailStatus, Msg: "endpoint responded with " + strconv.Itoa(s.FailStatus)}
+		}
+		return errors.New("mock send failure")
+	}
+	s.Sent[endpoint] = append(s.Sent[endpoint], payload)
+	s.LastAuthz = authzHeader
+	s.LastSignature = signature
+	s.LastTLSCert = tlsCert
+	return nil
+}
+
+// FetchVerificationHash returns the challenge configured for a mock endpoint
+func (s *MockSender) FetchVerificationHash(endpoint string, tlsCert string) (string, error) {
+	if hash, ok := s.VerificationHashes[endpoint]; ok {
+		return hash, nil
+	}
+	return "", errors.New("endpoint responded with 404 Not Found")
+}
@@ -0,0 +1,171 @@
+// Package replay mirrors the source topics of subscriptions requesting
+// extended retention into dedicated internal longer-retention topics, so
+// their consumers can reprocess messages the source topic already dropped.
+package replay
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/stores"
+	"github.com/kaggis/argo-messaging/subscriptions"
+)
+
+// mirrorBatch bounds the number of messages a mirror pass copies at once
+const mirrorBatch = 100
+
+// mirrorPollInterval is the idle wait between mirror passes finding no
+// new messages on the source topic
+const mirrorPollInterval = 300 * time.Millisecond
+
+// Manager runs a mirror loop for every replay enabled subscription
+type Manager struct {
+	sync.Mutex
+	pool    brokers.Provider
+	store   stores.Store
+	workers map[string]chan struct{}
+}
+
+// NewManager creates a new replay mirror manager
+func NewManager(pool brokers.Provider, store stores.Store) *Manager {
+	return &Manager{pool: pool, store: store, workers: make(map[string]chan struct{})}
+}
+
+// Add starts the mirror loop of a replay enabled subscription
+func (rm *Manager) Add(projectUUID string, name string) error {
+	rm.Lock()
+	defer rm.Unlock()
+
+	key := projectUUID + "/" + name
+	if _, ok := rm.workers[key]; ok {
+		return errors.New("already active")
+	}
+
+	stop := make(chan struct{})
+	rm.workers[key] = stop
+	go rm.loop(projectUUID, name, stop)
+	log.Infof("REPLAY: activated mirror loop for %v/%v", projectUUID, name)
+	return nil
+}
+
+// Stop stops the mirror loop of a subscription
+func (rm *Manager) Stop(projectUUID string, name string) error {
+	rm.Lock()
+	defer rm.Unlock()
+
+	key := projectUUID + "/" + name
+	stop, ok := rm.workers[key]
+	if !ok {
+		return errors.New("not active")
+	}
+	close(stop)
+	delete(rm.workers, key)
+	log.Infof("REPLAY: stopped mirror loop for %v/%v", projectUUID, name)
+	return nil
+}
+
+// IsActive checks if a subscription has an active mirror loop
+func (rm *Manager) IsActive(projectUUID string, name string) bool {
+	rm.Lock()
+	defer rm.Unlock()
+	_, ok := rm.workers[projectUUID+"/"+name]
+	return ok
+}
+
+// StartAll starts mirror loops for all replay enabled subscriptions of
+// all projects; loops already running are left untouched, so it doubles
+// as the periodic reconciliation picking up newly created subscriptions
+func (rm *Manager) StartAll() {
+	qProjects, err := rm.store.QueryProjects("", "")
+	if err != nil {
+		log.Errorf("REPLAY: unable to list projects, %v", err)
+		return
+	}
+	for _, project := range qProjects {
+		qSubs, _, _, err := rm.store.QuerySubs(project.UUID, "", "", "", 0, "", false)
+		if err != nil {
+			continue
+		}
+		for _, sub := range qSubs {
+			if sub.ReplayDays > 0 {
+				rm.Add(project.UUID, sub.Name)
+			}
+		}
+	}
+}
+
+// StopAll stops all active mirror loops
+func (rm *Manager) StopAll() {
+	rm.Lock()
+	defer rm.Unlock()
+	for key, stop := range rm.workers {
+		close(stop)
+		delete(rm.workers, key)
+	}
+}
+
+// loop copies messages from the source topic of a subscription into its
+// replay topic until stopped or the subscription stops requesting replay
+func (rm *Manager) loop(projectUUID string, name string, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		qSubs, _, _, err := rm.store.QuerySubs(projectUUID, "", name, "", 0, "", false)
+		if err != nil || len(qSubs) == 0 || qSubs[0].ReplayDays == 0 {
+			rm.Stop(projectUUID, name)
+			return
+		}
+
+		if rm.mirrorNext(projectUUID, qSubs[0]) {
+			continue
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(mirrorPollInterval):
+		}
+	}
+}
+
+// mirrorNext copies the next batch of source messages into the replay
+// topic as stored, returning true if any message was mirrored
+func (rm *Manager) mirrorNext(projectUUID string, qSub stores.QSub) bool {
+	brk := brokers.ForProject(rm.pool, rm.store, projectUUID)
+	srcTopic := projectUUID + "." + qSub.Topic
+	replayTopic := subscriptions.ReplayTopic(projectUUID, qSub.Name)
+
+	raw, err := brk.Consume(srcTopic, qSub.ReplayOffset, true, mirrorBatch)
+	if err != nil {
+		// the mirror offset fell behind the source topic retention, fast
+		// forward to the earliest available message
+		if minOffset := brk.GetMinOffset(srcTopic); qSub.ReplayOffset < minOffset {
+			rm.store.UpdateSubReplayOffset(projectUUID, qSub.Name, minOffset)
+		}
+		return false
+	}
+	if len(raw) == 0 {
+		return false
+	}
+
+	mirrored := 0
+	for _, payload := range raw {
+		if err := brk.PublishRaw(replayTopic, payload); err != nil {
+			log.Errorf("REPLAY: unable to mirror %v into %v, %v", srcTopic, replayTopic, err)
+			break
+		}
+		mirrored++
+	}
+	if mirrored == 0 {
+		return false
+	}
+	rm.store.UpdateSubReplayOffset(projectUUID, qSub.Name, qSub.ReplayOffset+int64(mirrored))
+	return true
+}
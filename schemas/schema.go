@@ -0,0 +1,140 @@
+package schemas
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// Schema holds a message schema shared by the topics of a project; any
+// number of topics may attach the same schema
+type Schema struct {
+	ProjectUUID string          `json:"-"`
+	Name        string          `json:"-"`
+	FullName    string          `json:"name"`
+	Type        string          `json:"type"`
+	RawSchema   json.RawMessage `json:"schema"`
+}
+
+// SchemaList holds the schemas of a project
+type SchemaList struct {
+	Schemas []Schema `json:"schemas"`
+}
+
+// New creates a new schema based on the project/schema combination
+func New(projectUUID string, projectName string, name string) Schema {
+	fullName := "projects/" + projectName + "/schemas/" + name
+	return Schema{ProjectUUID: projectUUID, Name: name, FullName: fullName}
+}
+
+// ExportJSON exports the schema as a json string
+func (sc *Schema) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(sc, "", "   ")
+	return string(output), err
+}
+
+// ExportJSON exports the schema list as a json string
+func (sl *SchemaList) ExportJSON() (string, error) {
+	if sl.Schemas == nil {
+		sl.Schemas = []Schema{}
+	}
+	output, err := json.MarshalIndent(sl, "", "   ")
+	return string(output), err
+}
+
+// Empty returns true if the list holds no schemas
+func (sl *SchemaList) Empty() bool {
+	return len(sl.Schemas) == 0
+}
+
+// One returns the first schema of the list
+func (sl *SchemaList) One() Schema {
+	if sl.Empty() {
+		return Schema{}
+	}
+	return sl.Schemas[0]
+}
+
+// Find returns the schemas of a project, optionally filtered by name
+func Find(projectUUID string, name string, store stores.Store) (SchemaList, error) {
+	result := SchemaList{}
+	qSchemas, err := store.QuerySchemas(projectUUID, name)
+	if err != nil {
+		return result, err
+	}
+	projectName := projectNameByUUID(projectUUID, store)
+	for _, item := range qSchemas {
+		schema := New(item.ProjectUUID, projectName, item.Name)
+		schema.Type = item.Type
+		schema.RawSchema = json.RawMessage(item.RawSchema)
+		result.Schemas = append(result.Schemas, schema)
+	}
+	return result, nil
+}
+
+// HasSchema checks if a schema exists in a project
+func HasSchema(projectUUID string, name string, store stores.Store) bool {
+	qSchemas, err := store.QuerySchemas(projectUUID, name)
+	return err == nil && len(qSchemas) > 0
+}
+
+// Create creates a new schema in the store
+func Create(projectUUID string, name string, schemaType string, rawSchema []byte, createdOn time.Time, store stores.Store) (Schema, error) {
+	if HasSchema(projectUUID, name, store) {
+		return Schema{}, errors.New("exists")
+	}
+	if schemaType != "json" && schemaType != "avro" {
+		return Schema{}, errors.New("unsupported schema type")
+	}
+	if !json.Valid(rawSchema) {
+		return Schema{}, errors.New("invalid schema")
+	}
+	err := store.InsertSchema(projectUUID, name, schemaType, string(rawSchema), createdOn.UTC().Format(time.RFC3339))
+	if err != nil {
+		return Schema{}, err
+	}
+	schema := New(projectUUID, projectNameByUUID(projectUUID, store), name)
+	schema.Type = schemaType
+	schema.RawSchema = json.RawMessage(rawSchema)
+	return schema, nil
+}
+
+// Remove removes a schema from the store; schemas still attached to a
+// topic cannot be removed
+func Remove(projectUUID string, name string, store stores.Store) error {
+	if !HasSchema(projectUUID, name, store) {
+		return errors.New("not found")
+	}
+	if used, err := UsedBy(projectUUID, name, store); err != nil {
+		return err
+	} else if len(used) > 0 {
+		return errors.New("in use")
+	}
+	return store.RemoveSchema(projectUUID, name)
+}
+
+// UsedBy returns the names of the topics a schema is attached to
+func UsedBy(projectUUID string, name string, store stores.Store) ([]string, error) {
+	qTopics, _, _, err := store.QueryTopics(projectUUID, "", "", "", 0, "", false)
+	if err != nil {
+		return nil, err
+	}
+	used := []string{}
+	for _, topic := range qTopics {
+		if topic.SchemaName == name {
+			used = append(used, topic.Name)
+		}
+	}
+	return used, nil
+}
+
+// projectNameByUUID resolves a project name from its uuid
+func projectNameByUUID(projectUUID string, store stores.Store) string {
+	qProjects, err := store.QueryProjects(projectUUID, "")
+	if err != nil || len(qProjects) == 0 {
+		return ""
+	}
+	return qProjects[0].Name
+}
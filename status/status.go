@@ -0,0 +1,85 @@
+// Package status captures the component health of the service, both for
+// the live status endpoint and for the periodic snapshots persisted for
+// post-incident reviews.
+package status
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/messages"
+	"github.com/kaggis/argo-messaging/push"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// canaryTopic is the service-owned topic the broker canary publishes to
+const canaryTopic = "_ams.canary"
+
+// Capture probes the store, the broker clusters and the push server and
+// returns a snapshot of their health; pushServerHost is empty when no
+// external push server is configured
+func Capture(t time.Time, pool brokers.Provider, str stores.Store, prv push.Provider, pushServerHost string) stores.QStatusSnapshot {
+	snapshot := stores.QStatusSnapshot{
+		CapturedOn: t.UTC().Format(time.RFC3339),
+		Status:     "ok",
+		Components: make(map[string]string),
+	}
+
+	// the store is probed with a cheap query
+	if _, err := str.QueryProjects("", ""); err != nil {
+		snapshot.Components["store"] = err.Error()
+	} else {
+		snapshot.Components["store"] = "ok"
+	}
+
+	// every broker cluster runs a canary: one message published to a
+	// service-owned topic and consumed back, exercising the full path
+	for _, cluster := range pool.Clusters() {
+		if err := canaryCheck(pool.For(cluster)); err != nil {
+			snapshot.Components["broker/"+cluster] = err.Error()
+		} else {
+			snapshot.Components["broker/"+cluster] = "ok"
+		}
+	}
+
+	if pushServerHost != "" {
+		if err := prv.HealthCheck(); err != nil {
+			snapshot.Components["push_server"] = err.Error()
+		} else {
+			snapshot.Components["push_server"] = "ok"
+		}
+	}
+
+	for _, component := range snapshot.Components {
+		if component != "ok" {
+			snapshot.Status = "degraded"
+			break
+		}
+	}
+	return snapshot
+}
+
+// canaryCheck publishes one message to the canary topic of a broker and
+// consumes it back from the returned offset
+func canaryCheck(brk brokers.Broker) error {
+	msg := messages.New(base64.StdEncoding.EncodeToString([]byte("canary")))
+	published, err := brk.Publish(canaryTopic, msg)
+	if err != nil {
+		return err
+	}
+	offset, err := strconv.ParseInt(published.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	consumed, err := brk.Consume(canaryTopic, offset, true, 1)
+	if err != nil {
+		return err
+	}
+	if len(consumed) == 0 {
+		return errors.New("canary message not consumable")
+	}
+	return nil
+}
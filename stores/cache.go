@@ -0,0 +1,182 @@
+package stores
+
+import (
+	"sync"
+	"time"
+)
+
+// CachedStore wraps a store with an in-memory ttl cache for the hot
+// authentication and acl lookups, so token and per-resource checks do
+// not hit the backend on every request. Entries expire after the ttl
+// and are invalidated explicitly when users or acls are modified.
+type CachedStore struct {
+	Store
+	ttl   time.Duration
+	mu    sync.Mutex
+	roles map[string]cachedRoles
+	acls  map[string]cachedACL
+}
+
+// cachedRoles is a cached GetUserRoles result
+type cachedRoles struct {
+	roles    []string
+	userUUID string
+	expires  time.Time
+}
+
+// cachedACL is a cached QueryACL result
+type cachedACL struct {
+	acl     QAcl
+	expires time.Time
+}
+
+// NewCachedStore wraps a store with a cache holding entries for the given ttl
+func NewCachedStore(store Store, ttl time.Duration) *CachedStore {
+	return &CachedStore{Store: store, ttl: ttl,
+		roles: make(map[string]cachedRoles), acls: make(map[string]cachedACL)}
+}
+
+// GetUserRoles returns the cached roles of a token, falling back to the
+// wrapped store on a miss
+func (cs *CachedStore) GetUserRoles(projectUUID string, token string) ([]string, string) {
+	key := projectUUID + "/" + token
+
+	cs.mu.Lock()
+	if entry, ok := cs.roles[key]; ok && time.Now().Before(entry.expires) {
+		cs.mu.Unlock()
+		return entry.roles, entry.userUUID
+	}
+	cs.mu.Unlock()
+
+	roles, userUUID := cs.Store.GetUserRoles(projectUUID, token)
+	cs.mu.Lock()
+	cs.roles[key] = cachedRoles{roles: roles, userUUID: userUUID, expires: time.Now().Add(cs.ttl)}
+	cs.mu.Unlock()
+	return roles, userUUID
+}
+
+// QueryACL returns the cached acl of a resource, falling back to the
+// wrapped store on a miss
+func (cs *CachedStore) QueryACL(projectUUID string, resourceType string, resourceName string) (QAcl, error) {
+	key := projectUUID + "/" + resourceType + "/" + resourceName
+
+	cs.mu.Lock()
+	if entry, ok := cs.acls[key]; ok && time.Now().Before(entry.expires) {
+		cs.mu.Unlock()
+		return entry.acl, nil
+	}
+	cs.mu.Unlock()
+
+	acl, err := cs.Store.QueryACL(projectUUID, resourceType, resourceName)
+	if err != nil {
+		return acl, err
+	}
+	cs.mu.Lock()
+	cs.acls[key] = cachedACL{acl: acl, expires: time.Now().Add(cs.ttl)}
+	cs.mu.Unlock()
+	return acl, nil
+}
+
+// ModACL updates an acl and drops its cached entry
+func (cs *CachedStore) ModACL(projectUUID string, resourceType string, resourceName string, acl []string, expires map[string]string) error {
+	err := cs.Store.ModACL(projectUUID, resourceType, resourceName, acl, expires)
+	cs.mu.Lock()
+	delete(cs.acls, projectUUID+"/"+resourceType+"/"+resourceName)
+	cs.mu.Unlock()
+	return err
+}
+
+// RemoveExpiredGrants sweeps expired grants and drops the cached entries
+// of the touched acls and users
+func (cs *CachedStore) RemoveExpiredGrants(now string) ([]QExpiredGrant, error) {
+	expired, err := cs.Store.RemoveExpiredGrants(now)
+	if len(expired) > 0 {
+		cs.mu.Lock()
+		for _, grant := range expired {
+			if grant.ResourceType == "project" {
+				// memberships feed the role lookups, drop them all
+				cs.roles = make(map[string]cachedRoles)
+				continue
+			}
+			delete(cs.acls, grant.ProjectUUID+"/"+grant.ResourceType+"/"+grant.ResourceName)
+		}
+		cs.mu.Unlock()
+	}
+	return expired, err
+}
+
+// RemoveTopic removes a topic and drops its cached acl
+func (cs *CachedStore) RemoveTopic(projectUUID string, name string) error {
+	err := cs.Store.RemoveTopic(projectUUID, name)
+	cs.mu.Lock()
+	delete(cs.acls, projectUUID+"/topics/"+name)
+	cs.mu.Unlock()
+	return err
+}
+
+// RemoveSub removes a subscription and drops its cached acl
+func (cs *CachedStore) RemoveSub(projectUUID string, name string) error {
+	err := cs.Store.RemoveSub(projectUUID, name)
+	cs.mu.Lock()
+	delete(cs.acls, projectUUID+"/subscriptions/"+name)
+	cs.mu.Unlock()
+	return err
+}
+
+// UpdateUser updates a user and flushes the cached roles
+func (cs *CachedStore) UpdateUser(uuid string, projects []QProjectRoles, name string, email string, serviceRoles []string, tokenExpiresOn string, modifiedOn string) error {
+	err := cs.Store.UpdateUser(uuid, projects, name, email, serviceRoles, tokenExpiresOn, modifiedOn)
+	cs.flushRoles()
+	return err
+}
+
+// UpdateUserSuspension changes the suspension state of a user and
+// flushes the cached roles
+func (cs *CachedStore) UpdateUserSuspension(uuid string, suspended bool, modifiedOn string) error {
+	err := cs.Store.UpdateUserSuspension(uuid, suspended, modifiedOn)
+	cs.flushRoles()
+	return err
+}
+
+// UpdateUserToken replaces a user token and flushes the cached roles
+func (cs *CachedStore) UpdateUserToken(uuid string, token string) error {
+	err := cs.Store.UpdateUserToken(uuid, token)
+	cs.flushRoles()
+	return err
+}
+
+// RotateUserToken rotates a user token and flushes the cached roles
+func (cs *CachedStore) RotateUserToken(uuid string, token string, previousExpiresOn string) error {
+	err := cs.Store.RotateUserToken(uuid, token, previousExpiresOn)
+	cs.flushRoles()
+	return err
+}
+
+// InsertUserToken adds a named api key and flushes the cached roles
+func (cs *CachedStore) InsertUserToken(uuid string, name string, token string, createdOn string, expiresOn string) error {
+	err := cs.Store.InsertUserToken(uuid, name, token, createdOn, expiresOn)
+	cs.flushRoles()
+	return err
+}
+
+// RemoveUserToken revokes a named api key and flushes the cached roles
+func (cs *CachedStore) RemoveUserToken(uuid string, name string) error {
+	err := cs.Store.RemoveUserToken(uuid, name)
+	cs.flushRoles()
+	return err
+}
+
+// RemoveUser removes a user and flushes the cached roles
+func (cs *CachedStore) RemoveUser(uuid string) error {
+	err := cs.Store.RemoveUser(uuid)
+	cs.flushRoles()
+	return err
+}
+
+// flushRoles drops every cached roles entry; user modifications are rare
+// enough that rebuilding the cache is cheaper than tracking tokens per user
+func (cs *CachedStore) flushRoles() {
+	cs.mu.Lock()
+	cs.roles = make(map[string]cachedRoles)
+	cs.mu.Unlock()
+}
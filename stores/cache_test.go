@@ -0,0 +1,69 @@
+package stores
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CacheTestSuite struct {
+	suite.Suite
+}
+
+func (suite *CacheTestSuite) TestGetUserRolesCached() {
+	mock := NewMockStore("", "")
+	cs := NewCachedStore(mock, time.Minute)
+
+	roles, userUUID := cs.GetUserRoles("argo_uuid", "S3CR3T1")
+	suite.Equal([]string{"consumer", "publisher"}, roles)
+	suite.Equal("uuid1", userUUID)
+
+	// the cached entry survives a token change on the wrapped store ...
+	mock.UserList[1].Token = "NEWTOKEN"
+	roles, userUUID = cs.GetUserRoles("argo_uuid", "S3CR3T1")
+	suite.Equal("uuid1", userUUID)
+
+	// ... until a user modification flushes the roles cache
+	cs.UpdateUserToken("uuid1", "NEWTOKEN")
+	_, userUUID = cs.GetUserRoles("argo_uuid", "S3CR3T1")
+	suite.Equal("", userUUID)
+}
+
+func (suite *CacheTestSuite) TestQueryACLCachedAndInvalidated() {
+	mock := NewMockStore("", "")
+	cs := NewCachedStore(mock, time.Minute)
+
+	acl, err := cs.QueryACL("argo_uuid", "topics", "topic1")
+	suite.NoError(err)
+	suite.Equal([]string{"uuid1", "uuid2"}, acl.ACL)
+
+	// a direct store change is not visible while the entry is cached
+	mock.TopicsACL["argo_uuid.topic1"] = QAcl{ACL: []string{"uuid1"}}
+	acl, _ = cs.QueryACL("argo_uuid", "topics", "topic1")
+	suite.Equal([]string{"uuid1", "uuid2"}, acl.ACL)
+
+	// modifying the acl through the cache drops the entry
+	suite.NoError(cs.ModACL("argo_uuid", "topics", "topic1", []string{"uuid2"}, nil))
+	acl, _ = cs.QueryACL("argo_uuid", "topics", "topic1")
+	suite.Equal([]string{"uuid2"}, acl.ACL)
+}
+
+func (suite *CacheTestSuite) TestEntriesExpire() {
+	mock := NewMockStore("", "")
+	cs := NewCachedStore(mock, time.Millisecond)
+
+	_, userUUID := cs.GetUserRoles("argo_uuid", "S3CR3T1")
+	suite.Equal("uuid1", userUUID)
+
+	mock.UserList[1].Token = "NEWTOKEN"
+	time.Sleep(5 * time.Millisecond)
+
+	// the expired entry falls through to the wrapped store
+	_, userUUID = cs.GetUserRoles("argo_uuid", "S3CR3T1")
+	suite.Equal("", userUUID)
+}
+
+func TestCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(CacheTestSuite))
+}
@@ -0,0 +1,869 @@
+package stores
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EmbeddedStore is a single-node store that keeps the full dataset in
+// memory and persists it as a json snapshot on disk. It is meant for
+// local development and small single-node setups where running a
+// mongodb deployment is not worth the trouble; handler behavior is
+// identical to the mongodb backend. It reuses the in-memory store
+// implementation and adds durability by writing the snapshot back
+// after every mutation.
+// Unlike the mongodb backend the in-memory maps and slices underneath
+// have no concurrency control of their own, so every delegated
+// operation takes the store mutex.
+type EmbeddedStore struct {
+	MockStore
+	path string
+	mu   sync.RWMutex
+}
+
+// embeddedSnapshot is the on-disk layout of the embedded store
+type embeddedSnapshot struct {
+	Projects     []QProject                `json:"projects"`
+	Topics       []QTopic                  `json:"topics"`
+	Schemas      []QSchema                 `json:"schemas"`
+	RoutingRules map[string][]QRoutingRule `json:"routing_rules"`
+	Subs         []QSub                    `json:"subscriptions"`
+	Users        []QUser                   `json:"users"`
+	TopicsACL    map[string]QAcl           `json:"topics_acl"`
+	SubsACL      map[string]QAcl           `json:"subscriptions_acl"`
+	DailyCounts  []QDailyMsgCount          `json:"daily_msg_counts"`
+	Migrations   []QMigration              `json:"migrations"`
+	Snapshots    []QStatusSnapshot         `json:"status_snapshots"`
+}
+
+// NewEmbeddedStore creates a new embedded store persisting to the given path
+func NewEmbeddedStore(path string) *EmbeddedStore {
+	es := EmbeddedStore{path: path}
+	es.empty()
+	return &es
+}
+
+// empty resets the in-memory dataset without loading fixtures
+func (es *EmbeddedStore) empty() {
+	es.ProjectList = []QProject{}
+	es.TopicList = []QTopic{}
+	es.SchemaList = []QSchema{}
+	es.RoutingRules = make(map[string][]QRoutingRule)
+	es.SubList = []QSub{}
+	es.UserList = []QUser{}
+	es.TopicsACL = make(map[string]QAcl)
+	es.SubsACL = make(map[string]QAcl)
+	es.DailyCounts = []QDailyMsgCount{}
+	es.Migrations = []QMigration{}
+	es.Snapshots = []QStatusSnapshot{}
+}
+
+// Initialize loads the snapshot from disk; a missing file starts empty
+func (es *EmbeddedStore) Initialize() error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	data, err := os.ReadFile(es.path)
+	if os.IsNotExist(err) {
+		log.Infof("STORE: embedded store starting empty at %v", es.path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	snapshot := embeddedSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	es.ProjectList = snapshot.Projects
+	es.TopicList = snapshot.Topics
+	es.SchemaList = snapshot.Schemas
+	if es.SchemaList == nil {
+		es.SchemaList = []QSchema{}
+	}
+	es.RoutingRules = snapshot.RoutingRules
+	if es.RoutingRules == nil {
+		es.RoutingRules = make(map[string][]QRoutingRule)
+	}
+	es.SubList = snapshot.Subs
+	es.UserList = snapshot.Users
+	es.TopicsACL = snapshot.TopicsACL
+	es.SubsACL = snapshot.SubsACL
+	es.DailyCounts = snapshot.DailyCounts
+	if es.DailyCounts == nil {
+		es.DailyCounts = []QDailyMsgCount{}
+	}
+	es.Migrations = snapshot.Migrations
+	es.Snapshots = snapshot.Snapshots
+	if es.Snapshots == nil {
+		es.Snapshots = []QStatusSnapshot{}
+	}
+	log.Infof("STORE: embedded store loaded from %v", es.path)
+	return nil
+}
+
+// Close writes the final snapshot back to disk
+func (es *EmbeddedStore) Close() {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.persist(); err != nil {
+		log.Errorf("STORE: unable to persist embedded store, %v", err)
+	}
+}
+
+// persist writes the dataset to disk atomically via a temp file rename
+func (es *EmbeddedStore) persist() error {
+	snapshot := embeddedSnapshot{
+		Projects:     es.ProjectList,
+		Topics:       es.TopicList,
+		Schemas:      es.SchemaList,
+		RoutingRules: es.RoutingRules,
+		Subs:         es.SubList,
+		Users:        es.UserList,
+		TopicsACL:    es.TopicsACL,
+		SubsACL:      es.SubsACL,
+		DailyCounts:  es.DailyCounts,
+		Migrations:   es.Migrations,
+		Snapshots:    es.Snapshots,
+	}
+	data, err := json.MarshalIndent(snapshot, "", "   ")
+	if err != nil {
+		return err
+	}
+	tmp := es.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, es.path)
+}
+
+// mutating methods delegate to the in-memory implementation and persist
+// the snapshot afterwards
+
+func (es *EmbeddedStore) InsertProject(uuid string, name string, createdOn string, modifiedOn string, createdBy string, description string, tags map[string]string, maxTopics int, maxSubs int) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.InsertProject(uuid, name, createdOn, modifiedOn, createdBy, description, tags, maxTopics, maxSubs); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateProject(uuid string, name string, description string, tags map[string]string, maxTopics int, maxSubs int, modifiedOn string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateProject(uuid, name, description, tags, maxTopics, maxSubs, modifiedOn); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) RemoveProject(uuid string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.RemoveProject(uuid); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateProjectCluster(projectUUID string, cluster string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateProjectCluster(projectUUID, cluster); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) InsertTopic(projectUUID string, name string, partitions int32, replicationFactor int16, idempotent bool, inheritProjectACL bool, routing bool, autoScaleDisabled bool, createdOn string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.InsertTopic(projectUUID, name, partitions, replicationFactor, idempotent, inheritProjectACL, routing, autoScaleDisabled, createdOn); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateTopicPartitions(projectUUID string, name string, partitions int32) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateTopicPartitions(projectUUID, name, partitions); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) ModRoutingRules(projectUUID string, topicName string, rules []QRoutingRule) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.ModRoutingRules(projectUUID, topicName, rules); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) RemoveTopic(projectUUID string, name string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.RemoveTopic(projectUUID, name); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) ModTopicSchema(projectUUID string, name string, schemaName string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.ModTopicSchema(projectUUID, name, schemaName); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) ModTopicLabels(projectUUID string, name string, labels map[string]string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.ModTopicLabels(projectUUID, name, labels); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) InsertSchema(projectUUID string, name string, schemaType string, rawSchema string, createdOn string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.InsertSchema(projectUUID, name, schemaType, rawSchema, createdOn); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) RemoveSchema(projectUUID string, name string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.RemoveSchema(projectUUID, name); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) IncrementTopicMsgNum(projectUUID string, name string, num int64) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.IncrementTopicMsgNum(projectUUID, name, num); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) IncrementTopicBytes(projectUUID string, name string, totalBytes int64) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.IncrementTopicBytes(projectUUID, name, totalBytes); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateTopicLatestPublish(projectUUID string, name string, date string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateTopicLatestPublish(projectUUID, name, date); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) IncrementDailyMsgCount(date string, projectUUID string, topicName string, num int64) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.IncrementDailyMsgCount(date, projectUUID, topicName, num); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) InsertSub(projectUUID string, name string, topic string, offset int64, ackDeadline int, pushEndpoint string, retPolicy string, retPeriod int, replayDays int, replayOffset int64, createdOn string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.InsertSub(projectUUID, name, topic, offset, ackDeadline, pushEndpoint, retPolicy, retPeriod, replayDays, replayOffset, createdOn); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) RemoveSub(projectUUID string, name string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.RemoveSub(projectUUID, name); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) ModSubPush(projectUUID string, name string, pushEndpoint string, retPolicy string, retPeriod int, cloudEvents bool, authzHeader string, digest bool, digestWindow int, maxBatchMessages int, maxBatchBytes int64, tlsCert string, pushType string, awsCredentials string, kafkaTLS bool, saslUser string, saslPassword string, emailSubject string, chatTemplate string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.ModSubPush(projectUUID, name, pushEndpoint, retPolicy, retPeriod, cloudEvents, authzHeader, digest, digestWindow, maxBatchMessages, maxBatchBytes, tlsCert, pushType, awsCredentials, kafkaTLS, saslUser, saslPassword, emailSubject, chatTemplate); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) ModSubVerification(projectUUID string, name string, verificationHash string, verified bool) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.ModSubVerification(projectUUID, name, verificationHash, verified); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) ModSubPushPaused(projectUUID string, name string, paused bool) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.ModSubPushPaused(projectUUID, name, paused); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) ModSubSigningSecret(projectUUID string, name string, signingSecret string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.ModSubSigningSecret(projectUUID, name, signingSecret); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) ModAck(projectUUID string, name string, ack int) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.ModAck(projectUUID, name, ack); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) ModSubAckDedup(projectUUID string, name string, windowSec int) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.ModSubAckDedup(projectUUID, name, windowSec); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) ModSubLabels(projectUUID string, name string, labels map[string]string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.ModSubLabels(projectUUID, name, labels); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateSubOffset(projectUUID string, name string, offset int64) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateSubOffset(projectUUID, name, offset); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateSubReplayOffset(projectUUID string, name string, offset int64) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateSubReplayOffset(projectUUID, name, offset); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateSubOffsetAck(projectUUID string, name string, offset int64, timestamp string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateSubOffsetAck(projectUUID, name, offset, timestamp); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateSubPull(projectUUID string, name string, offset int64, timestamp string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateSubPull(projectUUID, name, offset, timestamp); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateSubAckDeadline(projectUUID string, name string, deadline string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateSubAckDeadline(projectUUID, name, deadline); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) IncrementSubMsgNum(projectUUID string, name string, num int64) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.IncrementSubMsgNum(projectUUID, name, num); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) IncrementSubBytes(projectUUID string, name string, totalBytes int64) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.IncrementSubBytes(projectUUID, name, totalBytes); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) IncrementSubPushed(projectUUID string, name string, num int64, totalBytes int64, date string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.IncrementSubPushed(projectUUID, name, num, totalBytes, date); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) IncrementSubPushErrors(projectUUID string, name string, num int64) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.IncrementSubPushErrors(projectUUID, name, num); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) InsertUser(uuid string, projects []QProjectRoles, name string, token string, tokenExpiresOn string, email string, serviceRoles []string, createdOn string, modifiedOn string, createdBy string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.InsertUser(uuid, projects, name, token, tokenExpiresOn, email, serviceRoles, createdOn, modifiedOn, createdBy); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateUser(uuid string, projects []QProjectRoles, name string, email string, serviceRoles []string, tokenExpiresOn string, modifiedOn string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateUser(uuid, projects, name, email, serviceRoles, tokenExpiresOn, modifiedOn); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateUserSuspension(uuid string, suspended bool, modifiedOn string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateUserSuspension(uuid, suspended, modifiedOn); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateUserToken(uuid string, token string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateUserToken(uuid, token); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) RotateUserToken(uuid string, token string, previousExpiresOn string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.RotateUserToken(uuid, token, previousExpiresOn); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) InsertUserToken(uuid string, name string, token string, createdOn string, expiresOn string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.InsertUserToken(uuid, name, token, createdOn, expiresOn); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) RemoveUserToken(uuid string, name string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.RemoveUserToken(uuid, name); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) RemoveUser(uuid string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.RemoveUser(uuid); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) InsertTopicKey(projectUUID string, topicName string, name string, uuid string, token string, createdOn string, createdBy string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.InsertTopicKey(projectUUID, topicName, name, uuid, token, createdOn, createdBy); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) RemoveTopicKey(projectUUID string, topicName string, name string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.RemoveTopicKey(projectUUID, topicName, name); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) InsertRoleDefinition(name string, routes []string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.InsertRoleDefinition(name, routes); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateRoleDefinition(name string, routes []string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateRoleDefinition(name, routes); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) RemoveRoleDefinition(name string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.RemoveRoleDefinition(name); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) InsertServiceAccount(projectUUID string, uuid string, name string, token string, scopes []QServiceAccountScope, createdOn string, createdBy string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.InsertServiceAccount(projectUUID, uuid, name, token, scopes, createdOn, createdBy); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) UpdateServiceAccountScopes(projectUUID string, name string, scopes []QServiceAccountScope, modifiedOn string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateServiceAccountScopes(projectUUID, name, scopes, modifiedOn); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) RemoveServiceAccount(projectUUID string, name string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.RemoveServiceAccount(projectUUID, name); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) ModACL(projectUUID string, resourceType string, resourceName string, acl []string, expires map[string]string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.ModACL(projectUUID, resourceType, resourceName, acl, expires); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) RemoveExpiredGrants(now string) ([]QExpiredGrant, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	expired, err := es.MockStore.RemoveExpiredGrants(now)
+	if err != nil || len(expired) == 0 {
+		return expired, err
+	}
+	return expired, es.persist()
+}
+
+func (es *EmbeddedStore) EnsureACL(projectUUID string, resourceType string, resourceName string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.EnsureACL(projectUUID, resourceType, resourceName); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) InsertStatusSnapshot(snapshot QStatusSnapshot) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.InsertStatusSnapshot(snapshot); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+// UpdateProjectQuotas replaces the quota limits of a project and
+// persists the store
+func (es *EmbeddedStore) UpdateProjectQuotas(uuid string, maxTopics int, maxSubs int, maxDailyMessages int64, maxDailyBytes int64, modifiedOn string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.UpdateProjectQuotas(uuid, maxTopics, maxSubs, maxDailyMessages, maxDailyBytes, modifiedOn); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+// IncrementDailyProjectUsage adds publish usage to a project day and
+// persists the store
+func (es *EmbeddedStore) IncrementDailyProjectUsage(date string, projectUUID string, msgs int64, totalBytes int64) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.IncrementDailyProjectUsage(date, projectUUID, msgs, totalBytes); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+// InsertAuditLog records a mutating api call and persists the store
+func (es *EmbeddedStore) InsertAuditLog(entry QAuditLog) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.InsertAuditLog(entry); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) RemoveStatusSnapshots(before string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.RemoveStatusSnapshots(before); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+func (es *EmbeddedStore) InsertAppliedMigration(version int, description string, appliedOn string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if err := es.MockStore.InsertAppliedMigration(version, description, appliedOn); err != nil {
+		return err
+	}
+	return es.persist()
+}
+
+// the read operations promoted from the in-memory implementation take
+// the read lock so they can run concurrently with each other but never
+// against a mutation
+
+func (es *EmbeddedStore) QueryProjects(uuid string, name string) ([]QProject, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryProjects(uuid, name)
+}
+
+func (es *EmbeddedStore) QueryProjectCluster(projectUUID string) (string, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryProjectCluster(projectUUID)
+}
+
+func (es *EmbeddedStore) QueryTopics(projectUUID string, userUUID string, name string, pageToken string, pageSize int64, orderBy string, descending bool) ([]QTopic, int64, string, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryTopics(projectUUID, userUUID, name, pageToken, pageSize, orderBy, descending)
+}
+
+func (es *EmbeddedStore) HasTopic(projectUUID string, name string) bool {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.HasTopic(projectUUID, name)
+}
+
+func (es *EmbeddedStore) QueryDailyMsgCount(projectUUID string, topicName string) ([]QDailyMsgCount, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryDailyMsgCount(projectUUID, topicName)
+}
+
+func (es *EmbeddedStore) QueryRoutingRules(projectUUID string, topicName string) ([]QRoutingRule, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryRoutingRules(projectUUID, topicName)
+}
+
+func (es *EmbeddedStore) QuerySchemas(projectUUID string, name string) ([]QSchema, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QuerySchemas(projectUUID, name)
+}
+
+func (es *EmbeddedStore) QuerySubs(projectUUID string, userUUID string, name string, pageToken string, pageSize int64, orderBy string, descending bool) ([]QSub, int64, string, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QuerySubs(projectUUID, userUUID, name, pageToken, pageSize, orderBy, descending)
+}
+
+func (es *EmbeddedStore) QuerySubsByTopic(projectUUID string, topic string, pageToken string, pageSize int64) ([]QSub, int64, string, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QuerySubsByTopic(projectUUID, topic, pageToken, pageSize)
+}
+
+func (es *EmbeddedStore) HasSub(projectUUID string, name string) bool {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.HasSub(projectUUID, name)
+}
+
+func (es *EmbeddedStore) QueryLocks() ([]QLock, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryLocks()
+}
+
+func (es *EmbeddedStore) QueryPushAttempts(projectUUID string, name string) ([]QPushAttempt, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryPushAttempts(projectUUID, name)
+}
+
+func (es *EmbeddedStore) QueryUsers(projectUUID string, uuid string, name string) ([]QUser, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryUsers(projectUUID, uuid, name)
+}
+
+func (es *EmbeddedStore) FilterUsers(projectUUID string, serviceRole string, email string, namePrefix string, createdBy string, orderBy string, descending bool) ([]QUser, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.FilterUsers(projectUUID, serviceRole, email, namePrefix, createdBy, orderBy, descending)
+}
+
+func (es *EmbeddedStore) GetUserFromToken(token string) (QUser, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.GetUserFromToken(token)
+}
+
+func (es *EmbeddedStore) HasUsers(projectUUID string, users []string) (bool, []string) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.HasUsers(projectUUID, users)
+}
+
+func (es *EmbeddedStore) GetUserRoles(projectUUID string, token string) ([]string, string) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.GetUserRoles(projectUUID, token)
+}
+
+func (es *EmbeddedStore) QueryTopicKeys(projectUUID string, topicName string) ([]QTopicKey, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryTopicKeys(projectUUID, topicName)
+}
+
+func (es *EmbeddedStore) GetTopicKeyFromToken(projectUUID string, topicName string, token string) (QTopicKey, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.GetTopicKeyFromToken(projectUUID, topicName, token)
+}
+
+func (es *EmbeddedStore) QueryRoleDefinitions(name string) ([]QRoleDefinition, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryRoleDefinitions(name)
+}
+
+func (es *EmbeddedStore) QueryServiceAccounts(projectUUID string, name string) ([]QServiceAccount, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryServiceAccounts(projectUUID, name)
+}
+
+func (es *EmbeddedStore) GetServiceAccountFromToken(projectUUID string, token string) (QServiceAccount, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.GetServiceAccountFromToken(projectUUID, token)
+}
+
+func (es *EmbeddedStore) QueryACL(projectUUID string, resourceType string, resourceName string) (QAcl, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryACL(projectUUID, resourceType, resourceName)
+}
+
+func (es *EmbeddedStore) QueryAppliedMigrations() ([]QMigration, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryAppliedMigrations()
+}
+
+func (es *EmbeddedStore) QueryStatusSnapshots(since string) ([]QStatusSnapshot, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryStatusSnapshots(since)
+}
+
+func (es *EmbeddedStore) GetDailyProjectUsage(date string, projectUUID string) (QDailyProjectUsage, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.GetDailyProjectUsage(date, projectUUID)
+}
+
+func (es *EmbeddedStore) QueryAuditLogs(projectUUID string, userUUID string, action string, from string, to string) ([]QAuditLog, error) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return es.MockStore.QueryAuditLogs(projectUUID, userUUID, action, from, to)
+}
+
+// the lock table and the push attempt log mutate in-memory state that
+// is deliberately not part of the snapshot, so they take the write lock
+// without persisting
+
+func (es *EmbeddedStore) AcquireLock(name string, holder string, now string, expires string) (bool, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.MockStore.AcquireLock(name, holder, now, expires)
+}
+
+func (es *EmbeddedStore) ReleaseLock(name string, holder string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.MockStore.ReleaseLock(name, holder)
+}
+
+func (es *EmbeddedStore) AppendPushAttempt(attempt QPushAttempt) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.MockStore.AppendPushAttempt(attempt)
+}
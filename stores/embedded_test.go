@@ -0,0 +1,67 @@
+package stores
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type EmbeddedTestSuite struct {
+	suite.Suite
+}
+
+func (suite *EmbeddedTestSuite) TestStartsEmpty() {
+	path := filepath.Join(suite.T().TempDir(), "store.json")
+	es := NewEmbeddedStore(path)
+	suite.NoError(es.Initialize())
+
+	// no fixtures, no snapshot: the store starts with no data
+	projects, err := es.QueryProjects("", "")
+	suite.NoError(err)
+	suite.Equal([]QProject{}, projects)
+}
+
+func (suite *EmbeddedTestSuite) TestPersistsAcrossRestarts() {
+	path := filepath.Join(suite.T().TempDir(), "store.json")
+	es := NewEmbeddedStore(path)
+	suite.NoError(es.Initialize())
+
+	created := "2016-10-13T02:19:09Z"
+	suite.NoError(es.InsertProject("argo_uuid", "ARGO", created, created, "uuid1", "simple project", nil, 0, 0))
+	suite.NoError(es.InsertTopic("argo_uuid", "topic1", 1, 1, false, false, false, false, created))
+	suite.NoError(es.EnsureACL("argo_uuid", "topics", "topic1"))
+	suite.NoError(es.ModACL("argo_uuid", "topics", "topic1", []string{"uuid1"}, nil))
+	suite.NoError(es.IncrementTopicMsgNum("argo_uuid", "topic1", 5))
+
+	// a fresh store on the same path sees the full dataset
+	reopened := NewEmbeddedStore(path)
+	suite.NoError(reopened.Initialize())
+
+	projects, err := reopened.QueryProjects("", "ARGO")
+	suite.NoError(err)
+	suite.Equal("argo_uuid", projects[0].UUID)
+
+	topics, _, _, err := reopened.QueryTopics("argo_uuid", "", "topic1", "", 0, "", false)
+	suite.NoError(err)
+	suite.Equal(int64(5), topics[0].MsgNum)
+
+	acl, err := reopened.QueryACL("argo_uuid", "topics", "topic1")
+	suite.NoError(err)
+	suite.Equal([]string{"uuid1"}, acl.ACL)
+}
+
+func (suite *EmbeddedTestSuite) TestCloseWritesSnapshot() {
+	path := filepath.Join(suite.T().TempDir(), "store.json")
+	es := NewEmbeddedStore(path)
+	suite.NoError(es.Initialize())
+	es.Close()
+
+	_, err := os.Stat(path)
+	suite.NoError(err)
+}
+
+func TestEmbeddedTestSuite(t *testing.T) {
+	suite.Run(t, new(EmbeddedTestSuite))
+}
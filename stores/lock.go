@@ -0,0 +1,45 @@
+package stores
+
+import (
+	"time"
+
+	"github.com/kaggis/argo-messaging/metrics"
+)
+
+// DistLock is a store-backed lease handing one service instance at a
+// time the ownership of a named singleton job, such as a periodic
+// scheduler or the push delivery leader. Ownership lasts until the ttl
+// expires and is renewed by re-acquiring, so a crashed holder frees the
+// lock by simply ceasing to renew it.
+type DistLock struct {
+	store  Store
+	name   string
+	holder string
+	ttl    time.Duration
+}
+
+// NewDistLock creates a new distributed lock backed by the given store;
+// holder identifies this instance across the deployment
+func NewDistLock(store Store, name string, holder string, ttl time.Duration) *DistLock {
+	return &DistLock{store: store, name: name, holder: holder, ttl: ttl}
+}
+
+// TryAcquire acquires or renews the lock, reporting whether this
+// instance holds it; lost acquisitions feed the lock contention metric
+func (dl *DistLock) TryAcquire() bool {
+	now := time.Now().UTC()
+	acquired, err := dl.store.AcquireLock(dl.name, dl.holder,
+		now.Format(time.RFC3339), now.Add(dl.ttl).Format(time.RFC3339))
+	if err != nil {
+		return false
+	}
+	if !acquired {
+		metrics.LockContention.Record(dl.name, 1)
+	}
+	return acquired
+}
+
+// Release frees the lock so another instance may take over right away
+func (dl *DistLock) Release() {
+	dl.store.ReleaseLock(dl.name, dl.holder)
+}
@@ -0,0 +1,1448 @@
+package stores
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MockStore holds the store data in memory, used for testing
+type MockStore struct {
+	Server       string
+	Database     string
+	ProjectList  []QProject
+	TopicList    []QTopic
+	SchemaList   []QSchema
+	SubList      []QSub
+	UserList     []QUser
+	TopicsACL    map[string]QAcl
+	SubsACL      map[string]QAcl
+	RoutingRules map[string][]QRoutingRule
+	DailyCounts  []QDailyMsgCount
+	Migrations   []QMigration
+	Snapshots    []QStatusSnapshot
+	AuditLogs    []QAuditLog
+	DailyUsage   []QDailyProjectUsage
+	PushAttempts map[string][]QPushAttempt
+	Locks        map[string]QLock
+	SvcAccList   []QServiceAccount
+	RoleDefs     []QRoleDefinition
+	TopicKeys    []QTopicKey
+}
+
+// NewMockStore creates a new mock store and initializes it with fixture data
+func NewMockStore(server string, database string) *MockStore {
+	mk := MockStore{Server: server, Database: database}
+	mk.Initialize()
+	return &mk
+}
+
+// Initialize seeds the mock store with fixture data
+func (mk *MockStore) Initialize() error {
+	mk.TopicsACL = make(map[string]QAcl)
+	mk.SubsACL = make(map[string]QAcl)
+	mk.DailyCounts = []QDailyMsgCount{}
+	mk.Migrations = []QMigration{}
+	mk.SchemaList = []QSchema{}
+	mk.RoutingRules = make(map[string][]QRoutingRule)
+	mk.Snapshots = []QStatusSnapshot{}
+	mk.AuditLogs = []QAuditLog{}
+	mk.DailyUsage = []QDailyProjectUsage{}
+	mk.PushAttempts = make(map[string][]QPushAttempt)
+	mk.Locks = make(map[string]QLock)
+	mk.SvcAccList = []QServiceAccount{}
+	mk.RoleDefs = []QRoleDefinition{}
+	mk.TopicKeys = []QTopicKey{}
+
+	// populate projects
+	created := "2016-10-13T02:19:09Z"
+	mk.ProjectList = []QProject{
+		{UUID: "argo_uuid", Name: "ARGO", CreatedOn: created, ModifiedOn: created, CreatedBy: "uuid1", Description: "simple project"},
+		{UUID: "argo_uuid2", Name: "ARGO2", CreatedOn: created, ModifiedOn: created, CreatedBy: "uuid1", Description: "simple project"},
+	}
+
+	// populate topics
+	mk.TopicList = []QTopic{
+		{ProjectUUID: "argo_uuid", Name: "topic1", MsgNum: 0, CreatedOn: created},
+		{ProjectUUID: "argo_uuid", Name: "topic2", MsgNum: 0, CreatedOn: created},
+		{ProjectUUID: "argo_uuid", Name: "topic3", MsgNum: 0, CreatedOn: created},
+	}
+
+	// populate subscriptions
+	mk.SubList = []QSub{
+		{ProjectUUID: "argo_uuid", Name: "sub1", Topic: "topic1", Offset: 0, Ack: 10, CreatedOn: created},
+		{ProjectUUID: "argo_uuid", Name: "sub2", Topic: "topic2", Offset: 0, Ack: 10, CreatedOn: created},
+		{ProjectUUID: "argo_uuid", Name: "sub3", Topic: "topic3", Offset: 0, Ack: 10, CreatedOn: created},
+		{ProjectUUID: "argo_uuid", Name: "sub4", Topic: "topic4", Offset: 0, Ack: 10,
+			PushEndpoint: "endpoint.foo", RetPolicy: "linear", RetPeriod: 300, CreatedOn: created},
+	}
+
+	// populate users
+	mk.UserList = []QUser{
+		{UUID: "uuid0", Projects: []QProjectRoles{{ProjectUUID: "argo_uuid", Roles: []string{"consumer", "publisher"}}},
+			Name: "Test", Token: "S3CR3T", Email: "Test@test.com", CreatedOn: created, ModifiedOn: created},
+		{UUID: "uuid1", Projects: []QProjectRoles{{ProjectUUID: "argo_uuid", Roles: []string{"consumer", "publisher"}}},
+			Name: "UserA", Token: "S3CR3T1", Email: "foo-email", CreatedOn: created, ModifiedOn: created, CreatedBy: "uuid1"},
+		{UUID: "uuid2", Projects: []QProjectRoles{{ProjectUUID: "argo_uuid", Roles: []string{"consumer", "publisher"}}},
+			Name: "UserB", Token: "S3CR3T2", Email: "foo-email", CreatedOn: created, ModifiedOn: created, CreatedBy: "uuid1"},
+		{UUID: "uuid3", Projects: []QProjectRoles{{ProjectUUID: "argo_uuid", Roles: []string{"publisher"}}},
+			Name: "UserX", Token: "S3CR3T3", Email: "foo-email", CreatedOn: created, ModifiedOn: created, CreatedBy: "uuid1"},
+		{UUID: "uuid4", Projects: []QProjectRoles{{ProjectUUID: "argo_uuid", Roles: []string{"consumer"}}},
+			Name: "UserZ", Token: "S3CR3T4", Email: "foo-email", CreatedOn: created, ModifiedOn: created, CreatedBy: "uuid1"},
+		{UUID: "uuid7", Projects: []QProjectRoles{{ProjectUUID: "argo_uuid", Roles: []string{"project_admin"}}},
+			Name: "UserP", Token: "S3CR3T7", Email: "foo-email", CreatedOn: created, ModifiedOn: created, CreatedBy: "uuid1"},
+		{UUID: "uuid8", Projects: []QProjectRoles{}, Name: "UserAdmin", Token: "S3CR3T8",
+			Email: "foo-email", ServiceRoles: []string{"service_admin"}, CreatedOn: created, ModifiedOn: created},
+	}
+
+	// populate topic/sub ACLs
+	mk.TopicsACL["argo_uuid.topic1"] = QAcl{ACL: []string{"uuid1", "uuid2"}}
+	mk.TopicsACL["argo_uuid.topic2"] = QAcl{ACL: []string{"uuid1", "uuid2", "uuid4"}}
+	mk.TopicsACL["argo_uuid.topic3"] = QAcl{ACL: []string{"uuid3"}}
+	mk.SubsACL["argo_uuid.sub1"] = QAcl{ACL: []string{"uuid1", "uuid2"}}
+	mk.SubsACL["argo_uuid.sub2"] = QAcl{ACL: []string{"uuid1", "uuid3"}}
+	mk.SubsACL["argo_uuid.sub3"] = QAcl{ACL: []string{"uuid4", "uuid2", "uuid1"}}
+	mk.SubsACL["argo_uuid.sub4"] = QAcl{ACL: []string{"uuid2", "uuid4"}}
+
+	return nil
+}
+
+// Close is a noop for the mock store
+func (mk *MockStore) Close() {
+}
+
+// QueryProjects queries the projects by uuid or name
+func (mk *MockStore) QueryProjects(uuid string, name string) ([]QProject, error) {
+	result := []QProject{}
+	for _, project := range mk.ProjectList {
+		if uuid != "" && project.UUID != uuid {
+			continue
+		}
+		if name != "" && project.Name != name {
+			continue
+		}
+		result = append(result, project)
+	}
+	if (uuid != "" || name != "") && len(result) == 0 {
+		return result, errors.New("not found")
+	}
+	return result, nil
+}
+
+// InsertProject inserts a new project
+func (mk *MockStore) InsertProject(uuid string, name string, createdOn string, modifiedOn string, createdBy string, description string, tags map[string]string, maxTopics int, maxSubs int) error {
+	project := QProject{UUID: uuid, Name: name, CreatedOn: createdOn, ModifiedOn: modifiedOn, CreatedBy: createdBy,
+		Description: description, Tags: tags, MaxTopics: maxTopics, MaxSubs: maxSubs}
+	mk.ProjectList = append(mk.ProjectList, project)
+	return nil
+}
+
+// UpdateProject updates the name, description and tags of a project
+func (mk *MockStore) UpdateProject(uuid string, name string, description string, tags map[string]string, maxTopics int, maxSubs int, modifiedOn string) error {
+	for i := range mk.ProjectList {
+		if mk.ProjectList[i].UUID == uuid {
+			if name != "" {
+				mk.ProjectList[i].Name = name
+			}
+			if description != "" {
+				mk.ProjectList[i].Description = description
+			}
+			if tags != nil {
+				mk.ProjectList[i].Tags = tags
+			}
+			if maxTopics != 0 {
+				mk.ProjectList[i].MaxTopics = maxTopics
+			}
+			if maxSubs != 0 {
+				mk.ProjectList[i].MaxSubs = maxSubs
+			}
+			mk.ProjectList[i].ModifiedOn = modifiedOn
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// RemoveProject removes a project
+func (mk *MockStore) RemoveProject(uuid string) error {
+	for i, project := range mk.ProjectList {
+		if project.UUID == uuid {
+			mk.ProjectList = append(mk.ProjectList[:i], mk.ProjectList[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// QueryProjectCluster returns the kafka cluster assigned to a project
+func (mk *MockStore) QueryProjectCluster(projectUUID string) (string, error) {
+	for _, project := range mk.ProjectList {
+		if project.UUID == projectUUID {
+			return project.Cluster, nil
+		}
+	}
+	return "", errors.New("not found")
+}
+
+// UpdateProjectCluster assigns a kafka cluster to a project
+func (mk *MockStore) UpdateProjectCluster(projectUUID string, cluster string) error {
+	for i := range mk.ProjectList {
+		if mk.ProjectList[i].UUID == projectUUID {
+			mk.ProjectList[i].Cluster = cluster
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// QueryTopics queries the topics of a project, optionally filtered by user acl
+// membership or name, paginated with an offset based page token
+func (mk *MockStore) QueryTopics(projectUUID string, userUUID string, name string, pageToken string, pageSize int64, orderBy string, descending bool) ([]QTopic, int64, string, error) {
+	filtered := []QTopic{}
+	// iterate in reverse insertion order
+	for i := len(mk.TopicList) - 1; i >= 0; i-- {
+		topic := mk.TopicList[i]
+		if topic.ProjectUUID != projectUUID {
+			continue
+		}
+		if name != "" && topic.Name != name {
+			continue
+		}
+		if userUUID != "" && !mk.existsInACL(projectUUID, "topics", topic.Name, userUUID) {
+			continue
+		}
+		filtered = append(filtered, topic)
+	}
+	if orderBy != "" {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			left, right := qTopicSortValue(filtered[i], orderBy), qTopicSortValue(filtered[j], orderBy)
+			if descending {
+				return left > right
+			}
+			return left < right
+		})
+	}
+	return paginateTopics(filtered, pageToken, pageSize)
+}
+
+// qTopicSortValue returns the sort key of a topic for an orderBy field
+func qTopicSortValue(topic QTopic, orderBy string) string {
+	if orderBy == "created_on" {
+		return topic.CreatedOn
+	}
+	return topic.Name
+}
+
+func paginateTopics(topics []QTopic, pageToken string, pageSize int64) ([]QTopic, int64, string, error) {
+	offset, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	totalSize := int64(len(topics))
+	if offset > totalSize {
+		offset = totalSize
+	}
+	end := totalSize
+	nextPageToken := ""
+	if pageSize > 0 && offset+pageSize < totalSize {
+		end = offset + pageSize
+		nextPageToken = encodePageToken(end)
+	}
+	return topics[offset:end], totalSize, nextPageToken, nil
+}
+
+// HasTopic checks if a topic exists in a project
+func (mk *MockStore) HasTopic(projectUUID string, name string) bool {
+	for _, topic := range mk.TopicList {
+		if topic.ProjectUUID == projectUUID && topic.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertTopic inserts a new topic
+func (mk *MockStore) InsertTopic(projectUUID string, name string, partitions int32, replicationFactor int16, idempotent bool, inheritProjectACL bool, routing bool, autoScaleDisabled bool, createdOn string) error {
+	topic := QTopic{ProjectUUID: projectUUID, Name: name, Partitions: partitions, ReplicationFactor: replicationFactor, Idempotent: idempotent, InheritProjectACL: inheritProjectACL, Routing: routing, AutoScaleDisabled: autoScaleDisabled, CreatedOn: createdOn}
+	mk.TopicList = append(mk.TopicList, topic)
+	mk.TopicsACL[projectUUID+"."+name] = QAcl{ACL: []string{}}
+	return nil
+}
+
+// RemoveTopic removes a topic from the store
+func (mk *MockStore) RemoveTopic(projectUUID string, name string) error {
+	for i, topic := range mk.TopicList {
+		if topic.ProjectUUID == projectUUID && topic.Name == name {
+			mk.TopicList = append(mk.TopicList[:i], mk.TopicList[i+1:]...)
+			delete(mk.TopicsACL, projectUUID+"."+name)
+			delete(mk.RoutingRules, projectUUID+"."+name)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// IncrementTopicMsgNum increments the number of messages published to a topic
+func (mk *MockStore) IncrementTopicMsgNum(projectUUID string, name string, num int64) error {
+	for i := range mk.TopicList {
+		if mk.TopicList[i].ProjectUUID == projectUUID && mk.TopicList[i].Name == name {
+			mk.TopicList[i].MsgNum += num
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// IncrementTopicBytes increments the total bytes published to a topic
+func (mk *MockStore) IncrementTopicBytes(projectUUID string, name string, totalBytes int64) error {
+	for i := range mk.TopicList {
+		if mk.TopicList[i].ProjectUUID == projectUUID && mk.TopicList[i].Name == name {
+			mk.TopicList[i].TotalBytes += totalBytes
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// UpdateTopicPartitions updates the partition count of a topic
+func (mk *MockStore) UpdateTopicPartitions(projectUUID string, name string, partitions int32) error {
+	for i := range mk.TopicList {
+		if mk.TopicList[i].ProjectUUID == projectUUID && mk.TopicList[i].Name == name {
+			mk.TopicList[i].Partitions = partitions
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// UpdateTopicLatestPublish updates the latest publish date of a topic
+func (mk *MockStore) UpdateTopicLatestPublish(projectUUID string, name string, date string) error {
+	for i := range mk.TopicList {
+		if mk.TopicList[i].ProjectUUID == projectUUID && mk.TopicList[i].Name == name {
+			mk.TopicList[i].LatestPublish = date
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// IncrementDailyMsgCount adds to the message count of a topic for a day
+func (mk *MockStore) IncrementDailyMsgCount(date string, projectUUID string, topicName string, num int64) error {
+	for i := range mk.DailyCounts {
+		count := mk.DailyCounts[i]
+		if count.Date == date && count.ProjectUUID == projectUUID && count.TopicName == topicName {
+			mk.DailyCounts[i].MsgCount += num
+			return nil
+		}
+	}
+	mk.DailyCounts = append(mk.DailyCounts,
+		QDailyMsgCount{Date: date, ProjectUUID: projectUUID, TopicName: topicName, MsgCount: num})
+	return nil
+}
+
+// QueryDailyMsgCount returns the per-day message counts of a project,
+// optionally filtered by topic, most recent day first
+func (mk *MockStore) QueryDailyMsgCount(projectUUID string, topicName string) ([]QDailyMsgCount, error) {
+	result := []QDailyMsgCount{}
+	for _, count := range mk.DailyCounts {
+		if count.ProjectUUID != projectUUID {
+			continue
+		}
+		if topicName != "" && count.TopicName != topicName {
+			continue
+		}
+		result = append(result, count)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Date > result[j].Date })
+	return result, nil
+}
+
+// ModTopicSchema attaches a schema to a topic; an empty name detaches it
+func (mk *MockStore) ModTopicSchema(projectUUID string, name string, schemaName string) error {
+	for i := range mk.TopicList {
+		if mk.TopicList[i].ProjectUUID == projectUUID && mk.TopicList[i].Name == name {
+			mk.TopicList[i].SchemaName = schemaName
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// ModTopicLabels replaces the labels of a topic
+func (mk *MockStore) ModTopicLabels(projectUUID string, name string, labels map[string]string) error {
+	for i := range mk.TopicList {
+		if mk.TopicList[i].ProjectUUID == projectUUID && mk.TopicList[i].Name == name {
+			mk.TopicList[i].Labels = labels
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// QueryRoutingRules returns the attribute rules of a routing topic
+func (mk *MockStore) QueryRoutingRules(projectUUID string, topicName string) ([]QRoutingRule, error) {
+	if rules, ok := mk.RoutingRules[projectUUID+"."+topicName]; ok {
+		return rules, nil
+	}
+	return []QRoutingRule{}, nil
+}
+
+// ModRoutingRules replaces the attribute rules of a routing topic
+func (mk *MockStore) ModRoutingRules(projectUUID string, topicName string, rules []QRoutingRule) error {
+	mk.RoutingRules[projectUUID+"."+topicName] = rules
+	return nil
+}
+
+// QuerySchemas queries the schemas of a project, optionally filtered by name
+func (mk *MockStore) QuerySchemas(projectUUID string, name string) ([]QSchema, error) {
+	result := []QSchema{}
+	for _, schema := range mk.SchemaList {
+		if schema.ProjectUUID != projectUUID {
+			continue
+		}
+		if name != "" && schema.Name != name {
+			continue
+		}
+		result = append(result, schema)
+	}
+	return result, nil
+}
+
+// InsertSchema inserts a new schema
+func (mk *MockStore) InsertSchema(projectUUID string, name string, schemaType string, rawSchema string, createdOn string) error {
+	schema := QSchema{ProjectUUID: projectUUID, Name: name, Type: schemaType, RawSchema: rawSchema, CreatedOn: createdOn}
+	mk.SchemaList = append(mk.SchemaList, schema)
+	return nil
+}
+
+// RemoveSchema removes a schema from the store
+func (mk *MockStore) RemoveSchema(projectUUID string, name string) error {
+	for i, schema := range mk.SchemaList {
+		if schema.ProjectUUID == projectUUID && schema.Name == name {
+			mk.SchemaList = append(mk.SchemaList[:i], mk.SchemaList[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// QuerySubs queries the subscriptions of a project, optionally filtered by
+// user acl membership or name, paginated with an offset based page token
+func (mk *MockStore) QuerySubs(projectUUID string, userUUID string, name string, pageToken string, pageSize int64, orderBy string, descending bool) ([]QSub, int64, string, error) {
+	filtered := []QSub{}
+	for i := len(mk.SubList) - 1; i >= 0; i-- {
+		sub := mk.SubList[i]
+		if sub.ProjectUUID != projectUUID {
+			continue
+		}
+		if name != "" && sub.Name != name {
+			continue
+		}
+		if userUUID != "" && !mk.existsInACL(projectUUID, "subscriptions", sub.Name, userUUID) {
+			continue
+		}
+		filtered = append(filtered, sub)
+	}
+	if orderBy != "" {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			left, right := qSubSortValue(filtered[i], orderBy), qSubSortValue(filtered[j], orderBy)
+			if descending {
+				return left > right
+			}
+			return left < right
+		})
+	}
+	return paginateSubs(filtered, pageToken, pageSize)
+}
+
+// qSubSortValue returns the sort key of a subscription for an orderBy field
+func qSubSortValue(sub QSub, orderBy string) string {
+	if orderBy == "created_on" {
+		return sub.CreatedOn
+	}
+	return sub.Name
+}
+
+func paginateSubs(subs []QSub, pageToken string, pageSize int64) ([]QSub, int64, string, error) {
+	offset, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	totalSize := int64(len(subs))
+	if offset > totalSize {
+		offset = totalSize
+	}
+	end := totalSize
+	nextPageToken := ""
+	if pageSize > 0 && offset+pageSize < totalSize {
+		end = offset + pageSize
+		nextPageToken = encodePageToken(end)
+	}
+	return subs[offset:end], totalSize, nextPageToken, nil
+}
+
+// QuerySubsByTopic returns a page of the subscriptions attached to a
+// given topic
+func (mk *MockStore) QuerySubsByTopic(projectUUID string, topic string, pageToken string, pageSize int64) ([]QSub, int64, string, error) {
+	filtered := []QSub{}
+	for i := len(mk.SubList) - 1; i >= 0; i-- {
+		sub := mk.SubList[i]
+		if sub.ProjectUUID == projectUUID && sub.Topic == topic {
+			filtered = append(filtered, sub)
+		}
+	}
+	return paginateSubs(filtered, pageToken, pageSize)
+}
+
+// HasSub checks if a subscription exists in a project
+func (mk *MockStore) HasSub(projectUUID string, name string) bool {
+	for _, sub := range mk.SubList {
+		if sub.ProjectUUID == projectUUID && sub.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertSub inserts a new subscription
+func (mk *MockStore) InsertSub(projectUUID string, name string, topic string, offset int64, ackDeadline int, pushEndpoint string, retPolicy string, retPeriod int, replayDays int, replayOffset int64, createdOn string) error {
+	sub := QSub{ProjectUUID: projectUUID, Name: name, Topic: topic, Offset: offset, Ack: ackDeadline,
+		PushEndpoint: pushEndpoint, RetPolicy: retPolicy, RetPeriod: retPeriod,
+		ReplayDays: replayDays, ReplayOffset: replayOffset, CreatedOn: createdOn}
+	mk.SubList = append(mk.SubList, sub)
+	mk.SubsACL[projectUUID+"."+name] = QAcl{ACL: []string{}}
+	return nil
+}
+
+// RemoveSub removes a subscription from the store
+func (mk *MockStore) RemoveSub(projectUUID string, name string) error {
+	for i, sub := range mk.SubList {
+		if sub.ProjectUUID == projectUUID && sub.Name == name {
+			mk.SubList = append(mk.SubList[:i], mk.SubList[i+1:]...)
+			delete(mk.SubsACL, projectUUID+"."+name)
+			delete(mk.PushAttempts, projectUUID+"."+name)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// ModSubPush modifies the push configuration of a subscription
+func (mk *MockStore) ModSubPush(projectUUID string, name string, pushEndpoint string, retPolicy string, retPeriod int, cloudEvents bool, authzHeader string, digest bool, digestWindow int, maxBatchMessages int, maxBatchBytes int64, tlsCert string, pushType string, awsCredentials string, kafkaTLS bool, saslUser string, saslPassword string, emailSubject string, chatTemplate string) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].PushEndpoint = pushEndpoint
+			mk.SubList[i].RetPolicy = retPolicy
+			mk.SubList[i].RetPeriod = retPeriod
+			mk.SubList[i].CloudEvents = cloudEvents
+			mk.SubList[i].AuthzHeader = authzHeader
+			mk.SubList[i].Digest = digest
+			mk.SubList[i].DigestWindow = digestWindow
+			mk.SubList[i].MaxBatchMessages = maxBatchMessages
+			mk.SubList[i].MaxBatchBytes = maxBatchBytes
+			mk.SubList[i].TLSCert = tlsCert
+			mk.SubList[i].PushType = pushType
+			mk.SubList[i].AWSCredentials = awsCredentials
+			mk.SubList[i].KafkaTLS = kafkaTLS
+			mk.SubList[i].SASLUser = saslUser
+			mk.SubList[i].SASLPassword = saslPassword
+			mk.SubList[i].EmailSubject = emailSubject
+			mk.SubList[i].ChatTemplate = chatTemplate
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// ModSubVerification updates the endpoint ownership verification state of a subscription
+func (mk *MockStore) ModSubVerification(projectUUID string, name string, verificationHash string, verified bool) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].VerificationHash = verificationHash
+			mk.SubList[i].Verified = verified
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// ModSubPushPaused pauses or resumes the push deliveries of a subscription
+func (mk *MockStore) ModSubPushPaused(projectUUID string, name string, paused bool) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].PushPaused = paused
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// AppendPushAttempt records a push delivery attempt of a subscription,
+// keeping only the most recent pushAttemptsKept entries
+func (mk *MockStore) AppendPushAttempt(attempt QPushAttempt) error {
+	key := attempt.ProjectUUID + "." + attempt.Sub
+	attempts := append(mk.PushAttempts[key], attempt)
+	if len(attempts) > pushAttemptsKept {
+		attempts = attempts[len(attempts)-pushAttemptsKept:]
+	}
+	mk.PushAttempts[key] = attempts
+	return nil
+}
+
+// QueryPushAttempts returns the recorded push delivery attempts of a
+// subscription, most recent first
+func (mk *MockStore) QueryPushAttempts(projectUUID string, name string) ([]QPushAttempt, error) {
+	attempts := mk.PushAttempts[projectUUID+"."+name]
+	results := make([]QPushAttempt, 0, len(attempts))
+	for i := len(attempts) - 1; i >= 0; i-- {
+		results = append(results, attempts[i])
+	}
+	return results, nil
+}
+
+// AcquireLock acquires or renews a named lease; it returns false when
+// the lock is held by another holder and has not expired yet
+func (mk *MockStore) AcquireLock(name string, holder string, now string, expires string) (bool, error) {
+	if lock, ok := mk.Locks[name]; ok && lock.Holder != holder {
+		nowTime, err := time.Parse(time.RFC3339, now)
+		if err != nil {
+			return false, err
+		}
+		expiry, err := time.Parse(time.RFC3339, lock.ExpiresAt)
+		if err != nil || nowTime.Before(expiry) {
+			return false, nil
+		}
+	}
+	mk.Locks[name] = QLock{Name: name, Holder: holder, ExpiresAt: expires}
+	return true, nil
+}
+
+// ReleaseLock releases a named lease when still held by holder
+func (mk *MockStore) ReleaseLock(name string, holder string) error {
+	if lock, ok := mk.Locks[name]; ok && lock.Holder == holder {
+		delete(mk.Locks, name)
+	}
+	return nil
+}
+
+// QueryLocks returns the currently recorded singleton job leases
+func (mk *MockStore) QueryLocks() ([]QLock, error) {
+	result := []QLock{}
+	for _, lock := range mk.Locks {
+		result = append(result, lock)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// ModSubSigningSecret updates the push delivery signing secret of a subscription
+func (mk *MockStore) ModSubSigningSecret(projectUUID string, name string, signingSecret string) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].SigningSecret = signingSecret
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// ModAck modifies the ack deadline of a subscription
+func (mk *MockStore) ModAck(projectUUID string, name string, ack int) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].Ack = ack
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// ModSubAckDedup sets the ack dedup window of a subscription
+func (mk *MockStore) ModSubAckDedup(projectUUID string, name string, windowSec int) error {
+	for i, sub := range mk.SubList {
+		if sub.ProjectUUID == projectUUID && sub.Name == name {
+			mk.SubList[i].AckDedupWindowSec = windowSec
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// ModSubLabels replaces the labels of a subscription
+func (mk *MockStore) ModSubLabels(projectUUID string, name string, labels map[string]string) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].Labels = labels
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// UpdateSubOffset updates the offset of a subscription
+func (mk *MockStore) UpdateSubOffset(projectUUID string, name string, offset int64) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].Offset = offset
+			mk.SubList[i].NextOffset = 0
+			mk.SubList[i].PendingAck = ""
+			mk.SubList[i].AckDeadline = ""
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// UpdateSubReplayOffset updates the source topic offset of the replay mirror
+func (mk *MockStore) UpdateSubReplayOffset(projectUUID string, name string, offset int64) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].ReplayOffset = offset
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// UpdateSubOffsetAck updates the offset of a subscription on acknowledge
+func (mk *MockStore) UpdateSubOffsetAck(projectUUID string, name string, offset int64, timestamp string) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			if mk.SubList[i].PendingAck == "" {
+				return errors.New("no ack pending")
+			}
+			if offset <= mk.SubList[i].Offset || offset > mk.SubList[i].NextOffset {
+				return errors.New("ack timeout")
+			}
+			mk.SubList[i].Offset = offset
+			mk.SubList[i].NextOffset = 0
+			mk.SubList[i].PendingAck = ""
+			mk.SubList[i].AckDeadline = ""
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// UpdateSubPull updates the pending ack state of a subscription on pull
+func (mk *MockStore) UpdateSubPull(projectUUID string, name string, offset int64, timestamp string) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].NextOffset = offset
+			mk.SubList[i].PendingAck = timestamp
+			// the absolute deadline is persisted so recovery after a
+			// restart does not depend on the ack setting at that time
+			if pulled, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+				mk.SubList[i].AckDeadline = pulled.Add(time.Duration(mk.SubList[i].Ack) * time.Second).Format(time.RFC3339Nano)
+			}
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// UpdateSubAckDeadline pushes the in-flight ack deadline of a subscription forward
+func (mk *MockStore) UpdateSubAckDeadline(projectUUID string, name string, deadline string) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].AckDeadline = deadline
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// IncrementSubMsgNum increments the number of messages consumed by a subscription
+func (mk *MockStore) IncrementSubMsgNum(projectUUID string, name string, num int64) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].MsgNum += num
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// IncrementSubBytes increments the total bytes consumed by a subscription
+func (mk *MockStore) IncrementSubBytes(projectUUID string, name string, totalBytes int64) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].TotalBytes += totalBytes
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// IncrementSubPushed adds to the push delivery counters of a subscription
+func (mk *MockStore) IncrementSubPushed(projectUUID string, name string, num int64, totalBytes int64, date string) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].PushedMsgNum += num
+			mk.SubList[i].PushedBytes += totalBytes
+			mk.SubList[i].LatestPush = date
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// IncrementSubPushErrors adds to the failed push attempt counter of a
+// subscription
+func (mk *MockStore) IncrementSubPushErrors(projectUUID string, name string, num int64) error {
+	for i := range mk.SubList {
+		if mk.SubList[i].ProjectUUID == projectUUID && mk.SubList[i].Name == name {
+			mk.SubList[i].PushErrorNum += num
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// QueryUsers queries the users, optionally filtered by project, uuid or name
+func (mk *MockStore) QueryUsers(projectUUID string, uuid string, name string) ([]QUser, error) {
+	result := []QUser{}
+	for _, user := range mk.UserList {
+		if uuid != "" && user.UUID != uuid {
+			continue
+		}
+		if name != "" && user.Name != name {
+			continue
+		}
+		if projectUUID != "" {
+			found := false
+			for _, pr := range user.Projects {
+				if pr.ProjectUUID == projectUUID {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		result = append(result, user)
+	}
+	return result, nil
+}
+
+// GetUserFromToken returns the user holding a given token, either the
+// primary one or one of the named api keys
+func (mk *MockStore) GetUserFromToken(token string) (QUser, error) {
+	for _, user := range mk.UserList {
+		if user.Token == token {
+			return user, nil
+		}
+		for _, named := range user.Tokens {
+			if named.Token == token {
+				return user, nil
+			}
+		}
+	}
+	return QUser{}, errors.New("not found")
+}
+
+// HasUsers checks the existence of a list of usernames, returning the missing ones
+func (mk *MockStore) HasUsers(projectUUID string, users []string) (bool, []string) {
+	missing := []string{}
+	for _, username := range users {
+		found := false
+		for _, user := range mk.UserList {
+			if user.Name != username {
+				continue
+			}
+			for _, pr := range user.Projects {
+				if pr.ProjectUUID == projectUUID {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			missing = append(missing, username)
+		}
+	}
+	return len(missing) == 0, missing
+}
+
+// InsertUser inserts a new user
+func (mk *MockStore) InsertUser(uuid string, projects []QProjectRoles, name string, token string, tokenExpiresOn string, email string, serviceRoles []string, createdOn string, modifiedOn string, createdBy string) error {
+	user := QUser{UUID: uuid, Projects: projects, Name: name, Token: token, TokenExpiresOn: tokenExpiresOn, Email: email,
+		ServiceRoles: serviceRoles, CreatedOn: createdOn, ModifiedOn: modifiedOn, CreatedBy: createdBy}
+	mk.UserList = append(mk.UserList, user)
+	return nil
+}
+
+// UpdateUser updates the details of a user
+func (mk *MockStore) UpdateUser(uuid string, projects []QProjectRoles, name string, email string, serviceRoles []string, tokenExpiresOn string, modifiedOn string) error {
+	for i := range mk.UserList {
+		if mk.UserList[i].UUID == uuid {
+			if projects != nil {
+				mk.UserList[i].Projects = projects
+			}
+			if name != "" {
+				mk.UserList[i].Name = name
+			}
+			if email != "" {
+				mk.UserList[i].Email = email
+			}
+			if serviceRoles != nil {
+				mk.UserList[i].ServiceRoles = serviceRoles
+			}
+			if tokenExpiresOn != "" {
+				mk.UserList[i].TokenExpiresOn = tokenExpiresOn
+			}
+			mk.UserList[i].ModifiedOn = modifiedOn
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// UpdateUserSuspension sets the suspension state of a user
+func (mk *MockStore) UpdateUserSuspension(uuid string, suspended bool, modifiedOn string) error {
+	for i := range mk.UserList {
+		if mk.UserList[i].UUID == uuid {
+			mk.UserList[i].Suspended = suspended
+			mk.UserList[i].ModifiedOn = modifiedOn
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// UpdateUserToken updates the token of a user, dropping any expiry and
+// any previous token still within its grace period
+func (mk *MockStore) UpdateUserToken(uuid string, token string) error {
+	for i := range mk.UserList {
+		if mk.UserList[i].UUID == uuid {
+			mk.UserList[i].Token = token
+			mk.UserList[i].TokenExpiresOn = ""
+			mk.UserList[i].PreviousToken = ""
+			mk.UserList[i].PreviousTokenExpiresOn = ""
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// InsertUserToken adds a named api key to a user
+func (mk *MockStore) InsertUserToken(uuid string, name string, token string, createdOn string, expiresOn string) error {
+	for i := range mk.UserList {
+		if mk.UserList[i].UUID == uuid {
+			for _, named := range mk.UserList[i].Tokens {
+				if named.Name == name {
+					return errors.New("exists")
+				}
+			}
+			mk.UserList[i].Tokens = append(mk.UserList[i].Tokens,
+				QUserToken{Name: name, Token: token, CreatedOn: createdOn, ExpiresOn: expiresOn})
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// RemoveUserToken revokes a named api key of a user
+func (mk *MockStore) RemoveUserToken(uuid string, name string) error {
+	for i := range mk.UserList {
+		if mk.UserList[i].UUID == uuid {
+			for j, named := range mk.UserList[i].Tokens {
+				if named.Name == name {
+					mk.UserList[i].Tokens = append(mk.UserList[i].Tokens[:j], mk.UserList[i].Tokens[j+1:]...)
+					return nil
+				}
+			}
+			return errors.New("not found")
+		}
+	}
+	return errors.New("not found")
+}
+
+// RotateUserToken assigns a new token to a user, keeping the replaced one
+// valid until the given expiry
+func (mk *MockStore) RotateUserToken(uuid string, token string, previousExpiresOn string) error {
+	for i := range mk.UserList {
+		if mk.UserList[i].UUID == uuid {
+			mk.UserList[i].PreviousToken = mk.UserList[i].Token
+			mk.UserList[i].PreviousTokenExpiresOn = previousExpiresOn
+			mk.UserList[i].Token = token
+			mk.UserList[i].TokenExpiresOn = ""
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// RemoveUser removes a user
+func (mk *MockStore) RemoveUser(uuid string) error {
+	for i, user := range mk.UserList {
+		if user.UUID == uuid {
+			mk.UserList = append(mk.UserList[:i], mk.UserList[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// GetUserRoles returns the roles a token holds within a project; expired
+// tokens grant nothing, rotated-out tokens keep granting until their
+// grace period lapses
+func (mk *MockStore) GetUserRoles(projectUUID string, token string) ([]string, string) {
+	now := time.Now().UTC()
+	for _, user := range mk.UserList {
+		if user.tokenGrantsAccess(token, now) {
+			return append(user.getProjectRoles(projectUUID), user.ServiceRoles...), user.UUID
+		}
+	}
+	return []string{}, ""
+}
+
+// FilterUsers returns the users matching every given filter: project
+// membership, service role, email substring, name prefix and creator
+func (mk *MockStore) FilterUsers(projectUUID string, serviceRole string, email string, namePrefix string, createdBy string, orderBy string, descending bool) ([]QUser, error) {
+	result := []QUser{}
+	for _, user := range mk.UserList {
+		if projectUUID != "" {
+			found := false
+			for _, pr := range user.Projects {
+				if pr.ProjectUUID == projectUUID {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		if serviceRole != "" {
+			found := false
+			for _, role := range user.ServiceRoles {
+				if role == serviceRole {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		if email != "" && !strings.Contains(strings.ToLower(user.Email), strings.ToLower(email)) {
+			continue
+		}
+		if namePrefix != "" && !strings.HasPrefix(user.Name, namePrefix) {
+			continue
+		}
+		if createdBy != "" && user.CreatedBy != createdBy {
+			continue
+		}
+		result = append(result, user)
+	}
+	if orderBy != "" {
+		sort.SliceStable(result, func(i, j int) bool {
+			left, right := qUserSortValue(result[i], orderBy), qUserSortValue(result[j], orderBy)
+			if descending {
+				return left > right
+			}
+			return left < right
+		})
+	}
+	return result, nil
+}
+
+// qUserSortValue returns the sort key of a user for an orderBy field
+func qUserSortValue(user QUser, orderBy string) string {
+	switch orderBy {
+	case "created_on":
+		return user.CreatedOn
+	case "modified_on":
+		return user.ModifiedOn
+	}
+	return user.Name
+}
+
+// QueryTopicKeys returns the publish keys of a topic
+func (mk *MockStore) QueryTopicKeys(projectUUID string, topicName string) ([]QTopicKey, error) {
+	result := []QTopicKey{}
+	for _, key := range mk.TopicKeys {
+		if key.ProjectUUID == projectUUID && key.TopicName == topicName {
+			result = append(result, key)
+		}
+	}
+	return result, nil
+}
+
+// GetTopicKeyFromToken returns the publish key of a topic holding a
+// given token
+func (mk *MockStore) GetTopicKeyFromToken(projectUUID string, topicName string, token string) (QTopicKey, error) {
+	for _, key := range mk.TopicKeys {
+		if key.ProjectUUID == projectUUID && key.TopicName == topicName && key.Token == token {
+			return key, nil
+		}
+	}
+	return QTopicKey{}, errors.New("not found")
+}
+
+// InsertTopicKey inserts a new publish key for a topic
+func (mk *MockStore) InsertTopicKey(projectUUID string, topicName string, name string, uuid string, token string, createdOn string, createdBy string) error {
+	mk.TopicKeys = append(mk.TopicKeys, QTopicKey{ProjectUUID: projectUUID, TopicName: topicName,
+		Name: name, UUID: uuid, Token: token, CreatedOn: createdOn, CreatedBy: createdBy})
+	return nil
+}
+
+// RemoveTopicKey revokes a publish key of a topic
+func (mk *MockStore) RemoveTopicKey(projectUUID string, topicName string, name string) error {
+	for i, key := range mk.TopicKeys {
+		if key.ProjectUUID == projectUUID && key.TopicName == topicName && key.Name == name {
+			mk.TopicKeys = append(mk.TopicKeys[:i], mk.TopicKeys[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// QueryRoleDefinitions returns the custom role definitions, optionally
+// filtered by name
+func (mk *MockStore) QueryRoleDefinitions(name string) ([]QRoleDefinition, error) {
+	result := []QRoleDefinition{}
+	for _, def := range mk.RoleDefs {
+		if name != "" && def.Name != name {
+			continue
+		}
+		result = append(result, def)
+	}
+	return result, nil
+}
+
+// InsertRoleDefinition inserts a new custom role definition
+func (mk *MockStore) InsertRoleDefinition(name string, routes []string) error {
+	mk.RoleDefs = append(mk.RoleDefs, QRoleDefinition{Name: name, Routes: routes})
+	return nil
+}
+
+// UpdateRoleDefinition replaces the routes of a custom role definition
+func (mk *MockStore) UpdateRoleDefinition(name string, routes []string) error {
+	for i, def := range mk.RoleDefs {
+		if def.Name == name {
+			mk.RoleDefs[i].Routes = routes
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// RemoveRoleDefinition removes a custom role definition
+func (mk *MockStore) RemoveRoleDefinition(name string) error {
+	for i, def := range mk.RoleDefs {
+		if def.Name == name {
+			mk.RoleDefs = append(mk.RoleDefs[:i], mk.RoleDefs[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// QueryServiceAccounts returns the service accounts of a project,
+// optionally filtered by name
+func (mk *MockStore) QueryServiceAccounts(projectUUID string, name string) ([]QServiceAccount, error) {
+	result := []QServiceAccount{}
+	for _, sa := range mk.SvcAccList {
+		if sa.ProjectUUID != projectUUID {
+			continue
+		}
+		if name != "" && sa.Name != name {
+			continue
+		}
+		result = append(result, sa)
+	}
+	return result, nil
+}
+
+// GetServiceAccountFromToken returns the service account of a project
+// holding a given token
+func (mk *MockStore) GetServiceAccountFromToken(projectUUID string, token string) (QServiceAccount, error) {
+	for _, sa := range mk.SvcAccList {
+		if sa.ProjectUUID == projectUUID && sa.Token == token {
+			return sa, nil
+		}
+	}
+	return QServiceAccount{}, errors.New("not found")
+}
+
+// InsertServiceAccount inserts a new service account
+func (mk *MockStore) InsertServiceAccount(projectUUID string, uuid string, name string, token string, scopes []QServiceAccountScope, createdOn string, createdBy string) error {
+	mk.SvcAccList = append(mk.SvcAccList, QServiceAccount{ProjectUUID: projectUUID, UUID: uuid, Name: name,
+		Token: token, Scopes: scopes, CreatedOn: createdOn, ModifiedOn: createdOn, CreatedBy: createdBy})
+	return nil
+}
+
+// UpdateServiceAccountScopes replaces the scopes of a service account
+func (mk *MockStore) UpdateServiceAccountScopes(projectUUID string, name string, scopes []QServiceAccountScope, modifiedOn string) error {
+	for i, sa := range mk.SvcAccList {
+		if sa.ProjectUUID == projectUUID && sa.Name == name {
+			mk.SvcAccList[i].Scopes = scopes
+			mk.SvcAccList[i].ModifiedOn = modifiedOn
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// RemoveServiceAccount removes a service account
+func (mk *MockStore) RemoveServiceAccount(projectUUID string, name string) error {
+	for i, sa := range mk.SvcAccList {
+		if sa.ProjectUUID == projectUUID && sa.Name == name {
+			mk.SvcAccList = append(mk.SvcAccList[:i], mk.SvcAccList[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// QueryACL returns the acl of a resource
+func (mk *MockStore) QueryACL(projectUUID string, resourceType string, resourceName string) (QAcl, error) {
+	acls := mk.TopicsACL
+	if resourceType == "subscriptions" {
+		acls = mk.SubsACL
+	}
+	if acl, ok := acls[projectUUID+"."+resourceName]; ok {
+		return acl, nil
+	}
+	return QAcl{}, errors.New("not found")
+}
+
+// ModACL replaces the acl of a resource along with the grant expirations
+func (mk *MockStore) ModACL(projectUUID string, resourceType string, resourceName string, acl []string, expires map[string]string) error {
+	acls := mk.TopicsACL
+	if resourceType == "subscriptions" {
+		acls = mk.SubsACL
+	}
+	if _, ok := acls[projectUUID+"."+resourceName]; !ok {
+		return errors.New("not found")
+	}
+	acls[projectUUID+"."+resourceName] = QAcl{ACL: acl, Expires: expires}
+	return nil
+}
+
+// RemoveExpiredGrants drops acl entries and project memberships whose
+// expiry has passed, returning the removed grants
+func (mk *MockStore) RemoveExpiredGrants(now string) ([]QExpiredGrant, error) {
+	expired := []QExpiredGrant{}
+	nowTime, err := time.Parse(time.RFC3339, now)
+	if err != nil {
+		return expired, err
+	}
+	past := func(expiry string) bool {
+		t, err := time.Parse(time.RFC3339, expiry)
+		return err == nil && !nowTime.Before(t)
+	}
+
+	sweepACLs := func(acls map[string]QAcl, resourceType string) {
+		for key, acl := range acls {
+			kept := []string{}
+			changed := false
+			for _, userUUID := range acl.ACL {
+				if expiry, ok := acl.Expires[userUUID]; ok && past(expiry) {
+					tokens := strings.SplitN(key, ".", 2)
+					expired = append(expired, QExpiredGrant{ProjectUUID: tokens[0],
+						ResourceType: resourceType, ResourceName: tokens[1], UserUUID: userUUID})
+					delete(acl.Expires, userUUID)
+					changed = true
+					continue
+				}
+				kept = append(kept, userUUID)
+			}
+			if changed {
+				acl.ACL = kept
+				acls[key] = acl
+			}
+		}
+	}
+	sweepACLs(mk.TopicsACL, "topics")
+	sweepACLs(mk.SubsACL, "subscriptions")
+
+	for i, user := range mk.UserList {
+		kept := []QProjectRoles{}
+		changed := false
+		for _, pr := range user.Projects {
+			if pr.ExpiresOn != "" && past(pr.ExpiresOn) {
+				expired = append(expired, QExpiredGrant{ProjectUUID: pr.ProjectUUID,
+					ResourceType: "project", UserUUID: user.UUID})
+				changed = true
+				continue
+			}
+			kept = append(kept, pr)
+		}
+		if changed {
+			mk.UserList[i].Projects = kept
+		}
+	}
+
+	return expired, nil
+}
+
+// EnsureACL creates an empty acl entry for a resource if none exists
+func (mk *MockStore) EnsureACL(projectUUID string, resourceType string, resourceName string) error {
+	acls := mk.TopicsACL
+	if resourceType == "subscriptions" {
+		acls = mk.SubsACL
+	}
+	if _, ok := acls[projectUUID+"."+resourceName]; !ok {
+		acls[projectUUID+"."+resourceName] = QAcl{ACL: []string{}}
+	}
+	return nil
+}
+
+// QueryAppliedMigrations returns the schema migrations applied on the store
+func (mk *MockStore) QueryAppliedMigrations() ([]QMigration, error) {
+	return mk.Migrations, nil
+}
+
+// InsertAppliedMigration records an applied schema migration
+func (mk *MockStore) InsertAppliedMigration(version int, description string, appliedOn string) error {
+	mk.Migrations = append(mk.Migrations, QMigration{Version: version, Description: description, AppliedOn: appliedOn})
+	return nil
+}
+
+// InsertStatusSnapshot records a periodic health snapshot
+func (mk *MockStore) InsertStatusSnapshot(snapshot QStatusSnapshot) error {
+	mk.Snapshots = append(mk.Snapshots, snapshot)
+	return nil
+}
+
+// QueryStatusSnapshots returns the health snapshots captured since the
+// given utc time, newest first
+func (mk *MockStore) QueryStatusSnapshots(since string) ([]QStatusSnapshot, error) {
+	result := []QStatusSnapshot{}
+	for _, snapshot := range mk.Snapshots {
+		if snapshot.CapturedOn >= since {
+			result = append(result, snapshot)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CapturedOn > result[j].CapturedOn })
+	return result, nil
+}
+
+// RemoveStatusSnapshots removes the health snapshots captured before the
+// given utc time
+func (mk *MockStore) RemoveStatusSnapshots(before string) error {
+	kept := []QStatusSnapshot{}
+	for _, snapshot := range mk.Snapshots {
+		if snapshot.CapturedOn >= before {
+			kept = append(kept, snapshot)
+		}
+	}
+	mk.Snapshots = kept
+	return nil
+}
+
+// UpdateProjectQuotas replaces the quota limits of a project
+func (mk *MockStore) UpdateProjectQuotas(uuid string, maxTopics int, maxSubs int, maxDailyMessages int64, maxDailyBytes int64, modifiedOn string) error {
+	for i := range mk.ProjectList {
+		if mk.ProjectList[i].UUID == uuid {
+			mk.ProjectList[i].MaxTopics = maxTopics
+			mk.ProjectList[i].MaxSubs = maxSubs
+			mk.ProjectList[i].MaxDailyMessages = maxDailyMessages
+			mk.ProjectList[i].MaxDailyBytes = maxDailyBytes
+			mk.ProjectList[i].ModifiedOn = modifiedOn
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+// IncrementDailyProjectUsage adds published messages and bytes to the
+// usage of a project on the given day
+func (mk *MockStore) IncrementDailyProjectUsage(date string, projectUUID string, msgs int64, totalBytes int64) error {
+	for i := range mk.DailyUsage {
+		if mk.DailyUsage[i].Date == date && mk.DailyUsage[i].ProjectUUID == projectUUID {
+			mk.DailyUsage[i].MsgCount += msgs
+			mk.DailyUsage[i].BytesCount += totalBytes
+			return nil
+		}
+	}
+	mk.DailyUsage = append(mk.DailyUsage,
+		QDailyProjectUsage{Date: date, ProjectUUID: projectUUID, MsgCount: msgs, BytesCount: totalBytes})
+	return nil
+}
+
+// GetDailyProjectUsage returns the publish usage of a project on the
+// given day; a day without usage reads as zero
+func (mk *MockStore) GetDailyProjectUsage(date string, projectUUID string) (QDailyProjectUsage, error) {
+	for _, usage := range mk.DailyUsage {
+		if usage.Date == date && usage.ProjectUUID == projectUUID {
+			return usage, nil
+		}
+	}
+	return QDailyProjectUsage{Date: date, ProjectUUID: projectUUID}, nil
+}
+
+// InsertAuditLog records a mutating api call
+func (mk *MockStore) InsertAuditLog(entry QAuditLog) error {
+	mk.AuditLogs = append(mk.AuditLogs, entry)
+	return nil
+}
+
+// QueryAuditLogs returns the recorded api calls matching the given
+// filters, newest first; empty filters match everything
+func (mk *MockStore) QueryAuditLogs(projectUUID string, userUUID string, action string, from string, to string) ([]QAuditLog, error) {
+	result := []QAuditLog{}
+	for _, entry := range mk.AuditLogs {
+		if projectUUID != "" && entry.ProjectUUID != projectUUID {
+			continue
+		}
+		if userUUID != "" && entry.UserUUID != userUUID {
+			continue
+		}
+		if action != "" && entry.Action != action {
+			continue
+		}
+		if from != "" && entry.Timestamp < from {
+			continue
+		}
+		if to != "" && entry.Timestamp > to {
+			continue
+		}
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Timestamp > result[j].Timestamp })
+	return result, nil
+}
+
+// existsInACL checks if a user uuid is in the acl of a resource
+func (mk *MockStore) existsInACL(projectUUID string, resourceType string, resourceName string, userUUID string) bool {
+	acl, err := mk.QueryACL(projectUUID, resourceType, resourceName)
+	if err != nil {
+		return false
+	}
+	for _, uuid := range acl.ACL {
+		if uuid == userUUID {
+			return true
+		}
+	}
+	return false
+}
+
+// encodePageToken encodes an offset as an opaque page token
+func encodePageToken(offset int64) string {
+	return base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(offset, 10)))
+}
+
+// decodePageToken decodes an opaque page token to an offset
+func decodePageToken(pageToken string) (int64, error) {
+	if pageToken == "" {
+		return 0, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(pageToken)
+	if err != nil {
+		return 0, errors.New("invalid page token")
+	}
+	offset, err := strconv.ParseInt(string(decoded), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, errors.New("invalid page token")
+	}
+	return offset, nil
+}
@@ -0,0 +1,1276 @@
+package stores
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore holds a connection to a mongodb backend
+type MongoStore struct {
+	// Server is a plain host[:port] or a full mongodb:// / mongodb+srv://
+	// connection string carrying credentials, replica set, read preference
+	// and tls options
+	Server   string
+	Database string
+	Client   *mongo.Client
+	db       *mongo.Database
+}
+
+// mongoOpTimeout bounds the duration of a single store operation
+const mongoOpTimeout = 10 * time.Second
+
+// NewMongoStore creates a new mongo store
+func NewMongoStore(server string, database string) *MongoStore {
+	return &MongoStore{Server: server, Database: database}
+}
+
+// Initialize connects to the mongodb backend
+func (mg *MongoStore) Initialize() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mg.connectionURI()))
+	if err != nil {
+		return err
+	}
+	mg.Client = client
+	mg.db = client.Database(mg.Database)
+	// the unique index arbitrates concurrent lock acquisitions
+	mg.db.Collection("locks").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"name": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	// the user filters select on these fields
+	mg.db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.M{"name": 1}})
+	mg.db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.M{"projects.project_uuid": 1}})
+	mg.db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.M{"service_roles": 1}})
+	// the keyset-paginated listings scan by project and document id, or
+	// by project and the requested sort field
+	mg.db.Collection("topics").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "project_uuid", Value: 1}, {Key: "_id", Value: -1}}})
+	mg.db.Collection("subscriptions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "project_uuid", Value: 1}, {Key: "_id", Value: -1}}})
+	for _, field := range []string{"name", "created_on"} {
+		mg.db.Collection("topics").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "project_uuid", Value: 1}, {Key: field, Value: 1}}})
+		mg.db.Collection("subscriptions").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "project_uuid", Value: 1}, {Key: field, Value: 1}}})
+	}
+	mg.db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.M{"created_on": 1}})
+	mg.db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.M{"modified_on": 1}})
+	mg.db.Collection("subscriptions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "project_uuid", Value: 1}, {Key: "topic", Value: 1}, {Key: "_id", Value: -1}}})
+	log.Infof("STORE: connected to mongodb store %v", redactCredentials(mg.Server))
+	return nil
+}
+
+// connectionURI returns the connection string handed to the mongo driver.
+// Full mongodb:// and mongodb+srv:// uris pass through untouched so that
+// authenticated, replica set and srv based deployments are configured
+// entirely from store_host; bare host[:port] values keep working as before.
+func (mg *MongoStore) connectionURI() string {
+	if strings.HasPrefix(mg.Server, "mongodb://") || strings.HasPrefix(mg.Server, "mongodb+srv://") {
+		return mg.Server
+	}
+	return "mongodb://" + mg.Server
+}
+
+// redactCredentials strips the userinfo part of a connection string so
+// credentials never reach the logs
+func redactCredentials(server string) string {
+	at := strings.LastIndex(server, "@")
+	if at == -1 {
+		return server
+	}
+	scheme := ""
+	if idx := strings.Index(server, "://"); idx != -1 {
+		scheme = server[:idx+3]
+	}
+	return scheme + server[at+1:]
+}
+
+// Close closes the connection to the mongodb backend
+func (mg *MongoStore) Close() {
+	if mg.Client != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), mongoOpTimeout)
+		defer cancel()
+		mg.Client.Disconnect(ctx)
+	}
+}
+
+func opCtx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), mongoOpTimeout)
+}
+
+// QueryProjects queries the projects by uuid or name
+func (mg *MongoStore) QueryProjects(uuid string, name string) ([]QProject, error) {
+	query := bson.M{}
+	if uuid != "" {
+		query["uuid"] = uuid
+	}
+	if name != "" {
+		query["name"] = name
+	}
+	results := []QProject{}
+	err := mg.findAll("projects", query, &results)
+	if err != nil {
+		return results, err
+	}
+	if (uuid != "" || name != "") && len(results) == 0 {
+		return results, errors.New("not found")
+	}
+	return results, nil
+}
+
+// InsertProject inserts a new project
+func (mg *MongoStore) InsertProject(uuid string, name string, createdOn string, modifiedOn string, createdBy string, description string, tags map[string]string, maxTopics int, maxSubs int) error {
+	project := QProject{UUID: uuid, Name: name, CreatedOn: createdOn, ModifiedOn: modifiedOn, CreatedBy: createdBy,
+		Description: description, Tags: tags, MaxTopics: maxTopics, MaxSubs: maxSubs}
+	return mg.insert("projects", project)
+}
+
+// UpdateProject updates the name, description and tags of a project
+func (mg *MongoStore) UpdateProject(uuid string, name string, description string, tags map[string]string, maxTopics int, maxSubs int, modifiedOn string) error {
+	set := bson.M{"modified_on": modifiedOn}
+	if name != "" {
+		set["name"] = name
+	}
+	if description != "" {
+		set["description"] = description
+	}
+	if tags != nil {
+		set["tags"] = tags
+	}
+	if maxTopics != 0 {
+		set["max_topics"] = maxTopics
+	}
+	if maxSubs != 0 {
+		set["max_subs"] = maxSubs
+	}
+	return mg.update("projects", bson.M{"uuid": uuid}, bson.M{"$set": set})
+}
+
+// RemoveProject removes a project
+func (mg *MongoStore) RemoveProject(uuid string) error {
+	return mg.remove("projects", bson.M{"uuid": uuid})
+}
+
+// QueryProjectCluster returns the kafka cluster assigned to a project
+func (mg *MongoStore) QueryProjectCluster(projectUUID string) (string, error) {
+	projects, err := mg.QueryProjects(projectUUID, "")
+	if err != nil {
+		return "", err
+	}
+	return projects[0].Cluster, nil
+}
+
+// UpdateProjectCluster assigns a kafka cluster to a project
+func (mg *MongoStore) UpdateProjectCluster(projectUUID string, cluster string) error {
+	return mg.update("projects", bson.M{"uuid": projectUUID}, bson.M{"$set": bson.M{"cluster": cluster}})
+}
+
+// QueryTopics queries the topics of a project, optionally filtered by user acl
+// membership or name, paginated with an offset based page token
+func (mg *MongoStore) QueryTopics(projectUUID string, userUUID string, name string, pageToken string, pageSize int64, orderBy string, descending bool) ([]QTopic, int64, string, error) {
+	query := bson.M{"project_uuid": projectUUID}
+	if name != "" {
+		query["name"] = name
+	}
+	if userUUID != "" {
+		names, err := mg.aclResourceNames(projectUUID, "topics", userUUID)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		query["name"] = bson.M{"$in": names}
+	}
+	totalSize, err := mg.count("topics", query)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if pageToken != "" {
+		if err := applyCursor(query, pageToken, orderBy, descending); err != nil {
+			return nil, 0, "", err
+		}
+	}
+	results := []QTopic{}
+	if err := mg.findAllSortedLimit("topics", query, cursorSort(orderBy, descending), queryLimit(pageSize), &results); err != nil {
+		return nil, 0, "", err
+	}
+	nextPageToken := ""
+	if pageSize > 0 && int64(len(results)) > pageSize {
+		results = results[:pageSize]
+		last := results[len(results)-1]
+		nextPageToken = encodeCursorToken(qTopicSortValue(last, orderBy), orderBy, last.ID)
+	}
+	return results, totalSize, nextPageToken, nil
+}
+
+// HasTopic checks if a topic exists in a project
+func (mg *MongoStore) HasTopic(projectUUID string, name string) bool {
+	count, _ := mg.count("topics", bson.M{"project_uuid": projectUUID, "name": name})
+	return count > 0
+}
+
+// InsertTopic inserts a new topic
+func (mg *MongoStore) InsertTopic(projectUUID string, name string, partitions int32, replicationFactor int16, idempotent bool, inheritProjectACL bool, routing bool, autoScaleDisabled bool, createdOn string) error {
+	topic := QTopic{ProjectUUID: projectUUID, Name: name, Partitions: partitions, ReplicationFactor: replicationFactor, Idempotent: idempotent, InheritProjectACL: inheritProjectACL, Routing: routing, AutoScaleDisabled: autoScaleDisabled, CreatedOn: createdOn}
+	if err := mg.insert("topics", topic); err != nil {
+		return err
+	}
+	return mg.insert("topics_acl", bson.M{"project_uuid": projectUUID, "name": name, "acl": []string{}})
+}
+
+// RemoveTopic removes a topic from the store
+func (mg *MongoStore) RemoveTopic(projectUUID string, name string) error {
+	if err := mg.remove("topics", bson.M{"project_uuid": projectUUID, "name": name}); err != nil {
+		return err
+	}
+	mg.remove("topics_acl", bson.M{"project_uuid": projectUUID, "name": name})
+	mg.remove("routing_rules", bson.M{"project_uuid": projectUUID, "topic_name": name})
+	return nil
+}
+
+// IncrementTopicMsgNum increments the number of messages published to a topic
+func (mg *MongoStore) IncrementTopicMsgNum(projectUUID string, name string, num int64) error {
+	return mg.update("topics", bson.M{"project_uuid": projectUUID, "name": name}, bson.M{"$inc": bson.M{"msg_num": num}})
+}
+
+// IncrementTopicBytes increments the total bytes published to a topic
+func (mg *MongoStore) IncrementTopicBytes(projectUUID string, name string, totalBytes int64) error {
+	return mg.update("topics", bson.M{"project_uuid": projectUUID, "name": name}, bson.M{"$inc": bson.M{"total_bytes": totalBytes}})
+}
+
+// UpdateTopicPartitions updates the partition count of a topic
+func (mg *MongoStore) UpdateTopicPartitions(projectUUID string, name string, partitions int32) error {
+	return mg.update("topics", bson.M{"project_uuid": projectUUID, "name": name}, bson.M{"$set": bson.M{"partitions": partitions}})
+}
+
+// UpdateTopicLatestPublish updates the latest publish date of a topic
+func (mg *MongoStore) UpdateTopicLatestPublish(projectUUID string, name string, date string) error {
+	return mg.update("topics", bson.M{"project_uuid": projectUUID, "name": name}, bson.M{"$set": bson.M{"latest_publish": date}})
+}
+
+// IncrementDailyMsgCount adds to the message count of a topic for a day
+func (mg *MongoStore) IncrementDailyMsgCount(date string, projectUUID string, topicName string, num int64) error {
+	ctx, cancel := opCtx()
+	defer cancel()
+	query := bson.M{"date": date, "project_uuid": projectUUID, "topic_name": topicName}
+	change := bson.M{"$inc": bson.M{"msg_count": num}}
+	_, err := mg.db.Collection("daily_msg_counts").UpdateOne(ctx, query, change, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Errorf("STORE: update on daily_msg_counts failed, %v", err)
+	}
+	return err
+}
+
+// QueryDailyMsgCount returns the per-day message counts of a project,
+// optionally filtered by topic, most recent day first
+func (mg *MongoStore) QueryDailyMsgCount(projectUUID string, topicName string) ([]QDailyMsgCount, error) {
+	query := bson.M{"project_uuid": projectUUID}
+	if topicName != "" {
+		query["topic_name"] = topicName
+	}
+	results := []QDailyMsgCount{}
+	if err := mg.findAllSorted("daily_msg_counts", query, bson.M{"date": -1}, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ModTopicSchema attaches a schema to a topic; an empty name detaches it
+func (mg *MongoStore) ModTopicSchema(projectUUID string, name string, schemaName string) error {
+	return mg.update("topics", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"schema_name": schemaName}})
+}
+
+// ModTopicLabels replaces the labels of a topic
+func (mg *MongoStore) ModTopicLabels(projectUUID string, name string, labels map[string]string) error {
+	return mg.update("topics", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"labels": labels}})
+}
+
+// QueryRoutingRules returns the attribute rules of a routing topic
+func (mg *MongoStore) QueryRoutingRules(projectUUID string, topicName string) ([]QRoutingRule, error) {
+	results := []QRoutingRule{}
+	query := bson.M{"project_uuid": projectUUID, "topic_name": topicName}
+	if err := mg.findAll("routing_rules", query, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ModRoutingRules replaces the attribute rules of a routing topic
+func (mg *MongoStore) ModRoutingRules(projectUUID string, topicName string, rules []QRoutingRule) error {
+	mg.remove("routing_rules", bson.M{"project_uuid": projectUUID, "topic_name": topicName})
+	for _, rule := range rules {
+		if err := mg.insert("routing_rules", rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QuerySchemas queries the schemas of a project, optionally filtered by name
+func (mg *MongoStore) QuerySchemas(projectUUID string, name string) ([]QSchema, error) {
+	query := bson.M{"project_uuid": projectUUID}
+	if name != "" {
+		query["name"] = name
+	}
+	results := []QSchema{}
+	if err := mg.findAll("schemas", query, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// InsertSchema inserts a new schema
+func (mg *MongoStore) InsertSchema(projectUUID string, name string, schemaType string, rawSchema string, createdOn string) error {
+	schema := QSchema{ProjectUUID: projectUUID, Name: name, Type: schemaType, RawSchema: rawSchema, CreatedOn: createdOn}
+	return mg.insert("schemas", schema)
+}
+
+// RemoveSchema removes a schema from the store
+func (mg *MongoStore) RemoveSchema(projectUUID string, name string) error {
+	return mg.remove("schemas", bson.M{"project_uuid": projectUUID, "name": name})
+}
+
+// QuerySubs queries the subscriptions of a project, optionally filtered by
+// user acl membership or name, paginated with an offset based page token
+func (mg *MongoStore) QuerySubs(projectUUID string, userUUID string, name string, pageToken string, pageSize int64, orderBy string, descending bool) ([]QSub, int64, string, error) {
+	query := bson.M{"project_uuid": projectUUID}
+	if name != "" {
+		query["name"] = name
+	}
+	if userUUID != "" {
+		names, err := mg.aclResourceNames(projectUUID, "subscriptions", userUUID)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		query["name"] = bson.M{"$in": names}
+	}
+	return mg.querySubsPage(query, pageToken, pageSize, orderBy, descending)
+}
+
+// QuerySubsByTopic returns a page of the subscriptions attached to a
+// given topic
+func (mg *MongoStore) QuerySubsByTopic(projectUUID string, topic string, pageToken string, pageSize int64) ([]QSub, int64, string, error) {
+	query := bson.M{"project_uuid": projectUUID, "topic": topic}
+	return mg.querySubsPage(query, pageToken, pageSize, "", false)
+}
+
+// querySubsPage pages through the subscriptions matching a query by
+// descending document id, resuming below the cursor of the page token
+func (mg *MongoStore) querySubsPage(query bson.M, pageToken string, pageSize int64, orderBy string, descending bool) ([]QSub, int64, string, error) {
+	totalSize, err := mg.count("subscriptions", query)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if pageToken != "" {
+		if err := applyCursor(query, pageToken, orderBy, descending); err != nil {
+			return nil, 0, "", err
+		}
+	}
+	results := []QSub{}
+	if err := mg.findAllSortedLimit("subscriptions", query, cursorSort(orderBy, descending), queryLimit(pageSize), &results); err != nil {
+		return nil, 0, "", err
+	}
+	nextPageToken := ""
+	if pageSize > 0 && int64(len(results)) > pageSize {
+		results = results[:pageSize]
+		last := results[len(results)-1]
+		nextPageToken = encodeCursorToken(qSubSortValue(last, orderBy), orderBy, last.ID)
+	}
+	return results, totalSize, nextPageToken, nil
+}
+
+// HasSub checks if a subscription exists in a project
+func (mg *MongoStore) HasSub(projectUUID string, name string) bool {
+	count, _ := mg.count("subscriptions", bson.M{"project_uuid": projectUUID, "name": name})
+	return count > 0
+}
+
+// InsertSub inserts a new subscription
+func (mg *MongoStore) InsertSub(projectUUID string, name string, topic string, offset int64, ackDeadline int, pushEndpoint string, retPolicy string, retPeriod int, replayDays int, replayOffset int64, createdOn string) error {
+	sub := QSub{ProjectUUID: projectUUID, Name: name, Topic: topic, Offset: offset, Ack: ackDeadline,
+		PushEndpoint: pushEndpoint, RetPolicy: retPolicy, RetPeriod: retPeriod,
+		ReplayDays: replayDays, ReplayOffset: replayOffset, CreatedOn: createdOn}
+	if err := mg.insert("subscriptions", sub); err != nil {
+		return err
+	}
+	return mg.insert("subscriptions_acl", bson.M{"project_uuid": projectUUID, "name": name, "acl": []string{}})
+}
+
+// RemoveSub removes a subscription from the store
+func (mg *MongoStore) RemoveSub(projectUUID string, name string) error {
+	if err := mg.remove("subscriptions", bson.M{"project_uuid": projectUUID, "name": name}); err != nil {
+		return err
+	}
+	mg.remove("subscriptions_acl", bson.M{"project_uuid": projectUUID, "name": name})
+	mg.remove("push_attempts", bson.M{"project_uuid": projectUUID, "sub": name})
+	return nil
+}
+
+// ModSubPush modifies the push configuration of a subscription
+func (mg *MongoStore) ModSubPush(projectUUID string, name string, pushEndpoint string, retPolicy string, retPeriod int, cloudEvents bool, authzHeader string, digest bool, digestWindow int, maxBatchMessages int, maxBatchBytes int64, tlsCert string, pushType string, awsCredentials string, kafkaTLS bool, saslUser string, saslPassword string, emailSubject string, chatTemplate string) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"push_endpoint": pushEndpoint, "retry_policy": retPolicy,
+			"retry_period": retPeriod, "cloud_events": cloudEvents, "authorization_header": authzHeader,
+			"digest": digest, "digest_window": digestWindow,
+			"max_batch_messages": maxBatchMessages, "max_batch_bytes": maxBatchBytes,
+			"tls_cert": tlsCert, "push_type": pushType, "aws_credentials": awsCredentials,
+			"kafka_tls": kafkaTLS, "sasl_user": saslUser, "sasl_password": saslPassword,
+			"email_subject": emailSubject, "chat_template": chatTemplate}})
+}
+
+// ModSubVerification updates the endpoint ownership verification state of a subscription
+func (mg *MongoStore) ModSubVerification(projectUUID string, name string, verificationHash string, verified bool) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"verification_hash": verificationHash, "verified": verified}})
+}
+
+// ModSubPushPaused pauses or resumes the push deliveries of a subscription
+func (mg *MongoStore) ModSubPushPaused(projectUUID string, name string, paused bool) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"push_paused": paused}})
+}
+
+// AppendPushAttempt records a push delivery attempt of a subscription,
+// keeping only the most recent pushAttemptsKept entries
+func (mg *MongoStore) AppendPushAttempt(attempt QPushAttempt) error {
+	ctx, cancel := opCtx()
+	defer cancel()
+	query := bson.M{"project_uuid": attempt.ProjectUUID, "sub": attempt.Sub}
+	change := bson.M{"$push": bson.M{"attempts": bson.M{"$each": []QPushAttempt{attempt}, "$slice": -pushAttemptsKept}}}
+	_, err := mg.db.Collection("push_attempts").UpdateOne(ctx, query, change, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Errorf("STORE: update on push_attempts failed, %v", err)
+	}
+	return err
+}
+
+// QueryPushAttempts returns the recorded push delivery attempts of a
+// subscription, most recent first
+func (mg *MongoStore) QueryPushAttempts(projectUUID string, name string) ([]QPushAttempt, error) {
+	doc := struct {
+		Attempts []QPushAttempt `bson:"attempts"`
+	}{}
+	err := mg.findOne("push_attempts", bson.M{"project_uuid": projectUUID, "sub": name}, &doc)
+	if err == mongo.ErrNoDocuments {
+		return []QPushAttempt{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	results := make([]QPushAttempt, 0, len(doc.Attempts))
+	for i := len(doc.Attempts) - 1; i >= 0; i-- {
+		results = append(results, doc.Attempts[i])
+	}
+	return results, nil
+}
+
+// AcquireLock acquires or renews a named lease; it returns false when
+// the lock is held by another holder and has not expired yet
+func (mg *MongoStore) AcquireLock(name string, holder string, now string, expires string) (bool, error) {
+	ctx, cancel := opCtx()
+	defer cancel()
+	query := bson.M{"name": name, "$or": []bson.M{{"holder": holder}, {"expires_at": bson.M{"$lt": now}}}}
+	change := bson.M{"$set": bson.M{"holder": holder, "expires_at": expires}}
+	_, err := mg.db.Collection("locks").UpdateOne(ctx, query, change, options.Update().SetUpsert(true))
+	if err != nil {
+		// the unique index turns a lost acquisition race into a
+		// duplicate key error
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		log.Errorf("STORE: update on locks failed, %v", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseLock releases a named lease when still held by holder
+func (mg *MongoStore) ReleaseLock(name string, holder string) error {
+	ctx, cancel := opCtx()
+	defer cancel()
+	_, err := mg.db.Collection("locks").DeleteOne(ctx, bson.M{"name": name, "holder": holder})
+	return err
+}
+
+// QueryLocks returns the currently recorded singleton job leases
+func (mg *MongoStore) QueryLocks() ([]QLock, error) {
+	results := []QLock{}
+	err := mg.findAll("locks", bson.M{}, &results)
+	return results, err
+}
+
+// ModSubSigningSecret updates the push delivery signing secret of a subscription
+func (mg *MongoStore) ModSubSigningSecret(projectUUID string, name string, signingSecret string) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"signing_secret": signingSecret}})
+}
+
+// ModAck modifies the ack deadline of a subscription
+func (mg *MongoStore) ModAck(projectUUID string, name string, ack int) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"ack": ack}})
+}
+
+// ModSubAckDedup sets the ack dedup window of a subscription
+func (mg *MongoStore) ModSubAckDedup(projectUUID string, name string, windowSec int) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"ack_dedup_window_sec": windowSec}})
+}
+
+// ModSubLabels replaces the labels of a subscription
+func (mg *MongoStore) ModSubLabels(projectUUID string, name string, labels map[string]string) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"labels": labels}})
+}
+
+// UpdateSubOffset updates the offset of a subscription
+func (mg *MongoStore) UpdateSubOffset(projectUUID string, name string, offset int64) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"offset": offset, "next_offset": 0, "pending_ack": "", "ack_deadline": ""}})
+}
+
+// UpdateSubReplayOffset updates the source topic offset of the replay mirror
+func (mg *MongoStore) UpdateSubReplayOffset(projectUUID string, name string, offset int64) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"replay_offset": offset}})
+}
+
+// UpdateSubOffsetAck updates the offset of a subscription on acknowledge
+func (mg *MongoStore) UpdateSubOffsetAck(projectUUID string, name string, offset int64, timestamp string) error {
+	sub := QSub{}
+	if err := mg.findOne("subscriptions", bson.M{"project_uuid": projectUUID, "name": name}, &sub); err != nil {
+		return errors.New("not found")
+	}
+	if sub.PendingAck == "" {
+		return errors.New("no ack pending")
+	}
+	if offset <= sub.Offset || offset > sub.NextOffset {
+		return errors.New("ack timeout")
+	}
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"offset": offset, "next_offset": 0, "pending_ack": "", "ack_deadline": ""}})
+}
+
+// UpdateSubPull updates the pending ack state of a subscription on pull
+func (mg *MongoStore) UpdateSubPull(projectUUID string, name string, offset int64, timestamp string) error {
+	sub := QSub{}
+	if err := mg.findOne("subscriptions", bson.M{"project_uuid": projectUUID, "name": name}, &sub); err != nil {
+		return errors.New("not found")
+	}
+	// the absolute deadline is persisted so recovery after a restart does
+	// not depend on the ack setting at that time
+	deadline := ""
+	if pulled, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+		deadline = pulled.Add(time.Duration(sub.Ack) * time.Second).Format(time.RFC3339Nano)
+	}
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"next_offset": offset, "pending_ack": timestamp, "ack_deadline": deadline}})
+}
+
+// UpdateSubAckDeadline pushes the in-flight ack deadline of a subscription forward
+func (mg *MongoStore) UpdateSubAckDeadline(projectUUID string, name string, deadline string) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"ack_deadline": deadline}})
+}
+
+// IncrementSubMsgNum increments the number of messages consumed by a subscription
+func (mg *MongoStore) IncrementSubMsgNum(projectUUID string, name string, num int64) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name}, bson.M{"$inc": bson.M{"msg_num": num}})
+}
+
+// IncrementSubBytes increments the total bytes consumed by a subscription
+func (mg *MongoStore) IncrementSubBytes(projectUUID string, name string, totalBytes int64) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name}, bson.M{"$inc": bson.M{"total_bytes": totalBytes}})
+}
+
+// IncrementSubPushed adds to the push delivery counters of a subscription
+func (mg *MongoStore) IncrementSubPushed(projectUUID string, name string, num int64, totalBytes int64, date string) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name}, bson.M{
+		"$inc": bson.M{"pushed_msg_num": num, "pushed_bytes": totalBytes},
+		"$set": bson.M{"latest_push": date},
+	})
+}
+
+// IncrementSubPushErrors adds to the failed push attempt counter of a
+// subscription
+func (mg *MongoStore) IncrementSubPushErrors(projectUUID string, name string, num int64) error {
+	return mg.update("subscriptions", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$inc": bson.M{"push_error_num": num}})
+}
+
+// QueryUsers queries the users, optionally filtered by project, uuid or name
+func (mg *MongoStore) QueryUsers(projectUUID string, uuid string, name string) ([]QUser, error) {
+	query := bson.M{}
+	if projectUUID != "" {
+		query["projects.project_uuid"] = projectUUID
+	}
+	if uuid != "" {
+		query["uuid"] = uuid
+	}
+	if name != "" {
+		query["name"] = name
+	}
+	results := []QUser{}
+	err := mg.findAll("users", query, &results)
+	return results, err
+}
+
+// GetUserFromToken returns the user holding a given token, either the
+// primary one or one of the named api keys
+func (mg *MongoStore) GetUserFromToken(token string) (QUser, error) {
+	user := QUser{}
+	query := bson.M{"$or": []bson.M{{"token": token}, {"tokens.token": token}}}
+	if err := mg.findOne("users", query, &user); err != nil {
+		return user, errors.New("not found")
+	}
+	return user, nil
+}
+
+// HasUsers checks the existence of a list of usernames, returning the missing ones
+func (mg *MongoStore) HasUsers(projectUUID string, users []string) (bool, []string) {
+	results := []QUser{}
+	mg.findAll("users", bson.M{"projects.project_uuid": projectUUID, "name": bson.M{"$in": users}}, &results)
+	missing := []string{}
+	for _, username := range users {
+		found := false
+		for _, user := range results {
+			if user.Name == username {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, username)
+		}
+	}
+	return len(missing) == 0, missing
+}
+
+// InsertUser inserts a new user
+func (mg *MongoStore) InsertUser(uuid string, projects []QProjectRoles, name string, token string, tokenExpiresOn string, email string, serviceRoles []string, createdOn string, modifiedOn string, createdBy string) error {
+	user := QUser{UUID: uuid, Projects: projects, Name: name, Token: token, TokenExpiresOn: tokenExpiresOn, Email: email,
+		ServiceRoles: serviceRoles, CreatedOn: createdOn, ModifiedOn: modifiedOn, CreatedBy: createdBy}
+	return mg.insert("users", user)
+}
+
+// UpdateUser updates the details of a user
+func (mg *MongoStore) UpdateUser(uuid string, projects []QProjectRoles, name string, email string, serviceRoles []string, tokenExpiresOn string, modifiedOn string) error {
+	set := bson.M{"modified_on": modifiedOn}
+	if projects != nil {
+		set["projects"] = projects
+	}
+	if name != "" {
+		set["name"] = name
+	}
+	if email != "" {
+		set["email"] = email
+	}
+	if serviceRoles != nil {
+		set["service_roles"] = serviceRoles
+	}
+	if tokenExpiresOn != "" {
+		set["token_expires_on"] = tokenExpiresOn
+	}
+	return mg.update("users", bson.M{"uuid": uuid}, bson.M{"$set": set})
+}
+
+// UpdateUserSuspension sets the suspension state of a user
+func (mg *MongoStore) UpdateUserSuspension(uuid string, suspended bool, modifiedOn string) error {
+	return mg.update("users", bson.M{"uuid": uuid}, bson.M{
+		"$set": bson.M{"suspended": suspended, "modified_on": modifiedOn},
+	})
+}
+
+// UpdateUserToken updates the token of a user, dropping any expiry and
+// any previous token still within its grace period
+func (mg *MongoStore) UpdateUserToken(uuid string, token string) error {
+	return mg.update("users", bson.M{"uuid": uuid}, bson.M{
+		"$set":   bson.M{"token": token},
+		"$unset": bson.M{"token_expires_on": "", "previous_token": "", "previous_token_expires_on": ""},
+	})
+}
+
+// InsertUserToken adds a named api key to a user
+func (mg *MongoStore) InsertUserToken(uuid string, name string, token string, createdOn string, expiresOn string) error {
+	user := QUser{}
+	if err := mg.findOne("users", bson.M{"uuid": uuid}, &user); err != nil {
+		return errors.New("not found")
+	}
+	for _, named := range user.Tokens {
+		if named.Name == name {
+			return errors.New("exists")
+		}
+	}
+	return mg.update("users", bson.M{"uuid": uuid}, bson.M{
+		"$push": bson.M{"tokens": QUserToken{Name: name, Token: token, CreatedOn: createdOn, ExpiresOn: expiresOn}},
+	})
+}
+
+// RemoveUserToken revokes a named api key of a user
+func (mg *MongoStore) RemoveUserToken(uuid string, name string) error {
+	user := QUser{}
+	if err := mg.findOne("users", bson.M{"uuid": uuid, "tokens.name": name}, &user); err != nil {
+		return errors.New("not found")
+	}
+	return mg.update("users", bson.M{"uuid": uuid}, bson.M{
+		"$pull": bson.M{"tokens": bson.M{"name": name}},
+	})
+}
+
+// RotateUserToken assigns a new token to a user, keeping the replaced one
+// valid until the given expiry
+func (mg *MongoStore) RotateUserToken(uuid string, token string, previousExpiresOn string) error {
+	user := QUser{}
+	if err := mg.findOne("users", bson.M{"uuid": uuid}, &user); err != nil {
+		return errors.New("not found")
+	}
+	return mg.update("users", bson.M{"uuid": uuid}, bson.M{
+		"$set":   bson.M{"token": token, "previous_token": user.Token, "previous_token_expires_on": previousExpiresOn},
+		"$unset": bson.M{"token_expires_on": ""},
+	})
+}
+
+// RemoveUser removes a user
+func (mg *MongoStore) RemoveUser(uuid string) error {
+	return mg.remove("users", bson.M{"uuid": uuid})
+}
+
+// GetUserRoles returns the roles a token holds within a project; expired
+// tokens grant nothing, rotated-out tokens keep granting until their
+// grace period lapses
+func (mg *MongoStore) GetUserRoles(projectUUID string, token string) ([]string, string) {
+	user := QUser{}
+	query := bson.M{"$or": []bson.M{{"token": token}, {"previous_token": token}, {"tokens.token": token}}}
+	if err := mg.findOne("users", query, &user); err != nil {
+		return []string{}, ""
+	}
+	if !user.tokenGrantsAccess(token, time.Now().UTC()) {
+		return []string{}, ""
+	}
+	return append(user.getProjectRoles(projectUUID), user.ServiceRoles...), user.UUID
+}
+
+// FilterUsers returns the users matching every given filter, evaluated
+// in the store so the selection can use the user indexes
+func (mg *MongoStore) FilterUsers(projectUUID string, serviceRole string, email string, namePrefix string, createdBy string, orderBy string, descending bool) ([]QUser, error) {
+	query := bson.M{}
+	if projectUUID != "" {
+		query["projects.project_uuid"] = projectUUID
+	}
+	if serviceRole != "" {
+		query["service_roles"] = serviceRole
+	}
+	if email != "" {
+		query["email"] = bson.M{"$regex": regexp.QuoteMeta(email), "$options": "i"}
+	}
+	if namePrefix != "" {
+		query["name"] = bson.M{"$regex": "^" + regexp.QuoteMeta(namePrefix)}
+	}
+	if createdBy != "" {
+		query["created_by"] = createdBy
+	}
+	results := []QUser{}
+	if orderBy != "" {
+		dir := 1
+		if descending {
+			dir = -1
+		}
+		err := mg.findAllSortedLimit("users", query, bson.D{{Key: orderBy, Value: dir}}, 0, &results)
+		return results, err
+	}
+	err := mg.findAll("users", query, &results)
+	return results, err
+}
+
+// QueryTopicKeys returns the publish keys of a topic
+func (mg *MongoStore) QueryTopicKeys(projectUUID string, topicName string) ([]QTopicKey, error) {
+	results := []QTopicKey{}
+	err := mg.findAll("topic_keys", bson.M{"project_uuid": projectUUID, "topic_name": topicName}, &results)
+	return results, err
+}
+
+// GetTopicKeyFromToken returns the publish key of a topic holding a
+// given token
+func (mg *MongoStore) GetTopicKeyFromToken(projectUUID string, topicName string, token string) (QTopicKey, error) {
+	key := QTopicKey{}
+	query := bson.M{"project_uuid": projectUUID, "topic_name": topicName, "token": token}
+	if err := mg.findOne("topic_keys", query, &key); err != nil {
+		return key, errors.New("not found")
+	}
+	return key, nil
+}
+
+// InsertTopicKey inserts a new publish key for a topic
+func (mg *MongoStore) InsertTopicKey(projectUUID string, topicName string, name string, uuid string, token string, createdOn string, createdBy string) error {
+	key := QTopicKey{ProjectUUID: projectUUID, TopicName: topicName, Name: name, UUID: uuid,
+		Token: token, CreatedOn: createdOn, CreatedBy: createdBy}
+	return mg.insert("topic_keys", key)
+}
+
+// RemoveTopicKey revokes a publish key of a topic
+func (mg *MongoStore) RemoveTopicKey(projectUUID string, topicName string, name string) error {
+	return mg.remove("topic_keys", bson.M{"project_uuid": projectUUID, "topic_name": topicName, "name": name})
+}
+
+// QueryRoleDefinitions returns the custom role definitions, optionally
+// filtered by name
+func (mg *MongoStore) QueryRoleDefinitions(name string) ([]QRoleDefinition, error) {
+	query := bson.M{}
+	if name != "" {
+		query["name"] = name
+	}
+	results := []QRoleDefinition{}
+	err := mg.findAll("roles", query, &results)
+	return results, err
+}
+
+// InsertRoleDefinition inserts a new custom role definition
+func (mg *MongoStore) InsertRoleDefinition(name string, routes []string) error {
+	return mg.insert("roles", QRoleDefinition{Name: name, Routes: routes})
+}
+
+// UpdateRoleDefinition replaces the routes of a custom role definition
+func (mg *MongoStore) UpdateRoleDefinition(name string, routes []string) error {
+	return mg.update("roles", bson.M{"name": name}, bson.M{"$set": bson.M{"routes": routes}})
+}
+
+// RemoveRoleDefinition removes a custom role definition
+func (mg *MongoStore) RemoveRoleDefinition(name string) error {
+	return mg.remove("roles", bson.M{"name": name})
+}
+
+// QueryServiceAccounts returns the service accounts of a project,
+// optionally filtered by name
+func (mg *MongoStore) QueryServiceAccounts(projectUUID string, name string) ([]QServiceAccount, error) {
+	query := bson.M{"project_uuid": projectUUID}
+	if name != "" {
+		query["name"] = name
+	}
+	results := []QServiceAccount{}
+	err := mg.findAll("service_accounts", query, &results)
+	return results, err
+}
+
+// GetServiceAccountFromToken returns the service account of a project
+// holding a given token
+func (mg *MongoStore) GetServiceAccountFromToken(projectUUID string, token string) (QServiceAccount, error) {
+	sa := QServiceAccount{}
+	if err := mg.findOne("service_accounts", bson.M{"project_uuid": projectUUID, "token": token}, &sa); err != nil {
+		return sa, errors.New("not found")
+	}
+	return sa, nil
+}
+
+// InsertServiceAccount inserts a new service account
+func (mg *MongoStore) InsertServiceAccount(projectUUID string, uuid string, name string, token string, scopes []QServiceAccountScope, createdOn string, createdBy string) error {
+	sa := QServiceAccount{ProjectUUID: projectUUID, UUID: uuid, Name: name, Token: token,
+		Scopes: scopes, CreatedOn: createdOn, ModifiedOn: createdOn, CreatedBy: createdBy}
+	return mg.insert("service_accounts", sa)
+}
+
+// UpdateServiceAccountScopes replaces the scopes of a service account
+func (mg *MongoStore) UpdateServiceAccountScopes(projectUUID string, name string, scopes []QServiceAccountScope, modifiedOn string) error {
+	return mg.update("service_accounts", bson.M{"project_uuid": projectUUID, "name": name},
+		bson.M{"$set": bson.M{"scopes": scopes, "modified_on": modifiedOn}})
+}
+
+// RemoveServiceAccount removes a service account
+func (mg *MongoStore) RemoveServiceAccount(projectUUID string, name string) error {
+	return mg.remove("service_accounts", bson.M{"project_uuid": projectUUID, "name": name})
+}
+
+// QueryACL returns the acl of a resource
+func (mg *MongoStore) QueryACL(projectUUID string, resourceType string, resourceName string) (QAcl, error) {
+	acl := QAcl{}
+	err := mg.findOne(resourceType+"_acl", bson.M{"project_uuid": projectUUID, "name": resourceName}, &acl)
+	if err != nil {
+		return acl, errors.New("not found")
+	}
+	return acl, nil
+}
+
+// ModACL replaces the acl of a resource along with the grant expirations
+func (mg *MongoStore) ModACL(projectUUID string, resourceType string, resourceName string, acl []string, expires map[string]string) error {
+	return mg.update(resourceType+"_acl", bson.M{"project_uuid": projectUUID, "name": resourceName},
+		bson.M{"$set": bson.M{"acl": acl, "expires": expires}})
+}
+
+// qAclDoc is the full acl document layout, used by the expiry sweep
+type qAclDoc struct {
+	ProjectUUID string            `bson:"project_uuid"`
+	Name        string            `bson:"name"`
+	ACL         []string          `bson:"acl"`
+	Expires     map[string]string `bson:"expires,omitempty"`
+}
+
+// RemoveExpiredGrants drops acl entries and project memberships whose
+// expiry has passed, returning the removed grants
+func (mg *MongoStore) RemoveExpiredGrants(now string) ([]QExpiredGrant, error) {
+	expired := []QExpiredGrant{}
+	nowTime, err := time.Parse(time.RFC3339, now)
+	if err != nil {
+		return expired, err
+	}
+	past := func(expiry string) bool {
+		t, err := time.Parse(time.RFC3339, expiry)
+		return err == nil && !nowTime.Before(t)
+	}
+
+	for _, resourceType := range []string{"topics", "subscriptions"} {
+		docs := []qAclDoc{}
+		if err := mg.findAll(resourceType+"_acl", bson.M{"expires": bson.M{"$exists": true, "$ne": nil}}, &docs); err != nil {
+			return expired, err
+		}
+		for _, doc := range docs {
+			kept := []string{}
+			changed := false
+			for _, userUUID := range doc.ACL {
+				if expiry, ok := doc.Expires[userUUID]; ok && past(expiry) {
+					expired = append(expired, QExpiredGrant{ProjectUUID: doc.ProjectUUID,
+						ResourceType: resourceType, ResourceName: doc.Name, UserUUID: userUUID})
+					delete(doc.Expires, userUUID)
+					changed = true
+					continue
+				}
+				kept = append(kept, userUUID)
+			}
+			if !changed {
+				continue
+			}
+			err := mg.update(resourceType+"_acl", bson.M{"project_uuid": doc.ProjectUUID, "name": doc.Name},
+				bson.M{"$set": bson.M{"acl": kept, "expires": doc.Expires}})
+			if err != nil {
+				return expired, err
+			}
+		}
+	}
+
+	users := []QUser{}
+	if err := mg.findAll("users", bson.M{"projects.expires_on": bson.M{"$exists": true, "$ne": ""}}, &users); err != nil {
+		return expired, err
+	}
+	for _, user := range users {
+		kept := []QProjectRoles{}
+		changed := false
+		for _, pr := range user.Projects {
+			if pr.ExpiresOn != "" && past(pr.ExpiresOn) {
+				expired = append(expired, QExpiredGrant{ProjectUUID: pr.ProjectUUID,
+					ResourceType: "project", UserUUID: user.UUID})
+				changed = true
+				continue
+			}
+			kept = append(kept, pr)
+		}
+		if !changed {
+			continue
+		}
+		if err := mg.update("users", bson.M{"uuid": user.UUID}, bson.M{"$set": bson.M{"projects": kept}}); err != nil {
+			return expired, err
+		}
+	}
+
+	return expired, nil
+}
+
+// EnsureACL creates an empty acl entry for a resource if none exists
+func (mg *MongoStore) EnsureACL(projectUUID string, resourceType string, resourceName string) error {
+	count, err := mg.count(resourceType+"_acl", bson.M{"project_uuid": projectUUID, "name": resourceName})
+	if err != nil || count > 0 {
+		return err
+	}
+	return mg.insert(resourceType+"_acl", bson.M{"project_uuid": projectUUID, "name": resourceName, "acl": []string{}})
+}
+
+// QueryAppliedMigrations returns the schema migrations applied on the store
+func (mg *MongoStore) QueryAppliedMigrations() ([]QMigration, error) {
+	results := []QMigration{}
+	err := mg.findAll("migrations", bson.M{}, &results)
+	return results, err
+}
+
+// InsertAppliedMigration records an applied schema migration
+func (mg *MongoStore) InsertAppliedMigration(version int, description string, appliedOn string) error {
+	return mg.insert("migrations", QMigration{Version: version, Description: description, AppliedOn: appliedOn})
+}
+
+// InsertStatusSnapshot records a periodic health snapshot
+func (mg *MongoStore) InsertStatusSnapshot(snapshot QStatusSnapshot) error {
+	return mg.insert("status_snapshots", snapshot)
+}
+
+// QueryStatusSnapshots returns the health snapshots captured since the
+// given utc time, newest first
+func (mg *MongoStore) QueryStatusSnapshots(since string) ([]QStatusSnapshot, error) {
+	results := []QStatusSnapshot{}
+	err := mg.findAllSorted("status_snapshots", bson.M{"captured_on": bson.M{"$gte": since}},
+		bson.M{"captured_on": -1}, &results)
+	return results, err
+}
+
+// RemoveStatusSnapshots removes the health snapshots captured before the
+// given utc time
+func (mg *MongoStore) RemoveStatusSnapshots(before string) error {
+	ctx, cancel := opCtx()
+	defer cancel()
+	_, err := mg.db.Collection("status_snapshots").DeleteMany(ctx, bson.M{"captured_on": bson.M{"$lt": before}})
+	if err != nil {
+		log.Errorf("STORE: remove on status_snapshots failed, %v", err)
+	}
+	return err
+}
+
+// UpdateProjectQuotas replaces the quota limits of a project
+func (mg *MongoStore) UpdateProjectQuotas(uuid string, maxTopics int, maxSubs int, maxDailyMessages int64, maxDailyBytes int64, modifiedOn string) error {
+	return mg.update("projects", bson.M{"uuid": uuid},
+		bson.M{"$set": bson.M{"max_topics": maxTopics, "max_subs": maxSubs,
+			"max_daily_messages": maxDailyMessages, "max_daily_bytes": maxDailyBytes, "modified_on": modifiedOn}})
+}
+
+// IncrementDailyProjectUsage adds published messages and bytes to the
+// usage of a project on the given day
+func (mg *MongoStore) IncrementDailyProjectUsage(date string, projectUUID string, msgs int64, totalBytes int64) error {
+	ctx, cancel := opCtx()
+	defer cancel()
+	query := bson.M{"date": date, "project_uuid": projectUUID}
+	change := bson.M{"$inc": bson.M{"msg_count": msgs, "bytes_count": totalBytes}}
+	_, err := mg.db.Collection("daily_project_usage").UpdateOne(ctx, query, change, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Errorf("STORE: increment on daily_project_usage failed, %v", err)
+	}
+	return err
+}
+
+// GetDailyProjectUsage returns the publish usage of a project on the
+// given day; a day without usage reads as zero
+func (mg *MongoStore) GetDailyProjectUsage(date string, projectUUID string) (QDailyProjectUsage, error) {
+	result := QDailyProjectUsage{Date: date, ProjectUUID: projectUUID}
+	err := mg.findOne("daily_project_usage", bson.M{"date": date, "project_uuid": projectUUID}, &result)
+	if err == mongo.ErrNoDocuments {
+		return QDailyProjectUsage{Date: date, ProjectUUID: projectUUID}, nil
+	}
+	return result, err
+}
+
+// InsertAuditLog records a mutating api call
+func (mg *MongoStore) InsertAuditLog(entry QAuditLog) error {
+	return mg.insert("audit_logs", entry)
+}
+
+// QueryAuditLogs returns the recorded api calls matching the given
+// filters, newest first; empty filters match everything
+func (mg *MongoStore) QueryAuditLogs(projectUUID string, userUUID string, action string, from string, to string) ([]QAuditLog, error) {
+	query := bson.M{}
+	if projectUUID != "" {
+		query["project_uuid"] = projectUUID
+	}
+	if userUUID != "" {
+		query["user_uuid"] = userUUID
+	}
+	if action != "" {
+		query["action"] = action
+	}
+	timeRange := bson.M{}
+	if from != "" {
+		timeRange["$gte"] = from
+	}
+	if to != "" {
+		timeRange["$lte"] = to
+	}
+	if len(timeRange) > 0 {
+		query["timestamp"] = timeRange
+	}
+	results := []QAuditLog{}
+	err := mg.findAllSorted("audit_logs", query, bson.M{"timestamp": -1}, &results)
+	return results, err
+}
+
+// aclResourceNames returns the names of the resources that include a user in their acl
+func (mg *MongoStore) aclResourceNames(projectUUID string, resourceType string, userUUID string) ([]string, error) {
+	results := []struct {
+		Name string `bson:"name"`
+	}{}
+	err := mg.findAll(resourceType+"_acl", bson.M{"project_uuid": projectUUID, "acl": userUUID}, &results)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, result := range results {
+		names = append(names, result.Name)
+	}
+	return names, nil
+}
+
+// generic collection helpers
+
+func (mg *MongoStore) findAll(col string, query bson.M, results interface{}) error {
+	ctx, cancel := opCtx()
+	defer cancel()
+	cursor, err := mg.db.Collection(col).Find(ctx, query)
+	if err != nil {
+		log.Errorf("STORE: query on %v failed, %v", col, err)
+		return err
+	}
+	return cursor.All(ctx, results)
+}
+
+func (mg *MongoStore) findAllSorted(col string, query bson.M, sort bson.M, results interface{}) error {
+	ctx, cancel := opCtx()
+	defer cancel()
+	cursor, err := mg.db.Collection(col).Find(ctx, query, options.Find().SetSort(sort))
+	if err != nil {
+		log.Errorf("STORE: query on %v failed, %v", col, err)
+		return err
+	}
+	return cursor.All(ctx, results)
+}
+
+func (mg *MongoStore) findAllSortedLimit(col string, query bson.M, sort interface{}, limit int64, results interface{}) error {
+	ctx, cancel := opCtx()
+	defer cancel()
+	opts := options.Find().SetSort(sort)
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := mg.db.Collection(col).Find(ctx, query, opts)
+	if err != nil {
+		log.Errorf("STORE: query on %v failed, %v", col, err)
+		return err
+	}
+	return cursor.All(ctx, results)
+}
+
+// queryLimit turns a page size into a find limit fetching one extra
+// document, the lookahead deciding whether a next page token is needed
+func queryLimit(pageSize int64) int64 {
+	if pageSize > 0 {
+		return pageSize + 1
+	}
+	return 0
+}
+
+// encodeCursorToken encodes the position of the last document of a page
+// as an opaque token: the value of the sort field, if any, and the
+// document id separated by a NUL byte. Keyset cursors stay stable under
+// concurrent inserts, unlike offsets.
+func encodeCursorToken(value string, orderBy string, id primitive.ObjectID) string {
+	if orderBy == "" {
+		value = ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(value + "\x00" + id.Hex()))
+}
+
+// decodeCursorToken decodes an opaque page token back to the sort field
+// value and document id it encodes
+func decodeCursorToken(pageToken string) (string, primitive.ObjectID, error) {
+	decoded, err := base64.StdEncoding.DecodeString(pageToken)
+	if err != nil {
+		return "", primitive.NilObjectID, errors.New("invalid page token")
+	}
+	sep := strings.LastIndexByte(string(decoded), 0)
+	if sep == -1 {
+		return "", primitive.NilObjectID, errors.New("invalid page token")
+	}
+	id, err := primitive.ObjectIDFromHex(string(decoded[sep+1:]))
+	if err != nil {
+		return "", primitive.NilObjectID, errors.New("invalid page token")
+	}
+	return string(decoded[:sep]), id, nil
+}
+
+// applyCursor narrows a query to the documents beyond the page token in
+// the requested order
+func applyCursor(query bson.M, pageToken string, orderBy string, descending bool) error {
+	value, lastID, err := decodeCursorToken(pageToken)
+	if err != nil {
+		return err
+	}
+	if orderBy == "" {
+		query["_id"] = bson.M{"$lt": lastID}
+		return nil
+	}
+	cmp := "$gt"
+	if descending {
+		cmp = "$lt"
+	}
+	query["$or"] = []bson.M{
+		{orderBy: bson.M{cmp: value}},
+		{orderBy: value, "_id": bson.M{cmp: lastID}},
+	}
+	return nil
+}
+
+// cursorSort returns the sort document of a listing: the requested
+// field with the document id as tie breaker, or newest first by default
+func cursorSort(orderBy string, descending bool) interface{} {
+	if orderBy == "" {
+		return bson.M{"_id": -1}
+	}
+	dir := 1
+	if descending {
+		dir = -1
+	}
+	return bson.D{{Key: orderBy, Value: dir}, {Key: "_id", Value: dir}}
+}
+
+func (mg *MongoStore) findOne(col string, query bson.M, result interface{}) error {
+	ctx, cancel := opCtx()
+	defer cancel()
+	return mg.db.Collection(col).FindOne(ctx, query).Decode(result)
+}
+
+func (mg *MongoStore) insert(col string, doc interface{}) error {
+	ctx, cancel := opCtx()
+	defer cancel()
+	_, err := mg.db.Collection(col).InsertOne(ctx, doc)
+	if err != nil {
+		log.Errorf("STORE: insert on %v failed, %v", col, err)
+	}
+	return err
+}
+
+func (mg *MongoStore) update(col string, query bson.M, change bson.M) error {
+	ctx, cancel := opCtx()
+	defer cancel()
+	result, err := mg.db.Collection(col).UpdateOne(ctx, query, change)
+	if err != nil {
+		log.Errorf("STORE: update on %v failed, %v", col, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("not found")
+	}
+	return nil
+}
+
+func (mg *MongoStore) remove(col string, query bson.M) error {
+	ctx, cancel := opCtx()
+	defer cancel()
+	result, err := mg.db.Collection(col).DeleteOne(ctx, query)
+	if err != nil {
+		log.Errorf("STORE: remove on %v failed, %v", col, err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errors.New("not found")
+	}
+	return nil
+}
+
+func (mg *MongoStore) count(col string, query bson.M) (int64, error) {
+	ctx, cancel := opCtx()
+	defer cancel()
+	return mg.db.Collection(col).CountDocuments(ctx, query)
+}
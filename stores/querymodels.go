@@ -0,0 +1,396 @@
+package stores
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// QProject are the project details as stored in the backend
+type QProject struct {
+	UUID        string `bson:"uuid"`
+	Name        string `bson:"name"`
+	CreatedOn   string `bson:"created_on"`
+	ModifiedOn  string `bson:"modified_on"`
+	CreatedBy   string `bson:"created_by"`
+	Description string `bson:"description"`
+	// Cluster names the kafka cluster serving the project's topics.
+	// An empty value routes the project to the default cluster.
+	Cluster string `bson:"cluster,omitempty"`
+	// Tags are free-form labels used to group projects in usage reports,
+	// e.g. a cost-center for per-activity accounting
+	Tags map[string]string `bson:"tags,omitempty"`
+	// MaxTopics and MaxSubs cap how many topics and subscriptions the
+	// project may hold (0 means unlimited)
+	MaxTopics int `bson:"max_topics,omitempty"`
+	MaxSubs   int `bson:"max_subs,omitempty"`
+	// MaxDailyMessages and MaxDailyBytes cap what the project may publish
+	// per utc day; 0 leaves the throughput uncapped
+	MaxDailyMessages int64 `bson:"max_daily_messages,omitempty"`
+	MaxDailyBytes    int64 `bson:"max_daily_bytes,omitempty"`
+}
+
+// QTopic are the topic details as stored in the backend
+type QTopic struct {
+	// ID is the backend document id, used by the mongo store as the
+	// keyset pagination cursor; the other backends leave it unset
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	ProjectUUID   string             `bson:"project_uuid"`
+	Name          string             `bson:"name"`
+	MsgNum        int64              `bson:"msg_num"`
+	TotalBytes    int64              `bson:"total_bytes"`
+	LatestPublish string             `bson:"latest_publish"`
+	CreatedOn     string             `bson:"created_on"`
+	// Partitions and ReplicationFactor mirror the settings of the
+	// underlying kafka topic
+	Partitions        int32 `bson:"partitions"`
+	ReplicationFactor int16 `bson:"replication_factor"`
+	// Idempotent marks topics that publish through the idempotent producer
+	Idempotent bool `bson:"idempotent"`
+	// InheritProjectACL opens the topic to every project member holding
+	// the route roles instead of an explicit user list
+	InheritProjectACL bool `bson:"inherit_project_acl"`
+	// SchemaName references a schema of the same project attached to the
+	// topic; the same schema may be attached to multiple topics
+	SchemaName string `bson:"schema_name,omitempty"`
+	// Routing marks exchange-style topics that fan published messages out
+	// to member topics based on attribute rules instead of storing them
+	Routing bool `bson:"routing,omitempty"`
+	// AutoScaleDisabled opts the topic out of automatic partition growth
+	AutoScaleDisabled bool `bson:"autoscale_disabled,omitempty"`
+	// Labels are free-form key/value pairs tenants attach to group
+	// their topics; list requests may filter on them
+	Labels map[string]string `bson:"labels,omitempty"`
+}
+
+// QRoutingRule routes messages published to a routing topic towards a
+// member topic when the named attribute carries the expected value
+type QRoutingRule struct {
+	ProjectUUID string `bson:"project_uuid"`
+	TopicName   string `bson:"topic_name"`
+	Attribute   string `bson:"attribute"`
+	Value       string `bson:"value"`
+	TargetTopic string `bson:"target_topic"`
+}
+
+// QSchema are the message schema details as stored in the backend
+type QSchema struct {
+	ProjectUUID string `bson:"project_uuid"`
+	Name        string `bson:"name"`
+	Type        string `bson:"type"`
+	RawSchema   string `bson:"raw_schema"`
+	CreatedOn   string `bson:"created_on"`
+}
+
+// QSub are the subscription details as stored in the backend
+type QSub struct {
+	// ID is the backend document id, used by the mongo store as the
+	// keyset pagination cursor; the other backends leave it unset
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	ProjectUUID string             `bson:"project_uuid"`
+	Name        string             `bson:"name"`
+	Topic       string             `bson:"topic"`
+	Offset      int64              `bson:"offset"`
+	NextOffset  int64              `bson:"next_offset"`
+	PendingAck  string             `bson:"pending_ack"`
+	// AckDeadline is the absolute utc time until which the in-flight
+	// messages of the pending pull may still be acknowledged, persisted
+	// so a restarted instance recovers the lease deterministically
+	AckDeadline  string `bson:"ack_deadline,omitempty"`
+	Ack          int    `bson:"ack"`
+	PushEndpoint string `bson:"push_endpoint"`
+	RetPolicy    string `bson:"retry_policy"`
+	RetPeriod    int    `bson:"retry_period"`
+	// CloudEvents marks push subscriptions delivering cloudevents
+	// envelopes instead of the native push payload
+	CloudEvents bool `bson:"cloud_events,omitempty"`
+	// AuthzHeader is the encrypted authorization header value sent with
+	// every push delivery
+	AuthzHeader string `bson:"authorization_header,omitempty"`
+	// VerificationHash is the challenge the endpoint owner must expose to
+	// prove ownership before push deliveries start
+	VerificationHash string `bson:"verification_hash,omitempty"`
+	// Verified marks push endpoints whose ownership has been verified
+	Verified bool `bson:"verified,omitempty"`
+	// SigningSecret is the encrypted per-subscription secret signing
+	// every push delivery
+	SigningSecret string `bson:"signing_secret,omitempty"`
+	// PushPaused pauses push deliveries while keeping the configuration
+	PushPaused bool `bson:"push_paused,omitempty"`
+	// Digest marks push subscriptions delivering periodic windowed
+	// digests instead of individual messages
+	Digest bool `bson:"digest,omitempty"`
+	// DigestWindow is the digest delivery window in seconds
+	DigestWindow int `bson:"digest_window,omitempty"`
+	// MaxBatchMessages, when above one, makes push deliveries carry
+	// batches of up to that many messages per request
+	MaxBatchMessages int `bson:"max_batch_messages,omitempty"`
+	// MaxBatchBytes bounds the total payload bytes of a single batch
+	MaxBatchBytes int64 `bson:"max_batch_bytes,omitempty"`
+	// TLSCert names the client certificate presented for mutual tls deliveries
+	TLSCert string `bson:"tls_cert,omitempty"`
+	// PushType selects the push destination type: http (the default when
+	// empty), sqs, sns or kafka
+	PushType string `bson:"push_type,omitempty"`
+	// AWSCredentials names the configured aws key pair signing the
+	// deliveries of sqs and sns destinations
+	AWSCredentials string `bson:"aws_credentials,omitempty"`
+	// KafkaTLS connects to the cluster of a kafka destination over tls
+	KafkaTLS bool `bson:"kafka_tls,omitempty"`
+	// SASLUser authenticates against the cluster of a kafka destination
+	SASLUser string `bson:"sasl_user,omitempty"`
+	// SASLPassword is the encrypted sasl password of a kafka destination
+	SASLPassword string `bson:"sasl_password,omitempty"`
+	// EmailSubject is the subject template of an email destination
+	EmailSubject string `bson:"email_subject,omitempty"`
+	// ChatTemplate is the text template of a chat webhook destination
+	ChatTemplate string `bson:"chat_template,omitempty"`
+	// AckDedupWindowSec, when positive, filters messages acknowledged
+	// within that window from subsequent pull responses, reducing the
+	// duplicates consumers see after redeliveries
+	AckDedupWindowSec int `bson:"ack_dedup_window_sec,omitempty"`
+	// ReplayDays, when positive, keeps a mirror of the source topic in a
+	// dedicated internal topic retained for that many days
+	ReplayDays int `bson:"replay_days,omitempty"`
+	// ReplayOffset is the source topic offset the replay mirror copies next
+	ReplayOffset  int64  `bson:"replay_offset,omitempty"`
+	MsgNum        int64  `bson:"msg_num"`
+	TotalBytes    int64  `bson:"total_bytes"`
+	LatestConsume string `bson:"latest_consume"`
+	// PushedMsgNum, PushedBytes and LatestPush count the push deliveries
+	// separately from the pulled consumption above
+	PushedMsgNum int64  `bson:"pushed_msg_num,omitempty"`
+	PushedBytes  int64  `bson:"pushed_bytes,omitempty"`
+	LatestPush   string `bson:"latest_push,omitempty"`
+	// PushErrorNum counts the failed push delivery attempts
+	PushErrorNum int64  `bson:"push_error_num,omitempty"`
+	CreatedOn    string `bson:"created_on"`
+	// Labels are free-form key/value pairs tenants attach to group
+	// their subscriptions; list requests may filter on them
+	Labels map[string]string `bson:"labels,omitempty"`
+}
+
+// QProjectRoles holds the roles a user holds in a given project
+type QProjectRoles struct {
+	ProjectUUID string   `bson:"project_uuid"`
+	Roles       []string `bson:"roles"`
+	// ExpiresOn, when set, is the utc time the membership stops being
+	// valid and gets removed by the expiry sweep
+	ExpiresOn string `bson:"expires_on,omitempty"`
+}
+
+// QUser are the user details as stored in the backend
+type QUser struct {
+	UUID     string          `bson:"uuid"`
+	Projects []QProjectRoles `bson:"projects"`
+	Name     string          `bson:"name"`
+	Token    string          `bson:"token"`
+	// TokenExpiresOn, when set, is the utc time the token stops being
+	// accepted as a credential
+	TokenExpiresOn string `bson:"token_expires_on,omitempty"`
+	// PreviousToken holds the token a rotation replaced, accepted until
+	// the grace period expires so clients can switch without downtime
+	PreviousToken          string `bson:"previous_token,omitempty"`
+	PreviousTokenExpiresOn string `bson:"previous_token_expires_on,omitempty"`
+	// Tokens are additional named api keys of the user, each one
+	// individually revocable
+	Tokens       []QUserToken `bson:"tokens,omitempty"`
+	Email        string       `bson:"email"`
+	ServiceRoles []string     `bson:"service_roles"`
+	// Suspended rejects every token of the user while preserving the
+	// account, its grants and its history
+	Suspended  bool   `bson:"suspended,omitempty"`
+	CreatedOn  string `bson:"created_on"`
+	ModifiedOn string `bson:"modified_on"`
+	CreatedBy  string `bson:"created_by"`
+}
+
+// QTopicKey is a publish-only credential of a single topic, so devices
+// can publish without holding a full user account in the project
+type QTopicKey struct {
+	ProjectUUID string `bson:"project_uuid"`
+	TopicName   string `bson:"topic_name"`
+	Name        string `bson:"name"`
+	UUID        string `bson:"uuid"`
+	Token       string `bson:"token"`
+	CreatedOn   string `bson:"created_on"`
+	CreatedBy   string `bson:"created_by"`
+}
+
+// QRoleDefinition defines a custom role as the set of api routes it
+// opens; the role becomes assignable wherever the built-in ones are
+type QRoleDefinition struct {
+	Name   string   `bson:"name"`
+	Routes []string `bson:"routes"`
+}
+
+// QServiceAccountScope allows a service account one api action, named
+// by its route, optionally pinned to a single resource
+type QServiceAccountScope struct {
+	Route    string `bson:"route"`
+	Resource string `bson:"resource,omitempty"`
+}
+
+// QServiceAccount is a non-human principal of a project whose token only
+// grants the explicitly scoped actions, separate from full users
+type QServiceAccount struct {
+	ProjectUUID string                 `bson:"project_uuid"`
+	UUID        string                 `bson:"uuid"`
+	Name        string                 `bson:"name"`
+	Token       string                 `bson:"token"`
+	Scopes      []QServiceAccountScope `bson:"scopes"`
+	CreatedOn   string                 `bson:"created_on"`
+	ModifiedOn  string                 `bson:"modified_on"`
+	CreatedBy   string                 `bson:"created_by"`
+}
+
+// QMigration records a schema migration applied on the store
+type QMigration struct {
+	Version     int    `bson:"version"`
+	Description string `bson:"description"`
+	AppliedOn   string `bson:"applied_on"`
+}
+
+// QAcl holds the list of user uuids that may access a resource
+type QAcl struct {
+	ACL []string `bson:"acl"`
+	// Expires maps user uuids to the utc time their grant stops being
+	// valid and gets removed by the expiry sweep
+	Expires map[string]string `bson:"expires,omitempty"`
+}
+
+// QExpiredGrant records a grant removed by the expiry sweep; the resource
+// type is "topics", "subscriptions" or "project" for memberships
+type QExpiredGrant struct {
+	ProjectUUID  string
+	ResourceType string
+	ResourceName string
+	UserUUID     string
+}
+
+// QLock holds a named lease coordinating singleton jobs across service
+// instances; the lock is free once past its expiry
+type QLock struct {
+	Name      string `bson:"name"`
+	Holder    string `bson:"holder"`
+	ExpiresAt string `bson:"expires_at"`
+}
+
+// QPushAttempt holds one recorded push delivery attempt of a
+// subscription: when it happened, how long it took, the http status the
+// endpoint responded with (when known) and the error of failed attempts
+type QPushAttempt struct {
+	ProjectUUID string `bson:"project_uuid" json:"-"`
+	Sub         string `bson:"sub" json:"-"`
+	Timestamp   string `bson:"timestamp" json:"timestamp"`
+	StatusCode  int    `bson:"status_code,omitempty" json:"statusCode,omitempty"`
+	LatencyMs   int64  `bson:"latency_ms" json:"latencyMs"`
+	Error       string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// QDailyMsgCount holds the number of messages published to a topic on a
+// given day (utc, formatted as 2006-01-02), precomputed by the daily
+// count job
+type QDailyMsgCount struct {
+	Date        string `bson:"date"`
+	ProjectUUID string `bson:"project_uuid"`
+	TopicName   string `bson:"topic_name"`
+	MsgCount    int64  `bson:"msg_count"`
+}
+
+// QStatusSnapshot records the component health of the service at a point
+// in time (utc, formatted as RFC3339), captured by the periodic health
+// snapshot job
+type QStatusSnapshot struct {
+	CapturedOn string `bson:"captured_on"`
+	// Status summarizes the snapshot: ok when every component is ok,
+	// degraded otherwise
+	Status string `bson:"status"`
+	// Components maps a component name to "ok" or its error text
+	Components map[string]string `bson:"components"`
+}
+
+// isRoleInProject checks if the user has a specific role in a project
+func (qu *QUser) isRoleInProject(projectUUID string, role string) bool {
+	for _, pr := range qu.Projects {
+		if pr.ProjectUUID != projectUUID {
+			continue
+		}
+		for _, r := range pr.Roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// QUserToken is one of the named api keys of a user
+type QUserToken struct {
+	Name      string `bson:"name"`
+	Token     string `bson:"token"`
+	CreatedOn string `bson:"created_on"`
+	ExpiresOn string `bson:"expires_on,omitempty"`
+}
+
+// tokenGrantsAccess reports whether the given token is currently a valid
+// credential of the user: the active token before its expiry, or the
+// previous token while the rotation grace period lasts
+func (qu *QUser) tokenGrantsAccess(token string, now time.Time) bool {
+	if qu.Suspended {
+		return false
+	}
+	if token == qu.Token {
+		if qu.TokenExpiresOn == "" {
+			return true
+		}
+		t, err := time.Parse(time.RFC3339, qu.TokenExpiresOn)
+		return err == nil && now.Before(t)
+	}
+	if qu.PreviousToken != "" && token == qu.PreviousToken {
+		t, err := time.Parse(time.RFC3339, qu.PreviousTokenExpiresOn)
+		return err == nil && now.Before(t)
+	}
+	for _, named := range qu.Tokens {
+		if token == named.Token {
+			if named.ExpiresOn == "" {
+				return true
+			}
+			t, err := time.Parse(time.RFC3339, named.ExpiresOn)
+			return err == nil && now.Before(t)
+		}
+	}
+	return false
+}
+
+// getProjectRoles returns the roles of a user in a specific project
+func (qu *QUser) getProjectRoles(projectUUID string) []string {
+	for _, pr := range qu.Projects {
+		if pr.ProjectUUID == projectUUID {
+			return pr.Roles
+		}
+	}
+	return []string{}
+}
+
+// QAuditLog is one recorded mutating api call, kept for security reviews
+type QAuditLog struct {
+	Timestamp   string `bson:"timestamp"`
+	UserUUID    string `bson:"user_uuid"`
+	AuthMethod  string `bson:"auth_method"`
+	Action      string `bson:"action"`
+	ProjectUUID string `bson:"project_uuid,omitempty"`
+	Method      string `bson:"method"`
+	Path        string `bson:"path"`
+	StatusCode  int    `bson:"status_code"`
+}
+
+// QDailyProjectUsage tracks what a project published on a given day
+// (utc, formatted as 2006-01-02), maintained live at publish time so the
+// daily quotas can be enforced
+type QDailyProjectUsage struct {
+	Date        string `bson:"date"`
+	ProjectUUID string `bson:"project_uuid"`
+	MsgCount    int64  `bson:"msg_count"`
+	BytesCount  int64  `bson:"bytes_count"`
+}
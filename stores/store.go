@@ -0,0 +1,124 @@
+package stores
+
+// pushAttemptsKept bounds the per-subscription push attempt log; older
+// attempts are dropped as new ones are recorded
+const pushAttemptsKept = 20
+
+// Store encapsulates the backend used to persist service state
+type Store interface {
+	Initialize() error
+	Close()
+
+	// projects
+	QueryProjects(uuid string, name string) ([]QProject, error)
+	InsertProject(uuid string, name string, createdOn string, modifiedOn string, createdBy string, description string, tags map[string]string, maxTopics int, maxSubs int) error
+	UpdateProject(uuid string, name string, description string, tags map[string]string, maxTopics int, maxSubs int, modifiedOn string) error
+	RemoveProject(uuid string) error
+	QueryProjectCluster(projectUUID string) (string, error)
+	UpdateProjectCluster(projectUUID string, cluster string) error
+
+	// topics
+	QueryTopics(projectUUID string, userUUID string, name string, pageToken string, pageSize int64, orderBy string, descending bool) ([]QTopic, int64, string, error)
+	HasTopic(projectUUID string, name string) bool
+	InsertTopic(projectUUID string, name string, partitions int32, replicationFactor int16, idempotent bool, inheritProjectACL bool, routing bool, autoScaleDisabled bool, createdOn string) error
+	RemoveTopic(projectUUID string, name string) error
+	IncrementTopicMsgNum(projectUUID string, name string, num int64) error
+	IncrementTopicBytes(projectUUID string, name string, totalBytes int64) error
+	UpdateTopicLatestPublish(projectUUID string, name string, date string) error
+	UpdateTopicPartitions(projectUUID string, name string, partitions int32) error
+	IncrementDailyMsgCount(date string, projectUUID string, topicName string, num int64) error
+	QueryDailyMsgCount(projectUUID string, topicName string) ([]QDailyMsgCount, error)
+	ModTopicSchema(projectUUID string, name string, schemaName string) error
+	ModTopicLabels(projectUUID string, name string, labels map[string]string) error
+	QueryRoutingRules(projectUUID string, topicName string) ([]QRoutingRule, error)
+	ModRoutingRules(projectUUID string, topicName string, rules []QRoutingRule) error
+
+	// schemas
+	QuerySchemas(projectUUID string, name string) ([]QSchema, error)
+	InsertSchema(projectUUID string, name string, schemaType string, rawSchema string, createdOn string) error
+	RemoveSchema(projectUUID string, name string) error
+
+	// subscriptions
+	QuerySubs(projectUUID string, userUUID string, name string, pageToken string, pageSize int64, orderBy string, descending bool) ([]QSub, int64, string, error)
+	QuerySubsByTopic(projectUUID string, topic string, pageToken string, pageSize int64) ([]QSub, int64, string, error)
+	HasSub(projectUUID string, name string) bool
+	InsertSub(projectUUID string, name string, topic string, offset int64, ackDeadline int, pushEndpoint string, retPolicy string, retPeriod int, replayDays int, replayOffset int64, createdOn string) error
+	RemoveSub(projectUUID string, name string) error
+	ModSubPush(projectUUID string, name string, pushEndpoint string, retPolicy string, retPeriod int, cloudEvents bool, authzHeader string, digest bool, digestWindow int, maxBatchMessages int, maxBatchBytes int64, tlsCert string, pushType string, awsCredentials string, kafkaTLS bool, saslUser string, saslPassword string, emailSubject string, chatTemplate string) error
+	ModSubVerification(projectUUID string, name string, verificationHash string, verified bool) error
+	ModSubPushPaused(projectUUID string, name string, paused bool) error
+	AppendPushAttempt(attempt QPushAttempt) error
+	AcquireLock(name string, holder string, now string, expires string) (bool, error)
+	ReleaseLock(name string, holder string) error
+	QueryLocks() ([]QLock, error)
+	QueryPushAttempts(projectUUID string, name string) ([]QPushAttempt, error)
+	ModSubSigningSecret(projectUUID string, name string, signingSecret string) error
+	ModAck(projectUUID string, name string, ack int) error
+	ModSubAckDedup(projectUUID string, name string, windowSec int) error
+	ModSubLabels(projectUUID string, name string, labels map[string]string) error
+	UpdateSubOffset(projectUUID string, name string, offset int64) error
+	UpdateSubReplayOffset(projectUUID string, name string, offset int64) error
+	UpdateSubOffsetAck(projectUUID string, name string, offset int64, timestamp string) error
+	UpdateSubPull(projectUUID string, name string, offset int64, timestamp string) error
+	UpdateSubAckDeadline(projectUUID string, name string, deadline string) error
+	IncrementSubMsgNum(projectUUID string, name string, num int64) error
+	IncrementSubBytes(projectUUID string, name string, totalBytes int64) error
+	IncrementSubPushed(projectUUID string, name string, num int64, totalBytes int64, date string) error
+	IncrementSubPushErrors(projectUUID string, name string, num int64) error
+
+	// users and auth
+	QueryUsers(projectUUID string, uuid string, name string) ([]QUser, error)
+	FilterUsers(projectUUID string, serviceRole string, email string, namePrefix string, createdBy string, orderBy string, descending bool) ([]QUser, error)
+	GetUserFromToken(token string) (QUser, error)
+	HasUsers(projectUUID string, users []string) (bool, []string)
+	InsertUser(uuid string, projects []QProjectRoles, name string, token string, tokenExpiresOn string, email string, serviceRoles []string, createdOn string, modifiedOn string, createdBy string) error
+	UpdateUser(uuid string, projects []QProjectRoles, name string, email string, serviceRoles []string, tokenExpiresOn string, modifiedOn string) error
+	UpdateUserToken(uuid string, token string) error
+	UpdateUserSuspension(uuid string, suspended bool, modifiedOn string) error
+	RotateUserToken(uuid string, token string, previousExpiresOn string) error
+	InsertUserToken(uuid string, name string, token string, createdOn string, expiresOn string) error
+	RemoveUserToken(uuid string, name string) error
+	RemoveUser(uuid string) error
+	GetUserRoles(projectUUID string, token string) ([]string, string)
+
+	// topic publish keys
+	QueryTopicKeys(projectUUID string, topicName string) ([]QTopicKey, error)
+	GetTopicKeyFromToken(projectUUID string, topicName string, token string) (QTopicKey, error)
+	InsertTopicKey(projectUUID string, topicName string, name string, uuid string, token string, createdOn string, createdBy string) error
+	RemoveTopicKey(projectUUID string, topicName string, name string) error
+
+	// role definitions
+	QueryRoleDefinitions(name string) ([]QRoleDefinition, error)
+	InsertRoleDefinition(name string, routes []string) error
+	UpdateRoleDefinition(name string, routes []string) error
+	RemoveRoleDefinition(name string) error
+
+	// service accounts
+	QueryServiceAccounts(projectUUID string, name string) ([]QServiceAccount, error)
+	GetServiceAccountFromToken(projectUUID string, token string) (QServiceAccount, error)
+	InsertServiceAccount(projectUUID string, uuid string, name string, token string, scopes []QServiceAccountScope, createdOn string, createdBy string) error
+	UpdateServiceAccountScopes(projectUUID string, name string, scopes []QServiceAccountScope, modifiedOn string) error
+	RemoveServiceAccount(projectUUID string, name string) error
+
+	// acl
+	QueryACL(projectUUID string, resourceType string, resourceName string) (QAcl, error)
+	ModACL(projectUUID string, resourceType string, resourceName string, acl []string, expires map[string]string) error
+	EnsureACL(projectUUID string, resourceType string, resourceName string) error
+	RemoveExpiredGrants(now string) ([]QExpiredGrant, error)
+
+	// migrations
+	QueryAppliedMigrations() ([]QMigration, error)
+	InsertAppliedMigration(version int, description string, appliedOn string) error
+
+	// status snapshots
+	InsertStatusSnapshot(snapshot QStatusSnapshot) error
+	QueryStatusSnapshots(since string) ([]QStatusSnapshot, error)
+	RemoveStatusSnapshots(before string) error
+	// project quotas
+	UpdateProjectQuotas(uuid string, maxTopics int, maxSubs int, maxDailyMessages int64, maxDailyBytes int64, modifiedOn string) error
+	IncrementDailyProjectUsage(date string, projectUUID string, msgs int64, totalBytes int64) error
+	GetDailyProjectUsage(date string, projectUUID string) (QDailyProjectUsage, error)
+	// audit logs
+	InsertAuditLog(entry QAuditLog) error
+	QueryAuditLogs(projectUUID string, userUUID string, action string, from string, to string) ([]QAuditLog, error)
+}
@@ -0,0 +1,100 @@
+package subscriptions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// encPrefix marks stored push authorization headers that are encrypted
+const encPrefix = "enc:"
+
+// pushAuthzKey is the aes key encrypting the stored push authorization
+// headers so a database dump does not leak receiver credentials
+var pushAuthzKey []byte
+
+// SetPushAuthzKey derives the encryption key of the stored push
+// authorization headers from the configured secret; an empty secret
+// stores the headers as given
+func SetPushAuthzKey(secret string) {
+	if secret == "" {
+		pushAuthzKey = nil
+		return
+	}
+	sum := sha256.Sum256([]byte(secret))
+	pushAuthzKey = sum[:]
+}
+
+// newVerificationHash generates the random challenge a push endpoint
+// owner must expose to prove ownership of the endpoint
+func newVerificationHash() string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+// newSigningSecret generates the random per-subscription secret signing
+// push deliveries
+func newSigningSecret() string {
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+// encryptAuthzHeader encrypts a push credential (authorization header
+// or signing secret) for storage using aes-gcm with a random nonce
+func encryptAuthzHeader(plain string) (string, error) {
+	if plain == "" || pushAuthzKey == nil {
+		return plain, nil
+	}
+	block, err := aes.NewCipher(pushAuthzKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return encPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptAuthzHeader recovers a stored push credential; values stored
+// before a key was configured pass through unchanged and undecryptable
+// values resolve to empty
+func decryptAuthzHeader(stored string) string {
+	if !strings.HasPrefix(stored, encPrefix) {
+		return stored
+	}
+	if pushAuthzKey == nil {
+		return ""
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encPrefix))
+	if err != nil {
+		return ""
+	}
+	block, err := aes.NewCipher(pushAuthzKey)
+	if err != nil {
+		return ""
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return ""
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return ""
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return ""
+	}
+	return string(plain)
+}
@@ -0,0 +1,183 @@
+package topics
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kaggis/argo-messaging/brokers"
+	"github.com/kaggis/argo-messaging/messages"
+	"github.com/kaggis/argo-messaging/metrics"
+	"github.com/kaggis/argo-messaging/projects"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// MaxMessageSize is the maximum accepted payload size of a single message
+const MaxMessageSize = 1048576
+
+// ErrMessageTooLarge marks a publish rejected for exceeding MaxMessageSize
+var ErrMessageTooLarge = errors.New("message size too large")
+
+// QuotaError marks a publish rejected because it would exceed a daily
+// quota of the project
+type QuotaError struct {
+	// Resource names the exhausted quota, "daily messages" or "daily bytes"
+	Resource string
+	Usage    int64
+	Limit    int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%v quota exceeded (%v of %v used)", e.Resource, e.Usage, e.Limit)
+}
+
+// CheckDailyQuota rejects a batch of messages when accepting it would
+// push the project over its daily message or byte quota; projects
+// without explicit quotas pass through
+func CheckDailyQuota(projectUUID string, msgs []messages.Message, str stores.Store) error {
+	prj, err := projects.Find(projectUUID, "", str)
+	if err != nil || prj.Empty() {
+		return nil
+	}
+	p := prj.One()
+	if p.MaxDailyMessages == 0 && p.MaxDailyBytes == 0 {
+		return nil
+	}
+	today := time.Now().UTC().Format("2006-01-02")
+	usage, err := str.GetDailyProjectUsage(today, projectUUID)
+	if err != nil {
+		return err
+	}
+	incomingBytes := int64(0)
+	for _, msg := range msgs {
+		incomingBytes += int64(len(msg.Data))
+	}
+	if p.MaxDailyMessages > 0 && usage.MsgCount+int64(len(msgs)) > p.MaxDailyMessages {
+		return &QuotaError{Resource: "daily messages", Usage: usage.MsgCount, Limit: p.MaxDailyMessages}
+	}
+	if p.MaxDailyBytes > 0 && usage.BytesCount+incomingBytes > p.MaxDailyBytes {
+		return &QuotaError{Resource: "daily bytes", Usage: usage.BytesCount, Limit: p.MaxDailyBytes}
+	}
+	return nil
+}
+
+// PublishMessages runs a batch of messages through the publish pipeline
+// shared by the http api and the protocol bridges: the payload size
+// cap, the daily project quotas, the fan-out of routing topics and the
+// usage accounting afterwards. It returns the id assigned to each
+// message, so a publish carries the same guarantees regardless of the
+// transport it arrived over.
+func PublishMessages(projectUUID string, topic Topic, msgs []messages.Message, brk brokers.Broker, str stores.Store) ([]string, error) {
+	for _, msg := range msgs {
+		if len(msg.Data) > MaxMessageSize {
+			return nil, ErrMessageTooLarge
+		}
+	}
+	if err := CheckDailyQuota(projectUUID, msgs, str); err != nil {
+		return nil, err
+	}
+	if topic.Routing {
+		return RouteMessages(projectUUID, topic.Name, msgs, brk, str)
+	}
+
+	fullTopic := projectUUID + "." + topic.Name
+	msgIDs := make([]string, 0, len(msgs))
+	totalBytes := int64(0)
+	for _, msg := range msgs {
+		published, err := produce(brk, topic, fullTopic, msg)
+		if err != nil {
+			return nil, err
+		}
+		msgIDs = append(msgIDs, published.ID)
+		totalBytes += int64(len(published.Data))
+	}
+	publishedNum := int64(len(msgIDs))
+	metrics.Prom.IncCounter("ams_messages_published_total", "messages accepted for publishing", nil, float64(publishedNum))
+	metrics.Prom.IncCounter("ams_bytes_published_total", "payload bytes accepted for publishing", nil, float64(totalBytes))
+	recordPublish(projectUUID, topic.Name, publishedNum, totalBytes, str)
+	str.IncrementDailyProjectUsage(time.Now().UTC().Format("2006-01-02"), projectUUID, publishedNum, totalBytes)
+	metrics.MsgRate.Record(fullTopic, publishedNum)
+	metrics.MsgRate.Record(projectUUID, publishedNum)
+	return msgIDs, nil
+}
+
+// RouteMessages fans each message published to a routing topic out to
+// the member topics whose attribute rules match; messages matching no
+// rule are acknowledged as unrouted and dropped, mirroring an exchange
+// without a matching binding
+func RouteMessages(projectUUID string, topicName string, msgs []messages.Message, brk brokers.Broker, str stores.Store) ([]string, error) {
+	qRules, err := str.QueryRoutingRules(projectUUID, topicName)
+	if err != nil {
+		return nil, err
+	}
+
+	// resolve the member topics once so idempotent members publish
+	// through the matching producer
+	members := map[string]Topic{}
+	for _, rule := range qRules {
+		if _, ok := members[rule.TargetTopic]; ok {
+			continue
+		}
+		results, err := Find(projectUUID, "", rule.TargetTopic, "", 0, str)
+		if err != nil || results.Empty() {
+			continue
+		}
+		members[rule.TargetTopic] = results.One()
+	}
+
+	msgIDs := []string{}
+	routedNum := int64(0)
+	totalBytes := int64(0)
+	for _, msg := range msgs {
+		id := "unrouted"
+		for _, rule := range qRules {
+			if msg.Attrs[rule.Attribute] != rule.Value {
+				continue
+			}
+			member, ok := members[rule.TargetTopic]
+			if !ok {
+				continue
+			}
+			published, err := produce(brk, member, projectUUID+"."+rule.TargetTopic, msg)
+			if err != nil {
+				return nil, err
+			}
+			recordPublish(projectUUID, rule.TargetTopic, 1, int64(len(published.Data)), str)
+			metrics.MsgRate.Record(projectUUID+"."+rule.TargetTopic, 1)
+			if id == "unrouted" {
+				id = published.ID
+			}
+		}
+		if id != "unrouted" {
+			routedNum++
+			totalBytes += int64(len(msg.Data))
+		}
+		msgIDs = append(msgIDs, id)
+	}
+
+	// the routing topic itself counts the messages it routed; the daily
+	// project usage counts each routed message once regardless of how
+	// many member topics it fanned out to
+	if routedNum > 0 {
+		recordPublish(projectUUID, topicName, routedNum, totalBytes, str)
+		str.IncrementDailyProjectUsage(time.Now().UTC().Format("2006-01-02"), projectUUID, routedNum, totalBytes)
+		metrics.MsgRate.Record(projectUUID+"."+topicName, routedNum)
+		metrics.MsgRate.Record(projectUUID, routedNum)
+	}
+	return msgIDs, nil
+}
+
+// recordPublish folds accepted messages into the counters of a topic
+func recordPublish(projectUUID string, topicName string, num int64, totalBytes int64, str stores.Store) {
+	str.IncrementTopicMsgNum(projectUUID, topicName, num)
+	str.IncrementTopicBytes(projectUUID, topicName, totalBytes)
+	str.UpdateTopicLatestPublish(projectUUID, topicName, time.Now().UTC().Format(time.RFC3339Nano))
+}
+
+// produce sends a message through the producer matching the topic settings
+func produce(brk brokers.Broker, topic Topic, fullTopic string, msg messages.Message) (messages.Message, error) {
+	if topic.Idempotent {
+		return brk.PublishIdempotent(fullTopic, msg)
+	}
+	return brk.Publish(fullTopic, msg)
+}
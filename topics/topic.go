@@ -0,0 +1,272 @@
+package topics
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/kaggis/argo-messaging/names"
+	"github.com/kaggis/argo-messaging/stores"
+)
+
+// Topic holds the topic information exposed by the api
+type Topic struct {
+	ProjectUUID       string `json:"-"`
+	Name              string `json:"-"`
+	FullName          string `json:"name"`
+	Partitions        int32  `json:"partitions,omitempty"`
+	ReplicationFactor int16  `json:"replication_factor,omitempty"`
+	Idempotent        bool   `json:"idempotent,omitempty"`
+	// InheritProjectACL opens the topic to every project member holding
+	// the required roles instead of an explicit user list
+	InheritProjectACL bool `json:"inheritProjectAcl,omitempty"`
+	// Schema names a schema of the same project attached to the topic
+	Schema string `json:"schema,omitempty"`
+	// Routing marks exchange-style topics that fan published messages out
+	// to member topics based on attribute rules instead of storing them
+	Routing bool `json:"routing,omitempty"`
+	// AutoScaleDisabled opts the topic out of automatic partition growth
+	AutoScaleDisabled bool `json:"autoScaleDisabled,omitempty"`
+	// Labels are free-form key/value pairs tenants attach to group
+	// their topics; list requests may filter on them
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// RoutingRule routes messages published to a routing topic towards a
+// member topic when the named attribute carries the expected value
+type RoutingRule struct {
+	Attribute string `json:"attribute"`
+	Value     string `json:"value"`
+	Topic     string `json:"topic"`
+}
+
+// RoutingRules holds the attribute rules of a routing topic
+type RoutingRules struct {
+	Rules []RoutingRule `json:"rules"`
+}
+
+// ExportJSON exports the routing rules as a json string
+func (rr *RoutingRules) ExportJSON() (string, error) {
+	if rr.Rules == nil {
+		rr.Rules = []RoutingRule{}
+	}
+	output, err := json.MarshalIndent(rr, "", "   ")
+	return string(output), err
+}
+
+// PaginatedTopics holds a page of topics along with pagination details
+type PaginatedTopics struct {
+	Topics        []Topic `json:"topics"`
+	NextPageToken string  `json:"nextPageToken"`
+	TotalSize     int64   `json:"totalSize"`
+}
+
+// New creates a new topic based on the project/topic combination
+func New(projectUUID string, projectName string, name string) Topic {
+	return Topic{ProjectUUID: projectUUID, Name: name, FullName: names.Topic(projectName, name)}
+}
+
+// ExportJSON exports the topic as a json string
+func (tp *Topic) ExportJSON() (string, error) {
+	output, err := json.MarshalIndent(tp, "", "   ")
+	return string(output), err
+}
+
+// ExportJSON exports the paginated topics as a json string
+func (pt *PaginatedTopics) ExportJSON() (string, error) {
+	if pt.Topics == nil {
+		pt.Topics = []Topic{}
+	}
+	output, err := json.MarshalIndent(pt, "", "   ")
+	return string(output), err
+}
+
+// Empty returns true if the page holds no topics
+func (pt *PaginatedTopics) Empty() bool {
+	return len(pt.Topics) == 0
+}
+
+// One returns the first topic of the page
+func (pt *PaginatedTopics) One() Topic {
+	if pt.Empty() {
+		return Topic{}
+	}
+	return pt.Topics[0]
+}
+
+// Find returns a page of topics for a project, optionally filtered by user or name
+func Find(projectUUID string, userUUID string, name string, pageToken string, pageSize int64, store stores.Store) (PaginatedTopics, error) {
+	return FindSorted(projectUUID, userUUID, name, pageToken, pageSize, "", false, store)
+}
+
+// FindSorted returns a page of topics ordered by the requested field
+// instead of the default newest-first order
+func FindSorted(projectUUID string, userUUID string, name string, pageToken string, pageSize int64, orderBy string, descending bool, store stores.Store) (PaginatedTopics, error) {
+	result := PaginatedTopics{}
+	qTopics, totalSize, nextPageToken, err := store.QueryTopics(projectUUID, userUUID, name, pageToken, pageSize, orderBy, descending)
+	if err != nil {
+		return result, err
+	}
+	projectName := projectNameByUUID(projectUUID, store)
+	for _, item := range qTopics {
+		topic := New(item.ProjectUUID, projectName, item.Name)
+		topic.Partitions = item.Partitions
+		topic.ReplicationFactor = item.ReplicationFactor
+		topic.Idempotent = item.Idempotent
+		topic.InheritProjectACL = item.InheritProjectACL
+		topic.Schema = item.SchemaName
+		topic.Routing = item.Routing
+		topic.AutoScaleDisabled = item.AutoScaleDisabled
+		topic.Labels = item.Labels
+		result.Topics = append(result.Topics, topic)
+	}
+	result.TotalSize = totalSize
+	result.NextPageToken = nextPageToken
+	return result, nil
+}
+
+// FindByLabels returns the topics of a project carrying every
+// key/value pair of the selector; the filtered listing is not paginated
+func FindByLabels(projectUUID string, userUUID string, selector map[string]string, store stores.Store) (PaginatedTopics, error) {
+	result, err := Find(projectUUID, userUUID, "", "", 0, store)
+	if err != nil {
+		return result, err
+	}
+	matched := []Topic{}
+	for _, topic := range result.Topics {
+		if matchLabels(topic.Labels, selector) {
+			matched = append(matched, topic)
+		}
+	}
+	result.Topics = matched
+	result.TotalSize = int64(len(matched))
+	return result, nil
+}
+
+// matchLabels checks that the labels carry every pair of the selector
+func matchLabels(labels map[string]string, selector map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// HasTopic checks if a topic exists in a project
+func HasTopic(projectUUID string, name string, store stores.Store) bool {
+	return store.HasTopic(projectUUID, name)
+}
+
+// CreateTopic creates a new topic in the store with the given kafka settings
+func CreateTopic(projectUUID string, name string, partitions int32, replicationFactor int16, idempotent bool, inheritProjectACL bool, routing bool, autoScaleDisabled bool, createdOn time.Time, store stores.Store) (Topic, error) {
+	if HasTopic(projectUUID, name, store) {
+		return Topic{}, errors.New("exists")
+	}
+	if err := store.InsertTopic(projectUUID, name, partitions, replicationFactor, idempotent, inheritProjectACL, routing, autoScaleDisabled, createdOn.UTC().Format(time.RFC3339)); err != nil {
+		return Topic{}, err
+	}
+	topic := New(projectUUID, projectNameByUUID(projectUUID, store), name)
+	topic.Partitions = partitions
+	topic.ReplicationFactor = replicationFactor
+	topic.Idempotent = idempotent
+	topic.InheritProjectACL = inheritProjectACL
+	topic.Routing = routing
+	topic.AutoScaleDisabled = autoScaleDisabled
+	return topic, nil
+}
+
+// GetRoutingRules returns the attribute rules of a routing topic
+func GetRoutingRules(projectUUID string, name string, store stores.Store) (RoutingRules, error) {
+	qRules, err := store.QueryRoutingRules(projectUUID, name)
+	if err != nil {
+		return RoutingRules{}, err
+	}
+	result := RoutingRules{Rules: []RoutingRule{}}
+	for _, item := range qRules {
+		result.Rules = append(result.Rules, RoutingRule{Attribute: item.Attribute, Value: item.Value, Topic: item.TargetTopic})
+	}
+	return result, nil
+}
+
+// ModRoutingRules replaces the attribute rules of a routing topic
+func ModRoutingRules(projectUUID string, name string, rules RoutingRules, store stores.Store) error {
+	qRules := []stores.QRoutingRule{}
+	for _, rule := range rules.Rules {
+		qRules = append(qRules, stores.QRoutingRule{ProjectUUID: projectUUID, TopicName: name,
+			Attribute: rule.Attribute, Value: rule.Value, TargetTopic: rule.Topic})
+	}
+	return store.ModRoutingRules(projectUUID, name, qRules)
+}
+
+// RemoveTopic removes a topic from the store
+func RemoveTopic(projectUUID string, name string, store stores.Store) error {
+	if !HasTopic(projectUUID, name, store) {
+		return errors.New("not found")
+	}
+	return store.RemoveTopic(projectUUID, name)
+}
+
+// AttachSchema attaches a schema of the project to a topic; an empty
+// schema name detaches the current one
+func AttachSchema(projectUUID string, name string, schemaName string, store stores.Store) error {
+	if !HasTopic(projectUUID, name, store) {
+		return errors.New("not found")
+	}
+	return store.ModTopicSchema(projectUUID, name, schemaName)
+}
+
+// ModLabels replaces the labels of a topic
+func ModLabels(projectUUID string, name string, labels map[string]string, store stores.Store) error {
+	if !HasTopic(projectUUID, name, store) {
+		return errors.New("not found")
+	}
+	return store.ModTopicLabels(projectUUID, name, labels)
+}
+
+// PrecomputeDailyCounts folds the growth of each topic's message counter
+// since the last run into the precomputed per-day counts, so metrics
+// endpoints read the stored days instead of scanning counters. It returns
+// the number of topics whose count moved.
+func PrecomputeDailyCounts(now time.Time, store stores.Store) int {
+	day := now.UTC().Format("2006-01-02")
+	updated := 0
+
+	projects, err := store.QueryProjects("", "")
+	if err != nil {
+		return 0
+	}
+	for _, project := range projects {
+		qTopics, _, _, err := store.QueryTopics(project.UUID, "", "", "", 0, "", false)
+		if err != nil {
+			continue
+		}
+		for _, topic := range qTopics {
+			// the sum of the stored days is the part of the counter
+			// already accounted for by previous runs
+			counts, err := store.QueryDailyMsgCount(project.UUID, topic.Name)
+			if err != nil {
+				continue
+			}
+			counted := int64(0)
+			for _, count := range counts {
+				counted += count.MsgCount
+			}
+			if delta := topic.MsgNum - counted; delta > 0 {
+				if err := store.IncrementDailyMsgCount(day, project.UUID, topic.Name, delta); err == nil {
+					updated++
+				}
+			}
+		}
+	}
+	return updated
+}
+
+// projectNameByUUID resolves a project uuid to its name
+func projectNameByUUID(projectUUID string, store stores.Store) string {
+	projects, err := store.QueryProjects(projectUUID, "")
+	if err != nil || len(projects) == 0 {
+		return ""
+	}
+	return projects[0].Name
+}